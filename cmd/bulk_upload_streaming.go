@@ -14,8 +14,9 @@ import (
 )
 
 type bulkUploadStreamingOptions struct {
-	filePaths []string
-	chunkSize int
+	filePaths  []string
+	chunkSize  int
+	onConflict string
 }
 
 var bulkUploadStreamingOpts bulkUploadStreamingOptions
@@ -35,6 +36,7 @@ func init() {
 	flags := bulkUploadStreamingCmd.Flags()
 	flags.StringArrayVarP(&bulkUploadStreamingOpts.filePaths, "file", "f", nil, "Path to an image file to upload (repeatable)")
 	flags.IntVarP(&bulkUploadStreamingOpts.chunkSize, "chunk-size", "c", defaultChunkSize, "Size of each chunk in bytes for streaming upload")
+	flags.StringVar(&bulkUploadStreamingOpts.onConflict, "on-conflict", "replace", onConflictUsage)
 
 	_ = bulkUploadStreamingCmd.MarkFlagRequired("file")
 }
@@ -58,6 +60,11 @@ func runBulkUploadStreaming(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("chunk size must be positive, got: %d", chunkSize)
 	}
 
+	conflictPolicy, err := parseConflictPolicy(bulkUploadStreamingOpts.onConflict)
+	if err != nil {
+		return err
+	}
+
 	// Validate all files before opening the stream.
 	for _, filePath := range filePaths {
 		info, err := os.Stat(filePath)
@@ -93,6 +100,7 @@ func runBulkUploadStreaming(cmd *cobra.Command, args []string) error {
 	// all files to finish.
 	var (
 		successCount int
+		skippedCount int
 		failureCount int
 		recvWg       sync.WaitGroup
 		recvErr      error
@@ -109,10 +117,18 @@ func runBulkUploadStreaming(cmd *cobra.Command, args []string) error {
 				recvErr = fmt.Errorf("error receiving result from server: %w", err)
 				break
 			}
-			if result.GetSuccess() {
+			if result.GetSkipped() {
+				successCount++
+				skippedCount++
+				fmt.Printf("  [skip] %s (identical photo already exists)\n", result.GetObjectId())
+			} else if result.GetSuccess() {
 				successCount++
 				photo := result.GetPhoto()
-				fmt.Printf("  [ok] %s (%d bytes)\n", result.GetObjectId(), photo.GetSizeBytes())
+				if photo.GetObjectId() != result.GetObjectId() {
+					fmt.Printf("  [ok] %s -> %s (%d bytes)\n", result.GetObjectId(), photo.GetObjectId(), photo.GetSizeBytes())
+				} else {
+					fmt.Printf("  [ok] %s (%d bytes)\n", result.GetObjectId(), photo.GetSizeBytes())
+				}
 			} else {
 				failureCount++
 				fmt.Printf("  [fail] %s: %s\n", result.GetObjectId(), result.GetErrorMessage())
@@ -135,8 +151,9 @@ func runBulkUploadStreaming(cmd *cobra.Command, args []string) error {
 		metadataReq := &proto.StreamingUploadRequest{
 			Data: &proto.StreamingUploadRequest_Metadata{
 				Metadata: &proto.PhotoMetadata{
-					Filename:    objectID,
-					ContentType: contentType,
+					Filename:       objectID,
+					ContentType:    contentType,
+					ConflictPolicy: conflictPolicy,
 				},
 			},
 		}
@@ -191,6 +208,9 @@ func runBulkUploadStreaming(cmd *cobra.Command, args []string) error {
 
 	total := successCount + failureCount
 	fmt.Printf("\nBulk upload complete: %d/%d succeeded", successCount, total)
+	if skippedCount > 0 {
+		fmt.Printf(", %d skipped", skippedCount)
+	}
 	if failureCount > 0 {
 		fmt.Printf(", %d failed", failureCount)
 	}
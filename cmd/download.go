@@ -1,8 +1,13 @@
 package cmd
 
 import (
+	"archive/zip"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/alexhokl/photos/proto"
 	"github.com/spf13/cobra"
@@ -10,8 +15,12 @@ import (
 )
 
 type downloadOptions struct {
-	objectID string
-	filePath string
+	objectID       string
+	filePath       string
+	dir            string
+	prefix         string
+	includeDerived bool
+	stripLocation  bool
 }
 
 var downloadOpts downloadOptions
@@ -19,8 +28,10 @@ var downloadOpts downloadOptions
 var downloadCmd = &cobra.Command{
 	Use:   "download",
 	Short: "Download a photo from the storage bucket",
-	Long:  `Download a photo from the storage bucket by specifying its object ID. The photo can be saved to a file or piped to another command.`,
-	RunE:  runDownload,
+	Long: `Download a photo from the storage bucket by specifying its object ID. The photo can be saved to a file or piped to another command.
+
+With --dir, every photo under --prefix (including sub-directories) is downloaded as a single streamed ZIP archive and unpacked into the directory.`,
+	RunE: runDownload,
 }
 
 func init() {
@@ -29,11 +40,21 @@ func init() {
 	flags := downloadCmd.Flags()
 	flags.StringVarP(&downloadOpts.objectID, "object-id", "o", "", "Object ID of the photo to download")
 	flags.StringVarP(&downloadOpts.filePath, "file", "f", "", "Path to save the downloaded file (if not specified, output to stdout)")
-
-	_ = downloadCmd.MarkFlagRequired("object-id")
+	flags.StringVarP(&downloadOpts.dir, "dir", "d", "", "Download every photo under --prefix and unpack them into this directory")
+	flags.StringVarP(&downloadOpts.prefix, "prefix", "p", "", "Directory prefix to download with --dir (empty for the whole library)")
+	flags.BoolVar(&downloadOpts.includeDerived, "include-derived", false, "Include WebP renditions, previews and thumbnails with --dir")
+	flags.BoolVar(&downloadOpts.stripLocation, "strip-location", false, "Remove GPS location from JPEG and HEIC photos with --dir")
+
+	downloadCmd.MarkFlagsMutuallyExclusive("object-id", "dir")
+	downloadCmd.MarkFlagsMutuallyExclusive("file", "dir")
+	downloadCmd.MarkFlagsOneRequired("object-id", "dir")
 }
 
 func runDownload(cmd *cobra.Command, args []string) error {
+	if downloadOpts.dir != "" {
+		return runDownloadArchive(cmd)
+	}
+
 	objectID := downloadOpts.objectID
 	filePath := downloadOpts.filePath
 
@@ -81,3 +102,129 @@ func runDownload(cmd *cobra.Command, args []string) error {
 
 	return nil
 }
+
+func runDownloadArchive(cmd *cobra.Command) error {
+	conn, err := grpc.NewClient(
+		rootOpts.serviceURI,
+		grpc.WithTransportCredentials(getConnectionCredentials(requireSecureConnection(rootOpts.serviceURI))),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to connect to server: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	client := proto.NewByteServiceClient(conn)
+
+	stream, err := client.DownloadArchive(cmd.Context(), &proto.DownloadArchiveRequest{
+		Prefix:         downloadOpts.prefix,
+		IncludeDerived: downloadOpts.includeDerived,
+		StripLocation:  downloadOpts.stripLocation,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to download archive: %w", err)
+	}
+
+	// A ZIP archive can only be read once complete, as its directory is at
+	// the end, so it is spooled to a temporary file first
+	archive, err := os.CreateTemp("", "photos-archive-*.zip")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer func() {
+		_ = archive.Close()
+		_ = os.Remove(archive.Name())
+	}()
+
+	var size int64
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to download archive: %w", err)
+		}
+		n, err := archive.Write(resp.GetChunk())
+		if err != nil {
+			return fmt.Errorf("failed to write temporary file: %w", err)
+		}
+		size += int64(n)
+	}
+
+	count, err := unzipArchive(archive, size, downloadOpts.dir)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Successfully downloaded %d files (%d bytes) to %s\n", count, size, downloadOpts.dir)
+	return nil
+}
+
+// unzipArchive extracts the ZIP archive in r into dir and returns the number
+// of files written. Entries that would be written outside dir are rejected.
+func unzipArchive(r io.ReaderAt, size int64, dir string) (int, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read archive: %w", err)
+	}
+
+	count := 0
+	for _, file := range zr.File {
+		target, err := archiveTargetPath(dir, file.Name)
+		if err != nil {
+			return count, err
+		}
+		if file.FileInfo().IsDir() {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return count, fmt.Errorf("failed to create directory %s: %w", target, err)
+			}
+			continue
+		}
+		if err := extractArchiveFile(file, target); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// archiveTargetPath returns where the archive entry name is extracted to
+// under dir.
+func archiveTargetPath(dir, name string) (string, error) {
+	if filepath.IsAbs(name) || strings.HasPrefix(name, "/") {
+		return "", fmt.Errorf("archive entry has an absolute path: %s", name)
+	}
+	target := filepath.Join(dir, filepath.FromSlash(name))
+	rel, err := filepath.Rel(dir, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("archive entry escapes the target directory: %s", name)
+	}
+	return target, nil
+}
+
+func extractArchiveFile(file *zip.File, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", target, err)
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		return fmt.Errorf("failed to read %s from archive: %w", file.Name, err)
+	}
+	defer func() { _ = src.Close() }()
+
+	dst, err := os.Create(target)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", target, err)
+	}
+	_, err = io.Copy(dst, src)
+	err = errors.Join(err, dst.Close())
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", target, err)
+	}
+
+	if !file.Modified.IsZero() {
+		_ = os.Chtimes(target, file.Modified, file.Modified)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func buildTestZip(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatalf("failed to create zip entry: %v", err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatalf("failed to write zip entry: %v", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatalf("failed to close zip: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestUnzipArchive(t *testing.T) {
+	dir := t.TempDir()
+	data := buildTestZip(t, map[string]string{
+		"img.jpg":       "jpeg",
+		"trip/img2.jpg": "jpeg2",
+		"index.md":      "# 2024\n",
+	})
+
+	count, err := unzipArchive(bytes.NewReader(data), int64(len(data)), dir)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if count != 3 {
+		t.Errorf("expected 3 files, got %d", count)
+	}
+	got, err := os.ReadFile(filepath.Join(dir, "trip", "img2.jpg"))
+	if err != nil {
+		t.Fatalf("failed to read extracted file: %v", err)
+	}
+	if string(got) != "jpeg2" {
+		t.Errorf("expected content %q, got %q", "jpeg2", got)
+	}
+}
+
+func TestUnzipArchive_RejectsPathTraversal(t *testing.T) {
+	dir := t.TempDir()
+	data := buildTestZip(t, map[string]string{"../escape.jpg": "x"})
+
+	if _, err := unzipArchive(bytes.NewReader(data), int64(len(data)), dir); err == nil {
+		t.Fatal("expected error for entry outside the target directory")
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(dir), "escape.jpg")); !os.IsNotExist(err) {
+		t.Error("expected no file to be written outside the target directory")
+	}
+}
+
+func TestArchiveTargetPath(t *testing.T) {
+	tests := []struct {
+		name    string
+		entry   string
+		wantErr bool
+	}{
+		{"plain file", "img.jpg", false},
+		{"nested file", "2024/trip/img.jpg", false},
+		{"dot-dot inside name", "a..b.jpg", false},
+		{"parent directory", "../img.jpg", true},
+		{"nested escape", "2024/../../img.jpg", true},
+		{"absolute path", "/etc/passwd", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := archiveTargetPath("/tmp/photos", tt.entry)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("archiveTargetPath(%q) error = %v, wantErr %v", tt.entry, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/alexhokl/photos/proto"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+)
+
+var getCapabilitiesCmd = &cobra.Command{
+	Use:   "capabilities",
+	Short: "Get the capabilities of the server",
+	Long: `Report the external tools (cwebp, dcraw, ffprobe and ffmpeg) found when
+the server started and how each feature depending on them is provided: by the
+tool, by an in-process fallback, or not at all.`,
+	RunE: runGetCapabilities,
+}
+
+func init() {
+	getCmd.AddCommand(getCapabilitiesCmd)
+}
+
+func runGetCapabilities(cmd *cobra.Command, args []string) error {
+	conn, err := grpc.NewClient(
+		rootOpts.serviceURI,
+		grpc.WithTransportCredentials(getConnectionCredentials(requireSecureConnection(rootOpts.serviceURI))),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to connect to server: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	client := proto.NewLibraryServiceClient(conn)
+
+	resp, err := client.GetServerCapabilities(cmd.Context(), &proto.GetServerCapabilitiesRequest{})
+	if err != nil {
+		return fmt.Errorf("failed to get capabilities: %w", err)
+	}
+
+	fmt.Printf("Server Capabilities\n")
+	for _, capability := range resp.GetCapabilities() {
+		fmt.Printf("  %-16s %s\n", capability.GetFeature(), formatCapability(capability))
+	}
+
+	return nil
+}
+
+// formatCapability describes how a feature is provided.
+func formatCapability(capability *proto.ServerCapability) string {
+	switch capability.GetProvider() {
+	case proto.ServerCapability_PROVIDER_EXTERNAL:
+		return fmt.Sprintf("%s (%s)", capability.GetTool(), capability.GetToolPath())
+	case proto.ServerCapability_PROVIDER_FALLBACK:
+		return fmt.Sprintf("%s not found; %s", capability.GetTool(), capability.GetFallback())
+	default:
+		return fmt.Sprintf("%s not found; unavailable", capability.GetTool())
+	}
+}
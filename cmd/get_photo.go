@@ -78,6 +78,24 @@ func runGetPhoto(cmd *cobra.Command, args []string) error {
 	if photo.GetWebpObjectId() != "" {
 		fmt.Printf("  WebP ID:           %s\n", photo.GetWebpObjectId())
 	}
+	if photo.GetAvifObjectId() != "" {
+		fmt.Printf("  AVIF ID:           %s\n", photo.GetAvifObjectId())
+	}
+	if photo.GetProxyObjectId() != "" {
+		fmt.Printf("  Proxy ID:          %s\n", photo.GetProxyObjectId())
+	}
+	if photo.GetHlsObjectId() != "" {
+		fmt.Printf("  HLS ID:            %s\n", photo.GetHlsObjectId())
+	}
+	if photo.GetAnimatedPreviewObjectId() != "" {
+		fmt.Printf("  Animated Preview:  %s\n", photo.GetAnimatedPreviewObjectId())
+	}
+	if photo.GetCompanionObjectId() != "" {
+		fmt.Printf("  Companion:         %s\n", photo.GetCompanionObjectId())
+	}
+	if stack := photo.GetStack(); stack != nil {
+		fmt.Printf("  Stack:             %s of %d, covered by %s\n", stack.GetKind(), stack.GetCount(), stack.GetCoverObjectId())
+	}
 	fmt.Printf("  Content Type:      %s\n", photo.GetContentType())
 	fmt.Printf("  Size:              %d bytes\n", photo.GetSizeBytes())
 	if photo.GetHasDimensions() {
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/alexhokl/photos/proto"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+)
+
+var getUsageCmd = &cobra.Command{
+	Use:   "usage",
+	Short: "Get storage usage and quota",
+	Long: `Report the storage used by the authenticated user, broken down into
+original photos and videos and the derived WebP renditions, RAW and HEIC
+previews, video thumbnails and XMP sidecars. Quotas apply to originals only.`,
+	RunE: runGetUsage,
+}
+
+func init() {
+	getCmd.AddCommand(getUsageCmd)
+}
+
+func runGetUsage(cmd *cobra.Command, args []string) error {
+	conn, err := grpc.NewClient(
+		rootOpts.serviceURI,
+		grpc.WithTransportCredentials(getConnectionCredentials(requireSecureConnection(rootOpts.serviceURI))),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to connect to server: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	client := proto.NewLibraryServiceClient(conn)
+
+	resp, err := client.GetUsage(cmd.Context(), &proto.GetUsageRequest{})
+	if err != nil {
+		return fmt.Errorf("failed to get usage: %w", err)
+	}
+
+	fmt.Printf("Storage Usage\n")
+	fmt.Printf("  Objects:    %d%s\n", resp.GetObjectCount(), formatQuota(resp.GetQuotaObjects()))
+	fmt.Printf("  Originals:  %d bytes%s\n", resp.GetOriginalBytes(), formatQuota(resp.GetQuotaBytes()))
+	fmt.Printf("  WebP:       %d bytes\n", resp.GetWebpBytes())
+	fmt.Printf("  AVIF:       %d bytes\n", resp.GetAvifBytes())
+	fmt.Printf("  Videos:     %d bytes\n", resp.GetVideoBytes())
+	fmt.Printf("  Previews:   %d bytes\n", resp.GetPreviewBytes())
+	fmt.Printf("  Thumbnails: %d bytes\n", resp.GetThumbnailBytes())
+	fmt.Printf("  Sidecars:   %d bytes\n", resp.GetSidecarBytes())
+	fmt.Printf("  Renditions: %d bytes\n", resp.GetRenditionBytes())
+	fmt.Printf("  Total:      %d bytes\n", resp.GetTotalBytes())
+
+	return nil
+}
+
+// formatQuota returns " (quota: n)" for a non-zero quota and an empty string
+// for an unlimited one.
+func formatQuota(quota int64) string {
+	if quota <= 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (quota: %d)", quota)
+}
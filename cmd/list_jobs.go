@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/alexhokl/photos/proto"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+)
+
+type listJobsOptions struct {
+	status string
+}
+
+var listJobsOpts listJobsOptions
+
+var listJobsCmd = &cobra.Command{
+	Use:   "jobs",
+	Short: "List background jobs",
+	Long:  `List the background jobs generating the derived assets of uploads, newest first. Use --status to list only pending, running, succeeded or failed jobs.`,
+	RunE:  runListJobs,
+}
+
+func init() {
+	listCmd.AddCommand(listJobsCmd)
+
+	flags := listJobsCmd.Flags()
+	flags.StringVarP(&listJobsOpts.status, "status", "s", "", "Filter jobs by status (pending, running, succeeded or failed)")
+}
+
+func runListJobs(cmd *cobra.Command, args []string) error {
+	conn, err := grpc.NewClient(
+		rootOpts.serviceURI,
+		grpc.WithTransportCredentials(getConnectionCredentials(requireSecureConnection(rootOpts.serviceURI))),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to connect to server: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	client := proto.NewLibraryServiceClient(conn)
+
+	var jobs []*proto.Job
+	pageToken := ""
+	for {
+		resp, err := client.ListJobs(cmd.Context(), &proto.ListJobsRequest{
+			Status:    listJobsOpts.status,
+			PageToken: pageToken,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to list jobs: %w", err)
+		}
+		jobs = append(jobs, resp.GetJobs()...)
+		pageToken = resp.GetNextPageToken()
+		if pageToken == "" {
+			break
+		}
+	}
+
+	if len(jobs) == 0 {
+		fmt.Println("No jobs found")
+		return nil
+	}
+
+	for _, job := range jobs {
+		fmt.Printf("%d\t%s\t%s\t%d/%d\t%s", job.GetId(), job.GetStatus(), job.GetKind(), job.GetAttempts(), job.GetMaxAttempts(), job.GetObjectId())
+		if job.GetLastError() != "" {
+			fmt.Printf("\t%s", job.GetLastError())
+		}
+		fmt.Println()
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/alexhokl/photos/proto"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+)
+
+var listSchedulesCmd = &cobra.Command{
+	Use:   "schedules",
+	Short: "List scheduled maintenance tasks",
+	Long:  `List the maintenance tasks the server runs on a schedule, such as sync and WebP backfill, with when each next runs and the result of its last run.`,
+	RunE:  runListSchedules,
+}
+
+func init() {
+	listCmd.AddCommand(listSchedulesCmd)
+}
+
+func runListSchedules(cmd *cobra.Command, args []string) error {
+	conn, err := grpc.NewClient(
+		rootOpts.serviceURI,
+		grpc.WithTransportCredentials(getConnectionCredentials(requireSecureConnection(rootOpts.serviceURI))),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to connect to server: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	client := proto.NewLibraryServiceClient(conn)
+
+	resp, err := client.ListSchedules(cmd.Context(), &proto.ListSchedulesRequest{})
+	if err != nil {
+		return fmt.Errorf("failed to list schedules: %w", err)
+	}
+
+	schedules := resp.GetSchedules()
+	if len(schedules) == 0 {
+		fmt.Println("No schedules found")
+		return nil
+	}
+
+	for _, schedule := range schedules {
+		fmt.Printf("%s (%s)\n", schedule.GetTask(), schedule.GetExpression())
+		fmt.Printf("  Next run: %s\n", schedule.GetNextRunAt())
+		lastRun := schedule.GetLastRun()
+		if lastRun == nil {
+			fmt.Printf("  Last run: never\n")
+			continue
+		}
+		fmt.Printf("  Last run: %s at %s\n", lastRun.GetStatus(), lastRun.GetStartedAt())
+		if lastRun.GetSummary() != "" {
+			fmt.Printf("  Summary:  %s\n", lastRun.GetSummary())
+		}
+		if lastRun.GetError() != "" {
+			fmt.Printf("  Error:    %s\n", lastRun.GetError())
+		}
+	}
+
+	return nil
+}
@@ -31,6 +31,7 @@ import (
 
 const DefaultPort = 8080
 const DefaultProxyPort = 8081
+const DefaultRenderCacheSize = "1GB"
 
 type serveOptions struct {
 	Port                    int
@@ -44,6 +45,20 @@ type serveOptions struct {
 	GCSCredentials          string
 	GCSPrefix               string
 	WebPQuality             int
+	AVIFQuality             int
+	AllowedContentTypes     []string
+	MaxUploadSizes          []string
+	RenderCacheDir          string
+	RenderCacheSize         string
+	ThumbnailSizes          []int
+	TranscodeVideos         bool
+	HLSHeights              []int
+	JobWorkers              int
+	Schedules               []string
+	Concurrency             int
+	ObjectTimeout           time.Duration
+	GCSReadRate             float64
+	ToolRate                float64
 }
 
 var serveOpts serveOptions
@@ -74,6 +89,20 @@ func init() {
 	flags.StringVar(&serveOpts.GCSCredentials, "gcs-credentials", "", "Path to GCS service account credentials JSON file (optional, uses ADC if not set)")
 	flags.StringVar(&serveOpts.GCSPrefix, "gcs-prefix", "", "Object prefix/folder path within the bucket (optional)")
 	flags.IntVar(&serveOpts.WebPQuality, "webp-quality", internal.DefaultWebPQuality, "WebP quality percentage (1-100) for generated WebP images (requires cwebp)")
+	flags.IntVar(&serveOpts.AVIFQuality, "avif-quality", 0, fmt.Sprintf("AVIF quality percentage (1-100) for AVIF renditions generated alongside WebP images, e.g. %d (requires avifenc; if 0, no AVIF renditions are generated)", internal.DefaultAVIFQuality))
+	flags.StringSliceVar(&serveOpts.AllowedContentTypes, "allowed-content-types", internal.DefaultAllowedContentTypes, "Content types accepted for upload, as detected from the file contents")
+	flags.StringSliceVar(&serveOpts.MaxUploadSizes, "max-upload-sizes", internal.DefaultMaxUploadSizes, "Upload size limits per content type as type=size (e.g. image/*=200MB,video/mp4=4GB)")
+	flags.StringVar(&serveOpts.RenderCacheDir, "render-cache-dir", "", "Directory to cache resized renditions in (if empty, renditions are not cached)")
+	flags.StringVar(&serveOpts.RenderCacheSize, "render-cache-size", DefaultRenderCacheSize, "Maximum size of the rendition cache (e.g. 512MB, 2GB)")
+	flags.IntSliceVar(&serveOpts.ThumbnailSizes, "thumbnail-sizes", internal.DefaultThumbnailSizes, "Long edges in pixels of the thumbnails generated on upload and sync (if empty, no thumbnails are generated)")
+	flags.BoolVar(&serveOpts.TranscodeVideos, "transcode-videos", false, "Transcode uploaded videos to an H.264/AAC MP4 proxy in the background (requires ffmpeg)")
+	flags.IntSliceVar(&serveOpts.HLSHeights, "hls-heights", nil, "Short edges in pixels of the HLS ladder generated alongside the MP4 proxy, e.g. 360,720,1080 (if empty, no HLS is generated)")
+	flags.IntVar(&serveOpts.JobWorkers, "job-workers", internal.DefaultJobWorkers, "Number of background jobs, such as generating the previews of uploads, run at once (if 0, one per CPU)")
+	flags.StringArrayVar(&serveOpts.Schedules, "schedule", nil, "Maintenance task to run on a cron schedule as task=expression, e.g. \"sync=0 3 * * *\"; tasks are sync, sync_metadata, webp, purge_trash and verify (repeatable)")
+	flags.IntVar(&serveOpts.Concurrency, "concurrency", internal.DefaultConcurrency, "Number of objects refreshed at once by a sync with metadata refresh and by WebP generation (if 0, one at a time)")
+	flags.DurationVar(&serveOpts.ObjectTimeout, "object-timeout", internal.DefaultObjectTimeout, "Time each object refreshed by a sync with metadata refresh or by WebP generation may take (if 0, no limit)")
+	flags.Float64Var(&serveOpts.GCSReadRate, "gcs-read-rate", 0, "Objects those refreshes download from GCS per second, shared by all objects refreshed at once (if 0, no limit)")
+	flags.Float64Var(&serveOpts.ToolRate, "tool-rate", 0, "Runs of cwebp, dcraw and other tools those refreshes make per second, shared by all objects refreshed at once (if 0, no limit)")
 
 	_ = viper.BindPFlag("port", flags.Lookup("port"))
 	_ = viper.BindPFlag("proxy_port", flags.Lookup("proxy-port"))
@@ -86,6 +115,20 @@ func init() {
 	_ = viper.BindPFlag("gcs_credentials", flags.Lookup("gcs-credentials"))
 	_ = viper.BindPFlag("gcs_prefix", flags.Lookup("gcs-prefix"))
 	_ = viper.BindPFlag("webp_quality", flags.Lookup("webp-quality"))
+	_ = viper.BindPFlag("avif_quality", flags.Lookup("avif-quality"))
+	_ = viper.BindPFlag("allowed_content_types", flags.Lookup("allowed-content-types"))
+	_ = viper.BindPFlag("max_upload_sizes", flags.Lookup("max-upload-sizes"))
+	_ = viper.BindPFlag("render_cache_dir", flags.Lookup("render-cache-dir"))
+	_ = viper.BindPFlag("render_cache_size", flags.Lookup("render-cache-size"))
+	_ = viper.BindPFlag("thumbnail_sizes", flags.Lookup("thumbnail-sizes"))
+	_ = viper.BindPFlag("transcode_videos", flags.Lookup("transcode-videos"))
+	_ = viper.BindPFlag("hls_heights", flags.Lookup("hls-heights"))
+	_ = viper.BindPFlag("job_workers", flags.Lookup("job-workers"))
+	_ = viper.BindPFlag("schedule", flags.Lookup("schedule"))
+	_ = viper.BindPFlag("concurrency", flags.Lookup("concurrency"))
+	_ = viper.BindPFlag("object_timeout", flags.Lookup("object-timeout"))
+	_ = viper.BindPFlag("gcs_read_rate", flags.Lookup("gcs-read-rate"))
+	_ = viper.BindPFlag("tool_rate", flags.Lookup("tool-rate"))
 }
 
 func bindEnvironmentVariablesToServeOptions(cmd *cobra.Command, opts *serveOptions) {
@@ -130,6 +173,76 @@ func bindEnvironmentVariablesToServeOptions(cmd *cobra.Command, opts *serveOptio
 			opts.WebPQuality = v
 		}
 	}
+	if !cmd.Flags().Changed("avif-quality") {
+		if v := viper.GetInt("avif_quality"); v != 0 {
+			opts.AVIFQuality = v
+		}
+	}
+	if !cmd.Flags().Changed("allowed-content-types") {
+		if v := viper.GetStringSlice("allowed_content_types"); len(v) > 0 {
+			opts.AllowedContentTypes = v
+		}
+	}
+	if !cmd.Flags().Changed("max-upload-sizes") {
+		if v := viper.GetStringSlice("max_upload_sizes"); len(v) > 0 {
+			opts.MaxUploadSizes = v
+		}
+	}
+	if !cmd.Flags().Changed("render-cache-dir") {
+		if viper.IsSet("render_cache_dir") {
+			opts.RenderCacheDir = viper.GetString("render_cache_dir")
+		}
+	}
+	if !cmd.Flags().Changed("render-cache-size") {
+		if v := viper.GetString("render_cache_size"); v != "" {
+			opts.RenderCacheSize = v
+		}
+	}
+	if !cmd.Flags().Changed("thumbnail-sizes") {
+		if viper.IsSet("thumbnail_sizes") {
+			opts.ThumbnailSizes = viper.GetIntSlice("thumbnail_sizes")
+		}
+	}
+	if !cmd.Flags().Changed("transcode-videos") {
+		if v := viper.GetBool("transcode_videos"); v {
+			opts.TranscodeVideos = v
+		}
+	}
+	if !cmd.Flags().Changed("hls-heights") {
+		if viper.IsSet("hls_heights") {
+			opts.HLSHeights = viper.GetIntSlice("hls_heights")
+		}
+	}
+	if !cmd.Flags().Changed("job-workers") {
+		if viper.IsSet("job_workers") {
+			opts.JobWorkers = viper.GetInt("job_workers")
+		}
+	}
+	if !cmd.Flags().Changed("schedule") {
+		if v := viper.GetStringSlice("schedule"); len(v) > 0 {
+			opts.Schedules = v
+		}
+	}
+	if !cmd.Flags().Changed("concurrency") {
+		if viper.IsSet("concurrency") {
+			opts.Concurrency = viper.GetInt("concurrency")
+		}
+	}
+	if !cmd.Flags().Changed("object-timeout") {
+		if viper.IsSet("object_timeout") {
+			opts.ObjectTimeout = viper.GetDuration("object_timeout")
+		}
+	}
+	if !cmd.Flags().Changed("gcs-read-rate") {
+		if viper.IsSet("gcs_read_rate") {
+			opts.GCSReadRate = viper.GetFloat64("gcs_read_rate")
+		}
+	}
+	if !cmd.Flags().Changed("tool-rate") {
+		if viper.IsSet("tool_rate") {
+			opts.ToolRate = viper.GetFloat64("tool_rate")
+		}
+	}
 }
 
 func runServe(cmd *cobra.Command, args []string) error {
@@ -242,17 +355,57 @@ func runServe(cmd *cobra.Command, args []string) error {
 	// the gRPC server (served over Tailscale/tsnet or a plain TCP listener)
 	// and the RESTful gateway below, which invokes them in-process instead
 	// of dialing back into the gRPC server over the network.
+	capabilities := internal.ProbeCapabilities()
+	capabilities.LogMissing(ctx)
+	jobs := internal.NewJobQueue(dbConn, serveOpts.JobWorkers)
+	transcoder := internal.NewTranscoder(dbConn, gcsClient, serveOpts.GCSBucket, serveOpts.HLSHeights, capabilities)
+	// Already validated by validateFlags
+	schedules, _ := internal.ParseSchedules(serveOpts.Schedules)
 	libraryServer := &internal.LibraryServer{
-		DB:          dbConn,
-		GCSClient:   gcsClient,
-		BucketName:  serveOpts.GCSBucket,
-		WebPQuality: serveOpts.WebPQuality,
+		DB:             dbConn,
+		GCSClient:      gcsClient,
+		BucketName:     serveOpts.GCSBucket,
+		WebPQuality:    serveOpts.WebPQuality,
+		AVIFQuality:    serveOpts.AVIFQuality,
+		ThumbnailSizes: serveOpts.ThumbnailSizes,
+		Capabilities:   capabilities,
+		Transcoder:     transcoder,
+		Jobs:           jobs,
+		Schedules:      schedules,
+		Concurrency:    serveOpts.Concurrency,
+		ObjectTimeout:  serveOpts.ObjectTimeout,
+		Throttle:       internal.NewThrottle(serveOpts.GCSReadRate, serveOpts.ToolRate),
+	}
+	// Already validated by validateFlags
+	maxUploadSizes, _ := internal.ParseUploadSizeLimits(serveOpts.MaxUploadSizes)
+	var renderCache *internal.RenderCache
+	if serveOpts.RenderCacheDir != "" {
+		renderCacheSize, _ := internal.ParseByteSize(serveOpts.RenderCacheSize)
+		renderCache, err = internal.NewRenderCache(serveOpts.RenderCacheDir, renderCacheSize)
+		if err != nil {
+			return err
+		}
 	}
 	bytesServer := &internal.BytesServer{
 		DB:          dbConn,
 		GCSClient:   gcsClient,
 		BucketName:  serveOpts.GCSBucket,
 		WebPQuality: serveOpts.WebPQuality,
+		UploadPolicy: internal.UploadPolicy{
+			AllowedContentTypes: serveOpts.AllowedContentTypes,
+			MaxSizeBytes:        maxUploadSizes,
+		},
+		RenderCache:    renderCache,
+		ThumbnailSizes: serveOpts.ThumbnailSizes,
+		AVIFQuality:    serveOpts.AVIFQuality,
+		Capabilities:   capabilities,
+		Jobs:           jobs,
+	}
+	jobs.Handle(database.JobKindDerivedAssets, bytesServer.RunDerivedAssetsJob)
+	if serveOpts.TranscodeVideos {
+		bytesServer.Transcoder = transcoder
+		transcoder.Jobs = jobs
+		jobs.Handle(database.JobKindTranscode, transcoder.RunJob)
 	}
 
 	authenticationInterceptor := internal.DummyAuthenticationInterceptor
@@ -359,6 +512,18 @@ func runServe(cmd *cobra.Command, args []string) error {
 		return nil
 	})
 
+	// Background job goroutine
+	g.Go(func() error {
+		jobs.Run(ctx)
+		return nil
+	})
+
+	// Scheduled maintenance goroutine
+	g.Go(func() error {
+		libraryServer.RunSchedules(ctx)
+		return nil
+	})
+
 	// Non-HTTPS server goroutine (if enabled)
 	if nonHTTPSServer != nil {
 		g.Go(func() error {
@@ -423,6 +588,41 @@ func validateFlags(opts serveOptions) error {
 	if opts.WebPQuality < 1 || opts.WebPQuality > 100 {
 		return fmt.Errorf("invalid webp quality: %d (must be between 1 and 100)", opts.WebPQuality)
 	}
+	if opts.AVIFQuality < 0 || opts.AVIFQuality > 100 {
+		return fmt.Errorf("invalid avif quality: %d (must be between 1 and 100, or 0 to disable)", opts.AVIFQuality)
+	}
+	if _, err := internal.ParseUploadSizeLimits(opts.MaxUploadSizes); err != nil {
+		return err
+	}
+	if opts.RenderCacheDir != "" {
+		if _, err := internal.ParseByteSize(opts.RenderCacheSize); err != nil {
+			return fmt.Errorf("invalid render cache size %q: %w", opts.RenderCacheSize, err)
+		}
+	}
+	if err := internal.ValidateThumbnailSizes(opts.ThumbnailSizes); err != nil {
+		return err
+	}
+	if err := internal.ValidateHLSHeights(opts.HLSHeights); err != nil {
+		return err
+	}
+	if _, err := internal.ParseSchedules(opts.Schedules); err != nil {
+		return err
+	}
+	if opts.JobWorkers < 0 {
+		return fmt.Errorf("invalid number of job workers: %d (must be positive, or 0 for one per CPU)", opts.JobWorkers)
+	}
+	if opts.Concurrency < 0 {
+		return fmt.Errorf("invalid concurrency: %d (must be positive, or 0 for one at a time)", opts.Concurrency)
+	}
+	if opts.ObjectTimeout < 0 {
+		return fmt.Errorf("invalid object timeout: %s (must be positive, or 0 for no limit)", opts.ObjectTimeout)
+	}
+	if opts.GCSReadRate < 0 {
+		return fmt.Errorf("invalid GCS read rate: %g (must be positive, or 0 for no limit)", opts.GCSReadRate)
+	}
+	if opts.ToolRate < 0 {
+		return fmt.Errorf("invalid tool rate: %g (must be positive, or 0 for no limit)", opts.ToolRate)
+	}
 	return nil
 }
 
@@ -486,6 +686,10 @@ func getRestfulProxyServerHandler(
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("GET /v1/photos/bytes/{object_id...}", internal.NewRawBytesHandler(&internal.ByteServerDownloader{Server: bytesServer}))
+	archiveHandler := internal.NewArchiveHandler(&internal.ByteServerDownloader{Server: bytesServer})
+	mux.HandleFunc("GET /v1/archive", archiveHandler)
+	mux.HandleFunc("GET /v1/archive/{prefix...}", archiveHandler)
+	mux.HandleFunc("GET /v1/photos/render/{object_id...}", internal.NewRenderHandler(&internal.ByteServerDownloader{Server: bytesServer}))
 	mux.Handle("/", gwMux)
 
 	return authMiddleware(otelhttp.NewHandler(mux, "gateway")), nil
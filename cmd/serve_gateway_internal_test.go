@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/alexhokl/photos/proto"
+	"google.golang.org/grpc"
 )
 
 // stubLibraryServiceServer is a minimal proto.LibraryServiceServer test
@@ -26,11 +27,11 @@ func (s *stubLibraryServiceServer) GetPhoto(ctx context.Context, req *proto.GetP
 // stubByteServiceServer is a minimal proto.ByteServiceServer test double.
 type stubByteServiceServer struct {
 	proto.UnimplementedByteServiceServer
-	downloadFunc func(ctx context.Context, req *proto.DownloadRequest) (*proto.DownloadResponse, error)
+	streamingDownloadFunc func(req *proto.StreamingDownloadRequest, stream grpc.ServerStreamingServer[proto.StreamingDownloadResponse]) error
 }
 
-func (s *stubByteServiceServer) Download(ctx context.Context, req *proto.DownloadRequest) (*proto.DownloadResponse, error) {
-	return s.downloadFunc(ctx, req)
+func (s *stubByteServiceServer) StreamingDownload(req *proto.StreamingDownloadRequest, stream grpc.ServerStreamingServer[proto.StreamingDownloadResponse]) error {
+	return s.streamingDownloadFunc(req, stream)
 }
 
 // noopAuthMiddleware passes every request straight through, used where the
@@ -99,14 +100,20 @@ func TestGetRestfulProxyServerHandler_RawBytesHandler_ServedInProcess(t *testing
 
 	library := &stubLibraryServiceServer{}
 	bytesServer := &stubByteServiceServer{
-		downloadFunc: func(ctx context.Context, req *proto.DownloadRequest) (*proto.DownloadResponse, error) {
+		streamingDownloadFunc: func(req *proto.StreamingDownloadRequest, stream grpc.ServerStreamingServer[proto.StreamingDownloadResponse]) error {
 			if req.GetObjectId() != objectID {
 				t.Errorf("expected object ID %q, got %q", objectID, req.GetObjectId())
 			}
-			return &proto.DownloadResponse{
-				Photo: &proto.Photo{ContentType: "image/jpeg"},
-				Data:  imageBytes,
-			}, nil
+			if err := stream.Send(&proto.StreamingDownloadResponse{
+				Data: &proto.StreamingDownloadResponse_Metadata{
+					Metadata: &proto.Photo{ContentType: "image/jpeg", SizeBytes: int64(len(imageBytes))},
+				},
+			}); err != nil || req.GetMetadataOnly() {
+				return err
+			}
+			return stream.Send(&proto.StreamingDownloadResponse{
+				Data: &proto.StreamingDownloadResponse_Chunk{Chunk: imageBytes},
+			})
 		},
 	}
 
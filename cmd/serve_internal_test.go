@@ -2,6 +2,9 @@ package cmd
 
 import (
 	"testing"
+	"time"
+
+	"github.com/alexhokl/photos/internal"
 )
 
 func TestValidateFlagsWebPQuality(t *testing.T) {
@@ -40,3 +43,299 @@ func TestValidateFlagsWebPQuality(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateFlagsMaxUploadSizes(t *testing.T) {
+	validBase := serveOptions{
+		Port:             8080,
+		ProxyPort:        8081,
+		DatebaseFilePath: "photos.db",
+		GCSBucket:        "my-bucket",
+		WebPQuality:      80,
+	}
+
+	tests := []struct {
+		name           string
+		maxUploadSizes []string
+		wantErr        bool
+	}{
+		{"none", nil, false},
+		{"defaults", []string{"image/*=200MB", "video/*=4GB"}, false},
+		{"plain bytes", []string{"image/jpeg=1048576"}, false},
+		{"missing size", []string{"image/*"}, true},
+		{"not a content type", []string{"jpeg=10MB"}, true},
+		{"unknown unit", []string{"image/*=10TB"}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := validBase
+			opts.MaxUploadSizes = test.maxUploadSizes
+			err := validateFlags(opts)
+			if test.wantErr && err == nil {
+				t.Errorf("validateFlags with MaxUploadSizes=%v: expected error, got nil", test.maxUploadSizes)
+			}
+			if !test.wantErr && err != nil {
+				t.Errorf("validateFlags with MaxUploadSizes=%v: unexpected error: %v", test.maxUploadSizes, err)
+			}
+		})
+	}
+}
+
+func TestValidateFlagsRenderCacheSize(t *testing.T) {
+	validBase := serveOptions{
+		Port:             8080,
+		ProxyPort:        8081,
+		DatebaseFilePath: "photos.db",
+		GCSBucket:        "my-bucket",
+		WebPQuality:      80,
+		RenderCacheDir:   "./render-cache",
+	}
+
+	tests := []struct {
+		name            string
+		renderCacheDir  string
+		renderCacheSize string
+		wantErr         bool
+	}{
+		{"default", "./render-cache", DefaultRenderCacheSize, false},
+		{"megabytes", "./render-cache", "512MB", false},
+		{"empty size", "./render-cache", "", true},
+		{"invalid size", "./render-cache", "lots", true},
+		{"caching disabled ignores size", "", "lots", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := validBase
+			opts.RenderCacheDir = test.renderCacheDir
+			opts.RenderCacheSize = test.renderCacheSize
+			err := validateFlags(opts)
+			if test.wantErr && err == nil {
+				t.Errorf("validateFlags with RenderCacheSize=%q: expected error, got nil", test.renderCacheSize)
+			}
+			if !test.wantErr && err != nil {
+				t.Errorf("validateFlags with RenderCacheSize=%q: unexpected error: %v", test.renderCacheSize, err)
+			}
+		})
+	}
+}
+
+func TestValidateFlagsThumbnailSizes(t *testing.T) {
+	validBase := serveOptions{
+		Port:             8080,
+		ProxyPort:        8081,
+		DatebaseFilePath: "photos.db",
+		GCSBucket:        "my-bucket",
+		WebPQuality:      80,
+	}
+
+	tests := []struct {
+		name           string
+		thumbnailSizes []int
+		wantErr        bool
+	}{
+		{"default", internal.DefaultThumbnailSizes, false},
+		{"disabled", nil, false},
+		{"zero", []int{0}, true},
+		{"too large", []int{256, 8192}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := validBase
+			opts.ThumbnailSizes = test.thumbnailSizes
+			err := validateFlags(opts)
+			if test.wantErr && err == nil {
+				t.Errorf("validateFlags with ThumbnailSizes=%v: expected error, got nil", test.thumbnailSizes)
+			}
+			if !test.wantErr && err != nil {
+				t.Errorf("validateFlags with ThumbnailSizes=%v: unexpected error: %v", test.thumbnailSizes, err)
+			}
+		})
+	}
+}
+
+func TestValidateFlagsAVIFQuality(t *testing.T) {
+	validBase := serveOptions{
+		Port:             8080,
+		ProxyPort:        8081,
+		DatebaseFilePath: "photos.db",
+		GCSBucket:        "my-bucket",
+		WebPQuality:      80,
+	}
+
+	tests := []struct {
+		name        string
+		avifQuality int
+		wantErr     bool
+	}{
+		{"disabled (0)", 0, false},
+		{"default", internal.DefaultAVIFQuality, false},
+		{"maximum valid (100)", 100, false},
+		{"negative", -1, true},
+		{"above maximum (101)", 101, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := validBase
+			opts.AVIFQuality = test.avifQuality
+			err := validateFlags(opts)
+			if test.wantErr && err == nil {
+				t.Errorf("validateFlags with AVIFQuality=%d: expected error, got nil", test.avifQuality)
+			}
+			if !test.wantErr && err != nil {
+				t.Errorf("validateFlags with AVIFQuality=%d: unexpected error: %v", test.avifQuality, err)
+			}
+		})
+	}
+}
+
+func TestValidateFlagsHLSHeights(t *testing.T) {
+	validBase := serveOptions{
+		Port:             8080,
+		ProxyPort:        8081,
+		DatebaseFilePath: "photos.db",
+		GCSBucket:        "my-bucket",
+		WebPQuality:      80,
+	}
+
+	tests := []struct {
+		name       string
+		hlsHeights []int
+		wantErr    bool
+	}{
+		{"disabled (empty)", nil, false},
+		{"typical ladder", []int{360, 720, 1080}, false},
+		{"maximum valid (4320)", []int{4320}, false},
+		{"zero", []int{0}, true},
+		{"odd", []int{721}, true},
+		{"above maximum (4322)", []int{4322}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := validBase
+			opts.HLSHeights = test.hlsHeights
+			err := validateFlags(opts)
+			if test.wantErr && err == nil {
+				t.Errorf("validateFlags with HLSHeights=%v: expected error, got nil", test.hlsHeights)
+			}
+			if !test.wantErr && err != nil {
+				t.Errorf("validateFlags with HLSHeights=%v: unexpected error: %v", test.hlsHeights, err)
+			}
+		})
+	}
+}
+
+func TestValidateFlagsJobWorkers(t *testing.T) {
+	validBase := serveOptions{
+		Port:             8080,
+		ProxyPort:        8081,
+		DatebaseFilePath: "photos.db",
+		GCSBucket:        "my-bucket",
+		WebPQuality:      80,
+	}
+
+	tests := []struct {
+		name       string
+		jobWorkers int
+		wantErr    bool
+	}{
+		{"default", internal.DefaultJobWorkers, false},
+		{"one per CPU", 0, false},
+		{"negative", -1, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := validBase
+			opts.JobWorkers = test.jobWorkers
+			err := validateFlags(opts)
+			if test.wantErr && err == nil {
+				t.Errorf("validateFlags with JobWorkers=%d: expected error, got nil", test.jobWorkers)
+			}
+			if !test.wantErr && err != nil {
+				t.Errorf("validateFlags with JobWorkers=%d: unexpected error: %v", test.jobWorkers, err)
+			}
+		})
+	}
+}
+
+func TestValidateFlagsSchedules(t *testing.T) {
+	validBase := serveOptions{
+		Port:             8080,
+		ProxyPort:        8081,
+		DatebaseFilePath: "photos.db",
+		GCSBucket:        "my-bucket",
+		WebPQuality:      80,
+	}
+
+	tests := []struct {
+		name      string
+		schedules []string
+		wantErr   bool
+	}{
+		{"none", nil, false},
+		{"valid", []string{"sync=0 3 * * *", "webp=30 3 * * *", "purge_trash=@weekly"}, false},
+		{"list of minutes", []string{"verify=0,30 * * * *"}, false},
+		{"unknown task", []string{"backup=0 3 * * *"}, true},
+		{"invalid expression", []string{"sync=0 25 * * *"}, true},
+		{"missing expression", []string{"sync"}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := validBase
+			opts.Schedules = test.schedules
+			err := validateFlags(opts)
+			if test.wantErr && err == nil {
+				t.Errorf("validateFlags with Schedules=%q: expected error, got nil", test.schedules)
+			}
+			if !test.wantErr && err != nil {
+				t.Errorf("validateFlags with Schedules=%q: unexpected error: %v", test.schedules, err)
+			}
+		})
+	}
+}
+
+func TestValidateFlagsConcurrency(t *testing.T) {
+	validBase := serveOptions{
+		Port:             8080,
+		ProxyPort:        8081,
+		DatebaseFilePath: "photos.db",
+		GCSBucket:        "my-bucket",
+		WebPQuality:      80,
+	}
+
+	tests := []struct {
+		name    string
+		modify  func(*serveOptions)
+		wantErr bool
+	}{
+		{"defaults", func(o *serveOptions) {
+			o.Concurrency = internal.DefaultConcurrency
+			o.ObjectTimeout = internal.DefaultObjectTimeout
+		}, false},
+		{"unset", func(o *serveOptions) {}, false},
+		{"rate limits", func(o *serveOptions) { o.GCSReadRate, o.ToolRate = 10, 0.5 }, false},
+		{"negative concurrency", func(o *serveOptions) { o.Concurrency = -1 }, true},
+		{"negative object timeout", func(o *serveOptions) { o.ObjectTimeout = -time.Second }, true},
+		{"negative GCS read rate", func(o *serveOptions) { o.GCSReadRate = -1 }, true},
+		{"negative tool rate", func(o *serveOptions) { o.ToolRate = -1 }, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := validBase
+			test.modify(&opts)
+			err := validateFlags(opts)
+			if test.wantErr && err == nil {
+				t.Errorf("validateFlags: expected error, got nil")
+			}
+			if !test.wantErr && err != nil {
+				t.Errorf("validateFlags: unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/alexhokl/photos/proto"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+)
+
+type updateAvifOptions struct {
+	pauseInSeconds uint32
+}
+
+var updateAvifOpts updateAvifOptions
+
+var updateAvifCmd = &cobra.Command{
+	Use:   "avif",
+	Short: "Generate missing AVIF renditions for all eligible photos",
+	Long: `Generate missing AVIF renditions for every photo belonging to the
+authenticated user whose avif_object_id is empty, such as those uploaded
+before AVIF renditions were enabled on the server.
+
+For each eligible photo the original file is downloaded from the storage
+backend and a lossy AVIF rendition is generated and stored alongside the
+original as <name>.avif; the new object ID is recorded in avif_object_id.
+JPEG and PNG files use the original object as the AVIF source. RAW and HEIC
+files are handled via their JPEG preview: if no preview exists one is
+generated first. All other content types are skipped.
+
+The server must be started with a non-zero --avif-quality and have avifenc
+installed; otherwise the command fails without processing any photo.
+
+Per-object failures are logged and skipped; they do not abort the run.
+Progress is streamed from the server: one message per processed object,
+plus a final summary message with cumulative generated/skipped/failed
+counts.`,
+	RunE: runUpdateAvif,
+}
+
+func init() {
+	updateAvifCmd.Flags().Uint32Var(&updateAvifOpts.pauseInSeconds, "pause-in-seconds", 0, "Seconds to sleep between per-object AVIF generations (reduces CPU pressure)")
+	updateCmd.AddCommand(updateAvifCmd)
+}
+
+func runUpdateAvif(cmd *cobra.Command, args []string) error {
+	conn, err := grpc.NewClient(
+		rootOpts.serviceURI,
+		grpc.WithTransportCredentials(getConnectionCredentials(requireSecureConnection(rootOpts.serviceURI))),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to connect to server: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	client := proto.NewLibraryServiceClient(conn)
+
+	req := &proto.UpdateAvifRequest{
+		PauseBetweenObjectsSeconds: updateAvifOpts.pauseInSeconds,
+	}
+
+	stream, err := client.UpdateAvif(cmd.Context(), req)
+	if err != nil {
+		return fmt.Errorf("failed to update avif: %w", err)
+	}
+
+	for {
+		progress, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to receive avif update progress: %w", err)
+		}
+
+		if progress.GetComplete() {
+			fmt.Printf(
+				"AVIF update complete: generated=%d skipped=%d failed=%d\n",
+				progress.GetGenerated(),
+				progress.GetSkipped(),
+				progress.GetFailed(),
+			)
+			break
+		}
+
+		fmt.Printf(
+			"processed=%d/%d generated=%d skipped=%d failed=%d\n",
+			progress.GetProcessed(),
+			progress.GetTotal(),
+			progress.GetGenerated(),
+			progress.GetSkipped(),
+			progress.GetFailed(),
+		)
+	}
+
+	return nil
+}
@@ -1,8 +1,12 @@
 package cmd
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
 
 	"github.com/alexhokl/photos/proto"
 	"github.com/spf13/cobra"
@@ -12,6 +16,9 @@ import (
 type updateDatabaseOptions struct {
 	updateMetadata bool
 	pauseInSeconds uint32
+	full           bool
+	dryRun         bool
+	format         string
 }
 
 var updateDatabaseOpts updateDatabaseOptions
@@ -20,8 +27,18 @@ var updateDatabaseCmd = &cobra.Command{
 	Use:   "database",
 	Short: "Sync the photo database with the storage backend",
 	Long: `Sync the photo database with the storage backend (GCS bucket) for the
-authenticated user. Derived assets (.webp, _preview.jpg, _thumb.jpg) are
-excluded from all insertion logic. The sync has three phases:
+authenticated user. Derived assets (WebP renditions, RAW previews, video
+thumbnails and fixed-size thumbnails) are excluded from all insertion logic.
+They are identified by the record kept when they are generated, or by the
+derived_from marker in their GCS metadata, and never by filename, so a .webp
+photo you uploaded yourself is synced like any other. XMP sidecars (.xmp) are
+attached to their photo rather than inserted as photos.
+
+Only the objects changed since the last sync are examined, and photos whose
+object has been deleted are found by comparing the names of the objects in the
+bucket with the database; the phases below apply to those objects and photos.
+With --full, and on the first sync, every object and photo is examined. The
+sync has six phases:
 
 1. Add missing objects: any GCS object not present in the database (and not a
    derived asset) is inserted as a new PhotoObject (with content type, MD5
@@ -31,34 +48,64 @@ excluded from all insertion logic. The sync has three phases:
 
 2. Remove stale and derived objects: any PhotoObject in the database that no
    longer exists in GCS is deleted. Additionally, any PhotoObject whose
-   ObjectID is a derived asset (.webp, _preview.jpg, _thumb.jpg) is deleted
-   regardless of GCS state. In both cases, if the deletion leaves the parent
+   ObjectID is a recorded derived asset is deleted regardless of GCS state. In both cases, if the deletion leaves the parent
    directory empty, the PhotoDirectory entry is also deleted.
 
-3. Metadata refresh (--update-metadata only): for every GCS object, the file is
-   downloaded, EXIF metadata is extracted, the metadata is written back to the
-   GCS object, and time_taken is updated in the database. For DNG files that
-   have no JPEG preview yet, a preview is generated, uploaded, and its ID
-   stored in thumbnail_object_id. For eligible images (jpeg, png, gif) that
-   have no WebP version yet, a WebP rendition is generated and stored alongside
-   the original; for DNG files the WebP is derived from the JPEG preview. The
-   WebP object ID is stored in webp_object_id. Derived assets are skipped for
-   WebP generation. This flag is expensive as it downloads every object.
+3. Sidecars: new or changed XMP sidecars are parsed (rating, label, keywords,
+   caption and crop) and attached to the photo with the same basename, with
+   RAW files preferred over JPEGs. Sidecar records whose object no longer
+   exists in GCS are deleted.
+
+4. Metadata refresh (--update-metadata only): for every GCS object examined,
+   the file is downloaded, EXIF metadata is extracted, the metadata is written
+   back to the GCS object if it has changed, and time_taken is updated in the
+   database. For RAW and HEIC files that have no JPEG preview yet, a preview
+   is generated, uploaded, and its ID stored in thumbnail_object_id. For
+   eligible images (jpeg, png, gif) that have no WebP version yet, a WebP
+   rendition is generated and stored alongside the original; for RAW and
+   HEIC files the WebP is derived from the JPEG preview. The WebP object ID is
+   stored in webp_object_id. Derived assets are skipped for WebP generation.
+   This flag is expensive with --full as it downloads every object.
+
+5. Posters: videos without a poster frame or animated preview are downloaded
+   (if ffmpeg is installed on the server). A representative, non-black frame
+   of the first seconds is stored as <name>_thumb.jpg in thumbnail_object_id
+   and a short animated WebP as <name>_preview.webp in
+   animated_preview_object_id.
+
+6. Renditions: photos missing any of the fixed-size thumbnails configured on
+   the server (--thumbnail-sizes) are downloaded and the missing sizes are
+   generated, stored as <name>_<size>px.jpg and recorded against the photo.
+   RAW and HEIC files are rendered from their JPEG preview and videos from
+   their poster frame. Thumbnail records
+   whose photo no longer exists are deleted.
 
 Per-object failures in all phases are logged and skipped; they do not abort the
 sync. Progress is streamed from the server: one message per processed object,
-plus a final summary message with cumulative added/removed/metadata-updated
-counts.`,
+plus a final summary message with cumulative added/removed/metadata-updated,
+renditions-generated and posters-generated counts.
+
+With --dry-run nothing is changed. Instead the changes the sync would make are
+printed, one per object: photos and sidecars to add, deleted photos to
+restore, photos and sidecars to remove, metadata to update and WebP renditions
+to generate. Use --format json for JSON rather than a table.`,
 	RunE: runUpdateDatabase,
 }
 
 func init() {
 	updateDatabaseCmd.Flags().BoolVar(&updateDatabaseOpts.updateMetadata, "update-metadata", false, "Download each photo to extract EXIF metadata, update GCS object metadata, and set time_taken in the database")
+	updateDatabaseCmd.Flags().BoolVar(&updateDatabaseOpts.full, "full", false, "Examine every object in the bucket rather than only those changed since the last sync")
 	updateDatabaseCmd.Flags().Uint32Var(&updateDatabaseOpts.pauseInSeconds, "update-metadata-pause-in-seconds", 0, "Seconds to sleep between per-object metadata updates (reduces CPU pressure; only effective with --update-metadata)")
+	updateDatabaseCmd.Flags().BoolVar(&updateDatabaseOpts.dryRun, "dry-run", false, "Print the changes the sync would make without making them")
+	updateDatabaseCmd.Flags().StringVarP(&updateDatabaseOpts.format, "format", "f", "table", "Output format of --dry-run: table or json")
 	updateCmd.AddCommand(updateDatabaseCmd)
 }
 
 func runUpdateDatabase(cmd *cobra.Command, args []string) error {
+	if updateDatabaseOpts.format != "table" && updateDatabaseOpts.format != "json" {
+		return fmt.Errorf("invalid format %q: must be table or json", updateDatabaseOpts.format)
+	}
+
 	conn, err := grpc.NewClient(
 		rootOpts.serviceURI,
 		grpc.WithTransportCredentials(getConnectionCredentials(requireSecureConnection(rootOpts.serviceURI))),
@@ -73,6 +120,8 @@ func runUpdateDatabase(cmd *cobra.Command, args []string) error {
 	req := &proto.SyncDatabaseRequest{
 		UpdateMetadata:             updateDatabaseOpts.updateMetadata,
 		PauseBetweenObjectsSeconds: updateDatabaseOpts.pauseInSeconds,
+		Full:                       updateDatabaseOpts.full,
+		DryRun:                     updateDatabaseOpts.dryRun,
 	}
 
 	stream, err := client.SyncDatabase(cmd.Context(), req)
@@ -80,6 +129,28 @@ func runUpdateDatabase(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to sync database: %w", err)
 	}
 
+	if updateDatabaseOpts.dryRun {
+		var changes []*proto.SyncChange
+		var summary *proto.SyncDatabaseProgress
+		for {
+			progress, err := stream.Recv()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("failed to receive sync plan: %w", err)
+			}
+			if progress.GetComplete() {
+				summary = progress
+				break
+			}
+			if change := progress.GetChange(); change != nil {
+				changes = append(changes, change)
+			}
+		}
+		return printSyncPlan(os.Stdout, updateDatabaseOpts.format, changes, summary)
+	}
+
 	for {
 		progress, err := stream.Recv()
 		if err == io.EOF {
@@ -90,11 +161,18 @@ func runUpdateDatabase(cmd *cobra.Command, args []string) error {
 		}
 
 		if progress.GetComplete() {
+			mode := "incremental"
+			if progress.GetFull() {
+				mode = "full"
+			}
 			fmt.Printf(
-				"Sync complete: added=%d removed=%d metadata_updated=%d\n",
+				"Sync complete: mode=%s added=%d removed=%d metadata_updated=%d renditions_generated=%d posters_generated=%d\n",
+				mode,
 				progress.GetAdded(),
 				progress.GetRemoved(),
 				progress.GetMetadataUpdated(),
+				progress.GetRenditionsGenerated(),
+				progress.GetPostersGenerated(),
 			)
 			break
 		}
@@ -108,4 +186,70 @@ func runUpdateDatabase(cmd *cobra.Command, args []string) error {
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+// syncPlanEntry is a change of a sync dry run as printed in JSON.
+type syncPlanEntry struct {
+	Action   string `json:"action"`
+	ObjectID string `json:"object_id"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// syncActionName returns the name of a change printed, such as "add".
+func syncActionName(action proto.SyncChange_Action) string {
+	return strings.ToLower(strings.TrimPrefix(action.String(), "ACTION_"))
+}
+
+// printSyncPlan prints the changes reported by a sync dry run, as a table
+// followed by the summary or as JSON.
+func printSyncPlan(w io.Writer, format string, changes []*proto.SyncChange, summary *proto.SyncDatabaseProgress) error {
+	if format == "json" {
+		result := struct {
+			Full            bool            `json:"full"`
+			Changes         []syncPlanEntry `json:"changes"`
+			Added           uint32          `json:"added"`
+			Removed         uint32          `json:"removed"`
+			MetadataUpdated uint32          `json:"metadata_updated"`
+		}{
+			Full:            summary.GetFull(),
+			Changes:         make([]syncPlanEntry, 0, len(changes)),
+			Added:           summary.GetAdded(),
+			Removed:         summary.GetRemoved(),
+			MetadataUpdated: summary.GetMetadataUpdated(),
+		}
+		for _, change := range changes {
+			result.Changes = append(result.Changes, syncPlanEntry{
+				Action:   syncActionName(change.GetAction()),
+				ObjectID: change.GetObjectId(),
+				Reason:   change.GetReason(),
+			})
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(result); err != nil {
+			return fmt.Errorf("failed to encode JSON: %w", err)
+		}
+		return nil
+	}
+
+	if len(changes) == 0 {
+		_, _ = fmt.Fprintln(w, "No changes")
+	} else {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintln(tw, "ACTION\tOBJECT\tREASON")
+		for _, change := range changes {
+			_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\n", syncActionName(change.GetAction()), change.GetObjectId(), change.GetReason())
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	mode := "incremental"
+	if summary.GetFull() {
+		mode = "full"
+	}
+	_, _ = fmt.Fprintf(w, "Dry run (%s): would add %d, remove %d, update metadata of %d\n",
+		mode, summary.GetAdded(), summary.GetRemoved(), summary.GetMetadataUpdated())
+	return nil
+}
@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/alexhokl/photos/proto"
+)
+
+func TestUpdateDatabaseCommandFlags(t *testing.T) {
+	tests := []struct {
+		name     string
+		flagName string
+		expected string
+	}{
+		{"full flag exists", "full", "false"},
+		{"dry-run flag exists", "dry-run", "false"},
+		{"format flag exists", "format", "table"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			flag := updateDatabaseCmd.Flags().Lookup(test.flagName)
+			if flag == nil {
+				t.Errorf("Expected flag %s to exist, but it doesn't", test.flagName)
+				return
+			}
+			if flag.DefValue != test.expected {
+				t.Errorf("Expected default value %q for flag %s, but got %q", test.expected, test.flagName, flag.DefValue)
+			}
+		})
+	}
+}
+
+func testSyncPlan() ([]*proto.SyncChange, *proto.SyncDatabaseProgress) {
+	changes := []*proto.SyncChange{
+		{Action: proto.SyncChange_ACTION_ADD, ObjectId: "2024/new.jpg", Reason: "not in the database"},
+		{Action: proto.SyncChange_ACTION_REMOVE, ObjectId: "2024/gone.jpg", Reason: "not in storage"},
+		{Action: proto.SyncChange_ACTION_GENERATE_WEBP, ObjectId: "2024/new.jpg", Reason: "no WebP rendition"},
+	}
+	summary := &proto.SyncDatabaseProgress{Added: 1, Removed: 1, Complete: true}
+	return changes, summary
+}
+
+func TestPrintSyncPlan_Table(t *testing.T) {
+	changes, summary := testSyncPlan()
+	var out bytes.Buffer
+	if err := printSyncPlan(&out, "table", changes, summary); err != nil {
+		t.Fatalf("printSyncPlan() error = %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 5 {
+		t.Fatalf("got %d lines, want a header, 3 changes and a summary:\n%s", len(lines), out.String())
+	}
+	if fields := strings.Fields(lines[0]); len(fields) != 3 || fields[0] != "ACTION" {
+		t.Errorf("header = %q", lines[0])
+	}
+	if fields := strings.Fields(lines[2]); fields[0] != "remove" || fields[1] != "2024/gone.jpg" {
+		t.Errorf("second change = %q, want removal of 2024/gone.jpg", lines[2])
+	}
+	if !strings.Contains(lines[3], "generate_webp") {
+		t.Errorf("third change = %q, want generate_webp", lines[3])
+	}
+	if lines[4] != "Dry run (incremental): would add 1, remove 1, update metadata of 0" {
+		t.Errorf("summary = %q", lines[4])
+	}
+}
+
+func TestPrintSyncPlan_NoChanges(t *testing.T) {
+	var out bytes.Buffer
+	if err := printSyncPlan(&out, "table", nil, &proto.SyncDatabaseProgress{Full: true, Complete: true}); err != nil {
+		t.Fatalf("printSyncPlan() error = %v", err)
+	}
+	want := "No changes\nDry run (full): would add 0, remove 0, update metadata of 0\n"
+	if out.String() != want {
+		t.Errorf("printSyncPlan() = %q, want %q", out.String(), want)
+	}
+}
+
+func TestPrintSyncPlan_JSON(t *testing.T) {
+	changes, summary := testSyncPlan()
+	var out bytes.Buffer
+	if err := printSyncPlan(&out, "json", changes, summary); err != nil {
+		t.Fatalf("printSyncPlan() error = %v", err)
+	}
+
+	var result struct {
+		Full    bool `json:"full"`
+		Changes []struct {
+			Action   string `json:"action"`
+			ObjectID string `json:"object_id"`
+			Reason   string `json:"reason"`
+		} `json:"changes"`
+		Added   uint32 `json:"added"`
+		Removed uint32 `json:"removed"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &result); err != nil {
+		t.Fatalf("failed to decode JSON %q: %v", out.String(), err)
+	}
+	if len(result.Changes) != 3 || result.Added != 1 || result.Removed != 1 {
+		t.Fatalf("decoded = %+v, want 3 changes, 1 added and 1 removed", result)
+	}
+	if c := result.Changes[0]; c.Action != "add" || c.ObjectID != "2024/new.jpg" || c.Reason != "not in the database" {
+		t.Errorf("first change = %+v", c)
+	}
+}
@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/alexhokl/photos/proto"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+)
+
+type updateTranscodeOptions struct {
+	objectID       string
+	force          bool
+	pauseInSeconds uint32
+}
+
+var updateTranscodeOpts updateTranscodeOptions
+
+var updateTranscodeCmd = &cobra.Command{
+	Use:   "transcode",
+	Short: "Transcode videos to web-friendly MP4 and HLS",
+	Long: `Transcode the videos belonging to the authenticated user to an H.264/AAC
+MP4 proxy and, if the server is started with --hls-heights, an HLS ladder,
+so that they play in every browser and phone. Phones record HEVC in
+QuickTime (.mov) files, which many devices cannot play.
+
+The proxy is stored alongside the original as <name>_proxy.mp4 and the HLS
+playlists and segments under <name>_hls/, with the master playlist at
+<name>_hls/index.m3u8. Both are recorded in proxy_object_id and
+hls_object_id and served by the gateway like any other object.
+
+Without --object-id every video lacking its proxy or HLS ladder is
+transcoded, such as those uploaded before transcoding was enabled on the
+server. --force transcodes videos again even if they are complete.
+
+The server must have ffmpeg installed; otherwise the command fails without
+processing any video.
+
+Per-object failures are logged and skipped; they do not abort the run.
+Progress is streamed from the server while each video is transcoded, plus
+a final summary message with cumulative transcoded/skipped/failed counts.`,
+	RunE: runUpdateTranscode,
+}
+
+func init() {
+	flags := updateTranscodeCmd.Flags()
+	flags.StringVar(&updateTranscodeOpts.objectID, "object-id", "", "Object ID of the only video to transcode")
+	flags.BoolVar(&updateTranscodeOpts.force, "force", false, "Transcode videos again even if they are complete")
+	flags.Uint32Var(&updateTranscodeOpts.pauseInSeconds, "pause-in-seconds", 0, "Seconds to sleep between per-object transcodes (reduces CPU pressure)")
+	updateCmd.AddCommand(updateTranscodeCmd)
+}
+
+func runUpdateTranscode(cmd *cobra.Command, args []string) error {
+	conn, err := grpc.NewClient(
+		rootOpts.serviceURI,
+		grpc.WithTransportCredentials(getConnectionCredentials(requireSecureConnection(rootOpts.serviceURI))),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to connect to server: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	client := proto.NewLibraryServiceClient(conn)
+
+	req := &proto.TranscodeVideoRequest{
+		ObjectId:                   updateTranscodeOpts.objectID,
+		Force:                      updateTranscodeOpts.force,
+		PauseBetweenObjectsSeconds: updateTranscodeOpts.pauseInSeconds,
+	}
+
+	stream, err := client.TranscodeVideo(cmd.Context(), req)
+	if err != nil {
+		return fmt.Errorf("failed to transcode videos: %w", err)
+	}
+
+	for {
+		progress, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to receive transcode progress: %w", err)
+		}
+
+		if progress.GetComplete() {
+			fmt.Printf(
+				"Transcode complete: transcoded=%d skipped=%d failed=%d\n",
+				progress.GetTranscoded(),
+				progress.GetSkipped(),
+				progress.GetFailed(),
+			)
+			break
+		}
+
+		// Progress within a video overwrites the current line
+		if progress.GetPercent() < 100 {
+			fmt.Printf("\r%s: %3.0f%%", progress.GetObjectId(), progress.GetPercent())
+			continue
+		}
+
+		fmt.Printf(
+			"\r%s: processed=%d/%d transcoded=%d skipped=%d failed=%d\n",
+			progress.GetObjectId(),
+			progress.GetProcessed(),
+			progress.GetTotal(),
+			progress.GetTranscoded(),
+			progress.GetSkipped(),
+			progress.GetFailed(),
+		)
+	}
+
+	return nil
+}
@@ -5,6 +5,7 @@ import (
 	"mime"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/alexhokl/photos/proto"
 	"github.com/spf13/cobra"
@@ -12,8 +13,35 @@ import (
 )
 
 type uploadOptions struct {
-	filePath string
-	objectID string
+	filePath   string
+	objectID   string
+	onConflict string
+}
+
+// onConflictUsage is the help text shared by the --on-conflict flag of all
+// upload commands.
+const onConflictUsage = "What to do if the object already exists: fail, rename (to \"name (1).ext\"), replace, or skip (only if the content is identical)"
+
+// conflictPolicies maps the values accepted by --on-conflict to the policy
+// sent to the server.
+var conflictPolicies = map[string]proto.ConflictPolicy{
+	"fail":    proto.ConflictPolicy_CONFLICT_POLICY_FAIL,
+	"rename":  proto.ConflictPolicy_CONFLICT_POLICY_RENAME,
+	"replace": proto.ConflictPolicy_CONFLICT_POLICY_REPLACE,
+	"skip":    proto.ConflictPolicy_CONFLICT_POLICY_SKIP_IF_IDENTICAL,
+}
+
+// parseConflictPolicy converts the value of an --on-conflict flag to a
+// proto.ConflictPolicy. An empty value leaves the choice to the server.
+func parseConflictPolicy(value string) (proto.ConflictPolicy, error) {
+	if value == "" {
+		return proto.ConflictPolicy_CONFLICT_POLICY_UNSPECIFIED, nil
+	}
+	policy, ok := conflictPolicies[strings.ToLower(value)]
+	if !ok {
+		return proto.ConflictPolicy_CONFLICT_POLICY_UNSPECIFIED, fmt.Errorf("invalid --on-conflict value %q: must be one of fail, rename, replace, skip", value)
+	}
+	return policy, nil
 }
 
 var uploadOpts uploadOptions
@@ -31,6 +59,7 @@ func init() {
 	flags := uploadCmd.Flags()
 	flags.StringVarP(&uploadOpts.filePath, "file", "f", "", "Path to the image file to upload")
 	flags.StringVarP(&uploadOpts.objectID, "object-id", "o", "", "Object ID for the uploaded file (defaults to filename)")
+	flags.StringVar(&uploadOpts.onConflict, "on-conflict", "replace", onConflictUsage)
 
 	_ = uploadCmd.MarkFlagRequired("file")
 }
@@ -39,6 +68,11 @@ func runUpload(cmd *cobra.Command, args []string) error {
 	filePath := uploadOpts.filePath
 	objectID := uploadOpts.objectID
 
+	conflictPolicy, err := parseConflictPolicy(uploadOpts.onConflict)
+	if err != nil {
+		return err
+	}
+
 	// Validate file exists
 	fileInfo, err := os.Stat(filePath)
 	if err != nil {
@@ -83,9 +117,10 @@ func runUpload(cmd *cobra.Command, args []string) error {
 	client := proto.NewByteServiceClient(conn)
 
 	req := &proto.UploadRequest{
-		ObjectId:    objectID,
-		ContentType: contentType,
-		Data:        data,
+		ObjectId:       objectID,
+		ContentType:    contentType,
+		Data:           data,
+		ConflictPolicy: conflictPolicy,
 	}
 
 	resp, err := client.Upload(cmd.Context(), req)
@@ -94,7 +129,11 @@ func runUpload(cmd *cobra.Command, args []string) error {
 	}
 
 	photo := resp.GetPhoto()
-	fmt.Printf("Successfully uploaded photo\n")
+	if resp.GetSkipped() {
+		fmt.Printf("Skipped upload; an identical photo already exists\n")
+	} else {
+		fmt.Printf("Successfully uploaded photo\n")
+	}
 	fmt.Printf("  Object ID:    %s\n", photo.GetObjectId())
 	fmt.Printf("  Filename:     %s\n", photo.GetFilename())
 	fmt.Printf("  Content Type: %s\n", photo.GetContentType())
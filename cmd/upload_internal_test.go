@@ -0,0 +1,58 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/alexhokl/photos/proto"
+)
+
+func TestParseConflictPolicy(t *testing.T) {
+	tests := []struct {
+		value     string
+		expected  proto.ConflictPolicy
+		expectErr bool
+	}{
+		{"fail", proto.ConflictPolicy_CONFLICT_POLICY_FAIL, false},
+		{"rename", proto.ConflictPolicy_CONFLICT_POLICY_RENAME, false},
+		{"replace", proto.ConflictPolicy_CONFLICT_POLICY_REPLACE, false},
+		{"skip", proto.ConflictPolicy_CONFLICT_POLICY_SKIP_IF_IDENTICAL, false},
+		{"Rename", proto.ConflictPolicy_CONFLICT_POLICY_RENAME, false},
+		{"overwrite", proto.ConflictPolicy_CONFLICT_POLICY_UNSPECIFIED, true},
+		{"", proto.ConflictPolicy_CONFLICT_POLICY_UNSPECIFIED, false},
+	}
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			policy, err := parseConflictPolicy(test.value)
+			if test.expectErr {
+				if err == nil {
+					t.Errorf("expected error for %q, got nil", test.value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if policy != test.expected {
+				t.Errorf("parseConflictPolicy(%q) = %v, want %v", test.value, policy, test.expected)
+			}
+		})
+	}
+}
+
+func TestUploadCommandsHaveOnConflictFlag(t *testing.T) {
+	for _, cmd := range []string{"upload", "upload-streaming", "bulk-upload-streaming"} {
+		t.Run(cmd, func(t *testing.T) {
+			found, _, err := rootCmd.Find([]string{cmd})
+			if err != nil {
+				t.Fatalf("command %q not found: %v", cmd, err)
+			}
+			flag := found.Flags().Lookup("on-conflict")
+			if flag == nil {
+				t.Fatalf("command %q has no --on-conflict flag", cmd)
+			}
+			if flag.DefValue != "replace" {
+				t.Errorf("--on-conflict default = %q, want %q", flag.DefValue, "replace")
+			}
+		})
+	}
+}
@@ -15,9 +15,10 @@ import (
 const defaultChunkSize = 64 * 1024 // 64 KB
 
 type uploadStreamingOptions struct {
-	filePath  string
-	objectID  string
-	chunkSize int
+	filePath   string
+	objectID   string
+	chunkSize  int
+	onConflict string
 }
 
 var uploadStreamingOpts uploadStreamingOptions
@@ -39,6 +40,7 @@ func init() {
 	flags.StringVarP(&uploadStreamingOpts.filePath, "file", "f", "", "Path to the image file to upload")
 	flags.StringVarP(&uploadStreamingOpts.objectID, "object-id", "o", "", "Object ID for the uploaded file (defaults to filename)")
 	flags.IntVarP(&uploadStreamingOpts.chunkSize, "chunk-size", "c", defaultChunkSize, "Size of each chunk in bytes for streaming upload")
+	flags.StringVar(&uploadStreamingOpts.onConflict, "on-conflict", "replace", onConflictUsage)
 
 	_ = uploadStreamingCmd.MarkFlagRequired("file")
 }
@@ -53,6 +55,11 @@ func runUploadStreaming(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("chunk size must be positive, got: %d", chunkSize)
 	}
 
+	conflictPolicy, err := parseConflictPolicy(uploadStreamingOpts.onConflict)
+	if err != nil {
+		return err
+	}
+
 	// Validate file exists
 	fileInfo, err := os.Stat(filePath)
 	if err != nil {
@@ -107,8 +114,9 @@ func runUploadStreaming(cmd *cobra.Command, args []string) error {
 	metadataReq := &proto.StreamingUploadRequest{
 		Data: &proto.StreamingUploadRequest_Metadata{
 			Metadata: &proto.PhotoMetadata{
-				Filename:    objectID,
-				ContentType: contentType,
+				Filename:       objectID,
+				ContentType:    contentType,
+				ConflictPolicy: conflictPolicy,
 			},
 		},
 	}
@@ -150,7 +158,11 @@ func runUploadStreaming(cmd *cobra.Command, args []string) error {
 	}
 
 	photo := resp.GetPhoto()
-	fmt.Printf("Successfully uploaded photo (streaming)\n")
+	if resp.GetSkipped() {
+		fmt.Printf("Skipped upload (streaming); an identical photo already exists\n")
+	} else {
+		fmt.Printf("Successfully uploaded photo (streaming)\n")
+	}
 	fmt.Printf("  Object ID:    %s\n", photo.GetObjectId())
 	fmt.Printf("  Filename:     %s\n", photo.GetFilename())
 	fmt.Printf("  Content Type: %s\n", photo.GetContentType())
@@ -0,0 +1,182 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/alexhokl/photos/proto"
+	"github.com/spf13/cobra"
+	"google.golang.org/grpc"
+)
+
+type verifyOptions struct {
+	repair bool
+	format string
+}
+
+var verifyOpts verifyOptions
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check the photo database against the storage backend",
+	Long: `Check the photo database of the authenticated user against the storage
+backend (GCS bucket) and the derived assets, and print the problems found:
+
+- missing-object: a photo whose object is not in the bucket
+- md5-mismatch: a photo whose object has a different MD5 hash than recorded
+- dangling-derived: a WebP, AVIF, preview, thumbnail or other derived asset
+  referenced by a photo or recorded for it that is not in the bucket
+- orphaned-derived: a derived asset whose photo is not in the database
+- empty-directory: a directory with no photos
+- missing-directory: a directory of photos that has no directory record
+
+With --repair the problems that are safe to repair are repaired: photos whose
+object is missing are moved to the trash, as a sync would delete them,
+references to missing derived assets are cleared so that the next sync or
+update webp generates them again, derived assets whose original is gone from
+the bucket too are deleted, and directory records are deleted or created.
+Objects whose MD5 hash differs, and derived assets whose original is in the
+bucket but not yet in the database, are only reported. A repair does not run
+alongside a sync or WebP or AVIF update for the same user. Use --format json
+for JSON rather than a table.`,
+	RunE: runVerify,
+}
+
+func init() {
+	verifyCmd.Flags().BoolVar(&verifyOpts.repair, "repair", false, "Repair the problems that are safe to repair")
+	verifyCmd.Flags().StringVarP(&verifyOpts.format, "format", "f", "table", "Output format: table or json")
+	rootCmd.AddCommand(verifyCmd)
+}
+
+func runVerify(cmd *cobra.Command, args []string) error {
+	if verifyOpts.format != "table" && verifyOpts.format != "json" {
+		return fmt.Errorf("invalid format %q: must be table or json", verifyOpts.format)
+	}
+
+	conn, err := grpc.NewClient(
+		rootOpts.serviceURI,
+		grpc.WithTransportCredentials(getConnectionCredentials(requireSecureConnection(rootOpts.serviceURI))),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to connect to server: %v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	client := proto.NewLibraryServiceClient(conn)
+
+	stream, err := client.VerifyLibrary(cmd.Context(), &proto.VerifyLibraryRequest{Repair: verifyOpts.repair})
+	if err != nil {
+		return fmt.Errorf("failed to verify library: %w", err)
+	}
+
+	var problems []*proto.IntegrityProblem
+	var summary *proto.VerifyLibraryProgress
+	for {
+		progress, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to receive integrity report: %w", err)
+		}
+		if progress.GetComplete() {
+			summary = progress
+			break
+		}
+		if problem := progress.GetProblem(); problem != nil {
+			problems = append(problems, problem)
+		}
+	}
+	return printIntegrityReport(os.Stdout, verifyOpts.format, problems, summary)
+}
+
+// integrityProblemEntry is a problem found by verify as printed in JSON.
+type integrityProblemEntry struct {
+	Kind     string `json:"kind"`
+	ObjectID string `json:"object_id"`
+	Detail   string `json:"detail,omitempty"`
+	Repaired bool   `json:"repaired"`
+}
+
+// integrityProblemKindName returns the name of a kind of problem printed,
+// such as "missing-object".
+func integrityProblemKindName(kind proto.IntegrityProblem_Kind) string {
+	return strings.ReplaceAll(strings.ToLower(strings.TrimPrefix(kind.String(), "KIND_")), "_", "-")
+}
+
+// printIntegrityReport prints the problems found by verify, as a table
+// followed by the summary or as JSON.
+func printIntegrityReport(w io.Writer, format string, problems []*proto.IntegrityProblem, summary *proto.VerifyLibraryProgress) error {
+	if format == "json" {
+		result := struct {
+			Problems           []integrityProblemEntry `json:"problems"`
+			PhotosChecked      uint32                  `json:"photos_checked"`
+			MissingObjects     uint32                  `json:"missing_objects"`
+			MD5Mismatches      uint32                  `json:"md5_mismatches"`
+			DanglingDerived    uint32                  `json:"dangling_derived"`
+			OrphanedDerived    uint32                  `json:"orphaned_derived"`
+			EmptyDirectories   uint32                  `json:"empty_directories"`
+			MissingDirectories uint32                  `json:"missing_directories"`
+			Repaired           uint32                  `json:"repaired"`
+		}{
+			Problems:           make([]integrityProblemEntry, 0, len(problems)),
+			PhotosChecked:      summary.GetPhotosChecked(),
+			MissingObjects:     summary.GetMissingObjects(),
+			MD5Mismatches:      summary.GetMd5Mismatches(),
+			DanglingDerived:    summary.GetDanglingDerived(),
+			OrphanedDerived:    summary.GetOrphanedDerived(),
+			EmptyDirectories:   summary.GetEmptyDirectories(),
+			MissingDirectories: summary.GetMissingDirectories(),
+			Repaired:           summary.GetRepaired(),
+		}
+		for _, problem := range problems {
+			result.Problems = append(result.Problems, integrityProblemEntry{
+				Kind:     integrityProblemKindName(problem.GetKind()),
+				ObjectID: problem.GetObjectId(),
+				Detail:   problem.GetDetail(),
+				Repaired: problem.GetRepaired(),
+			})
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(result); err != nil {
+			return fmt.Errorf("failed to encode JSON: %w", err)
+		}
+		return nil
+	}
+
+	if len(problems) == 0 {
+		_, _ = fmt.Fprintln(w, "No problems found")
+	} else {
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintln(tw, "PROBLEM\tOBJECT\tDETAIL\tREPAIRED")
+		for _, problem := range problems {
+			repaired := "no"
+			if problem.GetRepaired() {
+				repaired = "yes"
+			}
+			_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n",
+				integrityProblemKindName(problem.GetKind()), problem.GetObjectId(), problem.GetDetail(), repaired)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	_, _ = fmt.Fprintf(w,
+		"Checked %d photos: %d missing from storage, %d with a different MD5, %d dangling derived assets, %d orphaned derived assets, %d empty directories, %d missing directories; repaired %d\n",
+		summary.GetPhotosChecked(),
+		summary.GetMissingObjects(),
+		summary.GetMd5Mismatches(),
+		summary.GetDanglingDerived(),
+		summary.GetOrphanedDerived(),
+		summary.GetEmptyDirectories(),
+		summary.GetMissingDirectories(),
+		summary.GetRepaired(),
+	)
+	return nil
+}
@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/alexhokl/photos/proto"
+)
+
+func TestVerifyCommandFlags(t *testing.T) {
+	tests := []struct {
+		name     string
+		flagName string
+		expected string
+	}{
+		{"repair flag exists", "repair", "false"},
+		{"format flag exists", "format", "table"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			flag := verifyCmd.Flags().Lookup(test.flagName)
+			if flag == nil {
+				t.Errorf("Expected flag %s to exist, but it doesn't", test.flagName)
+				return
+			}
+			if flag.DefValue != test.expected {
+				t.Errorf("Expected default value %q for flag %s, but got %q", test.expected, test.flagName, flag.DefValue)
+			}
+		})
+	}
+}
+
+func testIntegrityReport() ([]*proto.IntegrityProblem, *proto.VerifyLibraryProgress) {
+	problems := []*proto.IntegrityProblem{
+		{Kind: proto.IntegrityProblem_KIND_MISSING_OBJECT, ObjectId: "2024/gone.jpg", Detail: "photo missing from storage", Repaired: true},
+		{Kind: proto.IntegrityProblem_KIND_MD5_MISMATCH, ObjectId: "2024/a.jpg", Detail: "MD5 hash x differs from y in storage"},
+	}
+	summary := &proto.VerifyLibraryProgress{PhotosChecked: 10, MissingObjects: 1, Md5Mismatches: 1, Repaired: 1, Complete: true}
+	return problems, summary
+}
+
+func TestPrintIntegrityReport_Table(t *testing.T) {
+	problems, summary := testIntegrityReport()
+	var out bytes.Buffer
+	if err := printIntegrityReport(&out, "table", problems, summary); err != nil {
+		t.Fatalf("printIntegrityReport() error = %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("got %d lines, want a header, 2 problems and a summary:\n%s", len(lines), out.String())
+	}
+	if fields := strings.Fields(lines[0]); len(fields) != 4 || fields[0] != "PROBLEM" {
+		t.Errorf("header = %q", lines[0])
+	}
+	if fields := strings.Fields(lines[1]); fields[0] != "missing-object" || fields[1] != "2024/gone.jpg" || fields[len(fields)-1] != "yes" {
+		t.Errorf("first problem = %q", lines[1])
+	}
+	if !strings.HasPrefix(lines[3], "Checked 10 photos: 1 missing from storage") || !strings.HasSuffix(lines[3], "repaired 1") {
+		t.Errorf("summary = %q", lines[3])
+	}
+
+	out.Reset()
+	if err := printIntegrityReport(&out, "table", nil, &proto.VerifyLibraryProgress{PhotosChecked: 3, Complete: true}); err != nil {
+		t.Fatalf("printIntegrityReport() error = %v", err)
+	}
+	if !strings.HasPrefix(out.String(), "No problems found\n") {
+		t.Errorf("output = %q", out.String())
+	}
+}
+
+func TestPrintIntegrityReport_JSON(t *testing.T) {
+	problems, summary := testIntegrityReport()
+	var out bytes.Buffer
+	if err := printIntegrityReport(&out, "json", problems, summary); err != nil {
+		t.Fatalf("printIntegrityReport() error = %v", err)
+	}
+
+	var result struct {
+		Problems []struct {
+			Kind     string `json:"kind"`
+			ObjectID string `json:"object_id"`
+			Repaired bool   `json:"repaired"`
+		} `json:"problems"`
+		PhotosChecked uint32 `json:"photos_checked"`
+		MD5Mismatches uint32 `json:"md5_mismatches"`
+		Repaired      uint32 `json:"repaired"`
+	}
+	if err := json.Unmarshal(out.Bytes(), &result); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, out.String())
+	}
+	if len(result.Problems) != 2 || result.Problems[1].Kind != "md5-mismatch" || result.Problems[1].Repaired {
+		t.Errorf("problems = %+v", result.Problems)
+	}
+	if result.PhotosChecked != 10 || result.MD5Mismatches != 1 || result.Repaired != 1 {
+		t.Errorf("summary = %+v", result)
+	}
+}
@@ -9,6 +9,12 @@ import (
 type User struct {
 	gorm.Model
 	Username string `gorm:"not null;unique"`
+	// QuotaBytes caps the total size of the user's original photos and
+	// videos; zero means unlimited.
+	QuotaBytes int64 `gorm:"not null;default:0"`
+	// QuotaObjects caps the number of the user's original photos and videos;
+	// zero means unlimited.
+	QuotaObjects int64 `gorm:"not null;default:0"`
 }
 
 type TailscaleAddress struct {
@@ -22,15 +28,234 @@ type PhotoObject struct {
 	ObjectID          string     `gorm:"not null;unique"`
 	ContentType       string     `gorm:"not null"`
 	MD5Hash           string     `gorm:"not null"`
+	SizeBytes         int64      `gorm:"not null;default:0"`
 	UserID            uint       `gorm:"not null"`
 	User              User       `gorm:"foreignKey:UserID"`
 	TimeTaken         *time.Time `gorm:""`
 	DurationSeconds   *float64   `gorm:""`
 	ThumbnailObjectID *string    `gorm:""`
 	WebpObjectID      *string    `gorm:""`
+	AvifObjectID      *string    `gorm:""`
+	ProxyObjectID     *string    `gorm:""`
+	HLSObjectID       *string    `gorm:""`
+	// AnimatedPreviewObjectID holds the animated WebP shown on hover over
+	// a video
+	AnimatedPreviewObjectID *string `gorm:""`
+	// ContentIdentifier is the Apple ContentIdentifier shared by the still
+	// and the video of an iPhone Live Photo
+	ContentIdentifier string `gorm:"index"`
+	// CompanionObjectID holds the video of a Live Photo still. The video is
+	// a PhotoObject of its own, which is hidden from listings and is moved,
+	// copied and deleted with the still.
+	CompanionObjectID *string `gorm:"index"`
+	// MotionVideoObjectID holds the video extracted from an Android motion
+	// photo
+	MotionVideoObjectID *string `gorm:""`
+	// Camera is the make and model of the camera, which bursts are stacked
+	// by
+	Camera string `gorm:""`
+	// StackID is the PhotoStack the photo belongs to, if any
+	StackID *uint `gorm:"index"`
+	// Unstacked is set once the user has taken the photo out of its stack,
+	// so that it is not stacked again automatically
+	Unstacked bool `gorm:"not null;default:false"`
 }
 
 type PhotoDirectory struct {
 	gorm.Model
 	Path string `gorm:"not null;unique"`
 }
+
+// PhotoSidecar is an XMP sidecar (e.g. from Lightroom) attached to a photo.
+// PhotoObjectID holds the object ID of the photo the sidecar belongs to and
+// is empty while the sidecar has been uploaded without its photo.
+type PhotoSidecar struct {
+	gorm.Model
+	ObjectID      string `gorm:"not null;unique"`
+	PhotoObjectID string `gorm:"index"`
+	MD5Hash       string `gorm:"not null"`
+	SizeBytes     int64  `gorm:"not null;default:0"`
+	UserID        uint   `gorm:"not null"`
+	User          User   `gorm:"foreignKey:UserID"`
+	Rating        *int   `gorm:""`
+	Label         string `gorm:""`
+	// Keywords is a comma-separated list, as Lightroom does not allow commas
+	// within a keyword
+	Keywords   string  `gorm:""`
+	Caption    string  `gorm:""`
+	HasCrop    bool    `gorm:"not null;default:false"`
+	CropTop    float64 `gorm:""`
+	CropLeft   float64 `gorm:""`
+	CropBottom float64 `gorm:""`
+	CropRight  float64 `gorm:""`
+	CropAngle  float64 `gorm:""`
+}
+
+// PhotoRendition is a pre-generated, fixed-size thumbnail of a photo.
+// PhotoObjectID holds the object ID of the photo it was generated from and
+// LongEdge the configured size it was generated for; Width and Height are
+// its actual dimensions, which are smaller when the photo itself is.
+type PhotoRendition struct {
+	gorm.Model
+	ObjectID      string `gorm:"not null;unique"`
+	PhotoObjectID string `gorm:"not null;index"`
+	UserID        uint   `gorm:"not null"`
+	User          User   `gorm:"foreignKey:UserID"`
+	LongEdge      int    `gorm:"not null"`
+	Width         int    `gorm:"not null"`
+	Height        int    `gorm:"not null"`
+	ContentType   string `gorm:"not null"`
+	SizeBytes     int64  `gorm:"not null;default:0"`
+}
+
+// Kinds of DerivedObject.
+const (
+	DerivedKindWebP      = "webp"
+	DerivedKindAVIF      = "avif"
+	DerivedKindProxy     = "proxy"
+	DerivedKindHLS       = "hls"
+	DerivedKindPreview   = "preview"
+	DerivedKindThumbnail = "thumbnail"
+	DerivedKindRendition = "rendition"
+	// DerivedKindAnimatedPreview is the animated WebP of a video
+	DerivedKindAnimatedPreview = "animated_preview"
+	// DerivedKindMotionVideo is the video embedded in an Android motion photo
+	DerivedKindMotionVideo = "motion_video"
+)
+
+// DerivedObject records that an object in the bucket was generated from an
+// original rather than uploaded: a WebP or AVIF rendition, a JPEG preview, a
+// video thumbnail or animated preview, an MP4 proxy or HLS playlist or segment
+// of a video, the video of a motion photo, or a fixed-size thumbnail.
+// SourceObjectID holds the object ID of the original.
+type DerivedObject struct {
+	gorm.Model
+	ObjectID       string `gorm:"not null;unique"`
+	SourceObjectID string `gorm:"not null;index"`
+	Kind           string `gorm:"not null"`
+	UserID         uint   `gorm:"not null"`
+	User           User   `gorm:"foreignKey:UserID"`
+	SizeBytes      int64  `gorm:"not null;default:0"`
+}
+
+// Kinds of PhotoStack.
+const (
+	StackKindRawJpeg = "raw_jpeg"
+	StackKindBurst   = "burst"
+)
+
+// PhotoStack groups photos that are listed as one: the RAW and JPEG files of
+// the same shot, or the frames of a burst. Its photos refer to it by StackID
+// and CoverObjectID holds the photo listed in their place.
+type PhotoStack struct {
+	gorm.Model
+	Kind          string `gorm:"not null"`
+	CoverObjectID string `gorm:"not null;index"`
+	UserID        uint   `gorm:"not null"`
+	User          User   `gorm:"foreignKey:UserID"`
+}
+
+// Kinds of Job.
+const (
+	// JobKindDerivedAssets generates the previews, WebP and AVIF renditions,
+	// video posters, motion photo videos and thumbnails of an upload
+	JobKindDerivedAssets = "derived_assets"
+	// JobKindTranscode transcodes a video to an MP4 proxy and HLS
+	JobKindTranscode = "transcode"
+)
+
+// Statuses of Job.
+const (
+	JobStatusPending   = "pending"
+	JobStatusRunning   = "running"
+	JobStatusSucceeded = "succeeded"
+	JobStatusFailed    = "failed"
+)
+
+// Job is a unit of background work on a photo, run by the job queue of the
+// server. A pending job runs once RunAfter has passed; a job that fails is
+// retried with backoff until it has made MaxAttempts attempts, after which it
+// is failed until retried by the user. LastError holds the error of the last
+// failed attempt.
+type Job struct {
+	gorm.Model
+	Kind        string     `gorm:"not null"`
+	ObjectID    string     `gorm:"not null;index"`
+	UserID      uint       `gorm:"not null"`
+	User        User       `gorm:"foreignKey:UserID"`
+	Status      string     `gorm:"not null;index"`
+	Attempts    int        `gorm:"not null;default:0"`
+	MaxAttempts int        `gorm:"not null"`
+	RunAfter    time.Time  `gorm:"not null;index"`
+	LastError   string     `gorm:""`
+	StartedAt   *time.Time `gorm:""`
+	FinishedAt  *time.Time `gorm:""`
+}
+
+// Statuses of ScheduledRun.
+const (
+	ScheduledRunStatusRunning   = "running"
+	ScheduledRunStatusSucceeded = "succeeded"
+	ScheduledRunStatusFailed    = "failed"
+)
+
+// ScheduledRun is a run of a scheduled maintenance task, such as a sync, for
+// a user. Summary describes what the run did and Error why it failed.
+type ScheduledRun struct {
+	gorm.Model
+	Task       string     `gorm:"not null;index"`
+	UserID     uint       `gorm:"not null"`
+	User       User       `gorm:"foreignKey:UserID"`
+	Status     string     `gorm:"not null"`
+	StartedAt  time.Time  `gorm:"not null"`
+	FinishedAt *time.Time `gorm:""`
+	Summary    string     `gorm:""`
+	Error      string     `gorm:""`
+}
+
+// OperationLock is held while a long-running library operation, such as a
+// sync, runs for a user, so that only one runs at a time. Its holder renews
+// LeaseExpiresAt with a heartbeat while the operation runs; a lock whose
+// lease has expired was left by a holder that crashed and is taken over by
+// the next operation. Token identifies the operation holding the lock and
+// Holder the host and process running it.
+type OperationLock struct {
+	gorm.Model
+	UserID         uint      `gorm:"not null;uniqueIndex"`
+	User           User      `gorm:"foreignKey:UserID"`
+	Operation      string    `gorm:"not null"`
+	Token          string    `gorm:"not null"`
+	Holder         string    `gorm:""`
+	HeartbeatAt    time.Time `gorm:"not null"`
+	LeaseExpiresAt time.Time `gorm:"not null"`
+}
+
+// SyncCursor is the high-water mark of the last sync of a user: the update
+// time and generation of the most recently changed object examined. An
+// incremental sync only examines the objects changed after it.
+type SyncCursor struct {
+	gorm.Model
+	UserID           uint      `gorm:"not null;uniqueIndex"`
+	User             User      `gorm:"foreignKey:UserID"`
+	ObjectUpdated    time.Time `gorm:"not null"`
+	ObjectGeneration int64     `gorm:"not null"`
+}
+
+// Kinds of PhotoChange.
+const (
+	ChangeKindCreated = "created"
+	ChangeKindUpdated = "updated"
+	ChangeKindDeleted = "deleted"
+)
+
+// PhotoChange is an entry of the change log of the photos of a user, written
+// whenever a photo is created, updated or deleted so that clients can keep
+// an index of the photos up to date. Entries are ordered by ID, which only
+// increases.
+type PhotoChange struct {
+	gorm.Model
+	UserID   uint   `gorm:"not null;index"`
+	User     User   `gorm:"foreignKey:UserID"`
+	ObjectID string `gorm:"not null"`
+	Kind     string `gorm:"not null"`
+}
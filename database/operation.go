@@ -1,6 +1,8 @@
 package database
 
 import (
+	"time"
+
 	"gorm.io/gorm"
 )
 
@@ -10,10 +12,48 @@ func AutoMigrate(db *gorm.DB) error {
 		&TailscaleAddress{},
 		&PhotoObject{},
 		&PhotoDirectory{},
+		&PhotoSidecar{},
+		&PhotoRendition{},
+		&DerivedObject{},
+		&PhotoStack{},
+		&Job{},
+		&ScheduledRun{},
+		&OperationLock{},
+		&SyncCursor{},
+		&PhotoChange{},
 	); err != nil {
 		return err
 	}
 
+	return BackfillDerivedObjects(db)
+}
+
+// BackfillDerivedObjects records the derived assets referenced by photos and
+// renditions that have no DerivedObject row yet, such as those generated
+// before derived assets were recorded. It is safe to run repeatedly.
+func BackfillDerivedObjects(db *gorm.DB) error {
+	now := time.Now()
+	statements := []string{
+		`INSERT INTO derived_objects (created_at, updated_at, object_id, source_object_id, kind, user_id)
+		SELECT ?, ?, webp_object_id, object_id, '` + DerivedKindWebP + `', user_id FROM photo_objects
+		WHERE deleted_at IS NULL AND webp_object_id IS NOT NULL AND webp_object_id != ''
+		AND webp_object_id NOT IN (SELECT object_id FROM derived_objects)`,
+		`INSERT INTO derived_objects (created_at, updated_at, object_id, source_object_id, kind, user_id)
+		SELECT ?, ?, thumbnail_object_id, object_id,
+		CASE WHEN content_type LIKE 'video/%' THEN '` + DerivedKindThumbnail + `' ELSE '` + DerivedKindPreview + `' END,
+		user_id FROM photo_objects
+		WHERE deleted_at IS NULL AND thumbnail_object_id IS NOT NULL AND thumbnail_object_id != ''
+		AND thumbnail_object_id NOT IN (SELECT object_id FROM derived_objects)`,
+		`INSERT INTO derived_objects (created_at, updated_at, object_id, source_object_id, kind, user_id)
+		SELECT ?, ?, object_id, photo_object_id, '` + DerivedKindRendition + `', user_id FROM photo_renditions
+		WHERE deleted_at IS NULL
+		AND object_id NOT IN (SELECT object_id FROM derived_objects)`,
+	}
+	for _, statement := range statements {
+		if err := db.Exec(statement, now, now).Error; err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -29,8 +69,13 @@ func CreateOrRestorePhotoObject(db *gorm.DB, photoObject *PhotoObject) error {
 		existing.DeletedAt = gorm.DeletedAt{}
 		existing.ContentType = photoObject.ContentType
 		existing.MD5Hash = photoObject.MD5Hash
+		existing.SizeBytes = photoObject.SizeBytes
 		existing.UserID = photoObject.UserID
 		existing.TimeTaken = photoObject.TimeTaken
+		existing.ContentIdentifier = photoObject.ContentIdentifier
+		existing.Camera = photoObject.Camera
+		existing.StackID = photoObject.StackID
+		existing.Unstacked = photoObject.Unstacked
 		return db.Unscoped().Save(&existing).Error
 	}
 
@@ -72,3 +117,97 @@ func CreateOrRestorePhotoDirectory(db *gorm.DB, path string) error {
 
 	return result.Error
 }
+
+// CreateOrRestorePhotoSidecar creates a new PhotoSidecar or restores a soft-deleted one.
+// If a record (possibly soft-deleted) with the same ObjectID exists, it will be restored
+// and updated with the new values. Otherwise, a new record will be created.
+func CreateOrRestorePhotoSidecar(db *gorm.DB, sidecar *PhotoSidecar) error {
+	var existing PhotoSidecar
+	result := db.Unscoped().Where("object_id = ?", sidecar.ObjectID).First(&existing)
+
+	if result.Error == nil {
+		existing.DeletedAt = gorm.DeletedAt{}
+		existing.PhotoObjectID = sidecar.PhotoObjectID
+		existing.MD5Hash = sidecar.MD5Hash
+		existing.SizeBytes = sidecar.SizeBytes
+		existing.UserID = sidecar.UserID
+		existing.Rating = sidecar.Rating
+		existing.Label = sidecar.Label
+		existing.Keywords = sidecar.Keywords
+		existing.Caption = sidecar.Caption
+		existing.HasCrop = sidecar.HasCrop
+		existing.CropTop = sidecar.CropTop
+		existing.CropLeft = sidecar.CropLeft
+		existing.CropBottom = sidecar.CropBottom
+		existing.CropRight = sidecar.CropRight
+		existing.CropAngle = sidecar.CropAngle
+		if err := db.Unscoped().Save(&existing).Error; err != nil {
+			return err
+		}
+		sidecar.ID = existing.ID
+		return nil
+	}
+
+	if result.Error == gorm.ErrRecordNotFound {
+		return db.Create(sidecar).Error
+	}
+
+	return result.Error
+}
+
+// CreateOrRestorePhotoRendition creates a new PhotoRendition or restores a soft-deleted one.
+// If a record (possibly soft-deleted) with the same ObjectID exists, it will be restored
+// and updated with the new values. Otherwise, a new record will be created.
+func CreateOrRestorePhotoRendition(db *gorm.DB, rendition *PhotoRendition) error {
+	var existing PhotoRendition
+	result := db.Unscoped().Where("object_id = ?", rendition.ObjectID).First(&existing)
+
+	if result.Error == nil {
+		existing.DeletedAt = gorm.DeletedAt{}
+		existing.PhotoObjectID = rendition.PhotoObjectID
+		existing.UserID = rendition.UserID
+		existing.LongEdge = rendition.LongEdge
+		existing.Width = rendition.Width
+		existing.Height = rendition.Height
+		existing.ContentType = rendition.ContentType
+		existing.SizeBytes = rendition.SizeBytes
+		if err := db.Unscoped().Save(&existing).Error; err != nil {
+			return err
+		}
+		rendition.ID = existing.ID
+		return nil
+	}
+
+	if result.Error == gorm.ErrRecordNotFound {
+		return db.Create(rendition).Error
+	}
+
+	return result.Error
+}
+
+// CreateOrRestoreDerivedObject creates a new DerivedObject or restores a soft-deleted one.
+// If a record (possibly soft-deleted) with the same ObjectID exists, it will be restored
+// and updated with the new values. Otherwise, a new record will be created.
+func CreateOrRestoreDerivedObject(db *gorm.DB, derived *DerivedObject) error {
+	var existing DerivedObject
+	result := db.Unscoped().Where("object_id = ?", derived.ObjectID).First(&existing)
+
+	if result.Error == nil {
+		existing.DeletedAt = gorm.DeletedAt{}
+		existing.SourceObjectID = derived.SourceObjectID
+		existing.Kind = derived.Kind
+		existing.UserID = derived.UserID
+		existing.SizeBytes = derived.SizeBytes
+		if err := db.Unscoped().Save(&existing).Error; err != nil {
+			return err
+		}
+		derived.ID = existing.ID
+		return nil
+	}
+
+	if result.Error == gorm.ErrRecordNotFound {
+		return db.Create(derived).Error
+	}
+
+	return result.Error
+}
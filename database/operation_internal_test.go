@@ -1661,3 +1661,182 @@ func TestSyncScenario_RemoveDerivedObject_DirectoryKeptWhenOthersRemain(t *testi
 		t.Errorf("expected directory to remain (other photos present), but count=%d", dirCount)
 	}
 }
+
+func TestCreateOrRestorePhotoSidecar_NewSidecar(t *testing.T) {
+	db := setupTestDB(t)
+
+	rating := 4
+	sidecar := &PhotoSidecar{
+		ObjectID:      "photos/IMG_001.xmp",
+		PhotoObjectID: "photos/IMG_001.dng",
+		MD5Hash:       "abc123",
+		UserID:        1,
+		Rating:        &rating,
+		Keywords:      "family,beach",
+	}
+	if err := CreateOrRestorePhotoSidecar(db, sidecar); err != nil {
+		t.Fatalf("CreateOrRestorePhotoSidecar returned error: %v", err)
+	}
+
+	var stored PhotoSidecar
+	if err := db.Where("object_id = ?", "photos/IMG_001.xmp").First(&stored).Error; err != nil {
+		t.Fatalf("failed to find sidecar: %v", err)
+	}
+	if stored.PhotoObjectID != "photos/IMG_001.dng" {
+		t.Errorf("expected photo object ID %q, got %q", "photos/IMG_001.dng", stored.PhotoObjectID)
+	}
+	if stored.Rating == nil || *stored.Rating != 4 {
+		t.Errorf("expected rating 4, got %v", stored.Rating)
+	}
+}
+
+func TestCreateOrRestorePhotoSidecar_RestoreSoftDeleted(t *testing.T) {
+	db := setupTestDB(t)
+
+	original := &PhotoSidecar{ObjectID: "IMG_001.xmp", MD5Hash: "old", UserID: 1, Caption: "old caption"}
+	if err := db.Create(original).Error; err != nil {
+		t.Fatalf("failed to create sidecar: %v", err)
+	}
+	if err := db.Delete(original).Error; err != nil {
+		t.Fatalf("failed to soft delete sidecar: %v", err)
+	}
+
+	updated := &PhotoSidecar{ObjectID: "IMG_001.xmp", PhotoObjectID: "IMG_001.dng", MD5Hash: "new", UserID: 1, Caption: "new caption"}
+	if err := CreateOrRestorePhotoSidecar(db, updated); err != nil {
+		t.Fatalf("CreateOrRestorePhotoSidecar returned error: %v", err)
+	}
+
+	var sidecars []PhotoSidecar
+	if err := db.Unscoped().Where("object_id = ?", "IMG_001.xmp").Find(&sidecars).Error; err != nil {
+		t.Fatalf("failed to query sidecars: %v", err)
+	}
+	if len(sidecars) != 1 {
+		t.Fatalf("expected 1 sidecar record, got %d", len(sidecars))
+	}
+	if sidecars[0].DeletedAt.Valid {
+		t.Error("expected sidecar to be restored")
+	}
+	if sidecars[0].MD5Hash != "new" || sidecars[0].Caption != "new caption" {
+		t.Errorf("expected updated fields, got md5=%q caption=%q", sidecars[0].MD5Hash, sidecars[0].Caption)
+	}
+	if updated.ID != original.ID {
+		t.Errorf("expected ID %d to be reused, got %d", original.ID, updated.ID)
+	}
+}
+
+func TestCreateOrRestorePhotoRendition_RestoreSoftDeleted(t *testing.T) {
+	db := setupTestDB(t)
+
+	original := &PhotoRendition{ObjectID: "IMG_001_256px.jpg", PhotoObjectID: "IMG_001.jpg", UserID: 1, LongEdge: 256, Width: 256, Height: 192, ContentType: "image/jpeg", SizeBytes: 100}
+	if err := db.Create(original).Error; err != nil {
+		t.Fatalf("failed to create rendition: %v", err)
+	}
+	if err := db.Delete(original).Error; err != nil {
+		t.Fatalf("failed to soft delete rendition: %v", err)
+	}
+
+	updated := &PhotoRendition{ObjectID: "IMG_001_256px.jpg", PhotoObjectID: "IMG_001.jpg", UserID: 1, LongEdge: 256, Width: 192, Height: 256, ContentType: "image/jpeg", SizeBytes: 200}
+	if err := CreateOrRestorePhotoRendition(db, updated); err != nil {
+		t.Fatalf("CreateOrRestorePhotoRendition returned error: %v", err)
+	}
+
+	var renditions []PhotoRendition
+	if err := db.Unscoped().Where("object_id = ?", "IMG_001_256px.jpg").Find(&renditions).Error; err != nil {
+		t.Fatalf("failed to query renditions: %v", err)
+	}
+	if len(renditions) != 1 {
+		t.Fatalf("expected 1 rendition record, got %d", len(renditions))
+	}
+	if renditions[0].DeletedAt.Valid {
+		t.Error("expected rendition to be restored")
+	}
+	if renditions[0].Width != 192 || renditions[0].Height != 256 || renditions[0].SizeBytes != 200 {
+		t.Errorf("expected updated fields, got %dx%d size=%d", renditions[0].Width, renditions[0].Height, renditions[0].SizeBytes)
+	}
+	if updated.ID != original.ID {
+		t.Errorf("expected ID %d to be reused, got %d", original.ID, updated.ID)
+	}
+}
+
+func TestBackfillDerivedObjects(t *testing.T) {
+	db := setupTestDB(t)
+
+	webp := "IMG_001.webp"
+	preview := "IMG_002_preview.jpg"
+	thumb := "clip_thumb.jpg"
+	photos := []PhotoObject{
+		{ObjectID: "IMG_001.jpg", ContentType: "image/jpeg", MD5Hash: "a", UserID: 1, WebpObjectID: &webp},
+		{ObjectID: "IMG_002.dng", ContentType: "image/x-adobe-dng", MD5Hash: "b", UserID: 1, ThumbnailObjectID: &preview},
+		{ObjectID: "clip.mp4", ContentType: "video/mp4", MD5Hash: "c", UserID: 2, ThumbnailObjectID: &thumb},
+		// A WebP uploaded by the user is an original, not a derived asset
+		{ObjectID: "upload.webp", ContentType: "image/webp", MD5Hash: "d", UserID: 1},
+	}
+	if err := db.Create(&photos).Error; err != nil {
+		t.Fatalf("failed to create photos: %v", err)
+	}
+	rendition := &PhotoRendition{ObjectID: "IMG_001_256px.jpg", PhotoObjectID: "IMG_001.jpg", UserID: 1, LongEdge: 256, ContentType: "image/jpeg"}
+	if err := db.Create(rendition).Error; err != nil {
+		t.Fatalf("failed to create rendition: %v", err)
+	}
+
+	// Running twice must not create duplicates
+	for range 2 {
+		if err := BackfillDerivedObjects(db); err != nil {
+			t.Fatalf("BackfillDerivedObjects returned error: %v", err)
+		}
+	}
+
+	var derived []DerivedObject
+	if err := db.Order("object_id ASC").Find(&derived).Error; err != nil {
+		t.Fatalf("failed to query derived objects: %v", err)
+	}
+	expected := []DerivedObject{
+		{ObjectID: "IMG_001.webp", SourceObjectID: "IMG_001.jpg", Kind: DerivedKindWebP, UserID: 1},
+		{ObjectID: "IMG_001_256px.jpg", SourceObjectID: "IMG_001.jpg", Kind: DerivedKindRendition, UserID: 1},
+		{ObjectID: "IMG_002_preview.jpg", SourceObjectID: "IMG_002.dng", Kind: DerivedKindPreview, UserID: 1},
+		{ObjectID: "clip_thumb.jpg", SourceObjectID: "clip.mp4", Kind: DerivedKindThumbnail, UserID: 2},
+	}
+	if len(derived) != len(expected) {
+		t.Fatalf("expected %d derived objects, got %d", len(expected), len(derived))
+	}
+	for i, want := range expected {
+		got := derived[i]
+		if got.ObjectID != want.ObjectID || got.SourceObjectID != want.SourceObjectID || got.Kind != want.Kind || got.UserID != want.UserID {
+			t.Errorf("derived object %d = %+v, want %+v", i, got, want)
+		}
+	}
+}
+
+func TestCreateOrRestoreDerivedObject_RestoreSoftDeleted(t *testing.T) {
+	db := setupTestDB(t)
+
+	original := &DerivedObject{ObjectID: "IMG_001.webp", SourceObjectID: "IMG_001.jpg", Kind: DerivedKindWebP, UserID: 1}
+	if err := db.Create(original).Error; err != nil {
+		t.Fatalf("failed to create derived object: %v", err)
+	}
+	if err := db.Delete(original).Error; err != nil {
+		t.Fatalf("failed to soft delete derived object: %v", err)
+	}
+
+	updated := &DerivedObject{ObjectID: "IMG_001.webp", SourceObjectID: "IMG_001.png", Kind: DerivedKindWebP, UserID: 1}
+	if err := CreateOrRestoreDerivedObject(db, updated); err != nil {
+		t.Fatalf("CreateOrRestoreDerivedObject returned error: %v", err)
+	}
+
+	var derived []DerivedObject
+	if err := db.Unscoped().Where("object_id = ?", "IMG_001.webp").Find(&derived).Error; err != nil {
+		t.Fatalf("failed to query derived objects: %v", err)
+	}
+	if len(derived) != 1 {
+		t.Fatalf("expected 1 derived object record, got %d", len(derived))
+	}
+	if derived[0].DeletedAt.Valid {
+		t.Error("expected derived object to be restored")
+	}
+	if derived[0].SourceObjectID != "IMG_001.png" {
+		t.Errorf("expected source to be updated, got %q", derived[0].SourceObjectID)
+	}
+	if updated.ID != original.ID {
+		t.Errorf("expected ID %d to be reused, got %d", original.ID, updated.ID)
+	}
+}
@@ -0,0 +1,393 @@
+package internal
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/alexhokl/photos/database"
+	"github.com/alexhokl/photos/proto"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// archiveChunkSize is the size of the chunks a ZIP archive is streamed in.
+const archiveChunkSize = 64 * 1024
+
+// archiveIndexName is the name of the markdown index in a directory and at
+// the root of an archive.
+const archiveIndexName = "index.md"
+
+// archiveChunkWriter sends everything written to it as DownloadArchiveResponse
+// chunks.
+type archiveChunkWriter struct {
+	stream grpc.ServerStreamingServer[proto.DownloadArchiveResponse]
+}
+
+func (w *archiveChunkWriter) Write(p []byte) (int, error) {
+	if err := w.stream.Send(&proto.DownloadArchiveResponse{Chunk: p}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// archiveEntry is a file to be added to an archive.
+type archiveEntry struct {
+	objectID string
+	name     string
+	modified time.Time
+	// stripLocation removes GPS location from the file's EXIF
+	stripLocation bool
+}
+
+// DownloadArchive streams a ZIP archive of every photo under a prefix,
+// including sub-directories. The archive is written as it is produced, so
+// nothing is staged on disk or held in memory beyond a single photo (and
+// only when its location has to be stripped).
+//
+// Each photo's XMP sidecar is included, and with include_derived its derived
+// assets (WebP and AVIF renditions, previews, thumbnails, video proxies and
+// HLS streams). With strip_location, the location is removed from JPEG and
+// HEIC files, and XMP sidecars, the videos of Live Photos and motion photos
+// and derived videos, which commonly carry it too, are left out. index.md
+// files under the prefix are included, and if there is none at the prefix
+// itself one listing the archived photos is generated.
+func (s *BytesServer) DownloadArchive(req *proto.DownloadArchiveRequest, stream grpc.ServerStreamingServer[proto.DownloadArchiveResponse]) error {
+	ctx := stream.Context()
+	userID, ok := ctx.Value(contextKeyUser{}).(uint)
+	if !ok {
+		return status.Errorf(codes.Unauthenticated, "authentication required")
+	}
+
+	prefix := strings.Trim(req.GetPrefix(), "/")
+
+	photoObjects, err := s.listArchivePhotos(ctx, userID, prefix)
+	if err != nil {
+		return err
+	}
+	if len(photoObjects) == 0 {
+		return status.Errorf(codes.NotFound, "no photos found under prefix: %s", prefix)
+	}
+
+	ids := make([]string, len(photoObjects))
+	for i, photoObject := range photoObjects {
+		ids[i] = photoObject.ObjectID
+	}
+	sidecars, err := getPhotoSidecars(s.DB, userID, ids)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to list sidecars: %v", err)
+	}
+
+	var derived map[string][]database.DerivedObject
+	if req.GetIncludeDerived() {
+		derived, err = getDerivedObjects(s.DB, userID, ids)
+		if err != nil {
+			return status.Errorf(codes.Internal, "failed to list derived assets: %v", err)
+		}
+	}
+
+	entries := buildArchiveEntries(prefix, photoObjects, sidecars, derived, req.GetStripLocation())
+
+	slog.InfoContext(
+		ctx,
+		"Starting archive download",
+		slog.String("prefix", prefix),
+		slog.Int("photos", len(photoObjects)),
+		slog.Int("entries", len(entries)),
+		slog.Bool("include_derived", req.GetIncludeDerived()),
+		slog.Bool("strip_location", req.GetStripLocation()),
+	)
+
+	bucket := s.GCSClient.Bucket(s.BucketName)
+	indexEntries, err := listArchiveIndexes(ctx, bucket, prefix)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to list index files: %v", err)
+	}
+
+	buffered := bufio.NewWriterSize(&archiveChunkWriter{stream: stream}, archiveChunkSize)
+	zw := zip.NewWriter(buffered)
+
+	var written int
+	for _, entry := range entries {
+		if err := writeArchiveEntry(ctx, zw, bucket, entry); err != nil {
+			if errors.Is(err, storage.ErrObjectNotExist) {
+				slog.WarnContext(ctx, "Skipping archive entry missing from storage",
+					slog.String("object_id", entry.objectID),
+				)
+				continue
+			}
+			return status.Errorf(codes.Internal, "failed to add %s to archive: %v", entry.objectID, err)
+		}
+		written++
+	}
+
+	hasRootIndex := false
+	for _, entry := range indexEntries {
+		if err := writeArchiveEntry(ctx, zw, bucket, entry); err != nil {
+			return status.Errorf(codes.Internal, "failed to add %s to archive: %v", entry.objectID, err)
+		}
+		hasRootIndex = hasRootIndex || entry.name == archiveIndexName
+	}
+	if !hasRootIndex {
+		if err := writeGeneratedArchiveIndex(zw, prefix, photoObjects); err != nil {
+			return status.Errorf(codes.Internal, "failed to add index to archive: %v", err)
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return status.Errorf(codes.Internal, "failed to finish archive: %v", err)
+	}
+	if err := buffered.Flush(); err != nil {
+		return status.Errorf(codes.Internal, "failed to send archive: %v", err)
+	}
+
+	slog.InfoContext(
+		ctx,
+		"Completed archive download",
+		slog.String("prefix", prefix),
+		slog.Int("entries", written),
+	)
+
+	return nil
+}
+
+// listArchivePhotos returns the user's photos under prefix, including those
+// in sub-directories, ordered by object ID.
+func (s *BytesServer) listArchivePhotos(ctx context.Context, userID uint, prefix string) ([]database.PhotoObject, error) {
+	query := s.DB.Where("user_id = ?", userID).Where("object_id NOT LIKE ?", "%.md")
+	if prefix != "" {
+		query = query.Where("object_id LIKE ?", prefix+"/%")
+	}
+
+	var photoObjects []database.PhotoObject
+	_, dbSpan := startSpan(ctx, "db.list_archive_photos")
+	if err := query.Order("object_id").Find(&photoObjects).Error; err != nil {
+		recordSpanError(dbSpan, err)
+		return nil, status.Errorf(codes.Internal, "failed to list photos: %v", err)
+	}
+	endSpanOk(dbSpan)
+
+	// LIKE treats "_" in the prefix as a wildcard, so check the prefix exactly
+	if prefix != "" {
+		filtered := photoObjects[:0]
+		for _, photoObject := range photoObjects {
+			if strings.HasPrefix(photoObject.ObjectID, prefix+"/") {
+				filtered = append(filtered, photoObject)
+			}
+		}
+		photoObjects = filtered
+	}
+	return photoObjects, nil
+}
+
+// archiveEntryName returns the path of objectID inside an archive of prefix.
+func archiveEntryName(prefix, objectID string) string {
+	if prefix == "" {
+		return objectID
+	}
+	return strings.TrimPrefix(objectID, prefix+"/")
+}
+
+// buildArchiveEntries lists the files to archive for photoObjects: each
+// photo, its sidecar and, if derived is not nil, its derived assets, both
+// those recorded in derived and those the photo references. Location is
+// only stripped from JPEG and HEIC files, so if stripLocation is set the
+// sidecars, the videos of Live Photos and motion photos and the derived
+// videos are left out.
+func buildArchiveEntries(prefix string, photoObjects []database.PhotoObject, sidecars map[string]*database.PhotoSidecar, derived map[string][]database.DerivedObject, stripLocation bool) []archiveEntry {
+	var entries []archiveEntry
+	seen := make(map[string]bool)
+	if stripLocation {
+		for _, photoObject := range photoObjects {
+			for _, video := range []*string{photoObject.CompanionObjectID, photoObject.MotionVideoObjectID} {
+				if video != nil {
+					seen[*video] = true
+				}
+			}
+		}
+	}
+	add := func(objectID string, modified time.Time, strip bool) {
+		if objectID == "" || seen[objectID] {
+			return
+		}
+		seen[objectID] = true
+		entries = append(entries, archiveEntry{
+			objectID:      objectID,
+			name:          archiveEntryName(prefix, objectID),
+			modified:      modified,
+			stripLocation: strip,
+		})
+	}
+
+	for _, photoObject := range photoObjects {
+		modified := photoObject.UpdatedAt
+		if photoObject.TimeTaken != nil {
+			modified = *photoObject.TimeTaken
+		}
+		add(photoObject.ObjectID, modified, stripLocation)
+		if sidecar, ok := sidecars[photoObject.ObjectID]; ok && !stripLocation {
+			add(sidecar.ObjectID, sidecar.UpdatedAt, false)
+		}
+		if derived != nil {
+			if photoObject.WebpObjectID != nil {
+				add(*photoObject.WebpObjectID, modified, stripLocation)
+			}
+			if photoObject.ThumbnailObjectID != nil {
+				add(*photoObject.ThumbnailObjectID, modified, stripLocation)
+			}
+			for _, d := range derived[photoObject.ObjectID] {
+				switch d.Kind {
+				case database.DerivedKindProxy, database.DerivedKindHLS, database.DerivedKindMotionVideo:
+					if stripLocation {
+						continue
+					}
+				}
+				add(d.ObjectID, d.UpdatedAt, stripLocation)
+			}
+		}
+	}
+	return entries
+}
+
+// listArchiveIndexes returns an entry for every index.md under prefix.
+func listArchiveIndexes(ctx context.Context, bucket *storage.BucketHandle, prefix string) ([]archiveEntry, error) {
+	query := &storage.Query{}
+	if prefix != "" {
+		query.Prefix = prefix + "/"
+	}
+	if err := query.SetAttrSelection([]string{"Name", "Updated"}); err != nil {
+		return nil, err
+	}
+
+	_, listSpan := startSpan(ctx, "gcs.list_index_objects")
+	var entries []archiveEntry
+	it := bucket.Objects(ctx, query)
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			recordSpanError(listSpan, err)
+			return nil, err
+		}
+		if path.Base(attrs.Name) != archiveIndexName {
+			continue
+		}
+		entries = append(entries, archiveEntry{
+			objectID: attrs.Name,
+			name:     archiveEntryName(prefix, attrs.Name),
+			modified: attrs.Updated,
+		})
+	}
+	endSpanOk(listSpan)
+	return entries, nil
+}
+
+// writeArchiveEntry copies an object from GCS into the archive. Media is
+// already compressed, so entries are stored rather than deflated.
+func writeArchiveEntry(ctx context.Context, zw *zip.Writer, bucket *storage.BucketHandle, entry archiveEntry) error {
+	_, readSpan := startSpan(ctx, "gcs.read_object")
+	reader, err := bucket.Object(entry.objectID).NewReader(ctx)
+	if err != nil {
+		recordSpanError(readSpan, err)
+		return err
+	}
+	defer func() { _ = reader.Close() }()
+
+	var src io.Reader = reader
+	if entry.stripLocation && canStripLocation(reader.Attrs.ContentType) {
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			recordSpanError(readSpan, err)
+			return err
+		}
+		stripped, err := StripLocationFromImage(data)
+		if err != nil {
+			slog.WarnContext(ctx, "Failed to strip location from archived image, adding original",
+				slog.String("object_id", entry.objectID),
+				slog.String("error", err.Error()),
+			)
+			stripped = data
+		}
+		src = bytes.NewReader(stripped)
+	}
+
+	method := zip.Store
+	if entry.name == archiveIndexName || strings.HasSuffix(entry.name, "/"+archiveIndexName) {
+		method = zip.Deflate
+	}
+	w, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     entry.name,
+		Method:   method,
+		Modified: entry.modified,
+	})
+	if err != nil {
+		recordSpanError(readSpan, err)
+		return err
+	}
+	if _, err := io.Copy(w, src); err != nil {
+		recordSpanError(readSpan, err)
+		return err
+	}
+	endSpanOk(readSpan)
+	return nil
+}
+
+// canStripLocation reports whether contentType is JPEG or HEIC, the only
+// formats StripLocationFromImage modifies.
+func canStripLocation(contentType string) bool {
+	switch strings.ToLower(contentType) {
+	case "image/jpeg", "image/jpg":
+		return true
+	}
+	return IsHEICContentType(contentType)
+}
+
+// writeGeneratedArchiveIndex adds an index.md listing photoObjects at the
+// root of the archive.
+func writeGeneratedArchiveIndex(zw *zip.Writer, prefix string, photoObjects []database.PhotoObject) error {
+	w, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     archiveIndexName,
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, generateArchiveIndex(prefix, photoObjects))
+	return err
+}
+
+// generateArchiveIndex renders a markdown index of photoObjects, with links
+// relative to the root of the archive.
+func generateArchiveIndex(prefix string, photoObjects []database.PhotoObject) string {
+	title := prefix
+	if title == "" {
+		title = "Photos"
+	}
+
+	var sb strings.Builder
+	sb.WriteString("---\n---\n")
+	fmt.Fprintf(&sb, "# %s\n\n", title)
+	for _, photoObject := range photoObjects {
+		name := archiveEntryName(prefix, photoObject.ObjectID)
+		fmt.Fprintf(&sb, "- [%s](<%s>)", name, name)
+		if photoObject.TimeTaken != nil {
+			fmt.Fprintf(&sb, " (%s)", photoObject.TimeTaken.Format(time.DateTime))
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
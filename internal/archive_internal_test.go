@@ -0,0 +1,202 @@
+package internal
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alexhokl/photos/database"
+	"github.com/alexhokl/photos/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+// mockDownloadArchiveStream implements
+// grpc.ServerStreamingServer[proto.DownloadArchiveResponse] for testing.
+type mockDownloadArchiveStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	sent []*proto.DownloadArchiveResponse
+}
+
+func (m *mockDownloadArchiveStream) Send(msg *proto.DownloadArchiveResponse) error {
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func (m *mockDownloadArchiveStream) Context() context.Context { return m.ctx }
+
+func TestArchiveEntryName(t *testing.T) {
+	tests := []struct {
+		prefix   string
+		objectID string
+		expected string
+	}{
+		{"", "2024/img.jpg", "2024/img.jpg"},
+		{"2024", "2024/img.jpg", "img.jpg"},
+		{"2024", "2024/trip/img.jpg", "trip/img.jpg"},
+		{"2024/trip", "2024/trip/img.jpg", "img.jpg"},
+	}
+	for _, tt := range tests {
+		if got := archiveEntryName(tt.prefix, tt.objectID); got != tt.expected {
+			t.Errorf("archiveEntryName(%q, %q) = %q, want %q", tt.prefix, tt.objectID, got, tt.expected)
+		}
+	}
+}
+
+func TestBuildArchiveEntries(t *testing.T) {
+	taken := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	webp := "2024/img.webp"
+	thumbnail := "2024/img_thumbnail.jpg"
+	photoObjects := []database.PhotoObject{
+		{ObjectID: "2024/img.jpg", TimeTaken: &taken, WebpObjectID: &webp, ThumbnailObjectID: &thumbnail},
+		{ObjectID: "2024/other.png"},
+	}
+	sidecars := map[string]*database.PhotoSidecar{
+		"2024/img.jpg": {ObjectID: "2024/img.xmp", PhotoObjectID: "2024/img.jpg"},
+	}
+
+	t.Run("without derived assets", func(t *testing.T) {
+		entries := buildArchiveEntries("2024", photoObjects, sidecars, nil, false)
+		names := archiveEntryNames(entries)
+		expected := []string{"img.jpg", "img.xmp", "other.png"}
+		if strings.Join(names, ",") != strings.Join(expected, ",") {
+			t.Fatalf("expected entries %v, got %v", expected, names)
+		}
+		if !entries[0].modified.Equal(taken) {
+			t.Errorf("expected modified time %v, got %v", taken, entries[0].modified)
+		}
+	})
+
+	t.Run("with derived assets", func(t *testing.T) {
+		derived := map[string][]database.DerivedObject{
+			"2024/img.jpg": {
+				{ObjectID: "2024/img.avif", Kind: database.DerivedKindAVIF},
+				{ObjectID: "2024/img.webp", Kind: database.DerivedKindWebP},
+				{ObjectID: "2024/img_800.webp", Kind: database.DerivedKindRendition},
+			},
+			"2024/other.png": {
+				{ObjectID: "2024/other_thumbnail.jpg", Kind: database.DerivedKindThumbnail},
+			},
+		}
+		entries := buildArchiveEntries("2024", photoObjects, sidecars, derived, false)
+		names := archiveEntryNames(entries)
+		expected := []string{"img.jpg", "img.xmp", "img.webp", "img_thumbnail.jpg", "img.avif", "img_800.webp", "other.png", "other_thumbnail.jpg"}
+		if strings.Join(names, ",") != strings.Join(expected, ",") {
+			t.Fatalf("expected entries %v, got %v", expected, names)
+		}
+		for _, entry := range entries {
+			if entry.stripLocation {
+				t.Errorf("expected no location stripping for %s", entry.name)
+			}
+		}
+	})
+
+	t.Run("stripping location", func(t *testing.T) {
+		companion := "2024/live.mov"
+		motionVideo := "2024/motion_video.mp4"
+		photoObjects := []database.PhotoObject{
+			{ObjectID: "2024/img.jpg", TimeTaken: &taken, WebpObjectID: &webp, ThumbnailObjectID: &thumbnail},
+			{ObjectID: "2024/live.heic", CompanionObjectID: &companion},
+			{ObjectID: companion},
+			{ObjectID: "2024/motion.jpg", MotionVideoObjectID: &motionVideo},
+		}
+		derived := map[string][]database.DerivedObject{
+			"2024/img.jpg": {
+				{ObjectID: "2024/img_proxy.mp4", Kind: database.DerivedKindProxy},
+				{ObjectID: "2024/img_hls/index.m3u8", Kind: database.DerivedKindHLS},
+			},
+			"2024/motion.jpg": {
+				{ObjectID: motionVideo, Kind: database.DerivedKindMotionVideo},
+			},
+		}
+		entries := buildArchiveEntries("2024", photoObjects, sidecars, derived, true)
+		names := archiveEntryNames(entries)
+		// Sidecars and videos would carry the location as stored
+		expected := []string{"img.jpg", "img.webp", "img_thumbnail.jpg", "live.heic", "motion.jpg"}
+		if strings.Join(names, ",") != strings.Join(expected, ",") {
+			t.Fatalf("expected entries %v, got %v", expected, names)
+		}
+		for _, entry := range entries {
+			if !entry.stripLocation {
+				t.Errorf("expected location to be stripped from %s", entry.name)
+			}
+		}
+	})
+}
+
+func archiveEntryNames(entries []archiveEntry) []string {
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.name
+	}
+	return names
+}
+
+func TestGenerateArchiveIndex(t *testing.T) {
+	taken := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	index := generateArchiveIndex("2024", []database.PhotoObject{
+		{ObjectID: "2024/img 1.jpg", TimeTaken: &taken},
+		{ObjectID: "2024/trip/other.png"},
+	})
+
+	for _, want := range []string{
+		"# 2024\n",
+		"- [img 1.jpg](<img 1.jpg>) (2024-05-01 10:00:00)\n",
+		"- [trip/other.png](<trip/other.png>)\n",
+	} {
+		if !strings.Contains(index, want) {
+			t.Errorf("expected index to contain %q, got:\n%s", want, index)
+		}
+	}
+
+	if root := generateArchiveIndex("", nil); !strings.Contains(root, "# Photos\n") {
+		t.Errorf("expected default title for the root, got:\n%s", root)
+	}
+}
+
+func TestListArchivePhotos(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	for _, photoObject := range []database.PhotoObject{
+		{ObjectID: "2024/a.jpg", UserID: 1},
+		{ObjectID: "2024/trip/b.jpg", UserID: 1},
+		{ObjectID: "2024/index.md", UserID: 1},
+		{ObjectID: "2024x/c.jpg", UserID: 1},
+		{ObjectID: "2024/d.jpg", UserID: 2},
+	} {
+		if err := db.Create(&photoObject).Error; err != nil {
+			t.Fatalf("failed to create photo object: %v", err)
+		}
+	}
+	server := &BytesServer{DB: db}
+
+	photoObjects, err := server.listArchivePhotos(context.Background(), 1, "2024")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var ids []string
+	for _, photoObject := range photoObjects {
+		ids = append(ids, photoObject.ObjectID)
+	}
+	if strings.Join(ids, ",") != "2024/a.jpg,2024/trip/b.jpg" {
+		t.Errorf("unexpected photos: %v", ids)
+	}
+}
+
+func TestDownloadArchive_Unauthenticated(t *testing.T) {
+	server := &BytesServer{}
+	err := server.DownloadArchive(&proto.DownloadArchiveRequest{Prefix: "2024"}, &mockDownloadArchiveStream{ctx: context.Background()})
+	assertGRPCError(t, err, codes.Unauthenticated)
+}
+
+func TestDownloadArchive_EmptyPrefix(t *testing.T) {
+	server := &BytesServer{DB: setupLibraryTestDB(t)}
+	stream := &mockDownloadArchiveStream{ctx: contextWithUserID(1)}
+
+	err := server.DownloadArchive(&proto.DownloadArchiveRequest{Prefix: "missing"}, stream)
+	assertGRPCError(t, err, codes.NotFound)
+	if len(stream.sent) != 0 {
+		t.Errorf("expected nothing to be sent, got %d chunks", len(stream.sent))
+	}
+}
@@ -0,0 +1,369 @@
+package internal
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/alexhokl/photos/database"
+	"github.com/alexhokl/photos/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// DefaultAVIFQuality is the lossy quality used by GenerateAVIF (1-100) when
+// none is given. AVIF keeps more detail than WebP at the same size, so a
+// lower quality than DefaultWebPQuality looks alike.
+const DefaultAVIFQuality = 60
+
+// IsAVIFConvertibleContentType returns true for the image formats avifenc
+// can read. RAW and HEIC files are converted via their JPEG preview.
+func IsAVIFConvertibleContentType(contentType string) bool {
+	switch strings.ToLower(contentType) {
+	case "image/jpeg", "image/jpg", "image/png":
+		return true
+	}
+	return false
+}
+
+// avifObjectID returns the GCS object ID for the AVIF version of an image.
+// ".avif" is appended to the object ID, extension included (see
+// webpObjectID).
+// Example:
+//
+//	"dir1/dir2/image.jpg" → "dir1/dir2/image.jpg.avif"
+func avifObjectID(objectID string) string {
+	return objectID + ".avif"
+}
+
+// GenerateAVIF converts JPEG or PNG data to a lossy AVIF using the external
+// avifenc binary from libavif. quality must be in the range 1-100; values
+// outside that range are clamped to DefaultAVIFQuality.
+//
+// AVIF cannot be encoded in Go, so there is no fallback: if avifenc is not
+// installed an error wrapping exec.ErrNotFound is returned.
+func GenerateAVIF(caps *Capabilities, data []byte, quality int) ([]byte, error) {
+	if quality < 1 || quality > 100 {
+		quality = DefaultAVIFQuality
+	}
+	if !caps.Has(ToolAVIFEnc) {
+		return nil, fmt.Errorf("cannot encode AVIF: %w", &exec.Error{Name: ToolAVIFEnc, Err: exec.ErrNotFound})
+	}
+
+	// avifenc tells its input format by the file extension
+	var inputName string
+	switch DetectContentType(data) {
+	case "image/jpeg":
+		inputName = "input.jpg"
+	case "image/png":
+		inputName = "input.png"
+	default:
+		return nil, fmt.Errorf("avifenc cannot read %s", DetectContentType(data))
+	}
+
+	tmpDir, err := os.MkdirTemp("", "avif-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	inputPath := filepath.Join(tmpDir, inputName)
+	outputPath := filepath.Join(tmpDir, "output.avif")
+	if err := os.WriteFile(inputPath, data, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write input temp file: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 120*time.Second)
+	defer cancel()
+
+	// -q sets lossy quality (0-100); AVIF encoding is slow, so the speed is
+	// raised from the default of 6
+	cmd := exec.CommandContext(ctx, ToolAVIFEnc, "-q", fmt.Sprintf("%d", quality), "-s", "8", inputPath, outputPath)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("avifenc failed: %w, stderr: %s", err, stderr.String())
+	}
+
+	avifData, err := os.ReadFile(outputPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read avifenc output: %w", err)
+	}
+	if len(avifData) == 0 {
+		return nil, fmt.Errorf("avifenc produced no output")
+	}
+	return avifData, nil
+}
+
+// avifEnabled reports whether AVIF renditions are generated: quality is set
+// and avifenc is installed.
+func avifEnabled(caps *Capabilities, quality int) bool {
+	return quality > 0 && caps.Has(ToolAVIFEnc)
+}
+
+// storeAVIF generates the AVIF version of the image objectID from data and
+// uploads it to GCS, returning its object ID.
+func storeAVIF(ctx context.Context, bucket *storage.BucketHandle, caps *Capabilities, data []byte, objectID string, quality int) (string, error) {
+	avifData, err := GenerateAVIF(caps, data, quality)
+	if err != nil {
+		return "", err
+	}
+
+	avifID := avifObjectID(objectID)
+	_, writeSpan := startSpan(ctx, "gcs.write_object")
+	avifWriter, err := newDerivedObjectWriter(ctx, bucket.Object(avifID), database.DerivedKindAVIF, objectID)
+	if err != nil {
+		recordSpanError(writeSpan, err)
+		return "", err
+	}
+	avifWriter.ContentType = "image/avif"
+	if _, err := avifWriter.Write(avifData); err != nil {
+		_ = avifWriter.Close()
+		recordSpanError(writeSpan, err)
+		return "", fmt.Errorf("failed to write AVIF: %w", err)
+	}
+	if err := avifWriter.Close(); err != nil {
+		recordSpanError(writeSpan, err)
+		return "", fmt.Errorf("failed to close AVIF writer: %w", err)
+	}
+	endSpanOk(writeSpan)
+	return avifID, nil
+}
+
+// uploadAVIF generates an AVIF version of an image if AVIF renditions are
+// enabled (see avifEnabled), uploads it to GCS, and sets the AvifObjectID on
+// photoObject. data is the original or, for RAW and HEIC files, the JPEG
+// preview. Errors are logged but not fatal.
+func uploadAVIF(ctx context.Context, bucket *storage.BucketHandle, caps *Capabilities, data []byte, objectID string, quality int, photoObject *database.PhotoObject) {
+	if !avifEnabled(caps, quality) || !IsAVIFConvertibleContentType(DetectContentType(data)) {
+		return
+	}
+
+	avifID, err := storeAVIF(ctx, bucket, caps, data, objectID, quality)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to generate AVIF",
+			slog.String("object_id", objectID),
+			slog.String("error", err.Error()),
+		)
+		return
+	}
+	photoObject.AvifObjectID = &avifID
+
+	slog.InfoContext(ctx, "Generated AVIF",
+		slog.String("object_id", objectID),
+		slog.String("avif_object_id", avifID),
+	)
+}
+
+// UpdateAvif generates missing AVIF renditions for all eligible PhotoObject
+// rows belonging to the authenticated user that do not yet have an
+// avif_object_id set. It fails with FailedPrecondition if AVIF renditions
+// are disabled or avifenc is not installed.
+//
+// Eligibility:
+//   - The row's avif_object_id is NULL or empty.
+//   - The row's object_id is not a recorded derived asset.
+//   - The row is a JPEG or PNG, or a RAW or HEIC file, whose AVIF is derived
+//     from its JPEG preview (generated first if there is none yet).
+//
+// Per-object failures are logged and counted as failed; they do not abort
+// the run. Progress is streamed as in UpdateWebp: one message per processed
+// object plus a final summary message with complete=true.
+//
+// Only one of SyncDatabase, UpdateWebp and UpdateAvif runs at a time for a
+// user (see runExclusive).
+func (s *LibraryServer) UpdateAvif(req *proto.UpdateAvifRequest, stream grpc.ServerStreamingServer[proto.UpdateAvifProgress]) error {
+	ctx := stream.Context()
+	userID, ok := ctx.Value(contextKeyUser{}).(uint)
+	if !ok {
+		return status.Errorf(codes.Unauthenticated, "authentication required")
+	}
+	if s.AVIFQuality <= 0 {
+		return status.Errorf(codes.FailedPrecondition, "AVIF renditions are disabled on this server")
+	}
+	if !s.Capabilities.Has(ToolAVIFEnc) {
+		return status.Errorf(codes.FailedPrecondition, "%s is not installed on this server", ToolAVIFEnc)
+	}
+
+	return runExclusive(s, stream, userID, OperationUpdateAvif, func(stream grpc.ServerStreamingServer[proto.UpdateAvifProgress]) error {
+		return s.updateAvif(userID, req, stream)
+	})
+}
+
+// updateAvif implements UpdateAvif while holding the user's operation lock.
+func (s *LibraryServer) updateAvif(userID uint, req *proto.UpdateAvifRequest, stream grpc.ServerStreamingServer[proto.UpdateAvifProgress]) error {
+	ctx := stream.Context()
+
+	_, derivedListSpan := startSpan(ctx, "db.list_derived_objects")
+	derived, err := loadDerivedObjectSet(s.DB)
+	if err != nil {
+		recordSpanError(derivedListSpan, err)
+		return status.Errorf(codes.Internal, "failed to list derived objects: %v", err)
+	}
+	endSpanOk(derivedListSpan)
+
+	var databasePhotos []database.PhotoObject
+	_, dbListSpan := startSpan(ctx, "db.list_photo_objects")
+	if err := s.DB.Where("user_id = ? AND (avif_object_id IS NULL OR avif_object_id = '')", userID).
+		Find(&databasePhotos).Error; err != nil {
+		recordSpanError(dbListSpan, err)
+		return status.Errorf(codes.Internal, "failed to list database objects: %v", err)
+	}
+	endSpanOk(dbListSpan)
+
+	eligible := make([]database.PhotoObject, 0, len(databasePhotos))
+	for _, obj := range databasePhotos {
+		if derived.contains(obj.ObjectID) {
+			continue
+		}
+		if !IsAVIFConvertibleContentType(obj.ContentType) && !HasPreviewContentType(obj.ContentType) {
+			continue
+		}
+		eligible = append(eligible, obj)
+	}
+	slices.SortFunc(eligible, func(a, b database.PhotoObject) int {
+		return cmp.Compare(a.ObjectID, b.ObjectID)
+	})
+
+	pause := time.Duration(req.GetPauseBetweenObjectsSeconds()) * time.Second
+	total := uint32(len(eligible))
+
+	slog.InfoContext(
+		ctx,
+		"Starting AVIF generation",
+		slog.Int("eligible_db", len(eligible)),
+		slog.Int("total_db", len(databasePhotos)),
+		slog.Uint64("user_id", uint64(userID)),
+	)
+
+	var bucket *storage.BucketHandle
+	if len(eligible) > 0 && s.GCSClient != nil {
+		bucket = s.GCSClient.Bucket(s.BucketName)
+	}
+
+	var generated, skipped, failed int
+	for i := range eligible {
+		status := s.generateAvifForObject(ctx, bucket, &eligible[i])
+		switch status {
+		case webpStatusGenerated:
+			generated++
+		case webpStatusSkipped:
+			skipped++
+		case webpStatusFailed:
+			failed++
+		}
+
+		if pause > 0 && status == webpStatusGenerated {
+			time.Sleep(pause)
+		}
+
+		if err := stream.Send(&proto.UpdateAvifProgress{
+			Processed: uint32(i + 1),
+			Total:     total,
+			Generated: uint32(generated),
+			Skipped:   uint32(skipped),
+			Failed:    uint32(failed),
+		}); err != nil {
+			return err
+		}
+	}
+
+	slog.InfoContext(
+		ctx,
+		"AVIF generation pass completed",
+		slog.Int("generated", generated),
+		slog.Int("skipped", skipped),
+		slog.Int("failed", failed),
+		slog.Uint64("user_id", uint64(userID)),
+	)
+
+	return stream.Send(&proto.UpdateAvifProgress{
+		Processed: total,
+		Total:     total,
+		Generated: uint32(generated),
+		Skipped:   uint32(skipped),
+		Failed:    uint32(failed),
+		Complete:  true,
+	})
+}
+
+// generateAvifForObject generates the AVIF rendition of photoObject from the
+// original or its JPEG preview and records it in the database. The returned
+// webpStatus classifies the outcome for progress accounting.
+func (s *LibraryServer) generateAvifForObject(ctx context.Context, bucket *storage.BucketHandle, photoObject *database.PhotoObject) webpStatus {
+	objectID := photoObject.ObjectID
+	if bucket == nil {
+		slog.WarnContext(
+			ctx,
+			"no storage bucket available for AVIF generation",
+			slog.String("object_id", objectID),
+		)
+		return webpStatusFailed
+	}
+
+	var data []byte
+	var err error
+	switch {
+	case !HasPreviewContentType(photoObject.ContentType):
+		data, err = readRenditionSource(ctx, bucket, objectID)
+	case photoObject.ThumbnailObjectID != nil && *photoObject.ThumbnailObjectID != "":
+		data, err = readRenditionSource(ctx, bucket, *photoObject.ThumbnailObjectID)
+	default:
+		data, err = s.generateAndStorePreview(ctx, bucket, photoObject, objectID, photoObject.ContentType)
+	}
+	if err != nil {
+		slog.WarnContext(
+			ctx,
+			"failed to read source for AVIF generation",
+			slog.String("object_id", objectID),
+			slog.String("error", err.Error()),
+		)
+		return webpStatusFailed
+	}
+	if !IsAVIFConvertibleContentType(DetectContentType(data)) {
+		return webpStatusSkipped
+	}
+
+	avifID, err := storeAVIF(ctx, bucket, s.Capabilities, data, objectID, s.AVIFQuality)
+	if err != nil {
+		slog.WarnContext(
+			ctx,
+			"failed to generate AVIF",
+			slog.String("object_id", objectID),
+			slog.String("error", err.Error()),
+		)
+		return webpStatusFailed
+	}
+
+	_, dbSpan := startSpan(ctx, "db.update_avif_object_id")
+	if err := s.DB.Model(photoObject).Update("avif_object_id", avifID).Error; err != nil {
+		recordSpanError(dbSpan, err)
+		slog.WarnContext(
+			ctx,
+			"failed to update avif_object_id",
+			slog.String("object_id", objectID),
+			slog.String("error", err.Error()),
+		)
+		return webpStatusFailed
+	}
+	endSpanOk(dbSpan)
+	recordDerivedObject(ctx, s.DB, photoObject.UserID, database.DerivedKindAVIF, objectID, avifID, derivedObjectSize(ctx, bucket, avifID))
+
+	slog.InfoContext(
+		ctx,
+		"Generated AVIF",
+		slog.String("object_id", objectID),
+		slog.String("avif_object_id", avifID),
+	)
+	return webpStatusGenerated
+}
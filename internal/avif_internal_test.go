@@ -0,0 +1,168 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"testing"
+
+	"github.com/alexhokl/photos/database"
+	"github.com/alexhokl/photos/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+func TestIsAVIFConvertibleContentType(t *testing.T) {
+	tests := []struct {
+		contentType string
+		expected    bool
+	}{
+		{"image/jpeg", true},
+		{"IMAGE/PNG", true},
+		{"image/gif", false},
+		{"image/webp", false},
+		{"image/x-adobe-dng", false},
+		{"video/mp4", false},
+	}
+	for _, test := range tests {
+		if got := IsAVIFConvertibleContentType(test.contentType); got != test.expected {
+			t.Errorf("IsAVIFConvertibleContentType(%q) = %v, want %v", test.contentType, got, test.expected)
+		}
+	}
+}
+
+func TestAvifObjectID(t *testing.T) {
+	tests := []struct {
+		objectID string
+		expected string
+	}{
+		{"dir1/dir2/image.jpg", "dir1/dir2/image.jpg.avif"},
+		{"image.png", "image.png.avif"},
+		{"photo", "photo.avif"},
+	}
+	for _, test := range tests {
+		if got := avifObjectID(test.objectID); got != test.expected {
+			t.Errorf("avifObjectID(%q) = %q, want %q", test.objectID, got, test.expected)
+		}
+		if got := derivedObjectID(database.DerivedKindAVIF, test.objectID); got != test.expected {
+			t.Errorf("derivedObjectID(avif, %q) = %q, want %q", test.objectID, got, test.expected)
+		}
+	}
+}
+
+func TestGenerateAVIF(t *testing.T) {
+	if _, err := exec.LookPath(ToolAVIFEnc); err != nil {
+		t.Skip("avifenc not found in PATH, skipping GenerateAVIF test")
+	}
+
+	avifData, err := GenerateAVIF(nil, encodeTestJPEG(t, 16, 8), 0)
+	if err != nil {
+		t.Fatalf("GenerateAVIF returned error: %v", err)
+	}
+	if got := DetectContentType(avifData); got != "image/avif" {
+		t.Errorf("content type = %q, want image/avif", got)
+	}
+}
+
+func TestGenerateAVIF_MissingTool(t *testing.T) {
+	if _, err := GenerateAVIF(&Capabilities{}, encodeTestJPEG(t, 4, 4), 60); !errors.Is(err, exec.ErrNotFound) {
+		t.Errorf("GenerateAVIF error = %v, want exec.ErrNotFound", err)
+	}
+}
+
+func TestUploadAVIF_Disabled(t *testing.T) {
+	photoObject := &database.PhotoObject{ObjectID: "IMG_001.jpg"}
+
+	// Neither call reaches the nil bucket
+	uploadAVIF(context.Background(), nil, nil, encodeTestJPEG(t, 4, 4), "IMG_001.jpg", 0, photoObject)
+	uploadAVIF(context.Background(), nil, &Capabilities{}, encodeTestJPEG(t, 4, 4), "IMG_001.jpg", 60, photoObject)
+
+	if photoObject.AvifObjectID != nil {
+		t.Errorf("AvifObjectID = %q, want nil", *photoObject.AvifObjectID)
+	}
+}
+
+// mockUpdateAvifStream implements grpc.ServerStreamingServer[proto.UpdateAvifProgress]
+// for testing UpdateAvif. Sent progress messages are collected in sent.
+type mockUpdateAvifStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	sent []*proto.UpdateAvifProgress
+}
+
+func (m *mockUpdateAvifStream) Send(msg *proto.UpdateAvifProgress) error {
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func (m *mockUpdateAvifStream) Context() context.Context { return m.ctx }
+
+func TestUpdateAvif_Unauthenticated(t *testing.T) {
+	server := &LibraryServer{AVIFQuality: 60}
+
+	err := server.UpdateAvif(&proto.UpdateAvifRequest{}, &mockUpdateAvifStream{ctx: context.Background()})
+
+	assertGRPCError(t, err, codes.Unauthenticated)
+}
+
+func TestUpdateAvif_Unavailable(t *testing.T) {
+	tests := []struct {
+		name   string
+		server *LibraryServer
+	}{
+		{"disabled", &LibraryServer{}},
+		{"avifenc missing", &LibraryServer{AVIFQuality: 60, Capabilities: &Capabilities{}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stream := &mockUpdateAvifStream{ctx: contextWithUserID(1)}
+
+			err := test.server.UpdateAvif(&proto.UpdateAvifRequest{}, stream)
+
+			assertGRPCError(t, err, codes.FailedPrecondition)
+			if len(stream.sent) != 0 {
+				t.Errorf("expected no progress messages, got %d", len(stream.sent))
+			}
+		})
+	}
+}
+
+// TestUpdateAvif_EligibilityFilter verifies that only JPEG, PNG, RAW and HEIC
+// rows without an avif_object_id that are not derived assets are processed.
+// With a nil GCS client every eligible row fails.
+func TestUpdateAvif_EligibilityFilter(t *testing.T) {
+	db := setupLibraryTestDB(t)
+
+	avifID := "photos/already.avif"
+	objects := []database.PhotoObject{
+		{ObjectID: "photos/already.jpg", ContentType: "image/jpeg", MD5Hash: "h1", UserID: 1, AvifObjectID: &avifID},
+		{ObjectID: "photos/derived_web.jpg", ContentType: "image/jpeg", MD5Hash: "h2", UserID: 1},
+		{ObjectID: "photos/animation.gif", ContentType: "image/gif", MD5Hash: "h3", UserID: 1},
+		{ObjectID: "photos/clip.mp4", ContentType: "video/mp4", MD5Hash: "h4", UserID: 1},
+		{ObjectID: "photos/eligible.jpg", ContentType: "image/jpeg", MD5Hash: "h5", UserID: 1},
+		{ObjectID: "photos/eligible.dng", ContentType: "image/x-adobe-dng", MD5Hash: "h6", UserID: 1},
+		{ObjectID: "photos/other_user.jpg", ContentType: "image/jpeg", MD5Hash: "h7", UserID: 2},
+	}
+	for _, obj := range objects {
+		if err := db.Create(&obj).Error; err != nil {
+			t.Fatalf("failed to seed photo object: %v", err)
+		}
+	}
+	recordDerivedObject(context.Background(), db, 1, database.DerivedKindWebP, "photos/derived.jpg", "photos/derived_web.jpg", 0)
+
+	server := &LibraryServer{DB: db, BucketName: "test-bucket", AVIFQuality: 60}
+	stream := &mockUpdateAvifStream{ctx: contextWithUserID(1)}
+
+	if err := server.UpdateAvif(&proto.UpdateAvifRequest{}, stream); err != nil {
+		t.Fatalf("UpdateAvif returned error: %v", err)
+	}
+
+	if len(stream.sent) != 3 {
+		t.Fatalf("expected 3 progress messages (2 per-object + 1 summary), got %d", len(stream.sent))
+	}
+	summary := stream.sent[2]
+	if !summary.GetComplete() || summary.GetTotal() != 2 || summary.GetFailed() != 2 {
+		t.Errorf("summary complete=%v total=%d failed=%d, want true/2/2",
+			summary.GetComplete(), summary.GetTotal(), summary.GetFailed())
+	}
+}
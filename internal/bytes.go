@@ -10,12 +10,14 @@ import (
 	"io"
 	"log/slog"
 	"path"
+	"strings"
 	"sync"
 	"time"
 
 	"cloud.google.com/go/storage"
 	"github.com/alexhokl/photos/database"
 	"github.com/alexhokl/photos/proto"
+	"golang.org/x/sync/singleflight"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -24,10 +26,31 @@ import (
 
 type BytesServer struct {
 	proto.UnimplementedByteServiceServer
-	DB          *gorm.DB
-	GCSClient   *storage.Client
-	BucketName  string
-	WebPQuality int
+	DB           *gorm.DB
+	GCSClient    *storage.Client
+	BucketName   string
+	WebPQuality  int
+	UploadPolicy UploadPolicy
+	// RenderCache caches the renditions returned by RenderPhoto; nil
+	// disables caching
+	RenderCache *RenderCache
+	// ThumbnailSizes are the long edges of the thumbnails generated for
+	// each upload; empty disables generation
+	ThumbnailSizes []int
+	// AVIFQuality is the quality of the AVIF rendition generated for each
+	// upload alongside the WebP; zero disables it
+	AVIFQuality int
+	// Transcoder transcodes uploaded videos in the background; nil
+	// disables it
+	Transcoder *Transcoder
+	// Jobs generates the derived assets of uploads in the background; nil
+	// generates them before the upload returns
+	Jobs *JobQueue
+	// Capabilities are the external tools found at startup; nil assumes
+	// they are all installed
+	Capabilities *Capabilities
+
+	renders singleflight.Group
 }
 
 // Upload uploads a file to Google Cloud Storage.
@@ -45,6 +68,28 @@ func (s *BytesServer) Upload(ctx context.Context, req *proto.UploadRequest) (*pr
 	objectID := req.GetObjectId()
 	data := req.GetData()
 
+	// The stored content type is sniffed from the data, not taken from the request
+	contentType, err := s.detectUploadContentType(ctx, objectID, req.GetContentType(), data)
+	if err != nil {
+		return nil, err
+	}
+
+	// XMP sidecars are attached to their photo rather than stored as a photo
+	if isSidecarObjectID(objectID) {
+		photo, skipped, err := storeSidecar(ctx, s.DB, s.GCSClient.Bucket(s.BucketName), userID, objectID, data, req.GetConflictPolicy())
+		if err != nil {
+			return nil, err
+		}
+		return &proto.UploadResponse{
+			Photo:   photo,
+			Skipped: skipped,
+		}, nil
+	}
+
+	if err := checkQuota(ctx, s.DB, userID, objectID, 1, int64(len(data)), replacesExisting(req.GetConflictPolicy())); err != nil {
+		return nil, err
+	}
+
 	// Compute MD5 hash of the uploaded data
 	md5Hash := md5.Sum(data)
 	md5HashBase64 := base64.StdEncoding.EncodeToString(md5Hash[:])
@@ -60,108 +105,55 @@ func (s *BytesServer) Upload(ctx context.Context, req *proto.UploadRequest) (*pr
 	photoMetadata := ExtractPhotoMetadata(data, objectID)
 
 	bucket := s.GCSClient.Bucket(s.BucketName)
-	obj := bucket.Object(objectID)
 
-	_, writeSpan := startSpan(ctx, "gcs.write_object")
-	writer := obj.NewWriter(ctx)
-	writer.ContentType = req.GetContentType()
-	writer.Metadata = photoMetadata.ToGCSMetadata()
-
-	if _, err := writer.Write(data); err != nil {
-		recordSpanError(writeSpan, err)
-		return nil, status.Errorf(codes.Internal, "failed to write data to GCS: %v", err)
-	}
-
-	if err := writer.Close(); err != nil {
-		recordSpanError(writeSpan, err)
-		return nil, status.Errorf(codes.Internal, "failed to close GCS writer: %v", err)
-	}
-	endSpanOk(writeSpan)
-
-	// Get object attributes after upload
-	_, attrsSpan := startSpan(ctx, "gcs.get_object_attrs")
-	attrs, err := obj.Attrs(ctx)
+	// Write to GCS, resolving a clash with an existing object per the policy
+	written, err := writeUploadObject(ctx, bucket, objectID, contentType, photoMetadata.ToGCSMetadata(), data, md5Hash[:], req.GetConflictPolicy())
 	if err != nil {
-		recordSpanError(attrsSpan, err)
-		return nil, status.Errorf(codes.Internal, "failed to get object attributes: %v", err)
-	}
-	endSpanOk(attrsSpan)
-
-	// Write to PhotoObject table (create or restore if soft-deleted)
-	var timeTaken *time.Time
-	if photoMetadata.HasDateTaken {
-		timeTaken = &photoMetadata.DateTaken
-	}
-	photoObject := createPhotoObject(objectID, attrs, userID, md5HashBase64, timeTaken)
-
-	// For DNG files, generate a JPEG preview and upload it to GCS
-	if IsDNGContentType(req.GetContentType()) {
-		uploadDNGPreview(ctx, bucket, data, objectID, photoObject)
+		return nil, err
 	}
-
-	// For convertible image types, generate a WebP version and upload it to GCS
-	if IsWebPConvertibleContentType(req.GetContentType()) {
-		uploadWebP(ctx, bucket, data, objectID, s.WebPQuality, photoObject)
+	if written.Skipped {
+		return &proto.UploadResponse{
+			Photo:   s.skippedUploadPhoto(userID, written.Attrs),
+			Skipped: true,
+		}, nil
 	}
-
-	_, createSpan := startSpan(ctx, "db.create_or_restore_photo_object")
-	if err := database.CreateOrRestorePhotoObject(s.DB, photoObject); err != nil {
-		recordSpanError(createSpan, err)
-		return nil, status.Errorf(codes.Internal, "failed to create photo object record: %v", err)
-	}
-	endSpanOk(createSpan)
-
-	// Write to PhotoDirectory table (create or restore if soft-deleted)
-	dir := ExtractDirectoryFromPath(objectID)
-	if dir != "" {
-		_, dirSpan := startSpan(ctx, "db.create_or_restore_photo_directory")
-		if err := database.CreateOrRestorePhotoDirectory(s.DB, dir); err != nil {
-			recordSpanError(dirSpan, err)
-			return nil, status.Errorf(codes.Internal, "failed to create photo directory record: %v", err)
-		}
-		endSpanOk(dirSpan)
+	photo, err := s.finishUpload(ctx, userID, bucket, written, md5HashBase64, photoMetadata, data)
+	if err != nil {
+		return nil, err
 	}
 
 	slog.InfoContext(
 		ctx,
 		"Uploaded file to bucket",
-		slog.String("object_id", objectID),
+		slog.String("object_id", photo.ObjectId),
 	)
 
-	photo := &proto.Photo{
-		ObjectId:         objectID,
-		Filename:         objectID,
-		ContentType:      attrs.ContentType,
-		SizeBytes:        attrs.Size,
-		CreatedAt:        attrs.Created.Format(time.RFC3339),
-		UpdatedAt:        attrs.Updated.Format(time.RFC3339),
-		Md5Hash:          md5HashBase64,
-		Latitude:         photoMetadata.Latitude,
-		Longitude:        photoMetadata.Longitude,
-		HasLocation:      photoMetadata.HasLocation,
-		DateTaken:        photoMetadata.FormatDateTaken(),
-		HasDateTaken:     photoMetadata.HasDateTaken,
-		Width:            int32(photoMetadata.Width),
-		Height:           int32(photoMetadata.Height),
-		HasDimensions:    photoMetadata.HasDimensions,
-		OriginalFilename: photoMetadata.OriginalFilename,
-		CameraMake:       photoMetadata.CameraMake,
-		CameraModel:      photoMetadata.CameraModel,
-		FocalLength:      photoMetadata.FocalLength,
-		Iso:              int32(photoMetadata.ISO),
-		Aperture:         photoMetadata.Aperture,
-		ExposureTime:     photoMetadata.ExposureTime,
-		LensModel:        photoMetadata.LensModel,
-	}
-	if photoObject.WebpObjectID != nil {
-		photo.WebpObjectId = *photoObject.WebpObjectID
-	}
-
 	return &proto.UploadResponse{
 		Photo: photo,
 	}, nil
 }
 
+// detectUploadContentType sniffs the content type of an upload from its magic
+// bytes, and the extension of objectID for TIFF-based RAW files, and checks
+// it against the upload policy. A declared type that does not match is
+// logged and otherwise ignored.
+func (s *BytesServer) detectUploadContentType(ctx context.Context, objectID, declared string, data []byte) (string, error) {
+	detected := refineTIFFContentType(objectID, DetectContentType(data))
+	if declared != "" && !strings.EqualFold(declared, detected) {
+		slog.WarnContext(
+			ctx,
+			"Declared content type does not match detected content type",
+			slog.String("object_id", objectID),
+			slog.String("declared_content_type", declared),
+			slog.String("detected_content_type", detected),
+		)
+	}
+	if err := s.UploadPolicy.Check(detected, int64(len(data))); err != nil {
+		return "", err
+	}
+	return detected, nil
+}
+
 func validateUploadRequest(req *proto.UploadRequest) error {
 	if req == nil {
 		return status.Errorf(codes.InvalidArgument, "request not specified")
@@ -188,64 +180,82 @@ func ExtractDirectoryFromPath(objectPath string) string {
 
 // createPhotoObject creates a PhotoObject from the given object ID, storage attributes, user ID, MD5 hash, and optional time taken.
 func createPhotoObject(objectID string, attrs *storage.ObjectAttrs, userID uint, md5Hash string, timeTaken *time.Time) *database.PhotoObject {
-	return &database.PhotoObject{
+	photoObject := &database.PhotoObject{
 		ObjectID:    objectID,
 		ContentType: attrs.ContentType,
 		MD5Hash:     md5Hash,
+		SizeBytes:   attrs.Size,
 		UserID:      userID,
 		TimeTaken:   timeTaken,
 	}
+	// Live Photos are paired by the identifier recorded in the metadata
+	photoObject.ContentIdentifier = attrs.Metadata[MetadataKeyContentIdentifier]
+	// Bursts are stacked by the camera they were taken with
+	photoObject.Camera = cameraName(attrs.Metadata[MetadataKeyCameraMake], attrs.Metadata[MetadataKeyCameraModel])
+	return photoObject
 }
 
-// uploadDNGPreview generates a JPEG preview for a DNG file, uploads it to GCS,
-// and sets the ThumbnailObjectID on photoObject.  Errors are logged but not fatal.
-func uploadDNGPreview(ctx context.Context, bucket *storage.BucketHandle, data []byte, objectID string, photoObject *database.PhotoObject) {
-	previewData, err := GenerateDNGPreview(data)
+// uploadPreview generates the JPEG preview of a RAW or HEIC file (see
+// GeneratePreview), uploads it to GCS, and sets the ThumbnailObjectID on
+// photoObject. It returns the preview, or nil if there is none. Errors are
+// logged but not fatal.
+func uploadPreview(ctx context.Context, bucket *storage.BucketHandle, caps *Capabilities, contentType string, data []byte, objectID string, photoObject *database.PhotoObject) []byte {
+	previewData, err := GeneratePreview(caps, contentType, data)
 	if err != nil {
-		slog.WarnContext(ctx, "failed to generate DNG preview",
+		slog.WarnContext(ctx, "failed to generate preview",
 			slog.String("object_id", objectID),
 			slog.String("error", err.Error()),
 		)
-		return
+		return nil
 	}
 
-	previewObjectID := dngPreviewObjectID(objectID)
+	previewObjectID := previewObjectID(objectID)
 	_, writeSpan := startSpan(ctx, "gcs.write_object")
-	previewWriter := bucket.Object(previewObjectID).NewWriter(ctx)
+	previewWriter, err := newDerivedObjectWriter(ctx, bucket.Object(previewObjectID), database.DerivedKindPreview, objectID)
+	if err != nil {
+		recordSpanError(writeSpan, err)
+		slog.WarnContext(ctx, "failed to write preview to GCS",
+			slog.String("object_id", objectID),
+			slog.String("error", err.Error()),
+		)
+		return nil
+	}
 	previewWriter.ContentType = "image/jpeg"
 
 	if _, err := previewWriter.Write(previewData); err != nil {
 		_ = previewWriter.Close()
 		recordSpanError(writeSpan, err)
-		slog.WarnContext(ctx, "failed to write DNG preview to GCS",
+		slog.WarnContext(ctx, "failed to write preview to GCS",
 			slog.String("object_id", objectID),
 			slog.String("error", err.Error()),
 		)
-		return
+		return nil
 	}
 
 	if err := previewWriter.Close(); err != nil {
 		recordSpanError(writeSpan, err)
-		slog.WarnContext(ctx, "failed to close DNG preview writer",
+		slog.WarnContext(ctx, "failed to close preview writer",
 			slog.String("object_id", objectID),
 			slog.String("error", err.Error()),
 		)
-		return
+		return nil
 	}
 	endSpanOk(writeSpan)
 
 	photoObject.ThumbnailObjectID = &previewObjectID
 
-	slog.InfoContext(ctx, "Generated DNG preview",
+	slog.InfoContext(ctx, "Generated preview",
 		slog.String("object_id", objectID),
 		slog.String("preview_object_id", previewObjectID),
 	)
+	return previewData
 }
 
-// uploadWebP generates a WebP version of an image, uploads it to GCS,
-// and sets the WebpObjectID on photoObject.  Errors are logged but not fatal.
-func uploadWebP(ctx context.Context, bucket *storage.BucketHandle, data []byte, objectID string, quality int, photoObject *database.PhotoObject) {
-	webpData, err := GenerateWebP(data, quality)
+// uploadWebP generates a WebP version of an image (or a JPEG if cwebp is not
+// installed, see generateWebRendition), uploads it to GCS, and sets the
+// WebpObjectID on photoObject.  Errors are logged but not fatal.
+func uploadWebP(ctx context.Context, bucket *storage.BucketHandle, caps *Capabilities, data []byte, objectID string, quality int, photoObject *database.PhotoObject) {
+	rendition, err := generateWebRendition(caps, objectID, data, quality)
 	if err != nil {
 		slog.WarnContext(ctx, "failed to generate WebP",
 			slog.String("object_id", objectID),
@@ -254,12 +264,20 @@ func uploadWebP(ctx context.Context, bucket *storage.BucketHandle, data []byte,
 		return
 	}
 
-	webpID := webpObjectID(objectID)
+	webpID := rendition.ObjectID
 	_, writeSpan := startSpan(ctx, "gcs.write_object")
-	webpWriter := bucket.Object(webpID).NewWriter(ctx)
-	webpWriter.ContentType = "image/webp"
+	webpWriter, err := newDerivedObjectWriter(ctx, bucket.Object(webpID), database.DerivedKindWebP, objectID)
+	if err != nil {
+		recordSpanError(writeSpan, err)
+		slog.WarnContext(ctx, "failed to write WebP to GCS",
+			slog.String("object_id", objectID),
+			slog.String("error", err.Error()),
+		)
+		return
+	}
+	webpWriter.ContentType = rendition.ContentType
 
-	if _, err := webpWriter.Write(webpData); err != nil {
+	if _, err := webpWriter.Write(rendition.Data); err != nil {
 		_ = webpWriter.Close()
 		recordSpanError(writeSpan, err)
 		slog.WarnContext(ctx, "failed to write WebP to GCS",
@@ -287,6 +305,145 @@ func uploadWebP(ctx context.Context, bucket *storage.BucketHandle, data []byte,
 	)
 }
 
+// generateDerivedAssets generates the derived assets of the photo
+// photoObject from its data: the JPEG preview of RAW and HEIC files, the
+// WebP and AVIF renditions, the poster frame and animated preview of videos,
+// the video of Android motion photos and the fixed-size thumbnails. They are
+// uploaded to GCS, set on photoObject and recorded against the photo. It
+// returns the thumbnails generated, and an error naming any expected asset
+// that could not be generated.
+func (s *BytesServer) generateDerivedAssets(ctx context.Context, bucket *storage.BucketHandle, photoObject *database.PhotoObject, data []byte) ([]database.PhotoRendition, error) {
+	objectID := photoObject.ObjectID
+	contentType := photoObject.ContentType
+	var missing []string
+
+	// For RAW and HEIC files, generate a JPEG preview and upload it to GCS
+	var previewData []byte
+	if HasPreviewContentType(contentType) {
+		previewData = uploadPreview(ctx, bucket, s.Capabilities, contentType, data, objectID, photoObject)
+		if previewData == nil {
+			missing = append(missing, "preview")
+		}
+	}
+
+	// For convertible image types, generate a WebP (and, if enabled, an AVIF)
+	// version and upload it to GCS; photos with a preview get theirs from the
+	// preview
+	switch {
+	case IsWebPConvertibleContentType(contentType):
+		uploadWebP(ctx, bucket, s.Capabilities, data, objectID, s.WebPQuality, photoObject)
+		uploadAVIF(ctx, bucket, s.Capabilities, data, objectID, s.AVIFQuality, photoObject)
+	case previewData != nil:
+		uploadWebP(ctx, bucket, s.Capabilities, previewData, objectID, s.WebPQuality, photoObject)
+		uploadAVIF(ctx, bucket, s.Capabilities, previewData, objectID, s.AVIFQuality, photoObject)
+	}
+	if (IsWebPConvertibleContentType(contentType) || previewData != nil) && photoObject.WebpObjectID == nil {
+		missing = append(missing, "WebP")
+	}
+
+	// For videos, choose a poster frame, which the thumbnails are rendered
+	// from, and generate an animated preview
+	if IsVideoContentType(contentType) {
+		previewData = uploadVideoPreviews(ctx, bucket, s.Capabilities, data, objectID, photoObject)
+		if s.Capabilities.Has(ToolFFmpeg) && photoObject.ThumbnailObjectID == nil {
+			missing = append(missing, "poster")
+		}
+	}
+
+	// For Android motion photos, extract the embedded video so that it plays
+	// like that of a Live Photo
+	if contentType == "image/jpeg" {
+		uploadMotionVideo(ctx, bucket, data, objectID, photoObject)
+	}
+
+	// The photo may have been restored rather than created, so the columns
+	// are updated by object ID
+	_, updateSpan := startSpan(ctx, "db.update_photo_object")
+	if err := s.DB.Model(&database.PhotoObject{}).
+		Where("object_id = ? AND user_id = ?", objectID, photoObject.UserID).
+		Updates(map[string]any{
+			"thumbnail_object_id":        photoObject.ThumbnailObjectID,
+			"webp_object_id":             photoObject.WebpObjectID,
+			"avif_object_id":             photoObject.AvifObjectID,
+			"animated_preview_object_id": photoObject.AnimatedPreviewObjectID,
+			"motion_video_object_id":     photoObject.MotionVideoObjectID,
+		}).Error; err != nil {
+		recordSpanError(updateSpan, err)
+		return nil, fmt.Errorf("failed to record derived assets: %w", err)
+	}
+	endSpanOk(updateSpan)
+	recordPhotoDerivedObjects(ctx, s.DB, bucket, photoObject)
+
+	// Generate the fixed-size thumbnails now that the photo is recorded
+	renditions := storeRenditions(ctx, s.DB, bucket, photoObject.UserID, objectID, renditionSourceData(contentType, data, previewData), s.ThumbnailSizes)
+
+	if len(missing) > 0 {
+		return renditions, fmt.Errorf("failed to generate %s of %s", strings.Join(missing, ", "), objectID)
+	}
+	return renditions, nil
+}
+
+// uploadDerivedAssets generates the derived assets of an upload recorded as
+// photoObject, or, if there is a job queue, queues a JobKindDerivedAssets job
+// to generate them so that the upload returns without waiting for them. It
+// returns the thumbnails generated, which are none if the job was queued.
+// Errors are logged but not fatal.
+func (s *BytesServer) uploadDerivedAssets(ctx context.Context, bucket *storage.BucketHandle, photoObject *database.PhotoObject, data []byte) []database.PhotoRendition {
+	if s.Jobs == nil {
+		// Failures of each asset have been logged already
+		renditions, _ := s.generateDerivedAssets(ctx, bucket, photoObject, data)
+		return renditions
+	}
+	if err := s.Jobs.Enqueue(ctx, photoObject.UserID, database.JobKindDerivedAssets, photoObject.ObjectID); err != nil {
+		slog.WarnContext(ctx, "failed to queue derived assets; photo left for backfill",
+			slog.String("object_id", photoObject.ObjectID),
+			slog.String("error", err.Error()),
+		)
+	}
+	return nil
+}
+
+// RunDerivedAssetsJob is the JobHandler of JobKindDerivedAssets jobs. It
+// downloads the photo and generates its derived assets afresh. Photos
+// deleted since the job was queued are skipped.
+func (s *BytesServer) RunDerivedAssetsJob(ctx context.Context, job *database.Job) error {
+	var photoObject database.PhotoObject
+	err := s.DB.Where("object_id = ? AND user_id = ?", job.ObjectID, job.UserID).First(&photoObject).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to find photo: %w", err)
+	}
+	if s.GCSClient == nil {
+		return fmt.Errorf("no storage bucket available for derived assets")
+	}
+
+	bucket := s.GCSClient.Bucket(s.BucketName)
+	_, readSpan := startSpan(ctx, "gcs.read_object")
+	reader, err := bucket.Object(photoObject.ObjectID).NewReader(ctx)
+	if err != nil {
+		recordSpanError(readSpan, err)
+		return fmt.Errorf("failed to read photo: %w", err)
+	}
+	data, err := io.ReadAll(reader)
+	_ = reader.Close()
+	if err != nil {
+		recordSpanError(readSpan, err)
+		return fmt.Errorf("failed to read photo data: %w", err)
+	}
+	endSpanOk(readSpan)
+
+	// Generate every asset again rather than keep those of an earlier upload
+	photoObject.ThumbnailObjectID = nil
+	photoObject.WebpObjectID = nil
+	photoObject.AvifObjectID = nil
+	photoObject.AnimatedPreviewObjectID = nil
+	photoObject.MotionVideoObjectID = nil
+	_, err = s.generateDerivedAssets(ctx, bucket, &photoObject, data)
+	return err
+}
+
 // Download downloads a file from Google Cloud Storage.
 // The object_id in DownloadRequest corresponds to the object ID in the bucket.
 func (s *BytesServer) Download(ctx context.Context, req *proto.DownloadRequest) (*proto.DownloadResponse, error) {
@@ -443,6 +600,7 @@ func (s *BytesServer) StreamingUpload(stream grpc.ClientStreamingServer[proto.St
 
 	objectID := metadata.GetFilename()
 	contentType := metadata.GetContentType()
+	conflictPolicy := metadata.GetConflictPolicy()
 
 	slog.InfoContext(
 		ctx,
@@ -479,117 +637,71 @@ func (s *BytesServer) StreamingUpload(stream grpc.ClientStreamingServer[proto.St
 
 		// Update MD5 hash
 		_, _ = md5Hasher.Write(chunk)
-	}
 
-	// Extract photo metadata from EXIF data
-	photoMetadata := ExtractPhotoMetadata(allData, objectID)
-
-	bucket := s.GCSClient.Bucket(s.BucketName)
-	obj := bucket.Object(objectID)
+		// Reject an oversized upload as soon as its type is known rather than
+		// buffering all of it first
+		if len(allData) >= contentSniffLen {
+			if err := s.UploadPolicy.CheckSize(DetectContentType(allData), int64(len(allData))); err != nil {
+				return err
+			}
+		}
+	}
 
-	_, writeSpan := startSpan(ctx, "gcs.write_object")
-	writer := obj.NewWriter(ctx)
-	writer.ContentType = contentType
-	writer.Metadata = photoMetadata.ToGCSMetadata()
+	// The stored content type is sniffed from the data, not taken from the metadata
+	contentType, err = s.detectUploadContentType(ctx, objectID, contentType, allData)
+	if err != nil {
+		return err
+	}
 
-	// Write all data to GCS
-	if _, err := writer.Write(allData); err != nil {
-		_ = writer.Close()
-		recordSpanError(writeSpan, err)
-		return status.Errorf(codes.Internal, "failed to write data to GCS: %v", err)
+	// XMP sidecars are attached to their photo rather than stored as a photo
+	if isSidecarObjectID(objectID) {
+		photo, skipped, err := storeSidecar(ctx, s.DB, s.GCSClient.Bucket(s.BucketName), userID, objectID, allData, conflictPolicy)
+		if err != nil {
+			return err
+		}
+		return stream.SendAndClose(&proto.UploadResponse{
+			Photo:   photo,
+			Skipped: skipped,
+		})
 	}
 
-	if err := writer.Close(); err != nil {
-		recordSpanError(writeSpan, err)
-		return status.Errorf(codes.Internal, "failed to close GCS writer: %v", err)
+	if err := checkQuota(ctx, s.DB, userID, objectID, 1, int64(len(allData)), replacesExisting(conflictPolicy)); err != nil {
+		return err
 	}
-	endSpanOk(writeSpan)
+
+	// Extract photo metadata from EXIF data
+	photoMetadata := ExtractPhotoMetadata(allData, objectID)
 
 	// Compute final MD5 hash
 	md5Hash := md5Hasher.Sum(nil)
 	md5HashBase64 := base64.StdEncoding.EncodeToString(md5Hash)
 
-	// Get object attributes after upload
-	_, attrsSpan := startSpan(ctx, "gcs.get_object_attrs")
-	attrs, err := obj.Attrs(ctx)
-	if err != nil {
-		recordSpanError(attrsSpan, err)
-		return status.Errorf(codes.Internal, "failed to get object attributes: %v", err)
-	}
-	endSpanOk(attrsSpan)
-
-	// Write to PhotoObject table (create or restore if soft-deleted)
-	var streamTimeTaken *time.Time
-	if photoMetadata.HasDateTaken {
-		streamTimeTaken = &photoMetadata.DateTaken
-	}
-	photoObject := createPhotoObject(objectID, attrs, userID, md5HashBase64, streamTimeTaken)
-
-	// For DNG files, generate a JPEG preview and upload it to GCS
-	if IsDNGContentType(contentType) {
-		uploadDNGPreview(ctx, bucket, allData, objectID, photoObject)
-	}
+	bucket := s.GCSClient.Bucket(s.BucketName)
 
-	// For convertible image types, generate a WebP version and upload it to GCS
-	if IsWebPConvertibleContentType(contentType) {
-		uploadWebP(ctx, bucket, allData, objectID, s.WebPQuality, photoObject)
+	// Write all data to GCS, resolving a clash with an existing object per the policy
+	written, err := writeUploadObject(ctx, bucket, objectID, contentType, photoMetadata.ToGCSMetadata(), allData, md5Hash, conflictPolicy)
+	if err != nil {
+		return err
 	}
-
-	_, createSpan := startSpan(ctx, "db.create_or_restore_photo_object")
-	if err := database.CreateOrRestorePhotoObject(s.DB, photoObject); err != nil {
-		recordSpanError(createSpan, err)
-		return status.Errorf(codes.Internal, "failed to create photo object record: %v", err)
+	if written.Skipped {
+		return stream.SendAndClose(&proto.UploadResponse{
+			Photo:   s.skippedUploadPhoto(userID, written.Attrs),
+			Skipped: true,
+		})
 	}
-	endSpanOk(createSpan)
-
-	// Write to PhotoDirectory table (create or restore if soft-deleted)
-	dir := ExtractDirectoryFromPath(objectID)
-	if dir != "" {
-		_, dirSpan := startSpan(ctx, "db.create_or_restore_photo_directory")
-		if err := database.CreateOrRestorePhotoDirectory(s.DB, dir); err != nil {
-			recordSpanError(dirSpan, err)
-			return status.Errorf(codes.Internal, "failed to create photo directory record: %v", err)
-		}
-		endSpanOk(dirSpan)
+	photo, err := s.finishUpload(ctx, userID, bucket, written, md5HashBase64, photoMetadata, allData)
+	if err != nil {
+		return err
 	}
 
 	slog.InfoContext(
 		ctx,
 		"Completed streaming upload to bucket",
-		slog.String("object_id", objectID),
-		slog.Int64("size_bytes", attrs.Size),
+		slog.String("object_id", photo.ObjectId),
+		slog.Int64("size_bytes", photo.SizeBytes),
 		slog.String("md5_hash", md5HashBase64),
 	)
 
-	photo := &proto.Photo{
-		ObjectId:         objectID,
-		Filename:         objectID,
-		ContentType:      attrs.ContentType,
-		SizeBytes:        attrs.Size,
-		CreatedAt:        attrs.Created.Format(time.RFC3339),
-		UpdatedAt:        attrs.Updated.Format(time.RFC3339),
-		Md5Hash:          md5HashBase64,
-		Latitude:         photoMetadata.Latitude,
-		Longitude:        photoMetadata.Longitude,
-		HasLocation:      photoMetadata.HasLocation,
-		DateTaken:        photoMetadata.FormatDateTaken(),
-		HasDateTaken:     photoMetadata.HasDateTaken,
-		Width:            int32(photoMetadata.Width),
-		Height:           int32(photoMetadata.Height),
-		HasDimensions:    photoMetadata.HasDimensions,
-		OriginalFilename: photoMetadata.OriginalFilename,
-		CameraMake:       photoMetadata.CameraMake,
-		CameraModel:      photoMetadata.CameraModel,
-		FocalLength:      photoMetadata.FocalLength,
-		Iso:              int32(photoMetadata.ISO),
-		Aperture:         photoMetadata.Aperture,
-		ExposureTime:     photoMetadata.ExposureTime,
-		LensModel:        photoMetadata.LensModel,
-	}
-	if photoObject.WebpObjectID != nil {
-		photo.WebpObjectId = *photoObject.WebpObjectID
-	}
-
 	return stream.SendAndClose(&proto.UploadResponse{
 		Photo: photo,
 	})
@@ -636,8 +748,10 @@ func (s *BytesServer) BulkStreamingUpload(stream grpc.BidiStreamingServer[proto.
 	var (
 		currentObjectID  string
 		currentType      string
+		currentPolicy    proto.ConflictPolicy
 		currentData      []byte
 		currentMD5Hasher hash.Hash
+		currentRejected  error
 		fileStarted      bool
 	)
 
@@ -666,8 +780,10 @@ func (s *BytesServer) BulkStreamingUpload(stream grpc.BidiStreamingServer[proto.
 			} else {
 				currentObjectID = d.Metadata.GetFilename()
 				currentType = d.Metadata.GetContentType()
+				currentPolicy = d.Metadata.GetConflictPolicy()
 				currentData = nil
 				currentMD5Hasher = md5.New()
+				currentRejected = nil
 				fileStarted = true
 			}
 
@@ -676,9 +792,21 @@ func (s *BytesServer) BulkStreamingUpload(stream grpc.BidiStreamingServer[proto.
 				slog.WarnContext(ctx, "bulk upload: received chunk before metadata, ignoring")
 				continue
 			}
+			if currentRejected != nil {
+				continue
+			}
 			currentData = append(currentData, d.Chunk...)
 			_, _ = currentMD5Hasher.Write(d.Chunk)
 
+			// Reject an oversized file as soon as its type is known rather than
+			// buffering all of it first; the rest of its chunks are dropped
+			if len(currentData) >= contentSniffLen {
+				if err := s.UploadPolicy.CheckSize(DetectContentType(currentData), int64(len(currentData))); err != nil {
+					currentRejected = err
+					currentData = nil
+				}
+			}
+
 		case *proto.StreamingUploadRequest_EndOfFile:
 			if !fileStarted {
 				slog.WarnContext(ctx, "bulk upload: received end_of_file with no preceding metadata, ignoring")
@@ -687,17 +815,25 @@ func (s *BytesServer) BulkStreamingUpload(stream grpc.BidiStreamingServer[proto.
 			// Snapshot the current file's state so the goroutine captures its own copy.
 			objectID := currentObjectID
 			contentType := currentType
+			conflictPolicy := currentPolicy
 			data := currentData
 			md5Hasher := currentMD5Hasher
+			rejected := currentRejected
 
 			fileStarted = false
 			currentObjectID = ""
 			currentType = ""
+			currentPolicy = proto.ConflictPolicy_CONFLICT_POLICY_UNSPECIFIED
 			currentData = nil
 			currentMD5Hasher = nil
+			currentRejected = nil
 
+			if rejected != nil {
+				resultCh <- bulkUploadFailure(ctx, objectID, status.Convert(rejected).Message())
+				continue
+			}
 			wg.Go(func() {
-				result := s.uploadSingleFile(ctx, userID, objectID, contentType, data, md5Hasher)
+				result := s.uploadSingleFile(ctx, userID, objectID, contentType, conflictPolicy, data, md5Hasher)
 				resultCh <- result
 			})
 		}
@@ -722,27 +858,21 @@ func (s *BytesServer) BulkStreamingUpload(stream grpc.BidiStreamingServer[proto.
 }
 
 // uploadSingleFile performs the full upload pipeline for one file: EXIF extraction,
-// GCS write, database entry creation, and optional DNG preview generation.
+// GCS write, database entry creation, and optional RAW and HEIC preview generation.
 // It returns a BulkUploadFileResult so errors are reported per-file rather than
 // aborting the entire bulk upload.
 func (s *BytesServer) uploadSingleFile(
 	ctx context.Context,
 	userID uint,
 	objectID, contentType string,
+	conflictPolicy proto.ConflictPolicy,
 	data []byte,
 	md5Hasher hash.Hash,
 ) *proto.BulkUploadFileResult {
+	// Results are keyed by the requested object ID even if the upload is renamed.
+	requestedObjectID := objectID
 	failResult := func(format string, args ...any) *proto.BulkUploadFileResult {
-		msg := fmt.Sprintf(format, args...)
-		slog.ErrorContext(ctx, "bulk upload: file failed",
-			slog.String("object_id", objectID),
-			slog.String("error", msg),
-		)
-		return &proto.BulkUploadFileResult{
-			ObjectId:     objectID,
-			Success:      false,
-			ErrorMessage: msg,
-		}
+		return bulkUploadFailure(ctx, requestedObjectID, fmt.Sprintf(format, args...))
 	}
 
 	slog.InfoContext(ctx, "bulk upload: starting file upload",
@@ -750,81 +880,136 @@ func (s *BytesServer) uploadSingleFile(
 		slog.String("content_type", contentType),
 	)
 
+	// The stored content type is sniffed from the data, not taken from the metadata.
+	contentType, err := s.detectUploadContentType(ctx, objectID, contentType, data)
+	if err != nil {
+		return failResult("%s", status.Convert(err).Message())
+	}
+
+	// XMP sidecars are attached to their photo rather than stored as a photo.
+	if isSidecarObjectID(objectID) {
+		photo, skipped, err := storeSidecar(ctx, s.DB, s.GCSClient.Bucket(s.BucketName), userID, objectID, data, conflictPolicy)
+		if err != nil {
+			return failResult("%s", status.Convert(err).Message())
+		}
+		return &proto.BulkUploadFileResult{
+			ObjectId: objectID,
+			Success:  true,
+			Photo:    photo,
+			Skipped:  skipped,
+		}
+	}
+
+	if err := checkQuota(ctx, s.DB, userID, objectID, 1, int64(len(data)), replacesExisting(conflictPolicy)); err != nil {
+		return failResult("%s", status.Convert(err).Message())
+	}
+
 	// Extract photo metadata from EXIF data.
 	photoMetadata := ExtractPhotoMetadata(data, objectID)
 
-	bucket := s.GCSClient.Bucket(s.BucketName)
-	obj := bucket.Object(objectID)
+	// Compute the final MD5 hash.
+	md5Hash := md5Hasher.Sum(nil)
+	md5HashBase64 := base64.StdEncoding.EncodeToString(md5Hash)
 
-	_, writeSpan := startSpan(ctx, "gcs.write_object")
-	writer := obj.NewWriter(ctx)
-	writer.ContentType = contentType
-	writer.Metadata = photoMetadata.ToGCSMetadata()
+	bucket := s.GCSClient.Bucket(s.BucketName)
 
-	if _, err := writer.Write(data); err != nil {
-		_ = writer.Close()
-		recordSpanError(writeSpan, err)
-		return failResult("failed to write data to GCS: %v", err)
+	// Write to GCS, resolving a clash with an existing object per the policy.
+	written, err := writeUploadObject(ctx, bucket, objectID, contentType, photoMetadata.ToGCSMetadata(), data, md5Hash, conflictPolicy)
+	if err != nil {
+		return failResult("%s", status.Convert(err).Message())
 	}
-	if err := writer.Close(); err != nil {
-		recordSpanError(writeSpan, err)
-		return failResult("failed to close GCS writer: %v", err)
+	if written.Skipped {
+		return &proto.BulkUploadFileResult{
+			ObjectId: objectID,
+			Success:  true,
+			Photo:    s.skippedUploadPhoto(userID, written.Attrs),
+			Skipped:  true,
+		}
+	}
+	photo, err := s.finishUpload(ctx, userID, bucket, written, md5HashBase64, photoMetadata, data)
+	if err != nil {
+		return failResult("%s", status.Convert(err).Message())
 	}
-	endSpanOk(writeSpan)
 
-	// Compute the final MD5 hash.
-	md5HashBase64 := base64.StdEncoding.EncodeToString(md5Hasher.Sum(nil))
+	slog.InfoContext(ctx, "bulk upload: file upload completed",
+		slog.String("object_id", photo.ObjectId),
+		slog.Int64("size_bytes", photo.SizeBytes),
+		slog.String("md5_hash", md5HashBase64),
+	)
 
-	// Fetch GCS object attributes confirmed after the upload.
-	_, attrsSpan := startSpan(ctx, "gcs.get_object_attrs")
-	attrs, err := obj.Attrs(ctx)
-	if err != nil {
-		recordSpanError(attrsSpan, err)
-		return failResult("failed to get object attributes: %v", err)
+	return &proto.BulkUploadFileResult{
+		ObjectId: requestedObjectID,
+		Success:  true,
+		Photo:    photo,
 	}
-	endSpanOk(attrsSpan)
+}
 
+// bulkUploadFailure logs and returns the failed BulkUploadFileResult of
+// objectID.
+func bulkUploadFailure(ctx context.Context, objectID, msg string) *proto.BulkUploadFileResult {
+	slog.ErrorContext(ctx, "bulk upload: file failed",
+		slog.String("object_id", objectID),
+		slog.String("error", msg),
+	)
+	return &proto.BulkUploadFileResult{
+		ObjectId:     objectID,
+		Success:      false,
+		ErrorMessage: msg,
+	}
+}
+
+// finishUpload records an original that has been written to GCS: it creates
+// its PhotoObject and directory records, generates its derived assets (or
+// queues them), attaches its sidecar, pairs it with its Live Photo companion
+// and restacks it. It returns the proto.Photo describing the upload. The
+// returned errors are gRPC status errors.
+func (s *BytesServer) finishUpload(
+	ctx context.Context,
+	userID uint,
+	bucket *storage.BucketHandle,
+	written *uploadWrite,
+	md5HashBase64 string,
+	photoMetadata *PhotoMetadataInfo,
+	data []byte,
+) (*proto.Photo, error) {
+	objectID := written.ObjectID
+	attrs := written.Attrs
+
+	// Write to PhotoObject table (create or restore if soft-deleted)
 	var timeTaken *time.Time
 	if photoMetadata.HasDateTaken {
 		timeTaken = &photoMetadata.DateTaken
 	}
 	photoObject := createPhotoObject(objectID, attrs, userID, md5HashBase64, timeTaken)
 
-	// For DNG files, generate a JPEG preview and upload it to GCS.
-	if IsDNGContentType(contentType) {
-		uploadDNGPreview(ctx, bucket, data, objectID, photoObject)
-	}
-
-	// For convertible image types, generate a WebP version and upload it to GCS.
-	if IsWebPConvertibleContentType(contentType) {
-		uploadWebP(ctx, bucket, data, objectID, s.WebPQuality, photoObject)
-	}
-
-	// Create the database entry immediately — this is the key behaviour: the entry
-	// is written as soon as this file's upload completes, not after the full batch.
 	_, createSpan := startSpan(ctx, "db.create_or_restore_photo_object")
-	if err := database.CreateOrRestorePhotoObject(s.DB, photoObject); err != nil {
+	if err := withPhotoChange(s.DB, userID, database.ChangeKindCreated, objectID, func(tx *gorm.DB) error {
+		return database.CreateOrRestorePhotoObject(tx, photoObject)
+	}); err != nil {
 		recordSpanError(createSpan, err)
-		return failResult("failed to create photo object record: %v", err)
+		return nil, status.Errorf(codes.Internal, "failed to create photo object record: %v", err)
 	}
 	endSpanOk(createSpan)
+	forgetReplacedDerivedObject(ctx, s.DB, userID, objectID)
 
+	// Generate the previews, renditions and thumbnails, in the background
+	// if there is a job queue
+	renditions := s.uploadDerivedAssets(ctx, bucket, photoObject, data)
+	if s.Transcoder != nil && IsVideoContentType(photoObject.ContentType) {
+		s.Transcoder.Enqueue(ctx, userID, objectID)
+	}
+
+	// Write to PhotoDirectory table (create or restore if soft-deleted)
 	dir := ExtractDirectoryFromPath(objectID)
 	if dir != "" {
 		_, dirSpan := startSpan(ctx, "db.create_or_restore_photo_directory")
 		if err := database.CreateOrRestorePhotoDirectory(s.DB, dir); err != nil {
 			recordSpanError(dirSpan, err)
-			return failResult("failed to create photo directory record: %v", err)
+			return nil, status.Errorf(codes.Internal, "failed to create photo directory record: %v", err)
 		}
 		endSpanOk(dirSpan)
 	}
 
-	slog.InfoContext(ctx, "bulk upload: file upload completed",
-		slog.String("object_id", objectID),
-		slog.Int64("size_bytes", attrs.Size),
-		slog.String("md5_hash", md5HashBase64),
-	)
-
 	photo := &proto.Photo{
 		ObjectId:         objectID,
 		Filename:         objectID,
@@ -853,12 +1038,16 @@ func (s *BytesServer) uploadSingleFile(
 	if photoObject.WebpObjectID != nil {
 		photo.WebpObjectId = *photoObject.WebpObjectID
 	}
-
-	return &proto.BulkUploadFileResult{
-		ObjectId: objectID,
-		Success:  true,
-		Photo:    photo,
+	if photoObject.AvifObjectID != nil {
+		photo.AvifObjectId = *photoObject.AvifObjectID
 	}
+	applySidecar(photo, attachSidecar(ctx, s.DB, userID, objectID))
+	pairLivePhoto(ctx, s.DB, photoObject)
+	photo.CompanionObjectId = companionObjectID(photoObject)
+	restackPhoto(ctx, s.DB, userID, objectID)
+
+	photo.Renditions = renditionsToProto(renditions)
+	return photo, nil
 }
 
 const defaultDownloadChunkSize = 64 * 1024 // 64 KB
@@ -883,12 +1072,18 @@ func (s *BytesServer) StreamingDownload(req *proto.StreamingDownloadRequest, str
 	}
 
 	stripLocation := req.GetStripLocation()
+	offset, length := req.GetOffset(), req.GetLength()
+	if err := validateDownloadRange(offset, length, stripLocation); err != nil {
+		return err
+	}
 
 	slog.InfoContext(
 		ctx,
 		"Starting streaming download from bucket",
 		slog.String("object_id", objectID),
 		slog.Bool("strip_location", stripLocation),
+		slog.Int64("offset", offset),
+		slog.Int64("length", length),
 	)
 
 	bucket := s.GCSClient.Bucket(s.BucketName)
@@ -906,18 +1101,40 @@ func (s *BytesServer) StreamingDownload(req *proto.StreamingDownloadRequest, str
 	}
 	endSpanOk(attrsSpan)
 
-	// Create reader for streaming
+	// Parse stored metadata from GCS object attributes
+	photoMetadata := ParseGCSMetadata(attrs.Metadata)
+
+	if req.GetMetadataOnly() {
+		metadataResp := &proto.StreamingDownloadResponse{
+			Data: &proto.StreamingDownloadResponse_Metadata{
+				Metadata: streamingDownloadPhoto(objectID, attrs, photoMetadata),
+			},
+		}
+		if err := stream.Send(metadataResp); err != nil {
+			return status.Errorf(codes.Internal, "failed to send metadata: %v", err)
+		}
+		return nil
+	}
+
+	if offset > 0 && offset >= attrs.Size {
+		return status.Errorf(codes.OutOfRange, "offset %d is beyond the end of %s (%d bytes)", offset, objectID, attrs.Size)
+	}
+
+	// Create reader for streaming; a length of -1 reads to the end
+	rangeLength := int64(-1)
+	if length > 0 {
+		rangeLength = length
+	}
+	// Read the generation the metadata describes, even if the object is
+	// replaced meanwhile
 	_, readSpan := startSpan(ctx, "gcs.read_object")
-	reader, err := obj.NewReader(ctx)
+	reader, err := obj.Generation(attrs.Generation).NewRangeReader(ctx, offset, rangeLength)
 	if err != nil {
 		recordSpanError(readSpan, err)
 		return status.Errorf(codes.Internal, "failed to create reader for object: %v", err)
 	}
 	defer func() { _ = reader.Close() }()
 
-	// Parse stored metadata from GCS object attributes
-	photoMetadata := ParseGCSMetadata(attrs.Metadata)
-
 	// If strip_location is requested, we need to read the entire file, process it, then stream
 	if stripLocation {
 		err := s.streamDownloadWithLocationStripped(stream, reader, attrs, photoMetadata, objectID)
@@ -939,26 +1156,34 @@ func (s *BytesServer) StreamingDownload(req *proto.StreamingDownloadRequest, str
 	return err
 }
 
-// streamDownloadDirect streams the file directly from GCS without modification.
-func (s *BytesServer) streamDownloadDirect(
-	stream grpc.ServerStreamingServer[proto.StreamingDownloadResponse],
-	reader io.Reader,
-	attrs *storage.ObjectAttrs,
-	photoMetadata *PhotoMetadataInfo,
-	objectID string,
-) error {
-	// Compute MD5 hash from stored attributes (GCS stores MD5 hash)
-	md5HashBase64 := base64.StdEncoding.EncodeToString(attrs.MD5)
+// validateDownloadRange checks the offset and length of a ranged
+// StreamingDownload. A range cannot be combined with strip_location, as
+// stripping changes the size and offsets of the data.
+func validateDownloadRange(offset, length int64, stripLocation bool) error {
+	if length < 0 {
+		return status.Errorf(codes.InvalidArgument, "length must not be negative")
+	}
+	if offset < 0 && length != 0 {
+		return status.Errorf(codes.InvalidArgument, "length cannot be set with a negative (suffix) offset")
+	}
+	if stripLocation && (offset != 0 || length != 0) {
+		return status.Errorf(codes.InvalidArgument, "offset and length cannot be combined with strip_location")
+	}
+	return nil
+}
 
-	// Send metadata as the first message
-	photo := &proto.Photo{
+// streamingDownloadPhoto builds the metadata message of a StreamingDownload
+// from the stored object. SizeBytes and Md5Hash describe the whole object,
+// even when only a range of it is streamed.
+func streamingDownloadPhoto(objectID string, attrs *storage.ObjectAttrs, photoMetadata *PhotoMetadataInfo) *proto.Photo {
+	return &proto.Photo{
 		ObjectId:         objectID,
 		Filename:         objectID,
 		ContentType:      attrs.ContentType,
 		SizeBytes:        attrs.Size,
 		CreatedAt:        attrs.Created.Format(time.RFC3339),
 		UpdatedAt:        attrs.Updated.Format(time.RFC3339),
-		Md5Hash:          md5HashBase64,
+		Md5Hash:          base64.StdEncoding.EncodeToString(attrs.MD5),
 		Latitude:         photoMetadata.Latitude,
 		Longitude:        photoMetadata.Longitude,
 		HasLocation:      photoMetadata.HasLocation,
@@ -976,10 +1201,20 @@ func (s *BytesServer) streamDownloadDirect(
 		ExposureTime:     photoMetadata.ExposureTime,
 		LensModel:        photoMetadata.LensModel,
 	}
+}
 
+// streamDownloadDirect streams the file directly from GCS without modification.
+func (s *BytesServer) streamDownloadDirect(
+	stream grpc.ServerStreamingServer[proto.StreamingDownloadResponse],
+	reader io.Reader,
+	attrs *storage.ObjectAttrs,
+	photoMetadata *PhotoMetadataInfo,
+	objectID string,
+) error {
+	// Send metadata as the first message
 	metadataResp := &proto.StreamingDownloadResponse{
 		Data: &proto.StreamingDownloadResponse_Metadata{
-			Metadata: photo,
+			Metadata: streamingDownloadPhoto(objectID, attrs, photoMetadata),
 		},
 	}
 
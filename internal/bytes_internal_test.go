@@ -387,3 +387,56 @@ func TestBulkStreamingUpload_EmptyStreamSucceeds(t *testing.T) {
 		t.Errorf("expected no results, got %d", len(stream.sentResults))
 	}
 }
+
+// TestBulkStreamingUpload_OversizedFileRejected verifies that a file over the
+// size limit for its type fails on its own while it is still being received.
+func TestBulkStreamingUpload_OversizedFileRejected(t *testing.T) {
+	server := &BytesServer{UploadPolicy: UploadPolicy{MaxSizeBytes: map[string]int64{"image/*": 100}}}
+	jpeg := append([]byte{0xFF, 0xD8, 0xFF, 0xE0}, make([]byte, contentSniffLen)...)
+	msgs := []*proto.StreamingUploadRequest{
+		bulkMetadataMsg("big.jpg", "image/jpeg"),
+		bulkChunkMsg(jpeg),
+		bulkChunkMsg(make([]byte, contentSniffLen)),
+		bulkEofMsg(),
+	}
+	stream := newMockBulkUploadStream(bulkUploadCtxWithUserID(1), msgs)
+
+	if err := server.BulkStreamingUpload(stream); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(stream.sentResults) != 1 {
+		t.Fatalf("expected 1 result, got %d", len(stream.sentResults))
+	}
+	result := stream.sentResults[0]
+	if result.GetSuccess() || result.GetObjectId() != "big.jpg" || result.GetErrorMessage() == "" {
+		t.Errorf("result = %+v, want a failure for big.jpg", result)
+	}
+}
+
+func TestValidateDownloadRange(t *testing.T) {
+	tests := []struct {
+		name          string
+		offset        int64
+		length        int64
+		stripLocation bool
+		expectError   bool
+	}{
+		{"whole object", 0, 0, false, false},
+		{"offset and length", 10, 5, false, false},
+		{"suffix", -5, 0, false, false},
+		{"negative length", 0, -1, false, true},
+		{"suffix with length", -5, 2, false, true},
+		{"strip location with range", 10, 0, true, true},
+		{"strip location without range", 0, 0, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateDownloadRange(tt.offset, tt.length, tt.stripLocation)
+			if tt.expectError {
+				assertGRPCError(t, err, codes.InvalidArgument)
+			} else if err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package internal
+
+import (
+	"context"
+	"log/slog"
+	"os/exec"
+
+	"github.com/alexhokl/photos/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Names of the external tools the server shells out to
+const (
+	ToolCWebP       = "cwebp"
+	ToolDCRaw       = "dcraw"
+	ToolFFprobe     = "ffprobe"
+	ToolFFmpeg      = "ffmpeg"
+	ToolHeifConvert = "heif-convert"
+	ToolAVIFEnc     = "avifenc"
+)
+
+// externalTool is an external tool, the feature it provides and the
+// in-process fallback used when it is missing.
+type externalTool struct {
+	name    string
+	feature string
+	// fallback is empty if the feature is unavailable without the tool
+	fallback string
+}
+
+// externalTools are the tools probed by ProbeCapabilities.
+var externalTools = []externalTool{
+	{name: ToolCWebP, feature: "webp", fallback: "JPEG web renditions encoded in Go"},
+	{name: ToolDCRaw, feature: "raw_preview", fallback: "embedded RAW previews extracted in Go"},
+	{name: ToolFFprobe, feature: "video_metadata", fallback: "MP4 and QuickTime metadata read in Go"},
+	{name: ToolFFmpeg, feature: "video_thumbnail"},
+	{name: ToolHeifConvert, feature: "heic_preview", fallback: "HEIC metadata read and location stripped in Go, without previews"},
+	{name: ToolAVIFEnc, feature: "avif"},
+}
+
+// Capabilities records the external tools found on the host.
+type Capabilities struct {
+	// Tools maps the name of each tool found to its path
+	Tools map[string]string
+}
+
+// ProbeCapabilities looks up each external tool in PATH.
+func ProbeCapabilities() *Capabilities {
+	caps := &Capabilities{Tools: make(map[string]string)}
+	for _, tool := range externalTools {
+		if toolPath, err := exec.LookPath(tool.name); err == nil {
+			caps.Tools[tool.name] = toolPath
+		}
+	}
+	return caps
+}
+
+// Has reports whether tool was found. A nil Capabilities has not been probed
+// and assumes every tool is installed, leaving a missing tool to be detected
+// when it is run.
+func (c *Capabilities) Has(tool string) bool {
+	if c == nil {
+		return true
+	}
+	_, ok := c.Tools[tool]
+	return ok
+}
+
+// LogMissing logs each missing tool and how the feature it provides degrades.
+func (c *Capabilities) LogMissing(ctx context.Context) {
+	for _, tool := range externalTools {
+		if c.Has(tool.name) {
+			continue
+		}
+		if tool.fallback == "" {
+			slog.WarnContext(ctx, "external tool not found; feature unavailable",
+				slog.String("tool", tool.name),
+				slog.String("feature", tool.feature),
+			)
+			continue
+		}
+		slog.InfoContext(ctx, "external tool not found; using fallback",
+			slog.String("tool", tool.name),
+			slog.String("feature", tool.feature),
+			slog.String("fallback", tool.fallback),
+		)
+	}
+}
+
+// toProto describes how each feature depending on an external tool is
+// provided.
+func (c *Capabilities) toProto() []*proto.ServerCapability {
+	capabilities := make([]*proto.ServerCapability, 0, len(externalTools))
+	for _, tool := range externalTools {
+		capability := &proto.ServerCapability{
+			Feature:  tool.feature,
+			Tool:     tool.name,
+			Fallback: tool.fallback,
+		}
+		switch {
+		case c.Has(tool.name):
+			capability.Provider = proto.ServerCapability_PROVIDER_EXTERNAL
+			if c != nil {
+				capability.ToolPath = c.Tools[tool.name]
+			}
+		case tool.fallback != "":
+			capability.Provider = proto.ServerCapability_PROVIDER_FALLBACK
+		default:
+			capability.Provider = proto.ServerCapability_PROVIDER_UNAVAILABLE
+		}
+		capabilities = append(capabilities, capability)
+	}
+	return capabilities
+}
+
+// GetServerCapabilities reports the external tools found when the server
+// started and how each feature depending on them is provided.
+func (s *LibraryServer) GetServerCapabilities(ctx context.Context, req *proto.GetServerCapabilitiesRequest) (*proto.GetServerCapabilitiesResponse, error) {
+	if _, ok := ctx.Value(contextKeyUser{}).(uint); !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "authentication required")
+	}
+
+	return &proto.GetServerCapabilitiesResponse{
+		Capabilities: s.Capabilities.toProto(),
+	}, nil
+}
@@ -0,0 +1,68 @@
+package internal
+
+import (
+	"context"
+	"testing"
+
+	"github.com/alexhokl/photos/proto"
+	"google.golang.org/grpc/codes"
+)
+
+func TestCapabilities_Has(t *testing.T) {
+	var unprobed *Capabilities
+	if !unprobed.Has(ToolCWebP) {
+		t.Error("expected an unprobed Capabilities to assume cwebp is installed")
+	}
+
+	caps := &Capabilities{Tools: map[string]string{ToolDCRaw: "/usr/bin/dcraw"}}
+	if !caps.Has(ToolDCRaw) {
+		t.Error("expected dcraw to be found")
+	}
+	if caps.Has(ToolCWebP) {
+		t.Error("expected cwebp to be missing")
+	}
+}
+
+func TestGetServerCapabilities(t *testing.T) {
+	server := &LibraryServer{Capabilities: &Capabilities{Tools: map[string]string{ToolCWebP: "/usr/bin/cwebp"}}}
+
+	resp, err := server.GetServerCapabilities(contextWithUserID(1), &proto.GetServerCapabilitiesRequest{})
+	if err != nil {
+		t.Fatalf("GetServerCapabilities returned error: %v", err)
+	}
+
+	got := make(map[string]*proto.ServerCapability)
+	for _, capability := range resp.GetCapabilities() {
+		got[capability.GetTool()] = capability
+	}
+	expected := map[string]proto.ServerCapability_Provider{
+		ToolCWebP:       proto.ServerCapability_PROVIDER_EXTERNAL,
+		ToolDCRaw:       proto.ServerCapability_PROVIDER_FALLBACK,
+		ToolFFprobe:     proto.ServerCapability_PROVIDER_FALLBACK,
+		ToolFFmpeg:      proto.ServerCapability_PROVIDER_UNAVAILABLE,
+		ToolHeifConvert: proto.ServerCapability_PROVIDER_FALLBACK,
+		ToolAVIFEnc:     proto.ServerCapability_PROVIDER_UNAVAILABLE,
+	}
+	if len(got) != len(expected) {
+		t.Fatalf("expected %d capabilities, got %d", len(expected), len(got))
+	}
+	for tool, provider := range expected {
+		if got[tool].GetProvider() != provider {
+			t.Errorf("%s provider = %s, want %s", tool, got[tool].GetProvider(), provider)
+		}
+	}
+	if got[ToolCWebP].GetToolPath() != "/usr/bin/cwebp" {
+		t.Errorf("cwebp path = %q, want /usr/bin/cwebp", got[ToolCWebP].GetToolPath())
+	}
+	if got[ToolDCRaw].GetFallback() == "" {
+		t.Error("expected dcraw fallback to be described")
+	}
+}
+
+func TestGetServerCapabilities_Unauthenticated(t *testing.T) {
+	server := &LibraryServer{}
+
+	_, err := server.GetServerCapabilities(context.Background(), &proto.GetServerCapabilitiesRequest{})
+
+	assertGRPCError(t, err, codes.Unauthenticated)
+}
@@ -0,0 +1,176 @@
+package internal
+
+import (
+	"context"
+	"encoding/base64"
+	"strconv"
+
+	"github.com/alexhokl/photos/database"
+	"github.com/alexhokl/photos/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+const (
+	// defaultChangesPageSize is the number of changes GetChanges reads if
+	// no page size is given
+	defaultChangesPageSize = 1000
+	// maxChangesPageSize is the most changes GetChanges reads at once
+	maxChangesPageSize = 10000
+)
+
+// recordPhotoChange appends a change of kind to each of objectIDs to the
+// change log of the user. It is written by the transaction that makes the
+// change, so that the log misses no change that has been made.
+func recordPhotoChange(tx *gorm.DB, userID uint, kind string, objectIDs ...string) error {
+	if len(objectIDs) == 0 {
+		return nil
+	}
+	changes := make([]database.PhotoChange, 0, len(objectIDs))
+	for _, objectID := range objectIDs {
+		changes = append(changes, database.PhotoChange{
+			UserID:   userID,
+			ObjectID: objectID,
+			Kind:     kind,
+		})
+	}
+	return tx.Create(&changes).Error
+}
+
+// withPhotoChange makes a change to objectID and records it in the change
+// log of the user in one transaction.
+func withPhotoChange(db *gorm.DB, userID uint, kind, objectID string, change func(tx *gorm.DB) error) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := change(tx); err != nil {
+			return err
+		}
+		return recordPhotoChange(tx, userID, kind, objectID)
+	})
+}
+
+// encodeChangeToken returns the token of the changes up to and including
+// the change id.
+func encodeChangeToken(id uint) string {
+	return base64.StdEncoding.EncodeToString([]byte(strconv.FormatUint(uint64(id), 10)))
+}
+
+// decodeChangeToken returns the ID of the last change covered by token.
+func decodeChangeToken(token string) (uint, error) {
+	decoded, err := base64.StdEncoding.DecodeString(token)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.ParseUint(string(decoded), 10, 0)
+	if err != nil {
+		return 0, err
+	}
+	return uint(id), nil
+}
+
+// compactPhotoChanges lists each photo changed once, by its last change, in
+// order of its first change: a photo whose last change is a deletion as
+// deleted, otherwise one created by any of the changes as created, and
+// otherwise as updated.
+func compactPhotoChanges(changes []database.PhotoChange) (created, updated, deleted []string) {
+	type photoState struct {
+		created bool
+		last    string
+	}
+	states := make(map[string]*photoState)
+	var order []string
+	for _, change := range changes {
+		state, ok := states[change.ObjectID]
+		if !ok {
+			state = &photoState{}
+			states[change.ObjectID] = state
+			order = append(order, change.ObjectID)
+		}
+		if change.Kind == database.ChangeKindCreated {
+			state.created = true
+		}
+		state.last = change.Kind
+	}
+
+	for _, objectID := range order {
+		state := states[objectID]
+		switch {
+		case state.last == database.ChangeKindDeleted:
+			deleted = append(deleted, objectID)
+		case state.created:
+			created = append(created, objectID)
+		default:
+			updated = append(updated, objectID)
+		}
+	}
+	return created, updated, deleted
+}
+
+// GetChanges returns the photos of the authenticated user created, updated
+// and deleted since since_token (see compactPhotoChanges), reading at most
+// page_size changes, with the token to get the changes after them. Without
+// a token only the token of the latest change is returned, for a client
+// about to list its photos to start from.
+func (s *LibraryServer) GetChanges(ctx context.Context, req *proto.GetChangesRequest) (*proto.GetChangesResponse, error) {
+	userID, ok := ctx.Value(contextKeyUser{}).(uint)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "authentication required")
+	}
+
+	if req.GetSinceToken() == "" {
+		var latest uint
+		_, latestSpan := startSpan(ctx, "db.get_latest_photo_change")
+		if err := s.DB.Model(&database.PhotoChange{}).
+			Where("user_id = ?", userID).
+			Select("COALESCE(MAX(id), 0)").
+			Scan(&latest).Error; err != nil {
+			recordSpanError(latestSpan, err)
+			return nil, status.Errorf(codes.Internal, "failed to get latest change: %v", err)
+		}
+		endSpanOk(latestSpan)
+		return &proto.GetChangesResponse{NextToken: encodeChangeToken(latest)}, nil
+	}
+
+	since, err := decodeChangeToken(req.GetSinceToken())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid since token")
+	}
+
+	pageSize := int(req.GetPageSize())
+	if pageSize <= 0 {
+		pageSize = defaultChangesPageSize
+	}
+	if pageSize > maxChangesPageSize {
+		pageSize = maxChangesPageSize
+	}
+
+	// One more change than the page is read to tell whether there are more
+	var changes []database.PhotoChange
+	_, listSpan := startSpan(ctx, "db.list_photo_changes")
+	if err := s.DB.Where("user_id = ? AND id > ?", userID, since).
+		Order("id").
+		Limit(pageSize + 1).
+		Find(&changes).Error; err != nil {
+		recordSpanError(listSpan, err)
+		return nil, status.Errorf(codes.Internal, "failed to list changes: %v", err)
+	}
+	endSpanOk(listSpan)
+
+	hasMore := len(changes) > pageSize
+	if hasMore {
+		changes = changes[:pageSize]
+	}
+	next := since
+	if len(changes) > 0 {
+		next = changes[len(changes)-1].ID
+	}
+
+	created, updated, deleted := compactPhotoChanges(changes)
+	return &proto.GetChangesResponse{
+		CreatedObjectIds: created,
+		UpdatedObjectIds: updated,
+		DeletedObjectIds: deleted,
+		NextToken:        encodeChangeToken(next),
+		HasMore:          hasMore,
+	}, nil
+}
@@ -0,0 +1,198 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/alexhokl/photos/database"
+	"github.com/alexhokl/photos/proto"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc/codes"
+)
+
+func TestCompactPhotoChanges(t *testing.T) {
+	changes := []database.PhotoChange{
+		{ObjectID: "2024/a.jpg", Kind: database.ChangeKindUpdated},
+		{ObjectID: "2024/b.jpg", Kind: database.ChangeKindCreated},
+		{ObjectID: "2024/c.jpg", Kind: database.ChangeKindCreated},
+		{ObjectID: "2024/b.jpg", Kind: database.ChangeKindUpdated},
+		{ObjectID: "2024/c.jpg", Kind: database.ChangeKindDeleted},
+		{ObjectID: "2024/d.jpg", Kind: database.ChangeKindDeleted},
+		{ObjectID: "2024/d.jpg", Kind: database.ChangeKindCreated},
+		{ObjectID: "2024/a.jpg", Kind: database.ChangeKindUpdated},
+	}
+
+	created, updated, deleted := compactPhotoChanges(changes)
+	if want := []string{"2024/b.jpg", "2024/d.jpg"}; !slices.Equal(created, want) {
+		t.Errorf("created = %v, want %v", created, want)
+	}
+	if want := []string{"2024/a.jpg"}; !slices.Equal(updated, want) {
+		t.Errorf("updated = %v, want %v", updated, want)
+	}
+	if want := []string{"2024/c.jpg"}; !slices.Equal(deleted, want) {
+		t.Errorf("deleted = %v, want %v", deleted, want)
+	}
+}
+
+func TestChangeToken(t *testing.T) {
+	for _, id := range []uint{0, 1, 12345} {
+		got, err := decodeChangeToken(encodeChangeToken(id))
+		if err != nil || got != id {
+			t.Errorf("decodeChangeToken(encodeChangeToken(%d)) = %d, %v", id, got, err)
+		}
+	}
+	for _, token := range []string{"not base64!", "YWJj", "LTE="} {
+		if _, err := decodeChangeToken(token); err == nil {
+			t.Errorf("decodeChangeToken(%q) succeeded, want an error", token)
+		}
+	}
+}
+
+func TestGetChanges(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	server := &LibraryServer{DB: db}
+
+	_, err := server.GetChanges(context.Background(), &proto.GetChangesRequest{})
+	assertGRPCError(t, err, codes.Unauthenticated)
+
+	ctx := contextWithUserID(1)
+	_, err = server.GetChanges(ctx, &proto.GetChangesRequest{SinceToken: "not a token"})
+	assertGRPCError(t, err, codes.InvalidArgument)
+
+	// Without a token only the token to start from is returned
+	start, err := server.GetChanges(ctx, &proto.GetChangesRequest{})
+	if err != nil {
+		t.Fatalf("GetChanges() error = %v", err)
+	}
+	if start.GetNextToken() != encodeChangeToken(0) || start.GetHasMore() {
+		t.Errorf("GetChanges() without token = %v, want the token of no changes", start)
+	}
+
+	for _, change := range []database.PhotoChange{
+		{UserID: 1, Kind: database.ChangeKindCreated, ObjectID: "2024/a.jpg"},
+		{UserID: 2, Kind: database.ChangeKindCreated, ObjectID: "2024/other.jpg"},
+		{UserID: 1, Kind: database.ChangeKindCreated, ObjectID: "2024/b.jpg"},
+		{UserID: 1, Kind: database.ChangeKindDeleted, ObjectID: "2024/a.jpg"},
+	} {
+		if err := recordPhotoChange(db, change.UserID, change.Kind, change.ObjectID); err != nil {
+			t.Fatalf("recordPhotoChange() error = %v", err)
+		}
+	}
+
+	first, err := server.GetChanges(ctx, &proto.GetChangesRequest{SinceToken: start.GetNextToken(), PageSize: 2})
+	if err != nil {
+		t.Fatalf("GetChanges() error = %v", err)
+	}
+	if !slices.Equal(first.GetCreatedObjectIds(), []string{"2024/a.jpg", "2024/b.jpg"}) || len(first.GetDeletedObjectIds()) != 0 || !first.GetHasMore() {
+		t.Errorf("first page = %v, want 2024/a.jpg and 2024/b.jpg created and more", first)
+	}
+
+	second, err := server.GetChanges(ctx, &proto.GetChangesRequest{SinceToken: first.GetNextToken(), PageSize: 2})
+	if err != nil {
+		t.Fatalf("GetChanges() error = %v", err)
+	}
+	if !slices.Equal(second.GetDeletedObjectIds(), []string{"2024/a.jpg"}) || len(second.GetCreatedObjectIds()) != 0 || second.GetHasMore() {
+		t.Errorf("second page = %v, want 2024/a.jpg deleted and no more", second)
+	}
+
+	// Without changes since, the same token is returned
+	third, err := server.GetChanges(ctx, &proto.GetChangesRequest{SinceToken: second.GetNextToken()})
+	if err != nil {
+		t.Fatalf("GetChanges() error = %v", err)
+	}
+	if third.GetNextToken() != second.GetNextToken() || len(third.GetCreatedObjectIds())+len(third.GetUpdatedObjectIds())+len(third.GetDeletedObjectIds()) != 0 {
+		t.Errorf("GetChanges() without changes = %v, want no changes and the same token", third)
+	}
+
+	// A client starting now gets the token of the latest change of its user
+	latest, err := server.GetChanges(ctx, &proto.GetChangesRequest{})
+	if err != nil {
+		t.Fatalf("GetChanges() error = %v", err)
+	}
+	if latest.GetNextToken() != second.GetNextToken() {
+		t.Errorf("latest token = %q, want %q", latest.GetNextToken(), second.GetNextToken())
+	}
+}
+
+// newCopyDeleteGCSClient returns a client of a fake bucket in which every
+// copy and delete succeeds and no object can be read.
+func newCopyDeleteGCSClient(t *testing.T) *storage.Client {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodDelete:
+			w.WriteHeader(http.StatusNoContent)
+		case r.Method == http.MethodPost && strings.Contains(r.URL.Path, "/rewriteTo/"):
+			dest := r.URL.Path[strings.LastIndex(r.URL.Path, "/o/")+len("/o/"):]
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"done":true,"resource":{"bucket":"photos","name":%q,"size":"3","contentType":"image/jpeg","md5Hash":"rL0Y20zC+Fzt72VPzMSk2A=="}}`, dest)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":{"code":404,"message":"Not Found"}}`)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := storage.NewClient(context.Background(), option.WithEndpoint(server.URL+"/storage/v1/"), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatalf("failed to create storage client: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+func TestPhotoChanges_CopyRenameDelete(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	server := &LibraryServer{DB: db, GCSClient: newCopyDeleteGCSClient(t), BucketName: "photos"}
+	ctx := contextWithUserID(1)
+
+	// A Live Photo, whose video is copied, moved and deleted with it
+	companion := "2024/a.mov"
+	photos := []database.PhotoObject{
+		{ObjectID: "2024/a.jpg", ContentType: "image/jpeg", SizeBytes: 3, CompanionObjectID: &companion, UserID: 1},
+		{ObjectID: companion, ContentType: "video/quicktime", SizeBytes: 3, UserID: 1},
+	}
+	if err := db.Create(&photos).Error; err != nil {
+		t.Fatalf("failed to create photos: %v", err)
+	}
+
+	if _, err := server.CopyPhoto(ctx, &proto.CopyPhotoRequest{SourceObjectId: "2024/a.jpg", DestinationObjectId: "2024/b.jpg"}); err != nil {
+		t.Fatalf("CopyPhoto() error = %v", err)
+	}
+	if _, err := server.RenamePhoto(ctx, &proto.RenamePhotoRequest{SourceObjectId: "2024/b.jpg", DestinationObjectId: "2024/c.jpg"}); err != nil {
+		t.Fatalf("RenamePhoto() error = %v", err)
+	}
+	if _, err := server.DeletePhoto(ctx, &proto.DeletePhotoRequest{ObjectId: "2024/c.jpg"}); err != nil {
+		t.Fatalf("DeletePhoto() error = %v", err)
+	}
+
+	var changes []database.PhotoChange
+	if err := db.Where("user_id = ? AND kind != ?", 1, database.ChangeKindUpdated).Order("id ASC").Find(&changes).Error; err != nil {
+		t.Fatalf("failed to list changes: %v", err)
+	}
+	var got []string
+	for _, change := range changes {
+		got = append(got, change.Kind+" "+change.ObjectID)
+	}
+	want := []string{
+		database.ChangeKindCreated + " 2024/b.jpg",
+		database.ChangeKindCreated + " 2024/b.mov",
+		database.ChangeKindCreated + " 2024/c.jpg",
+		database.ChangeKindCreated + " 2024/c.mov",
+		database.ChangeKindDeleted + " 2024/b.jpg",
+		database.ChangeKindDeleted + " 2024/b.mov",
+		database.ChangeKindDeleted + " 2024/c.jpg",
+		database.ChangeKindDeleted + " 2024/c.mov",
+	}
+	slices.Sort(got)
+	if !slices.Equal(got, want) {
+		t.Errorf("changes = %v, want %v", got, want)
+	}
+}
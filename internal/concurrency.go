@@ -0,0 +1,187 @@
+package internal
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"golang.org/x/time/rate"
+)
+
+// DefaultConcurrency is the number of objects the update_metadata phase of
+// SyncDatabase and UpdateWebp process at once by default.
+const DefaultConcurrency = 4
+
+// DefaultObjectTimeout is how long each object processed by the
+// update_metadata phase of SyncDatabase and UpdateWebp may take by default.
+const DefaultObjectTimeout = 10 * time.Minute
+
+// processWindowPerWorker bounds how many items ahead of the first unfinished
+// one processInOrder starts, per worker, so that a slow item does not leave
+// the results of all those after it held in memory
+const processWindowPerWorker = 4
+
+// Throttle limits the rate of object downloads from GCS and of runs of
+// external tools such as cwebp and dcraw, shared by all the objects
+// processed at once. A nil Throttle does not limit anything.
+type Throttle struct {
+	gcsReads *rate.Limiter
+	toolRuns *rate.Limiter
+}
+
+// NewThrottle returns a Throttle allowing gcsReadsPerSecond object downloads
+// and toolRunsPerSecond tool runs a second; zero allows any number.
+func NewThrottle(gcsReadsPerSecond, toolRunsPerSecond float64) *Throttle {
+	return &Throttle{
+		gcsReads: newRateLimiter(gcsReadsPerSecond),
+		toolRuns: newRateLimiter(toolRunsPerSecond),
+	}
+}
+
+func newRateLimiter(perSecond float64) *rate.Limiter {
+	if perSecond <= 0 {
+		return rate.NewLimiter(rate.Inf, 1)
+	}
+	return rate.NewLimiter(rate.Limit(perSecond), 1)
+}
+
+// waitGCSRead blocks until an object may be downloaded, or ctx is done.
+func (t *Throttle) waitGCSRead(ctx context.Context) error {
+	if t == nil {
+		return ctx.Err()
+	}
+	return t.gcsReads.Wait(ctx)
+}
+
+// waitToolRun blocks until an external tool may be run, or ctx is done.
+func (t *Throttle) waitToolRun(ctx context.Context) error {
+	if t == nil {
+		return ctx.Err()
+	}
+	return t.toolRuns.Wait(ctx)
+}
+
+// processInOrder runs process for the items 0 to n-1, up to workers at a
+// time and each with a timeout if timeout is positive, and passes their
+// results to report in order of item, from the calling goroutine, as soon as
+// an item and all those before it are done. It stops at the first error
+// report returns, which it returns, or when ctx is done.
+func processInOrder[R any](
+	ctx context.Context,
+	workers int,
+	timeout time.Duration,
+	n int,
+	process func(ctx context.Context, i int) R,
+	report func(i int, result R) error,
+) error {
+	workers = max(1, min(workers, n))
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type itemResult struct {
+		i      int
+		result R
+	}
+	items := make(chan int)
+	results := make(chan itemResult)
+	slots := make(chan struct{}, workers*processWindowPerWorker)
+
+	go func() {
+		defer close(items)
+		for i := range n {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case items <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range items {
+				itemCtx, cancelItem := ctx, context.CancelFunc(func() {})
+				if timeout > 0 {
+					itemCtx, cancelItem = context.WithTimeout(ctx, timeout)
+				}
+				result := process(itemCtx, i)
+				cancelItem()
+				select {
+				case results <- itemResult{i: i, result: result}:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	pending := make(map[int]R)
+	next := 0
+	var err error
+	for done := range results {
+		if err != nil {
+			continue
+		}
+		pending[done.i] = done.result
+		for {
+			result, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+			if err = report(next, result); err != nil {
+				cancel()
+				break
+			}
+			next++
+			<-slots
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if next < n {
+		return ctx.Err()
+	}
+	return nil
+}
+
+// newObjectReader opens obj for reading once the throttle of the server
+// allows it.
+func (s *LibraryServer) newObjectReader(ctx context.Context, obj *storage.ObjectHandle) (*storage.Reader, error) {
+	if err := s.Throttle.waitGCSRead(ctx); err != nil {
+		return nil, err
+	}
+	return obj.NewReader(ctx)
+}
+
+// generatePreview runs GeneratePreview once the throttle of the server
+// allows a tool to be run.
+func (s *LibraryServer) generatePreview(ctx context.Context, contentType string, data []byte) ([]byte, error) {
+	if err := s.Throttle.waitToolRun(ctx); err != nil {
+		return nil, err
+	}
+	return GeneratePreview(s.Capabilities, contentType, data)
+}
+
+// generateWebRendition runs generateWebRendition with the capabilities and
+// quality of the server once its throttle allows a tool to be run.
+func (s *LibraryServer) generateWebRendition(ctx context.Context, objectID string, data []byte) (*webRendition, error) {
+	if err := s.Throttle.waitToolRun(ctx); err != nil {
+		return nil, err
+	}
+	return generateWebRendition(s.Capabilities, objectID, data, s.WebPQuality)
+}
@@ -0,0 +1,173 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alexhokl/photos/database"
+	"github.com/alexhokl/photos/proto"
+)
+
+func TestProcessInOrder_ReportsInOrder(t *testing.T) {
+	const n = 20
+	var running, most atomic.Int32
+	var reported []int
+	err := processInOrder(context.Background(), 4, 0, n,
+		func(ctx context.Context, i int) int {
+			current := running.Add(1)
+			defer running.Add(-1)
+			for {
+				seen := most.Load()
+				if current <= seen || most.CompareAndSwap(seen, current) {
+					break
+				}
+			}
+			// Later items finish first
+			time.Sleep(time.Duration(n-i) * time.Millisecond)
+			return i * i
+		},
+		func(i int, result int) error {
+			if result != i*i {
+				t.Errorf("result of item %d = %d, want %d", i, result, i*i)
+			}
+			reported = append(reported, i)
+			return nil
+		},
+	)
+	if err != nil {
+		t.Fatalf("processInOrder() error = %v", err)
+	}
+	if len(reported) != n {
+		t.Fatalf("reported %d items, want %d", len(reported), n)
+	}
+	for i, item := range reported {
+		if item != i {
+			t.Fatalf("reported = %v, want items in order", reported)
+		}
+	}
+	if got := most.Load(); got > 4 {
+		t.Errorf("%d items processed at once, want at most 4", got)
+	}
+}
+
+func TestProcessInOrder_StopsAtReportError(t *testing.T) {
+	errStop := errors.New("stop")
+	var processed atomic.Int32
+	err := processInOrder(context.Background(), 2, 0, 1000,
+		func(ctx context.Context, i int) int {
+			processed.Add(1)
+			return i
+		},
+		func(i int, result int) error {
+			if i == 3 {
+				return errStop
+			}
+			return nil
+		},
+	)
+	if !errors.Is(err, errStop) {
+		t.Fatalf("processInOrder() error = %v, want %v", err, errStop)
+	}
+	if got := processed.Load(); got >= 1000 {
+		t.Errorf("processed %d items after the report error, want processing stopped", got)
+	}
+}
+
+func TestProcessInOrder_TimesOutEachItem(t *testing.T) {
+	var timedOut []bool
+	err := processInOrder(context.Background(), 2, 10*time.Millisecond, 3,
+		func(ctx context.Context, i int) bool {
+			if i != 1 {
+				return false
+			}
+			<-ctx.Done()
+			return errors.Is(ctx.Err(), context.DeadlineExceeded)
+		},
+		func(i int, result bool) error {
+			timedOut = append(timedOut, result)
+			return nil
+		},
+	)
+	if err != nil {
+		t.Fatalf("processInOrder() error = %v", err)
+	}
+	if len(timedOut) != 3 || timedOut[0] || !timedOut[1] || timedOut[2] {
+		t.Errorf("timed out = %v, want only item 1", timedOut)
+	}
+}
+
+func TestProcessInOrder_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := processInOrder(ctx, 2, 0, 5,
+		func(ctx context.Context, i int) int { return i },
+		func(i int, result int) error { return nil },
+	)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("processInOrder() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	ctx := context.Background()
+	var unlimited *Throttle
+	if err := unlimited.waitGCSRead(ctx); err != nil {
+		t.Errorf("nil Throttle waitGCSRead() error = %v", err)
+	}
+	if err := NewThrottle(0, 0).waitToolRun(ctx); err != nil {
+		t.Errorf("unlimited waitToolRun() error = %v", err)
+	}
+
+	// A second download a second is not allowed within the deadline
+	throttle := NewThrottle(1, 0)
+	if err := throttle.waitGCSRead(ctx); err != nil {
+		t.Fatalf("first waitGCSRead() error = %v", err)
+	}
+	deadline, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := throttle.waitGCSRead(deadline); err == nil {
+		t.Error("second waitGCSRead() error = nil, want the rate exceeded")
+	}
+	if err := throttle.waitToolRun(deadline); err != nil {
+		t.Errorf("waitToolRun() error = %v, want tool runs not limited", err)
+	}
+}
+
+func TestUpdateWebp_Concurrent(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("failed to get sql.DB: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	server := &LibraryServer{DB: db, Concurrency: 3, ObjectTimeout: time.Second}
+
+	for _, objectID := range []string{"2024/c.mp4", "2024/a.jpg", "2024/b.png", "2024/d.txt"} {
+		photo := database.PhotoObject{ObjectID: objectID, ContentType: "image/jpeg", MD5Hash: "x", UserID: 1}
+		if err := db.Create(&photo).Error; err != nil {
+			t.Fatalf("failed to create photo: %v", err)
+		}
+	}
+
+	stream := &mockUpdateWebpStream{ctx: contextWithUserID(1)}
+	if err := server.UpdateWebp(&proto.UpdateWebpRequest{}, stream); err != nil {
+		t.Fatalf("UpdateWebp() error = %v", err)
+	}
+	if len(stream.sent) != 5 {
+		t.Fatalf("got %d progress messages, want 4 and a summary", len(stream.sent))
+	}
+	for i, msg := range stream.sent[:4] {
+		if msg.GetProcessed() != uint32(i+1) || msg.GetTotal() != 4 {
+			t.Errorf("message %d = %d of %d, want %d of 4", i, msg.GetProcessed(), msg.GetTotal(), i+1)
+		}
+		if msg.GetFailed() != uint32(i+1) {
+			t.Errorf("message %d failed = %d, want %d without a bucket", i, msg.GetFailed(), i+1)
+		}
+	}
+	if last := stream.sent[4]; !last.GetComplete() || last.GetFailed() != 4 {
+		t.Errorf("summary = %v, want 4 failed", last)
+	}
+}
@@ -0,0 +1,343 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/alexhokl/photos/database"
+	"github.com/alexhokl/photos/proto"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maxConflictRenames is the highest suffix tried by CONFLICT_POLICY_RENAME
+// before giving up with AlreadyExists.
+const maxConflictRenames = 999
+
+// maxRenameAttempts bounds how often CONFLICT_POLICY_RENAME picks another
+// free name after the one it picked was taken concurrently.
+const maxRenameAttempts = 3
+
+// maxSkipAttempts bounds how often CONFLICT_POLICY_SKIP_IF_IDENTICAL re-reads
+// an object that was created concurrently between its check and its write.
+const maxSkipAttempts = 3
+
+// uploadWrite describes the outcome of writeUploadObject.
+type uploadWrite struct {
+	// ObjectID is the object actually written, which differs from the
+	// requested one when the upload was renamed.
+	ObjectID string
+	// Attrs are the attributes of the written object, or of the existing
+	// object when Skipped is true.
+	Attrs *storage.ObjectAttrs
+	// Skipped is true when an identical object already existed and nothing
+	// was written.
+	Skipped bool
+}
+
+// conflictRenameParts splits objectID into the parts its alternative names
+// are built from: everything before the extension, and the extension.
+func conflictRenameParts(objectID string) (base, ext string) {
+	ext = path.Ext(objectID)
+	if ext == path.Base(objectID) {
+		// A dot-file such as ".hidden" has no extension to preserve
+		ext = ""
+	}
+	return strings.TrimSuffix(objectID, ext), ext
+}
+
+// conflictRenamedObjectID returns the n-th alternative name for objectID,
+// inserting " (n)" before the extension, e.g. "2024/IMG_001 (1).jpg".
+func conflictRenamedObjectID(objectID string, n int) string {
+	base, ext := conflictRenameParts(objectID)
+	return fmt.Sprintf("%s (%d)%s", base, n, ext)
+}
+
+// conflictRenameNumber returns n if name is the n-th alternative name for
+// objectID (see conflictRenamedObjectID).
+func conflictRenameNumber(objectID, name string) (int, bool) {
+	base, ext := conflictRenameParts(objectID)
+	digits, ok := strings.CutPrefix(name, base+" (")
+	if !ok {
+		return 0, false
+	}
+	digits, ok = strings.CutSuffix(digits, ")"+ext)
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.Atoi(digits)
+	if err != nil || n < 1 || strconv.Itoa(n) != digits {
+		return 0, false
+	}
+	return n, true
+}
+
+// freeConflictRename returns the lowest n for which the n-th alternative
+// name for objectID is not in the bucket, listing the alternative names
+// once, or 0 if all up to maxConflictRenames are taken.
+func freeConflictRename(ctx context.Context, bucket *storage.BucketHandle, objectID string) (int, error) {
+	base, _ := conflictRenameParts(objectID)
+	query := &storage.Query{Prefix: base + " ("}
+	if err := query.SetAttrSelection([]string{"Name"}); err != nil {
+		return 0, err
+	}
+
+	_, listSpan := startSpan(ctx, "gcs.list_objects")
+	taken := make(map[int]bool)
+	it := bucket.Objects(ctx, query)
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			recordSpanError(listSpan, err)
+			return 0, err
+		}
+		if n, ok := conflictRenameNumber(objectID, attrs.Name); ok {
+			taken[n] = true
+		}
+	}
+	endSpanOk(listSpan)
+
+	for n := 1; n <= maxConflictRenames; n++ {
+		if !taken[n] {
+			return n, nil
+		}
+	}
+	return 0, nil
+}
+
+// isPreconditionFailed reports whether err is a GCS precondition failure,
+// either from the JSON API (HTTP 412) or from the gRPC API.
+func isPreconditionFailed(err error) bool {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code == http.StatusPreconditionFailed
+	}
+	return status.Code(err) == codes.FailedPrecondition
+}
+
+// writeObjectIf writes data to obj only if conds hold and returns the
+// attributes of the new object. A failed precondition is returned as is so
+// callers can detect it with isPreconditionFailed.
+func writeObjectIf(ctx context.Context, obj *storage.ObjectHandle, conds storage.Conditions, contentType string, metadata map[string]string, data []byte) (*storage.ObjectAttrs, error) {
+	_, writeSpan := startSpan(ctx, "gcs.write_object")
+	writer := obj.If(conds).NewWriter(ctx)
+	writer.ContentType = contentType
+	writer.Metadata = metadata
+
+	if _, err := writer.Write(data); err != nil {
+		_ = writer.Close()
+		recordSpanError(writeSpan, err)
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		recordSpanError(writeSpan, err)
+		return nil, err
+	}
+	endSpanOk(writeSpan)
+
+	return writer.Attrs(), nil
+}
+
+// getExistingObjectAttrs returns the attributes of obj, or nil if it does not
+// exist.
+func getExistingObjectAttrs(ctx context.Context, obj *storage.ObjectHandle) (*storage.ObjectAttrs, error) {
+	_, attrsSpan := startSpan(ctx, "gcs.get_object_attrs")
+	attrs, err := obj.Attrs(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		endSpanOk(attrsSpan)
+		return nil, nil
+	}
+	if err != nil {
+		recordSpanError(attrsSpan, err)
+		return nil, err
+	}
+	endSpanOk(attrsSpan)
+	return attrs, nil
+}
+
+// writeUploadObject writes an uploaded file to GCS, resolving a clash with an
+// existing object according to policy. Every write carries a generation
+// precondition, so two concurrent uploads of the same object ID cannot
+// silently overwrite each other:
+//
+//   - FAIL writes only if the object does not exist, otherwise AlreadyExists.
+//   - RENAME writes "name (n).ext" with the lowest n not yet taken.
+//   - SKIP_IF_IDENTICAL leaves an existing object with the same MD5 untouched
+//     and fails with AlreadyExists if its content differs.
+//   - REPLACE (and UNSPECIFIED) overwrites the generation it observed; if
+//     another upload got there first, the call fails with Aborted.
+//
+// The returned errors are gRPC status errors.
+func writeUploadObject(
+	ctx context.Context,
+	bucket *storage.BucketHandle,
+	objectID, contentType string,
+	metadata map[string]string,
+	data, md5Hash []byte,
+	policy proto.ConflictPolicy,
+) (*uploadWrite, error) {
+	switch policy {
+	case proto.ConflictPolicy_CONFLICT_POLICY_FAIL:
+		attrs, err := writeObjectIf(ctx, bucket.Object(objectID), storage.Conditions{DoesNotExist: true}, contentType, metadata, data)
+		if isPreconditionFailed(err) {
+			return nil, status.Errorf(codes.AlreadyExists, "object already exists: %s", objectID)
+		}
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to write data to GCS: %v", err)
+		}
+		return &uploadWrite{ObjectID: objectID, Attrs: attrs}, nil
+
+	case proto.ConflictPolicy_CONFLICT_POLICY_RENAME:
+		// Try the name itself, then the first free alternative name, picking
+		// another if that is taken concurrently
+		candidate := objectID
+		for attempt := 0; attempt <= maxRenameAttempts; attempt++ {
+			if attempt > 0 {
+				n, err := freeConflictRename(ctx, bucket, objectID)
+				if err != nil {
+					return nil, status.Errorf(codes.Internal, "failed to list objects: %v", err)
+				}
+				if n == 0 {
+					return nil, status.Errorf(codes.AlreadyExists, "no free name for %s up to %d", objectID, maxConflictRenames)
+				}
+				candidate = conflictRenamedObjectID(objectID, n)
+			}
+			attrs, err := writeObjectIf(ctx, bucket.Object(candidate), storage.Conditions{DoesNotExist: true}, contentType, metadata, data)
+			if isPreconditionFailed(err) {
+				continue
+			}
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "failed to write data to GCS: %v", err)
+			}
+			if candidate != objectID {
+				slog.InfoContext(ctx, "Renamed upload to avoid conflict",
+					slog.String("object_id", objectID),
+					slog.String("renamed_object_id", candidate),
+				)
+			}
+			return &uploadWrite{ObjectID: candidate, Attrs: attrs}, nil
+		}
+		return nil, status.Errorf(codes.Aborted, "free names for %s are being taken concurrently", objectID)
+
+	case proto.ConflictPolicy_CONFLICT_POLICY_SKIP_IF_IDENTICAL:
+		obj := bucket.Object(objectID)
+		for range maxSkipAttempts {
+			existing, err := getExistingObjectAttrs(ctx, obj)
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "failed to get object attributes: %v", err)
+			}
+			if existing != nil {
+				if !bytes.Equal(existing.MD5, md5Hash) {
+					return nil, status.Errorf(codes.AlreadyExists, "object already exists with different content: %s", objectID)
+				}
+				slog.InfoContext(ctx, "Skipped upload of identical object", slog.String("object_id", objectID))
+				return &uploadWrite{ObjectID: objectID, Attrs: existing, Skipped: true}, nil
+			}
+			attrs, err := writeObjectIf(ctx, obj, storage.Conditions{DoesNotExist: true}, contentType, metadata, data)
+			if isPreconditionFailed(err) {
+				// Created concurrently; compare against the new object
+				continue
+			}
+			if err != nil {
+				return nil, status.Errorf(codes.Internal, "failed to write data to GCS: %v", err)
+			}
+			return &uploadWrite{ObjectID: objectID, Attrs: attrs}, nil
+		}
+		return nil, status.Errorf(codes.Aborted, "object %s is being modified concurrently", objectID)
+
+	default:
+		obj := bucket.Object(objectID)
+		existing, err := getExistingObjectAttrs(ctx, obj)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to get object attributes: %v", err)
+		}
+		conds := storage.Conditions{DoesNotExist: true}
+		if existing != nil {
+			conds = storage.Conditions{GenerationMatch: existing.Generation}
+		}
+		attrs, err := writeObjectIf(ctx, obj, conds, contentType, metadata, data)
+		if isPreconditionFailed(err) {
+			return nil, status.Errorf(codes.Aborted, "object %s was modified by a concurrent upload", objectID)
+		}
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to write data to GCS: %v", err)
+		}
+		return &uploadWrite{ObjectID: objectID, Attrs: attrs}, nil
+	}
+}
+
+// skippedUploadPhoto describes the existing object an upload was skipped in
+// favour of, using the metadata stored on the GCS object and the derived
+// assets recorded in the database.
+func (s *BytesServer) skippedUploadPhoto(userID uint, attrs *storage.ObjectAttrs) *proto.Photo {
+	photoMetadata := ParseGCSMetadata(attrs.Metadata)
+	photo := &proto.Photo{
+		ObjectId:         attrs.Name,
+		Filename:         attrs.Name,
+		ContentType:      attrs.ContentType,
+		SizeBytes:        attrs.Size,
+		CreatedAt:        attrs.Created.Format(time.RFC3339),
+		UpdatedAt:        attrs.Updated.Format(time.RFC3339),
+		Md5Hash:          base64.StdEncoding.EncodeToString(attrs.MD5),
+		Latitude:         photoMetadata.Latitude,
+		Longitude:        photoMetadata.Longitude,
+		HasLocation:      photoMetadata.HasLocation,
+		DateTaken:        photoMetadata.FormatDateTaken(),
+		HasDateTaken:     photoMetadata.HasDateTaken,
+		Width:            int32(photoMetadata.Width),
+		Height:           int32(photoMetadata.Height),
+		HasDimensions:    photoMetadata.HasDimensions,
+		OriginalFilename: photoMetadata.OriginalFilename,
+		CameraMake:       photoMetadata.CameraMake,
+		CameraModel:      photoMetadata.CameraModel,
+		FocalLength:      photoMetadata.FocalLength,
+		Iso:              int32(photoMetadata.ISO),
+		Aperture:         photoMetadata.Aperture,
+		ExposureTime:     photoMetadata.ExposureTime,
+		LensModel:        photoMetadata.LensModel,
+		IsVideo:          IsVideoContentType(attrs.ContentType),
+	}
+
+	var photoObject database.PhotoObject
+	if err := s.DB.Where("object_id = ? AND user_id = ?", attrs.Name, userID).First(&photoObject).Error; err == nil {
+		if photoObject.ThumbnailObjectID != nil {
+			photo.ThumbnailObjectId = *photoObject.ThumbnailObjectID
+		}
+		if photoObject.WebpObjectID != nil {
+			photo.WebpObjectId = *photoObject.WebpObjectID
+		}
+		if photoObject.AvifObjectID != nil {
+			photo.AvifObjectId = *photoObject.AvifObjectID
+		}
+		if photoObject.ProxyObjectID != nil {
+			photo.ProxyObjectId = *photoObject.ProxyObjectID
+		}
+		if photoObject.HLSObjectID != nil {
+			photo.HlsObjectId = *photoObject.HLSObjectID
+		}
+		if photoObject.AnimatedPreviewObjectID != nil {
+			photo.AnimatedPreviewObjectId = *photoObject.AnimatedPreviewObjectID
+		}
+		photo.CompanionObjectId = companionObjectID(&photoObject)
+	}
+	applySidecar(photo, getPhotoSidecar(s.DB, userID, attrs.Name))
+	if renditions, err := getPhotoRenditions(s.DB, userID, attrs.Name); err == nil {
+		photo.Renditions = renditionsToProto(renditions)
+	}
+	return photo
+}
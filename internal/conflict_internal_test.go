@@ -0,0 +1,217 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/alexhokl/photos/database"
+	"github.com/alexhokl/photos/proto"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestConflictRenamedObjectID(t *testing.T) {
+	tests := []struct {
+		objectID string
+		n        int
+		expected string
+	}{
+		{"IMG_001.jpg", 1, "IMG_001 (1).jpg"},
+		{"2024/vacation/IMG_001.JPG", 2, "2024/vacation/IMG_001 (2).JPG"},
+		{"2024/archive.tar.gz", 1, "2024/archive.tar (1).gz"},
+		{"2024/README", 3, "2024/README (3)"},
+		{"2024/.hidden", 1, "2024/.hidden (1)"},
+		{"2024.v2/photo", 1, "2024.v2/photo (1)"},
+	}
+	for _, test := range tests {
+		t.Run(test.expected, func(t *testing.T) {
+			if got := conflictRenamedObjectID(test.objectID, test.n); got != test.expected {
+				t.Errorf("conflictRenamedObjectID(%q, %d) = %q, want %q", test.objectID, test.n, got, test.expected)
+			}
+		})
+	}
+}
+
+func TestConflictRenameNumber(t *testing.T) {
+	tests := []struct {
+		objectID string
+		name     string
+		expected int
+	}{
+		{"2024/IMG_001.jpg", "2024/IMG_001 (1).jpg", 1},
+		{"2024/IMG_001.jpg", "2024/IMG_001 (12).jpg", 12},
+		{"2024/README", "2024/README (3)", 3},
+		{"2024/IMG_001.jpg", "2024/IMG_001.jpg", 0},
+		{"2024/IMG_001.jpg", "2024/IMG_001 (1).jpg_thumb.jpg", 0},
+		{"2024/IMG_001.jpg", "2024/IMG_001 (01).jpg", 0},
+		{"2024/IMG_001.jpg", "2024/IMG_001 (0).jpg", 0},
+		{"2024/IMG_001.jpg", "2024/IMG_001 (copy).jpg", 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			n, ok := conflictRenameNumber(test.objectID, test.name)
+			if n != test.expected || ok != (test.expected > 0) {
+				t.Errorf("conflictRenameNumber(%q, %q) = %d, %v, want %d", test.objectID, test.name, n, ok, test.expected)
+			}
+		})
+	}
+}
+
+// renameTestBucket is a fake bucket holding objects by name, in which a
+// write to an existing name fails its precondition.
+type renameTestBucket struct {
+	mu      sync.Mutex
+	objects map[string]bool
+	lists   int
+	writes  int
+}
+
+func (b *renameTestBucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+
+	switch {
+	case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/o"):
+		b.lists++
+		var items []map[string]string
+		for name := range b.objects {
+			if strings.HasPrefix(name, r.URL.Query().Get("prefix")) {
+				items = append(items, map[string]string{"name": name, "bucket": "photos"})
+			}
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"kind": "storage#objects", "items": items})
+
+	case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/upload/"):
+		b.writes++
+		_, params, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		part, err := multipart.NewReader(r.Body, params["boundary"]).NextPart()
+		var object struct {
+			Name string `json:"name"`
+		}
+		if err == nil {
+			err = json.NewDecoder(part).Decode(&object)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if b.objects[object.Name] {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			fmt.Fprint(w, `{"error":{"code":412,"message":"Precondition Failed"}}`)
+			return
+		}
+		b.objects[object.Name] = true
+		_ = json.NewEncoder(w).Encode(map[string]string{"name": object.Name, "bucket": "photos", "size": "4"})
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"error":{"code":404,"message":"Not Found"}}`)
+	}
+}
+
+func TestWriteUploadObject_RenameListsOnce(t *testing.T) {
+	fake := &renameTestBucket{objects: map[string]bool{
+		"2024/IMG_001.jpg":               true,
+		"2024/IMG_001 (1).jpg":           true,
+		"2024/IMG_001 (1).jpg_thumb.jpg": true,
+		"2024/IMG_001 (2).jpg":           true,
+		"2024/IMG_001 (4).jpg":           true,
+	}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	client, err := storage.NewClient(context.Background(), option.WithEndpoint(server.URL+"/storage/v1/"), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatalf("failed to create storage client: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+
+	written, err := writeUploadObject(context.Background(), client.Bucket("photos"), "2024/IMG_001.jpg", "image/jpeg", nil, []byte("data"), nil, proto.ConflictPolicy_CONFLICT_POLICY_RENAME)
+	if err != nil {
+		t.Fatalf("writeUploadObject() error = %v", err)
+	}
+	if written.ObjectID != "2024/IMG_001 (3).jpg" {
+		t.Errorf("written object = %q, want the first free name 2024/IMG_001 (3).jpg", written.ObjectID)
+	}
+	if fake.lists != 1 || fake.writes != 2 {
+		t.Errorf("got %d lists and %d writes, want 1 list and 2 writes", fake.lists, fake.writes)
+	}
+}
+
+func TestIsPreconditionFailed(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{"nil", nil, false},
+		{"JSON API 412", &googleapi.Error{Code: http.StatusPreconditionFailed}, true},
+		{"wrapped JSON API 412", fmt.Errorf("write: %w", &googleapi.Error{Code: http.StatusPreconditionFailed}), true},
+		{"JSON API 404", &googleapi.Error{Code: http.StatusNotFound}, false},
+		{"gRPC failed precondition", status.Error(codes.FailedPrecondition, "precondition"), true},
+		{"gRPC unavailable", status.Error(codes.Unavailable, "unavailable"), false},
+		{"plain error", errors.New("boom"), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := isPreconditionFailed(test.err); got != test.expected {
+				t.Errorf("isPreconditionFailed(%v) = %v, want %v", test.err, got, test.expected)
+			}
+		})
+	}
+}
+
+func TestSkippedUploadPhoto(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	server := &BytesServer{DB: db}
+
+	webpID := "2024/IMG_001.webp"
+	existing := &database.PhotoObject{ObjectID: "2024/IMG_001.jpg", ContentType: "image/jpeg", MD5Hash: "AAEC", UserID: 1, WebpObjectID: &webpID}
+	if err := db.Create(existing).Error; err != nil {
+		t.Fatalf("failed to create photo: %v", err)
+	}
+
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	attrs := &storage.ObjectAttrs{
+		Name:        "2024/IMG_001.jpg",
+		ContentType: "image/jpeg",
+		Size:        1024,
+		MD5:         []byte{0, 1, 2},
+		Created:     now,
+		Updated:     now,
+	}
+
+	photo := server.skippedUploadPhoto(1, attrs)
+
+	if photo.ObjectId != "2024/IMG_001.jpg" {
+		t.Errorf("ObjectId = %q, want %q", photo.ObjectId, "2024/IMG_001.jpg")
+	}
+	if photo.Md5Hash != "AAEC" {
+		t.Errorf("Md5Hash = %q, want %q", photo.Md5Hash, "AAEC")
+	}
+	if photo.SizeBytes != 1024 {
+		t.Errorf("SizeBytes = %d, want 1024", photo.SizeBytes)
+	}
+	if photo.WebpObjectId != webpID {
+		t.Errorf("WebpObjectId = %q, want %q", photo.WebpObjectId, webpID)
+	}
+
+	// Another user's record must not leak derived assets
+	other := server.skippedUploadPhoto(2, attrs)
+	if other.WebpObjectId != "" {
+		t.Errorf("WebpObjectId for other user = %q, want empty", other.WebpObjectId)
+	}
+}
@@ -0,0 +1,262 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// contentSniffLen is the number of leading bytes DetectContentType needs to
+// recognise every supported format (DNG needs IFD0, which may sit further in).
+const contentSniffLen = 4096
+
+// dngVersionTag is the TIFF tag that marks a TIFF file as DNG.
+const dngVersionTag = 0xC612
+
+// DefaultAllowedContentTypes are the detected content types accepted for
+// upload when no allow-list is configured.
+var DefaultAllowedContentTypes = []string{
+	"image/jpeg",
+	"image/png",
+	"image/gif",
+	"image/webp",
+	"image/heic",
+	"image/heif",
+	"image/avif",
+	"image/tiff",
+	"image/x-adobe-dng",
+	"image/x-canon-cr2",
+	"image/x-canon-cr3",
+	"image/x-nikon-nef",
+	"image/x-nikon-nrw",
+	"image/x-sony-arw",
+	"image/x-sony-srf",
+	"image/x-sony-sr2",
+	"image/x-fuji-raf",
+	"image/x-olympus-orf",
+	"video/mp4",
+	"video/quicktime",
+	XMPSidecarContentType,
+}
+
+// DefaultMaxUploadSizes are the per-type upload size caps used when none are
+// configured, in the "type=size" form accepted by ParseUploadSizeLimits.
+var DefaultMaxUploadSizes = []string{
+	"image/*=200MB",
+	"video/*=4GB",
+	XMPSidecarContentType + "=10MB",
+}
+
+// DetectContentType returns the content type of data based on its leading
+// magic bytes, ignoring any type declared by the client. Formats not
+// recognised here fall back to http.DetectContentType, which reports
+// "application/octet-stream" for unknown binary data.
+func DetectContentType(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8, 0xFF}):
+		return "image/jpeg"
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return "image/png"
+	case bytes.HasPrefix(data, []byte("GIF87a")), bytes.HasPrefix(data, []byte("GIF89a")):
+		return "image/gif"
+	case len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return "image/webp"
+	case bytes.HasPrefix(data, []byte("II*\x00")), bytes.HasPrefix(data, []byte("MM\x00*")):
+		return detectTIFFContentType(data)
+	case bytes.HasPrefix(data, []byte("IIRO")), bytes.HasPrefix(data, []byte("IIRS")), bytes.HasPrefix(data, []byte("MMOR")):
+		return "image/x-olympus-orf"
+	case bytes.HasPrefix(data, []byte(rafMagic)):
+		return "image/x-fuji-raf"
+	case len(data) >= 12 && string(data[4:8]) == "ftyp":
+		return detectISOBMFFContentType(data)
+	case len(data) >= 8 && isQuickTimeAtom(string(data[4:8])):
+		return "video/quicktime"
+	case isXMPData(data):
+		return XMPSidecarContentType
+	}
+	contentType := http.DetectContentType(data)
+	if i := strings.Index(contentType, ";"); i >= 0 {
+		contentType = contentType[:i]
+	}
+	return contentType
+}
+
+// detectTIFFContentType distinguishes DNG from plain TIFF by looking for the
+// DNGVersion tag in IFD0, and CR2 by the marker following the TIFF header.
+// Other TIFF-based RAW formats are told apart by their extension (see
+// refineTIFFContentType).
+func detectTIFFContentType(data []byte) string {
+	if len(data) < 8 {
+		return "image/tiff"
+	}
+	if len(data) >= 11 && string(data[8:11]) == "CR\x02" {
+		return "image/x-canon-cr2"
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if data[0] == 'M' {
+		order = binary.BigEndian
+	}
+	offset := int(order.Uint32(data[4:8]))
+	if offset < 8 || offset+2 > len(data) {
+		return "image/tiff"
+	}
+	count := int(order.Uint16(data[offset : offset+2]))
+	for i := range count {
+		entry := offset + 2 + i*12
+		if entry+2 > len(data) {
+			break
+		}
+		if order.Uint16(data[entry:entry+2]) == dngVersionTag {
+			return "image/x-adobe-dng"
+		}
+	}
+	return "image/tiff"
+}
+
+// detectISOBMFFContentType maps the brands of an ISO base media file ("ftyp"
+// box) to a content type. HEIF files often carry a generic major brand such
+// as "mif1", so the compatible brands are checked as well.
+func detectISOBMFFContentType(data []byte) string {
+	size := int(binary.BigEndian.Uint32(data[0:4]))
+	if size < 16 || size > len(data) {
+		size = min(len(data), 64)
+	}
+	brands := []string{string(data[8:12])}
+	for i := 16; i+4 <= size; i += 4 {
+		brands = append(brands, string(data[i:i+4]))
+	}
+
+	hasBrand := func(names ...string) bool {
+		return slices.ContainsFunc(brands, func(b string) bool { return slices.Contains(names, b) })
+	}
+	switch {
+	case hasBrand("avif", "avis"):
+		return "image/avif"
+	case brands[0] == "crx ":
+		return "image/x-canon-cr3"
+	case hasBrand("heic", "heix", "heim", "heis", "hevc", "hevx"):
+		return "image/heic"
+	case hasBrand("mif1", "msf1", "heif"):
+		return "image/heif"
+	case brands[0] == "qt  ":
+		return "video/quicktime"
+	}
+	return "video/mp4"
+}
+
+// isQuickTimeAtom reports whether name is a top-level atom that old
+// QuickTime files start with instead of an "ftyp" box.
+func isQuickTimeAtom(name string) bool {
+	switch name {
+	case "moov", "mdat", "wide", "free", "skip", "pnot":
+		return true
+	}
+	return false
+}
+
+// isXMPData reports whether data looks like an XMP packet.
+func isXMPData(data []byte) bool {
+	head := data[:min(len(data), 512)]
+	return bytes.Contains(head, []byte("<?xpacket")) ||
+		bytes.Contains(head, []byte("<x:xmpmeta")) ||
+		bytes.Contains(head, []byte("<rdf:RDF"))
+}
+
+// UploadPolicy restricts which content types may be uploaded and how large
+// an upload of each type may be. Content types are the detected ones, not
+// those declared by the client.
+type UploadPolicy struct {
+	// AllowedContentTypes lists the accepted content types. An empty list
+	// accepts every type.
+	AllowedContentTypes []string
+	// MaxSizeBytes caps the size of an upload per content type. Keys are
+	// either a full type ("image/jpeg") or a wildcard ("video/*"); a full
+	// type takes precedence. Types without an entry are not capped.
+	MaxSizeBytes map[string]int64
+}
+
+// maxSizeFor returns the size cap for contentType, or 0 if it has none.
+func (p UploadPolicy) maxSizeFor(contentType string) int64 {
+	if limit, ok := p.MaxSizeBytes[contentType]; ok {
+		return limit
+	}
+	if i := strings.Index(contentType, "/"); i >= 0 {
+		return p.MaxSizeBytes[contentType[:i]+"/*"]
+	}
+	return 0
+}
+
+// CheckSize returns an InvalidArgument error if size exceeds the cap for
+// contentType.
+func (p UploadPolicy) CheckSize(contentType string, size int64) error {
+	if limit := p.maxSizeFor(contentType); limit > 0 && size > limit {
+		return status.Errorf(codes.InvalidArgument, "upload of %s exceeds the %d byte limit for this type", contentType, limit)
+	}
+	return nil
+}
+
+// Check returns an InvalidArgument error if contentType is not allowed or
+// size exceeds its cap.
+func (p UploadPolicy) Check(contentType string, size int64) error {
+	if len(p.AllowedContentTypes) > 0 && !slices.Contains(p.AllowedContentTypes, contentType) {
+		return status.Errorf(codes.InvalidArgument, "content type %s is not allowed", contentType)
+	}
+	return p.CheckSize(contentType, size)
+}
+
+// ParseUploadSizeLimits parses "type=size" entries such as "image/*=200MB"
+// into a map suitable for UploadPolicy.MaxSizeBytes. Sizes are in bytes and
+// may carry a KB, MB or GB suffix (powers of 1024).
+func ParseUploadSizeLimits(entries []string) (map[string]int64, error) {
+	limits := make(map[string]int64, len(entries))
+	for _, entry := range entries {
+		contentType, size, ok := strings.Cut(entry, "=")
+		contentType = strings.TrimSpace(strings.ToLower(contentType))
+		if !ok || !strings.Contains(contentType, "/") {
+			return nil, fmt.Errorf("invalid upload size limit %q (expected type=size, e.g. image/*=200MB)", entry)
+		}
+		limit, err := ParseByteSize(size)
+		if err != nil {
+			return nil, fmt.Errorf("invalid upload size limit %q: %w", entry, err)
+		}
+		limits[contentType] = limit
+	}
+	return limits, nil
+}
+
+// ParseByteSize parses a size such as "512", "10KB", "200MB" or "4GB".
+func ParseByteSize(value string) (int64, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(1)
+	for _, unit := range []struct {
+		suffix     string
+		multiplier int64
+	}{
+		{"GB", 1 << 30},
+		{"MB", 1 << 20},
+		{"KB", 1 << 10},
+		{"B", 1},
+	} {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			multiplier = unit.multiplier
+			break
+		}
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("size must be a positive number of bytes, KB, MB or GB")
+	}
+	if n > math.MaxInt64/multiplier {
+		return 0, fmt.Errorf("size %s is too large", value)
+	}
+	return n * multiplier, nil
+}
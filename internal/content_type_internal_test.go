@@ -0,0 +1,133 @@
+package internal
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+)
+
+// tiffWithTag returns a minimal little-endian TIFF header whose IFD0 holds a
+// single entry with the given tag.
+func tiffWithTag(tag uint16) []byte {
+	data := []byte("II*\x00")
+	data = binary.LittleEndian.AppendUint32(data, 8)
+	data = binary.LittleEndian.AppendUint16(data, 1)
+	data = binary.LittleEndian.AppendUint16(data, tag)
+	data = append(data, make([]byte, 10)...)
+	return data
+}
+
+// ftypBox returns an ISO base media "ftyp" box with the given brands.
+func ftypBox(major string, compatible ...string) []byte {
+	size := 16 + 4*len(compatible)
+	data := binary.BigEndian.AppendUint32(nil, uint32(size))
+	data = append(data, "ftyp"+major+"\x00\x00\x00\x00"...)
+	for _, brand := range compatible {
+		data = append(data, brand...)
+	}
+	return data
+}
+
+func TestDetectContentType(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		expected string
+	}{
+		{"JPEG", []byte{0xFF, 0xD8, 0xFF, 0xE1, 0x00, 0x10}, "image/jpeg"},
+		{"PNG", []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\x0dIHDR"), "image/png"},
+		{"GIF", []byte("GIF89a\x01\x00\x01\x00"), "image/gif"},
+		{"WebP", []byte("RIFF\x00\x00\x00\x00WEBPVP8 "), "image/webp"},
+		{"DNG", tiffWithTag(dngVersionTag), "image/x-adobe-dng"},
+		{"TIFF", tiffWithTag(0x0100), "image/tiff"},
+		{"CR2", []byte("II*\x00\x10\x00\x00\x00CR\x02\x00"), "image/x-canon-cr2"},
+		{"CR3", ftypBox("crx ", "crx ", "isom"), "image/x-canon-cr3"},
+		{"RAF", []byte("FUJIFILMCCD-RAW 0201FF383501"), "image/x-fuji-raf"},
+		{"ORF", []byte("IIRO\x08\x00\x00\x00"), "image/x-olympus-orf"},
+		{"HEIC", ftypBox("heic", "mif1", "heic"), "image/heic"},
+		{"HEIC with generic major brand", ftypBox("mif1", "mif1", "heic"), "image/heic"},
+		{"HEIF", ftypBox("mif1", "mif1"), "image/heif"},
+		{"AVIF", ftypBox("avif", "mif1", "avif"), "image/avif"},
+		{"MP4", ftypBox("isom", "isom", "mp41"), "video/mp4"},
+		{"QuickTime", ftypBox("qt  ", "qt  "), "video/quicktime"},
+		{"legacy QuickTime", []byte("\x00\x00\x00\x08wide\x00\x00\x00\x00mdat"), "video/quicktime"},
+		{"XMP", []byte(`<?xpacket begin=""?><x:xmpmeta xmlns:x="adobe:ns:meta/">`), XMPSidecarContentType},
+		{"XMP without packet", []byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"></x:xmpmeta>`), XMPSidecarContentType},
+		{"plain text", []byte("hello world"), "text/plain"},
+		{"unknown binary", []byte{0x00, 0x01, 0x02, 0x03}, "application/octet-stream"},
+		{"empty", nil, "text/plain"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := DetectContentType(test.data); got != test.expected {
+				t.Errorf("DetectContentType() = %q, want %q", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestUploadPolicyCheck(t *testing.T) {
+	policy := UploadPolicy{
+		AllowedContentTypes: []string{"image/jpeg", "image/png", "video/mp4"},
+		MaxSizeBytes: map[string]int64{
+			"image/*":   100,
+			"image/png": 10,
+		},
+	}
+
+	tests := []struct {
+		name        string
+		contentType string
+		size        int64
+		expectedErr codes.Code
+	}{
+		{"allowed within wildcard limit", "image/jpeg", 100, codes.OK},
+		{"over wildcard limit", "image/jpeg", 101, codes.InvalidArgument},
+		{"exact type limit takes precedence", "image/png", 11, codes.InvalidArgument},
+		{"no limit for type", "video/mp4", 1 << 40, codes.OK},
+		{"not allowed", "image/heic", 1, codes.InvalidArgument},
+		{"unknown binary not allowed", "application/octet-stream", 1, codes.InvalidArgument},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := policy.Check(test.contentType, test.size)
+			if test.expectedErr == codes.OK {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			assertGRPCError(t, err, test.expectedErr)
+		})
+	}
+
+	// The zero policy accepts everything
+	if err := (UploadPolicy{}).Check("application/octet-stream", 1<<40); err != nil {
+		t.Errorf("zero policy returned error: %v", err)
+	}
+}
+
+func TestParseUploadSizeLimits(t *testing.T) {
+	limits, err := ParseUploadSizeLimits([]string{"image/*=200MB", "Video/MP4 = 4GB", "application/rdf+xml=10KB", "image/gif=512"})
+	if err != nil {
+		t.Fatalf("ParseUploadSizeLimits returned error: %v", err)
+	}
+	expected := map[string]int64{
+		"image/*":             200 << 20,
+		"video/mp4":           4 << 30,
+		"application/rdf+xml": 10 << 10,
+		"image/gif":           512,
+	}
+	for contentType, want := range expected {
+		if got := limits[contentType]; got != want {
+			t.Errorf("limits[%q] = %d, want %d", contentType, got, want)
+		}
+	}
+
+	for _, invalid := range []string{"image/*", "jpeg=1MB", "image/*=-1", "image/*=0", "image/*=lots", "image/*=9000000000GB"} {
+		if _, err := ParseUploadSizeLimits([]string{invalid}); err == nil {
+			t.Errorf("ParseUploadSizeLimits(%q) expected error, got nil", invalid)
+		}
+	}
+}
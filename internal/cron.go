@@ -0,0 +1,189 @@
+package internal
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSearchLimit bounds how far ahead the next time of a cron expression is
+// searched for; expressions such as "0 0 30 2 *" never match
+const cronSearchLimit = 5 * 366 * 24 * time.Hour
+
+// cronDescriptors are the shorthands accepted in place of the five fields
+// of a cron expression.
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// cronField is the range of values of a field of a cron expression.
+type cronField struct {
+	name     string
+	min, max int
+}
+
+var cronFields = []cronField{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 7},
+}
+
+// cronSchedule is a parsed cron expression; each field is a bit set of the
+// values it matches.
+type cronSchedule struct {
+	minutes, hours, days, months, weekdays uint64
+	// anyDay and anyWeekday record which of the day fields are "*"; if both
+	// are restricted a day matching either matches, as in cron
+	anyDay, anyWeekday bool
+}
+
+// parseCron parses a cron expression of five fields (minute, hour, day of
+// month, month and day of week), each "*", a value, a range "a-b" or a list
+// of them, optionally with a step "/n", or one of the descriptors such as
+// "@daily". Sunday is 0 or 7.
+func parseCron(expression string) (*cronSchedule, error) {
+	spec := strings.TrimSpace(expression)
+	if descriptor, ok := cronDescriptors[spec]; ok {
+		spec = descriptor
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("invalid cron expression %q: expected 5 fields, got %d", expression, len(fields))
+	}
+
+	bits := make([]uint64, len(fields))
+	for i, field := range fields {
+		var err error
+		bits[i], err = parseCronField(field, cronFields[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron expression %q: %w", expression, err)
+		}
+	}
+
+	weekdays := bits[4]
+	// Sunday is both 0 and 7
+	if weekdays&(1<<7) != 0 {
+		weekdays |= 1
+	}
+	return &cronSchedule{
+		minutes:    bits[0],
+		hours:      bits[1],
+		days:       bits[2],
+		months:     bits[3],
+		weekdays:   weekdays,
+		anyDay:     fields[2] == "*",
+		anyWeekday: fields[4] == "*",
+	}, nil
+}
+
+// parseCronField returns the bit set of the values of field matches.
+func parseCronField(field string, bounds cronField) (uint64, error) {
+	var bits uint64
+	for part := range strings.SplitSeq(field, ",") {
+		valueRange, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepText)
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step %q in %s field", stepText, bounds.name)
+			}
+		}
+
+		var low, high int
+		switch {
+		case valueRange == "*":
+			low, high = bounds.min, bounds.max
+		case strings.Contains(valueRange, "-"):
+			lowText, highText, _ := strings.Cut(valueRange, "-")
+			var err error
+			if low, err = parseCronValue(lowText, bounds); err != nil {
+				return 0, err
+			}
+			if high, err = parseCronValue(highText, bounds); err != nil {
+				return 0, err
+			}
+			if low > high {
+				return 0, fmt.Errorf("invalid range %q in %s field", valueRange, bounds.name)
+			}
+		default:
+			var err error
+			if low, err = parseCronValue(valueRange, bounds); err != nil {
+				return 0, err
+			}
+			high = low
+			// "5/15" means from 5 to the end in steps of 15
+			if hasStep {
+				high = bounds.max
+			}
+		}
+
+		for value := low; value <= high; value += step {
+			bits |= 1 << value
+		}
+	}
+	return bits, nil
+}
+
+// parseCronValue parses a value of a cron field, checking it is in range.
+func parseCronValue(text string, bounds cronField) (int, error) {
+	value, err := strconv.Atoi(text)
+	if err != nil || value < bounds.min || value > bounds.max {
+		return 0, fmt.Errorf("invalid value %q in %s field (must be between %d and %d)", text, bounds.name, bounds.min, bounds.max)
+	}
+	return value, nil
+}
+
+// matchesDay reports whether the day of t matches the schedule.
+func (c *cronSchedule) matchesDay(t time.Time) bool {
+	dayMatches := c.days&(1<<t.Day()) != 0
+	weekdayMatches := c.weekdays&(1<<int(t.Weekday())) != 0
+	switch {
+	case c.anyDay && c.anyWeekday:
+		return true
+	case c.anyDay:
+		return weekdayMatches
+	case c.anyWeekday:
+		return dayMatches
+	default:
+		return dayMatches || weekdayMatches
+	}
+}
+
+// next returns the first time after after that the schedule matches, in the
+// location of after, or the zero time if there is none within five years.
+func (c *cronSchedule) next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	limit := after.Add(cronSearchLimit)
+	location := after.Location()
+
+	for t.Before(limit) {
+		if c.months&(1<<int(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, location)
+			continue
+		}
+		if !c.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, location)
+			continue
+		}
+		if c.hours&(1<<t.Hour()) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, location)
+			continue
+		}
+		if c.minutes&(1<<t.Minute()) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
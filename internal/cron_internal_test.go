@@ -0,0 +1,77 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron_Invalid(t *testing.T) {
+	tests := []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+		"@sometimes",
+	}
+	for _, expression := range tests {
+		if _, err := parseCron(expression); err == nil {
+			t.Errorf("parseCron(%q): expected error, got nil", expression)
+		}
+	}
+}
+
+func TestCronSchedule_Next(t *testing.T) {
+	// A Wednesday
+	after := time.Date(2024, 5, 1, 10, 17, 30, 0, time.UTC)
+	tests := []struct {
+		expression string
+		expected   time.Time
+	}{
+		{"* * * * *", time.Date(2024, 5, 1, 10, 18, 0, 0, time.UTC)},
+		{"0 3 * * *", time.Date(2024, 5, 2, 3, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2024, 5, 1, 11, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC)},
+		{"5/20 10 * * *", time.Date(2024, 5, 1, 10, 25, 0, 0, time.UTC)},
+		{"0,45 9-11 * * *", time.Date(2024, 5, 1, 10, 45, 0, 0, time.UTC)},
+		{"30 2 * * 0", time.Date(2024, 5, 5, 2, 30, 0, 0, time.UTC)},
+		{"30 2 * * 7", time.Date(2024, 5, 5, 2, 30, 0, 0, time.UTC)},
+		{"0 4 * * 1-5", time.Date(2024, 5, 2, 4, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// Either day field matches when both are restricted
+		{"0 0 15 * 6", time.Date(2024, 5, 4, 0, 0, 0, 0, time.UTC)},
+		{"0 0 2 * 6", time.Date(2024, 5, 2, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, test := range tests {
+		cron, err := parseCron(test.expression)
+		if err != nil {
+			t.Fatalf("parseCron(%q) error = %v", test.expression, err)
+		}
+		if got := cron.next(after); !got.Equal(test.expected) {
+			t.Errorf("next of %q = %v, want %v", test.expression, got, test.expected)
+		}
+	}
+}
+
+func TestCronSchedule_Next_Location(t *testing.T) {
+	location := time.FixedZone("HKT", 8*60*60)
+	cron, err := parseCron("0 3 * * *")
+	if err != nil {
+		t.Fatalf("parseCron() error = %v", err)
+	}
+	// 02:00 in Hong Kong
+	after := time.Date(2024, 5, 1, 18, 0, 0, 0, time.UTC)
+	expected := time.Date(2024, 5, 2, 3, 0, 0, 0, location)
+	if got := cron.next(after.In(location)); !got.Equal(expected) {
+		t.Errorf("next = %v, want %v", got, expected)
+	}
+}
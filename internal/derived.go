@@ -0,0 +1,477 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/alexhokl/photos/database"
+	"gorm.io/gorm"
+)
+
+// GCS metadata keys marking an object as a derived asset, so that it can be
+// recognised from a bucket listing even without the database.
+const (
+	// MetadataKeyDerivedFrom holds the object ID of the original
+	MetadataKeyDerivedFrom = "derived_from"
+	// MetadataKeyDerivedKind holds the kind of derived asset (see
+	// database.DerivedKindWebP and friends)
+	MetadataKeyDerivedKind = "derived_kind"
+)
+
+// derivedObjectMetadata returns the GCS metadata marking an object as a
+// derived asset of sourceObjectID.
+func derivedObjectMetadata(kind, sourceObjectID string) map[string]string {
+	return map[string]string{
+		MetadataKeyDerivedFrom: sourceObjectID,
+		MetadataKeyDerivedKind: kind,
+	}
+}
+
+// markedDerivedObject returns the original and kind of a GCS object marked as
+// a derived asset in its metadata.
+func markedDerivedObject(attrs *storage.ObjectAttrs) (sourceObjectID, kind string, ok bool) {
+	sourceObjectID, ok = attrs.Metadata[MetadataKeyDerivedFrom]
+	if !ok || sourceObjectID == "" {
+		return "", "", false
+	}
+	return sourceObjectID, attrs.Metadata[MetadataKeyDerivedKind], true
+}
+
+// derivedObjectConditions returns the conditions to write obj as the derived
+// asset of kind of sourceObjectID under: the object is only created, or
+// replaces an object marked as the same asset, so that an original stored
+// under the name is never overwritten. The write then fails with a
+// precondition error instead. An error other than the object not existing
+// is returned, as whether it can be overwritten is unknown.
+func derivedObjectConditions(ctx context.Context, obj *storage.ObjectHandle, kind, sourceObjectID string) (storage.Conditions, error) {
+	attrs, err := obj.Attrs(ctx)
+	if errors.Is(err, storage.ErrObjectNotExist) {
+		return storage.Conditions{DoesNotExist: true}, nil
+	}
+	if err != nil {
+		return storage.Conditions{}, fmt.Errorf("failed to read attributes of %s: %w", obj.ObjectName(), err)
+	}
+	if source, markedKind, ok := markedDerivedObject(attrs); ok && source == sourceObjectID && markedKind == kind {
+		return storage.Conditions{GenerationMatch: attrs.Generation}, nil
+	}
+	return storage.Conditions{DoesNotExist: true}, nil
+}
+
+// newDerivedObjectWriter returns a writer storing obj as the derived asset of
+// kind of sourceObjectID, marked as such in its GCS metadata (see
+// derivedObjectConditions).
+func newDerivedObjectWriter(ctx context.Context, obj *storage.ObjectHandle, kind, sourceObjectID string) (*storage.Writer, error) {
+	conditions, err := derivedObjectConditions(ctx, obj, kind, sourceObjectID)
+	if err != nil {
+		return nil, err
+	}
+	writer := obj.If(conditions).NewWriter(ctx)
+	writer.Metadata = derivedObjectMetadata(kind, sourceObjectID)
+	return writer, nil
+}
+
+// thumbnailKind returns the kind of derived asset thumbnail_object_id holds
+// for a photo of contentType: a thumbnail for videos and a preview otherwise.
+func thumbnailKind(contentType string) string {
+	if IsVideoContentType(contentType) {
+		return database.DerivedKindThumbnail
+	}
+	return database.DerivedKindPreview
+}
+
+// derivedObjectID returns the object ID a derived asset of kind is stored
+// under for sourceObjectID, or an empty string for fixed-size thumbnails,
+// which are named after their size (see renditionObjectID).
+func derivedObjectID(kind, sourceObjectID string) string {
+	switch kind {
+	case database.DerivedKindWebP:
+		return webpObjectID(sourceObjectID)
+	case database.DerivedKindAVIF:
+		return avifObjectID(sourceObjectID)
+	case database.DerivedKindProxy:
+		return proxyObjectID(sourceObjectID)
+	case database.DerivedKindHLS:
+		return hlsObjectID(sourceObjectID)
+	case database.DerivedKindAnimatedPreview:
+		return animatedPreviewObjectID(sourceObjectID)
+	case database.DerivedKindMotionVideo:
+		return motionVideoObjectID(sourceObjectID)
+	case database.DerivedKindPreview:
+		return previewObjectID(sourceObjectID)
+	case database.DerivedKindThumbnail:
+		return sourceObjectID + "_thumb.jpg"
+	}
+	return ""
+}
+
+// derivedObjectColumn returns the PhotoObject column referencing the derived
+// asset objectID of kind, or an empty string if the asset is not referenced
+// directly, such as the media playlists and segments of an HLS ladder.
+func derivedObjectColumn(kind, objectID string) string {
+	switch kind {
+	case database.DerivedKindWebP:
+		return "webp_object_id"
+	case database.DerivedKindAVIF:
+		return "avif_object_id"
+	case database.DerivedKindProxy:
+		return "proxy_object_id"
+	case database.DerivedKindAnimatedPreview:
+		return "animated_preview_object_id"
+	case database.DerivedKindMotionVideo:
+		return "motion_video_object_id"
+	case database.DerivedKindHLS:
+		if path.Base(objectID) == hlsPlaylistName {
+			return "hls_object_id"
+		}
+		return ""
+	}
+	return "thumbnail_object_id"
+}
+
+// movedDerivedObjectID returns the object ID the derived asset objectID of
+// sourcePhotoID takes when it follows its photo to destPhotoID. The suffix
+// the asset adds to the name of its photo, such as ".webp" or "_web.jpg", is
+// kept and appended to destPhotoID. Assets named before the extension of
+// their photo was kept in their name, such as "image.webp" for "image.jpg",
+// take the same suffix; otherwise the default name of its kind is used.
+func movedDerivedObjectID(kind, objectID, sourcePhotoID, destPhotoID string) string {
+	if suffix, ok := strings.CutPrefix(objectID, sourcePhotoID); ok && suffix != "" {
+		return destPhotoID + suffix
+	}
+	sourceBase := strings.TrimSuffix(sourcePhotoID, path.Ext(sourcePhotoID))
+	if suffix, ok := strings.CutPrefix(objectID, sourceBase); ok && suffix != "" {
+		return destPhotoID + suffix
+	}
+	return derivedObjectID(kind, destPhotoID)
+}
+
+// recordDerivedObject records objectID, of sizeBytes, as a derived asset of
+// sourceObjectID. Errors are logged but not fatal.
+func recordDerivedObject(ctx context.Context, db *gorm.DB, userID uint, kind, sourceObjectID, objectID string, sizeBytes int64) {
+	_, dbSpan := startSpan(ctx, "db.create_or_restore_derived_object")
+	if err := database.CreateOrRestoreDerivedObject(db, &database.DerivedObject{
+		ObjectID:       objectID,
+		SourceObjectID: sourceObjectID,
+		Kind:           kind,
+		UserID:         userID,
+		SizeBytes:      sizeBytes,
+	}); err != nil {
+		recordSpanError(dbSpan, err)
+		slog.WarnContext(ctx, "failed to record derived object",
+			slog.String("object_id", sourceObjectID),
+			slog.String("derived_object_id", objectID),
+			slog.String("error", err.Error()),
+		)
+		return
+	}
+	endSpanOk(dbSpan)
+}
+
+// forgetReplacedDerivedObject forgets objectID as a derived asset of userID,
+// as userID has uploaded or copied an original to it: their derived asset and
+// thumbnail records are deleted and their photos referencing it no longer do,
+// so that a sync neither removes the original nor skips generating their
+// assets again. Errors are logged but not fatal.
+func forgetReplacedDerivedObject(ctx context.Context, db *gorm.DB, userID uint, objectID string) {
+	_, dbSpan := startSpan(ctx, "db.forget_replaced_derived_object")
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for _, column := range photoDerivedColumns {
+			if err := tx.Model(&database.PhotoObject{}).
+				Where(column+" = ? AND user_id = ?", objectID, userID).
+				Update(column, nil).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Where("object_id = ? AND user_id = ?", objectID, userID).Delete(&database.PhotoRendition{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("object_id = ? AND user_id = ?", objectID, userID).Delete(&database.DerivedObject{}).Error
+	})
+	if err != nil {
+		recordSpanError(dbSpan, err)
+		slog.WarnContext(ctx, "failed to forget derived object",
+			slog.String("object_id", objectID),
+			slog.String("error", err.Error()),
+		)
+		return
+	}
+	endSpanOk(dbSpan)
+}
+
+// recordPhotoDerivedObjects records the WebP and AVIF renditions, video
+// proxy and animated preview, motion photo video, and preview or thumbnail
+// referenced by photoObject, sized from bucket.
+func recordPhotoDerivedObjects(ctx context.Context, db *gorm.DB, bucket *storage.BucketHandle, photoObject *database.PhotoObject) {
+	for _, derived := range []struct {
+		kind     string
+		objectID *string
+	}{
+		{database.DerivedKindWebP, photoObject.WebpObjectID},
+		{database.DerivedKindAVIF, photoObject.AvifObjectID},
+		{database.DerivedKindProxy, photoObject.ProxyObjectID},
+		{database.DerivedKindAnimatedPreview, photoObject.AnimatedPreviewObjectID},
+		{database.DerivedKindMotionVideo, photoObject.MotionVideoObjectID},
+		{thumbnailKind(photoObject.ContentType), photoObject.ThumbnailObjectID},
+	} {
+		if derived.objectID == nil || *derived.objectID == "" {
+			continue
+		}
+		objectID := *derived.objectID
+		recordDerivedObject(ctx, db, photoObject.UserID, derived.kind, photoObject.ObjectID, objectID, derivedObjectSize(ctx, bucket, objectID))
+	}
+}
+
+// derivedObjectSize returns the size of the derived asset objectID in
+// bucket, or 0 if it cannot be read; a sync records the size later.
+func derivedObjectSize(ctx context.Context, bucket *storage.BucketHandle, objectID string) int64 {
+	_, attrsSpan := startSpan(ctx, "gcs.get_object_attrs")
+	attrs, err := bucket.Object(objectID).Attrs(ctx)
+	if err != nil {
+		recordSpanError(attrsSpan, err)
+		slog.WarnContext(ctx, "failed to read size of derived object",
+			slog.String("derived_object_id", objectID),
+			slog.String("error", err.Error()),
+		)
+		return 0
+	}
+	endSpanOk(attrsSpan)
+	return attrs.Size
+}
+
+// forgetDerivedObject deletes the record of a derived asset. Errors are
+// logged but not fatal.
+func forgetDerivedObject(ctx context.Context, db *gorm.DB, objectID string) {
+	_, dbSpan := startSpan(ctx, "db.delete_derived_object")
+	if err := db.Where("object_id = ?", objectID).Delete(&database.DerivedObject{}).Error; err != nil {
+		recordSpanError(dbSpan, err)
+		slog.WarnContext(ctx, "failed to delete derived object record",
+			slog.String("derived_object_id", objectID),
+			slog.String("error", err.Error()),
+		)
+		return
+	}
+	endSpanOk(dbSpan)
+}
+
+// derivedObjectSet is the set of object IDs recorded as derived assets.
+type derivedObjectSet map[string]struct{}
+
+// loadDerivedObjectSet returns the object IDs of all recorded derived
+// assets. The bucket is shared, so the derived assets of every user are
+// included.
+func loadDerivedObjectSet(db *gorm.DB) (derivedObjectSet, error) {
+	var objectIDs []string
+	if err := db.Model(&database.DerivedObject{}).Pluck("object_id", &objectIDs).Error; err != nil {
+		return nil, err
+	}
+	set := make(derivedObjectSet, len(objectIDs))
+	for _, objectID := range objectIDs {
+		set[objectID] = struct{}{}
+	}
+	return set, nil
+}
+
+// contains reports whether objectID is a recorded derived asset.
+func (d derivedObjectSet) contains(objectID string) bool {
+	_, ok := d[objectID]
+	return ok
+}
+
+// getDerivedObjects returns the recorded derived assets of the given photos,
+// keyed by photo object ID and ordered by object ID.
+func getDerivedObjects(db *gorm.DB, userID uint, photoObjectIDs []string) (map[string][]database.DerivedObject, error) {
+	derived := make(map[string][]database.DerivedObject)
+	if len(photoObjectIDs) == 0 {
+		return derived, nil
+	}
+
+	var rows []database.DerivedObject
+	if err := db.Where("source_object_id IN ? AND user_id = ?", photoObjectIDs, userID).Order("object_id").Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		derived[row.SourceObjectID] = append(derived[row.SourceObjectID], row)
+	}
+	return derived, nil
+}
+
+// isDerivedObject reports whether objectID is a recorded derived asset.
+func isDerivedObject(db *gorm.DB, objectID string) bool {
+	var count int64
+	if err := db.Model(&database.DerivedObject{}).Where("object_id = ?", objectID).Count(&count).Error; err != nil {
+		return false
+	}
+	return count > 0
+}
+
+// splitDerivedObjects removes the derived assets, recorded in derived or
+// marked in their GCS metadata, from gcsObjects and returns them in a
+// separate map.
+func splitDerivedObjects(gcsObjects map[string]*storage.ObjectAttrs, derived derivedObjectSet) map[string]*storage.ObjectAttrs {
+	derivedObjects := make(map[string]*storage.ObjectAttrs)
+	for id, attrs := range gcsObjects {
+		_, _, marked := markedDerivedObject(attrs)
+		if marked || derived.contains(id) {
+			derivedObjects[id] = attrs
+			delete(gcsObjects, id)
+		}
+	}
+	return derivedObjects
+}
+
+// recordMarkedDerivedObjects records the derived assets marked in their GCS
+// metadata that have no record, such as after the database has been rebuilt.
+// They are recorded against the owner of their original, or userID if the
+// original is not in the database. The sizes of those already recorded are
+// brought up to date. It returns the number recorded.
+func recordMarkedDerivedObjects(ctx context.Context, db *gorm.DB, userID uint, derivedObjects map[string]*storage.ObjectAttrs, derived derivedObjectSet) int {
+	recorded := 0
+	for id, attrs := range derivedObjects {
+		if derived.contains(id) {
+			if err := db.Model(&database.DerivedObject{}).
+				Where("object_id = ? AND size_bytes <> ?", id, attrs.Size).
+				Update("size_bytes", attrs.Size).Error; err != nil {
+				slog.WarnContext(ctx, "failed to record size of derived object",
+					slog.String("derived_object_id", id),
+					slog.String("error", err.Error()),
+				)
+			}
+			continue
+		}
+		sourceObjectID, kind, marked := markedDerivedObject(attrs)
+		if !marked {
+			continue
+		}
+		owner := userID
+		var source database.PhotoObject
+		if err := db.Where("object_id = ?", sourceObjectID).First(&source).Error; err == nil {
+			owner = source.UserID
+		}
+		recordDerivedObject(ctx, db, owner, kind, sourceObjectID, id, attrs.Size)
+		derived[id] = struct{}{}
+		recorded++
+	}
+	return recorded
+}
+
+// copyDerivedObjects copies the WebP and AVIF renditions, video transcodes
+// and preview or thumbnail of sourcePhotoID so that they sit next to destPhotoID, records
+// them and references them from the destination photo. If move is true the
+// source objects and records are removed afterwards. Fixed-size thumbnails
+// are handled by copyRenditions. Errors are logged but not fatal, as the
+// photo itself has already been copied.
+func copyDerivedObjects(ctx context.Context, db *gorm.DB, bucket *storage.BucketHandle, userID uint, sourcePhotoID, destPhotoID string, move bool) {
+	var sources []database.DerivedObject
+	if err := db.Where("source_object_id = ? AND user_id = ? AND kind != ?", sourcePhotoID, userID, database.DerivedKindRendition).
+		Find(&sources).Error; err != nil {
+		slog.WarnContext(ctx, "failed to list derived objects",
+			slog.String("object_id", sourcePhotoID),
+			slog.String("error", err.Error()),
+		)
+		return
+	}
+
+	for _, source := range sources {
+		destID := movedDerivedObjectID(source.Kind, source.ObjectID, sourcePhotoID, destPhotoID)
+		if destID == "" {
+			continue
+		}
+		_, copySpan := startSpan(ctx, "gcs.copy_object")
+		destObj := bucket.Object(destID)
+		conditions, err := derivedObjectConditions(ctx, destObj, source.Kind, destPhotoID)
+		var copied *storage.ObjectAttrs
+		if err == nil {
+			copier := destObj.If(conditions).CopierFrom(bucket.Object(source.ObjectID))
+			copier.Metadata = derivedObjectMetadata(source.Kind, destPhotoID)
+			copied, err = copier.Run(ctx)
+		}
+		if err != nil {
+			recordSpanError(copySpan, err)
+			slog.WarnContext(ctx, "failed to copy derived object",
+				slog.String("derived_object_id", source.ObjectID),
+				slog.String("destination", destID),
+				slog.String("error", err.Error()),
+			)
+			continue
+		}
+		endSpanOk(copySpan)
+		recordDerivedObject(ctx, db, userID, source.Kind, destPhotoID, destID, copied.Size)
+
+		if column := derivedObjectColumn(source.Kind, destID); column != "" {
+			referenceDerivedObject(ctx, db, userID, destPhotoID, column, destID)
+		}
+
+		if move {
+			deleteDerivedObject(ctx, db, bucket, source.ObjectID)
+		}
+	}
+}
+
+// referenceDerivedObject sets column of the photo objectID to the derived
+// asset derivedObjectID. Errors are logged but not fatal.
+func referenceDerivedObject(ctx context.Context, db *gorm.DB, userID uint, objectID, column, derivedObjectID string) {
+	_, dbSpan := startSpan(ctx, "db.update_"+column)
+	if err := db.Model(&database.PhotoObject{}).
+		Where("object_id = ? AND user_id = ?", objectID, userID).
+		Update(column, derivedObjectID).Error; err != nil {
+		recordSpanError(dbSpan, err)
+		slog.WarnContext(ctx, "failed to reference derived object",
+			slog.String("object_id", objectID),
+			slog.String("derived_object_id", derivedObjectID),
+			slog.String("error", err.Error()),
+		)
+		return
+	}
+	endSpanOk(dbSpan)
+}
+
+// deleteDerivedObject removes a derived asset from GCS and its record. A nil
+// bucket removes the record only. Errors are logged but not fatal.
+func deleteDerivedObject(ctx context.Context, db *gorm.DB, bucket *storage.BucketHandle, objectID string) {
+	if bucket != nil {
+		_, gcsDelSpan := startSpan(ctx, "gcs.delete_object")
+		if err := bucket.Object(objectID).Delete(ctx); err != nil && err != storage.ErrObjectNotExist {
+			recordSpanError(gcsDelSpan, err)
+			slog.WarnContext(ctx, "failed to delete derived object from storage",
+				slog.String("derived_object_id", objectID),
+				slog.String("error", err.Error()),
+			)
+		} else {
+			endSpanOk(gcsDelSpan)
+		}
+	}
+	forgetDerivedObject(ctx, db, objectID)
+}
+
+// deleteDerivedObjects removes every derived asset recorded for a photo,
+// including its fixed-size thumbnails, from GCS and the database. Errors are
+// logged but not fatal.
+func deleteDerivedObjects(ctx context.Context, db *gorm.DB, bucket *storage.BucketHandle, userID uint, photoObjectID string) {
+	var derived []database.DerivedObject
+	if err := db.Where("source_object_id = ? AND user_id = ?", photoObjectID, userID).Find(&derived).Error; err != nil {
+		slog.WarnContext(ctx, "failed to list derived objects",
+			slog.String("object_id", photoObjectID),
+			slog.String("error", err.Error()),
+		)
+		return
+	}
+	for _, d := range derived {
+		deleteDerivedObject(ctx, db, bucket, d.ObjectID)
+	}
+
+	_, dbDelSpan := startSpan(ctx, "db.delete_photo_renditions")
+	if err := db.Where("photo_object_id = ? AND user_id = ?", photoObjectID, userID).Delete(&database.PhotoRendition{}).Error; err != nil {
+		recordSpanError(dbDelSpan, err)
+		slog.WarnContext(ctx, "failed to delete thumbnail records",
+			slog.String("object_id", photoObjectID),
+			slog.String("error", err.Error()),
+		)
+		return
+	}
+	endSpanOk(dbDelSpan)
+}
@@ -0,0 +1,304 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/alexhokl/photos/database"
+	"google.golang.org/api/option"
+)
+
+func TestDerivedObjectID(t *testing.T) {
+	tests := []struct {
+		kind     string
+		source   string
+		expected string
+	}{
+		{database.DerivedKindWebP, "a/b/image.jpg", "a/b/image.jpg.webp"},
+		{database.DerivedKindPreview, "a/b/IMG_001.dng", "a/b/IMG_001.dng_preview.jpg"},
+		{database.DerivedKindThumbnail, "a/b/clip.mp4", "a/b/clip.mp4_thumb.jpg"},
+		{database.DerivedKindRendition, "a/b/image.jpg", ""},
+	}
+	for _, test := range tests {
+		if got := derivedObjectID(test.kind, test.source); got != test.expected {
+			t.Errorf("derivedObjectID(%q, %q) = %q, want %q", test.kind, test.source, got, test.expected)
+		}
+	}
+}
+
+func TestMarkedDerivedObject(t *testing.T) {
+	attrs := &storage.ObjectAttrs{Metadata: derivedObjectMetadata(database.DerivedKindWebP, "image.jpg")}
+	source, kind, ok := markedDerivedObject(attrs)
+	if !ok || source != "image.jpg" || kind != database.DerivedKindWebP {
+		t.Errorf("markedDerivedObject() = %q, %q, %v, want image.jpg, webp, true", source, kind, ok)
+	}
+
+	for _, attrs := range []*storage.ObjectAttrs{
+		{},
+		{Metadata: map[string]string{"other": "value"}},
+		{Metadata: map[string]string{MetadataKeyDerivedFrom: ""}},
+	} {
+		if _, _, ok := markedDerivedObject(attrs); ok {
+			t.Errorf("markedDerivedObject(%v) = true, want false", attrs.Metadata)
+		}
+	}
+}
+
+func TestSplitDerivedObjects(t *testing.T) {
+	gcsObjects := map[string]*storage.ObjectAttrs{
+		"photo.jpg": {Name: "photo.jpg", ContentType: "image/jpeg"},
+		"photo.webp": {
+			Name:        "photo.webp",
+			ContentType: "image/webp",
+			Metadata:    derivedObjectMetadata(database.DerivedKindWebP, "photo.jpg"),
+		},
+		"clip_thumb.jpg": {Name: "clip_thumb.jpg", ContentType: "image/jpeg"},
+		// A WebP uploaded by the user is neither marked nor recorded
+		"upload.webp": {Name: "upload.webp", ContentType: "image/webp"},
+	}
+
+	derivedObjects := splitDerivedObjects(gcsObjects, derivedObjectSet{"clip_thumb.jpg": {}})
+
+	if len(derivedObjects) != 2 {
+		t.Errorf("expected 2 derived objects, got %d", len(derivedObjects))
+	}
+	for _, id := range []string{"photo.webp", "clip_thumb.jpg"} {
+		if _, ok := derivedObjects[id]; !ok {
+			t.Errorf("expected %s to be split off as derived", id)
+		}
+		if _, ok := gcsObjects[id]; ok {
+			t.Errorf("expected %s to be removed from the originals", id)
+		}
+	}
+	for _, id := range []string{"photo.jpg", "upload.webp"} {
+		if _, ok := gcsObjects[id]; !ok {
+			t.Errorf("expected %s to be kept as an original", id)
+		}
+	}
+}
+
+func TestRecordMarkedDerivedObjects(t *testing.T) {
+	db := setupLibraryTestDB(t)
+
+	if err := db.Create(&database.PhotoObject{ObjectID: "photo.jpg", ContentType: "image/jpeg", MD5Hash: "a", UserID: 2}).Error; err != nil {
+		t.Fatalf("failed to create photo: %v", err)
+	}
+	recordDerivedObject(context.Background(), db, 2, database.DerivedKindPreview, "raw.dng", "raw_preview.jpg", 0)
+
+	derived, err := loadDerivedObjectSet(db)
+	if err != nil {
+		t.Fatalf("loadDerivedObjectSet returned error: %v", err)
+	}
+	derivedObjects := map[string]*storage.ObjectAttrs{
+		"photo.webp":      {Metadata: derivedObjectMetadata(database.DerivedKindWebP, "photo.jpg")},
+		"gone_256px.jpg":  {Metadata: derivedObjectMetadata(database.DerivedKindRendition, "gone.jpg")},
+		"raw_preview.jpg": {Metadata: derivedObjectMetadata(database.DerivedKindPreview, "raw.dng")},
+	}
+
+	if recorded := recordMarkedDerivedObjects(context.Background(), db, 1, derivedObjects, derived); recorded != 2 {
+		t.Errorf("expected 2 derived objects recorded, got %d", recorded)
+	}
+
+	var webp database.DerivedObject
+	if err := db.Where("object_id = ?", "photo.webp").First(&webp).Error; err != nil {
+		t.Fatalf("expected photo.webp to be recorded: %v", err)
+	}
+	if webp.UserID != 2 || webp.SourceObjectID != "photo.jpg" || webp.Kind != database.DerivedKindWebP {
+		t.Errorf("photo.webp recorded as %+v, want owned by the photo's user", webp)
+	}
+
+	var orphan database.DerivedObject
+	if err := db.Where("object_id = ?", "gone_256px.jpg").First(&orphan).Error; err != nil {
+		t.Fatalf("expected gone_256px.jpg to be recorded: %v", err)
+	}
+	if orphan.UserID != 1 {
+		t.Errorf("expected unknown original to be recorded against user 1, got %d", orphan.UserID)
+	}
+
+	if !derived.contains("photo.webp") || !derived.contains("gone_256px.jpg") {
+		t.Error("expected recorded objects to be added to the set")
+	}
+	if !isDerivedObject(db, "photo.webp") || isDerivedObject(db, "photo.jpg") {
+		t.Error("isDerivedObject does not reflect the records")
+	}
+}
+
+func TestDeleteDerivedObjects(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	ctx := context.Background()
+
+	recordDerivedObject(ctx, db, 1, database.DerivedKindWebP, "photo.jpg", "photo.webp", 0)
+	recordDerivedObject(ctx, db, 1, database.DerivedKindRendition, "photo.jpg", "photo_256px.jpg", 0)
+	// Another photo's derived asset is kept
+	recordDerivedObject(ctx, db, 2, database.DerivedKindWebP, "other.jpg", "other.webp", 0)
+	rendition := &database.PhotoRendition{ObjectID: "photo_256px.jpg", PhotoObjectID: "photo.jpg", UserID: 1, LongEdge: 256, ContentType: "image/jpeg"}
+	if err := db.Create(rendition).Error; err != nil {
+		t.Fatalf("failed to create rendition: %v", err)
+	}
+
+	deleteDerivedObjects(ctx, db, nil, 1, "photo.jpg")
+
+	var derivedCount, renditionCount int64
+	db.Model(&database.DerivedObject{}).Count(&derivedCount)
+	db.Model(&database.PhotoRendition{}).Count(&renditionCount)
+	if derivedCount != 1 {
+		t.Errorf("expected 1 derived object to remain, got %d", derivedCount)
+	}
+	if renditionCount != 0 {
+		t.Errorf("expected renditions to be deleted, %d remain", renditionCount)
+	}
+}
+
+func TestDerivedObjectID_RawAndJPEGPair(t *testing.T) {
+	kinds := []string{
+		database.DerivedKindWebP,
+		database.DerivedKindAVIF,
+		database.DerivedKindPreview,
+		database.DerivedKindThumbnail,
+		database.DerivedKindProxy,
+		database.DerivedKindHLS,
+		database.DerivedKindAnimatedPreview,
+		database.DerivedKindMotionVideo,
+	}
+	for _, kind := range kinds {
+		if raw, jpeg := derivedObjectID(kind, "a/IMG_0001.DNG"), derivedObjectID(kind, "a/IMG_0001.JPG"); raw == jpeg {
+			t.Errorf("derivedObjectID(%q) of a RAW and JPEG pair are both %q", kind, raw)
+		}
+	}
+	if renditionObjectID("a/IMG_0001.DNG", 256) == renditionObjectID("a/IMG_0001.JPG", 256) {
+		t.Error("renditionObjectID of a RAW and JPEG pair are the same")
+	}
+	// Nor is the WebP of a photo an original WebP of the same name
+	if webpObjectID("a/IMG_0001.JPG") == "a/IMG_0001.webp" {
+		t.Error("webpObjectID of a JPEG is the name of a WebP next to it")
+	}
+}
+
+func TestForgetReplacedDerivedObject(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	ctx := context.Background()
+
+	webp := "photo.webp"
+	otherWebP := "other.webp"
+	records := []any{
+		&database.PhotoObject{ObjectID: "photo.jpg", WebpObjectID: &webp, UserID: 1},
+		&database.PhotoObject{ObjectID: "other.jpg", WebpObjectID: &otherWebP, UserID: 2},
+		&database.DerivedObject{ObjectID: "other.webp", SourceObjectID: "other.jpg", Kind: database.DerivedKindWebP, UserID: 2},
+		&database.PhotoRendition{ObjectID: "photo_256px.jpg", PhotoObjectID: "photo.jpg", UserID: 1, LongEdge: 256, ContentType: "image/jpeg"},
+		&database.DerivedObject{ObjectID: "photo.webp", SourceObjectID: "photo.jpg", Kind: database.DerivedKindWebP, UserID: 1},
+		&database.DerivedObject{ObjectID: "photo_256px.jpg", SourceObjectID: "photo.jpg", Kind: database.DerivedKindRendition, UserID: 1},
+	}
+	for _, record := range records {
+		if err := db.Create(record).Error; err != nil {
+			t.Fatalf("failed to create %T: %v", record, err)
+		}
+	}
+
+	// An original is uploaded as photo.webp
+	forgetReplacedDerivedObject(ctx, db, 1, "photo.webp")
+	if isDerivedObject(db, "photo.webp") || !isDerivedObject(db, "photo_256px.jpg") {
+		t.Error("expected only photo.webp to be forgotten as a derived object")
+	}
+	var photo database.PhotoObject
+	db.Where("object_id = ?", "photo.jpg").First(&photo)
+	if photo.WebpObjectID != nil {
+		t.Errorf("webp_object_id = %q, want it cleared", *photo.WebpObjectID)
+	}
+
+	forgetReplacedDerivedObject(ctx, db, 1, "photo_256px.jpg")
+	var renditionCount int64
+	db.Model(&database.PhotoRendition{}).Count(&renditionCount)
+	if renditionCount != 0 || isDerivedObject(db, "photo_256px.jpg") {
+		t.Errorf("expected the thumbnail to be forgotten, %d renditions remain", renditionCount)
+	}
+
+	// Another user writing to the name does not touch the first user's records
+	forgetReplacedDerivedObject(ctx, db, 1, "other.webp")
+	var other database.PhotoObject
+	db.Where("object_id = ?", "other.jpg").First(&other)
+	if other.WebpObjectID == nil || !isDerivedObject(db, "other.webp") {
+		t.Error("expected another user's derived object to be kept")
+	}
+}
+
+func TestMovedDerivedObjectID(t *testing.T) {
+	tests := []struct {
+		kind     string
+		objectID string
+		source   string
+		dest     string
+		expected string
+	}{
+		{database.DerivedKindWebP, "a/image.jpg.webp", "a/image.jpg", "b/copy.jpg", "b/copy.jpg.webp"},
+		{database.DerivedKindWebP, "a/image.jpg_web.jpg", "a/image.jpg", "b/copy.jpg", "b/copy.jpg_web.jpg"},
+		{database.DerivedKindPreview, "a/IMG_001.dng_preview.jpg", "a/IMG_001.dng", "b/IMG_002.dng", "b/IMG_002.dng_preview.jpg"},
+		// Named before the extension of its photo was kept in its name
+		{database.DerivedKindWebP, "a/image.webp", "a/image.jpg", "b/copy.jpg", "b/copy.jpg.webp"},
+		{database.DerivedKindPreview, "a/IMG_001_preview.jpg", "a/IMG_001.dng", "b/IMG_002.dng", "b/IMG_002.dng_preview.jpg"},
+		// Not named after its photo
+		{database.DerivedKindThumbnail, "other/poster.jpg", "a/clip.mp4", "b/clip.mp4", "b/clip.mp4_thumb.jpg"},
+	}
+	for _, test := range tests {
+		if got := movedDerivedObjectID(test.kind, test.objectID, test.source, test.dest); got != test.expected {
+			t.Errorf("movedDerivedObjectID(%q, %q, %q) = %q, want %q", test.objectID, test.source, test.dest, got, test.expected)
+		}
+	}
+}
+
+func TestDerivedObjectConditions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/storage/v1/b/photos/o/missing.webp":
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"error":{"code":404,"message":"Not Found"}}`)
+		case "/storage/v1/b/photos/o/derived.webp":
+			fmt.Fprint(w, `{"bucket":"photos","name":"derived.webp","generation":"7","metadata":{"derived_from":"a.jpg","derived_kind":"webp"}}`)
+		case "/storage/v1/b/photos/o/original.webp":
+			fmt.Fprint(w, `{"bucket":"photos","name":"original.webp","generation":"8"}`)
+		default:
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `{"error":{"code":403,"message":"Forbidden"}}`)
+		}
+	}))
+	t.Cleanup(server.Close)
+	client, err := storage.NewClient(context.Background(), option.WithEndpoint(server.URL+"/storage/v1/"), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatalf("failed to create storage client: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	bucket := client.Bucket("photos")
+
+	tests := []struct {
+		objectID    string
+		expected    storage.Conditions
+		expectError bool
+	}{
+		{"missing.webp", storage.Conditions{DoesNotExist: true}, false},
+		{"derived.webp", storage.Conditions{GenerationMatch: 7}, false},
+		{"original.webp", storage.Conditions{DoesNotExist: true}, false},
+		{"forbidden.webp", storage.Conditions{}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.objectID, func(t *testing.T) {
+			conditions, err := derivedObjectConditions(t.Context(), bucket.Object(test.objectID), database.DerivedKindWebP, "a.jpg")
+			if test.expectError {
+				if err == nil || errors.Is(err, storage.ErrObjectNotExist) {
+					t.Errorf("derivedObjectConditions() error = %v, want a read error", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("derivedObjectConditions() error = %v", err)
+			}
+			if conditions != test.expected {
+				t.Errorf("derivedObjectConditions() = %+v, want %+v", conditions, test.expected)
+			}
+		})
+	}
+}
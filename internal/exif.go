@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"fmt"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/rwcarlsen/goexif/exif"
@@ -44,6 +43,9 @@ type PhotoMetadataInfo struct {
 	ExposureTime float64
 	// LensModel is the lens name (e.g., "EF 50mm f/1.4 USM")
 	LensModel string
+	// ContentIdentifier is the Apple ContentIdentifier shared by the still
+	// and the video of an iPhone Live Photo
+	ContentIdentifier string
 }
 
 // GCS metadata keys for storing photo metadata
@@ -61,38 +63,67 @@ const (
 	MetadataKeyAperture         = "aperture"
 	MetadataKeyExposureTime     = "exposure_time"
 	MetadataKeyLensModel        = "lens_model"
+	// MetadataKeyContentIdentifier holds the Apple ContentIdentifier pairing
+	// the still and the video of a Live Photo
+	MetadataKeyContentIdentifier = "content_identifier"
 )
 
 // ExtractPhotoMetadata extracts EXIF metadata from image data.
 // It returns a PhotoMetadataInfo struct with available metadata.
 // Fields that cannot be extracted will have their Has* flags set to false.
 //
-// For DNG raw images the raw bytes cannot be parsed by goexif directly.
-// GenerateDNGPreview is called first to extract the embedded JPEG, and EXIF
-// is then read from that JPEG instead.
+// For camera RAW images (see RawFormats) GenerateRawPreview is called first
+// to extract the embedded JPEG, and EXIF is read from that JPEG. Previews
+// without EXIF fall back to the RAW file itself, which goexif can read if it
+// is TIFF based.
+//
+// For HEIC images EXIF is read from the Exif item of the file (see readHEIF).
+//
+// Videos have no EXIF; of their metadata only the ContentIdentifier of an
+// iPhone Live Photo video is read (see readQuickTimeContentIdentifier).
 func ExtractPhotoMetadata(data []byte, originalFilename string) *PhotoMetadataInfo {
 	info := &PhotoMetadataInfo{
 		OriginalFilename: originalFilename,
 	}
 
-	// For DNG files, extract the embedded JPEG preview and read EXIF from it.
-	// Detect DNG by checking the originalFilename extension (content-type is not
-	// always available here, but the extension is reliable).
-	lowerName := strings.ToLower(originalFilename)
-	if strings.HasSuffix(lowerName, ".dng") {
-		if jpegData, err := GenerateDNGPreview(data); err == nil && len(jpegData) > 0 {
-			data = jpegData
+	if IsVideoContentType(DetectContentType(data)) {
+		info.ContentIdentifier = readQuickTimeContentIdentifier(data)
+		return info
+	}
+
+	// For RAW files, extract the embedded JPEG preview and read EXIF from it.
+	// Detect RAW by checking the originalFilename extension (content-type is
+	// not always available here, but the extension is reliable).
+	var x *exif.Exif
+	if rawFormatByExtension(originalFilename) != nil {
+		if jpegData, err := GenerateRawPreview(nil, data); err == nil && len(jpegData) > 0 {
+			x, _ = exif.Decode(bytes.NewReader(jpegData))
+		}
+		// If there is no preview or it has no EXIF, fall through and let
+		// goexif try the RAW file itself.
+	}
+
+	// HEIC files keep their EXIF in an item of the HEIF container, which is
+	// read in Go, and the size of the image in its "ispe" property.
+	if IsHEICContentType(DetectContentType(data)) {
+		if file, err := readHEIF(data); err == nil {
+			if file.Width > 0 && file.Height > 0 {
+				info.Width = file.Width
+				info.Height = file.Height
+				info.HasDimensions = true
+			}
+			data = file.Exif(data)
 		}
-		// If GenerateDNGPreview fails, fall through and let goexif try anyway —
-		// it will simply return no EXIF, which is the same outcome as before.
 	}
 
 	// Try to decode EXIF data
-	reader := bytes.NewReader(data)
-	x, err := exif.Decode(reader)
-	if err != nil {
-		// No EXIF data or failed to parse - return with just the filename
-		return info
+	if x == nil {
+		var err error
+		x, err = exif.Decode(bytes.NewReader(data))
+		if err != nil {
+			// No EXIF data or failed to parse - return with just the filename
+			return info
+		}
 	}
 
 	// Extract GPS coordinates
@@ -202,6 +233,12 @@ func ExtractPhotoMetadata(data []byte, originalFilename string) *PhotoMetadataIn
 		}
 	}
 
+	// Extract the Live Photo identifier from the Apple maker note
+	makerNoteTag, err := x.Get(exif.MakerNote)
+	if err == nil {
+		info.ContentIdentifier = appleContentIdentifier(makerNoteTag.Val)
+	}
+
 	return info
 }
 
@@ -256,6 +293,10 @@ func (p *PhotoMetadataInfo) ToGCSMetadata() map[string]string {
 		metadata[MetadataKeyLensModel] = p.LensModel
 	}
 
+	if p.ContentIdentifier != "" {
+		metadata[MetadataKeyContentIdentifier] = p.ContentIdentifier
+	}
+
 	return metadata
 }
 
@@ -334,6 +375,10 @@ func ParseGCSMetadata(metadata map[string]string) *PhotoMetadataInfo {
 		info.LensModel = lensModel
 	}
 
+	if contentIdentifier, ok := metadata[MetadataKeyContentIdentifier]; ok {
+		info.ContentIdentifier = contentIdentifier
+	}
+
 	return info
 }
 
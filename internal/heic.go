@@ -0,0 +1,423 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// IsHEICContentType returns true if the content type represents a HEIC or
+// HEIF image, as uploaded by iPhones.
+func IsHEICContentType(contentType string) bool {
+	switch strings.ToLower(contentType) {
+	case "image/heic", "image/heif", "image/heic-sequence", "image/heif-sequence":
+		return true
+	}
+	return false
+}
+
+// GenerateHEICPreview decodes HEIC image data to a JPEG preview using
+// heif-convert from libheif, which applies the rotation and mirroring stored
+// in the file.
+//
+// HEVC cannot be decoded in Go, so there is no fallback: if heif-convert is
+// not installed an error wrapping exec.ErrNotFound is returned and the photo
+// has no preview, WebP or thumbnails. Its metadata is still read and its
+// location stripped in Go (see readHEIF).
+func GenerateHEICPreview(caps *Capabilities, data []byte) ([]byte, error) {
+	if !caps.Has(ToolHeifConvert) {
+		return nil, fmt.Errorf("cannot decode HEIC: %w", &exec.Error{Name: ToolHeifConvert, Err: exec.ErrNotFound})
+	}
+
+	// heif-convert reads and writes files, and writes auxiliary images such
+	// as depth maps next to the output, so everything goes in a temp dir.
+	tmpDir, err := os.MkdirTemp("", "heic-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	inputPath := filepath.Join(tmpDir, "input.heic")
+	outputPath := filepath.Join(tmpDir, "preview.jpg")
+	if err := os.WriteFile(inputPath, data, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write HEIC temp file: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, ToolHeifConvert, "-q", "90", inputPath, outputPath)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("heif-convert failed: %w, stderr: %s", err, stderr.String())
+	}
+
+	preview, err := os.ReadFile(outputPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read heif-convert output: %w", err)
+	}
+	if len(preview) == 0 {
+		return nil, fmt.Errorf("heif-convert produced no output")
+	}
+	return preview, nil
+}
+
+// errNotHEIF is returned by readHEIF for data that is not a HEIF file.
+var errNotHEIF = errors.New("not a HEIF file")
+
+// heifFile is what readHEIF finds in the "meta" box of a HEIF file.
+type heifFile struct {
+	// Width and Height are those of the primary image ("ispe" property),
+	// before any rotation
+	Width  int
+	Height int
+	// ExifOffset and ExifLength locate the payload of the Exif item in the
+	// file; ExifLength is 0 if there is none
+	ExifOffset uint64
+	ExifLength uint64
+}
+
+// Exif returns the TIFF structure of the Exif item, which follows a 4 byte
+// offset to the TIFF header, or nil if there is none.
+func (h *heifFile) Exif(data []byte) []byte {
+	if h.ExifLength < 4 || !heifExtentInData(h.ExifOffset, h.ExifLength, len(data)) {
+		return nil
+	}
+	item := data[h.ExifOffset : h.ExifOffset+h.ExifLength]
+	start := 4 + uint64(binary.BigEndian.Uint32(item[0:4]))
+	if start+8 > uint64(len(item)) {
+		return nil
+	}
+	return item[start:]
+}
+
+// heifExtentInData returns true if the extent of length bytes at offset lies
+// within data of size bytes. Offsets and lengths are read from the file, so
+// they are compared without adding them, which could wrap around.
+func heifExtentInData(offset, length uint64, size int) bool {
+	return offset <= uint64(size) && length <= uint64(size)-offset
+}
+
+// heifItemLocation is an item extent from the "iloc" box.
+type heifItemLocation struct {
+	constructionMethod uint16
+	offset             uint64
+	length             uint64
+}
+
+// readHEIF reads the dimensions of the primary image and the location of the
+// Exif item from the "meta" box of HEIF data. Only the boxes needed for this
+// are parsed; the image data itself is HEVC coded and never decoded.
+func readHEIF(data []byte) (*heifFile, error) {
+	if len(data) < 12 || string(data[4:8]) != "ftyp" {
+		return nil, errNotHEIF
+	}
+	metas := mp4Boxes(data, "meta")
+	if len(metas) == 0 || len(metas[0]) < 4 {
+		return nil, fmt.Errorf("no meta box found")
+	}
+	// meta is a full box: skip its version and flags
+	meta := metas[0][4:]
+
+	var primaryID uint32
+	if pitms := mp4Boxes(meta, "pitm"); len(pitms) > 0 {
+		pitm := pitms[0]
+		switch {
+		case len(pitm) >= 6 && pitm[0] == 0:
+			primaryID = uint32(binary.BigEndian.Uint16(pitm[4:6]))
+		case len(pitm) >= 8:
+			primaryID = binary.BigEndian.Uint32(pitm[4:8])
+		}
+	}
+
+	file := &heifFile{}
+	file.Width, file.Height = heifImageSize(meta, primaryID)
+
+	exifID, ok := heifExifItemID(meta)
+	if !ok {
+		return file, nil
+	}
+	location, ok := heifItemLocations(meta)[exifID]
+	if !ok {
+		return file, nil
+	}
+	// Items stored in the "idat" box (construction method 1) are not
+	// supported; the Exif item is written to the file by cameras and phones
+	if location.constructionMethod != 0 {
+		return file, nil
+	}
+	if heifExtentInData(location.offset, location.length, len(data)) {
+		file.ExifOffset = location.offset
+		file.ExifLength = location.length
+	}
+	return file, nil
+}
+
+// heifExifItemID returns the ID of the item of type "Exif" listed in the
+// "iinf" box of meta.
+func heifExifItemID(meta []byte) (uint32, bool) {
+	iinfs := mp4Boxes(meta, "iinf")
+	if len(iinfs) == 0 || len(iinfs[0]) < 6 {
+		return 0, false
+	}
+	iinf := iinfs[0]
+	entries := iinf[6:]
+	if iinf[0] != 0 {
+		if len(iinf) < 8 {
+			return 0, false
+		}
+		entries = iinf[8:]
+	}
+	for _, infe := range mp4Boxes(entries, "infe") {
+		// Only version 2 and 3 entries have an item type
+		var id uint32
+		var itemType []byte
+		switch {
+		case len(infe) >= 12 && infe[0] == 2:
+			id = uint32(binary.BigEndian.Uint16(infe[4:6]))
+			itemType = infe[8:12]
+		case len(infe) >= 14 && infe[0] == 3:
+			id = binary.BigEndian.Uint32(infe[4:8])
+			itemType = infe[10:14]
+		default:
+			continue
+		}
+		if string(itemType) == "Exif" {
+			return id, true
+		}
+	}
+	return 0, false
+}
+
+// heifItemLocations returns the location of each item stored in a single
+// extent, read from the "iloc" box of meta.
+func heifItemLocations(meta []byte) map[uint32]heifItemLocation {
+	locations := make(map[uint32]heifItemLocation)
+	ilocs := mp4Boxes(meta, "iloc")
+	if len(ilocs) == 0 || len(ilocs[0]) < 8 {
+		return locations
+	}
+	iloc := ilocs[0]
+	version := iloc[0]
+	offsetSize := int(iloc[4] >> 4)
+	lengthSize := int(iloc[4] & 0x0f)
+	baseOffsetSize := int(iloc[5] >> 4)
+	indexSize := 0
+	if version == 1 || version == 2 {
+		indexSize = int(iloc[5] & 0x0f)
+	}
+
+	r := &heifReader{data: iloc[6:]}
+	itemCount := r.uint(2)
+	if version == 2 {
+		itemCount = r.uint(4)
+	}
+	for i := uint64(0); i < itemCount && r.ok(); i++ {
+		idSize := 2
+		if version == 2 {
+			idSize = 4
+		}
+		id := uint32(r.uint(idSize))
+		var location heifItemLocation
+		if version == 1 || version == 2 {
+			location.constructionMethod = uint16(r.uint(2) & 0x0f)
+		}
+		r.uint(2) // data_reference_index
+		baseOffset := r.uint(baseOffsetSize)
+		extentCount := r.uint(2)
+		overflow := false
+		for j := uint64(0); j < extentCount && r.ok(); j++ {
+			r.uint(indexSize)
+			offset := r.uint(offsetSize)
+			if offset > math.MaxUint64-baseOffset {
+				overflow = true
+			}
+			location.offset = baseOffset + offset
+			location.length = r.uint(lengthSize)
+		}
+		if extentCount == 1 && r.ok() && !overflow {
+			locations[id] = location
+		}
+	}
+	return locations
+}
+
+// heifImageSize returns the size in the "ispe" property associated with item
+// id by the "ipma" box of meta, or zeros if there is none.
+func heifImageSize(meta []byte, id uint32) (int, int) {
+	iprps := mp4Boxes(meta, "iprp")
+	if len(iprps) == 0 {
+		return 0, 0
+	}
+	ipcos := mp4Boxes(iprps[0], "ipco")
+	ipmas := mp4Boxes(iprps[0], "ipma")
+	if len(ipcos) == 0 || len(ipmas) == 0 || len(ipmas[0]) < 8 {
+		return 0, 0
+	}
+
+	// Properties are referenced by their 1-based position in ipco
+	var properties [][]byte
+	var propertyTypes []string
+	for data := ipcos[0]; len(data) >= 8; {
+		size := uint64(binary.BigEndian.Uint32(data[0:4]))
+		if size < 8 || size > uint64(len(data)) {
+			break
+		}
+		propertyTypes = append(propertyTypes, string(data[4:8]))
+		properties = append(properties, data[8:size])
+		data = data[size:]
+	}
+
+	ipma := ipmas[0]
+	version := ipma[0]
+	largeIndex := ipma[3]&1 == 1
+	r := &heifReader{data: ipma[4:]}
+	entryCount := r.uint(4)
+	for i := uint64(0); i < entryCount && r.ok(); i++ {
+		idSize := 2
+		if version >= 1 {
+			idSize = 4
+		}
+		itemID := uint32(r.uint(idSize))
+		associationCount := r.uint(1)
+		for j := uint64(0); j < associationCount && r.ok(); j++ {
+			var index uint64
+			if largeIndex {
+				index = r.uint(2) & 0x7fff
+			} else {
+				index = r.uint(1) & 0x7f
+			}
+			if itemID != id || index == 0 || index > uint64(len(properties)) {
+				continue
+			}
+			property := properties[index-1]
+			// ispe is a full box holding the width and height
+			if propertyTypes[index-1] == "ispe" && len(property) >= 12 {
+				return int(binary.BigEndian.Uint32(property[4:8])), int(binary.BigEndian.Uint32(property[8:12]))
+			}
+		}
+	}
+	return 0, 0
+}
+
+// heifReader reads big-endian integers of the sizes given in "iloc" and
+// "ipma" boxes, remembering if it ran out of data.
+type heifReader struct {
+	data      []byte
+	truncated bool
+}
+
+// uint reads an integer of size bytes; a size of 0 reads nothing.
+func (r *heifReader) uint(size int) uint64 {
+	if size > len(r.data) {
+		r.truncated = true
+		r.data = nil
+		return 0
+	}
+	var value uint64
+	for _, b := range r.data[:size] {
+		value = value<<8 | uint64(b)
+	}
+	r.data = r.data[size:]
+	return value
+}
+
+// ok reports whether every read so far was complete.
+func (r *heifReader) ok() bool {
+	return !r.truncated
+}
+
+// stripHEIFLocation returns a copy of HEIF data with the GPS IFD removed from
+// its Exif item. The item is edited in place so that its length, and the
+// offsets of everything after it, are unchanged: the GPS IFD and its values
+// are zeroed and the pointer to it is removed from IFD0. Data without
+// location is returned unchanged.
+func stripHEIFLocation(data []byte) ([]byte, error) {
+	file, err := readHEIF(data)
+	if err != nil {
+		return nil, err
+	}
+	if file.Exif(data) == nil {
+		return data, nil
+	}
+
+	stripped := bytes.Clone(data)
+	if !removeTIFFGPS(file.Exif(stripped)) {
+		return data, nil
+	}
+	return stripped, nil
+}
+
+// tiffTypeSizes are the sizes in bytes of the TIFF field types.
+var tiffTypeSizes = map[uint16]uint64{
+	1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8, 13: 4,
+}
+
+// removeTIFFGPS zeroes the GPS IFD of the TIFF structure tiff and removes the
+// pointer to it from IFD0, without changing the length of tiff. It reports
+// whether there was a GPS IFD.
+func removeTIFFGPS(tiff []byte) bool {
+	var order binary.ByteOrder
+	switch string(tiff[:4]) {
+	case "II*\x00":
+		order = binary.LittleEndian
+	case "MM\x00*":
+		order = binary.BigEndian
+	default:
+		return false
+	}
+
+	ifd0 := uint64(order.Uint32(tiff[4:8]))
+	if ifd0+2 > uint64(len(tiff)) {
+		return false
+	}
+	count := uint64(order.Uint16(tiff[ifd0:]))
+	end := ifd0 + 2 + count*12 + 4
+	if end > uint64(len(tiff)) {
+		return false
+	}
+
+	for i := uint64(0); i < count; i++ {
+		entry := ifd0 + 2 + i*12
+		if order.Uint16(tiff[entry:]) != GPSTagID {
+			continue
+		}
+		zeroTIFFIFD(tiff, order, uint64(order.Uint32(tiff[entry+8:])))
+
+		// Shift the later entries and the next IFD offset over the pointer
+		copy(tiff[entry:end-12], tiff[entry+12:end])
+		clear(tiff[end-12 : end])
+		order.PutUint16(tiff[ifd0:], uint16(count-1))
+		return true
+	}
+	return false
+}
+
+// zeroTIFFIFD zeroes the IFD at offset and the values its entries point to.
+func zeroTIFFIFD(tiff []byte, order binary.ByteOrder, offset uint64) {
+	if offset+2 > uint64(len(tiff)) {
+		return
+	}
+	count := uint64(order.Uint16(tiff[offset:]))
+	end := min(offset+2+count*12+4, uint64(len(tiff)))
+	for i := uint64(0); i < count && offset+2+i*12+12 <= end; i++ {
+		entry := tiff[offset+2+i*12:]
+		size := tiffTypeSizes[order.Uint16(entry[2:4])] * uint64(order.Uint32(entry[4:8]))
+		if size <= 4 {
+			continue
+		}
+		if valueOffset := uint64(order.Uint32(entry[8:12])); valueOffset+size <= uint64(len(tiff)) {
+			clear(tiff[valueOffset : valueOffset+size])
+		}
+	}
+	clear(tiff[offset:end])
+}
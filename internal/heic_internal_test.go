@@ -0,0 +1,292 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math"
+	"os/exec"
+	"testing"
+)
+
+// buildTestExif returns a little-endian TIFF structure whose IFD0 holds the
+// camera make, the date taken and a GPS IFD locating the photo in Hong Kong.
+func buildTestExif(t testing.TB) []byte {
+	t.Helper()
+	type entry struct {
+		tag, fieldType uint16
+		count          uint32
+		value          []byte
+	}
+	rational := func(values ...uint32) []byte {
+		b := make([]byte, 0, len(values)*8)
+		for _, v := range values {
+			b = binary.LittleEndian.AppendUint32(b, v)
+			b = binary.LittleEndian.AppendUint32(b, 1)
+		}
+		return b
+	}
+	const gpsIFDOffset = 76
+
+	var buf, values bytes.Buffer
+	writeIFD := func(offset int, entries []entry) {
+		valueOffset := offset + 2 + len(entries)*12 + 4
+		_ = binary.Write(&buf, binary.LittleEndian, uint16(len(entries)))
+		for _, e := range entries {
+			_ = binary.Write(&buf, binary.LittleEndian, e.tag)
+			_ = binary.Write(&buf, binary.LittleEndian, e.fieldType)
+			_ = binary.Write(&buf, binary.LittleEndian, e.count)
+			if len(e.value) <= 4 {
+				buf.Write(append(e.value, make([]byte, 4-len(e.value))...))
+				continue
+			}
+			_ = binary.Write(&buf, binary.LittleEndian, uint32(valueOffset+values.Len()))
+			values.Write(e.value)
+		}
+		_ = binary.Write(&buf, binary.LittleEndian, uint32(0))
+		buf.Write(values.Bytes())
+		values.Reset()
+	}
+
+	buf.WriteString("II*\x00")
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(8))
+	writeIFD(8, []entry{
+		{0x010F, 2, 6, []byte("Apple\x00")},
+		{0x0132, 2, 20, []byte("2024:05:01 10:20:30\x00")},
+		{GPSTagID, 4, 1, binary.LittleEndian.AppendUint32(nil, gpsIFDOffset)},
+	})
+	if buf.Len() != gpsIFDOffset {
+		t.Fatalf("GPS IFD at %d, want %d", buf.Len(), gpsIFDOffset)
+	}
+	writeIFD(gpsIFDOffset, []entry{
+		{1, 2, 2, []byte("N\x00")},
+		{2, 5, 3, rational(22, 18, 0)},
+		{3, 2, 2, []byte("E\x00")},
+		{4, 5, 3, rational(114, 12, 0)},
+	})
+	return buf.Bytes()
+}
+
+// buildTestHEIC returns a minimal HEIF file: a 4032x3024 primary image item
+// without data and an Exif item holding exif, stored in the "mdat" box after
+// a "Exif\0\0" prefix as iPhones write it.
+func buildTestHEIC(t testing.TB, exif []byte) []byte {
+	t.Helper()
+	return buildTestHEICWithILoc(t, exif, nil)
+}
+
+// buildTestHEICWithILoc returns the HEIF file of buildTestHEIC with the
+// "iloc" box returned by iloc, given the offset and length of the Exif item,
+// if it is not nil.
+func buildTestHEICWithILoc(t testing.TB, exif []byte, iloc func(exifOffset, exifLength uint32) []byte) []byte {
+	t.Helper()
+	box := func(boxType string, payload ...[]byte) []byte {
+		body := bytes.Join(payload, nil)
+		b := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+		return append(append(b, boxType...), body...)
+	}
+	fullBox := func(boxType string, version byte, flags uint32, payload ...[]byte) []byte {
+		header := binary.BigEndian.AppendUint32(nil, uint32(version)<<24|flags)
+		return box(boxType, append([][]byte{header}, payload...)...)
+	}
+	u16 := func(v uint16) []byte { return binary.BigEndian.AppendUint16(nil, v) }
+	u32 := func(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+
+	exifItem := append(u32(6), "Exif\x00\x00"...)
+	exifItem = append(exifItem, exif...)
+
+	build := func(exifOffset uint32) []byte {
+		// offset_size 4, length_size 4, base_offset_size 0
+		ilocBox := fullBox("iloc", 0, 0, []byte{0x44, 0x00}, u16(1),
+			u16(2), u16(0), u16(1), u32(exifOffset), u32(uint32(len(exifItem))),
+		)
+		if iloc != nil {
+			ilocBox = iloc(exifOffset, uint32(len(exifItem)))
+		}
+		meta := fullBox("meta", 0, 0,
+			fullBox("hdlr", 0, 0, u32(0), []byte("pict"), make([]byte, 13)),
+			fullBox("pitm", 0, 0, u16(1)),
+			fullBox("iinf", 0, 0, u16(2),
+				fullBox("infe", 2, 0, u16(1), u16(0), []byte("hvc1"), []byte{0}),
+				fullBox("infe", 2, 0, u16(2), u16(0), []byte("Exif"), []byte{0}),
+			),
+			ilocBox,
+			box("iprp",
+				box("ipco",
+					fullBox("hvcC", 0, 0),
+					fullBox("ispe", 0, 0, u32(4032), u32(3024)),
+				),
+				fullBox("ipma", 0, 0, u32(1), u16(1), []byte{2, 0x81, 0x02}),
+			),
+		)
+		ftyp := box("ftyp", []byte("heic"), u32(0), []byte("mif1heic"))
+		return bytes.Join([][]byte{ftyp, meta, box("mdat", exifItem)}, nil)
+	}
+
+	withoutOffset := build(0)
+	return build(uint32(len(withoutOffset) - len(exifItem)))
+}
+
+func TestReadHEIF(t *testing.T) {
+	exif := buildTestExif(t)
+	data := buildTestHEIC(t, exif)
+
+	file, err := readHEIF(data)
+	if err != nil {
+		t.Fatalf("readHEIF returned error: %v", err)
+	}
+	if file.Width != 4032 || file.Height != 3024 {
+		t.Errorf("size = %dx%d, want 4032x3024", file.Width, file.Height)
+	}
+	if !bytes.Equal(file.Exif(data), exif) {
+		t.Error("Exif() does not return the TIFF structure of the Exif item")
+	}
+
+	if _, err := readHEIF(encodeTestJPEG(t, 4, 4)); !errors.Is(err, errNotHEIF) {
+		t.Errorf("readHEIF(JPEG) error = %v, want errNotHEIF", err)
+	}
+}
+
+func TestReadHEIF_ILocOutOfRange(t *testing.T) {
+	exif := buildTestExif(t)
+	u16 := func(v uint16) []byte { return binary.BigEndian.AppendUint16(nil, v) }
+	u64 := func(v uint64) []byte { return binary.BigEndian.AppendUint64(nil, v) }
+	// iloc with offset_size 8, length_size 8 and base_offset_size 8 whose
+	// extent of the Exif item is given by base and offset
+	iloc := func(base, offset, length uint64) func(uint32, uint32) []byte {
+		return func(uint32, uint32) []byte {
+			body := bytes.Join([][]byte{
+				{0, 0, 0, 0, 0x88, 0x80}, u16(1),
+				u16(2), u16(0), u64(base), u16(1), u64(offset), u64(length),
+			}, nil)
+			header := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+			return append(append(header, "iloc"...), body...)
+		}
+	}
+
+	tests := []struct {
+		name                 string
+		base, offset, length uint64
+	}{
+		{"offset past the end", 0, math.MaxUint64 - 15, 32},
+		{"length past the end", 0, 16, math.MaxUint64 - 8},
+		{"base and offset overflow", math.MaxUint64 - 4, 100, 16},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := buildTestHEICWithILoc(t, exif, iloc(test.base, test.offset, test.length))
+			file, err := readHEIF(data)
+			if err != nil {
+				t.Fatalf("readHEIF returned error: %v", err)
+			}
+			if got := file.Exif(data); got != nil {
+				t.Errorf("Exif() = %d bytes, want nil", len(got))
+			}
+			_ = ExtractPhotoMetadata(data, "IMG_0001.HEIC")
+		})
+	}
+}
+
+func FuzzReadHEIF(f *testing.F) {
+	f.Add(buildTestHEIC(f, buildTestExif(f)))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		file, err := readHEIF(data)
+		if err != nil {
+			return
+		}
+		if exif := file.Exif(data); exif != nil {
+			removeTIFFGPS(bytes.Clone(exif))
+		}
+	})
+}
+
+func TestExtractPhotoMetadata_HEIC(t *testing.T) {
+	info := ExtractPhotoMetadata(buildTestHEIC(t, buildTestExif(t)), "IMG_0001.HEIC")
+
+	if !info.HasDimensions || info.Width != 4032 || info.Height != 3024 {
+		t.Errorf("dimensions = %dx%d (%v), want 4032x3024", info.Width, info.Height, info.HasDimensions)
+	}
+	if !info.HasDateTaken || info.DateTaken.Format("2006-01-02 15:04:05") != "2024-05-01 10:20:30" {
+		t.Errorf("date taken = %v (%v), want 2024-05-01 10:20:30", info.DateTaken, info.HasDateTaken)
+	}
+	if !info.HasLocation || math.Abs(info.Latitude-22.3) > 1e-6 || math.Abs(info.Longitude-114.2) > 1e-6 {
+		t.Errorf("location = %f,%f (%v), want 22.3,114.2", info.Latitude, info.Longitude, info.HasLocation)
+	}
+	if info.CameraMake != "Apple" {
+		t.Errorf("camera make = %q, want Apple", info.CameraMake)
+	}
+}
+
+func TestStripLocationFromImage_HEIC(t *testing.T) {
+	data := buildTestHEIC(t, buildTestExif(t))
+	original := bytes.Clone(data)
+
+	stripped, err := StripLocationFromImage(data)
+	if err != nil {
+		t.Fatalf("StripLocationFromImage returned error: %v", err)
+	}
+	if len(stripped) != len(data) {
+		t.Errorf("stripped length = %d, want %d", len(stripped), len(data))
+	}
+	if !bytes.Equal(data, original) {
+		t.Error("expected the input to be left unmodified")
+	}
+	if bytes.Contains(stripped, []byte("N\x00")) {
+		t.Error("expected the GPS values to be zeroed")
+	}
+
+	info := ExtractPhotoMetadata(stripped, "IMG_0001.HEIC")
+	if info.HasLocation {
+		t.Error("expected location to be stripped")
+	}
+	if !info.HasDateTaken || info.CameraMake != "Apple" || !info.HasDimensions {
+		t.Errorf("expected other metadata to be kept, got %+v", info)
+	}
+
+	// Stripping again finds nothing to remove
+	again, err := StripLocationFromImage(stripped)
+	if err != nil || !bytes.Equal(again, stripped) {
+		t.Errorf("StripLocationFromImage(stripped) = %v, want unchanged", err)
+	}
+}
+
+func TestGenerateHEICPreview_MissingTool(t *testing.T) {
+	if _, err := GenerateHEICPreview(&Capabilities{}, buildTestHEIC(t, buildTestExif(t))); !errors.Is(err, exec.ErrNotFound) {
+		t.Errorf("GenerateHEICPreview error = %v, want exec.ErrNotFound", err)
+	}
+}
+
+func TestHasPreviewContentType(t *testing.T) {
+	tests := []struct {
+		contentType string
+		expected    bool
+	}{
+		{"image/x-adobe-dng", true},
+		{"image/heic", true},
+		{"IMAGE/HEIF", true},
+		{"image/jpeg", false},
+		{"video/quicktime", false},
+	}
+	for _, test := range tests {
+		if got := HasPreviewContentType(test.contentType); got != test.expected {
+			t.Errorf("HasPreviewContentType(%q) = %v, want %v", test.contentType, got, test.expected)
+		}
+	}
+}
+
+func TestPreviewObjectID(t *testing.T) {
+	tests := []struct {
+		objectID string
+		expected string
+	}{
+		{"photos/2024/IMG_001.dng", "photos/2024/IMG_001.dng_preview.jpg"},
+		{"IMG_002.DNG", "IMG_002.DNG_preview.jpg"},
+		{"iphone/IMG_0001.HEIC", "iphone/IMG_0001.HEIC_preview.jpg"},
+		{"noext", "noext_preview.jpg"},
+	}
+	for _, test := range tests {
+		if got := previewObjectID(test.objectID); got != test.expected {
+			t.Errorf("previewObjectID(%q) = %q, want %q", test.objectID, got, test.expected)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package internal
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/alexhokl/photos/proto"
+	"google.golang.org/grpc"
+)
+
+// archiveDownloader is the minimal interface required to serve ZIP archives
+// over HTTP. Like byteDownloader, it is satisfied by both
+// proto.ByteServiceClient and *ByteServerDownloader.
+type archiveDownloader interface {
+	DownloadArchive(ctx context.Context, in *proto.DownloadArchiveRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[proto.DownloadArchiveResponse], error)
+}
+
+// DownloadArchive runs the in-process server's DownloadArchive and returns
+// a client stream that receives its chunks.
+func (a *ByteServerDownloader) DownloadArchive(ctx context.Context, in *proto.DownloadArchiveRequest, _ ...grpc.CallOption) (grpc.ServerStreamingClient[proto.DownloadArchiveResponse], error) {
+	return newInProcessStream(ctx, func(stream grpc.ServerStreamingServer[proto.DownloadArchiveResponse]) error {
+		return a.Server.DownloadArchive(in, stream)
+	}), nil
+}
+
+// NewArchiveHandler returns an HTTP handler that streams a ZIP archive of
+// every photo under a prefix from the gRPC ByteService. The query parameters
+// includeDerived and stripLocation map to the fields of
+// DownloadArchiveRequest.
+//
+// The URL may contain a {prefix...} wildcard path parameter, e.g.:
+//
+//	GET /v1/archive/{prefix...}
+//
+// Without it the whole library is archived.
+func NewArchiveHandler(client archiveDownloader) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		prefix := strings.Trim(r.PathValue("prefix"), "/")
+
+		req := &proto.DownloadArchiveRequest{Prefix: prefix}
+		for name, field := range map[string]*bool{
+			"includeDerived": &req.IncludeDerived,
+			"stripLocation":  &req.StripLocation,
+		} {
+			value := r.URL.Query().Get(name)
+			if value == "" {
+				continue
+			}
+			parsed, err := strconv.ParseBool(value)
+			if err != nil {
+				http.Error(w, "invalid "+name+": "+value, http.StatusBadRequest)
+				return
+			}
+			*field = parsed
+		}
+
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+
+		stream, err := client.DownloadArchive(ctx, req)
+		if err != nil {
+			http.Error(w, err.Error(), grpcStatusToHTTP(err))
+			return
+		}
+
+		// Errors such as an empty prefix arrive before the first chunk and
+		// can still be reported with a proper status code
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), grpcStatusToHTTP(err))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+archiveFilename(prefix)+`"`)
+		for {
+			if _, err := w.Write(resp.GetChunk()); err != nil {
+				return
+			}
+			resp, err = stream.Recv()
+			if err != nil {
+				// Once the body has started the status can no longer be
+				// changed; an error leaves the archive truncated and invalid
+				return
+			}
+		}
+	}
+}
+
+// archiveFilename returns the file name suggested for an archive of prefix.
+func archiveFilename(prefix string) string {
+	name := "photos"
+	if prefix != "" {
+		name = path.Base(prefix)
+	}
+	name = strings.NewReplacer(`"`, "_", `\`, "_").Replace(name)
+	return name + ".zip"
+}
@@ -0,0 +1,104 @@
+package internal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alexhokl/photos/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// stubArchiveServer is a proto.ByteServiceServer whose DownloadArchive is
+// provided by the test.
+type stubArchiveServer struct {
+	proto.UnimplementedByteServiceServer
+	downloadArchiveFunc func(req *proto.DownloadArchiveRequest, stream grpc.ServerStreamingServer[proto.DownloadArchiveResponse]) error
+}
+
+func (s *stubArchiveServer) DownloadArchive(req *proto.DownloadArchiveRequest, stream grpc.ServerStreamingServer[proto.DownloadArchiveResponse]) error {
+	return s.downloadArchiveFunc(req, stream)
+}
+
+func TestNewArchiveHandler(t *testing.T) {
+	tests := []struct {
+		name                string
+		path                string
+		downloadArchiveFunc func(req *proto.DownloadArchiveRequest, stream grpc.ServerStreamingServer[proto.DownloadArchiveResponse]) error
+		expectedStatus      int
+		expectedBody        string
+		expectedDisposition string
+	}{
+		{
+			name: "streams chunks as a zip attachment",
+			path: "/v1/archive/2024/trip?includeDerived=true&stripLocation=1",
+			downloadArchiveFunc: func(req *proto.DownloadArchiveRequest, stream grpc.ServerStreamingServer[proto.DownloadArchiveResponse]) error {
+				if req.GetPrefix() != "2024/trip" || !req.GetIncludeDerived() || !req.GetStripLocation() {
+					return status.Errorf(codes.InvalidArgument, "unexpected request: %v", req)
+				}
+				for _, chunk := range []string{"PK", "\x03\x04", "rest"} {
+					if err := stream.Send(&proto.DownloadArchiveResponse{Chunk: []byte(chunk)}); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+			expectedStatus:      http.StatusOK,
+			expectedBody:        "PK\x03\x04rest",
+			expectedDisposition: `attachment; filename="trip.zip"`,
+		},
+		{
+			name: "whole library is named photos.zip",
+			path: "/v1/archive",
+			downloadArchiveFunc: func(req *proto.DownloadArchiveRequest, stream grpc.ServerStreamingServer[proto.DownloadArchiveResponse]) error {
+				return stream.Send(&proto.DownloadArchiveResponse{Chunk: []byte("PK")})
+			},
+			expectedStatus:      http.StatusOK,
+			expectedBody:        "PK",
+			expectedDisposition: `attachment; filename="photos.zip"`,
+		},
+		{
+			name: "error before the first chunk maps to an HTTP status",
+			path: "/v1/archive/missing",
+			downloadArchiveFunc: func(req *proto.DownloadArchiveRequest, stream grpc.ServerStreamingServer[proto.DownloadArchiveResponse]) error {
+				return status.Errorf(codes.NotFound, "no photos found under prefix: missing")
+			},
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "invalid boolean query parameter",
+			path:           "/v1/archive/2024?includeDerived=maybe",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := NewArchiveHandler(&ByteServerDownloader{Server: &stubArchiveServer{downloadArchiveFunc: tt.downloadArchiveFunc}})
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /v1/archive", handler)
+			mux.HandleFunc("GET /v1/archive/{prefix...}", handler)
+
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d (body: %s)", tt.expectedStatus, rec.Code, rec.Body.String())
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+			if rec.Body.String() != tt.expectedBody {
+				t.Errorf("expected body %q, got %q", tt.expectedBody, rec.Body.String())
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "application/zip" {
+				t.Errorf("expected Content-Type application/zip, got %q", ct)
+			}
+			if cd := rec.Header().Get("Content-Disposition"); cd != tt.expectedDisposition {
+				t.Errorf("expected Content-Disposition %q, got %q", tt.expectedDisposition, cd)
+			}
+		})
+	}
+}
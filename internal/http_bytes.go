@@ -2,7 +2,13 @@ package internal
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/alexhokl/photos/proto"
 	"google.golang.org/grpc"
@@ -10,13 +16,23 @@ import (
 	"google.golang.org/grpc/status"
 )
 
+// rawBytesCacheControl is the Cache-Control of the raw bytes endpoint.
+// Responses are per user, so only private caches may keep them, and an
+// object ID can be overwritten by a later upload, so they are kept only
+// briefly; after that the ETag makes revalidation a cheap 304.
+const rawBytesCacheControl = "private, max-age=300"
+
+// errRangeNotSatisfiable is returned by parseByteRange when a Range header
+// lies entirely outside the object.
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
 // byteDownloader is the minimal interface required to serve raw photo bytes
 // over HTTP. It is satisfied by both proto.ByteServiceClient (a real gRPC
 // client) and *ByteServerDownloader (an in-process adapter around
 // proto.ByteServiceServer), so the handler works whether the RESTful gateway
 // is wired to a remote or a local (same-process) backend.
 type byteDownloader interface {
-	Download(ctx context.Context, in *proto.DownloadRequest, opts ...grpc.CallOption) (*proto.DownloadResponse, error)
+	StreamingDownload(ctx context.Context, in *proto.StreamingDownloadRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[proto.StreamingDownloadResponse], error)
 }
 
 // ByteServerDownloader adapts a proto.ByteServiceServer (the in-process
@@ -26,13 +42,34 @@ type ByteServerDownloader struct {
 	Server proto.ByteServiceServer
 }
 
-func (a *ByteServerDownloader) Download(ctx context.Context, in *proto.DownloadRequest, _ ...grpc.CallOption) (*proto.DownloadResponse, error) {
-	return a.Server.Download(ctx, in)
+// StreamingDownload runs the in-process server's StreamingDownload and
+// returns a client stream that receives its messages.
+func (a *ByteServerDownloader) StreamingDownload(ctx context.Context, in *proto.StreamingDownloadRequest, _ ...grpc.CallOption) (grpc.ServerStreamingClient[proto.StreamingDownloadResponse], error) {
+	return newInProcessStream(ctx, func(stream grpc.ServerStreamingServer[proto.StreamingDownloadResponse]) error {
+		return a.Server.StreamingDownload(in, stream)
+	}), nil
 }
 
-// NewRawBytesHandler returns an HTTP handler that fetches a photo from the
+// byteRange is a satisfiable single range of an object.
+type byteRange struct {
+	start  int64
+	length int64
+}
+
+// contentRange formats r as a Content-Range header value.
+func (r byteRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// NewRawBytesHandler returns an HTTP handler that streams a photo from the
 // gRPC ByteService and writes the raw bytes directly to the response. This
-// is suitable for use in HTML <img> tags.
+// is suitable for use in HTML <img> and <video> tags.
+//
+// The object is streamed rather than buffered. A single-range Range header
+// (optionally guarded by If-Range) is answered with 206 Partial Content so
+// video can be seeked, and If-None-Match (against the object's MD5) or
+// If-Modified-Since is answered with 304 Not Modified. HEAD requests return
+// the headers only.
 //
 // The URL must contain an {object_id...} wildcard path parameter, e.g.:
 //
@@ -45,21 +82,225 @@ func NewRawBytesHandler(client byteDownloader) http.HandlerFunc {
 			return
 		}
 
-		resp, err := client.Download(r.Context(), &proto.DownloadRequest{
-			ObjectId: objectID,
-		})
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+
+		photo, err := getStreamingDownloadMetadata(ctx, client, objectID)
 		if err != nil {
 			http.Error(w, err.Error(), grpcStatusToHTTP(err))
 			return
 		}
 
-		contentType := resp.GetPhoto().GetContentType()
+		size := photo.GetSizeBytes()
+		etag := ""
+		if photo.GetMd5Hash() != "" {
+			etag = `"` + photo.GetMd5Hash() + `"`
+		}
+		lastModified, hasLastModified := parsePhotoTime(photo.GetUpdatedAt())
+
+		header := w.Header()
+		if etag != "" {
+			header.Set("ETag", etag)
+		}
+		if hasLastModified {
+			header.Set("Last-Modified", lastModified.Format(http.TimeFormat))
+		}
+		header.Set("Cache-Control", rawBytesCacheControl)
+		header.Set("Accept-Ranges", "bytes")
+
+		if isNotModified(r, etag, lastModified, hasLastModified) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		contentType := photo.GetContentType()
 		if contentType == "" {
 			contentType = "application/octet-stream"
 		}
-		w.Header().Set("Content-Type", contentType)
-		_, _ = w.Write(resp.GetData())
+		header.Set("Content-Type", contentType)
+
+		statusCode := http.StatusOK
+		req := &proto.StreamingDownloadRequest{ObjectId: objectID}
+		contentLength := size
+		if rangeHeader := r.Header.Get("Range"); rangeHeader != "" && ifRangeMatches(r, etag, lastModified, hasLastModified) {
+			byteRange, err := parseByteRange(rangeHeader, size)
+			if errors.Is(err, errRangeNotSatisfiable) {
+				header.Set("Content-Range", fmt.Sprintf("bytes */%d", size))
+				http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+				return
+			}
+			if byteRange != nil {
+				statusCode = http.StatusPartialContent
+				req.Offset = byteRange.start
+				req.Length = byteRange.length
+				contentLength = byteRange.length
+				header.Set("Content-Range", byteRange.contentRange(size))
+			}
+		}
+
+		if r.Method == http.MethodHead {
+			header.Set("Content-Length", strconv.FormatInt(contentLength, 10))
+			w.WriteHeader(statusCode)
+			return
+		}
+
+		stream, err := client.StreamingDownload(ctx, req)
+		if err != nil {
+			http.Error(w, err.Error(), grpcStatusToHTTP(err))
+			return
+		}
+		// The first message is the metadata of the data streamed, which
+		// must be the object the headers above describe
+		first, err := stream.Recv()
+		if err != nil {
+			http.Error(w, err.Error(), grpcStatusToHTTP(err))
+			return
+		}
+		if streamed := first.GetMetadata(); streamed.GetMd5Hash() != photo.GetMd5Hash() || streamed.GetSizeBytes() != size {
+			http.Error(w, "object changed while it was being read", http.StatusServiceUnavailable)
+			return
+		}
+
+		header.Set("Content-Length", strconv.FormatInt(contentLength, 10))
+		w.WriteHeader(statusCode)
+		for {
+			resp, err := stream.Recv()
+			if err != nil {
+				// io.EOF ends the body; any other error can no longer change
+				// the status, and the short body tells the client it failed
+				return
+			}
+			if _, err := w.Write(resp.GetChunk()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// getStreamingDownloadMetadata fetches the metadata of objectID without its
+// data.
+func getStreamingDownloadMetadata(ctx context.Context, client byteDownloader, objectID string) (*proto.Photo, error) {
+	stream, err := client.StreamingDownload(ctx, &proto.StreamingDownloadRequest{
+		ObjectId:     objectID,
+		MetadataOnly: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp, err := stream.Recv()
+	if err == io.EOF {
+		return nil, status.Errorf(codes.Internal, "no metadata received for %s", objectID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	photo := resp.GetMetadata()
+	if photo == nil {
+		return nil, status.Errorf(codes.Internal, "first message for %s is not metadata", objectID)
+	}
+	return photo, nil
+}
+
+// parsePhotoTime parses an RFC 3339 timestamp of a proto.Photo, truncated to
+// the second precision of HTTP dates.
+func parsePhotoTime(value string) (time.Time, bool) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t.UTC().Truncate(time.Second), true
+}
+
+// isNotModified evaluates If-None-Match and, only in its absence,
+// If-Modified-Since for a GET or HEAD request.
+func isNotModified(r *http.Request, etag string, lastModified time.Time, hasLastModified bool) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagListMatches(inm, etag)
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && hasLastModified {
+		since, err := http.ParseTime(ims)
+		return err == nil && !lastModified.After(since)
+	}
+	return false
+}
+
+// etagListMatches reports whether a comma-separated If-None-Match list
+// matches etag using weak comparison, so W/"x" matches "x".
+func etagListMatches(list, etag string) bool {
+	if etag == "" {
+		return false
+	}
+	for candidate := range strings.SplitSeq(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// ifRangeMatches reports whether a Range header should be honoured given
+// If-Range. If-Range holds either an ETag, compared strongly, or the
+// Last-Modified date, which must match exactly; without it the range always
+// applies.
+func ifRangeMatches(r *http.Request, etag string, lastModified time.Time, hasLastModified bool) bool {
+	ifRange := r.Header.Get("If-Range")
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) || strings.HasPrefix(ifRange, "W/") {
+		return etag != "" && ifRange == etag
+	}
+	date, err := http.ParseTime(ifRange)
+	return err == nil && hasLastModified && date.Equal(lastModified)
+}
+
+// parseByteRange parses a Range header against an object of size bytes. It
+// returns nil for headers that should be ignored and the full object served,
+// namely malformed headers, other units and multiple ranges, and
+// errRangeNotSatisfiable when the range starts beyond the end of the object.
+func parseByteRange(header string, size int64) (*byteRange, error) {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok || strings.Contains(spec, ",") {
+		return nil, nil
+	}
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return nil, nil
+	}
+
+	if first == "" {
+		// Suffix range: the last n bytes
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return nil, nil
+		}
+		if n == 0 || size == 0 {
+			return nil, errRangeNotSatisfiable
+		}
+		n = min(n, size)
+		return &byteRange{start: size - n, length: n}, nil
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return nil, nil
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return nil, nil
+		}
+		end = min(end, size-1)
+	}
+	if start >= size {
+		return nil, errRangeNotSatisfiable
 	}
+	return &byteRange{start: start, length: end - start + 1}, nil
 }
 
 // grpcStatusToHTTP maps a gRPC status error to an appropriate HTTP status code.
@@ -80,6 +321,8 @@ func grpcStatusToHTTP(err error) int {
 		return http.StatusForbidden
 	case codes.InvalidArgument:
 		return http.StatusBadRequest
+	case codes.OutOfRange:
+		return http.StatusRequestedRangeNotSatisfiable
 	default:
 		return http.StatusInternalServerError
 	}
@@ -2,6 +2,7 @@ package internal
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -13,8 +14,11 @@ import (
 )
 
 // mockByteServiceClient implements proto.ByteServiceClient for testing.
+// StreamingDownload runs streamingDownloadFunc as if it were the server.
 type mockByteServiceClient struct {
-	downloadFunc func(ctx context.Context, in *proto.DownloadRequest, opts ...grpc.CallOption) (*proto.DownloadResponse, error)
+	downloadFunc          func(ctx context.Context, in *proto.DownloadRequest, opts ...grpc.CallOption) (*proto.DownloadResponse, error)
+	streamingDownloadFunc func(req *proto.StreamingDownloadRequest, stream grpc.ServerStreamingServer[proto.StreamingDownloadResponse]) error
+	streamingDownloadReqs []*proto.StreamingDownloadRequest
 }
 
 func (m *mockByteServiceClient) Download(ctx context.Context, in *proto.DownloadRequest, opts ...grpc.CallOption) (*proto.DownloadResponse, error) {
@@ -34,75 +38,104 @@ func (m *mockByteServiceClient) BulkStreamingUpload(ctx context.Context, opts ..
 }
 
 func (m *mockByteServiceClient) StreamingDownload(ctx context.Context, in *proto.StreamingDownloadRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[proto.StreamingDownloadResponse], error) {
+	m.streamingDownloadReqs = append(m.streamingDownloadReqs, in)
+	return newInProcessStream(ctx, func(stream grpc.ServerStreamingServer[proto.StreamingDownloadResponse]) error {
+		return m.streamingDownloadFunc(in, stream)
+	}), nil
+}
+
+func (m *mockByteServiceClient) DownloadArchive(ctx context.Context, in *proto.DownloadArchiveRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[proto.DownloadArchiveResponse], error) {
 	panic("not implemented")
 }
 
+func (m *mockByteServiceClient) RenderPhoto(ctx context.Context, in *proto.RenderPhotoRequest, opts ...grpc.CallOption) (*proto.RenderPhotoResponse, error) {
+	panic("not implemented")
+}
+
+// serveTestObject returns a streamingDownloadFunc serving data with the
+// given metadata, honouring metadata_only, offset and length like
+// BytesServer.StreamingDownload. Data is sent in 2-byte chunks.
+func serveTestObject(photo *proto.Photo, data []byte) func(req *proto.StreamingDownloadRequest, stream grpc.ServerStreamingServer[proto.StreamingDownloadResponse]) error {
+	return func(req *proto.StreamingDownloadRequest, stream grpc.ServerStreamingServer[proto.StreamingDownloadResponse]) error {
+		if err := stream.Send(&proto.StreamingDownloadResponse{
+			Data: &proto.StreamingDownloadResponse_Metadata{Metadata: photo},
+		}); err != nil {
+			return err
+		}
+		if req.GetMetadataOnly() {
+			return nil
+		}
+		part := data[req.GetOffset():]
+		if req.GetLength() > 0 {
+			part = part[:req.GetLength()]
+		}
+		for i := 0; i < len(part); i += 2 {
+			if err := stream.Send(&proto.StreamingDownloadResponse{
+				Data: &proto.StreamingDownloadResponse_Chunk{Chunk: part[i:min(i+2, len(part))]},
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
 func TestNewRawBytesHandler(t *testing.T) {
 	imageBytes := []byte{0xFF, 0xD8, 0xFF, 0xE0} // JPEG magic bytes
 
 	tests := []struct {
-		name             string
-		objectID         string
-		downloadFunc     func(ctx context.Context, in *proto.DownloadRequest, opts ...grpc.CallOption) (*proto.DownloadResponse, error)
-		expectedStatus   int
-		expectedBody     []byte
-		expectedCT       string
+		name                  string
+		objectID              string
+		streamingDownloadFunc func(req *proto.StreamingDownloadRequest, stream grpc.ServerStreamingServer[proto.StreamingDownloadResponse]) error
+		expectedStatus        int
+		expectedBody          []byte
+		expectedCT            string
 	}{
 		{
-			name:     "valid object ID returns 200 with correct content-type and body",
-			objectID: "photos/2024/img.jpg",
-			downloadFunc: func(ctx context.Context, in *proto.DownloadRequest, opts ...grpc.CallOption) (*proto.DownloadResponse, error) {
-				return &proto.DownloadResponse{
-					Photo: &proto.Photo{ContentType: "image/jpeg"},
-					Data:  imageBytes,
-				}, nil
-			},
-			expectedStatus: http.StatusOK,
-			expectedBody:   imageBytes,
-			expectedCT:     "image/jpeg",
+			name:                  "valid object ID returns 200 with correct content-type and body",
+			objectID:              "photos/2024/img.jpg",
+			streamingDownloadFunc: serveTestObject(&proto.Photo{ContentType: "image/jpeg", SizeBytes: 4}, imageBytes),
+			expectedStatus:        http.StatusOK,
+			expectedBody:          imageBytes,
+			expectedCT:            "image/jpeg",
 		},
 		{
-			name:     "missing content-type falls back to application/octet-stream",
-			objectID: "photos/2024/img.bin",
-			downloadFunc: func(ctx context.Context, in *proto.DownloadRequest, opts ...grpc.CallOption) (*proto.DownloadResponse, error) {
-				return &proto.DownloadResponse{
-					Photo: &proto.Photo{},
-					Data:  imageBytes,
-				}, nil
-			},
-			expectedStatus: http.StatusOK,
-			expectedBody:   imageBytes,
-			expectedCT:     "application/octet-stream",
+			name:                  "missing content-type falls back to application/octet-stream",
+			objectID:              "photos/2024/img.bin",
+			streamingDownloadFunc: serveTestObject(&proto.Photo{SizeBytes: 4}, imageBytes),
+			expectedStatus:        http.StatusOK,
+			expectedBody:          imageBytes,
+			expectedCT:            "application/octet-stream",
 		},
 		{
 			name:     "gRPC NotFound returns 404",
 			objectID: "photos/2024/missing.jpg",
-			downloadFunc: func(ctx context.Context, in *proto.DownloadRequest, opts ...grpc.CallOption) (*proto.DownloadResponse, error) {
-				return nil, status.Errorf(codes.NotFound, "photo not found")
+			streamingDownloadFunc: func(req *proto.StreamingDownloadRequest, stream grpc.ServerStreamingServer[proto.StreamingDownloadResponse]) error {
+				return status.Errorf(codes.NotFound, "photo not found")
 			},
 			expectedStatus: http.StatusNotFound,
 		},
 		{
 			name:     "gRPC Unauthenticated returns 401",
 			objectID: "photos/2024/img.jpg",
-			downloadFunc: func(ctx context.Context, in *proto.DownloadRequest, opts ...grpc.CallOption) (*proto.DownloadResponse, error) {
-				return nil, status.Errorf(codes.Unauthenticated, "authentication required")
+			streamingDownloadFunc: func(req *proto.StreamingDownloadRequest, stream grpc.ServerStreamingServer[proto.StreamingDownloadResponse]) error {
+				return status.Errorf(codes.Unauthenticated, "authentication required")
 			},
 			expectedStatus: http.StatusUnauthorized,
 		},
 		{
 			name:     "gRPC PermissionDenied returns 403",
 			objectID: "photos/2024/img.jpg",
-			downloadFunc: func(ctx context.Context, in *proto.DownloadRequest, opts ...grpc.CallOption) (*proto.DownloadResponse, error) {
-				return nil, status.Errorf(codes.PermissionDenied, "access denied")
+			streamingDownloadFunc: func(req *proto.StreamingDownloadRequest, stream grpc.ServerStreamingServer[proto.StreamingDownloadResponse]) error {
+				return status.Errorf(codes.PermissionDenied, "access denied")
 			},
 			expectedStatus: http.StatusForbidden,
 		},
 		{
 			name:     "gRPC Internal error returns 500",
 			objectID: "photos/2024/img.jpg",
-			downloadFunc: func(ctx context.Context, in *proto.DownloadRequest, opts ...grpc.CallOption) (*proto.DownloadResponse, error) {
-				return nil, status.Errorf(codes.Internal, "internal server error")
+			streamingDownloadFunc: func(req *proto.StreamingDownloadRequest, stream grpc.ServerStreamingServer[proto.StreamingDownloadResponse]) error {
+				return status.Errorf(codes.Internal, "internal server error")
 			},
 			expectedStatus: http.StatusInternalServerError,
 		},
@@ -110,7 +143,7 @@ func TestNewRawBytesHandler(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			client := &mockByteServiceClient{downloadFunc: tt.downloadFunc}
+			client := &mockByteServiceClient{streamingDownloadFunc: tt.streamingDownloadFunc}
 			handler := NewRawBytesHandler(client)
 
 			// Register on a test mux to exercise PathValue
@@ -148,6 +181,216 @@ func TestNewRawBytesHandler(t *testing.T) {
 	}
 }
 
+func TestNewRawBytesHandler_RangeAndConditional(t *testing.T) {
+	data := []byte("0123456789")
+	photo := &proto.Photo{
+		ContentType: "video/mp4",
+		SizeBytes:   int64(len(data)),
+		Md5Hash:     "abc==",
+		UpdatedAt:   "2024-05-01T10:00:00Z",
+	}
+	const etag = `"abc=="`
+	const lastModified = "Wed, 01 May 2024 10:00:00 GMT"
+
+	tests := []struct {
+		name                 string
+		method               string
+		headers              map[string]string
+		expectedStatus       int
+		expectedBody         string
+		expectedContentRange string
+		expectedLength       string
+	}{
+		{
+			name:           "full body carries caching headers",
+			expectedStatus: http.StatusOK,
+			expectedBody:   "0123456789",
+			expectedLength: "10",
+		},
+		{
+			name:                 "closed range",
+			headers:              map[string]string{"Range": "bytes=2-5"},
+			expectedStatus:       http.StatusPartialContent,
+			expectedBody:         "2345",
+			expectedContentRange: "bytes 2-5/10",
+			expectedLength:       "4",
+		},
+		{
+			name:                 "open-ended range",
+			headers:              map[string]string{"Range": "bytes=7-"},
+			expectedStatus:       http.StatusPartialContent,
+			expectedBody:         "789",
+			expectedContentRange: "bytes 7-9/10",
+			expectedLength:       "3",
+		},
+		{
+			name:                 "suffix range",
+			headers:              map[string]string{"Range": "bytes=-2"},
+			expectedStatus:       http.StatusPartialContent,
+			expectedBody:         "89",
+			expectedContentRange: "bytes 8-9/10",
+			expectedLength:       "2",
+		},
+		{
+			name:                 "range beyond the end",
+			headers:              map[string]string{"Range": "bytes=10-"},
+			expectedStatus:       http.StatusRequestedRangeNotSatisfiable,
+			expectedContentRange: "bytes */10",
+		},
+		{
+			name:           "multiple ranges serve the full body",
+			headers:        map[string]string{"Range": "bytes=0-1,4-5"},
+			expectedStatus: http.StatusOK,
+			expectedBody:   "0123456789",
+		},
+		{
+			name:                 "If-Range with matching ETag honours the range",
+			headers:              map[string]string{"Range": "bytes=0-0", "If-Range": etag},
+			expectedStatus:       http.StatusPartialContent,
+			expectedBody:         "0",
+			expectedContentRange: "bytes 0-0/10",
+		},
+		{
+			name:                 "If-Range with matching date honours the range",
+			headers:              map[string]string{"Range": "bytes=0-0", "If-Range": lastModified},
+			expectedStatus:       http.StatusPartialContent,
+			expectedBody:         "0",
+			expectedContentRange: "bytes 0-0/10",
+		},
+		{
+			name:           "If-Range with stale ETag serves the full body",
+			headers:        map[string]string{"Range": "bytes=0-0", "If-Range": `"old"`},
+			expectedStatus: http.StatusOK,
+			expectedBody:   "0123456789",
+		},
+		{
+			name:           "If-None-Match with current ETag",
+			headers:        map[string]string{"If-None-Match": `"other", ` + etag},
+			expectedStatus: http.StatusNotModified,
+		},
+		{
+			name:           "If-None-Match with weak ETag",
+			headers:        map[string]string{"If-None-Match": "W/" + etag},
+			expectedStatus: http.StatusNotModified,
+		},
+		{
+			name:           "If-None-Match with stale ETag",
+			headers:        map[string]string{"If-None-Match": `"old"`},
+			expectedStatus: http.StatusOK,
+			expectedBody:   "0123456789",
+		},
+		{
+			name:           "If-Modified-Since not modified",
+			headers:        map[string]string{"If-Modified-Since": lastModified},
+			expectedStatus: http.StatusNotModified,
+		},
+		{
+			name:                 "HEAD returns headers only",
+			method:               http.MethodHead,
+			headers:              map[string]string{"Range": "bytes=0-3"},
+			expectedStatus:       http.StatusPartialContent,
+			expectedContentRange: "bytes 0-3/10",
+			expectedLength:       "4",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &mockByteServiceClient{streamingDownloadFunc: serveTestObject(photo, data)}
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /v1/photos/bytes/{object_id...}", NewRawBytesHandler(client))
+
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, "/v1/photos/bytes/2024/clip.mp4", nil)
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d (body: %q)", tt.expectedStatus, w.Code, w.Body.String())
+			}
+			if tt.expectedStatus != http.StatusRequestedRangeNotSatisfiable && w.Body.String() != tt.expectedBody {
+				t.Errorf("expected body %q, got %q", tt.expectedBody, w.Body.String())
+			}
+			if cr := w.Header().Get("Content-Range"); cr != tt.expectedContentRange {
+				t.Errorf("expected Content-Range %q, got %q", tt.expectedContentRange, cr)
+			}
+			if tt.expectedLength != "" {
+				if cl := w.Header().Get("Content-Length"); cl != tt.expectedLength {
+					t.Errorf("expected Content-Length %q, got %q", tt.expectedLength, cl)
+				}
+			}
+			if got := w.Header().Get("ETag"); got != etag {
+				t.Errorf("expected ETag %q, got %q", etag, got)
+			}
+			if got := w.Header().Get("Last-Modified"); got != lastModified {
+				t.Errorf("expected Last-Modified %q, got %q", lastModified, got)
+			}
+			if got := w.Header().Get("Accept-Ranges"); got != "bytes" {
+				t.Errorf("expected Accept-Ranges bytes, got %q", got)
+			}
+			if got := w.Header().Get("Cache-Control"); got != rawBytesCacheControl {
+				t.Errorf("expected Cache-Control %q, got %q", rawBytesCacheControl, got)
+			}
+		})
+	}
+}
+
+func TestNewRawBytesHandler_StreamsInsteadOfBuffering(t *testing.T) {
+	client := &mockByteServiceClient{
+		streamingDownloadFunc: serveTestObject(&proto.Photo{ContentType: "image/jpeg", SizeBytes: 4}, []byte("abcd")),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/photos/bytes/{object_id...}", NewRawBytesHandler(client))
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/v1/photos/bytes/img.jpg", nil))
+
+	if len(client.streamingDownloadReqs) != 2 {
+		t.Fatalf("expected a metadata request and a data request, got %d requests", len(client.streamingDownloadReqs))
+	}
+	if !client.streamingDownloadReqs[0].GetMetadataOnly() {
+		t.Error("expected the first request to fetch metadata only")
+	}
+	if client.streamingDownloadReqs[1].GetMetadataOnly() {
+		t.Error("expected the second request to fetch data")
+	}
+}
+
+func TestParseByteRange(t *testing.T) {
+	tests := []struct {
+		header   string
+		size     int64
+		expected *byteRange
+		err      error
+	}{
+		{"bytes=0-0", 10, &byteRange{0, 1}, nil},
+		{"bytes=5-100", 10, &byteRange{5, 5}, nil},
+		{"bytes=-20", 10, &byteRange{0, 10}, nil},
+		{"bytes=-0", 10, nil, errRangeNotSatisfiable},
+		{"bytes=0-", 0, nil, errRangeNotSatisfiable},
+		{"bytes=5-2", 10, nil, nil},
+		{"bytes=abc", 10, nil, nil},
+		{"items=0-1", 10, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			got, err := parseByteRange(tt.header, tt.size)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("expected error %v, got %v", tt.err, err)
+			}
+			if (got == nil) != (tt.expected == nil) || (got != nil && *got != *tt.expected) {
+				t.Errorf("expected %+v, got %+v", tt.expected, got)
+			}
+		})
+	}
+}
+
 func TestGrpcStatusToHTTP(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -173,3 +416,26 @@ func TestGrpcStatusToHTTP(t *testing.T) {
 		})
 	}
 }
+
+func TestNewRawBytesHandler_ObjectReplacedWhileReading(t *testing.T) {
+	photo := &proto.Photo{ContentType: "image/jpeg", SizeBytes: 4, Md5Hash: "old=="}
+	replaced := &proto.Photo{ContentType: "image/jpeg", SizeBytes: 6, Md5Hash: "new=="}
+	serveOld, serveNew := serveTestObject(photo, []byte("1234")), serveTestObject(replaced, []byte("abcdef"))
+	client := &mockByteServiceClient{
+		streamingDownloadFunc: func(req *proto.StreamingDownloadRequest, stream grpc.ServerStreamingServer[proto.StreamingDownloadResponse]) error {
+			if req.GetMetadataOnly() {
+				return serveOld(req, stream)
+			}
+			return serveNew(req, stream)
+		},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/photos/raw/{object_id...}", NewRawBytesHandler(client))
+
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/photos/raw/2024/img.jpg", nil))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d, got %d (body: %s)", http.StatusServiceUnavailable, rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,119 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/alexhokl/photos/proto"
+	"google.golang.org/grpc"
+)
+
+// renderFits maps the fit query parameter of the render route to RenderFit.
+var renderFits = map[string]proto.RenderFit{
+	"contain": proto.RenderFit_RENDER_FIT_CONTAIN,
+	"cover":   proto.RenderFit_RENDER_FIT_COVER,
+}
+
+// renderFormats maps the fmt query parameter of the render route to
+// RenderFormat.
+var renderFormats = map[string]proto.RenderFormat{
+	"jpeg": proto.RenderFormat_RENDER_FORMAT_JPEG,
+	"jpg":  proto.RenderFormat_RENDER_FORMAT_JPEG,
+	"webp": proto.RenderFormat_RENDER_FORMAT_WEBP,
+}
+
+// photoRenderer is the minimal interface required to serve renditions over
+// HTTP. Like byteDownloader, it is satisfied by both proto.ByteServiceClient
+// and *ByteServerDownloader.
+type photoRenderer interface {
+	RenderPhoto(ctx context.Context, in *proto.RenderPhotoRequest, opts ...grpc.CallOption) (*proto.RenderPhotoResponse, error)
+}
+
+func (a *ByteServerDownloader) RenderPhoto(ctx context.Context, in *proto.RenderPhotoRequest, _ ...grpc.CallOption) (*proto.RenderPhotoResponse, error) {
+	return a.Server.RenderPhoto(ctx, in)
+}
+
+// NewRenderHandler returns an HTTP handler that writes a resized rendition
+// of a photo from the gRPC ByteService as raw image bytes, for grid views
+// and other places that do not need the original. The query parameters are
+// w and h (pixels), fit (contain or cover) and fmt (jpeg or webp).
+//
+// The URL must contain an {object_id...} wildcard path parameter, e.g.:
+//
+//	GET /v1/photos/render/{object_id...}?w=400&h=400&fit=cover&fmt=webp
+func NewRenderHandler(client photoRenderer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		objectID := r.PathValue("object_id")
+		if objectID == "" {
+			http.Error(w, "missing object_id", http.StatusBadRequest)
+			return
+		}
+
+		req, err := parseRenderQuery(objectID, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		// The ETag is known before rendering, so a cached rendition is not
+		// rendered again only to be discarded
+		req.IfNoneMatch = r.Header.Get("If-None-Match")
+		resp, err := client.RenderPhoto(r.Context(), req)
+		if err != nil {
+			http.Error(w, err.Error(), grpcStatusToHTTP(err))
+			return
+		}
+
+		header := w.Header()
+		header.Set("ETag", `"`+resp.GetEtag()+`"`)
+		header.Set("Cache-Control", rawBytesCacheControl)
+		if resp.GetNotModified() {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		header.Set("Content-Type", resp.GetContentType())
+		header.Set("Content-Length", strconv.Itoa(len(resp.GetData())))
+		if r.Method == http.MethodHead {
+			return
+		}
+		_, _ = w.Write(resp.GetData())
+	}
+}
+
+// parseRenderQuery builds a RenderPhotoRequest from the query parameters of
+// the render route.
+func parseRenderQuery(objectID string, r *http.Request) (*proto.RenderPhotoRequest, error) {
+	query := r.URL.Query()
+	req := &proto.RenderPhotoRequest{ObjectId: objectID}
+
+	for name, field := range map[string]*int32{"w": &req.Width, "h": &req.Height} {
+		value := query.Get(name)
+		if value == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %s", name, value)
+		}
+		*field = int32(n)
+	}
+	if value := query.Get("fit"); value != "" {
+		fit, ok := renderFits[strings.ToLower(value)]
+		if !ok {
+			return nil, fmt.Errorf("invalid fit: %s", value)
+		}
+		req.Fit = fit
+	}
+	if value := query.Get("fmt"); value != "" {
+		format, ok := renderFormats[strings.ToLower(value)]
+		if !ok {
+			return nil, fmt.Errorf("invalid fmt: %s", value)
+		}
+		req.Format = format
+	}
+	return req, nil
+}
@@ -0,0 +1,120 @@
+package internal
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alexhokl/photos/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// stubRenderer implements photoRenderer for testing.
+type stubRenderer struct {
+	renderPhotoFunc func(ctx context.Context, in *proto.RenderPhotoRequest) (*proto.RenderPhotoResponse, error)
+}
+
+func (s *stubRenderer) RenderPhoto(ctx context.Context, in *proto.RenderPhotoRequest, _ ...grpc.CallOption) (*proto.RenderPhotoResponse, error) {
+	return s.renderPhotoFunc(ctx, in)
+}
+
+func TestNewRenderHandler(t *testing.T) {
+	rendition := &proto.RenderPhotoResponse{Data: []byte("webp"), ContentType: "image/webp", Width: 400, Height: 400, Etag: "abc"}
+
+	tests := []struct {
+		name           string
+		path           string
+		headers        map[string]string
+		renderErr      error
+		expectedReq    *proto.RenderPhotoRequest
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name: "renders with query parameters",
+			path: "/v1/photos/render/2024/img.jpg?w=400&h=400&fit=cover&fmt=webp",
+			expectedReq: &proto.RenderPhotoRequest{
+				ObjectId: "2024/img.jpg", Width: 400, Height: 400,
+				Fit: proto.RenderFit_RENDER_FIT_COVER, Format: proto.RenderFormat_RENDER_FORMAT_WEBP,
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   "webp",
+		},
+		{
+			name:           "matching ETag is not modified",
+			path:           "/v1/photos/render/2024/img.jpg?w=400",
+			headers:        map[string]string{"If-None-Match": `"abc"`},
+			expectedStatus: http.StatusNotModified,
+		},
+		{
+			name:           "invalid width",
+			path:           "/v1/photos/render/2024/img.jpg?w=wide",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "invalid fit",
+			path:           "/v1/photos/render/2024/img.jpg?w=400&fit=stretch",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "invalid format",
+			path:           "/v1/photos/render/2024/img.jpg?w=400&fmt=gif",
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "gRPC NotFound returns 404",
+			path:           "/v1/photos/render/2024/missing.jpg?w=400",
+			renderErr:      status.Errorf(codes.NotFound, "object not found"),
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			renderer := &stubRenderer{
+				renderPhotoFunc: func(_ context.Context, in *proto.RenderPhotoRequest) (*proto.RenderPhotoResponse, error) {
+					if tt.expectedReq != nil && (in.GetObjectId() != tt.expectedReq.GetObjectId() ||
+						in.GetWidth() != tt.expectedReq.GetWidth() || in.GetHeight() != tt.expectedReq.GetHeight() ||
+						in.GetFit() != tt.expectedReq.GetFit() || in.GetFormat() != tt.expectedReq.GetFormat()) {
+						t.Errorf("expected request %v, got %v", tt.expectedReq, in)
+					}
+					if tt.renderErr != nil {
+						return nil, tt.renderErr
+					}
+					if etagListMatches(in.GetIfNoneMatch(), `"`+rendition.GetEtag()+`"`) {
+						return &proto.RenderPhotoResponse{Etag: rendition.GetEtag(), NotModified: true}, nil
+					}
+					return rendition, nil
+				},
+			}
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /v1/photos/render/{object_id...}", NewRenderHandler(renderer))
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedStatus {
+				t.Fatalf("expected status %d, got %d (body: %s)", tt.expectedStatus, rec.Code, rec.Body.String())
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+			if rec.Body.String() != tt.expectedBody {
+				t.Errorf("expected body %q, got %q", tt.expectedBody, rec.Body.String())
+			}
+			if ct := rec.Header().Get("Content-Type"); ct != "image/webp" {
+				t.Errorf("expected Content-Type image/webp, got %q", ct)
+			}
+			if etag := rec.Header().Get("ETag"); etag != `"abc"` {
+				t.Errorf("expected ETag \"abc\", got %q", etag)
+			}
+		})
+	}
+}
@@ -0,0 +1,657 @@
+package internal
+
+import (
+	"cmp"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"cloud.google.com/go/storage"
+	"github.com/alexhokl/photos/database"
+	"github.com/alexhokl/photos/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+// photoDerivedColumns are the PhotoObject columns referencing derived
+// assets, in the order photoDerivedReferences returns them.
+var photoDerivedColumns = []string{
+	"thumbnail_object_id",
+	"webp_object_id",
+	"avif_object_id",
+	"proxy_object_id",
+	"hls_object_id",
+	"animated_preview_object_id",
+	"motion_video_object_id",
+}
+
+// errIntegrityProblemGone is returned by repairIntegrityProblem if the
+// problem has gone since the check found it.
+var errIntegrityProblemGone = errors.New("problem no longer present")
+
+// integrityReport counts the problems found by an integrity check of a
+// user's library.
+type integrityReport struct {
+	photos             int
+	missingObjects     int
+	md5Mismatches      int
+	danglingDerived    int
+	orphanedDerived    int
+	emptyDirectories   int
+	missingDirectories int
+	repaired           int
+}
+
+// String summarises the report for the run history.
+func (r integrityReport) String() string {
+	summary := fmt.Sprintf("checked %d photos: %d missing from storage, %d with a different MD5, %d dangling derived assets, %d orphaned derived assets, %d empty directories, %d missing directories",
+		r.photos, r.missingObjects, r.md5Mismatches, r.danglingDerived, r.orphanedDerived, r.emptyDirectories, r.missingDirectories)
+	if r.repaired > 0 {
+		summary += fmt.Sprintf("; repaired %d", r.repaired)
+	}
+	return summary
+}
+
+// integrityProblem is a problem found by an integrity check.
+type integrityProblem struct {
+	kind proto.IntegrityProblem_Kind
+	// objectID is the photo or derived asset with the problem, or the path
+	// of the directory
+	objectID string
+	// photoID is the ID of the photo whose object is missing
+	photoID uint
+	// derivedID is the derived asset a dangling reference refers to
+	derivedID string
+	detail    string
+	// repairable is set if repairIntegrityProblem may repair the problem
+	repairable bool
+}
+
+// integrityCheck collects the problems found by an integrity check.
+type integrityCheck struct {
+	ctx      context.Context
+	report   integrityReport
+	problems []integrityProblem
+}
+
+// found counts and logs a problem.
+func (c *integrityCheck) found(problem integrityProblem) {
+	switch problem.kind {
+	case proto.IntegrityProblem_KIND_MISSING_OBJECT:
+		c.report.missingObjects++
+	case proto.IntegrityProblem_KIND_MD5_MISMATCH:
+		c.report.md5Mismatches++
+	case proto.IntegrityProblem_KIND_DANGLING_DERIVED:
+		c.report.danglingDerived++
+	case proto.IntegrityProblem_KIND_ORPHANED_DERIVED:
+		c.report.orphanedDerived++
+	case proto.IntegrityProblem_KIND_EMPTY_DIRECTORY:
+		c.report.emptyDirectories++
+	case proto.IntegrityProblem_KIND_MISSING_DIRECTORY:
+		c.report.missingDirectories++
+	}
+	slog.WarnContext(c.ctx, "integrity check: problem found",
+		slog.String("kind", problem.kind.String()),
+		slog.String("object_id", problem.objectID),
+		slog.String("detail", problem.detail),
+	)
+	c.problems = append(c.problems, problem)
+}
+
+// sortedProblems returns the problems found in order of kind and then
+// object.
+func (c *integrityCheck) sortedProblems() []integrityProblem {
+	slices.SortStableFunc(c.problems, func(a, b integrityProblem) int {
+		return cmp.Or(
+			cmp.Compare(a.kind, b.kind),
+			cmp.Compare(a.objectID, b.objectID),
+			cmp.Compare(a.derivedID, b.derivedID),
+		)
+	})
+	return c.problems
+}
+
+// photoDerivedReferences returns the object IDs of the derived assets
+// photoObject references.
+func photoDerivedReferences(photoObject *database.PhotoObject) []string {
+	var objectIDs []string
+	for _, objectID := range []*string{
+		photoObject.ThumbnailObjectID,
+		photoObject.WebpObjectID,
+		photoObject.AvifObjectID,
+		photoObject.ProxyObjectID,
+		photoObject.HLSObjectID,
+		photoObject.AnimatedPreviewObjectID,
+		photoObject.MotionVideoObjectID,
+	} {
+		if objectID != nil && *objectID != "" {
+			objectIDs = append(objectIDs, *objectID)
+		}
+	}
+	return objectIDs
+}
+
+// checkPhotoIntegrity checks photos against the objects in storage: that
+// each photo's object exists with the MD5 hash recorded, and that the
+// derived assets it references exist.
+func (c *integrityCheck) checkPhotoIntegrity(photos []database.PhotoObject, objects map[string]*storage.ObjectAttrs) {
+	c.report.photos += len(photos)
+	for i := range photos {
+		photo := &photos[i]
+		attrs, ok := objects[photo.ObjectID]
+		if !ok {
+			c.found(integrityProblem{
+				kind:       proto.IntegrityProblem_KIND_MISSING_OBJECT,
+				objectID:   photo.ObjectID,
+				photoID:    photo.ID,
+				detail:     "photo missing from storage",
+				repairable: true,
+			})
+		} else if len(attrs.MD5) > 0 && base64.StdEncoding.EncodeToString(attrs.MD5) != photo.MD5Hash {
+			// Composite objects have no MD5 hash to compare
+			c.found(integrityProblem{
+				kind:     proto.IntegrityProblem_KIND_MD5_MISMATCH,
+				objectID: photo.ObjectID,
+				detail: fmt.Sprintf("MD5 hash %s differs from %s in storage",
+					photo.MD5Hash, base64.StdEncoding.EncodeToString(attrs.MD5)),
+			})
+		}
+
+		for _, derivedID := range photoDerivedReferences(photo) {
+			if _, ok := objects[derivedID]; !ok {
+				c.found(integrityProblem{
+					kind:       proto.IntegrityProblem_KIND_DANGLING_DERIVED,
+					objectID:   photo.ObjectID,
+					derivedID:  derivedID,
+					detail:     fmt.Sprintf("derived asset %s missing from storage", derivedID),
+					repairable: true,
+				})
+			}
+		}
+	}
+}
+
+// checkDerivedIntegrity checks the thumbnails and derived asset records of
+// the user, and the derived assets marked in their GCS metadata, against
+// the photos of the user and the objects in storage. A derived asset whose
+// photo is not in the database is only repairable once its original is gone
+// from storage too, as a sync may yet add the photo; marked derived assets
+// with no record are only checked then, as their owner is unknown.
+func (c *integrityCheck) checkDerivedIntegrity(
+	photos []database.PhotoObject,
+	renditions []database.PhotoRendition,
+	derived []database.DerivedObject,
+	allDerived derivedObjectSet,
+	allPhotoIDs map[string]struct{},
+	objects map[string]*storage.ObjectAttrs,
+) {
+	userPhotos := make(map[string]struct{}, len(photos))
+	reported := make(map[string]struct{})
+	for i := range photos {
+		userPhotos[photos[i].ObjectID] = struct{}{}
+		for _, derivedID := range photoDerivedReferences(&photos[i]) {
+			reported[derivedID] = struct{}{}
+		}
+	}
+
+	for _, rendition := range renditions {
+		if _, ok := userPhotos[rendition.PhotoObjectID]; !ok {
+			// Reported as an orphan by its derived asset record, if any
+			continue
+		}
+		if _, ok := objects[rendition.ObjectID]; ok {
+			continue
+		}
+		reported[rendition.ObjectID] = struct{}{}
+		c.found(integrityProblem{
+			kind:       proto.IntegrityProblem_KIND_DANGLING_DERIVED,
+			objectID:   rendition.PhotoObjectID,
+			derivedID:  rendition.ObjectID,
+			detail:     fmt.Sprintf("thumbnail %s missing from storage", rendition.ObjectID),
+			repairable: true,
+		})
+	}
+
+	for _, record := range derived {
+		if _, ok := userPhotos[record.SourceObjectID]; !ok {
+			_, sourceStored := objects[record.SourceObjectID]
+			detail := fmt.Sprintf("derived from %s, which is not in the database", record.SourceObjectID)
+			if !sourceStored {
+				detail = fmt.Sprintf("derived from %s, which is not in storage", record.SourceObjectID)
+			}
+			c.found(integrityProblem{
+				kind:       proto.IntegrityProblem_KIND_ORPHANED_DERIVED,
+				objectID:   record.ObjectID,
+				detail:     detail,
+				repairable: !sourceStored,
+			})
+			continue
+		}
+		if _, ok := reported[record.ObjectID]; ok {
+			continue
+		}
+		if _, ok := objects[record.ObjectID]; ok {
+			continue
+		}
+		c.found(integrityProblem{
+			kind:       proto.IntegrityProblem_KIND_DANGLING_DERIVED,
+			objectID:   record.SourceObjectID,
+			derivedID:  record.ObjectID,
+			detail:     fmt.Sprintf("derived asset record %s missing from storage", record.ObjectID),
+			repairable: true,
+		})
+	}
+
+	for objectID, attrs := range objects {
+		sourceObjectID, _, marked := markedDerivedObject(attrs)
+		if !marked {
+			continue
+		}
+		if allDerived.contains(objectID) {
+			continue
+		}
+		_, sourceRecorded := allPhotoIDs[sourceObjectID]
+		_, sourceStored := objects[sourceObjectID]
+		if sourceRecorded || sourceStored {
+			continue
+		}
+		c.found(integrityProblem{
+			kind:       proto.IntegrityProblem_KIND_ORPHANED_DERIVED,
+			objectID:   objectID,
+			detail:     fmt.Sprintf("derived from %s, which is not in storage", sourceObjectID),
+			repairable: true,
+		})
+	}
+}
+
+// checkDirectoryIntegrity checks the directory rows against the photos:
+// each row should have photos under it, and the directory of each photo of
+// the user should have a row. Directory rows are shared by all users, so a
+// row is empty only if no photo of any user, nor any other object in
+// storage such as an index.md, is under it.
+func (c *integrityCheck) checkDirectoryIntegrity(
+	photos []database.PhotoObject,
+	directories []database.PhotoDirectory,
+	allPhotoIDs map[string]struct{},
+	objects map[string]*storage.ObjectAttrs,
+) {
+	occupied := make(map[string]struct{})
+	occupy := func(objectID string) {
+		for dir := ExtractDirectoryFromPath(objectID); dir != ""; dir = ExtractDirectoryFromPath(dir) {
+			if _, ok := occupied[dir]; ok {
+				break
+			}
+			occupied[dir] = struct{}{}
+		}
+	}
+	for objectID := range allPhotoIDs {
+		occupy(objectID)
+	}
+	for objectID := range objects {
+		occupy(objectID)
+	}
+
+	rows := make(map[string]struct{}, len(directories))
+	for _, directory := range directories {
+		rows[directory.Path] = struct{}{}
+		if _, ok := occupied[directory.Path]; ok {
+			continue
+		}
+		c.found(integrityProblem{
+			kind:       proto.IntegrityProblem_KIND_EMPTY_DIRECTORY,
+			objectID:   directory.Path,
+			detail:     "directory has no photos",
+			repairable: true,
+		})
+	}
+
+	for _, photo := range photos {
+		dir := ExtractDirectoryFromPath(photo.ObjectID)
+		if dir == "" {
+			continue
+		}
+		if _, ok := rows[dir]; ok {
+			continue
+		}
+		rows[dir] = struct{}{}
+		c.found(integrityProblem{
+			kind:       proto.IntegrityProblem_KIND_MISSING_DIRECTORY,
+			objectID:   dir,
+			detail:     "directory of photos has no row",
+			repairable: true,
+		})
+	}
+}
+
+// checkIntegrity checks the library of the user against the objects in
+// storage: its photos (see checkPhotoIntegrity), its thumbnails and derived
+// assets (see checkDerivedIntegrity) and the directory rows (see
+// checkDirectoryIntegrity). The problems found are returned in order of
+// kind and then object. Nothing is changed.
+func (s *LibraryServer) checkIntegrity(ctx context.Context, userID uint) (integrityReport, []integrityProblem, error) {
+	objects, err := getGCSObjectsMap(ctx, s.GCSClient, s.BucketName)
+	if err != nil {
+		return integrityReport{}, nil, fmt.Errorf("failed to list GCS objects: %w", err)
+	}
+	return s.checkIntegrityAgainst(ctx, userID, objects)
+}
+
+// checkIntegrityAgainst implements checkIntegrity given the objects in
+// storage.
+func (s *LibraryServer) checkIntegrityAgainst(ctx context.Context, userID uint, objects map[string]*storage.ObjectAttrs) (integrityReport, []integrityProblem, error) {
+	var photos []database.PhotoObject
+	_, dbListSpan := startSpan(ctx, "db.list_photo_objects")
+	if err := s.DB.Where("user_id = ?", userID).Find(&photos).Error; err != nil {
+		recordSpanError(dbListSpan, err)
+		return integrityReport{}, nil, fmt.Errorf("failed to list database objects: %w", err)
+	}
+	endSpanOk(dbListSpan)
+
+	var allPhotoObjectIDs []string
+	_, idsSpan := startSpan(ctx, "db.list_photo_object_ids")
+	if err := s.DB.Model(&database.PhotoObject{}).Pluck("object_id", &allPhotoObjectIDs).Error; err != nil {
+		recordSpanError(idsSpan, err)
+		return integrityReport{}, nil, fmt.Errorf("failed to list database objects: %w", err)
+	}
+	endSpanOk(idsSpan)
+	allPhotoIDs := make(map[string]struct{}, len(allPhotoObjectIDs))
+	for _, objectID := range allPhotoObjectIDs {
+		allPhotoIDs[objectID] = struct{}{}
+	}
+
+	var renditions []database.PhotoRendition
+	_, renditionsSpan := startSpan(ctx, "db.list_photo_renditions")
+	if err := s.DB.Where("user_id = ?", userID).Find(&renditions).Error; err != nil {
+		recordSpanError(renditionsSpan, err)
+		return integrityReport{}, nil, fmt.Errorf("failed to list thumbnails: %w", err)
+	}
+	endSpanOk(renditionsSpan)
+
+	var derived []database.DerivedObject
+	_, derivedSpan := startSpan(ctx, "db.list_derived_objects")
+	if err := s.DB.Where("user_id = ?", userID).Find(&derived).Error; err != nil {
+		recordSpanError(derivedSpan, err)
+		return integrityReport{}, nil, fmt.Errorf("failed to list derived objects: %w", err)
+	}
+	endSpanOk(derivedSpan)
+
+	_, allDerivedSpan := startSpan(ctx, "db.list_derived_objects")
+	allDerived, err := loadDerivedObjectSet(s.DB)
+	if err != nil {
+		recordSpanError(allDerivedSpan, err)
+		return integrityReport{}, nil, fmt.Errorf("failed to list derived objects: %w", err)
+	}
+	endSpanOk(allDerivedSpan)
+
+	var directories []database.PhotoDirectory
+	_, directoriesSpan := startSpan(ctx, "db.list_photo_directories")
+	if err := s.DB.Find(&directories).Error; err != nil {
+		recordSpanError(directoriesSpan, err)
+		return integrityReport{}, nil, fmt.Errorf("failed to list directories: %w", err)
+	}
+	endSpanOk(directoriesSpan)
+
+	check := &integrityCheck{ctx: ctx}
+	check.checkPhotoIntegrity(photos, objects)
+	check.checkDerivedIntegrity(photos, renditions, derived, allDerived, allPhotoIDs, objects)
+	check.checkDirectoryIntegrity(photos, directories, allPhotoIDs, objects)
+	return check.report, check.sortedProblems(), nil
+}
+
+// integrityProblemGone reports whether a missing object or a dangling
+// derived asset found by a check is no longer a problem. Storage is listed
+// before the database is read, and neither uploads nor background jobs take
+// the operation lock, so the object may have been written since, or the row
+// referring to it removed. Storage is only checked again if bucket is not
+// nil.
+func (s *LibraryServer) integrityProblemGone(ctx context.Context, bucket *storage.BucketHandle, userID uint, problem integrityProblem) (bool, error) {
+	objectID := problem.objectID
+	if problem.kind == proto.IntegrityProblem_KIND_DANGLING_DERIVED {
+		objectID = problem.derivedID
+	}
+	if bucket != nil {
+		_, attrsSpan := startSpan(ctx, "gcs.get_object_attrs")
+		_, err := bucket.Object(objectID).Attrs(ctx)
+		if err == nil {
+			endSpanOk(attrsSpan)
+			return true, nil
+		}
+		if !errors.Is(err, storage.ErrObjectNotExist) {
+			recordSpanError(attrsSpan, err)
+			return false, err
+		}
+		endSpanOk(attrsSpan)
+	}
+
+	_, dbSpan := startSpan(ctx, "db.count_photo_objects")
+	var count int64
+	var err error
+	if problem.kind == proto.IntegrityProblem_KIND_MISSING_OBJECT {
+		err = s.DB.Model(&database.PhotoObject{}).
+			Where("id = ? AND object_id = ? AND user_id = ?", problem.photoID, problem.objectID, userID).
+			Count(&count).Error
+	} else {
+		references := s.DB.Where("1 = 0")
+		for _, column := range photoDerivedColumns {
+			references = references.Or(column+" = ?", problem.derivedID)
+		}
+		err = s.DB.Model(&database.PhotoObject{}).
+			Where("object_id = ? AND user_id = ?", problem.objectID, userID).
+			Where(references).
+			Count(&count).Error
+		if err == nil && count == 0 {
+			err = s.DB.Model(&database.PhotoRendition{}).
+				Where("object_id = ? AND photo_object_id = ? AND user_id = ?", problem.derivedID, problem.objectID, userID).
+				Count(&count).Error
+		}
+	}
+	if err != nil {
+		recordSpanError(dbSpan, err)
+		return false, err
+	}
+	endSpanOk(dbSpan)
+	return count == 0, nil
+}
+
+// repairIntegrityProblem repairs a repairable problem of the user's library.
+// Photos whose object is missing are moved to the trash, as a sync would
+// delete them; references to missing derived assets are cleared so that
+// they are generated again; orphaned derived assets are deleted; and
+// directory rows are deleted or created. A nil bucket leaves storage as it
+// is. Missing objects and derived assets are looked up again first, and
+// errIntegrityProblemGone is returned if they have turned up since.
+func (s *LibraryServer) repairIntegrityProblem(ctx context.Context, bucket *storage.BucketHandle, userID uint, problem integrityProblem) error {
+	switch problem.kind {
+	case proto.IntegrityProblem_KIND_MISSING_OBJECT, proto.IntegrityProblem_KIND_DANGLING_DERIVED:
+		gone, err := s.integrityProblemGone(ctx, bucket, userID, problem)
+		if err != nil {
+			return err
+		}
+		if gone {
+			return errIntegrityProblemGone
+		}
+	}
+
+	switch problem.kind {
+	case proto.IntegrityProblem_KIND_MISSING_OBJECT:
+		_, span := startSpan(ctx, "db.delete_photo")
+		if err := withPhotoChange(s.DB, userID, database.ChangeKindDeleted, problem.objectID, func(tx *gorm.DB) error {
+			return tx.Where("id = ? AND user_id = ?", problem.photoID, userID).Delete(&database.PhotoObject{}).Error
+		}); err != nil {
+			recordSpanError(span, err)
+			return err
+		}
+		endSpanOk(span)
+		return nil
+
+	case proto.IntegrityProblem_KIND_DANGLING_DERIVED:
+		_, span := startSpan(ctx, "db.clear_derived_reference")
+		err := s.DB.Transaction(func(tx *gorm.DB) error {
+			for _, column := range photoDerivedColumns {
+				if err := tx.Model(&database.PhotoObject{}).
+					Where("object_id = ? AND user_id = ? AND "+column+" = ?", problem.objectID, userID, problem.derivedID).
+					Update(column, nil).Error; err != nil {
+					return err
+				}
+			}
+			return forgetDerivedRecords(tx, userID, problem.derivedID)
+		})
+		if err != nil {
+			recordSpanError(span, err)
+			return err
+		}
+		endSpanOk(span)
+		return nil
+
+	case proto.IntegrityProblem_KIND_ORPHANED_DERIVED:
+		if bucket != nil {
+			_, gcsDelSpan := startSpan(ctx, "gcs.delete_object")
+			if err := bucket.Object(problem.objectID).Delete(ctx); err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+				recordSpanError(gcsDelSpan, err)
+				return err
+			}
+			endSpanOk(gcsDelSpan)
+		}
+		_, span := startSpan(ctx, "db.delete_derived_object")
+		if err := s.DB.Transaction(func(tx *gorm.DB) error {
+			return forgetDerivedRecords(tx, userID, problem.objectID)
+		}); err != nil {
+			recordSpanError(span, err)
+			return err
+		}
+		endSpanOk(span)
+		return nil
+
+	case proto.IntegrityProblem_KIND_EMPTY_DIRECTORY:
+		_, span := startSpan(ctx, "db.delete_directory")
+		if err := s.DB.Where("path = ?", problem.objectID).Delete(&database.PhotoDirectory{}).Error; err != nil {
+			recordSpanError(span, err)
+			return err
+		}
+		endSpanOk(span)
+		return nil
+
+	case proto.IntegrityProblem_KIND_MISSING_DIRECTORY:
+		_, span := startSpan(ctx, "db.create_or_restore_photo_directory")
+		if err := database.CreateOrRestorePhotoDirectory(s.DB, problem.objectID); err != nil {
+			recordSpanError(span, err)
+			return err
+		}
+		endSpanOk(span)
+		return nil
+	}
+	return fmt.Errorf("%s cannot be repaired", problem.kind)
+}
+
+// forgetDerivedRecords deletes the derived asset record and thumbnail row of
+// the user for objectID, if any.
+func forgetDerivedRecords(tx *gorm.DB, userID uint, objectID string) error {
+	if err := tx.Where("object_id = ? AND user_id = ?", objectID, userID).Delete(&database.PhotoRendition{}).Error; err != nil {
+		return err
+	}
+	return tx.Where("object_id = ? AND user_id = ?", objectID, userID).Delete(&database.DerivedObject{}).Error
+}
+
+// VerifyLibrary checks the library of the authenticated user against the
+// storage backend (see checkIntegrity) and streams the problems found, one
+// per message in order of kind and then object, followed by a summary.
+//
+// With repair the problems that are safe to repair are repaired as they are
+// streamed (see repairIntegrityProblem); a failed repair is logged and the
+// problem reported as not repaired. A repair takes the user's operation
+// lock, so it does not run alongside SyncDatabase, UpdateWebp or UpdateAvif
+// (see runExclusive); a check alone is not limited. Problems caused by a
+// repair, such as a directory emptied by moving its photos to the trash,
+// are found by the next check.
+func (s *LibraryServer) VerifyLibrary(req *proto.VerifyLibraryRequest, stream grpc.ServerStreamingServer[proto.VerifyLibraryProgress]) error {
+	ctx := stream.Context()
+	userID, ok := ctx.Value(contextKeyUser{}).(uint)
+	if !ok {
+		return status.Errorf(codes.Unauthenticated, "authentication required")
+	}
+
+	if !req.GetRepair() {
+		return s.verifyLibrary(userID, req, stream)
+	}
+	return runExclusive(s, stream, userID, OperationVerifyLibrary, func(stream grpc.ServerStreamingServer[proto.VerifyLibraryProgress]) error {
+		return s.verifyLibrary(userID, req, stream)
+	})
+}
+
+// verifyLibrary implements VerifyLibrary, holding the user's operation lock
+// if it repairs.
+func (s *LibraryServer) verifyLibrary(userID uint, req *proto.VerifyLibraryRequest, stream grpc.ServerStreamingServer[proto.VerifyLibraryProgress]) error {
+	ctx := stream.Context()
+
+	report, problems, err := s.checkIntegrity(ctx, userID)
+	if err != nil {
+		return status.Errorf(codes.Internal, "%v", err)
+	}
+
+	var bucket *storage.BucketHandle
+	if s.GCSClient != nil {
+		bucket = s.GCSClient.Bucket(s.BucketName)
+	}
+
+	for _, problem := range problems {
+		repaired := false
+		if req.GetRepair() && problem.repairable {
+			err := s.repairIntegrityProblem(ctx, bucket, userID, problem)
+			switch {
+			case errors.Is(err, errIntegrityProblemGone):
+				problem.detail += "; no longer present"
+			case err != nil:
+				slog.WarnContext(
+					ctx,
+					"failed to repair integrity problem",
+					slog.String("kind", problem.kind.String()),
+					slog.String("object_id", problem.objectID),
+					slog.String("error", err.Error()),
+				)
+			default:
+				repaired = true
+				report.repaired++
+			}
+		}
+
+		detail := problem.detail
+		if req.GetRepair() && !problem.repairable {
+			detail += "; not safe to repair"
+		}
+		if err := stream.Send(&proto.VerifyLibraryProgress{
+			Problem: &proto.IntegrityProblem{
+				Kind:     problem.kind,
+				ObjectId: problem.objectID,
+				Detail:   detail,
+				Repaired: repaired,
+			},
+		}); err != nil {
+			return err
+		}
+	}
+
+	slog.InfoContext(
+		ctx,
+		"Library integrity check completed",
+		slog.String("report", report.String()),
+		slog.Bool("repair", req.GetRepair()),
+		slog.Uint64("user_id", uint64(userID)),
+	)
+
+	return stream.Send(&proto.VerifyLibraryProgress{
+		PhotosChecked:      uint32(report.photos),
+		MissingObjects:     uint32(report.missingObjects),
+		Md5Mismatches:      uint32(report.md5Mismatches),
+		DanglingDerived:    uint32(report.danglingDerived),
+		OrphanedDerived:    uint32(report.orphanedDerived),
+		EmptyDirectories:   uint32(report.emptyDirectories),
+		MissingDirectories: uint32(report.missingDirectories),
+		Repaired:           uint32(report.repaired),
+		Complete:           true,
+	})
+}
@@ -0,0 +1,276 @@
+package internal
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/alexhokl/photos/database"
+	"github.com/alexhokl/photos/proto"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"gorm.io/gorm"
+)
+
+// setupIntegrityLibrary creates a library of user 1 with one problem of
+// each kind against the objects it returns.
+func setupIntegrityLibrary(t *testing.T, db *gorm.DB) map[string]*storage.ObjectAttrs {
+	t.Helper()
+	md5Hash := []byte("0123456789abcdef")
+	encoded := base64.StdEncoding.EncodeToString(md5Hash)
+	aWebp := "2024/a.webp"
+	bWebp := "2024/b.webp"
+	records := []any{
+		&database.PhotoObject{ObjectID: "2024/a.jpg", MD5Hash: encoded, WebpObjectID: &aWebp, UserID: 1},
+		&database.PhotoObject{ObjectID: "2024/b.jpg", MD5Hash: "different", WebpObjectID: &bWebp, UserID: 1},
+		&database.PhotoObject{ObjectID: "2024/missing.jpg", MD5Hash: "x", UserID: 1},
+		&database.PhotoObject{ObjectID: "2025/c.jpg", MD5Hash: encoded, UserID: 1},
+		&database.PhotoRendition{ObjectID: "2024/a_256.jpg", PhotoObjectID: "2024/a.jpg", LongEdge: 256, Width: 256, Height: 192, ContentType: "image/jpeg", UserID: 1},
+		&database.DerivedObject{ObjectID: "2024/a_256.jpg", SourceObjectID: "2024/a.jpg", Kind: database.DerivedKindRendition, UserID: 1},
+		&database.DerivedObject{ObjectID: "2024/gone.webp", SourceObjectID: "2024/gone.jpg", Kind: database.DerivedKindWebP, UserID: 1},
+		&database.DerivedObject{ObjectID: "2024/pending.webp", SourceObjectID: "2024/pending.jpg", Kind: database.DerivedKindWebP, UserID: 1},
+		&database.PhotoDirectory{Path: "2024"},
+		&database.PhotoDirectory{Path: "empty"},
+		&database.PhotoDirectory{Path: "old"},
+	}
+	for _, record := range records {
+		if err := db.Create(record).Error; err != nil {
+			t.Fatalf("failed to create %T: %v", record, err)
+		}
+	}
+
+	return map[string]*storage.ObjectAttrs{
+		"2024/a.jpg":        {Name: "2024/a.jpg", MD5: md5Hash},
+		"2024/a.webp":       {Name: "2024/a.webp"},
+		"2024/b.jpg":        {Name: "2024/b.jpg", MD5: md5Hash},
+		"2024/pending.jpg":  {Name: "2024/pending.jpg"},
+		"2024/pending.webp": {Name: "2024/pending.webp"},
+		"2025/c.jpg":        {Name: "2025/c.jpg", MD5: md5Hash},
+		"old/x.webp":        {Name: "old/x.webp", Metadata: derivedObjectMetadata(database.DerivedKindWebP, "old/x.jpg")},
+	}
+}
+
+func TestCheckIntegrityAgainst(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	objects := setupIntegrityLibrary(t, db)
+	server := &LibraryServer{DB: db}
+
+	report, problems, err := server.checkIntegrityAgainst(context.Background(), 1, objects)
+	if err != nil {
+		t.Fatalf("checkIntegrityAgainst() error = %v", err)
+	}
+
+	expected := []struct {
+		kind       proto.IntegrityProblem_Kind
+		objectID   string
+		repairable bool
+	}{
+		{proto.IntegrityProblem_KIND_MISSING_OBJECT, "2024/missing.jpg", true},
+		{proto.IntegrityProblem_KIND_MD5_MISMATCH, "2024/b.jpg", false},
+		{proto.IntegrityProblem_KIND_DANGLING_DERIVED, "2024/a.jpg", true},
+		{proto.IntegrityProblem_KIND_DANGLING_DERIVED, "2024/b.jpg", true},
+		{proto.IntegrityProblem_KIND_ORPHANED_DERIVED, "2024/gone.webp", true},
+		{proto.IntegrityProblem_KIND_ORPHANED_DERIVED, "2024/pending.webp", false},
+		{proto.IntegrityProblem_KIND_ORPHANED_DERIVED, "old/x.webp", true},
+		{proto.IntegrityProblem_KIND_EMPTY_DIRECTORY, "empty", true},
+		{proto.IntegrityProblem_KIND_MISSING_DIRECTORY, "2025", true},
+	}
+	if len(problems) != len(expected) {
+		t.Fatalf("got %d problems %+v, want %d", len(problems), problems, len(expected))
+	}
+	for i, want := range expected {
+		got := problems[i]
+		if got.kind != want.kind || got.objectID != want.objectID || got.repairable != want.repairable {
+			t.Errorf("problem %d = %s %s repairable %v, want %s %s repairable %v",
+				i, got.kind, got.objectID, got.repairable, want.kind, want.objectID, want.repairable)
+		}
+	}
+
+	want := integrityReport{photos: 4, missingObjects: 1, md5Mismatches: 1, danglingDerived: 2, orphanedDerived: 3, emptyDirectories: 1, missingDirectories: 1}
+	if report != want {
+		t.Errorf("report = %+v, want %+v", report, want)
+	}
+}
+
+func TestRepairIntegrityProblem(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	objects := setupIntegrityLibrary(t, db)
+	server := &LibraryServer{DB: db}
+	ctx := context.Background()
+
+	_, problems, err := server.checkIntegrityAgainst(ctx, 1, objects)
+	if err != nil {
+		t.Fatalf("checkIntegrityAgainst() error = %v", err)
+	}
+	for _, problem := range problems {
+		if !problem.repairable {
+			continue
+		}
+		if err := server.repairIntegrityProblem(ctx, nil, 1, problem); err != nil {
+			t.Errorf("repairIntegrityProblem(%s %s) error = %v", problem.kind, problem.objectID, err)
+		}
+	}
+	// Without a bucket the orphaned object is left in storage
+	delete(objects, "old/x.webp")
+
+	_, problems, err = server.checkIntegrityAgainst(ctx, 1, objects)
+	if err != nil {
+		t.Fatalf("checkIntegrityAgainst() error = %v", err)
+	}
+	// Those not safe to repair remain, and the directory emptied by deleting
+	// the orphan is found
+	if len(problems) != 3 ||
+		problems[0].kind != proto.IntegrityProblem_KIND_MD5_MISMATCH ||
+		problems[1].kind != proto.IntegrityProblem_KIND_ORPHANED_DERIVED || problems[1].objectID != "2024/pending.webp" ||
+		problems[2].kind != proto.IntegrityProblem_KIND_EMPTY_DIRECTORY || problems[2].objectID != "old" {
+		t.Errorf("problems after repair = %+v, want those not safe to repair and the emptied directory", problems)
+	}
+
+	var trashed database.PhotoObject
+	if err := db.Unscoped().Where("object_id = ?", "2024/missing.jpg").First(&trashed).Error; err != nil || !trashed.DeletedAt.Valid {
+		t.Errorf("photo missing from storage = %+v, %v, want it in the trash", trashed, err)
+	}
+	var b database.PhotoObject
+	db.Where("object_id = ?", "2024/b.jpg").First(&b)
+	if b.WebpObjectID != nil {
+		t.Errorf("webp_object_id = %q, want it cleared", *b.WebpObjectID)
+	}
+}
+
+func TestRepairIntegrityProblem_GoneSinceCheck(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	objects := setupIntegrityLibrary(t, db)
+	ctx := context.Background()
+
+	// Uploaded, or written by a background job, after storage was listed
+	written := map[string]bool{"2024/missing.jpg": true, "2024/b.webp": true, "2024/a_256.jpg": true}
+	gcs := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := r.URL.Path[strings.LastIndex(r.URL.Path, "/o/")+len("/o/"):]
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method == http.MethodGet && written[name] {
+			fmt.Fprintf(w, `{"bucket":"photos","name":%q,"size":"3"}`, name)
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"error":{"code":404,"message":"Not Found"}}`)
+	}))
+	t.Cleanup(gcs.Close)
+	client, err := storage.NewClient(ctx, option.WithEndpoint(gcs.URL+"/storage/v1/"), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatalf("failed to create storage client: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	server := &LibraryServer{DB: db}
+
+	_, problems, err := server.checkIntegrityAgainst(ctx, 1, objects)
+	if err != nil {
+		t.Fatalf("checkIntegrityAgainst() error = %v", err)
+	}
+	var repairs int
+	for _, problem := range problems {
+		switch problem.kind {
+		case proto.IntegrityProblem_KIND_MISSING_OBJECT, proto.IntegrityProblem_KIND_DANGLING_DERIVED:
+		default:
+			continue
+		}
+		repairs++
+		err := server.repairIntegrityProblem(ctx, client.Bucket("photos"), 1, problem)
+		if !errors.Is(err, errIntegrityProblemGone) {
+			t.Errorf("repairIntegrityProblem(%s %s) error = %v, want %v", problem.kind, problem.objectID, err, errIntegrityProblemGone)
+		}
+	}
+	if repairs != 3 {
+		t.Fatalf("got %d missing objects and dangling derived assets, want 3", repairs)
+	}
+
+	var photo database.PhotoObject
+	if err := db.Where("object_id = ?", "2024/missing.jpg").First(&photo).Error; err != nil {
+		t.Errorf("photo uploaded since the check was deleted: %v", err)
+	}
+	var b database.PhotoObject
+	db.Where("object_id = ?", "2024/b.jpg").First(&b)
+	if b.WebpObjectID == nil {
+		t.Error("reference to a derived asset written since the check was cleared")
+	}
+
+	// A photo deleted and uploaded again since the check has a new row
+	problem := integrityProblem{kind: proto.IntegrityProblem_KIND_MISSING_OBJECT, objectID: "2024/missing.jpg", photoID: photo.ID + 100}
+	if err := server.repairIntegrityProblem(ctx, nil, 1, problem); !errors.Is(err, errIntegrityProblemGone) {
+		t.Errorf("repairIntegrityProblem() of a replaced row error = %v, want %v", err, errIntegrityProblemGone)
+	}
+}
+
+// mockVerifyLibraryStream records the messages sent on it.
+type mockVerifyLibraryStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	sent []*proto.VerifyLibraryProgress
+}
+
+func (m *mockVerifyLibraryStream) Send(msg *proto.VerifyLibraryProgress) error {
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func (m *mockVerifyLibraryStream) Context() context.Context { return m.ctx }
+
+func TestVerifyLibrary(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	server := &LibraryServer{DB: db}
+
+	err := server.VerifyLibrary(&proto.VerifyLibraryRequest{}, &mockVerifyLibraryStream{ctx: context.Background()})
+	assertGRPCError(t, err, codes.Unauthenticated)
+
+	// Without a bucket, the object of the photo is missing
+	if err := db.Create(&database.PhotoObject{ObjectID: "2024/a.jpg", MD5Hash: "x", UserID: 1}).Error; err != nil {
+		t.Fatalf("failed to create photo: %v", err)
+	}
+	if err := db.Create(&database.PhotoDirectory{Path: "2024"}).Error; err != nil {
+		t.Fatalf("failed to create directory: %v", err)
+	}
+
+	stream := &mockVerifyLibraryStream{ctx: contextWithUserID(1)}
+	if err := server.VerifyLibrary(&proto.VerifyLibraryRequest{}, stream); err != nil {
+		t.Fatalf("VerifyLibrary() error = %v", err)
+	}
+	if len(stream.sent) != 2 {
+		t.Fatalf("got %d messages, want a problem and a summary", len(stream.sent))
+	}
+	problem := stream.sent[0].GetProblem()
+	if problem.GetKind() != proto.IntegrityProblem_KIND_MISSING_OBJECT || problem.GetObjectId() != "2024/a.jpg" || problem.GetRepaired() {
+		t.Errorf("problem = %v, want 2024/a.jpg missing and not repaired", problem)
+	}
+	if last := stream.sent[1]; !last.GetComplete() || last.GetMissingObjects() != 1 || last.GetPhotosChecked() != 1 {
+		t.Errorf("summary = %v, want 1 photo checked and 1 missing", last)
+	}
+
+	stream = &mockVerifyLibraryStream{ctx: contextWithUserID(1)}
+	if err := server.VerifyLibrary(&proto.VerifyLibraryRequest{Repair: true}, stream); err != nil {
+		t.Fatalf("VerifyLibrary() with repair error = %v", err)
+	}
+	if !stream.sent[0].GetProblem().GetRepaired() || stream.sent[len(stream.sent)-1].GetRepaired() != 1 {
+		t.Errorf("messages = %v, want the problem repaired", stream.sent)
+	}
+
+	// The directory emptied by the repair is found by the next check
+	stream = &mockVerifyLibraryStream{ctx: contextWithUserID(1)}
+	if err := server.VerifyLibrary(&proto.VerifyLibraryRequest{}, stream); err != nil {
+		t.Fatalf("VerifyLibrary() error = %v", err)
+	}
+	if kind := stream.sent[0].GetProblem().GetKind(); kind != proto.IntegrityProblem_KIND_EMPTY_DIRECTORY {
+		t.Errorf("problem after repair = %s, want %s", kind, proto.IntegrityProblem_KIND_EMPTY_DIRECTORY)
+	}
+
+	var count int64
+	db.Unscoped().Model(&database.OperationLock{}).Count(&count)
+	if count != 0 {
+		t.Errorf("got %d lock rows after the repair, want 0", count)
+	}
+}
@@ -0,0 +1,479 @@
+package internal
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/alexhokl/photos/database"
+	"github.com/alexhokl/photos/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+const (
+	// DefaultJobWorkers is the number of background jobs run at once
+	DefaultJobWorkers = 2
+	// jobMaxAttempts is the number of attempts made at a job before it is
+	// failed
+	jobMaxAttempts = 5
+	// jobRetryBaseDelay is the delay before the first retry of a job; it
+	// doubles with each further attempt up to jobRetryMaxDelay
+	jobRetryBaseDelay = 30 * time.Second
+	jobRetryMaxDelay  = time.Hour
+	// jobPollInterval is how often idle workers look for jobs whose retry
+	// has become due
+	jobPollInterval = 10 * time.Second
+	// jobBusyDelay is the delay before a job whose handler was busy is run
+	// again
+	jobBusyDelay = time.Minute
+)
+
+// errJobBusy is returned by a JobHandler that cannot run its job while
+// another one holds what it needs. The job is run again after jobBusyDelay
+// without counting the attempt, so that it does not hold up a worker.
+var errJobBusy = errors.New("job handler busy")
+
+// jobStatuses are the statuses a job can have.
+var jobStatuses = []string{
+	database.JobStatusPending,
+	database.JobStatusRunning,
+	database.JobStatusSucceeded,
+	database.JobStatusFailed,
+}
+
+// JobHandler runs a job of a kind; an error fails the attempt.
+type JobHandler func(ctx context.Context, job *database.Job) error
+
+// JobQueue runs the jobs recorded in the database in the background, at most
+// Workers at a time. Jobs are persisted, so those queued or interrupted when
+// the server stops are run when it starts again.
+type JobQueue struct {
+	DB *gorm.DB
+	// Workers is the number of jobs run at once
+	Workers int
+
+	handlers map[string]JobHandler
+	wake     chan struct{}
+}
+
+// NewJobQueue returns a JobQueue running workers jobs at once, or one per
+// CPU if workers is not positive.
+func NewJobQueue(db *gorm.DB, workers int) *JobQueue {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	return &JobQueue{
+		DB:       db,
+		Workers:  workers,
+		handlers: make(map[string]JobHandler),
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Handle registers the handler running jobs of kind. It must be called
+// before Run.
+func (q *JobQueue) Handle(kind string, handler JobHandler) {
+	q.handlers[kind] = handler
+}
+
+// Enqueue queues a job of kind on objectID of the user, unless one is
+// already pending.
+func (q *JobQueue) Enqueue(ctx context.Context, userID uint, kind, objectID string) error {
+	var pending int64
+	_, countSpan := startSpan(ctx, "db.count_jobs")
+	if err := q.DB.Model(&database.Job{}).
+		Where("kind = ? AND object_id = ? AND user_id = ? AND status = ?", kind, objectID, userID, database.JobStatusPending).
+		Count(&pending).Error; err != nil {
+		recordSpanError(countSpan, err)
+		return err
+	}
+	endSpanOk(countSpan)
+	if pending > 0 {
+		return nil
+	}
+
+	job := &database.Job{
+		Kind:        kind,
+		ObjectID:    objectID,
+		UserID:      userID,
+		Status:      database.JobStatusPending,
+		MaxAttempts: jobMaxAttempts,
+		RunAfter:    time.Now(),
+	}
+	_, createSpan := startSpan(ctx, "db.create_job")
+	if err := q.DB.Create(job).Error; err != nil {
+		recordSpanError(createSpan, err)
+		return err
+	}
+	endSpanOk(createSpan)
+	q.notify()
+
+	slog.InfoContext(ctx, "Queued job",
+		slog.Uint64("job_id", uint64(job.ID)),
+		slog.String("kind", kind),
+		slog.String("object_id", objectID),
+	)
+	return nil
+}
+
+// notify wakes an idle worker, if there is one.
+func (q *JobQueue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run runs queued jobs until ctx is cancelled. Jobs left running by a server
+// that stopped are queued again first.
+func (q *JobQueue) Run(ctx context.Context) {
+	if err := q.DB.Model(&database.Job{}).
+		Where("status = ?", database.JobStatusRunning).
+		Updates(map[string]any{
+			"status":    database.JobStatusPending,
+			"run_after": time.Now(),
+		}).Error; err != nil {
+		slog.WarnContext(ctx, "failed to requeue interrupted jobs",
+			slog.String("error", err.Error()),
+		)
+	}
+
+	var wg sync.WaitGroup
+	for range q.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+// work runs jobs as they become due until ctx is cancelled.
+func (q *JobQueue) work(ctx context.Context) {
+	ticker := time.NewTicker(jobPollInterval)
+	defer ticker.Stop()
+
+	for {
+		for ctx.Err() == nil {
+			job, err := q.claim()
+			if err != nil {
+				slog.WarnContext(ctx, "failed to claim job",
+					slog.String("error", err.Error()),
+				)
+				break
+			}
+			if job == nil {
+				break
+			}
+			// Another job may be due; let an idle worker look for it
+			q.notify()
+			q.runJob(ctx, job)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-q.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// claim marks the pending job that has been due the longest as running and
+// returns it, or returns nil if no job is due.
+func (q *JobQueue) claim() (*database.Job, error) {
+	for {
+		var job database.Job
+		err := q.DB.Where("status = ? AND run_after <= ?", database.JobStatusPending, time.Now()).
+			Order("run_after ASC, id ASC").
+			First(&job).Error
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		now := time.Now()
+		result := q.DB.Model(&database.Job{}).
+			Where("id = ? AND status = ?", job.ID, database.JobStatusPending).
+			Updates(map[string]any{
+				"status":      database.JobStatusRunning,
+				"attempts":    gorm.Expr("attempts + 1"),
+				"started_at":  now,
+				"finished_at": nil,
+			})
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		// Otherwise another worker claimed it first
+		if result.RowsAffected == 1 {
+			job.Status = database.JobStatusRunning
+			job.Attempts++
+			job.StartedAt = &now
+			job.FinishedAt = nil
+			return &job, nil
+		}
+	}
+}
+
+// jobRetryDelay returns how long a job waits before it is attempted again
+// after its attempts-th attempt failed.
+func jobRetryDelay(attempts int) time.Duration {
+	delay := jobRetryBaseDelay
+	for i := 1; i < attempts && delay < jobRetryMaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, jobRetryMaxDelay)
+}
+
+// runJob runs job with the handler of its kind and records the outcome:
+// succeeded, pending for a retry, or failed once it has made its last
+// attempt. A job interrupted by ctx being cancelled, or whose handler is
+// busy, is left pending without counting the attempt.
+func (q *JobQueue) runJob(ctx context.Context, job *database.Job) {
+	ctx, span := startSpan(ctx, "job."+job.Kind)
+
+	var err error
+	if handler, ok := q.handlers[job.Kind]; ok {
+		err = handler(ctx, job)
+	} else {
+		err = fmt.Errorf("unknown job kind %q", job.Kind)
+	}
+
+	now := time.Now()
+	updates := map[string]any{"finished_at": now}
+	switch {
+	case err == nil:
+		updates["status"] = database.JobStatusSucceeded
+		updates["last_error"] = ""
+	case ctx.Err() != nil:
+		updates["status"] = database.JobStatusPending
+		updates["attempts"] = job.Attempts - 1
+		updates["run_after"] = now
+	case errors.Is(err, errJobBusy):
+		updates["status"] = database.JobStatusPending
+		updates["attempts"] = job.Attempts - 1
+		updates["run_after"] = now.Add(jobBusyDelay)
+	case job.Attempts >= job.MaxAttempts:
+		updates["status"] = database.JobStatusFailed
+		updates["last_error"] = err.Error()
+	default:
+		updates["status"] = database.JobStatusPending
+		updates["last_error"] = err.Error()
+		updates["run_after"] = now.Add(jobRetryDelay(job.Attempts))
+	}
+	if dbErr := q.DB.Model(&database.Job{}).Where("id = ?", job.ID).Updates(updates).Error; dbErr != nil {
+		slog.WarnContext(ctx, "failed to record job outcome",
+			slog.Uint64("job_id", uint64(job.ID)),
+			slog.String("error", dbErr.Error()),
+		)
+	}
+
+	if errors.Is(err, errJobBusy) {
+		endSpanOk(span)
+		slog.InfoContext(ctx, "Deferred job",
+			slog.Uint64("job_id", uint64(job.ID)),
+			slog.String("kind", job.Kind),
+			slog.String("object_id", job.ObjectID),
+		)
+		return
+	}
+	if err != nil {
+		recordSpanError(span, err)
+		slog.WarnContext(ctx, "job failed",
+			slog.Uint64("job_id", uint64(job.ID)),
+			slog.String("kind", job.Kind),
+			slog.String("object_id", job.ObjectID),
+			slog.Int("attempts", job.Attempts),
+			slog.String("status", updates["status"].(string)),
+			slog.String("error", err.Error()),
+		)
+		return
+	}
+	endSpanOk(span)
+	slog.InfoContext(ctx, "Completed job",
+		slog.Uint64("job_id", uint64(job.ID)),
+		slog.String("kind", job.Kind),
+		slog.String("object_id", job.ObjectID),
+	)
+}
+
+// jobToProto converts a Job to its protobuf message.
+func jobToProto(job *database.Job) *proto.Job {
+	result := &proto.Job{
+		Id:          uint64(job.ID),
+		Kind:        job.Kind,
+		ObjectId:    job.ObjectID,
+		Status:      job.Status,
+		Attempts:    int32(job.Attempts),
+		MaxAttempts: int32(job.MaxAttempts),
+		LastError:   job.LastError,
+		CreatedAt:   job.CreatedAt.Format(time.RFC3339),
+		RunAfter:    job.RunAfter.Format(time.RFC3339),
+	}
+	if job.StartedAt != nil {
+		result.StartedAt = job.StartedAt.Format(time.RFC3339)
+	}
+	if job.FinishedAt != nil {
+		result.FinishedAt = job.FinishedAt.Format(time.RFC3339)
+	}
+	return result
+}
+
+// getJob returns the job id of the user, or a gRPC error if there is none.
+func (s *LibraryServer) getJob(ctx context.Context, userID uint, id uint64) (*database.Job, error) {
+	if id == 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "id is required")
+	}
+
+	var job database.Job
+	_, dbSpan := startSpan(ctx, "db.get_job")
+	if err := s.DB.Where("id = ? AND user_id = ?", id, userID).First(&job).Error; err != nil {
+		recordSpanError(dbSpan, err)
+		if err == gorm.ErrRecordNotFound {
+			return nil, status.Errorf(codes.NotFound, "job not found: %d", id)
+		}
+		return nil, status.Errorf(codes.Internal, "failed to query job: %v", err)
+	}
+	endSpanOk(dbSpan)
+	return &job, nil
+}
+
+// ListJobs lists the background jobs of the authenticated user, newest
+// first, optionally only those of a status.
+func (s *LibraryServer) ListJobs(ctx context.Context, req *proto.ListJobsRequest) (*proto.ListJobsResponse, error) {
+	userID, ok := ctx.Value(contextKeyUser{}).(uint)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "authentication required")
+	}
+
+	pageSize := req.GetPageSize()
+	if pageSize <= 0 {
+		pageSize = 100
+	}
+	if pageSize > 1000 {
+		pageSize = 1000
+	}
+
+	query := s.DB.Where("user_id = ?", userID)
+	if jobStatus := req.GetStatus(); jobStatus != "" {
+		if !slices.Contains(jobStatuses, jobStatus) {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid status: %s", jobStatus)
+		}
+		query = query.Where("status = ?", jobStatus)
+	}
+
+	// Token format: the ID of the last job of the previous page
+	if pageToken := req.GetPageToken(); pageToken != "" {
+		decodedToken, err := base64.StdEncoding.DecodeString(pageToken)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid page token")
+		}
+		lastID, err := strconv.ParseUint(string(decodedToken), 10, 64)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid page token format")
+		}
+		query = query.Where("id < ?", lastID)
+	}
+
+	// Fetch one extra record to determine if there are more results
+	var jobs []database.Job
+	_, listSpan := startSpan(ctx, "db.list_jobs")
+	if err := query.Order("id DESC").Limit(int(pageSize) + 1).Find(&jobs).Error; err != nil {
+		recordSpanError(listSpan, err)
+		return nil, status.Errorf(codes.Internal, "failed to list jobs: %v", err)
+	}
+	endSpanOk(listSpan)
+
+	var nextPageToken string
+	if len(jobs) > int(pageSize) {
+		jobs = jobs[:pageSize]
+		nextPageToken = base64.StdEncoding.EncodeToString([]byte(strconv.FormatUint(uint64(jobs[len(jobs)-1].ID), 10)))
+	}
+	protoJobs := make([]*proto.Job, 0, len(jobs))
+	for i := range jobs {
+		protoJobs = append(protoJobs, jobToProto(&jobs[i]))
+	}
+
+	return &proto.ListJobsResponse{
+		Jobs:          protoJobs,
+		NextPageToken: nextPageToken,
+	}, nil
+}
+
+// GetJob retrieves a background job of the authenticated user by ID.
+func (s *LibraryServer) GetJob(ctx context.Context, req *proto.GetJobRequest) (*proto.GetJobResponse, error) {
+	userID, ok := ctx.Value(contextKeyUser{}).(uint)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "authentication required")
+	}
+
+	job, err := s.getJob(ctx, userID, req.GetId())
+	if err != nil {
+		return nil, err
+	}
+
+	return &proto.GetJobResponse{
+		Job: jobToProto(job),
+	}, nil
+}
+
+// RetryJob queues a failed background job of the authenticated user again,
+// with its attempts reset. It fails with FailedPrecondition if the job has
+// not failed.
+func (s *LibraryServer) RetryJob(ctx context.Context, req *proto.RetryJobRequest) (*proto.RetryJobResponse, error) {
+	userID, ok := ctx.Value(contextKeyUser{}).(uint)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "authentication required")
+	}
+
+	job, err := s.getJob(ctx, userID, req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	if job.Status != database.JobStatusFailed {
+		return nil, status.Errorf(codes.FailedPrecondition, "job %d is %s; only failed jobs can be retried", job.ID, job.Status)
+	}
+
+	job.Status = database.JobStatusPending
+	job.Attempts = 0
+	job.RunAfter = time.Now()
+	_, updateSpan := startSpan(ctx, "db.update_job")
+	if err := s.DB.Model(&database.Job{}).
+		Where("id = ? AND status = ?", job.ID, database.JobStatusFailed).
+		Updates(map[string]any{
+			"status":    job.Status,
+			"attempts":  job.Attempts,
+			"run_after": job.RunAfter,
+		}).Error; err != nil {
+		recordSpanError(updateSpan, err)
+		return nil, status.Errorf(codes.Internal, "failed to retry job: %v", err)
+	}
+	endSpanOk(updateSpan)
+	if s.Jobs != nil {
+		s.Jobs.notify()
+	}
+
+	slog.InfoContext(
+		ctx,
+		"Retrying job",
+		slog.Uint64("job_id", uint64(job.ID)),
+		slog.String("kind", job.Kind),
+		slog.String("object_id", job.ObjectID),
+	)
+
+	return &proto.RetryJobResponse{
+		Job: jobToProto(job),
+	}, nil
+}
@@ -0,0 +1,428 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alexhokl/photos/database"
+	"github.com/alexhokl/photos/proto"
+	"google.golang.org/grpc/codes"
+	"gorm.io/gorm"
+)
+
+func TestJobRetryDelay(t *testing.T) {
+	tests := []struct {
+		attempts int
+		expected time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{5, 8 * time.Minute},
+		{7, 32 * time.Minute},
+		{8, time.Hour},
+		{100, time.Hour},
+	}
+	for _, test := range tests {
+		if got := jobRetryDelay(test.attempts); got != test.expected {
+			t.Errorf("jobRetryDelay(%d) = %v, want %v", test.attempts, got, test.expected)
+		}
+	}
+}
+
+// getTestJob returns the job id, failing the test if there is none.
+func getTestJob(t *testing.T, db *gorm.DB, id uint) database.Job {
+	t.Helper()
+	var job database.Job
+	if err := db.First(&job, id).Error; err != nil {
+		t.Fatalf("failed to find job %d: %v", id, err)
+	}
+	return job
+}
+
+func TestJobQueue_Enqueue(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	queue := NewJobQueue(db, 1)
+	ctx := context.Background()
+
+	if err := queue.Enqueue(ctx, 1, database.JobKindDerivedAssets, "a.jpg"); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	// A second upload of the same photo before the job runs is a no-op
+	if err := queue.Enqueue(ctx, 1, database.JobKindDerivedAssets, "a.jpg"); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	if err := queue.Enqueue(ctx, 1, database.JobKindTranscode, "a.jpg"); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	if err := queue.Enqueue(ctx, 2, database.JobKindDerivedAssets, "a.jpg"); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+
+	var jobs []database.Job
+	db.Order("id").Find(&jobs)
+	if len(jobs) != 3 {
+		t.Fatalf("got %d jobs, want 3", len(jobs))
+	}
+	job := jobs[0]
+	if job.Status != database.JobStatusPending || job.MaxAttempts != jobMaxAttempts || job.Attempts != 0 {
+		t.Errorf("got status %q, attempts %d/%d, want pending, 0/%d", job.Status, job.Attempts, job.MaxAttempts, jobMaxAttempts)
+	}
+
+	// Once the job has run, the photo can be queued again
+	db.Model(&database.Job{}).Where("id = ?", job.ID).Update("status", database.JobStatusSucceeded)
+	if err := queue.Enqueue(ctx, 1, database.JobKindDerivedAssets, "a.jpg"); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	var count int64
+	db.Model(&database.Job{}).Count(&count)
+	if count != 4 {
+		t.Errorf("got %d jobs, want 4", count)
+	}
+}
+
+func TestJobQueue_Claim(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	queue := NewJobQueue(db, 1)
+	now := time.Now()
+
+	jobs := []database.Job{
+		{Kind: database.JobKindDerivedAssets, ObjectID: "later.jpg", UserID: 1, Status: database.JobStatusPending, MaxAttempts: jobMaxAttempts, RunAfter: now.Add(time.Hour)},
+		{Kind: database.JobKindDerivedAssets, ObjectID: "second.jpg", UserID: 1, Status: database.JobStatusPending, MaxAttempts: jobMaxAttempts, RunAfter: now.Add(-time.Minute)},
+		{Kind: database.JobKindDerivedAssets, ObjectID: "first.jpg", UserID: 1, Status: database.JobStatusPending, MaxAttempts: jobMaxAttempts, RunAfter: now.Add(-time.Hour)},
+		{Kind: database.JobKindDerivedAssets, ObjectID: "failed.jpg", UserID: 1, Status: database.JobStatusFailed, MaxAttempts: jobMaxAttempts, RunAfter: now.Add(-2 * time.Hour)},
+	}
+	db.Create(&jobs)
+
+	for _, expected := range []string{"first.jpg", "second.jpg"} {
+		job, err := queue.claim()
+		if err != nil {
+			t.Fatalf("claim() error = %v", err)
+		}
+		if job == nil || job.ObjectID != expected {
+			t.Fatalf("claim() = %v, want %s", job, expected)
+		}
+		stored := getTestJob(t, db, job.ID)
+		if stored.Status != database.JobStatusRunning || stored.Attempts != 1 || stored.StartedAt == nil {
+			t.Errorf("%s: got status %q, attempts %d, started at %v, want running, 1, set", expected, stored.Status, stored.Attempts, stored.StartedAt)
+		}
+	}
+
+	job, err := queue.claim()
+	if err != nil {
+		t.Fatalf("claim() error = %v", err)
+	}
+	if job != nil {
+		t.Errorf("claim() = %s, want no job due", job.ObjectID)
+	}
+}
+
+func TestJobQueue_RunJob(t *testing.T) {
+	errBoom := errors.New("boom")
+	tests := []struct {
+		name            string
+		kind            string
+		attempts        int
+		handlerErr      error
+		expectedStatus  string
+		expectedError   string
+		expectRetryWait bool
+	}{
+		{"success", database.JobKindDerivedAssets, 1, nil, database.JobStatusSucceeded, "", false},
+		{"failure is retried", database.JobKindDerivedAssets, 1, errBoom, database.JobStatusPending, "boom", true},
+		{"last attempt fails the job", database.JobKindDerivedAssets, jobMaxAttempts, errBoom, database.JobStatusFailed, "boom", false},
+		{"unknown kind", "unknown", jobMaxAttempts, nil, database.JobStatusFailed, `unknown job kind "unknown"`, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			db := setupLibraryTestDB(t)
+			queue := NewJobQueue(db, 1)
+			var handled *database.Job
+			queue.Handle(database.JobKindDerivedAssets, func(_ context.Context, job *database.Job) error {
+				handled = job
+				return test.handlerErr
+			})
+
+			started := time.Now()
+			job := database.Job{
+				Kind:        test.kind,
+				ObjectID:    "a.jpg",
+				UserID:      1,
+				Status:      database.JobStatusRunning,
+				Attempts:    test.attempts,
+				MaxAttempts: jobMaxAttempts,
+				RunAfter:    started,
+				StartedAt:   &started,
+			}
+			db.Create(&job)
+
+			queue.runJob(context.Background(), &job)
+
+			if test.kind == database.JobKindDerivedAssets && (handled == nil || handled.ObjectID != "a.jpg") {
+				t.Errorf("handler got %v, want job of a.jpg", handled)
+			}
+			stored := getTestJob(t, db, job.ID)
+			if stored.Status != test.expectedStatus {
+				t.Errorf("got status %q, want %q", stored.Status, test.expectedStatus)
+			}
+			if stored.LastError != test.expectedError {
+				t.Errorf("got last error %q, want %q", stored.LastError, test.expectedError)
+			}
+			if stored.FinishedAt == nil {
+				t.Error("expected finished at to be set")
+			}
+			waited := stored.RunAfter.Sub(started)
+			if test.expectRetryWait && waited < jobRetryDelay(test.attempts)-time.Second {
+				t.Errorf("got retry after %v, want at least %v", waited, jobRetryDelay(test.attempts))
+			}
+		})
+	}
+}
+
+func TestJobQueue_RunJob_Cancelled(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	queue := NewJobQueue(db, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	queue.Handle(database.JobKindTranscode, func(ctx context.Context, _ *database.Job) error {
+		cancel()
+		return ctx.Err()
+	})
+
+	job := database.Job{Kind: database.JobKindTranscode, ObjectID: "clip.mov", UserID: 1, Status: database.JobStatusRunning, Attempts: 1, MaxAttempts: jobMaxAttempts, RunAfter: time.Now()}
+	db.Create(&job)
+
+	queue.runJob(ctx, &job)
+
+	// A job interrupted by the server stopping is run again on restart
+	// without counting the attempt
+	stored := getTestJob(t, db, job.ID)
+	if stored.Status != database.JobStatusPending || stored.Attempts != 0 || stored.LastError != "" {
+		t.Errorf("got status %q, attempts %d, last error %q, want pending, 0, none", stored.Status, stored.Attempts, stored.LastError)
+	}
+}
+
+func TestJobQueue_RunJob_TranscoderBusy(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	queue := NewJobQueue(db, 1)
+	transcoder := NewTranscoder(db, nil, "", nil, nil)
+	queue.Handle(database.JobKindTranscode, transcoder.RunJob)
+
+	job := database.Job{Kind: database.JobKindTranscode, ObjectID: "clip.mov", UserID: 1, Status: database.JobStatusRunning, Attempts: 1, MaxAttempts: jobMaxAttempts, RunAfter: time.Now()}
+	db.Create(&job)
+
+	// A transcode is running, so the job is deferred rather than waiting
+	// for it
+	transcoder.mu.Lock()
+	before := time.Now()
+	queue.runJob(context.Background(), &job)
+	transcoder.mu.Unlock()
+
+	stored := getTestJob(t, db, job.ID)
+	if stored.Status != database.JobStatusPending || stored.Attempts != 0 || stored.LastError != "" {
+		t.Errorf("got status %q, attempts %d, last error %q, want pending, 0, none", stored.Status, stored.Attempts, stored.LastError)
+	}
+	if stored.RunAfter.Before(before.Add(jobBusyDelay)) {
+		t.Errorf("got run after %v, want at least %v later", stored.RunAfter, jobBusyDelay)
+	}
+}
+
+func TestJobQueue_Run(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	// Every connection to an in-memory database opens a database of its own
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("failed to get database: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+
+	// A job left running by a server that stopped, and one queued
+	started := time.Now().Add(-time.Minute)
+	interrupted := database.Job{Kind: database.JobKindDerivedAssets, ObjectID: "interrupted.jpg", UserID: 1, Status: database.JobStatusRunning, Attempts: 1, MaxAttempts: jobMaxAttempts, RunAfter: started, StartedAt: &started}
+	queued := database.Job{Kind: database.JobKindDerivedAssets, ObjectID: "queued.jpg", UserID: 1, Status: database.JobStatusPending, MaxAttempts: jobMaxAttempts, RunAfter: started}
+	db.Create(&interrupted)
+	db.Create(&queued)
+
+	queue := NewJobQueue(db, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handled := make(chan string, 3)
+	queue.Handle(database.JobKindDerivedAssets, func(_ context.Context, job *database.Job) error {
+		handled <- job.ObjectID
+		return nil
+	})
+	done := make(chan struct{})
+	go func() {
+		queue.Run(ctx)
+		close(done)
+	}()
+
+	seen := map[string]bool{}
+	for len(seen) < 2 {
+		select {
+		case objectID := <-handled:
+			seen[objectID] = true
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for jobs; ran %v", seen)
+		}
+	}
+
+	// A job queued while the queue is running is picked up without waiting
+	// for the poll
+	if err := queue.Enqueue(ctx, 1, database.JobKindDerivedAssets, "new.jpg"); err != nil {
+		t.Fatalf("Enqueue() error = %v", err)
+	}
+	select {
+	case objectID := <-handled:
+		if objectID != "new.jpg" {
+			t.Errorf("ran %s, want new.jpg", objectID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for new job")
+	}
+
+	cancel()
+	<-done
+
+	if stored := getTestJob(t, db, interrupted.ID); stored.Status != database.JobStatusSucceeded || stored.Attempts != 2 {
+		t.Errorf("got status %q, attempts %d, want succeeded, 2", stored.Status, stored.Attempts)
+	}
+}
+
+// createTestJobs creates a job of user 1 for each status, in order, and one
+// of user 2.
+func createTestJobs(t *testing.T, db *gorm.DB) []database.Job {
+	t.Helper()
+	var jobs []database.Job
+	for _, jobStatus := range []string{database.JobStatusSucceeded, database.JobStatusFailed, database.JobStatusPending} {
+		jobs = append(jobs, database.Job{
+			Kind:        database.JobKindDerivedAssets,
+			ObjectID:    jobStatus + ".jpg",
+			UserID:      1,
+			Status:      jobStatus,
+			Attempts:    jobMaxAttempts,
+			MaxAttempts: jobMaxAttempts,
+			RunAfter:    time.Now(),
+			LastError:   "error of " + jobStatus,
+		})
+	}
+	jobs = append(jobs, database.Job{Kind: database.JobKindTranscode, ObjectID: "other.mov", UserID: 2, Status: database.JobStatusFailed, MaxAttempts: jobMaxAttempts, RunAfter: time.Now()})
+	if err := db.Create(&jobs).Error; err != nil {
+		t.Fatalf("failed to create jobs: %v", err)
+	}
+	return jobs
+}
+
+func jobObjectIDs(jobs []*proto.Job) []string {
+	var objectIDs []string
+	for _, job := range jobs {
+		objectIDs = append(objectIDs, job.GetObjectId())
+	}
+	return objectIDs
+}
+
+func TestListJobs(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	createTestJobs(t, db)
+	server := &LibraryServer{DB: db}
+	ctx := contextWithUserID(1)
+
+	resp, err := server.ListJobs(ctx, &proto.ListJobsRequest{})
+	if err != nil {
+		t.Fatalf("ListJobs() error = %v", err)
+	}
+	if got := jobObjectIDs(resp.GetJobs()); len(got) != 3 || got[0] != "pending.jpg" || got[2] != "succeeded.jpg" {
+		t.Errorf("got jobs %v, want newest first of user 1", got)
+	}
+
+	resp, err = server.ListJobs(ctx, &proto.ListJobsRequest{Status: database.JobStatusFailed})
+	if err != nil {
+		t.Fatalf("ListJobs() error = %v", err)
+	}
+	if got := jobObjectIDs(resp.GetJobs()); len(got) != 1 || got[0] != "failed.jpg" {
+		t.Errorf("got jobs %v, want [failed.jpg]", got)
+	}
+	if job := resp.GetJobs()[0]; job.GetLastError() != "error of failed" || job.GetAttempts() != jobMaxAttempts {
+		t.Errorf("got last error %q, attempts %d", job.GetLastError(), job.GetAttempts())
+	}
+
+	// Paging
+	var pages [][]string
+	pageToken := ""
+	for {
+		resp, err := server.ListJobs(ctx, &proto.ListJobsRequest{PageSize: 2, PageToken: pageToken})
+		if err != nil {
+			t.Fatalf("ListJobs() error = %v", err)
+		}
+		pages = append(pages, jobObjectIDs(resp.GetJobs()))
+		pageToken = resp.GetNextPageToken()
+		if pageToken == "" {
+			break
+		}
+	}
+	if len(pages) != 2 || len(pages[0]) != 2 || len(pages[1]) != 1 || pages[1][0] != "succeeded.jpg" {
+		t.Errorf("got pages %v", pages)
+	}
+
+	_, err = server.ListJobs(ctx, &proto.ListJobsRequest{Status: "stuck"})
+	assertGRPCError(t, err, codes.InvalidArgument)
+	_, err = server.ListJobs(ctx, &proto.ListJobsRequest{PageToken: "!"})
+	assertGRPCError(t, err, codes.InvalidArgument)
+	_, err = server.ListJobs(context.Background(), &proto.ListJobsRequest{})
+	assertGRPCError(t, err, codes.Unauthenticated)
+}
+
+func TestGetJob(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	jobs := createTestJobs(t, db)
+	server := &LibraryServer{DB: db}
+	ctx := contextWithUserID(1)
+
+	resp, err := server.GetJob(ctx, &proto.GetJobRequest{Id: uint64(jobs[1].ID)})
+	if err != nil {
+		t.Fatalf("GetJob() error = %v", err)
+	}
+	if job := resp.GetJob(); job.GetObjectId() != "failed.jpg" || job.GetStatus() != database.JobStatusFailed || job.GetKind() != database.JobKindDerivedAssets {
+		t.Errorf("got job %v", job)
+	}
+
+	_, err = server.GetJob(ctx, &proto.GetJobRequest{Id: uint64(jobs[3].ID)})
+	assertGRPCError(t, err, codes.NotFound)
+	_, err = server.GetJob(ctx, &proto.GetJobRequest{})
+	assertGRPCError(t, err, codes.InvalidArgument)
+	_, err = server.GetJob(context.Background(), &proto.GetJobRequest{Id: uint64(jobs[1].ID)})
+	assertGRPCError(t, err, codes.Unauthenticated)
+}
+
+func TestRetryJob(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	jobs := createTestJobs(t, db)
+	server := &LibraryServer{DB: db, Jobs: NewJobQueue(db, 1)}
+	ctx := contextWithUserID(1)
+
+	resp, err := server.RetryJob(ctx, &proto.RetryJobRequest{Id: uint64(jobs[1].ID)})
+	if err != nil {
+		t.Fatalf("RetryJob() error = %v", err)
+	}
+	if job := resp.GetJob(); job.GetStatus() != database.JobStatusPending || job.GetAttempts() != 0 {
+		t.Errorf("got status %q, attempts %d, want pending, 0", job.GetStatus(), job.GetAttempts())
+	}
+	stored := getTestJob(t, db, jobs[1].ID)
+	if stored.Status != database.JobStatusPending || stored.Attempts != 0 || stored.RunAfter.After(time.Now()) {
+		t.Errorf("got status %q, attempts %d, run after %v, want pending, 0, now", stored.Status, stored.Attempts, stored.RunAfter)
+	}
+	// The error of the failed attempt is kept until the job runs again
+	if stored.LastError != "error of failed" {
+		t.Errorf("got last error %q", stored.LastError)
+	}
+
+	for _, job := range []database.Job{jobs[0], jobs[1], jobs[2]} {
+		_, err = server.RetryJob(ctx, &proto.RetryJobRequest{Id: uint64(job.ID)})
+		assertGRPCError(t, err, codes.FailedPrecondition)
+	}
+	_, err = server.RetryJob(ctx, &proto.RetryJobRequest{Id: uint64(jobs[3].ID)})
+	assertGRPCError(t, err, codes.NotFound)
+	_, err = server.RetryJob(context.Background(), &proto.RetryJobRequest{Id: uint64(jobs[1].ID)})
+	assertGRPCError(t, err, codes.Unauthenticated)
+}
@@ -4,9 +4,11 @@ import (
 	"cmp"
 	"context"
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"os/exec"
 	"slices"
 	"strconv"
 	"strings"
@@ -29,6 +31,36 @@ type LibraryServer struct {
 	GCSClient   *storage.Client
 	BucketName  string
 	WebPQuality int
+	// AVIFQuality is the quality of the AVIF renditions UpdateAvif
+	// generates; zero disables them
+	AVIFQuality int
+	// ThumbnailSizes are the long edges of the thumbnails SyncDatabase
+	// generates; empty disables generation
+	ThumbnailSizes []int
+	// Transcoder transcodes videos for TranscodeVideo; nil disables it
+	Transcoder *Transcoder
+	// Jobs runs the jobs retried by RetryJob; nil leaves them queued until
+	// a job queue runs
+	Jobs *JobQueue
+	// Schedules are the maintenance tasks RunSchedules runs
+	Schedules []Schedule
+	// Concurrency is the number of objects the update_metadata phase of
+	// SyncDatabase and UpdateWebp process at once; less than one processes
+	// them one at a time
+	Concurrency int
+	// ObjectTimeout is how long each of those objects may take; zero does
+	// not limit it
+	ObjectTimeout time.Duration
+	// Throttle limits the rate of their downloads and tool runs; nil does
+	// not limit them
+	Throttle *Throttle
+	// Capabilities are the external tools found at startup; nil assumes
+	// they are all installed
+	Capabilities *Capabilities
+
+	// operations are the SyncDatabase, UpdateWebp and UpdateAvif calls
+	// running in this process
+	operations operationRegistry
 }
 
 // ListDirectories lists virtual directories (common prefixes) stored in the database.
@@ -149,6 +181,21 @@ func (s *LibraryServer) GetPhoto(ctx context.Context, req *proto.GetPhotoRequest
 		webpObjectID = *photoObject.WebpObjectID
 	}
 
+	// Get avif object ID if available
+	var avifObjectID string
+	if photoObject.AvifObjectID != nil {
+		avifObjectID = *photoObject.AvifObjectID
+	}
+
+	// Get video transcode object IDs if available
+	var proxyObjectID, hlsObjectID string
+	if photoObject.ProxyObjectID != nil {
+		proxyObjectID = *photoObject.ProxyObjectID
+	}
+	if photoObject.HLSObjectID != nil {
+		hlsObjectID = *photoObject.HLSObjectID
+	}
+
 	photo := &proto.Photo{
 		ObjectId:          photoObject.ObjectID,
 		Filename:          photoObject.ObjectID,
@@ -177,7 +224,35 @@ func (s *LibraryServer) GetPhoto(ctx context.Context, req *proto.GetPhotoRequest
 		IsVideo:           isVideo,
 		ThumbnailObjectId: thumbnailObjectID,
 		WebpObjectId:      webpObjectID,
+		AvifObjectId:      avifObjectID,
+		ProxyObjectId:     proxyObjectID,
+		HlsObjectId:       hlsObjectID,
 	}
+	if photoObject.AnimatedPreviewObjectID != nil {
+		photo.AnimatedPreviewObjectId = *photoObject.AnimatedPreviewObjectID
+	}
+	photo.CompanionObjectId = companionObjectID(&photoObject)
+	applySidecar(photo, getPhotoSidecar(s.DB, userID, objectID))
+
+	if photoObject.StackID != nil {
+		_, stackSpan := startSpan(ctx, "db.list_photo_stacks")
+		stacks, err := getPhotoStacks(s.DB, userID, []database.PhotoObject{photoObject})
+		if err != nil {
+			recordSpanError(stackSpan, err)
+			return nil, status.Errorf(codes.Internal, "failed to get photo stack: %v", err)
+		}
+		endSpanOk(stackSpan)
+		photo.Stack = stacks[*photoObject.StackID]
+	}
+
+	_, renditionSpan := startSpan(ctx, "db.list_photo_renditions")
+	renditions, err := getPhotoRenditions(s.DB, userID, objectID)
+	if err != nil {
+		recordSpanError(renditionSpan, err)
+		return nil, status.Errorf(codes.Internal, "failed to list photo renditions: %v", err)
+	}
+	endSpanOk(renditionSpan)
+	photo.Renditions = renditionsToProto(renditions)
 
 	slog.InfoContext(
 		ctx,
@@ -275,6 +350,13 @@ func (s *LibraryServer) CopyPhoto(ctx context.Context, req *proto.CopyPhotoReque
 		return nil, status.Errorf(codes.AlreadyExists, "destination photo already exists: %s", destObjectID)
 	}
 
+	// A copy is a new original and counts towards the quota, as do the Live
+	// Photo video and sidecar copied with it
+	copiedObjects, copiedBytes := copiedUsage(s.DB, userID, &sourcePhoto)
+	if err := checkQuota(ctx, s.DB, userID, destObjectID, copiedObjects, copiedBytes, false); err != nil {
+		return nil, err
+	}
+
 	// Copy the object in GCS
 	bucket := s.GCSClient.Bucket(s.BucketName)
 	srcObj := bucket.Object(sourceObjectID)
@@ -300,11 +382,19 @@ func (s *LibraryServer) CopyPhoto(ctx context.Context, req *proto.CopyPhotoReque
 		ObjectID:    destObjectID,
 		ContentType: attrs.ContentType,
 		MD5Hash:     md5HashBase64,
+		SizeBytes:   attrs.Size,
 		UserID:      userID,
 	}
+	// Keep what photos are stacked and Live Photos paired by
+	destPhoto.TimeTaken = sourcePhoto.TimeTaken
+	destPhoto.Camera = sourcePhoto.Camera
+	destPhoto.ContentIdentifier = sourcePhoto.ContentIdentifier
+	destPhoto.DurationSeconds = sourcePhoto.DurationSeconds
 
 	_, createSpan := startSpan(ctx, "db.create_or_restore_photo_object")
-	if err := database.CreateOrRestorePhotoObject(s.DB, destPhoto); err != nil {
+	if err := withPhotoChange(s.DB, userID, database.ChangeKindCreated, destObjectID, func(tx *gorm.DB) error {
+		return database.CreateOrRestorePhotoObject(tx, destPhoto)
+	}); err != nil {
 		recordSpanError(createSpan, err)
 		// Try to clean up the GCS object if database insert fails
 		_, delSpan := startSpan(ctx, "gcs.delete_object")
@@ -313,6 +403,7 @@ func (s *LibraryServer) CopyPhoto(ctx context.Context, req *proto.CopyPhotoReque
 		return nil, status.Errorf(codes.Internal, "failed to create photo record: %v", err)
 	}
 	endSpanOk(createSpan)
+	forgetReplacedDerivedObject(ctx, s.DB, userID, destObjectID)
 
 	// Create directory entry if applicable (create or restore if soft-deleted)
 	dir := ExtractDirectoryFromPath(destObjectID)
@@ -330,6 +421,19 @@ func (s *LibraryServer) CopyPhoto(ctx context.Context, req *proto.CopyPhotoReque
 		endSpanOk(dirSpan)
 	}
 
+	// Copy the XMP sidecar, if any, alongside the photo
+	copySidecar(ctx, s.DB, bucket, userID, sourceObjectID, destObjectID, false)
+
+	// Copy the video of a Live Photo, if any, alongside the photo
+	copyCompanion(ctx, s.DB, bucket, userID, &sourcePhoto, destObjectID, false)
+
+	// Copy the derived assets and thumbnails alongside the photo
+	copyDerivedObjects(ctx, s.DB, bucket, userID, sourceObjectID, destObjectID, false)
+	copyRenditions(ctx, s.DB, bucket, userID, sourceObjectID, destObjectID, false)
+
+	// Stack the copy with the photos of its directory
+	restackPhoto(ctx, s.DB, userID, destObjectID)
+
 	slog.InfoContext(
 		ctx,
 		"Copied photo",
@@ -426,11 +530,19 @@ func (s *LibraryServer) RenamePhoto(ctx context.Context, req *proto.RenamePhotoR
 		ObjectID:    destObjectID,
 		ContentType: attrs.ContentType,
 		MD5Hash:     md5HashBase64,
+		SizeBytes:   attrs.Size,
 		UserID:      userID,
 	}
+	// Keep what photos are stacked and Live Photos paired by
+	destPhoto.TimeTaken = sourcePhoto.TimeTaken
+	destPhoto.Camera = sourcePhoto.Camera
+	destPhoto.ContentIdentifier = sourcePhoto.ContentIdentifier
+	destPhoto.DurationSeconds = sourcePhoto.DurationSeconds
 
 	_, createSpan := startSpan(ctx, "db.create_or_restore_photo_object")
-	if err := database.CreateOrRestorePhotoObject(s.DB, destPhoto); err != nil {
+	if err := withPhotoChange(s.DB, userID, database.ChangeKindCreated, destObjectID, func(tx *gorm.DB) error {
+		return database.CreateOrRestorePhotoObject(tx, destPhoto)
+	}); err != nil {
 		recordSpanError(createSpan, err)
 		// Try to clean up the GCS object if database insert fails
 		_, delSpan := startSpan(ctx, "gcs.delete_object")
@@ -439,6 +551,7 @@ func (s *LibraryServer) RenamePhoto(ctx context.Context, req *proto.RenamePhotoR
 		return nil, status.Errorf(codes.Internal, "failed to create photo record: %v", err)
 	}
 	endSpanOk(createSpan)
+	forgetReplacedDerivedObject(ctx, s.DB, userID, destObjectID)
 
 	// Create directory entry for destination if applicable (create or restore if soft-deleted)
 	destDir := ExtractDirectoryFromPath(destObjectID)
@@ -456,6 +569,18 @@ func (s *LibraryServer) RenamePhoto(ctx context.Context, req *proto.RenamePhotoR
 		endSpanOk(dirSpan)
 	}
 
+	// Move the XMP sidecar, if any, alongside the photo
+	copySidecar(ctx, s.DB, bucket, userID, sourceObjectID, destObjectID, true)
+
+	// Move the video of a Live Photo, if any, alongside the photo, and keep
+	// a renamed Live Photo video paired with its still
+	copyCompanion(ctx, s.DB, bucket, userID, &sourcePhoto, destObjectID, true)
+	relinkCompanion(ctx, s.DB, userID, sourceObjectID, &destObjectID)
+
+	// Move the derived assets and thumbnails alongside the photo
+	copyDerivedObjects(ctx, s.DB, bucket, userID, sourceObjectID, destObjectID, true)
+	copyRenditions(ctx, s.DB, bucket, userID, sourceObjectID, destObjectID, true)
+
 	// Delete the source object from GCS
 	_, srcDelSpan := startSpan(ctx, "gcs.delete_object")
 	if err := srcObj.Delete(ctx); err != nil {
@@ -473,12 +598,19 @@ func (s *LibraryServer) RenamePhoto(ctx context.Context, req *proto.RenamePhotoR
 
 	// Delete the source database record
 	_, srcDbDelSpan := startSpan(ctx, "db.delete_source_photo")
-	if err := s.DB.Delete(&sourcePhoto).Error; err != nil {
+	if err := withPhotoChange(s.DB, userID, database.ChangeKindDeleted, sourceObjectID, func(tx *gorm.DB) error {
+		return tx.Delete(&sourcePhoto).Error
+	}); err != nil {
 		recordSpanError(srcDbDelSpan, err)
 		return nil, status.Errorf(codes.Internal, "failed to delete source photo from database: %v", err)
 	}
 	endSpanOk(srcDbDelSpan)
 
+	// Stack the photo with those of its new directory, and those it leaves
+	// behind without it
+	restackPhoto(ctx, s.DB, userID, destObjectID)
+	restackPhoto(ctx, s.DB, userID, sourceObjectID)
+
 	// Check if the source directory is now empty and clean up
 	sourceDir := ExtractDirectoryFromPath(sourceObjectID)
 	if sourceDir != "" {
@@ -667,6 +799,12 @@ func (s *LibraryServer) ListPhotos(ctx context.Context, req *proto.ListPhotosReq
 	// Exclude markdown files
 	query = query.Where("object_id NOT LIKE ?", "%.md")
 
+	// Exclude Live Photo videos, which are shown with their still
+	query = query.Where("object_id NOT IN (?)", companionsQuery(s.DB, userID))
+
+	// List the cover of each stack in place of its photos
+	query = query.Where("(stack_id IS NULL OR object_id IN (?))", stackCoversQuery(s.DB, userID))
+
 	// Count total matching items (before pagination)
 	var totalCount int64
 	_, countSpan := startSpan(ctx, "db.count_photos")
@@ -745,6 +883,34 @@ func (s *LibraryServer) ListPhotos(ctx context.Context, req *proto.ListPhotosReq
 	}
 	endSpanOk(listSpan)
 
+	pageObjectIDs := make([]string, 0, len(photoObjects))
+	for _, obj := range photoObjects {
+		pageObjectIDs = append(pageObjectIDs, obj.ObjectID)
+	}
+	_, sidecarSpan := startSpan(ctx, "db.list_photo_sidecars")
+	sidecars, err := getPhotoSidecars(s.DB, userID, pageObjectIDs)
+	if err != nil {
+		recordSpanError(sidecarSpan, err)
+		return nil, status.Errorf(codes.Internal, "failed to list photo sidecars: %v", err)
+	}
+	endSpanOk(sidecarSpan)
+
+	_, renditionSpan := startSpan(ctx, "db.list_photo_renditions")
+	renditions, err := getPhotosRenditions(s.DB, userID, pageObjectIDs)
+	if err != nil {
+		recordSpanError(renditionSpan, err)
+		return nil, status.Errorf(codes.Internal, "failed to list photo renditions: %v", err)
+	}
+	endSpanOk(renditionSpan)
+
+	_, stackSpan := startSpan(ctx, "db.list_photo_stacks")
+	stacks, err := getPhotoStacks(s.DB, userID, photoObjects)
+	if err != nil {
+		recordSpanError(stackSpan, err)
+		return nil, status.Errorf(codes.Internal, "failed to list photo stacks: %v", err)
+	}
+	endSpanOk(stackSpan)
+
 	var photos []*proto.Photo
 	var lastPhoto *database.PhotoObject
 	count := int32(0)
@@ -759,42 +925,11 @@ func (s *LibraryServer) ListPhotos(ctx context.Context, req *proto.ListPhotosReq
 
 		lastPhoto = obj
 
-		// Determine if this is a video
-		isVideo := IsVideoContentType(obj.ContentType)
-
-		// Get thumbnail object ID if available
-		var thumbnailObjectID string
-		if obj.ThumbnailObjectID != nil {
-			thumbnailObjectID = *obj.ThumbnailObjectID
-		}
-
-		// Get webp object ID if available
-		var webpObjectID string
-		if obj.WebpObjectID != nil {
-			webpObjectID = *obj.WebpObjectID
-		}
-
-		// Get duration if available
-		var durationSeconds float64
-		if obj.DurationSeconds != nil {
-			durationSeconds = *obj.DurationSeconds
-		}
-
-		photo := &proto.Photo{
-			ObjectId:          obj.ObjectID,
-			Filename:          obj.ObjectID,
-			ContentType:       obj.ContentType,
-			Md5Hash:           obj.MD5Hash,
-			CreatedAt:         obj.CreatedAt.Format(time.RFC3339),
-			UpdatedAt:         obj.UpdatedAt.Format(time.RFC3339),
-			IsVideo:           isVideo,
-			ThumbnailObjectId: thumbnailObjectID,
-			WebpObjectId:      webpObjectID,
-			DurationSeconds:   durationSeconds,
-		}
-		if obj.TimeTaken != nil {
-			photo.DateTaken = obj.TimeTaken.Format(time.RFC3339)
-			photo.HasDateTaken = true
+		photo := listedPhoto(obj)
+		applySidecar(photo, sidecars[obj.ObjectID])
+		photo.Renditions = renditionsToProto(renditions[obj.ObjectID])
+		if obj.StackID != nil {
+			photo.Stack = stacks[*obj.StackID]
 		}
 
 		photos = append(photos, photo)
@@ -828,6 +963,71 @@ func (s *LibraryServer) ListPhotos(ctx context.Context, req *proto.ListPhotosReq
 	}, nil
 }
 
+// listedPhoto returns the Photo of a listing for obj, from its database
+// record alone.
+func listedPhoto(obj *database.PhotoObject) *proto.Photo {
+	// Determine if this is a video
+	isVideo := IsVideoContentType(obj.ContentType)
+
+	// Get thumbnail object ID if available
+	var thumbnailObjectID string
+	if obj.ThumbnailObjectID != nil {
+		thumbnailObjectID = *obj.ThumbnailObjectID
+	}
+
+	// Get webp object ID if available
+	var webpObjectID string
+	if obj.WebpObjectID != nil {
+		webpObjectID = *obj.WebpObjectID
+	}
+
+	// Get avif object ID if available
+	var avifObjectID string
+	if obj.AvifObjectID != nil {
+		avifObjectID = *obj.AvifObjectID
+	}
+
+	// Get video transcode object IDs if available
+	var proxyObjectID, hlsObjectID string
+	if obj.ProxyObjectID != nil {
+		proxyObjectID = *obj.ProxyObjectID
+	}
+	if obj.HLSObjectID != nil {
+		hlsObjectID = *obj.HLSObjectID
+	}
+
+	// Get duration if available
+	var durationSeconds float64
+	if obj.DurationSeconds != nil {
+		durationSeconds = *obj.DurationSeconds
+	}
+
+	photo := &proto.Photo{
+		ObjectId:          obj.ObjectID,
+		Filename:          obj.ObjectID,
+		ContentType:       obj.ContentType,
+		Md5Hash:           obj.MD5Hash,
+		CreatedAt:         obj.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:         obj.UpdatedAt.Format(time.RFC3339),
+		IsVideo:           isVideo,
+		ThumbnailObjectId: thumbnailObjectID,
+		WebpObjectId:      webpObjectID,
+		AvifObjectId:      avifObjectID,
+		ProxyObjectId:     proxyObjectID,
+		HlsObjectId:       hlsObjectID,
+		DurationSeconds:   durationSeconds,
+	}
+	if obj.AnimatedPreviewObjectID != nil {
+		photo.AnimatedPreviewObjectId = *obj.AnimatedPreviewObjectID
+	}
+	photo.CompanionObjectId = companionObjectID(obj)
+	if obj.TimeTaken != nil {
+		photo.DateTaken = obj.TimeTaken.Format(time.RFC3339)
+		photo.HasDateTaken = true
+	}
+	return photo
+}
+
 // DeletePhoto deletes a photo from Google Cloud Storage and the database.
 func (s *LibraryServer) DeletePhoto(ctx context.Context, req *proto.DeletePhotoRequest) (*proto.DeletePhotoResponse, error) {
 	userID, ok := ctx.Value(contextKeyUser{}).(uint)
@@ -871,14 +1071,32 @@ func (s *LibraryServer) DeletePhoto(ctx context.Context, req *proto.DeletePhotoR
 	}
 	endSpanOk(gcsDelSpan)
 
+	// Delete the XMP sidecar, if any, together with the photo
+	if sidecar := getPhotoSidecar(s.DB, userID, objectID); sidecar != nil {
+		deleteSidecar(ctx, s.DB, bucket, sidecar)
+	}
+
+	// Delete the derived assets and thumbnails together with the photo
+	deleteDerivedObjects(ctx, s.DB, bucket, userID, objectID)
+
+	// Delete the video of a Live Photo, if any, together with the photo, and
+	// unpair the still of a deleted Live Photo video
+	deleteCompanion(ctx, s.DB, bucket, &photoObject)
+	relinkCompanion(ctx, s.DB, userID, objectID, nil)
+
 	// Delete from database
 	_, dbDelSpan := startSpan(ctx, "db.delete_photo")
-	if err := s.DB.Delete(&photoObject).Error; err != nil {
+	if err := withPhotoChange(s.DB, userID, database.ChangeKindDeleted, objectID, func(tx *gorm.DB) error {
+		return tx.Delete(&photoObject).Error
+	}); err != nil {
 		recordSpanError(dbDelSpan, err)
 		return nil, status.Errorf(codes.Internal, "failed to delete photo from database: %v", err)
 	}
 	endSpanOk(dbDelSpan)
 
+	// Stack the photos left in the directory without it
+	restackPhoto(ctx, s.DB, userID, objectID)
+
 	// Check if it is the last file in the directory, if so delete the directory as well
 	directoryPath := ExtractDirectoryFromPath(objectID)
 	if directoryPath != "" {
@@ -926,8 +1144,24 @@ func (s *LibraryServer) DeletePhoto(ctx context.Context, req *proto.DeletePhotoR
 }
 
 // SyncDatabase syncs the photo database with the storage backend.
-// Derived assets (.webp, _preview.jpg, _thumb.jpg) are excluded from all
-// insertion logic. The sync proceeds in three phases:
+// Derived assets (WebP renditions, RAW previews, video thumbnails and
+// fixed-size thumbnails) are excluded from all insertion logic. They are
+// identified by their DerivedObject record or, for objects not yet recorded
+// such as after the database has been rebuilt, by the derived_from marker in
+// their GCS metadata, which is then recorded; never by filename, so a WebP
+// uploaded by the user is synced like any other photo. XMP sidecars (.xmp)
+// are tracked as PhotoSidecar rows rather than as photos.
+//
+// A full sync examines every object in the bucket and every photo of the
+// user. Otherwise only the objects changed since the cursor left by the last
+// sync (see SyncCursor) are examined, with the photos whose object is no
+// longer in the bucket, found by comparing a listing of object names with
+// the photos; the phases below then apply to those objects and photos only.
+// The first sync of a user is always full, and the cursor is kept before
+// any object that could not be synced, so that the next sync examines it
+// again. A dry run reports the changes a
+// sync would make without making them (see planSyncDatabase). The sync
+// proceeds in six phases:
 //
 //  1. Add missing objects: any GCS object not already in the database (and not a
 //     derived asset) is inserted as a new PhotoObject. Content type, MD5 hash,
@@ -937,43 +1171,94 @@ func (s *LibraryServer) DeletePhoto(ctx context.Context, req *proto.DeletePhotoR
 //
 //  2. Remove stale objects: any PhotoObject in the database whose ObjectID no
 //     longer exists in GCS is deleted. Additionally, any PhotoObject whose
-//     ObjectID is a derived asset is deleted regardless of GCS state. In both
-//     cases, if the deletion leaves the parent directory empty the corresponding
-//     PhotoDirectory is also deleted.
+//     ObjectID is a recorded derived asset is deleted regardless of GCS state.
+//     In both cases, if the deletion leaves the parent directory empty the
+//     corresponding PhotoDirectory is also deleted.
+//
+//  3. Sidecars: new or changed XMP sidecars are parsed into PhotoSidecar rows
+//     and attached to the photo with the same basename; rows whose sidecar no
+//     longer exists in GCS are deleted.
 //
-//  3. Metadata refresh (update_metadata only): for every GCS object the file is
-//     downloaded, EXIF metadata is extracted, written back to GCS, and
-//     time_taken is updated in the database. DNG files without a JPEG preview
-//     have one generated and stored (thumbnail_object_id). Eligible images
-//     (JPEG, PNG, GIF, DNG-preview) without a WebP rendition have one generated
-//     and stored (webp_object_id). Derived assets are skipped for WebP
-//     generation. This phase is expensive as it downloads every object.
+//  4. Metadata refresh (update_metadata only): for every GCS object examined
+//     the file is downloaded, EXIF metadata is extracted, written back to GCS
+//     if it has changed, and time_taken is updated in the database. RAW and HEIC files without a
+//     JPEG preview have one generated and stored (thumbnail_object_id).
+//     Eligible images (JPEG, PNG, GIF, RAW and HEIC previews) without a WebP
+//     rendition have one generated and stored (webp_object_id). Derived
+//     assets are skipped for WebP generation. This phase is expensive in a
+//     full sync as it downloads every object, so objects are refreshed
+//     Concurrency at a time, each within ObjectTimeout, with downloads and
+//     tool runs limited by Throttle. Live Photo stills and videos are then paired
+//     (see syncLivePhotos), and RAW and JPEG pairs and bursts are stacked
+//     (see syncStacks), whether or not metadata is refreshed.
+//
+//  5. Posters: videos without a poster frame or animated preview have them
+//     generated (see storeVideoPreviews) if ffmpeg is installed.
+//
+//  6. Renditions: photos missing one of the configured fixed-size thumbnails
+//     (ThumbnailSizes) are downloaded and have the missing sizes generated
+//     and recorded as PhotoRendition rows; RAW and HEIC files are rendered
+//     from their JPEG preview and videos from their poster frame. Renditions
+//     whose photo no longer exists are deleted.
+//
+// Only one of SyncDatabase, UpdateWebp and UpdateAvif runs at a time for a
+// user (see runExclusive); dry runs are not limited.
 func (s *LibraryServer) SyncDatabase(req *proto.SyncDatabaseRequest, stream grpc.ServerStreamingServer[proto.SyncDatabaseProgress]) error {
 	ctx := stream.Context()
 	userID, ok := ctx.Value(contextKeyUser{}).(uint)
 	if !ok {
 		return status.Errorf(codes.Unauthenticated, "authentication required")
 	}
+	if req.GetDryRun() {
+		return s.planSyncDatabase(userID, req, stream)
+	}
+
+	return runExclusive(s, stream, userID, OperationSyncDatabase, func(stream grpc.ServerStreamingServer[proto.SyncDatabaseProgress]) error {
+		return s.syncDatabase(userID, req, stream)
+	})
+}
+
+// syncDatabase implements SyncDatabase while holding the user's operation lock.
+func (s *LibraryServer) syncDatabase(userID uint, req *proto.SyncDatabaseRequest, stream grpc.ServerStreamingServer[proto.SyncDatabaseProgress]) error {
+	ctx := stream.Context()
 
 	updateMetadata := req.GetUpdateMetadata()
 
-	// Get all objects from GCS
-	_, gcsListSpan := startSpan(ctx, "gcs.list_objects")
-	gcsObjects, err := getGCSNonDerivedObjectsMap(ctx, s.GCSClient, s.BucketName)
+	// Get the derived assets recorded so far
+	_, derivedListSpan := startSpan(ctx, "db.list_derived_objects")
+	derived, err := loadDerivedObjectSet(s.DB)
 	if err != nil {
-		recordSpanError(gcsListSpan, err)
-		return status.Errorf(codes.Internal, "failed to list GCS objects: %v", err)
+		recordSpanError(derivedListSpan, err)
+		return status.Errorf(codes.Internal, "failed to list derived objects: %v", err)
 	}
-	endSpanOk(gcsListSpan)
+	endSpanOk(derivedListSpan)
 
-	// Get all objects from database for this user
-	var dbObjects []database.PhotoObject
-	_, dbListSpan := startSpan(ctx, "db.list_photo_objects")
-	if err := s.DB.Where("user_id = ?", userID).Find(&dbObjects).Error; err != nil {
-		recordSpanError(dbListSpan, err)
-		return status.Errorf(codes.Internal, "failed to list database objects: %v", err)
+	// Examine every object on the first sync of the user or when asked to,
+	// and otherwise only those changed since the last sync
+	cursor, err := loadSyncCursor(ctx, s.DB, userID)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to get sync cursor: %v", err)
 	}
-	endSpanOk(dbListSpan)
+	full := req.GetFull() || cursor == nil
+	var examined *syncObjects
+	if full {
+		examined, err = s.listAllSyncObjects(ctx, userID, derived)
+	} else {
+		examined, err = s.listChangedSyncObjects(ctx, userID, derived, *cursor)
+	}
+	if err != nil {
+		return status.Errorf(codes.Internal, "%v", err)
+	}
+	gcsObjects := examined.objects
+	dbObjects := examined.photos
+
+	// Derived assets only known from their GCS metadata marker, such as after
+	// the database has been rebuilt, are recorded so that they are never
+	// tracked as photos
+	recordMarkedDerivedObjects(ctx, s.DB, userID, examined.derivedObjects, derived)
+
+	// XMP sidecars are attached to photos rather than tracked as photos
+	sidecarObjects := splitSidecarObjects(gcsObjects)
 
 	// Create a map of database objects for quick lookup
 	dbObjectMap := make(map[string]database.PhotoObject)
@@ -997,7 +1282,9 @@ func (s *LibraryServer) SyncDatabase(req *proto.SyncDatabaseRequest, stream grpc
 	var processedAdd uint32
 	for objectID, attrs := range gcsObjects {
 		processedAdd++
-		if _, exists := dbObjectMap[objectID]; !exists {
+		existing, exists := dbObjectMap[objectID]
+		switch action, _ := syncPhotoAction(attrs, existing, exists); action {
+		case proto.SyncChange_ACTION_ADD:
 			md5Hash := ""
 			if len(attrs.MD5) > 0 {
 				md5Hash = base64.StdEncoding.EncodeToString(attrs.MD5)
@@ -1014,13 +1301,18 @@ func (s *LibraryServer) SyncDatabase(req *proto.SyncDatabaseRequest, stream grpc
 				ObjectID:    objectID,
 				ContentType: attrs.ContentType,
 				MD5Hash:     md5Hash,
+				SizeBytes:   attrs.Size,
 				UserID:      userID,
 				TimeTaken:   syncTimeTaken,
 			}
+			photoObject.ContentIdentifier = photoMetadata.ContentIdentifier
+			photoObject.Camera = cameraName(photoMetadata.CameraMake, photoMetadata.CameraModel)
 
 			// Create or restore photo object if soft-deleted
 			_, createSpan := startSpan(ctx, "db.create_or_restore_photo_object")
-			if err := database.CreateOrRestorePhotoObject(s.DB, photoObject); err != nil {
+			if err := withPhotoChange(s.DB, userID, database.ChangeKindCreated, objectID, func(tx *gorm.DB) error {
+				return database.CreateOrRestorePhotoObject(tx, photoObject)
+			}); err != nil {
 				recordSpanError(createSpan, err)
 				slog.WarnContext(
 					ctx,
@@ -1028,6 +1320,7 @@ func (s *LibraryServer) SyncDatabase(req *proto.SyncDatabaseRequest, stream grpc
 					slog.String("object_id", objectID),
 					slog.String("error", err.Error()),
 				)
+				examined.fail(attrs)
 				continue
 			}
 			endSpanOk(createSpan)
@@ -1049,6 +1342,22 @@ func (s *LibraryServer) SyncDatabase(req *proto.SyncDatabaseRequest, stream grpc
 			}
 
 			added++
+		case proto.SyncChange_ACTION_UPDATE_METADATA:
+			_, sizeSpan := startSpan(ctx, "db.update_size_bytes")
+			if err := withPhotoChange(s.DB, userID, database.ChangeKindUpdated, objectID, func(tx *gorm.DB) error {
+				return tx.Model(&existing).Update("size_bytes", attrs.Size).Error
+			}); err != nil {
+				recordSpanError(sizeSpan, err)
+				slog.WarnContext(
+					ctx,
+					"failed to update photo size during sync",
+					slog.String("object_id", objectID),
+					slog.String("error", err.Error()),
+				)
+				examined.fail(attrs)
+			} else {
+				endSpanOk(sizeSpan)
+			}
 		}
 
 		if err := stream.Send(&proto.SyncDatabaseProgress{
@@ -1060,14 +1369,18 @@ func (s *LibraryServer) SyncDatabase(req *proto.SyncDatabaseRequest, stream grpc
 		}
 	}
 
-	// Remove objects that exist in DB but not in GCS
+	// Remove objects that exist in DB but not in GCS, and recorded derived
+	// assets (WebP renditions, RAW previews, video thumbnails) that should
+	// not be tracked as first-class photos
 	totalRemove := uint32(len(dbObjectMap))
 	var processedRemove uint32
 	for objectID, photoObject := range dbObjectMap {
 		processedRemove++
-		if _, exists := gcsObjects[objectID]; !exists {
+		if syncRemoveReason(objectID, examined.exists, derived) != "" {
 			_, delSpan := startSpan(ctx, "db.delete_photo")
-			if err := s.DB.Delete(&photoObject).Error; err != nil {
+			if err := withPhotoChange(s.DB, userID, database.ChangeKindDeleted, objectID, func(tx *gorm.DB) error {
+				return tx.Delete(&photoObject).Error
+			}); err != nil {
 				recordSpanError(delSpan, err)
 				slog.WarnContext(
 					ctx,
@@ -1075,6 +1388,9 @@ func (s *LibraryServer) SyncDatabase(req *proto.SyncDatabaseRequest, stream grpc
 					slog.String("object_id", objectID),
 					slog.String("error", err.Error()),
 				)
+				if attrs, ok := examined.derivedObjects[objectID]; ok {
+					examined.fail(attrs)
+				}
 				continue
 			}
 			endSpanOk(delSpan)
@@ -1117,104 +1433,118 @@ func (s *LibraryServer) SyncDatabase(req *proto.SyncDatabaseRequest, stream grpc
 		}
 	}
 
-	// Remove any derived objects (WebP renditions, DNG previews, video thumbnails)
-	// that exist in the database but should not be tracked as first-class photos.
-	// These are reported under the PHASE_REMOVE phase as part of the same pass.
-	for objectID, photoObject := range dbObjectMap {
-		if !isDerivedObjectID(objectID) {
-			continue
-		}
-		_, delSpan := startSpan(ctx, "db.delete_derived_photo")
-		if err := s.DB.Delete(&photoObject).Error; err != nil {
-			recordSpanError(delSpan, err)
-			slog.WarnContext(
-				ctx,
-				"failed to delete derived photo object during sync",
-				slog.String("object_id", objectID),
-				slog.String("error", err.Error()),
-			)
-			continue
-		}
-		endSpanOk(delSpan)
+	// Attach XMP sidecars to their photos
+	sidecarsAdded, sidecarsRemoved, err := s.syncSidecars(ctx, userID, sidecarObjects, examined.exists, examined.fail, stream)
+	if err != nil {
+		return err
+	}
+	added += sidecarsAdded
+	removed += sidecarsRemoved
 
-		// Check if it's the last file in the directory
-		dir := ExtractDirectoryFromPath(objectID)
-		if dir != "" {
-			var count int64
-			_, countSpan := startSpan(ctx, "db.count_photos_in_directory")
-			if err := s.DB.Model(&database.PhotoObject{}).
-				Where("object_id LIKE ?", dir+"/%").
-				Count(&count).Error; err == nil && count == 0 {
-				endSpanOk(countSpan)
-				_, dirDelSpan := startSpan(ctx, "db.delete_directory")
-				if err := s.DB.Where("path = ?", dir).Delete(&database.PhotoDirectory{}).Error; err != nil {
-					recordSpanError(dirDelSpan, err)
+	// Update metadata for all objects if requested, Concurrency at a time;
+	// progress is reported in order of object ID
+	if updateMetadata {
+		pause := time.Duration(req.GetPauseBetweenObjectsSeconds()) * time.Second
+		objectIDs := sortedObjectIDs(gcsObjects)
+		type metadataResult struct {
+			updated bool
+			err     error
+		}
+		err := processInOrder(ctx, s.Concurrency, s.ObjectTimeout, len(objectIDs),
+			func(ctx context.Context, i int) metadataResult {
+				updated, err := s.updateObjectMetadata(ctx, objectIDs[i], gcsObjects[objectIDs[i]], userID)
+				if err == nil && updated && pause > 0 {
+					select {
+					case <-time.After(pause):
+					case <-ctx.Done():
+					}
+				}
+				return metadataResult{updated: updated, err: err}
+			},
+			func(i int, result metadataResult) error {
+				if result.err != nil {
 					slog.WarnContext(
 						ctx,
-						"failed to delete empty directory during sync",
-						slog.String("path", dir),
-						slog.String("error", err.Error()),
+						"failed to update metadata during sync",
+						slog.String("object_id", objectIDs[i]),
+						slog.String("error", result.err.Error()),
 					)
-				} else {
-					endSpanOk(dirDelSpan)
+					examined.fail(gcsObjects[objectIDs[i]])
+				} else if result.updated {
+					metadataUpdated++
 				}
-			} else {
-				endSpanOk(countSpan)
-			}
+
+				return stream.Send(&proto.SyncDatabaseProgress{
+					Phase:     proto.SyncDatabaseProgress_PHASE_METADATA,
+					Processed: uint32(i + 1),
+					Total:     uint32(len(objectIDs)),
+				})
+			},
+		)
+		if err != nil {
+			return err
 		}
+	}
 
-		removed++
+	// Pair Live Photo stills and videos after the metadata refresh, which
+	// records their identifiers
+	livePhotosPaired, err := s.syncLivePhotos(ctx, userID)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to pair Live Photos: %v", err)
 	}
 
-	// Update metadata for all objects if requested
-	if updateMetadata {
-		pause := time.Duration(req.GetPauseBetweenObjectsSeconds()) * time.Second
-		totalMetadata := uint32(len(gcsObjects))
-		var processedMetadata uint32
-		for objectID, attrs := range gcsObjects {
-			processedMetadata++
-			updated, err := s.updateObjectMetadata(ctx, objectID, attrs, userID)
-			if err != nil {
-				slog.WarnContext(
-					ctx,
-					"failed to update metadata during sync",
-					slog.String("object_id", objectID),
-					slog.String("error", err.Error()),
-				)
-			} else if updated {
-				metadataUpdated++
-				if pause > 0 {
-					time.Sleep(pause)
-				}
-			}
+	// Stack RAW and JPEG pairs and bursts, by the time taken and camera
+	// refreshed above
+	photoStacks, err := s.syncStacks(ctx, userID)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to stack photos: %v", err)
+	}
 
-			if err := stream.Send(&proto.SyncDatabaseProgress{
-				Phase:     proto.SyncDatabaseProgress_PHASE_METADATA,
-				Processed: processedMetadata,
-				Total:     totalMetadata,
-			}); err != nil {
-				return err
-			}
-		}
+	// Choose poster frames for videos without one, so that their thumbnails
+	// can be generated from them below
+	postersGenerated, err := s.syncVideoPreviews(ctx, userID, stream)
+	if err != nil {
+		return err
+	}
+
+	// Generate missing thumbnails after the metadata refresh, so that DNG and
+	// HEIC previews generated there are used
+	renditionsGenerated, renditionsRemoved, err := s.syncRenditions(ctx, userID, stream)
+	if err != nil {
+		return err
+	}
+	removed += renditionsRemoved
+
+	if err := saveSyncCursor(ctx, s.DB, userID, examined.position); err != nil {
+		return status.Errorf(codes.Internal, "failed to save sync cursor: %v", err)
 	}
 
 	slog.InfoContext(
 		ctx,
 		"Database sync completed",
+		slog.Bool("full", full),
 		slog.Int("added", added),
 		slog.Int("removed", removed),
 		slog.Int("metadata_updated", metadataUpdated),
-		slog.Int("total_gcs", len(gcsObjects)),
-		slog.Int("total_db_before", len(dbObjects)),
+		slog.Int("renditions_generated", renditionsGenerated),
+		slog.Int("posters_generated", postersGenerated),
+		slog.Int("live_photos_paired", livePhotosPaired),
+		slog.Int("photo_stacks", photoStacks),
+		slog.Int("total_gcs", len(examined.names)),
+		slog.Int("examined_gcs", len(gcsObjects)),
+		slog.Int("examined_db", len(dbObjects)),
 		slog.Uint64("user_id", uint64(userID)),
 	)
 
 	return stream.Send(&proto.SyncDatabaseProgress{
-		Phase:           proto.SyncDatabaseProgress_PHASE_UNSPECIFIED,
-		Added:           uint32(added),
-		Removed:         uint32(removed),
-		MetadataUpdated: uint32(metadataUpdated),
-		Complete:        true,
+		Phase:               proto.SyncDatabaseProgress_PHASE_UNSPECIFIED,
+		Added:               uint32(added),
+		Removed:             uint32(removed),
+		MetadataUpdated:     uint32(metadataUpdated),
+		RenditionsGenerated: uint32(renditionsGenerated),
+		PostersGenerated:    uint32(postersGenerated),
+		Complete:            true,
+		Full:                full,
 	})
 }
 
@@ -1224,20 +1554,26 @@ func (s *LibraryServer) SyncDatabase(req *proto.SyncDatabaseRequest, stream grpc
 //
 // Eligibility:
 //   - The row's webp_object_id is NULL or empty.
-//   - The row's object_id is not a derived asset (.webp, _preview.jpg,
-//     _thumb.jpg); derived assets are skipped to avoid producing artefacts of
-//     already-generated files.
+//   - The row's object_id is not a recorded derived asset (WebP rendition,
+//     RAW preview, video thumbnail or fixed-size thumbnail); derived assets
+//     are skipped to avoid producing artefacts of already-generated files.
+//     A WebP uploaded by the user is an original and is not skipped.
 //
 // For each eligible row the original object is downloaded from GCS and a WebP
 // rendition is generated and stored alongside the original; the new object ID
-// is persisted to webp_object_id. DNG files are handled via their JPEG preview
+// is persisted to webp_object_id. RAW and HEIC files are handled via their JPEG preview
 // (thumbnail_object_id): if a preview does not yet exist one is generated
 // first, then the WebP is derived from the preview. JPEG/PNG/GIF files use the
 // original object as the WebP source. All other content types are skipped.
 //
-// Per-object failures are logged and counted as failed; they do not abort the
-// run. Progress is streamed: one message per processed object plus a final
-// summary message with complete=true.
+// Objects are processed Concurrency at a time, each within ObjectTimeout,
+// with downloads and tool runs limited by Throttle. Per-object failures,
+// timeouts included, are logged and counted as failed; they do not abort the
+// run. Progress is streamed in order of object: one message per processed
+// object plus a final summary message with complete=true.
+//
+// Only one of SyncDatabase, UpdateWebp and UpdateAvif runs at a time for a
+// user (see runExclusive).
 func (s *LibraryServer) UpdateWebp(req *proto.UpdateWebpRequest, stream grpc.ServerStreamingServer[proto.UpdateWebpProgress]) error {
 	ctx := stream.Context()
 	userID, ok := ctx.Value(contextKeyUser{}).(uint)
@@ -1245,6 +1581,15 @@ func (s *LibraryServer) UpdateWebp(req *proto.UpdateWebpRequest, stream grpc.Ser
 		return status.Errorf(codes.Unauthenticated, "authentication required")
 	}
 
+	return runExclusive(s, stream, userID, OperationUpdateWebp, func(stream grpc.ServerStreamingServer[proto.UpdateWebpProgress]) error {
+		return s.updateWebp(userID, req, stream)
+	})
+}
+
+// updateWebp implements UpdateWebp while holding the user's operation lock.
+func (s *LibraryServer) updateWebp(userID uint, req *proto.UpdateWebpRequest, stream grpc.ServerStreamingServer[proto.UpdateWebpProgress]) error {
+	ctx := stream.Context()
+
 	// Get all objects from GCS
 	_, gcsListSpan := startSpan(ctx, "gcs.list_objects")
 	gcsObjects, err := getGCSObjectsMap(ctx, s.GCSClient, s.BucketName)
@@ -1254,7 +1599,15 @@ func (s *LibraryServer) UpdateWebp(req *proto.UpdateWebpRequest, stream grpc.Ser
 	}
 	endSpanOk(gcsListSpan)
 
-	objectsMissingWebp := missingWebp(gcsObjects)
+	_, derivedListSpan := startSpan(ctx, "db.list_derived_objects")
+	derived, err := loadDerivedObjectSet(s.DB)
+	if err != nil {
+		recordSpanError(derivedListSpan, err)
+		return status.Errorf(codes.Internal, "failed to list derived objects: %v", err)
+	}
+	endSpanOk(derivedListSpan)
+
+	objectsMissingWebp := missingWebp(gcsObjects, derived)
 	slices.Sort(objectsMissingWebp)
 
 	var databasePhotos []database.PhotoObject
@@ -1268,7 +1621,7 @@ func (s *LibraryServer) UpdateWebp(req *proto.UpdateWebpRequest, stream grpc.Ser
 	// Filter to eligible rows: missing webp_object_id and not a derived asset.
 	eligible := make([]database.PhotoObject, 0, len(databasePhotos))
 	for _, obj := range databasePhotos {
-		if isDerivedObjectID(obj.ObjectID) {
+		if derived.contains(obj.ObjectID) {
 			continue
 		}
 		if obj.WebpObjectID != nil && *obj.WebpObjectID != "" {
@@ -1287,10 +1640,18 @@ func (s *LibraryServer) UpdateWebp(req *proto.UpdateWebpRequest, stream grpc.Ser
 		eligibleSet[obj.ObjectID] = struct{}{}
 	}
 
+	// Objects only in GCS are processed after the eligible rows
+	var gcsOnly []string
+	for _, objectID := range objectsMissingWebp {
+		if _, ok := eligibleSet[objectID]; !ok {
+			gcsOnly = append(gcsOnly, objectID)
+		}
+	}
+
 	pause := time.Duration(req.GetPauseBetweenObjectsSeconds()) * time.Second
 
 	var generated, skipped, failed int
-	total := uint32(len(eligible) + len(objectsMissingWebp))
+	total := uint32(len(eligible) + len(gcsOnly))
 
 	slog.InfoContext(
 		ctx,
@@ -1305,70 +1666,49 @@ func (s *LibraryServer) UpdateWebp(req *proto.UpdateWebpRequest, stream grpc.Ser
 	// configured; this avoids a nil pointer dereference on the GCS client when
 	// the database has no eligible rows or the server is misconfigured.
 	var bucket *storage.BucketHandle
-	if (len(eligible) > 0 || len(objectsMissingWebp) > 0) && s.GCSClient != nil {
+	if total > 0 && s.GCSClient != nil {
 		bucket = s.GCSClient.Bucket(s.BucketName)
 	}
 
-	var processed uint32
-	for i := range eligible {
-		processed++
-		photoObject := &eligible[i]
-		objectID := photoObject.ObjectID
-
-		status := s.generateWebpForObject(ctx, bucket, photoObject, objectID)
-		switch status {
-		case webpStatusGenerated:
-			generated++
-		case webpStatusSkipped:
-			skipped++
-		case webpStatusFailed:
-			failed++
-		}
-
-		if pause > 0 && status == webpStatusGenerated {
-			time.Sleep(pause)
-		}
-
-		if err := stream.Send(&proto.UpdateWebpProgress{
-			Processed: processed,
-			Total:     total,
-			Generated: uint32(generated),
-			Skipped:   uint32(skipped),
-			Failed:    uint32(failed),
-		}); err != nil {
-			return err
-		}
-	}
-
-	for _, objectID := range objectsMissingWebp {
-		if _, ok := eligibleSet[objectID]; ok {
-			continue
-		}
-		processed++
-
-		status := s.generateWebpFromPath(ctx, bucket, objectID)
-		switch status {
-		case webpStatusGenerated:
-			generated++
-		case webpStatusSkipped:
-			skipped++
-		case webpStatusFailed:
-			failed++
-		}
-
-		if pause > 0 && status == webpStatusGenerated {
-			time.Sleep(pause)
-		}
+	// Objects are processed Concurrency at a time, and progress is reported
+	// in the order above
+	err = processInOrder(ctx, s.Concurrency, s.ObjectTimeout, int(total),
+		func(ctx context.Context, i int) webpStatus {
+			var status webpStatus
+			if i < len(eligible) {
+				status = s.generateWebpForObject(ctx, bucket, &eligible[i], eligible[i].ObjectID)
+			} else {
+				status = s.generateWebpFromPath(ctx, bucket, userID, gcsOnly[i-len(eligible)])
+			}
+			if pause > 0 && status == webpStatusGenerated {
+				select {
+				case <-time.After(pause):
+				case <-ctx.Done():
+				}
+			}
+			return status
+		},
+		func(i int, status webpStatus) error {
+			switch status {
+			case webpStatusGenerated:
+				generated++
+			case webpStatusSkipped:
+				skipped++
+			case webpStatusFailed:
+				failed++
+			}
 
-		if err := stream.Send(&proto.UpdateWebpProgress{
-			Processed: processed,
-			Total:     total,
-			Generated: uint32(generated),
-			Skipped:   uint32(skipped),
-			Failed:    uint32(failed),
-		}); err != nil {
-			return err
-		}
+			return stream.Send(&proto.UpdateWebpProgress{
+				Processed: uint32(i + 1),
+				Total:     total,
+				Generated: uint32(generated),
+				Skipped:   uint32(skipped),
+				Failed:    uint32(failed),
+			})
+		},
+	)
+	if err != nil {
+		return err
 	}
 
 	slog.InfoContext(
@@ -1378,7 +1718,7 @@ func (s *LibraryServer) UpdateWebp(req *proto.UpdateWebpRequest, stream grpc.Ser
 		slog.Int("skipped", skipped),
 		slog.Int("failed", failed),
 		slog.Int("eligible_db", len(eligible)),
-		slog.Int("gcs_only", len(objectsMissingWebp)),
+		slog.Int("gcs_only", len(gcsOnly)),
 		slog.Uint64("user_id", uint64(userID)),
 	)
 
@@ -1392,7 +1732,8 @@ func (s *LibraryServer) UpdateWebp(req *proto.UpdateWebpRequest, stream grpc.Ser
 	})
 }
 
-// webpStatus is the outcome of a single-object WebP generation attempt.
+// webpStatus is the outcome of a single-object WebP (or AVIF) generation
+// attempt.
 type webpStatus int
 
 const (
@@ -1401,7 +1742,7 @@ const (
 	webpStatusFailed
 )
 
-// generateWebpForObject downloads the original object (or its DNG preview) from
+// generateWebpForObject downloads the original object (or its preview) from
 // GCS and generates a WebP rendition, recording the new object ID in the
 // database. It mirrors the WebP generation logic of updateObjectMetadata but
 // performs no EXIF/metadata refresh. The returned webpStatus classifies the
@@ -1438,16 +1779,16 @@ func (s *LibraryServer) generateWebpForObject(
 	endSpanOk(attrsSpan)
 
 	switch {
-	case IsDNGContentType(attrs.ContentType):
-		// For DNG files, the WebP is derived from the JPEG preview. Ensure a
-		// preview exists; generate one if none is recorded.
+	case HasPreviewContentType(attrs.ContentType):
+		// For RAW and HEIC files, the WebP is derived from the JPEG preview.
+		// Ensure a preview exists; generate one if none is recorded.
 		var srcData []byte
 		if photoObject.ThumbnailObjectID == nil || *photoObject.ThumbnailObjectID == "" {
-			generated, genErr := s.generateAndStoreDNGPreview(ctx, bucket, photoObject, objectID, attrs.ContentType)
+			generated, genErr := s.generateAndStorePreview(ctx, bucket, photoObject, objectID, attrs.ContentType)
 			if genErr != nil {
 				slog.WarnContext(
 					ctx,
-					"failed to generate DNG preview for WebP",
+					"failed to generate preview for WebP",
 					slog.String("object_id", objectID),
 					slog.String("error", genErr.Error()),
 				)
@@ -1456,12 +1797,12 @@ func (s *LibraryServer) generateWebpForObject(
 			srcData = generated
 		} else {
 			_, readSpan := startSpan(ctx, "gcs.read_object")
-			previewReader, rErr := bucket.Object(*photoObject.ThumbnailObjectID).NewReader(ctx)
+			previewReader, rErr := s.newObjectReader(ctx, bucket.Object(*photoObject.ThumbnailObjectID))
 			if rErr != nil {
 				recordSpanError(readSpan, rErr)
 				slog.WarnContext(
 					ctx,
-					"failed to read DNG preview for WebP",
+					"failed to read preview for WebP",
 					slog.String("object_id", objectID),
 					slog.String("error", rErr.Error()),
 				)
@@ -1473,7 +1814,7 @@ func (s *LibraryServer) generateWebpForObject(
 				recordSpanError(readSpan, err)
 				slog.WarnContext(
 					ctx,
-					"failed to read DNG preview data for WebP",
+					"failed to read preview data for WebP",
 					slog.String("object_id", objectID),
 					slog.String("error", err.Error()),
 				)
@@ -1484,7 +1825,7 @@ func (s *LibraryServer) generateWebpForObject(
 		if len(srcData) == 0 {
 			slog.WarnContext(
 				ctx,
-				"no source data for DNG WebP generation",
+				"no source data for preview WebP generation",
 				slog.String("object_id", objectID),
 			)
 			return webpStatusSkipped
@@ -1496,7 +1837,7 @@ func (s *LibraryServer) generateWebpForObject(
 
 	case IsWebPConvertibleContentType(attrs.ContentType):
 		_, readSpan := startSpan(ctx, "gcs.read_object")
-		reader, rErr := bucket.Object(objectID).NewReader(ctx)
+		reader, rErr := s.newObjectReader(ctx, bucket.Object(objectID))
 		if rErr != nil {
 			recordSpanError(readSpan, rErr)
 			slog.WarnContext(
@@ -1526,19 +1867,20 @@ func (s *LibraryServer) generateWebpForObject(
 		return webpStatusFailed
 
 	default:
-		// Unsupported content type (video, heic, etc.) - skip.
+		// Unsupported content type (video, etc.) - skip.
 		return webpStatusSkipped
 	}
 }
 
 // generateWebpFromPath generates a WebP rendition for an object identified only
 // by its GCS object ID, without a database.PhotoObject record. It mirrors
-// generateWebpForObject but skips DNG preview handling and database updates,
+// generateWebpForObject but skips preview handling and database updates,
 // both of which require a PhotoObject. The WebP is uploaded to GCS but the
 // caller is responsible for persisting the resulting webp_object_id if needed.
 func (s *LibraryServer) generateWebpFromPath(
 	ctx context.Context,
 	bucket *storage.BucketHandle,
+	userID uint,
 	objectID string,
 ) webpStatus {
 	if bucket == nil {
@@ -1567,19 +1909,20 @@ func (s *LibraryServer) generateWebpFromPath(
 	endSpanOk(attrsSpan)
 
 	switch {
-	case IsDNGContentType(attrs.ContentType):
-		// DNG handling requires a PhotoObject to persist the preview object ID
-		// (thumbnail_object_id), so it cannot be performed from a path alone.
+	case HasPreviewContentType(attrs.ContentType):
+		// RAW and HEIC handling requires a PhotoObject to persist the preview
+		// object ID (thumbnail_object_id), so it cannot be performed from a
+		// path alone.
 		slog.WarnContext(
 			ctx,
-			"DNG WebP generation requires a PhotoObject",
+			"preview WebP generation requires a PhotoObject",
 			slog.String("object_id", objectID),
 		)
 		return webpStatusSkipped
 
 	case IsWebPConvertibleContentType(attrs.ContentType):
 		_, readSpan := startSpan(ctx, "gcs.read_object")
-		reader, rErr := bucket.Object(objectID).NewReader(ctx)
+		reader, rErr := s.newObjectReader(ctx, bucket.Object(objectID))
 		if rErr != nil {
 			recordSpanError(readSpan, rErr)
 			slog.WarnContext(
@@ -1604,8 +1947,7 @@ func (s *LibraryServer) generateWebpFromPath(
 		}
 		endSpanOk(readSpan)
 
-		webpID := webpObjectID(objectID)
-		webpData, genErr := GenerateWebP(data, s.WebPQuality)
+		rendition, genErr := s.generateWebRendition(ctx, objectID, data)
 		if genErr != nil {
 			slog.WarnContext(
 				ctx,
@@ -1616,10 +1958,21 @@ func (s *LibraryServer) generateWebpFromPath(
 			return webpStatusFailed
 		}
 
+		webpID := rendition.ObjectID
 		_, writeSpan := startSpan(ctx, "gcs.write_object")
-		webpWriter := bucket.Object(webpID).NewWriter(ctx)
-		webpWriter.ContentType = "image/webp"
-		if _, wErr := webpWriter.Write(webpData); wErr != nil {
+		webpWriter, wErr := newDerivedObjectWriter(ctx, bucket.Object(webpID), database.DerivedKindWebP, objectID)
+		if wErr != nil {
+			recordSpanError(writeSpan, wErr)
+			slog.WarnContext(
+				ctx,
+				"failed to write WebP to GCS",
+				slog.String("object_id", objectID),
+				slog.String("error", wErr.Error()),
+			)
+			return webpStatusFailed
+		}
+		webpWriter.ContentType = rendition.ContentType
+		if _, wErr := webpWriter.Write(rendition.Data); wErr != nil {
 			_ = webpWriter.Close()
 			recordSpanError(writeSpan, wErr)
 			slog.WarnContext(
@@ -1641,6 +1994,7 @@ func (s *LibraryServer) generateWebpFromPath(
 			return webpStatusFailed
 		}
 		endSpanOk(writeSpan)
+		recordDerivedObject(ctx, s.DB, userID, database.DerivedKindWebP, objectID, webpID, int64(len(rendition.Data)))
 
 		slog.InfoContext(
 			ctx,
@@ -1651,55 +2005,59 @@ func (s *LibraryServer) generateWebpFromPath(
 		return webpStatusGenerated
 
 	default:
-		// Unsupported content type (video, heic, etc.) - skip.
+		// Unsupported content type (video, etc.) - skip.
 		return webpStatusSkipped
 	}
 }
 
-// generateAndStoreDNGPreview generates a JPEG preview for a DNG file, uploads
-// it to GCS under the DNG preview object ID, persists the ID to
+// generateAndStorePreview generates a JPEG preview for a RAW or HEIC file,
+// uploads it to GCS under the preview object ID, persists the ID to
 // thumbnail_object_id, and returns the generated bytes. It is a refactored
-// extraction of the DNG-preview block in updateObjectMetadata so that the
+// extraction of the preview block in updateObjectMetadata so that the
 // standalone WebP pass can reuse it without performing a full metadata sync.
-func (s *LibraryServer) generateAndStoreDNGPreview(
+func (s *LibraryServer) generateAndStorePreview(
 	ctx context.Context,
 	bucket *storage.BucketHandle,
 	photoObject *database.PhotoObject,
 	objectID string,
 	contentType string,
 ) ([]byte, error) {
-	// Download the DNG once to derive the preview.
+	// Download the original once to derive the preview.
 	_, readSpan := startSpan(ctx, "gcs.read_object")
-	reader, err := bucket.Object(objectID).NewReader(ctx)
+	reader, err := s.newObjectReader(ctx, bucket.Object(objectID))
 	if err != nil {
 		recordSpanError(readSpan, err)
-		return nil, fmt.Errorf("failed to read DNG for preview: %w", err)
+		return nil, fmt.Errorf("failed to read original for preview: %w", err)
 	}
 	data, err := io.ReadAll(reader)
 	_ = reader.Close()
 	if err != nil {
 		recordSpanError(readSpan, err)
-		return nil, fmt.Errorf("failed to read DNG data for preview: %w", err)
+		return nil, fmt.Errorf("failed to read original data for preview: %w", err)
 	}
 	endSpanOk(readSpan)
 
-	generated, err := GenerateDNGPreview(data)
+	generated, err := s.generatePreview(ctx, contentType, data)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate DNG preview: %w", err)
+		return nil, fmt.Errorf("failed to generate preview: %w", err)
 	}
 
-	previewObjectID := dngPreviewObjectID(objectID)
+	previewObjectID := previewObjectID(objectID)
 	_, writeSpan := startSpan(ctx, "gcs.write_object")
-	previewWriter := bucket.Object(previewObjectID).NewWriter(ctx)
+	previewWriter, wErr := newDerivedObjectWriter(ctx, bucket.Object(previewObjectID), database.DerivedKindPreview, objectID)
+	if wErr != nil {
+		recordSpanError(writeSpan, wErr)
+		return nil, wErr
+	}
 	previewWriter.ContentType = "image/jpeg"
 	if _, wErr := previewWriter.Write(generated); wErr != nil {
 		_ = previewWriter.Close()
 		recordSpanError(writeSpan, wErr)
-		return nil, fmt.Errorf("failed to write DNG preview: %w", wErr)
+		return nil, fmt.Errorf("failed to write preview: %w", wErr)
 	}
 	if cErr := previewWriter.Close(); cErr != nil {
 		recordSpanError(writeSpan, cErr)
-		return nil, fmt.Errorf("failed to close DNG preview writer: %w", cErr)
+		return nil, fmt.Errorf("failed to close preview writer: %w", cErr)
 	}
 	endSpanOk(writeSpan)
 
@@ -1709,20 +2067,22 @@ func (s *LibraryServer) generateAndStoreDNGPreview(
 		return nil, fmt.Errorf("failed to update thumbnail_object_id: %w", dbErr)
 	}
 	endSpanOk(dbSpan)
+	recordDerivedObject(ctx, s.DB, photoObject.UserID, database.DerivedKindPreview, objectID, previewObjectID, int64(len(generated)))
 
 	slog.InfoContext(
 		ctx,
-		"Generated DNG preview during WebP pass",
+		"Generated preview during WebP pass",
 		slog.String("object_id", objectID),
 		slog.String("preview_object_id", previewObjectID),
 	)
 	return generated, nil
 }
 
-// generateAndRecordWebP generates a WebP from srcData, uploads it to GCS under
-// webpObjectID(originalObjectID), and persists the new object ID to the
-// database.  All failures are logged as warnings and do not abort the caller.
-// Returns true on success, false on failure.
+// generateAndRecordWebP generates a WebP from srcData (or a JPEG if cwebp is
+// not installed, see generateWebRendition), uploads it to GCS next to
+// originalObjectID, and persists the new object ID to the database. All
+// failures are logged as warnings and do not abort the caller. Returns true
+// on success, false on failure.
 func (s *LibraryServer) generateAndRecordWebP(
 	ctx context.Context,
 	bucket *storage.BucketHandle,
@@ -1730,9 +2090,7 @@ func (s *LibraryServer) generateAndRecordWebP(
 	originalObjectID string,
 	srcData []byte,
 ) bool {
-	webpID := webpObjectID(originalObjectID)
-
-	webpData, err := GenerateWebP(srcData, s.WebPQuality)
+	rendition, err := s.generateWebRendition(ctx, originalObjectID, srcData)
 	if err != nil {
 		slog.WarnContext(
 			ctx,
@@ -1743,11 +2101,22 @@ func (s *LibraryServer) generateAndRecordWebP(
 		return false
 	}
 
+	webpID := rendition.ObjectID
 	_, writeSpan := startSpan(ctx, "gcs.write_object")
-	webpWriter := bucket.Object(webpID).NewWriter(ctx)
-	webpWriter.ContentType = "image/webp"
+	webpWriter, err := newDerivedObjectWriter(ctx, bucket.Object(webpID), database.DerivedKindWebP, originalObjectID)
+	if err != nil {
+		recordSpanError(writeSpan, err)
+		slog.WarnContext(
+			ctx,
+			"failed to write WebP to GCS",
+			slog.String("object_id", originalObjectID),
+			slog.String("error", err.Error()),
+		)
+		return false
+	}
+	webpWriter.ContentType = rendition.ContentType
 
-	if _, err := webpWriter.Write(webpData); err != nil {
+	if _, err := webpWriter.Write(rendition.Data); err != nil {
 		_ = webpWriter.Close()
 		recordSpanError(writeSpan, err)
 		slog.WarnContext(
@@ -1783,6 +2152,7 @@ func (s *LibraryServer) generateAndRecordWebP(
 		return false
 	}
 	endSpanOk(dbSpan)
+	recordDerivedObject(ctx, s.DB, photoObject.UserID, database.DerivedKindWebP, originalObjectID, webpID, int64(len(rendition.Data)))
 
 	slog.InfoContext(
 		ctx,
@@ -1793,12 +2163,19 @@ func (s *LibraryServer) generateAndRecordWebP(
 	return true
 }
 
+// hasGCSMetadata reports whether the GCS metadata current already has every
+// entry of metadata.
+func hasGCSMetadata(current, metadata map[string]string) bool {
+	return len(staleGCSMetadataKeys(current, metadata)) == 0
+}
+
 // updateObjectMetadata downloads a photo, extracts EXIF metadata, updates GCS
 // object metadata, and updates the time_taken field in the database.
-// For DNG files it also generates a JPEG preview if one does not already exist.
-// For eligible images (jpeg/png/gif) and for DNG files (via their JPEG preview)
-// it generates a WebP rendition if webp_object_id is not yet set, and persists
-// the new object ID to the database.
+// For RAW and HEIC files it also generates a JPEG preview if one does not
+// already exist. For eligible images (jpeg/png/gif) and for RAW and HEIC files
+// (via their JPEG preview) it generates a WebP rendition if webp_object_id is
+// not yet set, and persists the new object ID to the database. The video of
+// an Android motion photo is extracted if it has not been yet.
 // Derived assets (_preview.jpg, _thumb.jpg) are skipped for WebP generation.
 // Returns true if metadata was updated, false if skipped (already has metadata).
 func (s *LibraryServer) updateObjectMetadata(ctx context.Context, objectID string, attrs *storage.ObjectAttrs, userID uint) (bool, error) {
@@ -1807,7 +2184,7 @@ func (s *LibraryServer) updateObjectMetadata(ctx context.Context, objectID strin
 
 	// Download the object data
 	_, readSpan := startSpan(ctx, "gcs.read_object")
-	reader, err := obj.NewReader(ctx)
+	reader, err := s.newObjectReader(ctx, obj)
 	if err != nil {
 		recordSpanError(readSpan, err)
 		return false, err
@@ -1823,65 +2200,73 @@ func (s *LibraryServer) updateObjectMetadata(ctx context.Context, objectID strin
 
 	// Extract EXIF metadata from the photo data
 	photoMetadata := ExtractPhotoMetadata(data, objectID)
+	metadata := newSyncMetadata(photoMetadata)
 
-	// Update GCS object metadata
-	attrsToUpdate := storage.ObjectAttrsToUpdate{
-		Metadata: photoMetadata.ToGCSMetadata(),
-	}
-
-	_, updateSpan := startSpan(ctx, "gcs.update_object")
-	if _, err := obj.Update(ctx, attrsToUpdate); err != nil {
-		recordSpanError(updateSpan, err)
-		return false, err
-	}
-	endSpanOk(updateSpan)
+	// Update GCS object metadata, unless it is already up to date; an
+	// update would change the object and make the next incremental sync
+	// examine it again
+	if !hasGCSMetadata(attrs.Metadata, metadata.gcs) {
+		attrsToUpdate := storage.ObjectAttrsToUpdate{
+			Metadata: metadata.gcs,
+		}
 
-	// Update time_taken in the database
-	var timeTaken *time.Time
-	if photoMetadata.HasDateTaken {
-		timeTaken = &photoMetadata.DateTaken
+		_, updateSpan := startSpan(ctx, "gcs.update_object")
+		if _, err := obj.Update(ctx, attrsToUpdate); err != nil {
+			recordSpanError(updateSpan, err)
+			return false, err
+		}
+		endSpanOk(updateSpan)
 	}
 
+	// Update time_taken, the Live Photo identifier and the camera in the
+	// database
 	_, dbTimeSpan := startSpan(ctx, "db.update_time_taken")
-	if err := s.DB.Model(&database.PhotoObject{}).
-		Where("object_id = ? AND user_id = ?", objectID, userID).
-		Update("time_taken", timeTaken).Error; err != nil {
+	if err := withPhotoChange(s.DB, userID, database.ChangeKindUpdated, objectID, func(tx *gorm.DB) error {
+		return tx.Model(&database.PhotoObject{}).
+			Where("object_id = ? AND user_id = ?", objectID, userID).
+			Updates(metadata.photoColumns()).Error
+	}); err != nil {
 		recordSpanError(dbTimeSpan, err)
 		return false, err
 	}
 	endSpanOk(dbTimeSpan)
 
-	// Load the PhotoObject row once; used by both the DNG-preview and WebP blocks.
+	// Load the PhotoObject row once; used by both the preview and WebP blocks.
 	var photoObject database.PhotoObject
 	_, dbGetSpan := startSpan(ctx, "db.get_photo")
 	hasPhotoObject := s.DB.Where("object_id = ? AND user_id = ?", objectID, userID).First(&photoObject).Error == nil
 	endSpanOk(dbGetSpan)
 
-	// For DNG files, generate a JPEG preview if one does not already exist.
+	// For RAW and HEIC files, generate a JPEG preview if one does not already
+	// exist.
 	// previewData is kept in scope so the WebP block can reuse the freshly
 	// generated bytes without a second GCS read.
 	var previewData []byte
-	if IsDNGContentType(attrs.ContentType) && hasPhotoObject {
+	if HasPreviewContentType(attrs.ContentType) && hasPhotoObject {
 		if photoObject.ThumbnailObjectID == nil || *photoObject.ThumbnailObjectID == "" {
-			generated, err := GenerateDNGPreview(data)
+			generated, err := s.generatePreview(ctx, attrs.ContentType, data)
 			if err != nil {
 				slog.WarnContext(
 					ctx,
-					"failed to generate DNG preview during sync",
+					"failed to generate preview during sync",
 					slog.String("object_id", objectID),
 					slog.String("error", err.Error()),
 				)
 			} else {
-				previewObjectID := dngPreviewObjectID(objectID)
+				previewObjectID := previewObjectID(objectID)
 				_, writeSpan := startSpan(ctx, "gcs.write_object")
-				previewWriter := bucket.Object(previewObjectID).NewWriter(ctx)
-				previewWriter.ContentType = "image/jpeg"
-				if _, writeErr := previewWriter.Write(generated); writeErr != nil {
-					_ = previewWriter.Close()
+				previewWriter, writeErr := newDerivedObjectWriter(ctx, bucket.Object(previewObjectID), database.DerivedKindPreview, objectID)
+				if writeErr == nil {
+					previewWriter.ContentType = "image/jpeg"
+					if _, writeErr = previewWriter.Write(generated); writeErr != nil {
+						_ = previewWriter.Close()
+					}
+				}
+				if writeErr != nil {
 					recordSpanError(writeSpan, writeErr)
 					slog.WarnContext(
 						ctx,
-						"failed to write DNG preview during sync",
+						"failed to write preview during sync",
 						slog.String("object_id", objectID),
 						slog.String("error", writeErr.Error()),
 					)
@@ -1889,7 +2274,7 @@ func (s *LibraryServer) updateObjectMetadata(ctx context.Context, objectID strin
 					recordSpanError(writeSpan, closeErr)
 					slog.WarnContext(
 						ctx,
-						"failed to close DNG preview writer during sync",
+						"failed to close preview writer during sync",
 						slog.String("object_id", objectID),
 						slog.String("error", closeErr.Error()),
 					)
@@ -1906,10 +2291,11 @@ func (s *LibraryServer) updateObjectMetadata(ctx context.Context, objectID strin
 						)
 					} else {
 						endSpanOk(dbThumbSpan)
+						recordDerivedObject(ctx, s.DB, userID, database.DerivedKindPreview, objectID, previewObjectID, int64(len(generated)))
 						previewData = generated
 						slog.InfoContext(
 							ctx,
-							"Generated DNG preview during sync",
+							"Generated preview during sync",
 							slog.String("object_id", objectID),
 							slog.String("preview_object_id", previewObjectID),
 						)
@@ -1919,27 +2305,36 @@ func (s *LibraryServer) updateObjectMetadata(ctx context.Context, objectID strin
 		}
 	}
 
+	// Extract the video of an Android motion photo if it has not been yet
+	if attrs.ContentType == "image/jpeg" && hasPhotoObject &&
+		(photoObject.MotionVideoObjectID == nil || *photoObject.MotionVideoObjectID == "") {
+		uploadMotionVideo(ctx, bucket, data, objectID, &photoObject)
+		if photoObject.MotionVideoObjectID != nil {
+			referenceDerivedObject(ctx, s.DB, userID, objectID, "motion_video_object_id", *photoObject.MotionVideoObjectID)
+			recordDerivedObject(ctx, s.DB, userID, database.DerivedKindMotionVideo, objectID, *photoObject.MotionVideoObjectID, derivedObjectSize(ctx, bucket, *photoObject.MotionVideoObjectID))
+		}
+	}
+
 	// Generate a WebP rendition if one is not yet recorded.
-	// Derived assets (_preview.jpg, _thumb.jpg) are skipped to avoid
-	// producing WebPs of secondary assets.
-	if hasPhotoObject && !isDerivedObjectID(objectID) &&
-		(photoObject.WebpObjectID == nil || *photoObject.WebpObjectID == "") {
+	// Derived assets are skipped to avoid producing WebPs of secondary
+	// assets.
+	if hasPhotoObject && !isDerivedObject(s.DB, objectID) && syncWebPReason(&photoObject, attrs.ContentType) != "" {
 
 		switch {
-		case IsDNGContentType(attrs.ContentType):
-			// For DNG files, use the JPEG preview as the WebP source.
+		case HasPreviewContentType(attrs.ContentType):
+			// For RAW and HEIC files, use the JPEG preview as the WebP source.
 			// Prefer freshly generated bytes from above; otherwise read from GCS.
 			var srcData []byte
 			if len(previewData) > 0 {
 				srcData = previewData
 			} else if photoObject.ThumbnailObjectID != nil && *photoObject.ThumbnailObjectID != "" {
 				_, previewReadSpan := startSpan(ctx, "gcs.read_object")
-				previewReader, err := bucket.Object(*photoObject.ThumbnailObjectID).NewReader(ctx)
+				previewReader, err := s.newObjectReader(ctx, bucket.Object(*photoObject.ThumbnailObjectID))
 				if err != nil {
 					recordSpanError(previewReadSpan, err)
 					slog.WarnContext(
 						ctx,
-						"failed to read DNG preview for WebP generation during sync",
+						"failed to read preview for WebP generation during sync",
 						slog.String("object_id", objectID),
 						slog.String("error", err.Error()),
 					)
@@ -1950,7 +2345,7 @@ func (s *LibraryServer) updateObjectMetadata(ctx context.Context, objectID strin
 						recordSpanError(previewReadSpan, err)
 						slog.WarnContext(
 							ctx,
-							"failed to read DNG preview data for WebP generation during sync",
+							"failed to read preview data for WebP generation during sync",
 							slog.String("object_id", objectID),
 							slog.String("error", err.Error()),
 						)
@@ -2037,13 +2432,18 @@ func (s *LibraryServer) UpdatePhotoMetadata(ctx context.Context, req *proto.Upda
 	endSpanOk(gcsUpdateSpan)
 
 	// Update database if content type changed
-	if contentType != "" && contentType != photoObject.ContentType {
-		_, dbContentSpan := startSpan(ctx, "db.update_content_type")
-		if err := s.DB.Model(&photoObject).Update("content_type", contentType).Error; err != nil {
-			recordSpanError(dbContentSpan, err)
-			return nil, status.Errorf(codes.Internal, "failed to update database: %v", err)
+	_, dbContentSpan := startSpan(ctx, "db.update_content_type")
+	if err := withPhotoChange(s.DB, userID, database.ChangeKindUpdated, objectID, func(tx *gorm.DB) error {
+		if contentType == "" || contentType == photoObject.ContentType {
+			return nil
 		}
-		endSpanOk(dbContentSpan)
+		return tx.Model(&photoObject).Update("content_type", contentType).Error
+	}); err != nil {
+		recordSpanError(dbContentSpan, err)
+		return nil, status.Errorf(codes.Internal, "failed to update database: %v", err)
+	}
+	endSpanOk(dbContentSpan)
+	if contentType != "" {
 		photoObject.ContentType = contentType
 	}
 
@@ -2081,21 +2481,31 @@ func (s *LibraryServer) UpdatePhotoMetadata(ctx context.Context, req *proto.Upda
 	}, nil
 }
 
-// missingWebp returns the object IDs of original (non-derived) GCS objects
-// whose expected WebP rendition is absent from the bucket, filtered to
-// content types eligible for WebP generation (raster images and DNG files).
-// Videos, HEIC, and already-WebP objects are excluded so the returned slice
-// reflects only objects that could actually produce a WebP rendition.
-func missingWebp(gcsObjects map[string]*storage.ObjectAttrs) (objectsMissingWebp []string) {
+// missingWebp returns the object IDs of original GCS objects whose expected
+// WebP rendition is absent from the bucket, filtered to content types
+// eligible for WebP generation (raster images, and RAW and HEIC files via
+// their preview). Derived assets, recorded in derived or marked in their GCS
+// metadata, are excluded, as are videos and WebP objects, so the returned
+// slice reflects only objects that could actually produce a WebP rendition.
+// A rendition is found by its name or, for one named before the extension of
+// its original was kept in its name, by the marker in its GCS metadata.
+func missingWebp(gcsObjects map[string]*storage.ObjectAttrs, derived derivedObjectSet) (objectsMissingWebp []string) {
+	markedWebp := make(map[string]bool)
+	for _, attrs := range gcsObjects {
+		if source, kind, marked := markedDerivedObject(attrs); marked && kind == database.DerivedKindWebP {
+			markedWebp[source] = true
+		}
+	}
 	for objectID, attrs := range gcsObjects {
-		if isDerivedObjectID(objectID) {
+		if _, _, marked := markedDerivedObject(attrs); marked || derived.contains(objectID) {
 			continue
 		}
-		if !IsWebPConvertibleContentType(attrs.ContentType) && !IsDNGContentType(attrs.ContentType) {
+		if !IsWebPConvertibleContentType(attrs.ContentType) && !HasPreviewContentType(attrs.ContentType) {
 			continue
 		}
-		webpID := webpObjectID(objectID)
-		if _, ok := gcsObjects[webpID]; !ok {
+		_, hasWebp := gcsObjects[webpObjectID(objectID)]
+		_, hasWebJPEG := gcsObjects[webJPEGObjectID(objectID)]
+		if !hasWebp && !hasWebJPEG && !markedWebp[objectID] {
 			objectsMissingWebp = append(objectsMissingWebp, objectID)
 		}
 	}
@@ -2103,7 +2513,7 @@ func missingWebp(gcsObjects map[string]*storage.ObjectAttrs) (objectsMissingWebp
 }
 
 // getGCSObjectsMap reads from the specified bucket and returns a map of object IDs
-// to their attributes, including both original uploads and derived assets (DNG JPEG
+// to their attributes, including both original uploads and derived assets (RAW JPEG
 // previews, video thumbnails, and WebP renditions).
 func getGCSObjectsMap(ctx context.Context, client *storage.Client, bucketName string) (map[string]*storage.ObjectAttrs, error) {
 	if client == nil {
@@ -2133,20 +2543,21 @@ func getGCSObjectsMap(ctx context.Context, client *storage.Client, bucketName st
 }
 
 // getGCSNonDerivedObjectsMap reads from the specified bucket and returns a map of
-// object IDs to their attributes. Derived assets (DNG JPEG previews, video
-// thumbnails, and WebP renditions identified by isDerivedObjectID) are excluded so
-// callers can treat every entry as an original upload.
-func getGCSNonDerivedObjectsMap(ctx context.Context, client *storage.Client, bucketName string) (map[string]*storage.ObjectAttrs, error) {
-	objects, err := getGCSObjectsMap(ctx, client, bucketName)
+// object IDs to their attributes. Derived assets (RAW JPEG previews, video
+// thumbnails, WebP renditions and fixed-size thumbnails), recorded in derived or
+// marked in their GCS metadata, are returned in a separate map so callers can
+// treat every entry of the first as an original upload.
+func getGCSNonDerivedObjectsMap(
+	ctx context.Context,
+	client *storage.Client,
+	bucketName string,
+	derived derivedObjectSet,
+) (objects, derivedObjects map[string]*storage.ObjectAttrs, err error) {
+	objects, err = getGCSObjectsMap(ctx, client, bucketName)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	for id := range objects {
-		if isDerivedObjectID(id) {
-			delete(objects, id)
-		}
-	}
-	return objects, nil
+	return objects, splitDerivedObjects(objects, derived), nil
 }
 
 // getDirectoryConfiguration reads the index.md file from the specified prefix and parses
@@ -2525,6 +2936,11 @@ func (s *LibraryServer) GenerateVideoThumbnail(ctx context.Context, req *proto.G
 		// Thumbnail record exists but file doesn't - regenerate it
 	}
 
+	// There is no fallback for decoding video frames
+	if !s.Capabilities.Has(ToolFFmpeg) {
+		return nil, status.Errorf(codes.FailedPrecondition, "video thumbnails require %s, which is not installed on the server", ToolFFmpeg)
+	}
+
 	// Download video from GCS
 	bucket := s.GCSClient.Bucket(s.BucketName)
 	obj := bucket.Object(objectID)
@@ -2547,19 +2963,32 @@ func (s *LibraryServer) GenerateVideoThumbnail(ctx context.Context, req *proto.G
 	}
 	endSpanOk(readSpan)
 
-	// Generate thumbnail using ffmpeg
+	// Generate thumbnail using ffmpeg; without an offset a poster frame is
+	// chosen as on upload
 	timeOffsetMs := req.GetTimeOffsetMs()
-	thumbnailData, err := GenerateVideoThumbnail(videoData, timeOffsetMs)
+	var thumbnailData []byte
+	if timeOffsetMs == 0 {
+		thumbnailData, err = generateVideoPosterFromData(ctx, s.Capabilities, videoData, objectID)
+	} else {
+		thumbnailData, err = GenerateVideoThumbnail(videoData, timeOffsetMs)
+	}
 	if err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return nil, status.Errorf(codes.FailedPrecondition, "video thumbnails require %s, which is not installed on the server", ToolFFmpeg)
+		}
 		return nil, status.Errorf(codes.Internal, "failed to generate thumbnail: %v", err)
 	}
 
 	// Generate thumbnail object ID (same path as video but with _thumb.jpg suffix)
-	thumbnailObjectID := strings.TrimSuffix(objectID, "."+getFileExtension(objectID)) + "_thumb.jpg"
+	thumbnailObjectID := derivedObjectID(database.DerivedKindThumbnail, objectID)
 
 	// Upload thumbnail to GCS
 	_, writeSpan := startSpan(ctx, "gcs.write_object")
-	thumbWriter := bucket.Object(thumbnailObjectID).NewWriter(ctx)
+	thumbWriter, err := newDerivedObjectWriter(ctx, bucket.Object(thumbnailObjectID), database.DerivedKindThumbnail, objectID)
+	if err != nil {
+		recordSpanError(writeSpan, err)
+		return nil, status.Errorf(codes.Internal, "failed to write thumbnail to GCS: %v", err)
+	}
 	thumbWriter.ContentType = "image/jpeg"
 
 	if _, err := thumbWriter.Write(thumbnailData); err != nil {
@@ -2580,6 +3009,7 @@ func (s *LibraryServer) GenerateVideoThumbnail(ctx context.Context, req *proto.G
 		return nil, status.Errorf(codes.Internal, "failed to update photo with thumbnail: %v", err)
 	}
 	endSpanOk(dbThumbSpan)
+	recordDerivedObject(ctx, s.DB, userID, database.DerivedKindThumbnail, objectID, thumbnailObjectID, int64(len(thumbnailData)))
 
 	// Generate signed URL for the new thumbnail
 	expiresAt := time.Now().Add(time.Hour)
@@ -2610,7 +3040,9 @@ func (s *LibraryServer) GenerateVideoThumbnail(ctx context.Context, req *proto.G
 	}, nil
 }
 
-// GenerateDNGPreview generates a JPEG preview image for a DNG photo using dcraw and stores it in GCS.
+// GenerateDNGPreview generates a JPEG preview image for a camera RAW photo
+// (DNG, CR2, CR3, NEF, ARW, RAF or ORF; see RawFormats) using dcraw, or the
+// preview embedded in the file, and stores it in GCS.
 func (s *LibraryServer) GenerateDNGPreview(ctx context.Context, req *proto.GenerateDNGPreviewRequest) (*proto.GenerateDNGPreviewResponse, error) {
 	userID, ok := ctx.Value(contextKeyUser{}).(uint)
 	if !ok {
@@ -2634,9 +3066,9 @@ func (s *LibraryServer) GenerateDNGPreview(ctx context.Context, req *proto.Gener
 	}
 	endSpanOk(dbSpan)
 
-	// Verify this is a DNG file
-	if !IsDNGContentType(photoObject.ContentType) {
-		return nil, status.Errorf(codes.InvalidArgument, "object is not a DNG file: %s", photoObject.ContentType)
+	// Verify this is a RAW file
+	if !IsRawContentType(photoObject.ContentType) {
+		return nil, status.Errorf(codes.InvalidArgument, "object is not a RAW file: %s", photoObject.ContentType)
 	}
 
 	bucket := s.GCSClient.Bucket(s.BucketName)
@@ -2665,7 +3097,7 @@ func (s *LibraryServer) GenerateDNGPreview(ctx context.Context, req *proto.Gener
 
 			slog.InfoContext(
 				ctx,
-				"Returned existing DNG preview",
+				"Returned existing RAW preview",
 				slog.String("object_id", objectID),
 				slog.String("thumbnail_object_id", *photoObject.ThumbnailObjectID),
 				slog.Uint64("user_id", uint64(userID)),
@@ -2680,7 +3112,7 @@ func (s *LibraryServer) GenerateDNGPreview(ctx context.Context, req *proto.Gener
 		// Preview record exists but file doesn't - regenerate it
 	}
 
-	// Download DNG from GCS
+	// Download the RAW file from GCS
 	obj := bucket.Object(objectID)
 
 	_, readSpan := startSpan(ctx, "gcs.read_object")
@@ -2688,41 +3120,45 @@ func (s *LibraryServer) GenerateDNGPreview(ctx context.Context, req *proto.Gener
 	if err != nil {
 		recordSpanError(readSpan, err)
 		if err == storage.ErrObjectNotExist {
-			return nil, status.Errorf(codes.NotFound, "DNG not found in storage: %s", objectID)
+			return nil, status.Errorf(codes.NotFound, "RAW file not found in storage: %s", objectID)
 		}
-		return nil, status.Errorf(codes.Internal, "failed to open DNG: %v", err)
+		return nil, status.Errorf(codes.Internal, "failed to open RAW file: %v", err)
 	}
 	defer func() { _ = reader.Close() }()
 
-	dngData, err := io.ReadAll(reader)
+	rawData, err := io.ReadAll(reader)
 	if err != nil {
 		recordSpanError(readSpan, err)
-		return nil, status.Errorf(codes.Internal, "failed to read DNG: %v", err)
+		return nil, status.Errorf(codes.Internal, "failed to read RAW file: %v", err)
 	}
 	endSpanOk(readSpan)
 
 	// Generate JPEG preview using dcraw
-	previewData, err := GenerateDNGPreview(dngData)
+	previewData, err := GenerateRawPreview(s.Capabilities, rawData)
 	if err != nil {
-		return nil, status.Errorf(codes.Internal, "failed to generate DNG preview: %v", err)
+		return nil, status.Errorf(codes.Internal, "failed to generate RAW preview: %v", err)
 	}
 
 	// Derive preview object ID
-	previewObjectID := dngPreviewObjectID(objectID)
+	previewObjectID := previewObjectID(objectID)
 
 	// Upload preview to GCS
 	_, writeSpan := startSpan(ctx, "gcs.write_object")
-	previewWriter := bucket.Object(previewObjectID).NewWriter(ctx)
+	previewWriter, err := newDerivedObjectWriter(ctx, bucket.Object(previewObjectID), database.DerivedKindPreview, objectID)
+	if err != nil {
+		recordSpanError(writeSpan, err)
+		return nil, status.Errorf(codes.Internal, "failed to write RAW preview to GCS: %v", err)
+	}
 	previewWriter.ContentType = "image/jpeg"
 
 	if _, err := previewWriter.Write(previewData); err != nil {
 		recordSpanError(writeSpan, err)
-		return nil, status.Errorf(codes.Internal, "failed to write DNG preview to GCS: %v", err)
+		return nil, status.Errorf(codes.Internal, "failed to write RAW preview to GCS: %v", err)
 	}
 
 	if err := previewWriter.Close(); err != nil {
 		recordSpanError(writeSpan, err)
-		return nil, status.Errorf(codes.Internal, "failed to close DNG preview writer: %v", err)
+		return nil, status.Errorf(codes.Internal, "failed to close RAW preview writer: %v", err)
 	}
 	endSpanOk(writeSpan)
 
@@ -2733,6 +3169,7 @@ func (s *LibraryServer) GenerateDNGPreview(ctx context.Context, req *proto.Gener
 		return nil, status.Errorf(codes.Internal, "failed to update photo with preview: %v", err)
 	}
 	endSpanOk(dbThumbSpan)
+	recordDerivedObject(ctx, s.DB, userID, database.DerivedKindPreview, objectID, previewObjectID, int64(len(previewData)))
 
 	// Generate signed URL for the new preview
 	expiresAt := time.Now().Add(time.Hour)
@@ -2749,7 +3186,7 @@ func (s *LibraryServer) GenerateDNGPreview(ctx context.Context, req *proto.Gener
 
 	slog.InfoContext(
 		ctx,
-		"Generated DNG preview",
+		"Generated RAW preview",
 		slog.String("object_id", objectID),
 		slog.String("thumbnail_object_id", previewObjectID),
 		slog.Uint64("user_id", uint64(userID)),
@@ -2762,17 +3199,6 @@ func (s *LibraryServer) GenerateDNGPreview(ctx context.Context, req *proto.Gener
 	}, nil
 }
 
-// isDerivedObjectID reports whether the object ID belongs to a generated
-// derived asset (DNG JPEG preview, video thumbnail, or WebP rendition) rather
-// than an original upload.  Derived assets are skipped during database sync and
-// WebP generation to avoid producing artefacts from already-generated files.
-func isDerivedObjectID(objectID string) bool {
-	lower := strings.ToLower(objectID)
-	return strings.HasSuffix(lower, "_preview.jpg") ||
-		strings.HasSuffix(lower, "_thumb.jpg") ||
-		strings.HasSuffix(lower, ".webp")
-}
-
 // generateAndRecordWebPForSync is retained as a thin wrapper over
 // generateAndRecordWebP for the SyncDatabase metadata phase. The shared helper
 // is also used by the standalone UpdateWebp pass.
@@ -15,9 +15,10 @@ import (
 // routing tests. Only the methods exercised by the tests are populated; the
 // rest panic to keep the mock minimal.
 type mockLibraryServiceClient struct {
-	getPhotoFunc     func(ctx context.Context, in *proto.GetPhotoRequest, opts ...grpc.CallOption) (*proto.GetPhotoResponse, error)
-	photoExistsFunc  func(ctx context.Context, in *proto.PhotoExistsRequest, opts ...grpc.CallOption) (*proto.PhotoExistsResponse, error)
-	getMarkdownFunc  func(ctx context.Context, in *proto.GetMarkdownRequest, opts ...grpc.CallOption) (*proto.GetMarkdownResponse, error)
+	getPhotoFunc    func(ctx context.Context, in *proto.GetPhotoRequest, opts ...grpc.CallOption) (*proto.GetPhotoResponse, error)
+	photoExistsFunc func(ctx context.Context, in *proto.PhotoExistsRequest, opts ...grpc.CallOption) (*proto.PhotoExistsResponse, error)
+	getMarkdownFunc func(ctx context.Context, in *proto.GetMarkdownRequest, opts ...grpc.CallOption) (*proto.GetMarkdownResponse, error)
+	unstackFunc     func(ctx context.Context, in *proto.UnstackRequest, opts ...grpc.CallOption) (*proto.UnstackResponse, error)
 }
 
 func (m *mockLibraryServiceClient) GetPhoto(ctx context.Context, in *proto.GetPhotoRequest, opts ...grpc.CallOption) (*proto.GetPhotoResponse, error) {
@@ -64,10 +65,26 @@ func (m *mockLibraryServiceClient) SyncDatabase(ctx context.Context, in *proto.S
 	panic("not implemented")
 }
 
+func (m *mockLibraryServiceClient) VerifyLibrary(ctx context.Context, in *proto.VerifyLibraryRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[proto.VerifyLibraryProgress], error) {
+	panic("not implemented")
+}
+
+func (m *mockLibraryServiceClient) GetChanges(ctx context.Context, in *proto.GetChangesRequest, opts ...grpc.CallOption) (*proto.GetChangesResponse, error) {
+	panic("not implemented")
+}
+
 func (m *mockLibraryServiceClient) UpdateWebp(ctx context.Context, in *proto.UpdateWebpRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[proto.UpdateWebpProgress], error) {
 	panic("not implemented")
 }
 
+func (m *mockLibraryServiceClient) UpdateAvif(ctx context.Context, in *proto.UpdateAvifRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[proto.UpdateAvifProgress], error) {
+	panic("not implemented")
+}
+
+func (m *mockLibraryServiceClient) TranscodeVideo(ctx context.Context, in *proto.TranscodeVideoRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[proto.TranscodeVideoProgress], error) {
+	panic("not implemented")
+}
+
 func (m *mockLibraryServiceClient) CreateMarkdown(ctx context.Context, in *proto.CreateMarkdownRequest, opts ...grpc.CallOption) (*proto.CreateMarkdownResponse, error) {
 	panic("not implemented")
 }
@@ -88,6 +105,42 @@ func (m *mockLibraryServiceClient) GenerateDNGPreview(ctx context.Context, in *p
 	panic("not implemented")
 }
 
+func (m *mockLibraryServiceClient) GetUsage(ctx context.Context, in *proto.GetUsageRequest, opts ...grpc.CallOption) (*proto.GetUsageResponse, error) {
+	panic("not implemented")
+}
+
+func (m *mockLibraryServiceClient) GetServerCapabilities(ctx context.Context, in *proto.GetServerCapabilitiesRequest, opts ...grpc.CallOption) (*proto.GetServerCapabilitiesResponse, error) {
+	panic("not implemented")
+}
+
+func (m *mockLibraryServiceClient) GetStack(ctx context.Context, in *proto.GetStackRequest, opts ...grpc.CallOption) (*proto.GetStackResponse, error) {
+	panic("not implemented")
+}
+
+func (m *mockLibraryServiceClient) SetStackCover(ctx context.Context, in *proto.SetStackCoverRequest, opts ...grpc.CallOption) (*proto.SetStackCoverResponse, error) {
+	panic("not implemented")
+}
+
+func (m *mockLibraryServiceClient) Unstack(ctx context.Context, in *proto.UnstackRequest, opts ...grpc.CallOption) (*proto.UnstackResponse, error) {
+	return m.unstackFunc(ctx, in, opts...)
+}
+
+func (m *mockLibraryServiceClient) ListJobs(ctx context.Context, in *proto.ListJobsRequest, opts ...grpc.CallOption) (*proto.ListJobsResponse, error) {
+	panic("not implemented")
+}
+
+func (m *mockLibraryServiceClient) GetJob(ctx context.Context, in *proto.GetJobRequest, opts ...grpc.CallOption) (*proto.GetJobResponse, error) {
+	panic("not implemented")
+}
+
+func (m *mockLibraryServiceClient) RetryJob(ctx context.Context, in *proto.RetryJobRequest, opts ...grpc.CallOption) (*proto.RetryJobResponse, error) {
+	panic("not implemented")
+}
+
+func (m *mockLibraryServiceClient) ListSchedules(ctx context.Context, in *proto.ListSchedulesRequest, opts ...grpc.CallOption) (*proto.ListSchedulesResponse, error) {
+	panic("not implemented")
+}
+
 // TestGateway_GetPhoto_MultiSegmentObjectID verifies that the gRPC-gateway
 // routes GET /v1/photos/{object_id=**} correctly captures a multi-segment
 // object ID (containing "/") and passes it to the underlying gRPC handler.
@@ -173,6 +226,35 @@ func TestGateway_PhotoExists_MultiSegmentObjectID(t *testing.T) {
 	}
 }
 
+// TestGateway_Unstack_MultiSegmentObjectID verifies that
+// DELETE {object_id=**}/stack is routed to Unstack rather than DeletePhoto.
+func TestGateway_Unstack_MultiSegmentObjectID(t *testing.T) {
+	var capturedObjID string
+	mock := &mockLibraryServiceClient{
+		unstackFunc: func(_ context.Context, in *proto.UnstackRequest, _ ...grpc.CallOption) (*proto.UnstackResponse, error) {
+			capturedObjID = in.GetObjectId()
+			return &proto.UnstackResponse{ObjectIds: []string{in.GetObjectId()}}, nil
+		},
+	}
+
+	mux := runtime.NewServeMux()
+	if err := proto.RegisterLibraryServiceHandlerClient(context.Background(), mux, mock); err != nil {
+		t.Fatalf("failed to register handler: %v", err)
+	}
+
+	req := httptest.NewRequest(http.MethodDelete, "/v1/photos/2024/vacation/DSC001.JPG/stack", nil)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("expected status 200, got %d (body: %s)", rec.Code, rec.Body.String())
+	}
+	want := "2024/vacation/DSC001.JPG"
+	if capturedObjID != want {
+		t.Errorf("expected object_id %q, got %q", want, capturedObjID)
+	}
+}
+
 // TestGateway_GetMarkdown_MultiSegmentPrefix verifies that
 // {prefix=**}/markdown captures a nested directory prefix.
 func TestGateway_GetMarkdown_MultiSegmentPrefix(t *testing.T) {
@@ -253,4 +335,4 @@ func TestGateway_UnmatchedRouteReturns404(t *testing.T) {
 	if rec.Code != http.StatusNotFound {
 		t.Errorf("expected status 404, got %d", rec.Code)
 	}
-}
\ No newline at end of file
+}
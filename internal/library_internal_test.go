@@ -794,6 +794,36 @@ func TestPhotoExists_MissingObjectID(t *testing.T) {
 	assertGRPCError(t, err, codes.InvalidArgument)
 }
 
+func TestCopyRenamePhoto_KeepsLivePhotoFields(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	server := &LibraryServer{DB: db, GCSClient: newCopyDeleteGCSClient(t), BucketName: "photos"}
+	ctx := contextWithUserID(1)
+
+	duration := 2.5
+	source := &database.PhotoObject{ObjectID: "2024/a.mov", ContentType: "video/quicktime", SizeBytes: 3, ContentIdentifier: "ABC", DurationSeconds: &duration, UserID: 1}
+	if err := db.Create(source).Error; err != nil {
+		t.Fatalf("failed to create photo: %v", err)
+	}
+
+	if _, err := server.CopyPhoto(ctx, &proto.CopyPhotoRequest{SourceObjectId: "2024/a.mov", DestinationObjectId: "2024/b.mov"}); err != nil {
+		t.Fatalf("CopyPhoto() error = %v", err)
+	}
+	if _, err := server.RenamePhoto(ctx, &proto.RenamePhotoRequest{SourceObjectId: "2024/b.mov", DestinationObjectId: "2024/c.mov"}); err != nil {
+		t.Fatalf("RenamePhoto() error = %v", err)
+	}
+
+	var renamed database.PhotoObject
+	if err := db.Where("object_id = ?", "2024/c.mov").First(&renamed).Error; err != nil {
+		t.Fatalf("failed to find renamed photo: %v", err)
+	}
+	if renamed.ContentIdentifier != "ABC" {
+		t.Errorf("ContentIdentifier = %q, want %q", renamed.ContentIdentifier, "ABC")
+	}
+	if renamed.DurationSeconds == nil || *renamed.DurationSeconds != duration {
+		t.Errorf("DurationSeconds = %v, want %v", renamed.DurationSeconds, duration)
+	}
+}
+
 func TestCopyPhoto_ValidationErrors(t *testing.T) {
 	server := &LibraryServer{}
 	ctx := contextWithUserID(1)
@@ -1025,7 +1055,7 @@ func setupLibraryTestDB(t *testing.T) *gorm.DB {
 	if err != nil {
 		t.Fatalf("failed to open test database: %v", err)
 	}
-	if err := db.AutoMigrate(&database.PhotoObject{}, &database.PhotoDirectory{}, &database.User{}); err != nil {
+	if err := db.AutoMigrate(&database.PhotoObject{}, &database.PhotoDirectory{}, &database.User{}, &database.PhotoSidecar{}, &database.PhotoRendition{}, &database.DerivedObject{}, &database.PhotoStack{}, &database.Job{}, &database.ScheduledRun{}, &database.OperationLock{}, &database.SyncCursor{}, &database.PhotoChange{}); err != nil {
 		t.Fatalf("failed to migrate database: %v", err)
 	}
 	return db
@@ -2124,8 +2154,8 @@ func TestUpdateWebp_Unauthenticated(t *testing.T) {
 }
 
 // TestUpdateWebp_NoEligibleObjects verifies that when the database contains no
-// eligible PhotoObject rows (all have webp_object_id set or are derived
-// assets), UpdateWebp streams a single complete summary message with zero
+// eligible PhotoObject rows (all have webp_object_id set or are recorded
+// derived assets), UpdateWebp streams a single complete summary message with zero
 // counts and no per-object messages.
 func TestUpdateWebp_NoEligibleObjects(t *testing.T) {
 	db := setupLibraryTestDB(t)
@@ -2151,6 +2181,7 @@ func TestUpdateWebp_NoEligibleObjects(t *testing.T) {
 			t.Fatalf("failed to seed photo object: %v", err)
 		}
 	}
+	recordDerivedObject(context.Background(), db, 1, database.DerivedKindWebP, "photos/derived.jpg", "photos/derived.webp", 0)
 
 	server := &LibraryServer{DB: db, BucketName: "test-bucket"}
 	stream := newMockUpdateWebpStream(contextWithUserID(1))
@@ -2181,7 +2212,8 @@ func TestUpdateWebp_NoEligibleObjects(t *testing.T) {
 }
 
 // TestUpdateWebp_EligibilityFilter verifies that only rows with an empty
-// webp_object_id and a non-derived object_id are processed. With a nil GCS
+// webp_object_id and an object_id not recorded as a derived asset are
+// processed. With a nil GCS
 // client the per-object generation will fail, so the test asserts that exactly
 // the eligible rows produce per-object progress messages and that the final
 // summary reports the expected failed count.
@@ -2221,6 +2253,7 @@ func TestUpdateWebp_EligibilityFilter(t *testing.T) {
 			t.Fatalf("failed to seed photo object: %v", err)
 		}
 	}
+	recordDerivedObject(context.Background(), db, 1, database.DerivedKindWebP, "photos/derived.jpg", "photos/derived.webp", 0)
 
 	// Nil GCSClient: bucket.Attrs / NewReader will fail, so eligible objects
 	// are counted as failed rather than generated. This still exercises the
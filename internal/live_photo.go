@@ -0,0 +1,558 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"log/slog"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/alexhokl/photos/database"
+	"gorm.io/gorm"
+)
+
+// appleMakerNotePrefix starts the maker note of photos taken by iPhones.
+const appleMakerNotePrefix = "Apple iOS\x00"
+
+// appleContentIdentifierTag is the tag of the Apple maker note holding the
+// ContentIdentifier of a Live Photo.
+const appleContentIdentifierTag = 0x0011
+
+// quickTimeContentIdentifierKey is the QuickTime metadata key holding the
+// ContentIdentifier of a Live Photo video.
+const quickTimeContentIdentifierKey = "com.apple.quicktime.content.identifier"
+
+var (
+	// microVideoOffsetPattern matches the offset, from the end of the file,
+	// of the video of an older (MVIMG) Android motion photo
+	microVideoOffsetPattern = regexp.MustCompile(`MicroVideoOffset="(\d+)"`)
+	// containerItemPattern matches the items of the XMP container directory
+	// of a newer Android motion photo
+	containerItemPattern = regexp.MustCompile(`<Container:Item\b[^>]*>`)
+	// itemLengthPattern matches the length of a container item
+	itemLengthPattern = regexp.MustCompile(`Item:Length="(\d+)"`)
+)
+
+// motionVideoObjectID returns the GCS object ID of the video extracted from a
+// motion photo. "_motion.mp4" is appended to the object ID, extension
+// included.
+// Example:
+//
+//	"dir1/dir2/PXL_0001.MP.jpg" → "dir1/dir2/PXL_0001.MP.jpg_motion.mp4"
+func motionVideoObjectID(objectID string) string {
+	return objectID + "_motion.mp4"
+}
+
+// appleContentIdentifier returns the ContentIdentifier in the Apple maker
+// note makerNote, or an empty string if there is none. The maker note is a
+// TIFF IFD following a 14-byte header, whose offsets are relative to the
+// start of the maker note.
+func appleContentIdentifier(makerNote []byte) string {
+	if !bytes.HasPrefix(makerNote, []byte(appleMakerNotePrefix)) || len(makerNote) < 16 {
+		return ""
+	}
+	var order binary.ByteOrder
+	switch string(makerNote[12:14]) {
+	case "MM":
+		order = binary.BigEndian
+	case "II":
+		order = binary.LittleEndian
+	default:
+		return ""
+	}
+
+	count := int(order.Uint16(makerNote[14:16]))
+	for i := range count {
+		entry := 16 + i*12
+		if entry+12 > len(makerNote) {
+			return ""
+		}
+		if order.Uint16(makerNote[entry:entry+2]) != appleContentIdentifierTag {
+			continue
+		}
+		// An ASCII value of more than 4 bytes is stored at an offset
+		length := int(order.Uint32(makerNote[entry+4 : entry+8]))
+		value := makerNote[entry+8 : entry+12]
+		if length > 4 {
+			offset := int(order.Uint32(makerNote[entry+8 : entry+12]))
+			if offset < 0 || length > len(makerNote) || offset > len(makerNote)-length {
+				return ""
+			}
+			value = makerNote[offset : offset+length]
+		} else {
+			value = value[:length]
+		}
+		return strings.TrimRight(string(value), "\x00")
+	}
+	return ""
+}
+
+// readQuickTimeContentIdentifier returns the ContentIdentifier of an iPhone
+// Live Photo video, or an empty string if there is none. It is stored in
+// the QuickTime metadata of the movie: the "keys" box of moov/meta lists the
+// keys, and the "ilst" box holds a box per value, whose type is the 1-based
+// index of its key.
+func readQuickTimeContentIdentifier(data []byte) string {
+	moovs := mp4Boxes(data, "moov")
+	if len(moovs) == 0 {
+		return ""
+	}
+	metas := mp4Boxes(moovs[0], "meta")
+	if len(metas) == 0 {
+		return ""
+	}
+	meta := metas[0]
+	// The meta box of MP4 files has a version and flags, unlike that of
+	// QuickTime
+	if len(mp4Boxes(meta, "keys")) == 0 && len(meta) >= 4 {
+		meta = meta[4:]
+	}
+	keysBoxes := mp4Boxes(meta, "keys")
+	ilsts := mp4Boxes(meta, "ilst")
+	if len(keysBoxes) == 0 || len(ilsts) == 0 {
+		return ""
+	}
+
+	keys := keysBoxes[0]
+	if len(keys) < 8 {
+		return ""
+	}
+	index := uint32(0)
+	count := binary.BigEndian.Uint32(keys[4:8])
+	entries := keys[8:]
+	for i := uint32(1); i <= count && len(entries) >= 8; i++ {
+		size := binary.BigEndian.Uint32(entries[0:4])
+		if size < 8 || uint64(size) > uint64(len(entries)) {
+			return ""
+		}
+		if string(entries[8:size]) == quickTimeContentIdentifierKey {
+			index = i
+			break
+		}
+		entries = entries[size:]
+	}
+	if index == 0 {
+		return ""
+	}
+
+	var indexType [4]byte
+	binary.BigEndian.PutUint32(indexType[:], index)
+	for _, item := range mp4Boxes(ilsts[0], string(indexType[:])) {
+		for _, value := range mp4Boxes(item, "data") {
+			// The value follows its type and locale
+			if len(value) > 8 {
+				return string(value[8:])
+			}
+		}
+	}
+	return ""
+}
+
+// ExtractMotionPhotoVideo returns the MP4 video appended to an Android motion
+// photo JPEG, or nil if data is not one. Its length is taken from the XMP of
+// the photo: MicroVideoOffset for older motion photos and the length of the
+// MotionPhoto item of the container directory for newer ones. Without a
+// length the last MP4 file type box marks its start.
+func ExtractMotionPhotoVideo(data []byte) []byte {
+	xmpStart := bytes.Index(data, []byte("<x:xmpmeta"))
+	if xmpStart < 0 {
+		return nil
+	}
+	xmpEnd := bytes.Index(data[xmpStart:], []byte("</x:xmpmeta>"))
+	if xmpEnd < 0 {
+		return nil
+	}
+	xmp := data[xmpStart : xmpStart+xmpEnd]
+	if !bytes.Contains(xmp, []byte("MicroVideo")) && !bytes.Contains(xmp, []byte("MotionPhoto")) {
+		return nil
+	}
+
+	length := 0
+	if m := microVideoOffsetPattern.FindSubmatch(xmp); m != nil {
+		length, _ = strconv.Atoi(string(m[1]))
+	}
+	for _, item := range containerItemPattern.FindAll(xmp, -1) {
+		if !bytes.Contains(item, []byte(`Item:Semantic="MotionPhoto"`)) {
+			continue
+		}
+		if m := itemLengthPattern.FindSubmatch(item); m != nil {
+			length, _ = strconv.Atoi(string(m[1]))
+		}
+	}
+
+	var video []byte
+	if length > 0 && length < len(data) {
+		video = data[len(data)-length:]
+	} else if i := bytes.LastIndex(data, []byte("ftyp")); i >= 4 {
+		video = data[i-4:]
+	}
+	if len(video) < 8 || string(video[4:8]) != "ftyp" {
+		return nil
+	}
+	return video
+}
+
+// uploadMotionVideo extracts the video of an Android motion photo, uploads it
+// to GCS and sets MotionVideoObjectID on photoObject. It does nothing for
+// other photos. Errors are logged but not fatal.
+func uploadMotionVideo(ctx context.Context, bucket *storage.BucketHandle, data []byte, objectID string, photoObject *database.PhotoObject) {
+	video := ExtractMotionPhotoVideo(data)
+	if video == nil {
+		return
+	}
+
+	videoID := motionVideoObjectID(objectID)
+	if err := writeDerivedObject(ctx, bucket, database.DerivedKindMotionVideo, objectID, videoID, "video/mp4", video); err != nil {
+		slog.WarnContext(ctx, "failed to store motion photo video",
+			slog.String("object_id", objectID),
+			slog.String("error", err.Error()),
+		)
+		return
+	}
+	photoObject.MotionVideoObjectID = &videoID
+
+	slog.InfoContext(ctx, "Extracted motion photo video",
+		slog.String("object_id", objectID),
+		slog.String("motion_video_object_id", videoID),
+	)
+}
+
+// companionObjectID returns the video played with photoObject: the video of
+// a Live Photo or the video extracted from a motion photo, or an empty
+// string if there is none.
+func companionObjectID(photoObject *database.PhotoObject) string {
+	if photoObject.CompanionObjectID != nil && *photoObject.CompanionObjectID != "" {
+		return *photoObject.CompanionObjectID
+	}
+	if photoObject.MotionVideoObjectID != nil {
+		return *photoObject.MotionVideoObjectID
+	}
+	return ""
+}
+
+// isLivePhotoStillContentType reports whether a photo of contentType can be
+// the still of a Live Photo.
+func isLivePhotoStillContentType(contentType string) bool {
+	return IsHEICContentType(contentType) || contentType == "image/jpeg"
+}
+
+// companionsQuery returns a subquery selecting the object IDs of the user's
+// Live Photo videos, which are not listed on their own.
+func companionsQuery(db *gorm.DB, userID uint) *gorm.DB {
+	return db.Model(&database.PhotoObject{}).
+		Select("companion_object_id").
+		Where("user_id = ? AND companion_object_id IS NOT NULL", userID)
+}
+
+// findLivePhotoPartner returns the other half of the Live Photo photoObject
+// belongs to: the video of a still or the still of a video, or nil if there
+// is none. The two are paired by their ContentIdentifier or, failing that,
+// by sharing a directory and basename, unless both have an identifier and
+// they differ. Photos already paired with another are skipped.
+func findLivePhotoPartner(db *gorm.DB, photoObject *database.PhotoObject) (*database.PhotoObject, error) {
+	isStill := isLivePhotoStillContentType(photoObject.ContentType)
+	if !isStill && !IsVideoContentType(photoObject.ContentType) {
+		return nil, nil
+	}
+
+	objectID := photoObject.ObjectID
+	base := strings.TrimSuffix(objectID, path.Ext(objectID))
+	query := db.Where("user_id = ? AND object_id != ?", photoObject.UserID, objectID)
+	if photoObject.ContentIdentifier != "" {
+		query = query.Where("content_identifier = ? OR object_id LIKE ?", photoObject.ContentIdentifier, base+".%")
+	} else {
+		query = query.Where("object_id LIKE ?", base+".%")
+	}
+	var candidates []database.PhotoObject
+	if err := query.Order("object_id ASC").Find(&candidates).Error; err != nil {
+		return nil, err
+	}
+
+	var byName *database.PhotoObject
+	for i := range candidates {
+		candidate := &candidates[i]
+		if isStill && !IsVideoContentType(candidate.ContentType) ||
+			!isStill && !isLivePhotoStillContentType(candidate.ContentType) {
+			continue
+		}
+		if isDerivedObject(db, candidate.ObjectID) {
+			continue
+		}
+		sameIdentifier := photoObject.ContentIdentifier != "" && candidate.ContentIdentifier == photoObject.ContentIdentifier
+		// LIKE treats "_" and "%" in the basename as wildcards, so confirm
+		// the match exactly.
+		sameName := strings.TrimSuffix(candidate.ObjectID, path.Ext(candidate.ObjectID)) == base
+		if !sameIdentifier && (!sameName || photoObject.ContentIdentifier != "" && candidate.ContentIdentifier != "") {
+			continue
+		}
+
+		paired, err := pairedElsewhere(db, photoObject, candidate, isStill)
+		if err != nil {
+			return nil, err
+		}
+		if paired {
+			continue
+		}
+		if sameIdentifier {
+			return candidate, nil
+		}
+		if byName == nil {
+			byName = candidate
+		}
+	}
+	return byName, nil
+}
+
+// pairedElsewhere reports whether candidate already forms a Live Photo with
+// a photo other than photoObject.
+func pairedElsewhere(db *gorm.DB, photoObject, candidate *database.PhotoObject, isStill bool) (bool, error) {
+	if !isStill {
+		return candidate.CompanionObjectID != nil && *candidate.CompanionObjectID != "" &&
+			*candidate.CompanionObjectID != photoObject.ObjectID, nil
+	}
+	var count int64
+	if err := db.Model(&database.PhotoObject{}).
+		Where("user_id = ? AND companion_object_id = ? AND object_id != ?", candidate.UserID, candidate.ObjectID, photoObject.ObjectID).
+		Count(&count).Error; err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// pairLivePhoto links photoObject with the other half of its Live Photo, if
+// there is one (see findLivePhotoPartner), setting CompanionObjectID on the
+// still, and on photoObject if it is the still. It reports whether a pair
+// was linked. Errors are logged but not fatal.
+func pairLivePhoto(ctx context.Context, db *gorm.DB, photoObject *database.PhotoObject) bool {
+	if photoObject.CompanionObjectID != nil && *photoObject.CompanionObjectID != "" {
+		return false
+	}
+
+	partner, err := findLivePhotoPartner(db, photoObject)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to find Live Photo pair",
+			slog.String("object_id", photoObject.ObjectID),
+			slog.String("error", err.Error()),
+		)
+		return false
+	}
+	if partner == nil {
+		return false
+	}
+
+	still, video := photoObject, partner
+	if IsVideoContentType(photoObject.ContentType) {
+		still, video = partner, photoObject
+	}
+	if still.CompanionObjectID != nil && *still.CompanionObjectID == video.ObjectID {
+		return false
+	}
+
+	_, dbSpan := startSpan(ctx, "db.update_companion_object_id")
+	if err := withPhotoChange(db, still.UserID, database.ChangeKindUpdated, still.ObjectID, func(tx *gorm.DB) error {
+		return tx.Model(&database.PhotoObject{}).
+			Where("object_id = ? AND user_id = ?", still.ObjectID, still.UserID).
+			Update("companion_object_id", video.ObjectID).Error
+	}); err != nil {
+		recordSpanError(dbSpan, err)
+		slog.WarnContext(ctx, "failed to pair Live Photo",
+			slog.String("object_id", still.ObjectID),
+			slog.String("companion_object_id", video.ObjectID),
+			slog.String("error", err.Error()),
+		)
+		return false
+	}
+	endSpanOk(dbSpan)
+	videoID := video.ObjectID
+	still.CompanionObjectID = &videoID
+
+	slog.InfoContext(ctx, "Paired Live Photo",
+		slog.String("object_id", still.ObjectID),
+		slog.String("companion_object_id", videoID),
+	)
+	return true
+}
+
+// relinkCompanion points the still whose Live Photo video is objectID at
+// newObjectID instead, or unpairs it if newObjectID is nil, after the video
+// itself has been renamed or deleted. Errors are logged but not fatal.
+func relinkCompanion(ctx context.Context, db *gorm.DB, userID uint, objectID string, newObjectID *string) {
+	_, dbSpan := startSpan(ctx, "db.update_companion_object_id")
+	if err := updateCompanions(db, userID, db.Where("companion_object_id = ?", objectID), newObjectID); err != nil {
+		recordSpanError(dbSpan, err)
+		slog.WarnContext(ctx, "failed to update Live Photo pair",
+			slog.String("companion_object_id", objectID),
+			slog.String("error", err.Error()),
+		)
+		return
+	}
+	endSpanOk(dbSpan)
+}
+
+// updateCompanions points the user's stills matched by condition at the
+// video companionObjectID, or unpairs them if it is nil, and records them as
+// updated in the change log.
+func updateCompanions(db *gorm.DB, userID uint, condition *gorm.DB, companionObjectID *string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var objectIDs []string
+		if err := tx.Model(&database.PhotoObject{}).
+			Where("user_id = ?", userID).
+			Where(condition).
+			Pluck("object_id", &objectIDs).Error; err != nil {
+			return err
+		}
+		if len(objectIDs) == 0 {
+			return nil
+		}
+		if err := tx.Model(&database.PhotoObject{}).
+			Where("user_id = ? AND object_id IN ?", userID, objectIDs).
+			Update("companion_object_id", companionObjectID).Error; err != nil {
+			return err
+		}
+		return recordPhotoChange(tx, userID, database.ChangeKindUpdated, objectIDs...)
+	})
+}
+
+// copyCompanion copies the Live Photo video of sourcePhoto, with its derived
+// assets and thumbnails, so that it sits next to destPhotoID with the same
+// basename, and pairs it with destPhotoID. If move is true the source video
+// is removed afterwards. Errors are logged but not fatal, as the photo itself
+// has already been copied.
+func copyCompanion(ctx context.Context, db *gorm.DB, bucket *storage.BucketHandle, userID uint, sourcePhoto *database.PhotoObject, destPhotoID string, move bool) {
+	if sourcePhoto.CompanionObjectID == nil || *sourcePhoto.CompanionObjectID == "" {
+		return
+	}
+	var source database.PhotoObject
+	if err := db.Where("object_id = ? AND user_id = ?", *sourcePhoto.CompanionObjectID, userID).First(&source).Error; err != nil {
+		slog.WarnContext(ctx, "failed to find Live Photo video",
+			slog.String("object_id", sourcePhoto.ObjectID),
+			slog.String("companion_object_id", *sourcePhoto.CompanionObjectID),
+			slog.String("error", err.Error()),
+		)
+		return
+	}
+
+	destID := strings.TrimSuffix(destPhotoID, path.Ext(destPhotoID)) + path.Ext(source.ObjectID)
+	if destID == destPhotoID {
+		return
+	}
+	_, copySpan := startSpan(ctx, "gcs.copy_object")
+	if _, err := bucket.Object(destID).CopierFrom(bucket.Object(source.ObjectID)).Run(ctx); err != nil {
+		recordSpanError(copySpan, err)
+		slog.WarnContext(ctx, "failed to copy Live Photo video",
+			slog.String("companion_object_id", source.ObjectID),
+			slog.String("destination", destID),
+			slog.String("error", err.Error()),
+		)
+		return
+	}
+	endSpanOk(copySpan)
+
+	dest := source
+	dest.Model = gorm.Model{}
+	dest.ObjectID = destID
+	// The derived assets are referenced as they are copied below
+	dest.ThumbnailObjectID, dest.WebpObjectID, dest.AvifObjectID = nil, nil, nil
+	dest.ProxyObjectID, dest.HLSObjectID, dest.AnimatedPreviewObjectID = nil, nil, nil
+	_, createSpan := startSpan(ctx, "db.create_or_restore_photo_object")
+	if err := withPhotoChange(db, userID, database.ChangeKindCreated, destID, func(tx *gorm.DB) error {
+		return database.CreateOrRestorePhotoObject(tx, &dest)
+	}); err != nil {
+		recordSpanError(createSpan, err)
+		slog.WarnContext(ctx, "failed to create Live Photo video record",
+			slog.String("companion_object_id", destID),
+			slog.String("error", err.Error()),
+		)
+		return
+	}
+	endSpanOk(createSpan)
+
+	copyDerivedObjects(ctx, db, bucket, userID, source.ObjectID, destID, move)
+	copyRenditions(ctx, db, bucket, userID, source.ObjectID, destID, move)
+	referenceDerivedObject(ctx, db, userID, destPhotoID, "companion_object_id", destID)
+
+	if move {
+		deletePhotoObject(ctx, db, bucket, &source)
+	}
+}
+
+// deleteCompanion deletes the Live Photo video of photoObject, with its
+// derived assets and thumbnails. Errors are logged but not fatal.
+func deleteCompanion(ctx context.Context, db *gorm.DB, bucket *storage.BucketHandle, photoObject *database.PhotoObject) {
+	if photoObject.CompanionObjectID == nil || *photoObject.CompanionObjectID == "" {
+		return
+	}
+	var companion database.PhotoObject
+	if err := db.Where("object_id = ? AND user_id = ?", *photoObject.CompanionObjectID, photoObject.UserID).First(&companion).Error; err != nil {
+		return
+	}
+	deleteDerivedObjects(ctx, db, bucket, companion.UserID, companion.ObjectID)
+	deletePhotoObject(ctx, db, bucket, &companion)
+}
+
+// deletePhotoObject removes a photo object from GCS and its database record.
+// Errors are logged but not fatal.
+func deletePhotoObject(ctx context.Context, db *gorm.DB, bucket *storage.BucketHandle, photoObject *database.PhotoObject) {
+	_, gcsDelSpan := startSpan(ctx, "gcs.delete_object")
+	if err := bucket.Object(photoObject.ObjectID).Delete(ctx); err != nil && !errors.Is(err, storage.ErrObjectNotExist) {
+		recordSpanError(gcsDelSpan, err)
+		slog.WarnContext(ctx, "failed to delete Live Photo video from storage",
+			slog.String("companion_object_id", photoObject.ObjectID),
+			slog.String("error", err.Error()),
+		)
+	} else {
+		endSpanOk(gcsDelSpan)
+	}
+
+	_, dbDelSpan := startSpan(ctx, "db.delete_photo")
+	if err := withPhotoChange(db, photoObject.UserID, database.ChangeKindDeleted, photoObject.ObjectID, func(tx *gorm.DB) error {
+		return tx.Delete(photoObject).Error
+	}); err != nil {
+		recordSpanError(dbDelSpan, err)
+		slog.WarnContext(ctx, "failed to delete Live Photo video record",
+			slog.String("companion_object_id", photoObject.ObjectID),
+			slog.String("error", err.Error()),
+		)
+		return
+	}
+	endSpanOk(dbDelSpan)
+}
+
+// syncLivePhotos pairs the user's Live Photo stills and videos that are not
+// yet paired, such as those uploaded before pairing existed or synced from
+// the bucket, and unpairs stills whose video no longer exists. It returns the
+// number of pairs linked.
+func (s *LibraryServer) syncLivePhotos(ctx context.Context, userID uint) (int, error) {
+	_, unpairSpan := startSpan(ctx, "db.update_companion_object_id")
+	if err := updateCompanions(s.DB, userID, s.DB.Where("companion_object_id IS NOT NULL").
+		Where("companion_object_id NOT IN (?)", s.DB.Model(&database.PhotoObject{}).Select("object_id").Where("user_id = ?", userID)),
+		nil); err != nil {
+		recordSpanError(unpairSpan, err)
+		return 0, err
+	}
+	endSpanOk(unpairSpan)
+
+	var unpaired []database.PhotoObject
+	_, dbListSpan := startSpan(ctx, "db.list_photo_objects")
+	if err := s.DB.Where("user_id = ? AND (companion_object_id IS NULL OR companion_object_id = '')", userID).
+		Where("content_type LIKE ?", "image/%").
+		Order("object_id ASC").
+		Find(&unpaired).Error; err != nil {
+		recordSpanError(dbListSpan, err)
+		return 0, err
+	}
+	endSpanOk(dbListSpan)
+
+	paired := 0
+	for i := range unpaired {
+		if isLivePhotoStillContentType(unpaired[i].ContentType) && pairLivePhoto(ctx, s.DB, &unpaired[i]) {
+			paired++
+		}
+	}
+	return paired, nil
+}
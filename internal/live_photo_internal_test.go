@@ -0,0 +1,289 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"strconv"
+	"testing"
+
+	"github.com/alexhokl/photos/database"
+	"github.com/alexhokl/photos/proto"
+)
+
+func TestMotionVideoObjectID(t *testing.T) {
+	tests := []struct {
+		objectID string
+		expected string
+	}{
+		{"dir1/dir2/PXL_0001.MP.jpg", "dir1/dir2/PXL_0001.MP.jpg_motion.mp4"},
+		{"MVIMG_0001.jpg", "MVIMG_0001.jpg_motion.mp4"},
+		{"noext", "noext_motion.mp4"},
+	}
+	for _, test := range tests {
+		if got := motionVideoObjectID(test.objectID); got != test.expected {
+			t.Errorf("motionVideoObjectID(%q) = %q, want %q", test.objectID, got, test.expected)
+		}
+		if got := derivedObjectID(database.DerivedKindMotionVideo, test.objectID); got != test.expected {
+			t.Errorf("derivedObjectID(motion_video, %q) = %q, want %q", test.objectID, got, test.expected)
+		}
+	}
+	if got := derivedObjectColumn(database.DerivedKindMotionVideo, "a_motion.mp4"); got != "motion_video_object_id" {
+		t.Errorf("derivedObjectColumn(motion_video) = %q, want motion_video_object_id", got)
+	}
+}
+
+// appleMakerNote returns a big-endian Apple maker note whose only entry is
+// the ContentIdentifier id.
+func appleMakerNote(id string) []byte {
+	note := []byte(appleMakerNotePrefix)
+	note = append(note, 0, 1, 'M', 'M')
+	note = binary.BigEndian.AppendUint16(note, 1)
+	note = binary.BigEndian.AppendUint16(note, appleContentIdentifierTag)
+	note = binary.BigEndian.AppendUint16(note, 2)
+	note = binary.BigEndian.AppendUint32(note, uint32(len(id)+1))
+	// the value follows the entry and the offset of the next IFD
+	note = binary.BigEndian.AppendUint32(note, uint32(len(note)+8))
+	note = binary.BigEndian.AppendUint32(note, 0)
+	return append(append(note, id...), 0)
+}
+
+func TestAppleContentIdentifier(t *testing.T) {
+	const id = "3F2504E0-4F89-11D3-9A0C-0305E82C3301"
+
+	if got := appleContentIdentifier(appleMakerNote(id)); got != id {
+		t.Errorf("appleContentIdentifier() = %q, want %q", got, id)
+	}
+	if got := appleContentIdentifier([]byte("Nikon\x00\x02\x10\x00\x00MM\x00\x00")); got != "" {
+		t.Errorf("appleContentIdentifier() of a Nikon maker note = %q, want empty", got)
+	}
+	// an offset past the end of the maker note is ignored
+	truncated := appleMakerNote(id)
+	if got := appleContentIdentifier(truncated[:len(truncated)-10]); got != "" {
+		t.Errorf("appleContentIdentifier() of a truncated maker note = %q, want empty", got)
+	}
+}
+
+// liveVideo returns a QuickTime movie whose metadata holds the
+// ContentIdentifier id after another key.
+func liveVideo(id string) []byte {
+	key := func(name string) []byte {
+		entry := binary.BigEndian.AppendUint32(nil, uint32(8+len(name)))
+		return append(append(entry, "mdta"...), name...)
+	}
+	keys := binary.BigEndian.AppendUint32(make([]byte, 4), 2)
+	keys = append(keys, key("com.apple.quicktime.make")...)
+	keys = append(keys, key(quickTimeContentIdentifierKey)...)
+	value := func(index uint32, v string) []byte {
+		var boxType [4]byte
+		binary.BigEndian.PutUint32(boxType[:], index)
+		return mp4Box(string(boxType[:]), mp4Box("data", make([]byte, 8), []byte(v)))
+	}
+
+	return append(
+		mp4Box("ftyp", []byte("qt  ")),
+		mp4Box("moov",
+			mp4Box("meta",
+				mp4Box("hdlr", make([]byte, 24)),
+				mp4Box("keys", keys),
+				mp4Box("ilst", value(1, "Apple"), value(2, id)),
+			),
+		)...,
+	)
+}
+
+func TestReadQuickTimeContentIdentifier(t *testing.T) {
+	const id = "3F2504E0-4F89-11D3-9A0C-0305E82C3301"
+
+	if got := readQuickTimeContentIdentifier(liveVideo(id)); got != id {
+		t.Errorf("readQuickTimeContentIdentifier() = %q, want %q", got, id)
+	}
+	if got := ExtractPhotoMetadata(liveVideo(id), "IMG_0001.MOV").ContentIdentifier; got != id {
+		t.Errorf("ExtractPhotoMetadata().ContentIdentifier = %q, want %q", got, id)
+	}
+	if got := readQuickTimeContentIdentifier(mp4Box("ftyp", []byte("isom"))); got != "" {
+		t.Errorf("readQuickTimeContentIdentifier() without metadata = %q, want empty", got)
+	}
+}
+
+func TestContentIdentifierGCSMetadata(t *testing.T) {
+	info := &PhotoMetadataInfo{ContentIdentifier: "abc"}
+
+	metadata := info.ToGCSMetadata()
+	if metadata[MetadataKeyContentIdentifier] != "abc" {
+		t.Errorf("content identifier metadata = %q, want abc", metadata[MetadataKeyContentIdentifier])
+	}
+	if got := ParseGCSMetadata(metadata).ContentIdentifier; got != "abc" {
+		t.Errorf("parsed ContentIdentifier = %q, want abc", got)
+	}
+}
+
+func TestExtractMotionPhotoVideo(t *testing.T) {
+	jpeg := []byte("\xff\xd8\xff\xe1")
+	video := mp4Box("ftyp", []byte("isom"), []byte("mp41"))
+	video = append(video, mp4Box("mdat", []byte("frames"))...)
+	tests := []struct {
+		name string
+		xmp  string
+		want []byte
+	}{
+		{
+			"MicroVideoOffset",
+			`<x:xmpmeta><rdf:Description GCamera:MicroVideo="1" GCamera:MicroVideoOffset="` + strconv.Itoa(len(video)) + `"/></x:xmpmeta>`,
+			video,
+		},
+		{
+			"container directory",
+			`<x:xmpmeta><Camera:MotionPhoto>1</Camera:MotionPhoto><Container:Directory><rdf:Seq>` +
+				`<rdf:li><Container:Item Item:Semantic="Primary" Item:Mime="image/jpeg"/></rdf:li>` +
+				`<rdf:li><Container:Item Item:Mime="video/mp4" Item:Semantic="MotionPhoto" Item:Length="` + strconv.Itoa(len(video)) + `"/></rdf:li>` +
+				`</rdf:Seq></Container:Directory></x:xmpmeta>`,
+			video,
+		},
+		{
+			"no length",
+			`<x:xmpmeta><rdf:Description GCamera:MotionPhoto="1"/></x:xmpmeta>`,
+			video,
+		},
+		{
+			"not a motion photo",
+			`<x:xmpmeta><rdf:Description xmp:Rating="5"/></x:xmpmeta>`,
+			nil,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			data := append(append(append([]byte{}, jpeg...), test.xmp...), "\xff\xd9"...)
+			data = append(data, video...)
+
+			if got := ExtractMotionPhotoVideo(data); !bytes.Equal(got, test.want) {
+				t.Errorf("ExtractMotionPhotoVideo() = %q, want %q", got, test.want)
+			}
+		})
+	}
+
+	if got := ExtractMotionPhotoVideo(jpeg); got != nil {
+		t.Errorf("ExtractMotionPhotoVideo() without XMP = %q, want nil", got)
+	}
+}
+
+func TestPairLivePhoto(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	for _, obj := range []database.PhotoObject{
+		// paired by name
+		{ObjectID: "trip/IMG_0001.HEIC", ContentType: "image/heic", MD5Hash: "h1", UserID: 1},
+		{ObjectID: "trip/IMG_0001.MOV", ContentType: "video/quicktime", MD5Hash: "h2", UserID: 1},
+		// paired by identifier
+		{ObjectID: "trip/IMG_E0002.JPG", ContentType: "image/jpeg", MD5Hash: "h3", UserID: 1, ContentIdentifier: "id-2"},
+		{ObjectID: "trip/IMG_0002.MOV", ContentType: "video/quicktime", MD5Hash: "h4", UserID: 1, ContentIdentifier: "id-2"},
+		// same name, different identifiers
+		{ObjectID: "trip/IMG_0003.HEIC", ContentType: "image/heic", MD5Hash: "h5", UserID: 1, ContentIdentifier: "id-3"},
+		{ObjectID: "trip/IMG_0003.MOV", ContentType: "video/quicktime", MD5Hash: "h6", UserID: 1, ContentIdentifier: "other"},
+		// another user's video
+		{ObjectID: "trip/IMG_0004.HEIC", ContentType: "image/heic", MD5Hash: "h7", UserID: 1},
+		{ObjectID: "trip/IMG_0004.MOV", ContentType: "video/quicktime", MD5Hash: "h8", UserID: 2},
+	} {
+		if err := db.Create(&obj).Error; err != nil {
+			t.Fatalf("failed to seed photo object: %v", err)
+		}
+	}
+
+	tests := []struct {
+		objectID  string
+		companion string
+	}{
+		{"trip/IMG_0001.MOV", "trip/IMG_0001.MOV"},
+		{"trip/IMG_E0002.JPG", "trip/IMG_0002.MOV"},
+		{"trip/IMG_0003.HEIC", ""},
+		{"trip/IMG_0004.HEIC", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.objectID, func(t *testing.T) {
+			var photoObject database.PhotoObject
+			if err := db.Where("object_id = ?", test.objectID).First(&photoObject).Error; err != nil {
+				t.Fatalf("failed to load photo object: %v", err)
+			}
+
+			paired := pairLivePhoto(context.Background(), db, &photoObject)
+
+			if paired != (test.companion != "") {
+				t.Errorf("pairLivePhoto() = %v, want %v", paired, test.companion != "")
+			}
+			var still database.PhotoObject
+			if err := db.Where("companion_object_id = ?", test.companion).First(&still).Error; test.companion != "" && err != nil {
+				t.Errorf("no still paired with %s: %v", test.companion, err)
+			}
+		})
+	}
+
+	// a paired video is not paired again with another still
+	var other database.PhotoObject
+	if err := db.Create(&database.PhotoObject{ObjectID: "trip/IMG_0001.JPG", ContentType: "image/jpeg", MD5Hash: "h9", UserID: 1}).Error; err != nil {
+		t.Fatalf("failed to seed photo object: %v", err)
+	}
+	db.Where("object_id = ?", "trip/IMG_0001.JPG").First(&other)
+	if pairLivePhoto(context.Background(), db, &other) {
+		t.Error("expected a paired video not to be paired again")
+	}
+}
+
+func TestListPhotos_HidesLivePhotoVideos(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	companion := "trip/IMG_0001.MOV"
+	for _, obj := range []database.PhotoObject{
+		{ObjectID: "trip/IMG_0001.HEIC", ContentType: "image/heic", MD5Hash: "h1", UserID: 1, CompanionObjectID: &companion},
+		{ObjectID: companion, ContentType: "video/quicktime", MD5Hash: "h2", UserID: 1},
+		{ObjectID: "trip/clip.mov", ContentType: "video/quicktime", MD5Hash: "h3", UserID: 1},
+	} {
+		if err := db.Create(&obj).Error; err != nil {
+			t.Fatalf("failed to seed photo object: %v", err)
+		}
+	}
+	server := &LibraryServer{DB: db}
+
+	resp, err := server.ListPhotos(contextWithUserID(1), &proto.ListPhotosRequest{Prefix: "trip/"})
+	if err != nil {
+		t.Fatalf("ListPhotos failed: %v", err)
+	}
+
+	if resp.TotalCount != 2 || len(resp.Photos) != 2 {
+		t.Fatalf("expected the still and the other video, got %d of %d", len(resp.Photos), resp.TotalCount)
+	}
+	for _, photo := range resp.Photos {
+		if photo.ObjectId == companion {
+			t.Errorf("expected %s to be hidden", companion)
+		}
+		if photo.ObjectId == "trip/IMG_0001.HEIC" && photo.CompanionObjectId != companion {
+			t.Errorf("CompanionObjectId = %q, want %q", photo.CompanionObjectId, companion)
+		}
+	}
+}
+
+func TestSyncLivePhotos(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	gone := "trip/IMG_0009.MOV"
+	for _, obj := range []database.PhotoObject{
+		{ObjectID: "trip/IMG_0001.HEIC", ContentType: "image/heic", MD5Hash: "h1", UserID: 1},
+		{ObjectID: "trip/IMG_0001.MOV", ContentType: "video/quicktime", MD5Hash: "h2", UserID: 1},
+		{ObjectID: "trip/IMG_0009.HEIC", ContentType: "image/heic", MD5Hash: "h3", UserID: 1, CompanionObjectID: &gone},
+	} {
+		if err := db.Create(&obj).Error; err != nil {
+			t.Fatalf("failed to seed photo object: %v", err)
+		}
+	}
+	server := &LibraryServer{DB: db}
+
+	paired, err := server.syncLivePhotos(context.Background(), 1)
+
+	if err != nil {
+		t.Fatalf("syncLivePhotos() error = %v", err)
+	}
+	if paired != 1 {
+		t.Errorf("paired = %d, want 1", paired)
+	}
+	var unpaired database.PhotoObject
+	db.Where("object_id = ?", "trip/IMG_0009.HEIC").First(&unpaired)
+	if unpaired.CompanionObjectID != nil {
+		t.Errorf("CompanionObjectID of a still whose video is gone = %q, want nil", *unpaired.CompanionObjectID)
+	}
+}
@@ -0,0 +1,451 @@
+package internal
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/alexhokl/photos/database"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	protobuf "google.golang.org/protobuf/proto"
+	"gorm.io/gorm"
+)
+
+// Long-running library operations, only one of which runs at a time for a
+// user.
+const (
+	OperationSyncDatabase = "sync_database"
+	OperationUpdateWebp   = "update_webp"
+	OperationUpdateAvif   = "update_avif"
+	// OperationVerifyLibrary is held by VerifyLibrary only while it repairs
+	OperationVerifyLibrary = "verify_library"
+)
+
+const (
+	// operationLeaseDuration is how long an operation lock is held without
+	// a heartbeat before it is taken to have been left by a crashed holder
+	operationLeaseDuration = time.Minute
+	// operationHeartbeatInterval is how often the holder of an operation
+	// lock renews its lease
+	operationHeartbeatInterval = 20 * time.Second
+	// operationRunningReason is the reason of the ErrorInfo detail of the
+	// FailedPrecondition error returned while another operation runs
+	operationRunningReason = "OPERATION_RUNNING"
+)
+
+// operationHolder identifies this process as the holder of operation locks.
+var operationHolder = func() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("%s/%d", hostname, os.Getpid())
+}()
+
+// operationLock is an operation lock held by this process.
+type operationLock struct {
+	db   *gorm.DB
+	lock database.OperationLock
+	// ctx is cancelled when the lock is taken over or released
+	ctx    context.Context
+	cancel context.CancelFunc
+	stop   chan struct{}
+	done   chan struct{}
+	close  sync.Once
+}
+
+// acquireOperationLock takes the operation lock of the user for operation,
+// taking over a lock whose lease has expired. If another operation holds
+// the lock, it is returned instead.
+func acquireOperationLock(ctx context.Context, db *gorm.DB, userID uint, operation string) (*operationLock, *database.OperationLock, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate lock token: %w", err)
+	}
+
+	now := time.Now()
+	lock := database.OperationLock{
+		UserID:         userID,
+		Operation:      operation,
+		Token:          hex.EncodeToString(token),
+		Holder:         operationHolder,
+		HeartbeatAt:    now,
+		LeaseExpiresAt: now.Add(operationLeaseDuration),
+	}
+
+	_, span := startSpan(ctx, "db.acquire_operation_lock")
+	var held *database.OperationLock
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var existing database.OperationLock
+		err := tx.Where("user_id = ?", userID).First(&existing).Error
+		if err == nil {
+			if existing.LeaseExpiresAt.After(now) {
+				held = &existing
+				return nil
+			}
+			slog.WarnContext(ctx, "taking over expired operation lock",
+				slog.Uint64("user_id", uint64(userID)),
+				slog.String("operation", existing.Operation),
+				slog.String("holder", existing.Holder),
+				slog.Time("lease_expires_at", existing.LeaseExpiresAt),
+			)
+			if err := tx.Unscoped().Delete(&existing).Error; err != nil {
+				return err
+			}
+		} else if err != gorm.ErrRecordNotFound {
+			return err
+		}
+		return tx.Create(&lock).Error
+	})
+	if err != nil {
+		recordSpanError(span, err)
+		return nil, nil, fmt.Errorf("failed to acquire operation lock: %w", err)
+	}
+	endSpanOk(span)
+	if held != nil {
+		return nil, held, nil
+	}
+
+	lockCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	l := &operationLock{
+		db:     db,
+		lock:   lock,
+		ctx:    lockCtx,
+		cancel: cancel,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go l.heartbeat(context.WithoutCancel(ctx))
+	return l, nil, nil
+}
+
+// heartbeat renews the lease of the lock until it is released, and cancels
+// the context of the lock if it has been taken over.
+func (l *operationLock) heartbeat(ctx context.Context) {
+	defer close(l.done)
+	ticker := time.NewTicker(operationHeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+		}
+
+		now := time.Now()
+		result := l.db.Model(&database.OperationLock{}).
+			Where("id = ? AND token = ?", l.lock.ID, l.lock.Token).
+			Updates(map[string]any{
+				"heartbeat_at":     now,
+				"lease_expires_at": now.Add(operationLeaseDuration),
+			})
+		if result.Error != nil {
+			slog.WarnContext(ctx, "failed to renew operation lock",
+				slog.String("operation", l.lock.Operation),
+				slog.String("error", result.Error.Error()),
+			)
+			continue
+		}
+		if result.RowsAffected == 0 {
+			slog.WarnContext(ctx, "operation lock was taken over",
+				slog.String("operation", l.lock.Operation),
+			)
+			l.cancel()
+			return
+		}
+	}
+}
+
+// release stops the heartbeat and deletes the lock, unless it has been
+// taken over.
+func (l *operationLock) release(ctx context.Context) {
+	l.close.Do(func() {
+		close(l.stop)
+		<-l.done
+		l.cancel()
+
+		_, span := startSpan(ctx, "db.release_operation_lock")
+		if err := l.db.Unscoped().
+			Where("id = ? AND token = ?", l.lock.ID, l.lock.Token).
+			Delete(&database.OperationLock{}).Error; err != nil {
+			recordSpanError(span, err)
+			slog.WarnContext(ctx, "failed to release operation lock",
+				slog.String("operation", l.lock.Operation),
+				slog.String("error", err.Error()),
+			)
+			return
+		}
+		endSpanOk(span)
+	})
+}
+
+// operationRunningError returns the FailedPrecondition error telling a
+// caller that another operation holds the lock, with the details of that
+// operation in an ErrorInfo.
+func operationRunningError(held *database.OperationLock) error {
+	st := status.Newf(codes.FailedPrecondition,
+		"%s is already running (started %s on %s)",
+		held.Operation, held.CreatedAt.Format(time.RFC3339), held.Holder)
+	detailed, err := st.WithDetails(&errdetails.ErrorInfo{
+		Reason: operationRunningReason,
+		Domain: "photos",
+		Metadata: map[string]string{
+			"operation":        held.Operation,
+			"started_at":       held.CreatedAt.Format(time.RFC3339),
+			"heartbeat_at":     held.HeartbeatAt.Format(time.RFC3339),
+			"lease_expires_at": held.LeaseExpiresAt.Format(time.RFC3339),
+			"holder":           held.Holder,
+		},
+	})
+	if err != nil {
+		return st.Err()
+	}
+	return detailed.Err()
+}
+
+// operationProgress relays the progress messages of a running operation to
+// the callers attached to it. Attached callers receive the latest message
+// whenever they are ready for one, so that a slow caller skips messages
+// rather than holding up the operation.
+type operationProgress[T any] struct {
+	mu      sync.Mutex
+	last    *T
+	version int
+	changed chan struct{}
+	done    chan struct{}
+	err     error
+}
+
+func newOperationProgress[T any]() *operationProgress[T] {
+	return &operationProgress[T]{
+		changed: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// publish makes msg the latest message of the operation.
+func (p *operationProgress[T]) publish(msg *T) {
+	// Handlers reuse their buffers, as for inProcessServerStream
+	clone := any(protobuf.Clone(any(msg).(protobuf.Message))).(*T)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.last = clone
+	p.version++
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// finish records the result of the operation.
+func (p *operationProgress[T]) finish(err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.err = err
+	close(p.done)
+}
+
+// follow sends the progress of the operation to send, starting with its
+// latest message, until it finishes, and returns its result.
+func (p *operationProgress[T]) follow(ctx context.Context, send func(*T) error) error {
+	sent := 0
+	for {
+		p.mu.Lock()
+		last, version, changed := p.last, p.version, p.changed
+		p.mu.Unlock()
+
+		if version != sent && last != nil {
+			if err := send(last); err != nil {
+				return err
+			}
+			sent = version
+			continue
+		}
+
+		select {
+		case <-changed:
+		case <-p.done:
+			p.mu.Lock()
+			last, version, err := p.last, p.version, p.err
+			p.mu.Unlock()
+			if version != sent && last != nil {
+				if sendErr := send(last); sendErr != nil {
+					return sendErr
+				}
+			}
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// progressStream publishes every message sent on a server stream to the
+// callers attached to the operation. Its context is that of the operation
+// rather than of the caller that started it, and it stops sending to that
+// caller once it has gone.
+type progressStream[T any] struct {
+	grpc.ServerStreamingServer[T]
+	ctx      context.Context
+	progress *operationProgress[T]
+}
+
+func (s *progressStream[T]) Context() context.Context { return s.ctx }
+
+func (s *progressStream[T]) Send(msg *T) error {
+	s.progress.publish(msg)
+	if s.ServerStreamingServer.Context().Err() != nil {
+		return nil
+	}
+	return s.ServerStreamingServer.Send(msg)
+}
+
+// operationCallers counts the callers attached to a running operation and
+// cancels the operation when the last of them has gone.
+type operationCallers struct {
+	mu sync.Mutex
+	// ctx is the context of the operation
+	ctx    context.Context
+	count  int
+	cancel context.CancelFunc
+}
+
+// attach counts a caller until its context is done or the returned
+// function is called. It reports false, attaching nothing, if the
+// operation has already been cancelled.
+func (c *operationCallers) attach(ctx context.Context) (func(), bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ctx.Err() != nil {
+		return nil, false
+	}
+	c.count++
+	stop := context.AfterFunc(ctx, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.count--
+		if c.count == 0 {
+			c.cancel()
+		}
+	})
+	return func() { stop() }, true
+}
+
+// runningOperation is an operation running in this process.
+type runningOperation struct {
+	lock database.OperationLock
+	// progress is the *operationProgress of the operation's progress
+	// message type
+	progress any
+	callers  *operationCallers
+}
+
+// operationRegistry tracks the operations running in this process, by user,
+// so that callers can attach to them. The zero value is ready to use.
+type operationRegistry struct {
+	mu      sync.Mutex
+	running map[uint]runningOperation
+}
+
+// runExclusive runs operation for the user while holding the user's
+// operation lock, streaming its progress to stream. A caller of the same
+// operation while it runs in this process is attached to it and receives
+// its progress and result instead; any other caller gets FailedPrecondition
+// with the details of the running operation. The operation is cancelled
+// once all its callers have gone or its lock has been taken over; a caller
+// arriving after that waits for it to end and starts it again.
+func runExclusive[T any](
+	s *LibraryServer,
+	stream grpc.ServerStreamingServer[T],
+	userID uint,
+	operation string,
+	run func(grpc.ServerStreamingServer[T]) error,
+) error {
+	ctx := stream.Context()
+	registry := &s.operations
+
+	for {
+		registry.mu.Lock()
+		running, ok := registry.running[userID]
+		if !ok {
+			break
+		}
+		progress, ok := running.progress.(*operationProgress[T])
+		if !ok || running.lock.Operation != operation {
+			registry.mu.Unlock()
+			return operationRunningError(&running.lock)
+		}
+		detach, attached := running.callers.attach(ctx)
+		registry.mu.Unlock()
+		if attached {
+			defer detach()
+			slog.InfoContext(ctx, "Attaching to running operation",
+				slog.String("operation", operation),
+				slog.Uint64("user_id", uint64(userID)),
+			)
+			return progress.follow(ctx, stream.Send)
+		}
+		select {
+		case <-progress.done:
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		}
+	}
+
+	// The operation is registered before its lock is taken, so that the
+	// registry is not held during database I/O; callers arriving meanwhile
+	// are attached and get the error if the lock is held elsewhere
+	runCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancel()
+	progress := newOperationProgress[T]()
+	callers := &operationCallers{ctx: runCtx, cancel: cancel}
+	detach, _ := callers.attach(ctx)
+	if registry.running == nil {
+		registry.running = make(map[uint]runningOperation)
+	}
+	registry.running[userID] = runningOperation{
+		lock:     database.OperationLock{UserID: userID, Operation: operation, Holder: operationHolder, Model: gorm.Model{CreatedAt: time.Now()}},
+		progress: progress,
+		callers:  callers,
+	}
+	registry.mu.Unlock()
+
+	finish := func(err error) error {
+		detach()
+		registry.mu.Lock()
+		delete(registry.running, userID)
+		registry.mu.Unlock()
+		progress.finish(err)
+		return err
+	}
+
+	lock, held, err := acquireOperationLock(ctx, s.DB, userID, operation)
+	if err != nil {
+		return finish(status.Errorf(codes.Internal, "%v", err))
+	}
+	if held != nil {
+		return finish(operationRunningError(held))
+	}
+	stopLock := context.AfterFunc(lock.ctx, cancel)
+	defer stopLock()
+	registry.mu.Lock()
+	registry.running[userID] = runningOperation{lock: lock.lock, progress: progress, callers: callers}
+	registry.mu.Unlock()
+
+	err = run(&progressStream[T]{ServerStreamingServer: stream, ctx: runCtx, progress: progress})
+	detach()
+
+	registry.mu.Lock()
+	delete(registry.running, userID)
+	registry.mu.Unlock()
+	lock.release(context.WithoutCancel(ctx))
+	progress.finish(err)
+	return err
+}
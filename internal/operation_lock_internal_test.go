@@ -0,0 +1,353 @@
+package internal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alexhokl/photos/database"
+	"github.com/alexhokl/photos/proto"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAcquireOperationLock(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	ctx := context.Background()
+
+	lock, held, err := acquireOperationLock(ctx, db, 1, OperationSyncDatabase)
+	if err != nil {
+		t.Fatalf("acquireOperationLock() error = %v", err)
+	}
+	if lock == nil || held != nil {
+		t.Fatalf("acquireOperationLock() = %v, %v, want the lock", lock, held)
+	}
+
+	_, held, err = acquireOperationLock(ctx, db, 1, OperationUpdateWebp)
+	if err != nil {
+		t.Fatalf("acquireOperationLock() error = %v", err)
+	}
+	if held == nil || held.Operation != OperationSyncDatabase || held.Holder != operationHolder {
+		t.Fatalf("acquireOperationLock() held = %+v, want %s by %s", held, OperationSyncDatabase, operationHolder)
+	}
+
+	other, _, err := acquireOperationLock(ctx, db, 2, OperationUpdateWebp)
+	if err != nil || other == nil {
+		t.Fatalf("acquireOperationLock() for another user = %v, %v, want the lock", other, err)
+	}
+	other.release(ctx)
+
+	lock.release(ctx)
+	// Releasing twice is harmless
+	lock.release(ctx)
+
+	again, _, err := acquireOperationLock(ctx, db, 1, OperationUpdateWebp)
+	if err != nil || again == nil {
+		t.Fatalf("acquireOperationLock() after release = %v, %v, want the lock", again, err)
+	}
+	again.release(ctx)
+
+	var count int64
+	db.Unscoped().Model(&database.OperationLock{}).Count(&count)
+	if count != 0 {
+		t.Errorf("got %d lock rows after release, want 0", count)
+	}
+}
+
+func TestAcquireOperationLock_TakesOverExpiredLease(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	ctx := context.Background()
+
+	crashed, _, err := acquireOperationLock(ctx, db, 1, OperationSyncDatabase)
+	if err != nil || crashed == nil {
+		t.Fatalf("acquireOperationLock() = %v, %v, want the lock", crashed, err)
+	}
+	// The holder stops sending heartbeats
+	expired := time.Now().Add(-time.Second)
+	if err := db.Model(&database.OperationLock{}).Where("user_id = ?", 1).
+		Update("lease_expires_at", expired).Error; err != nil {
+		t.Fatalf("failed to expire lock: %v", err)
+	}
+
+	lock, held, err := acquireOperationLock(ctx, db, 1, OperationUpdateWebp)
+	if err != nil {
+		t.Fatalf("acquireOperationLock() error = %v", err)
+	}
+	if lock == nil || held != nil {
+		t.Fatalf("acquireOperationLock() = %v, %+v, want the expired lock taken over", lock, held)
+	}
+
+	// The previous holder releasing late leaves the new lock alone
+	crashed.release(ctx)
+	var stored database.OperationLock
+	if err := db.Where("user_id = ?", 1).First(&stored).Error; err != nil {
+		t.Fatalf("lock taken over was deleted: %v", err)
+	}
+	if stored.Operation != OperationUpdateWebp {
+		t.Errorf("stored lock operation = %q, want %q", stored.Operation, OperationUpdateWebp)
+	}
+	lock.release(ctx)
+}
+
+func TestSyncDatabase_LockedByAnotherHolder(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	server := &LibraryServer{DB: db}
+
+	now := time.Now()
+	running := database.OperationLock{
+		UserID:         1,
+		Operation:      OperationUpdateWebp,
+		Token:          "other",
+		Holder:         "other-host/42",
+		HeartbeatAt:    now,
+		LeaseExpiresAt: now.Add(operationLeaseDuration),
+	}
+	if err := db.Create(&running).Error; err != nil {
+		t.Fatalf("failed to create lock: %v", err)
+	}
+
+	stream := newMockSyncDatabaseStream(contextWithUserID(1))
+	err := server.SyncDatabase(&proto.SyncDatabaseRequest{}, stream)
+	assertGRPCError(t, err, codes.FailedPrecondition)
+	if len(stream.sent) != 0 {
+		t.Errorf("got %d progress messages, want none", len(stream.sent))
+	}
+
+	st, _ := status.FromError(err)
+	var info *errdetails.ErrorInfo
+	for _, detail := range st.Details() {
+		if d, ok := detail.(*errdetails.ErrorInfo); ok {
+			info = d
+		}
+	}
+	if info == nil {
+		t.Fatalf("error details = %v, want an ErrorInfo", st.Details())
+	}
+	if info.GetReason() != operationRunningReason {
+		t.Errorf("reason = %q, want %q", info.GetReason(), operationRunningReason)
+	}
+	metadata := info.GetMetadata()
+	if metadata["operation"] != OperationUpdateWebp || metadata["holder"] != "other-host/42" {
+		t.Errorf("metadata = %v, want operation %s held by other-host/42", metadata, OperationUpdateWebp)
+	}
+	if metadata["started_at"] == "" || metadata["heartbeat_at"] == "" {
+		t.Errorf("metadata = %v, want started_at and heartbeat_at", metadata)
+	}
+
+	// A lock held by another user does not get in the way
+	other := newMockSyncDatabaseStream(contextWithUserID(2))
+	if err := server.SyncDatabase(&proto.SyncDatabaseRequest{}, other); err != nil {
+		t.Fatalf("SyncDatabase() for another user error = %v", err)
+	}
+}
+
+// channelSyncDatabaseStream passes the messages sent on it to a channel.
+type channelSyncDatabaseStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	sent chan *proto.SyncDatabaseProgress
+}
+
+func (c *channelSyncDatabaseStream) Send(msg *proto.SyncDatabaseProgress) error {
+	c.sent <- msg
+	return nil
+}
+
+func (c *channelSyncDatabaseStream) Context() context.Context { return c.ctx }
+
+func TestRunExclusive_AttachesToRunningOperation(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("failed to get sql.DB: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	server := &LibraryServer{DB: db}
+
+	started := make(chan struct{})
+	proceed := make(chan struct{})
+	run := func(stream grpc.ServerStreamingServer[proto.SyncDatabaseProgress]) error {
+		msg := &proto.SyncDatabaseProgress{Processed: 1, Total: 2, Added: 1}
+		if err := stream.Send(msg); err != nil {
+			return err
+		}
+		close(started)
+		<-proceed
+		// Handlers reuse their messages
+		msg.Processed, msg.Added, msg.Complete = 2, 2, true
+		return stream.Send(msg)
+	}
+
+	leader := newMockSyncDatabaseStream(contextWithUserID(1))
+	leaderDone := make(chan error, 1)
+	go func() {
+		leaderDone <- runExclusive(server, leader, 1, OperationSyncDatabase, run)
+	}()
+	<-started
+
+	// Another operation is refused while the first runs
+	webp := &mockUpdateWebpStream{ctx: contextWithUserID(1)}
+	err = runExclusive(server, webp, 1, OperationUpdateWebp, func(grpc.ServerStreamingServer[proto.UpdateWebpProgress]) error {
+		t.Error("second operation ran")
+		return nil
+	})
+	assertGRPCError(t, err, codes.FailedPrecondition)
+	st, _ := status.FromError(err)
+	if details := st.Details(); len(details) != 1 || details[0].(*errdetails.ErrorInfo).GetMetadata()["operation"] != OperationSyncDatabase {
+		t.Errorf("error details = %v, want an ErrorInfo of %s", details, OperationSyncDatabase)
+	}
+
+	follower := &channelSyncDatabaseStream{ctx: contextWithUserID(1), sent: make(chan *proto.SyncDatabaseProgress, 2)}
+	followerDone := make(chan error, 1)
+	go func() {
+		followerDone <- runExclusive(server, follower, 1, OperationSyncDatabase, func(grpc.ServerStreamingServer[proto.SyncDatabaseProgress]) error {
+			t.Error("attached call ran the operation again")
+			return nil
+		})
+	}()
+
+	first := <-follower.sent
+	if first.GetAdded() != 1 || first.GetProcessed() != 1 {
+		t.Errorf("first attached message = %v, want the latest progress", first)
+	}
+	close(proceed)
+
+	if err := <-leaderDone; err != nil {
+		t.Fatalf("runExclusive() error = %v", err)
+	}
+	if err := <-followerDone; err != nil {
+		t.Fatalf("attached runExclusive() error = %v", err)
+	}
+	last := <-follower.sent
+	if !last.GetComplete() || last.GetAdded() != 2 {
+		t.Errorf("last attached message = %v, want the summary", last)
+	}
+	if len(leader.sent) != 2 {
+		t.Errorf("leader got %d messages, want 2", len(leader.sent))
+	}
+
+	var count int64
+	db.Unscoped().Model(&database.OperationLock{}).Count(&count)
+	if count != 0 {
+		t.Errorf("got %d lock rows after the operation, want 0", count)
+	}
+}
+
+func TestRunExclusive_CancelsWhenCallersHaveGone(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("failed to get sql.DB: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	server := &LibraryServer{DB: db}
+
+	started := make(chan struct{})
+	runDone := make(chan error, 1)
+	run := func(stream grpc.ServerStreamingServer[proto.SyncDatabaseProgress]) error {
+		if err := stream.Send(&proto.SyncDatabaseProgress{Processed: 1}); err != nil {
+			return err
+		}
+		close(started)
+		<-stream.Context().Done()
+		runDone <- stream.Context().Err()
+		return stream.Context().Err()
+	}
+
+	leaderCtx, cancelLeader := context.WithCancel(contextWithUserID(1))
+	leaderDone := make(chan error, 1)
+	go func() {
+		leaderDone <- runExclusive(server, newMockSyncDatabaseStream(leaderCtx), 1, OperationSyncDatabase, run)
+	}()
+	<-started
+
+	followerCtx, cancelFollower := context.WithCancel(contextWithUserID(1))
+	follower := &channelSyncDatabaseStream{ctx: followerCtx, sent: make(chan *proto.SyncDatabaseProgress, 1)}
+	followerDone := make(chan error, 1)
+	go func() {
+		followerDone <- runExclusive(server, follower, 1, OperationSyncDatabase, run)
+	}()
+	<-follower.sent
+
+	// The operation outlives the caller that started it
+	cancelLeader()
+	select {
+	case err := <-runDone:
+		t.Fatalf("operation cancelled with an attached caller: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancelFollower()
+	if err := <-runDone; err != context.Canceled {
+		t.Errorf("operation context error = %v, want %v", err, context.Canceled)
+	}
+	if err := <-followerDone; err != context.Canceled {
+		t.Errorf("attached runExclusive() error = %v, want %v", err, context.Canceled)
+	}
+	if err := <-leaderDone; err != context.Canceled {
+		t.Errorf("runExclusive() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestRunExclusive_RestartsCancelledOperation(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("failed to get sql.DB: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+	server := &LibraryServer{DB: db}
+
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	stopping := make(chan struct{})
+	leaderRun := func(stream grpc.ServerStreamingServer[proto.SyncDatabaseProgress]) error {
+		close(started)
+		<-stream.Context().Done()
+		close(cancelled)
+		// The operation takes a while to wind down
+		<-stopping
+		return stream.Context().Err()
+	}
+
+	leaderCtx, cancelLeader := context.WithCancel(contextWithUserID(1))
+	leaderDone := make(chan error, 1)
+	go func() {
+		leaderDone <- runExclusive(server, newMockSyncDatabaseStream(leaderCtx), 1, OperationSyncDatabase, leaderRun)
+	}()
+	<-started
+	cancelLeader()
+	<-cancelled
+
+	// A caller arriving now is not attached to the cancelled operation but
+	// runs it again once it has ended
+	ran := make(chan struct{})
+	next := newMockSyncDatabaseStream(contextWithUserID(1))
+	nextDone := make(chan error, 1)
+	go func() {
+		nextDone <- runExclusive(server, next, 1, OperationSyncDatabase, func(stream grpc.ServerStreamingServer[proto.SyncDatabaseProgress]) error {
+			close(ran)
+			return stream.Send(&proto.SyncDatabaseProgress{Complete: true})
+		})
+	}()
+	select {
+	case <-ran:
+		t.Fatal("operation ran again before the cancelled one ended")
+	case err := <-nextDone:
+		t.Fatalf("runExclusive() returned %v before the cancelled operation ended", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(stopping)
+	if err := <-leaderDone; err != context.Canceled {
+		t.Errorf("runExclusive() error = %v, want %v", err, context.Canceled)
+	}
+	if err := <-nextDone; err != nil {
+		t.Fatalf("restarted runExclusive() error = %v", err)
+	}
+	if len(next.sent) != 1 || !next.sent[0].GetComplete() {
+		t.Errorf("restarted caller got %v, want the summary of its own run", next.sent)
+	}
+}
@@ -0,0 +1,388 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/alexhokl/photos/database"
+	"github.com/alexhokl/photos/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// posterScanSeconds is how far into a video a poster frame is looked for
+	posterScanSeconds = 5
+	// posterScanFrames is the number of frames the thumbnail filter compares,
+	// enough for posterScanSeconds at 60 fps
+	posterScanFrames = posterScanSeconds * 60
+	// posterMinLuma is the average luma (of 255) below which a frame is
+	// treated as black; video black is 16
+	posterMinLuma = 24
+	// animatedPreviewSeconds is the length of the animated preview
+	animatedPreviewSeconds = 3
+	// animatedPreviewFPS is the frame rate of the animated preview
+	animatedPreviewFPS = 10
+	// animatedPreviewShortEdge is the short edge, in pixels, of the animated
+	// preview
+	animatedPreviewShortEdge = 240
+	// videoPreviewTimeout bounds generating the poster and animated preview
+	videoPreviewTimeout = 2 * time.Minute
+)
+
+// animatedPreviewObjectID returns the GCS object ID of the animated preview
+// of a video. "_preview.webp" is appended to the object ID, extension
+// included.
+// Example:
+//
+//	"dir1/dir2/clip.mov" → "dir1/dir2/clip.mov_preview.webp"
+func animatedPreviewObjectID(objectID string) string {
+	return objectID + "_preview.webp"
+}
+
+// posterFilter returns the ffmpeg filter choosing the poster frame of a
+// video: frames darker than posterMinLuma are dropped, and of the rest the
+// thumbnail filter picks the one closest to their average colour histogram,
+// which passes over fades, flashes and frames blurred by motion.
+func posterFilter() string {
+	return fmt.Sprintf(
+		"signalstats,metadata=mode=select:key=lavfi.signalstats.YAVG:value=%d:function=greater,thumbnail=n=%d",
+		posterMinLuma, posterScanFrames,
+	)
+}
+
+// posterArgs returns the ffmpeg arguments writing the poster frame of the
+// first posterScanSeconds of input to output as a JPEG.
+func posterArgs(input, output string) []string {
+	return []string{
+		"-t", strconv.Itoa(posterScanSeconds),
+		"-i", input,
+		"-vf", posterFilter(),
+		"-frames:v", "1",
+		"-q:v", "2",
+		output,
+	}
+}
+
+// firstFrameArgs returns the ffmpeg arguments writing the first frame of
+// input to output as a JPEG, for videos that are black throughout.
+func firstFrameArgs(input, output string) []string {
+	return []string{
+		"-i", input,
+		"-frames:v", "1",
+		"-q:v", "2",
+		output,
+	}
+}
+
+// animatedPreviewArgs returns the ffmpeg arguments writing a short, silent,
+// looping animated WebP of the start of input to output.
+func animatedPreviewArgs(input, output string) []string {
+	return []string{
+		"-t", strconv.Itoa(animatedPreviewSeconds),
+		"-i", input,
+		"-vf", fmt.Sprintf("fps=%d,%s", animatedPreviewFPS, shortEdgeScaleFilter(animatedPreviewShortEdge)),
+		"-an",
+		"-c:v", "libwebp_anim",
+		"-quality", "60",
+		"-loop", "0",
+		"-f", "webp",
+		output,
+	}
+}
+
+// runFFmpegToFile runs ffmpeg with args and returns the file it wrote to
+// outputPath, failing if ffmpeg wrote nothing, as it does when a filter
+// drops every frame.
+func runFFmpegToFile(ctx context.Context, args []string, outputPath string) ([]byte, error) {
+	if err := runFFmpeg(ctx, args, 0, nil); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(outputPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ffmpeg output: %w", err)
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("ffmpeg produced no output")
+	}
+	return data, nil
+}
+
+// GenerateVideoPoster returns a representative JPEG frame from the first
+// seconds of the video at videoPath using ffmpeg, skipping black frames
+// (see posterFilter). If every frame is black the first one is used.
+//
+// There is no fallback for decoding video frames: if ffmpeg is not installed
+// an error wrapping exec.ErrNotFound is returned.
+func GenerateVideoPoster(ctx context.Context, caps *Capabilities, videoPath string) ([]byte, error) {
+	if !caps.Has(ToolFFmpeg) {
+		return nil, fmt.Errorf("cannot generate video poster: %w", &exec.Error{Name: ToolFFmpeg, Err: exec.ErrNotFound})
+	}
+
+	tmpDir, err := os.MkdirTemp("", "video-poster-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	posterPath := filepath.Join(tmpDir, "poster.jpg")
+	poster, err := runFFmpegToFile(ctx, posterArgs(videoPath, posterPath), posterPath)
+	if err == nil {
+		return poster, nil
+	}
+	slog.DebugContext(ctx, "no poster frame chosen; using the first frame",
+		slog.String("error", err.Error()),
+	)
+	return runFFmpegToFile(ctx, firstFrameArgs(videoPath, posterPath), posterPath)
+}
+
+// generateVideoPosterFromData is GenerateVideoPoster for the video objectID
+// held in memory.
+func generateVideoPosterFromData(ctx context.Context, caps *Capabilities, data []byte, objectID string) ([]byte, error) {
+	videoPath, err := writeTempVideo(data, objectID)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = os.Remove(videoPath) }()
+	return GenerateVideoPoster(ctx, caps, videoPath)
+}
+
+// GenerateAnimatedVideoPreview returns a short, silent, looping animated WebP
+// of the start of the video at videoPath using ffmpeg, for playback on hover.
+func GenerateAnimatedVideoPreview(ctx context.Context, caps *Capabilities, videoPath string) ([]byte, error) {
+	if !caps.Has(ToolFFmpeg) {
+		return nil, fmt.Errorf("cannot generate animated preview: %w", &exec.Error{Name: ToolFFmpeg, Err: exec.ErrNotFound})
+	}
+
+	tmpDir, err := os.MkdirTemp("", "video-preview-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	previewPath := filepath.Join(tmpDir, "preview.webp")
+	return runFFmpegToFile(ctx, animatedPreviewArgs(videoPath, previewPath), previewPath)
+}
+
+// writeDerivedObject uploads data as the derived asset objectID of
+// sourceObjectID.
+func writeDerivedObject(ctx context.Context, bucket *storage.BucketHandle, kind, sourceObjectID, objectID, contentType string, data []byte) error {
+	_, writeSpan := startSpan(ctx, "gcs.write_object")
+	writer, err := newDerivedObjectWriter(ctx, bucket.Object(objectID), kind, sourceObjectID)
+	if err != nil {
+		recordSpanError(writeSpan, err)
+		return err
+	}
+	writer.ContentType = contentType
+	if _, err := writer.Write(data); err != nil {
+		_ = writer.Close()
+		recordSpanError(writeSpan, err)
+		return fmt.Errorf("failed to write %s: %w", objectID, err)
+	}
+	if err := writer.Close(); err != nil {
+		recordSpanError(writeSpan, err)
+		return fmt.Errorf("failed to close writer of %s: %w", objectID, err)
+	}
+	endSpanOk(writeSpan)
+	return nil
+}
+
+// writeTempVideo writes the video objectID to a temporary file, keeping its
+// extension so that ffmpeg can tell the container, and returns its path. The
+// caller removes the file.
+func writeTempVideo(data []byte, objectID string) (string, error) {
+	tmpFile, err := os.CreateTemp("", "video-*"+path.Ext(objectID))
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	_, err = tmpFile.Write(data)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmpFile.Name())
+		return "", fmt.Errorf("failed to write temp file: %w", err)
+	}
+	return tmpFile.Name(), nil
+}
+
+// storeVideoPreviews generates the poster frame of the video at videoPath,
+// unless photoObject already has one, and its animated preview, unless it
+// already has one, uploads them to GCS and sets ThumbnailObjectID and
+// AnimatedPreviewObjectID on photoObject. It returns the poster frame if one
+// was generated; the first error is returned after both have been
+// attempted.
+func storeVideoPreviews(ctx context.Context, bucket *storage.BucketHandle, caps *Capabilities, photoObject *database.PhotoObject, videoPath string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, videoPreviewTimeout)
+	defer cancel()
+
+	objectID := photoObject.ObjectID
+	var poster []byte
+	var firstErr error
+
+	if photoObject.ThumbnailObjectID == nil || *photoObject.ThumbnailObjectID == "" {
+		posterID := derivedObjectID(database.DerivedKindThumbnail, objectID)
+		data, err := GenerateVideoPoster(ctx, caps, videoPath)
+		if err == nil {
+			err = writeDerivedObject(ctx, bucket, database.DerivedKindThumbnail, objectID, posterID, "image/jpeg", data)
+		}
+		if err != nil {
+			firstErr = fmt.Errorf("failed to generate poster: %w", err)
+		} else {
+			photoObject.ThumbnailObjectID = &posterID
+			poster = data
+		}
+	}
+
+	if photoObject.AnimatedPreviewObjectID == nil || *photoObject.AnimatedPreviewObjectID == "" {
+		previewID := animatedPreviewObjectID(objectID)
+		data, err := GenerateAnimatedVideoPreview(ctx, caps, videoPath)
+		if err == nil {
+			err = writeDerivedObject(ctx, bucket, database.DerivedKindAnimatedPreview, objectID, previewID, "image/webp", data)
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("failed to generate animated preview: %w", err)
+			}
+		} else {
+			photoObject.AnimatedPreviewObjectID = &previewID
+		}
+	}
+
+	return poster, firstErr
+}
+
+// uploadVideoPreviews generates the poster frame and animated preview of an
+// uploaded video if ffmpeg is installed, uploads them to GCS, and sets
+// ThumbnailObjectID and AnimatedPreviewObjectID on photoObject. It returns
+// the poster frame, or nil if there is none. Errors are logged but not
+// fatal.
+func uploadVideoPreviews(ctx context.Context, bucket *storage.BucketHandle, caps *Capabilities, data []byte, objectID string, photoObject *database.PhotoObject) []byte {
+	if !caps.Has(ToolFFmpeg) {
+		return nil
+	}
+
+	videoPath, err := writeTempVideo(data, objectID)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to generate video previews",
+			slog.String("object_id", objectID),
+			slog.String("error", err.Error()),
+		)
+		return nil
+	}
+	defer func() { _ = os.Remove(videoPath) }()
+
+	poster, err := storeVideoPreviews(ctx, bucket, caps, photoObject, videoPath)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to generate video previews",
+			slog.String("object_id", objectID),
+			slog.String("error", err.Error()),
+		)
+	}
+	if poster != nil {
+		slog.InfoContext(ctx, "Generated video poster",
+			slog.String("object_id", objectID),
+			slog.String("thumbnail_object_id", *photoObject.ThumbnailObjectID),
+		)
+	}
+	return poster
+}
+
+// syncVideoPreviews generates the poster frames and animated previews
+// missing from the user's videos, such as those uploaded before they were
+// generated or while ffmpeg was missing. A PHASE_POSTERS progress message is
+// sent per video examined. It does nothing if ffmpeg is not installed.
+func (s *LibraryServer) syncVideoPreviews(
+	ctx context.Context,
+	userID uint,
+	stream grpc.ServerStreamingServer[proto.SyncDatabaseProgress],
+) (generated int, err error) {
+	if s.GCSClient == nil || !s.Capabilities.Has(ToolFFmpeg) {
+		return 0, nil
+	}
+
+	var videos []database.PhotoObject
+	_, dbListSpan := startSpan(ctx, "db.list_photo_objects")
+	if err := s.DB.Where("user_id = ? AND content_type LIKE ?", userID, "video/%").
+		Where("thumbnail_object_id IS NULL OR thumbnail_object_id = '' OR animated_preview_object_id IS NULL OR animated_preview_object_id = ''").
+		Order("object_id ASC").
+		Find(&videos).Error; err != nil {
+		recordSpanError(dbListSpan, err)
+		return 0, status.Errorf(codes.Internal, "failed to list database objects: %v", err)
+	}
+	endSpanOk(dbListSpan)
+
+	derived, err := loadDerivedObjectSet(s.DB)
+	if err != nil {
+		return 0, status.Errorf(codes.Internal, "failed to list derived objects: %v", err)
+	}
+
+	bucket := s.GCSClient.Bucket(s.BucketName)
+	total := uint32(len(videos))
+	for i := range videos {
+		video := &videos[i]
+		if !derived.contains(video.ObjectID) {
+			if ok, err := s.generateVideoPreviewsForObject(ctx, bucket, video); err != nil {
+				slog.WarnContext(ctx, "failed to generate video previews during sync",
+					slog.String("object_id", video.ObjectID),
+					slog.String("error", err.Error()),
+				)
+			} else if ok {
+				generated++
+			}
+		}
+
+		if err := stream.Send(&proto.SyncDatabaseProgress{
+			Phase:     proto.SyncDatabaseProgress_PHASE_POSTERS,
+			Processed: uint32(i + 1),
+			Total:     total,
+		}); err != nil {
+			return generated, err
+		}
+	}
+
+	return generated, nil
+}
+
+// generateVideoPreviewsForObject downloads the video photoObject, generates
+// its missing poster frame and animated preview, and records them. It
+// reports whether either was generated; whatever was generated is recorded
+// even if the other failed.
+func (s *LibraryServer) generateVideoPreviewsForObject(ctx context.Context, bucket *storage.BucketHandle, photoObject *database.PhotoObject) (bool, error) {
+	tmpDir, err := os.MkdirTemp("", "video-previews-*")
+	if err != nil {
+		return false, fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	videoPath := filepath.Join(tmpDir, "input"+path.Ext(photoObject.ObjectID))
+	if _, err := downloadObjectToFile(ctx, bucket, photoObject.ObjectID, videoPath); err != nil {
+		return false, err
+	}
+
+	hadPoster := photoObject.ThumbnailObjectID != nil && *photoObject.ThumbnailObjectID != ""
+	hadPreview := photoObject.AnimatedPreviewObjectID != nil && *photoObject.AnimatedPreviewObjectID != ""
+	_, genErr := storeVideoPreviews(ctx, bucket, s.Capabilities, photoObject, videoPath)
+
+	generated := false
+	if !hadPoster && photoObject.ThumbnailObjectID != nil {
+		referenceDerivedObject(ctx, s.DB, photoObject.UserID, photoObject.ObjectID, "thumbnail_object_id", *photoObject.ThumbnailObjectID)
+		recordDerivedObject(ctx, s.DB, photoObject.UserID, database.DerivedKindThumbnail, photoObject.ObjectID, *photoObject.ThumbnailObjectID, derivedObjectSize(ctx, bucket, *photoObject.ThumbnailObjectID))
+		generated = true
+	}
+	if !hadPreview && photoObject.AnimatedPreviewObjectID != nil {
+		referenceDerivedObject(ctx, s.DB, photoObject.UserID, photoObject.ObjectID, "animated_preview_object_id", *photoObject.AnimatedPreviewObjectID)
+		recordDerivedObject(ctx, s.DB, photoObject.UserID, database.DerivedKindAnimatedPreview, photoObject.ObjectID, *photoObject.AnimatedPreviewObjectID, derivedObjectSize(ctx, bucket, *photoObject.AnimatedPreviewObjectID))
+		generated = true
+	}
+	return generated, genErr
+}
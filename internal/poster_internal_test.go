@@ -0,0 +1,113 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/alexhokl/photos/database"
+	"github.com/alexhokl/photos/proto"
+)
+
+func TestAnimatedPreviewObjectID(t *testing.T) {
+	tests := []struct {
+		objectID string
+		expected string
+	}{
+		{"dir1/dir2/clip.mov", "dir1/dir2/clip.mov_preview.webp"},
+		{"clip.mp4", "clip.mp4_preview.webp"},
+		{"noext", "noext_preview.webp"},
+	}
+	for _, test := range tests {
+		if got := animatedPreviewObjectID(test.objectID); got != test.expected {
+			t.Errorf("animatedPreviewObjectID(%q) = %q, want %q", test.objectID, got, test.expected)
+		}
+		if got := derivedObjectID(database.DerivedKindAnimatedPreview, test.objectID); got != test.expected {
+			t.Errorf("derivedObjectID(animated_preview, %q) = %q, want %q", test.objectID, got, test.expected)
+		}
+	}
+	if got := derivedObjectColumn(database.DerivedKindAnimatedPreview, "clip_preview.webp"); got != "animated_preview_object_id" {
+		t.Errorf("derivedObjectColumn(animated_preview) = %q, want animated_preview_object_id", got)
+	}
+}
+
+func TestPosterArgs(t *testing.T) {
+	args := posterArgs("in.mov", "out.jpg")
+
+	if args[len(args)-1] != "out.jpg" {
+		t.Errorf("posterArgs() output = %q, want out.jpg", args[len(args)-1])
+	}
+	i := slices.Index(args, "-vf")
+	if i < 0 {
+		t.Fatalf("posterArgs() = %v, want a -vf filter", args)
+	}
+	filter := args[i+1]
+	// black frames are dropped before the thumbnail filter compares the rest
+	if !strings.Contains(filter, "signalstats") || strings.Index(filter, "signalstats") > strings.Index(filter, "thumbnail") {
+		t.Errorf("poster filter = %q, want signalstats before thumbnail", filter)
+	}
+	if !slices.Contains(args, "-t") {
+		t.Errorf("posterArgs() = %v, want the scan limited with -t", args)
+	}
+}
+
+func TestAnimatedPreviewArgs(t *testing.T) {
+	args := animatedPreviewArgs("in.mov", "out.webp")
+
+	for _, want := range []string{"libwebp_anim", "-an", "-loop"} {
+		if !slices.Contains(args, want) {
+			t.Errorf("animatedPreviewArgs() = %v, want %q", args, want)
+		}
+	}
+	if args[len(args)-1] != "out.webp" {
+		t.Errorf("animatedPreviewArgs() output = %q, want out.webp", args[len(args)-1])
+	}
+}
+
+func TestVideoPreviews_MissingTool(t *testing.T) {
+	if _, err := GenerateVideoPoster(context.Background(), &Capabilities{}, "clip.mov"); !errors.Is(err, exec.ErrNotFound) {
+		t.Errorf("GenerateVideoPoster() error = %v, want exec.ErrNotFound", err)
+	}
+	if _, err := GenerateAnimatedVideoPreview(context.Background(), &Capabilities{}, "clip.mov"); !errors.Is(err, exec.ErrNotFound) {
+		t.Errorf("GenerateAnimatedVideoPreview() error = %v, want exec.ErrNotFound", err)
+	}
+}
+
+func TestUploadVideoPreviews_NoFFmpeg(t *testing.T) {
+	photoObject := &database.PhotoObject{ObjectID: "clip.mov", ContentType: "video/quicktime"}
+
+	poster := uploadVideoPreviews(context.Background(), nil, &Capabilities{}, []byte("video"), "clip.mov", photoObject)
+
+	if poster != nil {
+		t.Errorf("expected no poster without ffmpeg, got %d bytes", len(poster))
+	}
+	if photoObject.ThumbnailObjectID != nil || photoObject.AnimatedPreviewObjectID != nil {
+		t.Error("expected no previews to be referenced without ffmpeg")
+	}
+}
+
+func TestSyncVideoPreviews_NoFFmpeg(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	if err := db.Create(&database.PhotoObject{ObjectID: "clip.mov", ContentType: "video/quicktime", MD5Hash: "h1", UserID: 1}).Error; err != nil {
+		t.Fatalf("failed to seed photo object: %v", err)
+	}
+	server := &LibraryServer{DB: db, Capabilities: &Capabilities{}}
+	stream := newMockSyncDatabaseStream(contextWithUserID(1))
+
+	generated, err := server.syncVideoPreviews(stream.Context(), 1, stream)
+
+	if err != nil {
+		t.Fatalf("syncVideoPreviews() error = %v", err)
+	}
+	if generated != 0 {
+		t.Errorf("generated = %d, want 0", generated)
+	}
+	for _, msg := range stream.sent {
+		if msg.Phase == proto.SyncDatabaseProgress_PHASE_POSTERS {
+			t.Errorf("expected no PHASE_POSTERS messages without ffmpeg, got %+v", msg)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package internal
+
+import (
+	"fmt"
+)
+
+// HasPreviewContentType returns true for photos that cannot be decoded in Go
+// and are instead displayed from a generated JPEG preview, stored in
+// thumbnail_object_id: camera RAW images (see RawFormats) and HEIC images.
+// Their WebP and thumbnails are rendered from the preview.
+func HasPreviewContentType(contentType string) bool {
+	return IsRawContentType(contentType) || IsHEICContentType(contentType)
+}
+
+// GeneratePreview generates the JPEG preview of a photo of contentType (see
+// HasPreviewContentType).
+func GeneratePreview(caps *Capabilities, contentType string, data []byte) ([]byte, error) {
+	switch {
+	case IsRawContentType(contentType):
+		return GenerateRawPreview(caps, data)
+	case IsHEICContentType(contentType):
+		return GenerateHEICPreview(caps, data)
+	}
+	return nil, fmt.Errorf("no preview for content type %s", contentType)
+}
+
+// previewObjectID returns the object ID of the JPEG preview of a photo.
+// For example "photos/2024/IMG_001.dng" → "photos/2024/IMG_001.dng_preview.jpg".
+func previewObjectID(objectID string) string {
+	return objectID + "_preview.jpg"
+}
@@ -0,0 +1,249 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+
+	"github.com/alexhokl/photos/database"
+	"github.com/alexhokl/photos/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+// quotaUsage is the number of a user's original photos and videos and the
+// total size of those originals and their XMP sidecars, which is what quotas
+// are enforced against.
+type quotaUsage struct {
+	Objects int64
+	Bytes   int64
+}
+
+// getQuotaUsage sums the PhotoObject and PhotoSidecar rows owned by userID.
+func getQuotaUsage(db *gorm.DB, userID uint) (quotaUsage, error) {
+	var usage quotaUsage
+	if err := db.Model(&database.PhotoObject{}).
+		Select("COUNT(*) AS objects, COALESCE(SUM(size_bytes), 0) AS bytes").
+		Where("user_id = ?", userID).
+		Scan(&usage).Error; err != nil {
+		return usage, err
+	}
+	sidecarBytes, err := sumSizeBytes(db, &database.PhotoSidecar{}, userID)
+	usage.Bytes += sidecarBytes
+	return usage, err
+}
+
+// sumSizeBytes sums the size_bytes column of the rows of model owned by
+// userID.
+func sumSizeBytes(db *gorm.DB, model any, userID uint) (int64, error) {
+	var total int64
+	err := db.Model(model).
+		Select("COALESCE(SUM(size_bytes), 0)").
+		Where("user_id = ?", userID).
+		Scan(&total).Error
+	return total, err
+}
+
+// checkQuota returns a ResourceExhausted error if storing objects originals,
+// or an XMP sidecar if objects is 0, totalling sizeBytes at objectID would
+// take userID over their object or byte quota. If replaces is true and
+// objectID already belongs to the user, the existing object is not counted
+// twice. Derived assets (WebP, AVIF, previews, thumbnails) are generated by
+// the server and not counted.
+// Users without a quota, or without a User row, are not limited.
+//
+// The check is made before the write, so concurrent uploads by the same user
+// can overshoot the quota by at most the uploads in flight.
+func checkQuota(ctx context.Context, db *gorm.DB, userID uint, objectID string, objects, sizeBytes int64, replaces bool) error {
+	var user database.User
+	_, userSpan := startSpan(ctx, "db.get_user")
+	if err := db.First(&user, userID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			endSpanOk(userSpan)
+			return nil
+		}
+		recordSpanError(userSpan, err)
+		return status.Errorf(codes.Internal, "failed to load user quota: %v", err)
+	}
+	endSpanOk(userSpan)
+
+	if user.QuotaBytes <= 0 && user.QuotaObjects <= 0 {
+		return nil
+	}
+
+	_, usageSpan := startSpan(ctx, "db.get_quota_usage")
+	usage, err := getQuotaUsage(db, userID)
+	if err != nil {
+		recordSpanError(usageSpan, err)
+		return status.Errorf(codes.Internal, "failed to compute usage: %v", err)
+	}
+	endSpanOk(usageSpan)
+
+	addObjects, addBytes := objects, sizeBytes
+	if replaces {
+		if isSidecarObjectID(objectID) {
+			var existing database.PhotoSidecar
+			if err := db.Where("object_id = ? AND user_id = ?", objectID, userID).First(&existing).Error; err == nil {
+				addBytes -= existing.SizeBytes
+			}
+		} else {
+			var existing database.PhotoObject
+			if err := db.Where("object_id = ? AND user_id = ?", objectID, userID).First(&existing).Error; err == nil {
+				addObjects = 0
+				addBytes -= existing.SizeBytes
+			}
+		}
+	}
+
+	if user.QuotaObjects > 0 && addObjects > 0 && usage.Objects+addObjects > user.QuotaObjects {
+		slog.WarnContext(ctx, "Object quota exceeded",
+			slog.String("object_id", objectID),
+			slog.Uint64("user_id", uint64(userID)),
+			slog.Int64("quota_objects", user.QuotaObjects),
+		)
+		return status.Errorf(codes.ResourceExhausted, "object quota exceeded: %d of %d objects used", usage.Objects, user.QuotaObjects)
+	}
+	if user.QuotaBytes > 0 && usage.Bytes+addBytes > user.QuotaBytes {
+		slog.WarnContext(ctx, "Storage quota exceeded",
+			slog.String("object_id", objectID),
+			slog.Uint64("user_id", uint64(userID)),
+			slog.Int64("quota_bytes", user.QuotaBytes),
+		)
+		return status.Errorf(codes.ResourceExhausted, "storage quota exceeded: %d of %d bytes used, upload needs %d", usage.Bytes, user.QuotaBytes, sizeBytes)
+	}
+	return nil
+}
+
+// copiedUsage returns the number of originals and bytes a copy of photo
+// adds: the photo itself, its Live Photo video and its XMP sidecar.
+func copiedUsage(db *gorm.DB, userID uint, photo *database.PhotoObject) (int64, int64) {
+	objects, bytes := int64(1), photo.SizeBytes
+	if photo.CompanionObjectID != nil && *photo.CompanionObjectID != "" {
+		var companion database.PhotoObject
+		if err := db.Where("object_id = ? AND user_id = ?", *photo.CompanionObjectID, userID).First(&companion).Error; err == nil {
+			objects++
+			bytes += companion.SizeBytes
+		}
+	}
+	if sidecar := getPhotoSidecar(db, userID, photo.ObjectID); sidecar != nil {
+		bytes += sidecar.SizeBytes
+	}
+	return objects, bytes
+}
+
+// replacesExisting reports whether an upload with policy overwrites an
+// existing object of the same ID rather than adding a new one.
+func replacesExisting(policy proto.ConflictPolicy) bool {
+	switch policy {
+	case proto.ConflictPolicy_CONFLICT_POLICY_UNSPECIFIED,
+		proto.ConflictPolicy_CONFLICT_POLICY_REPLACE,
+		proto.ConflictPolicy_CONFLICT_POLICY_SKIP_IF_IDENTICAL:
+		return true
+	}
+	return false
+}
+
+// GetUsage reports the authenticated user's storage usage and quota, summed
+// from the sizes recorded in the database.
+func (s *LibraryServer) GetUsage(ctx context.Context, req *proto.GetUsageRequest) (*proto.GetUsageResponse, error) {
+	userID, ok := ctx.Value(contextKeyUser{}).(uint)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "authentication required")
+	}
+
+	var originals quotaUsage
+	_, dbSpan := startSpan(ctx, "db.sum_photo_objects")
+	if err := s.DB.Model(&database.PhotoObject{}).
+		Select("COUNT(*) AS objects, COALESCE(SUM(size_bytes), 0) AS bytes").
+		Where("user_id = ?", userID).
+		Scan(&originals).Error; err != nil {
+		recordSpanError(dbSpan, err)
+		return nil, status.Errorf(codes.Internal, "failed to sum photos: %v", err)
+	}
+	endSpanOk(dbSpan)
+
+	_, sidecarSpan := startSpan(ctx, "db.sum_photo_sidecars")
+	sidecarBytes, err := sumSizeBytes(s.DB, &database.PhotoSidecar{}, userID)
+	if err != nil {
+		recordSpanError(sidecarSpan, err)
+		return nil, status.Errorf(codes.Internal, "failed to sum sidecars: %v", err)
+	}
+	endSpanOk(sidecarSpan)
+
+	_, renditionSpan := startSpan(ctx, "db.sum_photo_renditions")
+	renditionBytes, err := sumSizeBytes(s.DB, &database.PhotoRendition{}, userID)
+	if err != nil {
+		recordSpanError(renditionSpan, err)
+		return nil, status.Errorf(codes.Internal, "failed to sum renditions: %v", err)
+	}
+	endSpanOk(renditionSpan)
+
+	var derived []derivedUsage
+	_, derivedSpan := startSpan(ctx, "db.sum_derived_objects")
+	if err := s.DB.Model(&database.DerivedObject{}).
+		Select("kind, COALESCE(SUM(size_bytes), 0) AS bytes").
+		Where("user_id = ?", userID).
+		Group("kind").
+		Scan(&derived).Error; err != nil {
+		recordSpanError(derivedSpan, err)
+		return nil, status.Errorf(codes.Internal, "failed to sum derived objects: %v", err)
+	}
+	endSpanOk(derivedSpan)
+
+	resp := computeUsage(originals, sidecarBytes, renditionBytes, derived)
+
+	var user database.User
+	if err := s.DB.First(&user, userID).Error; err == nil {
+		resp.QuotaBytes = user.QuotaBytes
+		resp.QuotaObjects = user.QuotaObjects
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, status.Errorf(codes.Internal, "failed to load user quota: %v", err)
+	}
+
+	slog.InfoContext(ctx, "Computed usage",
+		slog.Uint64("user_id", uint64(userID)),
+		slog.Int64("object_count", resp.ObjectCount),
+		slog.Int64("total_bytes", resp.TotalBytes),
+	)
+
+	return resp, nil
+}
+
+// derivedUsage is the total size of a user's derived assets of one kind.
+type derivedUsage struct {
+	Kind  string
+	Bytes int64
+}
+
+// computeUsage breaks the storage used by a user down into originals,
+// sidecars, renditions and the derived assets of each kind. A RAW or HEIC
+// file has a JPEG preview; any other type has a thumbnail, counted with the
+// animated preview of a video. Video transcodes count the MP4 proxy and every
+// playlist and segment of the HLS ladder, and the video extracted from a
+// motion photo. The video of a Live Photo is an original. Renditions are
+// counted from their own records rather than as derived assets.
+func computeUsage(originals quotaUsage, sidecarBytes, renditionBytes int64, derived []derivedUsage) *proto.GetUsageResponse {
+	resp := &proto.GetUsageResponse{
+		ObjectCount:    originals.Objects,
+		OriginalBytes:  originals.Bytes,
+		SidecarBytes:   sidecarBytes,
+		RenditionBytes: renditionBytes,
+	}
+	for _, usage := range derived {
+		switch usage.Kind {
+		case database.DerivedKindWebP:
+			resp.WebpBytes += usage.Bytes
+		case database.DerivedKindAVIF:
+			resp.AvifBytes += usage.Bytes
+		case database.DerivedKindProxy, database.DerivedKindHLS, database.DerivedKindMotionVideo:
+			resp.VideoBytes += usage.Bytes
+		case database.DerivedKindPreview:
+			resp.PreviewBytes += usage.Bytes
+		case database.DerivedKindThumbnail, database.DerivedKindAnimatedPreview:
+			resp.ThumbnailBytes += usage.Bytes
+		}
+	}
+	resp.TotalBytes = resp.OriginalBytes + resp.WebpBytes + resp.AvifBytes + resp.VideoBytes + resp.PreviewBytes + resp.ThumbnailBytes + resp.SidecarBytes + resp.RenditionBytes
+	return resp
+}
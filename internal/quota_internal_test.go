@@ -0,0 +1,231 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/alexhokl/photos/database"
+	"github.com/alexhokl/photos/proto"
+	"google.golang.org/grpc/codes"
+)
+
+func TestCheckQuota(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	ctx := contextWithUserID(1)
+
+	users := []database.User{
+		{Username: "limited", QuotaBytes: 1000, QuotaObjects: 2},
+		{Username: "unlimited"},
+	}
+	for i := range users {
+		if err := db.Create(&users[i]).Error; err != nil {
+			t.Fatalf("failed to create user: %v", err)
+		}
+	}
+	photos := []database.PhotoObject{
+		{ObjectID: "a.jpg", ContentType: "image/jpeg", MD5Hash: "a", SizeBytes: 400, UserID: users[0].ID},
+		{ObjectID: "b.jpg", ContentType: "image/jpeg", MD5Hash: "b", SizeBytes: 500, UserID: users[1].ID},
+	}
+	for i := range photos {
+		if err := db.Create(&photos[i]).Error; err != nil {
+			t.Fatalf("failed to create photo: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name        string
+		userID      uint
+		objectID    string
+		size        int64
+		replaces    bool
+		expectedErr codes.Code
+	}{
+		{"within quota", users[0].ID, "c.jpg", 600, false, codes.OK},
+		{"over byte quota", users[0].ID, "c.jpg", 601, false, codes.ResourceExhausted},
+		{"replacing does not count the old size", users[0].ID, "a.jpg", 1000, true, codes.OK},
+		{"replacing still checks the new size", users[0].ID, "a.jpg", 1001, true, codes.ResourceExhausted},
+		{"unlimited user", users[1].ID, "c.jpg", 1 << 40, false, codes.OK},
+		{"no user row", 99, "c.jpg", 1 << 40, false, codes.OK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := checkQuota(ctx, db, test.userID, test.objectID, 1, test.size, test.replaces)
+			if test.expectedErr == codes.OK {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			assertGRPCError(t, err, test.expectedErr)
+		})
+	}
+
+	// A second new object fits; a third exceeds the object quota
+	extra := &database.PhotoObject{ObjectID: "c.jpg", ContentType: "image/jpeg", MD5Hash: "c", SizeBytes: 100, UserID: users[0].ID}
+	if err := db.Create(extra).Error; err != nil {
+		t.Fatalf("failed to create photo: %v", err)
+	}
+	assertGRPCError(t, checkQuota(ctx, db, users[0].ID, "d.jpg", 1, 1, false), codes.ResourceExhausted)
+	if err := checkQuota(ctx, db, users[0].ID, "c.jpg", 1, 100, true); err != nil {
+		t.Errorf("replacing an existing object at the object quota returned error: %v", err)
+	}
+}
+
+func TestCheckQuota_Sidecar(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	ctx := contextWithUserID(1)
+
+	user := &database.User{Username: "limited", QuotaBytes: 1000, QuotaObjects: 1}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	photo := &database.PhotoObject{ObjectID: "a.jpg", ContentType: "image/jpeg", MD5Hash: "a", SizeBytes: 900, UserID: user.ID}
+	if err := db.Create(photo).Error; err != nil {
+		t.Fatalf("failed to create photo: %v", err)
+	}
+	sidecar := &database.PhotoSidecar{ObjectID: "a.xmp", PhotoObjectID: "a.jpg", MD5Hash: "x", SizeBytes: 50, UserID: user.ID}
+	if err := db.Create(sidecar).Error; err != nil {
+		t.Fatalf("failed to create sidecar: %v", err)
+	}
+
+	// A sidecar is not an object, so the object quota does not apply, but
+	// its bytes count alongside the originals
+	if err := checkQuota(ctx, db, user.ID, "b.xmp", 0, 50, false); err != nil {
+		t.Errorf("sidecar within the byte quota returned error: %v", err)
+	}
+	assertGRPCError(t, checkQuota(ctx, db, user.ID, "b.xmp", 0, 51, false), codes.ResourceExhausted)
+	if err := checkQuota(ctx, db, user.ID, "a.xmp", 0, 100, true); err != nil {
+		t.Errorf("replacing a sidecar returned error: %v", err)
+	}
+	assertGRPCError(t, checkQuota(ctx, db, user.ID, "a.xmp", 0, 101, true), codes.ResourceExhausted)
+}
+
+func TestComputeUsage(t *testing.T) {
+	derived := []derivedUsage{
+		{Kind: database.DerivedKindWebP, Bytes: 100},
+		{Kind: database.DerivedKindAVIF, Bytes: 60},
+		{Kind: database.DerivedKindPreview, Bytes: 200},
+		{Kind: database.DerivedKindThumbnail, Bytes: 30},
+		{Kind: database.DerivedKindAnimatedPreview, Bytes: 20},
+		{Kind: database.DerivedKindProxy, Bytes: 700},
+		{Kind: database.DerivedKindHLS, Bytes: 303},
+		{Kind: database.DerivedKindMotionVideo, Bytes: 5},
+		// renditions are counted from their own records
+		{Kind: database.DerivedKindRendition, Bytes: 50},
+	}
+
+	usage := computeUsage(quotaUsage{Objects: 3, Bytes: 15000}, 4, 50, derived)
+
+	expected := &proto.GetUsageResponse{
+		ObjectCount:    3,
+		OriginalBytes:  15000,
+		WebpBytes:      100,
+		AvifBytes:      60,
+		VideoBytes:     1008,
+		PreviewBytes:   200,
+		ThumbnailBytes: 50,
+		SidecarBytes:   4,
+		RenditionBytes: 50,
+		TotalBytes:     16472,
+	}
+	if usage.ObjectCount != expected.ObjectCount ||
+		usage.OriginalBytes != expected.OriginalBytes ||
+		usage.WebpBytes != expected.WebpBytes ||
+		usage.AvifBytes != expected.AvifBytes ||
+		usage.VideoBytes != expected.VideoBytes ||
+		usage.PreviewBytes != expected.PreviewBytes ||
+		usage.ThumbnailBytes != expected.ThumbnailBytes ||
+		usage.SidecarBytes != expected.SidecarBytes ||
+		usage.RenditionBytes != expected.RenditionBytes ||
+		usage.TotalBytes != expected.TotalBytes {
+		t.Errorf("computeUsage() = %+v, want %+v", usage, expected)
+	}
+}
+
+func TestGetUsage(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	server := &LibraryServer{DB: db}
+
+	user := &database.User{Username: "alice", QuotaBytes: 10000}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	photo := &database.PhotoObject{ObjectID: "a.jpg", ContentType: "image/jpeg", MD5Hash: "a", SizeBytes: 1234, UserID: user.ID}
+	if err := db.Create(photo).Error; err != nil {
+		t.Fatalf("failed to create photo: %v", err)
+	}
+	records := []any{
+		&database.DerivedObject{ObjectID: "a.webp", SourceObjectID: "a.jpg", Kind: database.DerivedKindWebP, UserID: user.ID, SizeBytes: 100},
+		&database.DerivedObject{ObjectID: "a_256px.jpg", SourceObjectID: "a.jpg", Kind: database.DerivedKindRendition, UserID: user.ID, SizeBytes: 40},
+		&database.PhotoRendition{ObjectID: "a_256px.jpg", PhotoObjectID: "a.jpg", UserID: user.ID, LongEdge: 256, Width: 256, Height: 192, ContentType: "image/jpeg", SizeBytes: 40},
+		&database.PhotoSidecar{ObjectID: "a.xmp", PhotoObjectID: "a.jpg", MD5Hash: "x", UserID: user.ID, SizeBytes: 4},
+		// another user's assets are not counted
+		&database.DerivedObject{ObjectID: "b.webp", SourceObjectID: "b.jpg", Kind: database.DerivedKindWebP, UserID: user.ID + 1, SizeBytes: 999},
+	}
+	for _, record := range records {
+		if err := db.Create(record).Error; err != nil {
+			t.Fatalf("failed to create record: %v", err)
+		}
+	}
+
+	resp, err := server.GetUsage(contextWithUserID(user.ID), &proto.GetUsageRequest{})
+	if err != nil {
+		t.Fatalf("GetUsage returned error: %v", err)
+	}
+	if resp.ObjectCount != 1 || resp.OriginalBytes != 1234 || resp.WebpBytes != 100 ||
+		resp.RenditionBytes != 40 || resp.SidecarBytes != 4 || resp.TotalBytes != 1378 {
+		t.Errorf("usage = %+v, want 1 object of 1234 bytes and 144 bytes of other assets", resp)
+	}
+	if resp.QuotaBytes != 10000 || resp.QuotaObjects != 0 {
+		t.Errorf("quota = %d bytes / %d objects, want 10000 / 0", resp.QuotaBytes, resp.QuotaObjects)
+	}
+
+	_, err = server.GetUsage(t.Context(), &proto.GetUsageRequest{})
+	assertGRPCError(t, err, codes.Unauthenticated)
+}
+
+func TestCopyPhoto_QuotaExceeded(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	server := &LibraryServer{DB: db}
+
+	user := &database.User{Username: "alice", QuotaObjects: 1}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	photo := &database.PhotoObject{ObjectID: "a.jpg", ContentType: "image/jpeg", MD5Hash: "a", SizeBytes: 10, UserID: user.ID}
+	if err := db.Create(photo).Error; err != nil {
+		t.Fatalf("failed to create photo: %v", err)
+	}
+
+	_, err := server.CopyPhoto(contextWithUserID(user.ID), &proto.CopyPhotoRequest{
+		SourceObjectId:      "a.jpg",
+		DestinationObjectId: "b.jpg",
+	})
+	assertGRPCError(t, err, codes.ResourceExhausted)
+}
+
+func TestCopyPhoto_QuotaCountsCompanion(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	server := &LibraryServer{DB: db}
+
+	user := &database.User{Username: "alice", QuotaObjects: 3}
+	if err := db.Create(user).Error; err != nil {
+		t.Fatalf("failed to create user: %v", err)
+	}
+	companion := "a.mov"
+	photos := []database.PhotoObject{
+		{ObjectID: "a.heic", ContentType: "image/heic", MD5Hash: "a", SizeBytes: 10, UserID: user.ID, CompanionObjectID: &companion},
+		{ObjectID: "a.mov", ContentType: "video/quicktime", MD5Hash: "b", SizeBytes: 20, UserID: user.ID},
+	}
+	for i := range photos {
+		if err := db.Create(&photos[i]).Error; err != nil {
+			t.Fatalf("failed to create photo: %v", err)
+		}
+	}
+
+	// The copy fits on its own, but not with its Live Photo video
+	_, err := server.CopyPhoto(contextWithUserID(user.ID), &proto.CopyPhotoRequest{
+		SourceObjectId:      "a.heic",
+		DestinationObjectId: "b.heic",
+	})
+	assertGRPCError(t, err, codes.ResourceExhausted)
+}
@@ -0,0 +1,413 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+)
+
+// RawFormat is a camera RAW format, recognised by the extension of its files
+// and its content types. RAW photos are displayed from a JPEG preview
+// generated by GenerateRawPreview.
+type RawFormat struct {
+	// Name describes the format, such as "Nikon NEF"
+	Name string
+	// Extensions are the lower-case file extensions, with the leading dot
+	Extensions []string
+	// ContentTypes are the content types of the format; the first is the one
+	// uploads are stored with
+	ContentTypes []string
+	// TIFF is true for formats laid out as a TIFF file, which
+	// DetectContentType cannot tell apart from TIFF images or each other
+	TIFF bool
+}
+
+// RawFormats are the supported camera RAW formats.
+var RawFormats = []RawFormat{
+	{Name: "Adobe DNG", Extensions: []string{".dng"}, ContentTypes: []string{"image/x-adobe-dng", "image/dng", "image/x-dng"}, TIFF: true},
+	{Name: "Canon CR2", Extensions: []string{".cr2"}, ContentTypes: []string{"image/x-canon-cr2"}, TIFF: true},
+	{Name: "Canon CR3", Extensions: []string{".cr3"}, ContentTypes: []string{"image/x-canon-cr3"}},
+	{Name: "Nikon NEF", Extensions: []string{".nef", ".nrw"}, ContentTypes: []string{"image/x-nikon-nef", "image/x-nikon-nrw"}, TIFF: true},
+	{Name: "Sony ARW", Extensions: []string{".arw", ".srf", ".sr2"}, ContentTypes: []string{"image/x-sony-arw", "image/x-sony-srf", "image/x-sony-sr2"}, TIFF: true},
+	{Name: "Fujifilm RAF", Extensions: []string{".raf"}, ContentTypes: []string{"image/x-fuji-raf"}},
+	{Name: "Olympus ORF", Extensions: []string{".orf"}, ContentTypes: []string{"image/x-olympus-orf"}},
+}
+
+// rawFormatByContentType returns the RAW format with contentType, or nil if
+// contentType is not a RAW format.
+func rawFormatByContentType(contentType string) *RawFormat {
+	contentType = strings.ToLower(contentType)
+	for i := range RawFormats {
+		for _, candidate := range RawFormats[i].ContentTypes {
+			if candidate == contentType {
+				return &RawFormats[i]
+			}
+		}
+	}
+	return nil
+}
+
+// rawFormatByExtension returns the RAW format of the file name, matched by
+// its extension case-insensitively, or nil if it is not a RAW format.
+func rawFormatByExtension(name string) *RawFormat {
+	ext := strings.ToLower(path.Ext(name))
+	for i := range RawFormats {
+		for _, candidate := range RawFormats[i].Extensions {
+			if candidate == ext {
+				return &RawFormats[i]
+			}
+		}
+	}
+	return nil
+}
+
+// refineTIFFContentType returns the content type of the TIFF-based RAW
+// format named by the extension of objectID if detected is plain TIFF, as
+// DetectContentType cannot tell NEF and ARW files from TIFF images by their
+// content. Any other detected content type is returned unchanged.
+func refineTIFFContentType(objectID, detected string) string {
+	if detected != "image/tiff" {
+		return detected
+	}
+	if format := rawFormatByExtension(objectID); format != nil && format.TIFF {
+		return format.ContentTypes[0]
+	}
+	return detected
+}
+
+// IsRawContentType returns true if the content type represents a camera RAW
+// image of one of RawFormats.
+func IsRawContentType(contentType string) bool {
+	return rawFormatByContentType(contentType) != nil
+}
+
+// GenerateRawPreview generates a JPEG preview from camera RAW image data using
+// dcraw.
+//
+// It first tries to extract the embedded thumbnail/preview with "dcraw -e -c"
+// (fast, lossless). If that produces no output, it falls back to a full
+// demosaic via "dcraw -w -c" (PPM output) which is then encoded to JPEG.
+//
+// If dcraw is not installed, or cannot read the file (as for CR3, which it
+// predates), the largest embedded JPEG preview is extracted in Go instead
+// (see extractRawPreview).
+func GenerateRawPreview(caps *Capabilities, data []byte) ([]byte, error) {
+	if !caps.Has(ToolDCRaw) {
+		return extractRawPreview(data)
+	}
+	preview, err := generateDCRawPreview(data)
+	if err != nil {
+		if extracted, extractErr := extractRawPreview(data); extractErr == nil {
+			return extracted, nil
+		}
+		return nil, err
+	}
+	return preview, nil
+}
+
+// generateDCRawPreview generates a JPEG preview from RAW data with dcraw.
+func generateDCRawPreview(data []byte) ([]byte, error) {
+	// Write RAW data to a temporary file because dcraw requires a file path.
+	tmpFile, err := os.CreateTemp("", "raw-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer func() {
+		_ = tmpFile.Close()
+		_ = os.Remove(tmpFile.Name())
+	}()
+
+	if _, err := tmpFile.Write(data); err != nil {
+		return nil, fmt.Errorf("failed to write RAW temp file: %w", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close RAW temp file: %w", err)
+	}
+
+	// Attempt 1: extract the embedded JPEG thumbnail (-e = extract thumbnail, -c = write to stdout).
+	ctx1, cancel1 := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel1()
+
+	cmd1 := exec.CommandContext(ctx1, "dcraw", "-e", "-c", tmpFile.Name())
+	embeddedJPEG, err1 := cmd1.Output()
+	if err1 == nil && len(embeddedJPEG) > 0 {
+		return embeddedJPEG, nil
+	}
+
+	// Attempt 2: full demosaic to PPM (raw colour output) then encode to JPEG.
+	// -w  = use camera white balance
+	// -c  = write to stdout
+	// (default output is PPM)
+	ctx2, cancel2 := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel2()
+
+	cmd2 := exec.CommandContext(ctx2, "dcraw", "-w", "-c", tmpFile.Name())
+	var stderr2 bytes.Buffer
+	cmd2.Stderr = &stderr2
+
+	ppmData, err2 := cmd2.Output()
+	if err2 != nil {
+		return nil, fmt.Errorf("dcraw demosaic failed: %w, stderr: %s", err2, stderr2.String())
+	}
+	if len(ppmData) == 0 {
+		return nil, fmt.Errorf("dcraw produced no output")
+	}
+
+	// Decode the PPM image and re-encode as JPEG.
+	img, _, err := image.Decode(bytes.NewReader(ppmData))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode dcraw PPM output: %w", err)
+	}
+
+	var jpegBuf bytes.Buffer
+	if err := jpeg.Encode(&jpegBuf, img, &jpeg.Options{Quality: 90}); err != nil {
+		return nil, fmt.Errorf("failed to encode RAW preview as JPEG: %w", err)
+	}
+
+	return jpegBuf.Bytes(), nil
+}
+
+// TIFF tags read by extractRawPreview
+const (
+	tiffTagCompression                 = 259
+	tiffTagStripOffsets                = 273
+	tiffTagStripByteCounts             = 279
+	tiffTagSubIFDs                     = 330
+	tiffTagJPEGInterchangeFormat       = 513
+	tiffTagJPEGInterchangeFormatLength = 514
+)
+
+// maxTIFFIFDs bounds the number of IFDs extractRawPreview visits, guarding
+// against loops in malformed files.
+const maxTIFFIFDs = 64
+
+// errNoRawPreview is returned by extractRawPreview for a RAW file without a
+// decodable JPEG preview.
+var errNoRawPreview = errors.New("no embedded JPEG preview found")
+
+// rafMagic starts Fujifilm RAF files, whose header locates the JPEG preview.
+const rafMagic = "FUJIFILMCCD-RAW "
+
+// extractRawPreview returns the largest baseline JPEG embedded in RAW data.
+// TIFF-based files (DNG, CR2, NEF, ARW) are searched by walking their IFDs
+// and SubIFDs, and RAF files by their header. Files where neither finds a
+// preview, such as CR3 and ORF, which keep theirs in vendor boxes or maker
+// notes, are scanned for JPEG markers. Raw image data, even if stored as
+// lossless JPEG, is skipped as it cannot be decoded by image/jpeg.
+func extractRawPreview(data []byte) ([]byte, error) {
+	var best []byte
+	bestPixels := 0
+	consider := func(start, length uint64) {
+		if length == 0 || start+length > uint64(len(data)) {
+			return
+		}
+		candidate := data[start : start+length]
+		config, err := jpeg.DecodeConfig(bytes.NewReader(candidate))
+		if err != nil {
+			return
+		}
+		if pixels := config.Width * config.Height; pixels > bestPixels {
+			best, bestPixels = candidate, pixels
+		}
+	}
+
+	if order, ok := tiffByteOrder(data); ok {
+		for _, segment := range tiffJPEGSegments(data, order) {
+			consider(uint64(segment[0]), uint64(segment[1]))
+		}
+	}
+	if len(data) >= 92 && string(data[:len(rafMagic)]) == rafMagic {
+		consider(uint64(binary.BigEndian.Uint32(data[84:88])), uint64(binary.BigEndian.Uint32(data[88:92])))
+	}
+	if best == nil {
+		for _, segment := range scanJPEGs(data) {
+			consider(uint64(segment[0]), uint64(segment[1]))
+		}
+	}
+
+	if best == nil {
+		return nil, errNoRawPreview
+	}
+	return best, nil
+}
+
+// tiffByteOrder returns the byte order of TIFF-based data, including ORF
+// files, which replace the TIFF magic number with their own.
+func tiffByteOrder(data []byte) (binary.ByteOrder, bool) {
+	if len(data) < 8 {
+		return nil, false
+	}
+	switch string(data[:4]) {
+	case "II*\x00", "IIRO", "IIRS":
+		return binary.LittleEndian, true
+	case "MM\x00*", "MMOR":
+		return binary.BigEndian, true
+	}
+	return nil, false
+}
+
+// tiffJPEGSegments returns the offset and length of the JPEG data held by the
+// IFDs of TIFF-based data and their SubIFDs.
+func tiffJPEGSegments(data []byte, order binary.ByteOrder) [][2]uint32 {
+	var segments [][2]uint32
+	visited := make(map[uint32]bool)
+	pending := []uint32{order.Uint32(data[4:8])}
+	for len(pending) > 0 && len(visited) < maxTIFFIFDs {
+		offset := pending[0]
+		pending = pending[1:]
+		if offset == 0 || visited[offset] {
+			continue
+		}
+		visited[offset] = true
+
+		entries, next, ok := readTIFFIFD(data, order, offset)
+		if !ok {
+			continue
+		}
+		pending = append(pending, next)
+		pending = append(pending, entries[tiffTagSubIFDs]...)
+		segments = append(segments, jpegSegments(entries)...)
+	}
+	return segments
+}
+
+// scanJPEGs returns the offset and length of each complete JPEG found by
+// searching data for start of image markers. JPEGs nested in another, such as
+// the EXIF thumbnail of a preview, are not returned separately.
+func scanJPEGs(data []byte) [][2]uint32 {
+	var segments [][2]uint32
+	soi := []byte{0xFF, 0xD8, 0xFF}
+	for i := 0; i < len(data); {
+		found := bytes.Index(data[i:], soi)
+		if found < 0 {
+			break
+		}
+		start := i + found
+		if length := jpegLength(data[start:]); length > 0 {
+			segments = append(segments, [2]uint32{uint32(start), uint32(length)})
+			i = start + length
+			continue
+		}
+		i = start + len(soi)
+	}
+	return segments
+}
+
+// jpegLength returns the length of the JPEG at the start of data, found by
+// following its marker segments and entropy-coded scans to the end of image
+// marker, or 0 if data does not hold a complete JPEG.
+func jpegLength(data []byte) int {
+	i := 2
+	for i+2 <= len(data) {
+		if data[i] != 0xFF {
+			return 0
+		}
+		marker := data[i+1]
+		switch {
+		case marker == 0xFF:
+			// Fill byte
+			i++
+			continue
+		case marker == 0xD9:
+			return i + 2
+		case marker == 0x01, marker >= 0xD0 && marker <= 0xD7:
+			// Markers without a length
+			i += 2
+			continue
+		}
+		if i+4 > len(data) {
+			return 0
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		if length < 2 {
+			return 0
+		}
+		i += 2 + length
+		if marker != 0xDA {
+			continue
+		}
+		// In the entropy-coded data after a scan header, 0xFF is only
+		// followed by a stuffed zero or a restart marker
+		for i+1 < len(data) && (data[i] != 0xFF || data[i+1] == 0 || data[i+1] >= 0xD0 && data[i+1] <= 0xD7) {
+			i++
+		}
+	}
+	return 0
+}
+
+// jpegSegments returns the offset and length of the JPEG data an IFD may
+// hold: its JPEGInterchangeFormat, or its only strip if it is JPEG
+// compressed.
+func jpegSegments(entries map[uint16][]uint32) [][2]uint32 {
+	var segments [][2]uint32
+	if offsets, lengths := entries[tiffTagJPEGInterchangeFormat], entries[tiffTagJPEGInterchangeFormatLength]; len(offsets) == 1 && len(lengths) == 1 {
+		segments = append(segments, [2]uint32{offsets[0], lengths[0]})
+	}
+	compression := entries[tiffTagCompression]
+	if len(compression) == 1 && (compression[0] == 6 || compression[0] == 7) {
+		if offsets, lengths := entries[tiffTagStripOffsets], entries[tiffTagStripByteCounts]; len(offsets) == 1 && len(lengths) == 1 {
+			segments = append(segments, [2]uint32{offsets[0], lengths[0]})
+		}
+	}
+	return segments
+}
+
+// readTIFFIFD reads the IFD at offset, returning the values of its SHORT,
+// LONG and IFD entries by tag and the offset of the next IFD.
+func readTIFFIFD(data []byte, order binary.ByteOrder, offset uint32) (map[uint16][]uint32, uint32, bool) {
+	start := uint64(offset)
+	if start+2 > uint64(len(data)) {
+		return nil, 0, false
+	}
+	count := uint64(order.Uint16(data[start:]))
+	end := start + 2 + count*12
+	if end+4 > uint64(len(data)) {
+		return nil, 0, false
+	}
+
+	entries := make(map[uint16][]uint32, count)
+	for i := uint64(0); i < count; i++ {
+		entry := data[start+2+i*12:]
+		tag := order.Uint16(entry[0:2])
+		fieldType := order.Uint16(entry[2:4])
+		n := uint64(order.Uint32(entry[4:8]))
+
+		var size uint64
+		switch fieldType {
+		case 3: // SHORT
+			size = 2
+		case 4, 13: // LONG, IFD
+			size = 4
+		default:
+			continue
+		}
+		valueStart := start + 2 + i*12 + 8
+		if n*size > 4 {
+			valueStart = uint64(order.Uint32(entry[8:12]))
+		}
+		if n > maxTIFFIFDs || valueStart+n*size > uint64(len(data)) {
+			continue
+		}
+
+		values := make([]uint32, n)
+		for j := range values {
+			value := data[valueStart+uint64(j)*size:]
+			if size == 2 {
+				values[j] = uint32(order.Uint16(value))
+			} else {
+				values[j] = order.Uint32(value)
+			}
+		}
+		entries[tag] = values
+	}
+	return entries, order.Uint32(data[end:]), true
+}
@@ -0,0 +1,223 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"strings"
+	"testing"
+)
+
+// buildTestDNG returns a little-endian TIFF whose IFD0 holds small as its
+// JPEGInterchangeFormat and whose SubIFD holds large as a JPEG-compressed
+// strip, as DNG files lay out their thumbnail and preview.
+func buildTestDNG(t *testing.T, small, large []byte) []byte {
+	t.Helper()
+	type entry struct {
+		tag, fieldType uint16
+		value          uint32
+	}
+	writeIFD := func(buf *bytes.Buffer, entries []entry) {
+		_ = binary.Write(buf, binary.LittleEndian, uint16(len(entries)))
+		for _, e := range entries {
+			_ = binary.Write(buf, binary.LittleEndian, e.tag)
+			_ = binary.Write(buf, binary.LittleEndian, e.fieldType)
+			_ = binary.Write(buf, binary.LittleEndian, uint32(1))
+			if e.fieldType == 3 {
+				_ = binary.Write(buf, binary.LittleEndian, uint16(e.value))
+				_ = binary.Write(buf, binary.LittleEndian, uint16(0))
+			} else {
+				_ = binary.Write(buf, binary.LittleEndian, e.value)
+			}
+		}
+		_ = binary.Write(buf, binary.LittleEndian, uint32(0))
+	}
+
+	const ifd0Offset = 8
+	ifd0Size := 2 + 3*12 + 4
+	subIFDOffset := ifd0Offset + ifd0Size
+	subIFDSize := 2 + 3*12 + 4
+	smallOffset := subIFDOffset + subIFDSize
+	largeOffset := smallOffset + len(small)
+
+	var buf bytes.Buffer
+	buf.WriteString("II*\x00")
+	_ = binary.Write(&buf, binary.LittleEndian, uint32(ifd0Offset))
+	writeIFD(&buf, []entry{
+		{tiffTagSubIFDs, 4, uint32(subIFDOffset)},
+		{tiffTagJPEGInterchangeFormat, 4, uint32(smallOffset)},
+		{tiffTagJPEGInterchangeFormatLength, 4, uint32(len(small))},
+	})
+	writeIFD(&buf, []entry{
+		{tiffTagCompression, 3, 7},
+		{tiffTagStripOffsets, 4, uint32(largeOffset)},
+		{tiffTagStripByteCounts, 4, uint32(len(large))},
+	})
+	buf.Write(small)
+	buf.Write(large)
+	return buf.Bytes()
+}
+
+func encodeTestJPEG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, width, height)), nil); err != nil {
+		t.Fatalf("failed to encode JPEG: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtractRawPreview(t *testing.T) {
+	small := encodeTestJPEG(t, 16, 12)
+	large := encodeTestJPEG(t, 64, 48)
+
+	preview, err := extractRawPreview(buildTestDNG(t, small, large))
+	if err != nil {
+		t.Fatalf("extractRawPreview returned error: %v", err)
+	}
+	if !bytes.Equal(preview, large) {
+		t.Errorf("expected the largest embedded JPEG (%d bytes), got %d bytes", len(large), len(preview))
+	}
+}
+
+func TestExtractRawPreview_NoPreview(t *testing.T) {
+	// Raw data that is not a baseline JPEG is skipped
+	data := buildTestDNG(t, []byte("raw"), []byte("more raw data"))
+
+	if _, err := extractRawPreview(data); err == nil {
+		t.Error("expected error for a DNG without a JPEG preview")
+	}
+	for _, data := range [][]byte{nil, []byte("not a tiff"), []byte("II*\x00\xff\xff\xff\xff")} {
+		if _, err := extractRawPreview(data); err == nil {
+			t.Errorf("extractRawPreview(%q): expected error, got nil", data)
+		}
+	}
+}
+
+func TestExtractRawPreview_RAF(t *testing.T) {
+	preview := encodeTestJPEG(t, 32, 24)
+
+	header := make([]byte, 100)
+	copy(header, rafMagic)
+	binary.BigEndian.PutUint32(header[84:88], uint32(len(header)))
+	binary.BigEndian.PutUint32(header[88:92], uint32(len(preview)))
+	data := append(header, preview...)
+	data = append(data, "raw sensor data"...)
+
+	got, err := extractRawPreview(data)
+	if err != nil {
+		t.Fatalf("extractRawPreview returned error: %v", err)
+	}
+	if !bytes.Equal(got, preview) {
+		t.Errorf("expected the JPEG located by the RAF header (%d bytes), got %d bytes", len(preview), len(got))
+	}
+}
+
+func TestExtractRawPreview_Scan(t *testing.T) {
+	small := encodeTestJPEG(t, 16, 12)
+	large := encodeTestJPEG(t, 64, 48)
+
+	// CR3 files keep their previews in vendor boxes, and ORF files in the
+	// maker notes, where only a scan finds them
+	for name, header := range map[string][]byte{
+		"CR3": []byte("\x00\x00\x00\x18ftypcrx \x00\x00\x00\x01crx isom"),
+		"ORF": []byte("IIRO\x08\x00\x00\x00\x00\x00\x00\x00\x00\x00"),
+	} {
+		var data []byte
+		data = append(data, header...)
+		data = append(data, 0xFF, 0xD8, 0xFF, 0x00)
+		data = append(data, small...)
+		data = append(data, "padding"...)
+		data = append(data, large...)
+		data = append(data, "raw sensor data"...)
+
+		got, err := extractRawPreview(data)
+		if err != nil {
+			t.Fatalf("%s: extractRawPreview returned error: %v", name, err)
+		}
+		if !bytes.Equal(got, large) {
+			t.Errorf("%s: expected the largest JPEG (%d bytes), got %d bytes", name, len(large), len(got))
+		}
+	}
+}
+
+func TestJPEGLength(t *testing.T) {
+	preview := encodeTestJPEG(t, 8, 8)
+
+	if got := jpegLength(append(bytes.Clone(preview), "trailing"...)); got != len(preview) {
+		t.Errorf("jpegLength() = %d, want %d", got, len(preview))
+	}
+	if got := jpegLength(preview[:len(preview)-2]); got != 0 {
+		t.Errorf("jpegLength(truncated) = %d, want 0", got)
+	}
+}
+
+func TestRawFormats(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		format      string
+	}{
+		{"IMG_0001.DNG", "image/x-adobe-dng", "Adobe DNG"},
+		{"IMG_0001.CR2", "image/x-canon-cr2", "Canon CR2"},
+		{"IMG_0001.cr3", "image/x-canon-cr3", "Canon CR3"},
+		{"DSC_0001.NEF", "image/x-nikon-nef", "Nikon NEF"},
+		{"DSC00001.ARW", "image/x-sony-arw", "Sony ARW"},
+		{"DSCF0001.RAF", "image/x-fuji-raf", "Fujifilm RAF"},
+		{"P1010001.ORF", "image/x-olympus-orf", "Olympus ORF"},
+	}
+	for _, test := range tests {
+		byExtension := rawFormatByExtension(test.name)
+		if byExtension == nil || byExtension.Name != test.format {
+			t.Errorf("rawFormatByExtension(%q) = %v, want %s", test.name, byExtension, test.format)
+		}
+		byContentType := rawFormatByContentType(strings.ToUpper(test.contentType))
+		if byContentType == nil || byContentType.Name != test.format {
+			t.Errorf("rawFormatByContentType(%q) = %v, want %s", test.contentType, byContentType, test.format)
+		}
+		if !IsRawContentType(test.contentType) || !HasPreviewContentType(test.contentType) {
+			t.Errorf("expected %s to be a RAW content type with a preview", test.contentType)
+		}
+	}
+
+	for _, name := range []string{"photo.jpg", "clip.mov", "noext"} {
+		if format := rawFormatByExtension(name); format != nil {
+			t.Errorf("rawFormatByExtension(%q) = %s, want nil", name, format.Name)
+		}
+	}
+	if IsRawContentType("image/tiff") {
+		t.Error("expected image/tiff not to be a RAW content type")
+	}
+}
+
+func TestRefineTIFFContentType(t *testing.T) {
+	tests := []struct {
+		objectID string
+		detected string
+		expected string
+	}{
+		{"DSC_0001.NEF", "image/tiff", "image/x-nikon-nef"},
+		{"DSC00001.arw", "image/tiff", "image/x-sony-arw"},
+		{"scan.tif", "image/tiff", "image/tiff"},
+		// Not TIFF based, so a TIFF named like one is kept as TIFF
+		{"DSCF0001.RAF", "image/tiff", "image/tiff"},
+		// Only plain TIFF is refined
+		{"photo.nef", "image/jpeg", "image/jpeg"},
+	}
+	for _, test := range tests {
+		if got := refineTIFFContentType(test.objectID, test.detected); got != test.expected {
+			t.Errorf("refineTIFFContentType(%q, %q) = %q, want %q", test.objectID, test.detected, got, test.expected)
+		}
+	}
+}
+
+func TestExtractPhotoMetadata_RawWithoutPreview(t *testing.T) {
+	// A TIFF-based RAW file without an embedded preview has its EXIF read
+	// from the file itself
+	info := ExtractPhotoMetadata(buildTestExif(t), "DSC_0001.NEF")
+
+	if info.CameraMake != "Apple" || !info.HasDateTaken || !info.HasLocation {
+		t.Errorf("expected EXIF read from the RAW file, got %+v", info)
+	}
+}
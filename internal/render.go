@@ -0,0 +1,430 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log/slog"
+	"math"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/alexhokl/photos/database"
+	"github.com/alexhokl/photos/proto"
+	"github.com/rwcarlsen/goexif/exif"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/tiff"
+	_ "golang.org/x/image/webp"
+	"golang.org/x/sync/singleflight"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// maxRenderDimension is the largest width or height a rendition may have.
+const maxRenderDimension = 4096
+
+// renderJPEGQuality is the quality of JPEG renditions.
+const renderJPEGQuality = 85
+
+// maxRenderSourcePixels is the largest number of pixels of an image that is
+// decoded for a rendition or thumbnail, so that a small file declaring huge
+// dimensions cannot exhaust memory.
+const maxRenderSourcePixels = 100_000_000
+
+// renderTimeout bounds a shared render, which outlives the request that
+// started it if other requests are waiting on it.
+const renderTimeout = 2 * time.Minute
+
+// renderOptions are the normalised parameters of a rendition.
+type renderOptions struct {
+	Width  int
+	Height int
+	Fit    proto.RenderFit
+	Format proto.RenderFormat
+}
+
+// cacheKey identifies the rendition of the content with md5Hash (base64, as
+// stored by GCS) under these options.
+func (o renderOptions) cacheKey(md5Hash string) string {
+	sum := sha256.Sum256(fmt.Appendf(nil, "%s|w=%d|h=%d|fit=%s|fmt=%s", md5Hash, o.Width, o.Height, o.Fit, o.Format))
+	return hex.EncodeToString(sum[:])
+}
+
+// normaliseRenderRequest validates req and resolves its defaults.
+func normaliseRenderRequest(req *proto.RenderPhotoRequest) (renderOptions, error) {
+	if req.GetObjectId() == "" {
+		return renderOptions{}, status.Errorf(codes.InvalidArgument, "object_id is required")
+	}
+	width, height := int(req.GetWidth()), int(req.GetHeight())
+	if width < 0 || height < 0 || width > maxRenderDimension || height > maxRenderDimension {
+		return renderOptions{}, status.Errorf(codes.InvalidArgument, "width and height must be between 0 and %d", maxRenderDimension)
+	}
+	if width == 0 && height == 0 {
+		return renderOptions{}, status.Errorf(codes.InvalidArgument, "width or height is required")
+	}
+
+	opts := renderOptions{Width: width, Height: height, Fit: req.GetFit(), Format: req.GetFormat()}
+	if opts.Fit == proto.RenderFit_RENDER_FIT_UNSPECIFIED {
+		opts.Fit = proto.RenderFit_RENDER_FIT_CONTAIN
+	}
+	if opts.Format == proto.RenderFormat_RENDER_FORMAT_UNSPECIFIED {
+		opts.Format = proto.RenderFormat_RENDER_FORMAT_JPEG
+	}
+	// With a single dimension the box follows the photo, so fit is moot
+	if width == 0 || height == 0 {
+		opts.Fit = proto.RenderFit_RENDER_FIT_CONTAIN
+	}
+	return opts, nil
+}
+
+// IsRenderableContentType returns true for image formats that can be
+// decoded in pure Go and therefore rendered directly. Other photos and
+// videos are rendered from their stored preview or thumbnail.
+func IsRenderableContentType(contentType string) bool {
+	switch strings.ToLower(contentType) {
+	case "image/jpeg", "image/jpg", "image/png", "image/gif", "image/webp", "image/tiff":
+		return true
+	}
+	return false
+}
+
+// RenderPhoto returns a resized rendition of a photo. Renditions are cached
+// on local disk by the MD5 of the source and the requested parameters, so a
+// photo that changes gets new renditions while an unchanged one is decoded
+// only once. Photos that cannot be decoded in pure Go (RAW, HEIC, videos) are
+// rendered from their preview or thumbnail.
+func (s *BytesServer) RenderPhoto(ctx context.Context, req *proto.RenderPhotoRequest) (*proto.RenderPhotoResponse, error) {
+	userID, ok := ctx.Value(contextKeyUser{}).(uint)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "authentication required")
+	}
+
+	opts, err := normaliseRenderRequest(req)
+	if err != nil {
+		return nil, err
+	}
+
+	objectID := req.GetObjectId()
+	bucket := s.GCSClient.Bucket(s.BucketName)
+
+	sourceID, attrs, err := s.renderSource(ctx, bucket, userID, objectID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Without cwebp WebP renditions are JPEG, so they are keyed as such
+	if opts.Format == proto.RenderFormat_RENDER_FORMAT_WEBP && !s.Capabilities.Has(ToolCWebP) {
+		opts.Format = proto.RenderFormat_RENDER_FORMAT_JPEG
+	}
+	md5Hash := base64.StdEncoding.EncodeToString(attrs.MD5)
+	key := opts.cacheKey(md5Hash)
+	if inm := req.GetIfNoneMatch(); inm != "" && etagListMatches(inm, `"`+key+`"`) {
+		return &proto.RenderPhotoResponse{Etag: key, NotModified: true}, nil
+	}
+	if data, contentType, ok := s.RenderCache.Get(key); ok {
+		return renderPhotoResponse(key, data, contentType), nil
+	}
+
+	// Concurrent requests for the same rendition, typical of a grid view
+	// being opened on several devices, share a single render. It is not
+	// cancelled with the request that started it, as the others are still
+	// waiting on it; each request stops waiting when it is cancelled.
+	renders := s.renders.DoChan(key, func() (any, error) {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), renderTimeout)
+		defer cancel()
+
+		_, readSpan := startSpan(ctx, "gcs.read_object")
+		reader, err := bucket.Object(sourceID).NewReader(ctx)
+		if err != nil {
+			recordSpanError(readSpan, err)
+			return nil, status.Errorf(codes.Internal, "failed to create reader for object: %v", err)
+		}
+		defer func() { _ = reader.Close() }()
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			recordSpanError(readSpan, err)
+			return nil, status.Errorf(codes.Internal, "failed to read object data: %v", err)
+		}
+		endSpanOk(readSpan)
+
+		_, renderSpan := startSpan(ctx, "image.render")
+		rendered, contentType, err := renderImage(ctx, data, opts, s.Capabilities, s.WebPQuality)
+		if err != nil {
+			recordSpanError(renderSpan, err)
+			return nil, status.Errorf(codes.FailedPrecondition, "failed to render %s: %v", sourceID, err)
+		}
+		endSpanOk(renderSpan)
+
+		// A rendition that fell back to JPEG is kept under the key of JPEG,
+		// so that a later request can still produce WebP
+		renderedKey := key
+		if contentType != renderContentType(opts.Format) {
+			fallback := opts
+			fallback.Format = proto.RenderFormat_RENDER_FORMAT_JPEG
+			renderedKey = fallback.cacheKey(md5Hash)
+		}
+		if err := s.RenderCache.Put(renderedKey, contentType, rendered); err != nil {
+			slog.WarnContext(ctx, "Failed to cache rendition",
+				slog.String("object_id", objectID),
+				slog.String("error", err.Error()),
+			)
+		}
+		return renderPhotoResponse(renderedKey, rendered, contentType), nil
+	})
+	var result singleflight.Result
+	select {
+	case <-ctx.Done():
+		return nil, status.FromContextError(ctx.Err()).Err()
+	case result = <-renders:
+	}
+	if result.Err != nil {
+		return nil, result.Err
+	}
+
+	resp := result.Val.(*proto.RenderPhotoResponse)
+	slog.InfoContext(
+		ctx,
+		"Rendered photo",
+		slog.String("object_id", objectID),
+		slog.String("source_object_id", sourceID),
+		slog.Int("width", int(resp.GetWidth())),
+		slog.Int("height", int(resp.GetHeight())),
+		slog.String("content_type", resp.GetContentType()),
+		slog.Int("size_bytes", len(resp.GetData())),
+	)
+	return resp, nil
+}
+
+// renderSource returns the object a rendition of objectID is made from and
+// its attributes: the photo itself if it can be decoded, otherwise its
+// preview or thumbnail.
+func (s *BytesServer) renderSource(ctx context.Context, bucket *storage.BucketHandle, userID uint, objectID string) (string, *storage.ObjectAttrs, error) {
+	_, attrsSpan := startSpan(ctx, "gcs.get_object_attrs")
+	attrs, err := bucket.Object(objectID).Attrs(ctx)
+	if err != nil {
+		recordSpanError(attrsSpan, err)
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return "", nil, status.Errorf(codes.NotFound, "object not found: %s", objectID)
+		}
+		return "", nil, status.Errorf(codes.Internal, "failed to get object attributes: %v", err)
+	}
+	endSpanOk(attrsSpan)
+
+	if IsRenderableContentType(attrs.ContentType) {
+		return objectID, attrs, nil
+	}
+
+	var photoObject database.PhotoObject
+	if err := s.DB.Where("object_id = ? AND user_id = ?", objectID, userID).First(&photoObject).Error; err != nil || photoObject.ThumbnailObjectID == nil {
+		return "", nil, status.Errorf(codes.FailedPrecondition, "cannot render %s of type %s", objectID, attrs.ContentType)
+	}
+	sourceID := *photoObject.ThumbnailObjectID
+
+	_, previewSpan := startSpan(ctx, "gcs.get_object_attrs")
+	previewAttrs, err := bucket.Object(sourceID).Attrs(ctx)
+	if err != nil {
+		recordSpanError(previewSpan, err)
+		if errors.Is(err, storage.ErrObjectNotExist) {
+			return "", nil, status.Errorf(codes.FailedPrecondition, "preview of %s is missing: %s", objectID, sourceID)
+		}
+		return "", nil, status.Errorf(codes.Internal, "failed to get object attributes: %v", err)
+	}
+	endSpanOk(previewSpan)
+	return sourceID, previewAttrs, nil
+}
+
+// renderPhotoResponse describes an encoded rendition. The dimensions are
+// read back from the encoded data, so cached renditions need no sidecar
+// metadata.
+func renderPhotoResponse(key string, data []byte, contentType string) *proto.RenderPhotoResponse {
+	resp := &proto.RenderPhotoResponse{
+		Data:        data,
+		ContentType: contentType,
+		Etag:        key,
+	}
+	if config, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+		resp.Width = int32(config.Width)
+		resp.Height = int32(config.Height)
+	}
+	return resp
+}
+
+// renderContentType returns the content type of renditions in format.
+func renderContentType(format proto.RenderFormat) string {
+	if format == proto.RenderFormat_RENDER_FORMAT_WEBP {
+		return "image/webp"
+	}
+	return "image/jpeg"
+}
+
+// decodeImage decodes image data, refusing images of more than
+// maxRenderSourcePixels before they are decoded.
+func decodeImage(data []byte) (image.Image, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	if int64(config.Width)*int64(config.Height) > maxRenderSourcePixels {
+		return nil, fmt.Errorf("image of %dx%d pixels is larger than %d pixels", config.Width, config.Height, maxRenderSourcePixels)
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode image: %w", err)
+	}
+	return img, nil
+}
+
+// renderImage decodes data (see decodeImage), resizes it according to opts,
+// corrects its EXIF orientation and encodes the result. WebP is encoded with
+// cwebp (see GenerateWebP); if cwebp is not in caps or fails the rendition
+// falls back to JPEG. It returns the encoded data and its content type.
+func renderImage(ctx context.Context, data []byte, opts renderOptions, caps *Capabilities, webpQuality int) ([]byte, string, error) {
+	src, err := decodeImage(data)
+	if err != nil {
+		return nil, "", err
+	}
+	orientation := readOrientation(data)
+
+	// Resize before rotating, so the rotation handles the small image. A
+	// rotation by 90 degrees swaps the box the source has to fit.
+	width, height := opts.Width, opts.Height
+	if orientationSwapsAxes(orientation) {
+		width, height = height, width
+	}
+	resized := resizeImage(src, width, height, opts.Fit)
+	oriented := applyOrientation(resized, orientation)
+
+	if opts.Format == proto.RenderFormat_RENDER_FORMAT_WEBP && caps.Has(ToolCWebP) {
+		var pngData bytes.Buffer
+		// cwebp takes a file, so hand it a lossless intermediate
+		if err := (&png.Encoder{CompressionLevel: png.NoCompression}).Encode(&pngData, oriented); err != nil {
+			return nil, "", fmt.Errorf("failed to encode PNG: %w", err)
+		}
+		webpData, err := GenerateWebP(pngData.Bytes(), webpQuality)
+		if err == nil {
+			return webpData, "image/webp", nil
+		}
+		slog.WarnContext(ctx, "Failed to encode WebP rendition, falling back to JPEG",
+			slog.String("error", err.Error()),
+		)
+	}
+
+	var out bytes.Buffer
+	if err := jpeg.Encode(&out, oriented, &jpeg.Options{Quality: renderJPEGQuality}); err != nil {
+		return nil, "", fmt.Errorf("failed to encode JPEG: %w", err)
+	}
+	return out.Bytes(), "image/jpeg", nil
+}
+
+// resizeImage scales src into a box of width x height according to fit,
+// never scaling up. A zero width or height is derived from the aspect ratio.
+func resizeImage(src image.Image, width, height int, fit proto.RenderFit) image.Image {
+	bounds := src.Bounds()
+	sw, sh := float64(bounds.Dx()), float64(bounds.Dy())
+	if sw == 0 || sh == 0 {
+		return src
+	}
+
+	crop := bounds
+	var scale float64
+	switch {
+	case width == 0:
+		scale = float64(height) / sh
+	case height == 0:
+		scale = float64(width) / sw
+	case fit == proto.RenderFit_RENDER_FIT_COVER:
+		// Crop the largest centred area with the box's aspect ratio, then
+		// scale that to the box
+		cropScale := math.Min(sw/float64(width), sh/float64(height))
+		cw := min(int(math.Round(float64(width)*cropScale)), bounds.Dx())
+		ch := min(int(math.Round(float64(height)*cropScale)), bounds.Dy())
+		x0 := bounds.Min.X + (bounds.Dx()-cw)/2
+		y0 := bounds.Min.Y + (bounds.Dy()-ch)/2
+		crop = image.Rect(x0, y0, x0+cw, y0+ch)
+		scale = float64(width) / float64(cw)
+	default:
+		scale = math.Min(float64(width)/sw, float64(height)/sh)
+	}
+	scale = math.Min(scale, 1)
+
+	dw := max(1, int(math.Round(float64(crop.Dx())*scale)))
+	dh := max(1, int(math.Round(float64(crop.Dy())*scale)))
+	if crop == bounds && dw == bounds.Dx() && dh == bounds.Dy() {
+		return src
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, crop, draw.Src, nil)
+	return dst
+}
+
+// readOrientation returns the EXIF orientation (1-8) of data, or 1 if it
+// has none.
+func readOrientation(data []byte) int {
+	x, err := exif.Decode(bytes.NewReader(data))
+	if err != nil {
+		return 1
+	}
+	tag, err := x.Get(exif.Orientation)
+	if err != nil {
+		return 1
+	}
+	orientation, err := tag.Int(0)
+	if err != nil || orientation < 1 || orientation > 8 {
+		return 1
+	}
+	return orientation
+}
+
+// orientationSwapsAxes reports whether an EXIF orientation rotates the image
+// by 90 or 270 degrees.
+func orientationSwapsAxes(orientation int) bool {
+	return orientation >= 5 && orientation <= 8
+}
+
+// applyOrientation transforms img so it displays upright, undoing the EXIF
+// orientation.
+func applyOrientation(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	dw, dh := w, h
+	if orientationSwapsAxes(orientation) {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := range h {
+		for x := range w {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored horizontally
+				dx, dy = w-1-x, y
+			case 3: // rotated 180
+				dx, dy = w-1-x, h-1-y
+			case 4: // mirrored vertically
+				dx, dy = x, h-1-y
+			case 5: // mirrored along the top-left diagonal
+				dx, dy = y, x
+			case 6: // rotated 90 clockwise to display
+				dx, dy = h-1-y, x
+			case 7: // mirrored along the top-right diagonal
+				dx, dy = h-1-y, w-1-x
+			case 8: // rotated 90 counter-clockwise to display
+				dx, dy = y, w-1-x
+			}
+			dst.Set(dx, dy, img.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return dst
+}
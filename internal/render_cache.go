@@ -0,0 +1,214 @@
+package internal
+
+import (
+	"container/list"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// renderCacheExtensions maps the content types of cached renditions to the
+// file extensions they are stored with.
+var renderCacheExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/webp": ".webp",
+}
+
+// RenderCache is a size-bounded, least-recently-used cache of renditions in
+// a directory on local disk. Entries are files named after their key, so
+// the cache survives restarts; recency is kept in the files' modification
+// times. A nil *RenderCache caches nothing.
+type RenderCache struct {
+	dir      string
+	maxBytes int64
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+	size    int64
+}
+
+// renderCacheEntry is a cached rendition, most recently used at the front
+// of RenderCache.lru.
+type renderCacheEntry struct {
+	key         string
+	contentType string
+	size        int64
+}
+
+// NewRenderCache opens the cache in dir, creating the directory if needed,
+// and indexes renditions left by a previous run, evicting the least recently
+// used if they exceed maxBytes.
+func NewRenderCache(dir string, maxBytes int64) (*RenderCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create render cache directory: %w", err)
+	}
+	c := &RenderCache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read render cache directory: %w", err)
+	}
+	type existing struct {
+		entry   *renderCacheEntry
+		modTime time.Time
+	}
+	var found []existing
+	for _, dirEntry := range dirEntries {
+		if !dirEntry.Type().IsRegular() {
+			continue
+		}
+		name := dirEntry.Name()
+		ext := filepath.Ext(name)
+		contentType := ""
+		for ct, e := range renderCacheExtensions {
+			if e == ext {
+				contentType = ct
+			}
+		}
+		info, err := dirEntry.Info()
+		if contentType == "" || err != nil {
+			// Left-over temporary files from an interrupted write
+			_ = os.Remove(filepath.Join(dir, name))
+			continue
+		}
+		found = append(found, existing{
+			entry:   &renderCacheEntry{key: strings.TrimSuffix(name, ext), contentType: contentType, size: info.Size()},
+			modTime: info.ModTime(),
+		})
+	}
+	slices.SortFunc(found, func(a, b existing) int { return b.modTime.Compare(a.modTime) })
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, f := range found {
+		c.entries[f.entry.key] = c.lru.PushBack(f.entry)
+		c.size += f.entry.size
+	}
+	c.evictLocked()
+	return c, nil
+}
+
+func (c *RenderCache) path(entry *renderCacheEntry) string {
+	return filepath.Join(c.dir, entry.key+renderCacheExtensions[entry.contentType])
+}
+
+// Get returns the rendition stored under key and its content type.
+func (c *RenderCache) Get(key string) ([]byte, string, bool) {
+	if c == nil {
+		return nil, "", false
+	}
+	c.mu.Lock()
+	element, ok := c.entries[key]
+	if !ok {
+		c.mu.Unlock()
+		return nil, "", false
+	}
+	c.lru.MoveToFront(element)
+	entry := element.Value.(*renderCacheEntry)
+	c.mu.Unlock()
+
+	path := c.path(entry)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		// Removed from disk behind the cache's back
+		c.remove(key)
+		return nil, "", false
+	}
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+	return data, entry.contentType, true
+}
+
+// Put stores a rendition under key and evicts the least recently used
+// renditions until the cache fits in its size. Renditions larger than the
+// whole cache are not stored.
+func (c *RenderCache) Put(key, contentType string, data []byte) error {
+	if c == nil || int64(len(data)) > c.maxBytes {
+		return nil
+	}
+	if _, ok := renderCacheExtensions[contentType]; !ok {
+		return fmt.Errorf("unsupported rendition content type: %s", contentType)
+	}
+
+	entry := &renderCacheEntry{key: key, contentType: contentType, size: int64(len(data))}
+	// Write to a temporary file and rename, so readers never see a partial
+	// rendition
+	tmp, err := os.CreateTemp(c.dir, key+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create render cache file: %w", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write render cache file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write render cache file: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), c.path(entry)); err != nil {
+		_ = os.Remove(tmp.Name())
+		return fmt.Errorf("failed to store render cache file: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok {
+		old := element.Value.(*renderCacheEntry)
+		c.size -= old.size
+		c.lru.Remove(element)
+		if old.contentType != contentType {
+			_ = os.Remove(c.path(old))
+		}
+	}
+	c.entries[key] = c.lru.PushFront(entry)
+	c.size += entry.size
+	c.evictLocked()
+	return nil
+}
+
+// Size returns the total size of the cached renditions in bytes.
+func (c *RenderCache) Size() int64 {
+	if c == nil {
+		return 0
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+func (c *RenderCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok {
+		c.size -= element.Value.(*renderCacheEntry).size
+		c.lru.Remove(element)
+		delete(c.entries, key)
+	}
+}
+
+// evictLocked removes the least recently used renditions until the cache
+// fits in maxBytes. c.mu must be held.
+func (c *RenderCache) evictLocked() {
+	for c.size > c.maxBytes {
+		element := c.lru.Back()
+		if element == nil {
+			return
+		}
+		entry := element.Value.(*renderCacheEntry)
+		_ = os.Remove(c.path(entry))
+		c.size -= entry.size
+		c.lru.Remove(element)
+		delete(c.entries, entry.key)
+	}
+}
@@ -0,0 +1,103 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRenderCache_PutGet(t *testing.T) {
+	cache, err := NewRenderCache(t.TempDir(), 1024)
+	if err != nil {
+		t.Fatalf("failed to create cache: %v", err)
+	}
+
+	if _, _, ok := cache.Get("missing"); ok {
+		t.Error("expected a miss for an unknown key")
+	}
+	if err := cache.Put("a", "image/webp", []byte("webp")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, contentType, ok := cache.Get("a")
+	if !ok || string(data) != "webp" || contentType != "image/webp" {
+		t.Errorf("expected cached webp, got %q %q %v", data, contentType, ok)
+	}
+}
+
+func TestRenderCache_EvictsLeastRecentlyUsed(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := NewRenderCache(dir, 10)
+	if err != nil {
+		t.Fatalf("failed to create cache: %v", err)
+	}
+
+	_ = cache.Put("a", "image/jpeg", []byte("aaaa"))
+	_ = cache.Put("b", "image/jpeg", []byte("bbbb"))
+	// Touch a so that b is the least recently used
+	if _, _, ok := cache.Get("a"); !ok {
+		t.Fatal("expected a to be cached")
+	}
+	_ = cache.Put("c", "image/jpeg", []byte("cccc"))
+
+	if _, _, ok := cache.Get("b"); ok {
+		t.Error("expected b to be evicted")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "b.jpg")); !os.IsNotExist(err) {
+		t.Error("expected the evicted file to be removed")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, _, ok := cache.Get(key); !ok {
+			t.Errorf("expected %s to be cached", key)
+		}
+	}
+	if size := cache.Size(); size != 8 {
+		t.Errorf("expected size 8, got %d", size)
+	}
+}
+
+func TestRenderCache_SkipsOversizedRenditions(t *testing.T) {
+	cache, err := NewRenderCache(t.TempDir(), 4)
+	if err != nil {
+		t.Fatalf("failed to create cache: %v", err)
+	}
+	if err := cache.Put("big", "image/jpeg", []byte("too big")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, _, ok := cache.Get("big"); ok {
+		t.Error("expected a rendition larger than the cache not to be stored")
+	}
+}
+
+func TestRenderCache_ReloadsFromDisk(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := NewRenderCache(dir, 1024)
+	if err != nil {
+		t.Fatalf("failed to create cache: %v", err)
+	}
+	_ = cache.Put("a", "image/jpeg", []byte("jpeg"))
+	if err := os.WriteFile(filepath.Join(dir, "partial.123.tmp"), []byte("x"), 0644); err != nil {
+		t.Fatalf("failed to write temp file: %v", err)
+	}
+
+	reopened, err := NewRenderCache(dir, 1024)
+	if err != nil {
+		t.Fatalf("failed to reopen cache: %v", err)
+	}
+	data, contentType, ok := reopened.Get("a")
+	if !ok || string(data) != "jpeg" || contentType != "image/jpeg" {
+		t.Errorf("expected the rendition to survive a restart, got %q %q %v", data, contentType, ok)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "partial.123.tmp")); !os.IsNotExist(err) {
+		t.Error("expected left-over temporary files to be removed")
+	}
+}
+
+func TestRenderCache_Nil(t *testing.T) {
+	var cache *RenderCache
+	if err := cache.Put("a", "image/jpeg", []byte("jpeg")); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, _, ok := cache.Get("a"); ok {
+		t.Error("expected a nil cache to miss")
+	}
+}
@@ -0,0 +1,269 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/alexhokl/photos/proto"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc/codes"
+)
+
+func TestNormaliseRenderRequest(t *testing.T) {
+	tests := []struct {
+		name     string
+		req      *proto.RenderPhotoRequest
+		expected renderOptions
+		code     codes.Code
+	}{
+		{
+			name:     "defaults",
+			req:      &proto.RenderPhotoRequest{ObjectId: "a.jpg", Width: 400, Height: 300},
+			expected: renderOptions{Width: 400, Height: 300, Fit: proto.RenderFit_RENDER_FIT_CONTAIN, Format: proto.RenderFormat_RENDER_FORMAT_JPEG},
+		},
+		{
+			name:     "single dimension ignores fit",
+			req:      &proto.RenderPhotoRequest{ObjectId: "a.jpg", Width: 400, Fit: proto.RenderFit_RENDER_FIT_COVER, Format: proto.RenderFormat_RENDER_FORMAT_WEBP},
+			expected: renderOptions{Width: 400, Fit: proto.RenderFit_RENDER_FIT_CONTAIN, Format: proto.RenderFormat_RENDER_FORMAT_WEBP},
+		},
+		{name: "missing object ID", req: &proto.RenderPhotoRequest{Width: 400}, code: codes.InvalidArgument},
+		{name: "no dimensions", req: &proto.RenderPhotoRequest{ObjectId: "a.jpg"}, code: codes.InvalidArgument},
+		{name: "too large", req: &proto.RenderPhotoRequest{ObjectId: "a.jpg", Width: maxRenderDimension + 1}, code: codes.InvalidArgument},
+		{name: "negative", req: &proto.RenderPhotoRequest{ObjectId: "a.jpg", Width: -1, Height: 10}, code: codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := normaliseRenderRequest(tt.req)
+			if tt.code != codes.OK {
+				assertGRPCError(t, err, tt.code)
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if opts != tt.expected {
+				t.Errorf("expected %+v, got %+v", tt.expected, opts)
+			}
+		})
+	}
+}
+
+func TestRenderOptionsCacheKey(t *testing.T) {
+	opts := renderOptions{Width: 400, Height: 400, Fit: proto.RenderFit_RENDER_FIT_COVER, Format: proto.RenderFormat_RENDER_FORMAT_JPEG}
+	key := opts.cacheKey("md5-a")
+	if key != opts.cacheKey("md5-a") {
+		t.Error("expected the same key for the same content and options")
+	}
+	if key == opts.cacheKey("md5-b") {
+		t.Error("expected a different key for different content")
+	}
+	other := opts
+	other.Format = proto.RenderFormat_RENDER_FORMAT_WEBP
+	if key == other.cacheKey("md5-a") {
+		t.Error("expected a different key for different options")
+	}
+}
+
+func TestResizeImage(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 800, 400))
+	tests := []struct {
+		name           string
+		width, height  int
+		fit            proto.RenderFit
+		expectedWidth  int
+		expectedHeight int
+	}{
+		{"contain", 200, 200, proto.RenderFit_RENDER_FIT_CONTAIN, 200, 100},
+		{"cover", 200, 200, proto.RenderFit_RENDER_FIT_COVER, 200, 200},
+		{"width only", 400, 0, proto.RenderFit_RENDER_FIT_CONTAIN, 400, 200},
+		{"height only", 0, 100, proto.RenderFit_RENDER_FIT_CONTAIN, 200, 100},
+		{"never scales up", 1600, 1600, proto.RenderFit_RENDER_FIT_CONTAIN, 800, 400},
+		{"cover larger than source crops without scaling up", 1000, 1000, proto.RenderFit_RENDER_FIT_COVER, 400, 400},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := resizeImage(src, tt.width, tt.height, tt.fit).Bounds()
+			if got.Dx() != tt.expectedWidth || got.Dy() != tt.expectedHeight {
+				t.Errorf("expected %dx%d, got %dx%d", tt.expectedWidth, tt.expectedHeight, got.Dx(), got.Dy())
+			}
+		})
+	}
+}
+
+func TestApplyOrientation(t *testing.T) {
+	// A 2x1 image with a red left pixel and a blue right pixel
+	src := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	red := color.RGBA{R: 255, A: 255}
+	blue := color.RGBA{B: 255, A: 255}
+	src.Set(0, 0, red)
+	src.Set(1, 0, blue)
+
+	tests := []struct {
+		orientation int
+		width       int
+		height      int
+		redAt       image.Point
+	}{
+		{1, 2, 1, image.Pt(0, 0)},
+		{2, 2, 1, image.Pt(1, 0)},
+		{3, 2, 1, image.Pt(1, 0)},
+		{6, 1, 2, image.Pt(0, 0)},
+		{8, 1, 2, image.Pt(0, 1)},
+	}
+	for _, tt := range tests {
+		got := applyOrientation(src, tt.orientation)
+		if got.Bounds().Dx() != tt.width || got.Bounds().Dy() != tt.height {
+			t.Errorf("orientation %d: expected %dx%d, got %v", tt.orientation, tt.width, tt.height, got.Bounds())
+			continue
+		}
+		if c := color.RGBAModel.Convert(got.At(tt.redAt.X, tt.redAt.Y)); c != red {
+			t.Errorf("orientation %d: expected red at %v, got %v", tt.orientation, tt.redAt, c)
+		}
+	}
+}
+
+func TestRenderImage_JPEG(t *testing.T) {
+	var src bytes.Buffer
+	if err := jpeg.Encode(&src, image.NewRGBA(image.Rect(0, 0, 640, 480)), nil); err != nil {
+		t.Fatalf("failed to encode test image: %v", err)
+	}
+
+	opts := renderOptions{Width: 320, Height: 320, Fit: proto.RenderFit_RENDER_FIT_COVER, Format: proto.RenderFormat_RENDER_FORMAT_JPEG}
+	data, contentType, err := renderImage(context.Background(), src.Bytes(), opts, nil, DefaultWebPQuality)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if contentType != "image/jpeg" {
+		t.Errorf("expected image/jpeg, got %s", contentType)
+	}
+	resp := renderPhotoResponse("key", data, contentType)
+	if resp.GetWidth() != 320 || resp.GetHeight() != 320 {
+		t.Errorf("expected 320x320, got %dx%d", resp.GetWidth(), resp.GetHeight())
+	}
+}
+
+func TestRenderImage_NotAnImage(t *testing.T) {
+	opts := renderOptions{Width: 100, Format: proto.RenderFormat_RENDER_FORMAT_JPEG}
+	if _, _, err := renderImage(context.Background(), []byte("not an image"), opts, nil, DefaultWebPQuality); err == nil {
+		t.Error("expected an error for data that is not an image")
+	}
+}
+
+// oversizedPNG returns a PNG header declaring 20000x20000 pixels, more than
+// maxRenderSourcePixels, without any image data.
+func oversizedPNG() []byte {
+	ihdr := binary.BigEndian.AppendUint32(nil, 20000)
+	ihdr = binary.BigEndian.AppendUint32(ihdr, 20000)
+	ihdr = append(ihdr, 8, 2, 0, 0, 0)
+	chunk := append([]byte("IHDR"), ihdr...)
+	data := []byte("\x89PNG\r\n\x1a\n")
+	data = binary.BigEndian.AppendUint32(data, uint32(len(ihdr)))
+	data = append(data, chunk...)
+	return binary.BigEndian.AppendUint32(data, crc32.ChecksumIEEE(chunk))
+}
+
+func TestRenderImage_TooManyPixels(t *testing.T) {
+	opts := renderOptions{Width: 100, Format: proto.RenderFormat_RENDER_FORMAT_JPEG}
+	_, _, err := renderImage(context.Background(), oversizedPNG(), opts, nil, DefaultWebPQuality)
+	if err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Errorf("renderImage() error = %v, want the image refused as too large", err)
+	}
+}
+
+func TestRenderPhoto_Unauthenticated(t *testing.T) {
+	server := &BytesServer{}
+	_, err := server.RenderPhoto(context.Background(), &proto.RenderPhotoRequest{ObjectId: "a.jpg", Width: 100})
+	assertGRPCError(t, err, codes.Unauthenticated)
+}
+
+func TestRenderPhoto_SharedRenderOutlivesCancelledRequest(t *testing.T) {
+	var source bytes.Buffer
+	if err := jpeg.Encode(&source, image.NewRGBA(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatalf("failed to encode source: %v", err)
+	}
+	sum := md5.Sum(source.Bytes())
+
+	reading := make(chan struct{})
+	release := make(chan struct{})
+	var reads atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/o/a.jpg") && r.URL.Query().Get("alt") != "media" {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"bucket":"photos","name":"a.jpg","contentType":"image/jpeg","md5Hash":%q}`, base64.StdEncoding.EncodeToString(sum[:]))
+			return
+		}
+		// Reading the source blocks until the test releases it
+		if reads.Add(1) == 1 {
+			close(reading)
+		}
+		<-release
+		w.Header().Set("Content-Type", "image/jpeg")
+		_, _ = w.Write(source.Bytes())
+	}))
+	t.Cleanup(server.Close)
+	client, err := storage.NewClient(context.Background(), option.WithEndpoint(server.URL+"/storage/v1/"), option.WithoutAuthentication())
+	if err != nil {
+		t.Fatalf("failed to create storage client: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	cache, err := NewRenderCache(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatalf("NewRenderCache() error = %v", err)
+	}
+	s := &BytesServer{GCSClient: client, BucketName: "photos", RenderCache: cache}
+	req := &proto.RenderPhotoRequest{ObjectId: "a.jpg", Width: 4}
+
+	ctx, cancel := context.WithCancel(contextWithUserID(1))
+	done := make(chan error, 1)
+	go func() {
+		_, err := s.RenderPhoto(ctx, req)
+		done <- err
+	}()
+	<-reading
+	cancel()
+	select {
+	case err := <-done:
+		assertGRPCError(t, err, codes.Canceled)
+	case <-time.After(5 * time.Second):
+		t.Fatal("RenderPhoto did not return when its request was cancelled")
+	}
+
+	// The render carries on and is cached for the next request
+	close(release)
+	opts, err := normaliseRenderRequest(req)
+	if err != nil {
+		t.Fatalf("normaliseRenderRequest() error = %v", err)
+	}
+	key := opts.cacheKey(base64.StdEncoding.EncodeToString(sum[:]))
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, _, ok := cache.Get(key); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the shared render was not cached after its first request was cancelled")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, err := s.RenderPhoto(contextWithUserID(1), req); err != nil {
+		t.Fatalf("RenderPhoto() error = %v", err)
+	}
+	if n := reads.Load(); n != 1 {
+		t.Errorf("source read %d times, want 1", n)
+	}
+}
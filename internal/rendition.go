@@ -0,0 +1,458 @@
+package internal
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"image/jpeg"
+	"io"
+	"log/slog"
+	"slices"
+	"strconv"
+
+	"cloud.google.com/go/storage"
+	"github.com/alexhokl/photos/database"
+	"github.com/alexhokl/photos/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+// DefaultThumbnailSizes are the long edges, in pixels, of the thumbnails
+// generated for every photo on upload and by SyncDatabase.
+var DefaultThumbnailSizes = []int{256, 1024, 2048}
+
+// thumbnail is a rendered JPEG thumbnail of a photo.
+type thumbnail struct {
+	LongEdge int
+	Width    int
+	Height   int
+	Data     []byte
+}
+
+// ValidateThumbnailSizes checks that every thumbnail size is a positive
+// number of pixels no larger than the largest rendition RenderPhoto serves.
+func ValidateThumbnailSizes(sizes []int) error {
+	for _, size := range sizes {
+		if size < 1 || size > maxRenderDimension {
+			return fmt.Errorf("invalid thumbnail size %d (must be between 1 and %d)", size, maxRenderDimension)
+		}
+	}
+	return nil
+}
+
+// renditionObjectID returns the GCS object ID of the thumbnail of an image
+// with the given long edge. "_<long edge>px.jpg" is appended to the object
+// ID, extension included, so that a RAW file and the JPEG next to it have
+// thumbnails of their own.
+// Examples:
+//
+//	"dir1/dir2/image.jpg", 256 → "dir1/dir2/image.jpg_256px.jpg"
+//	"image.dng", 1024          → "image.dng_1024px.jpg"
+func renditionObjectID(objectID string, longEdge int) string {
+	return objectID + "_" + strconv.Itoa(longEdge) + "px.jpg"
+}
+
+// renditionSourceData returns the data thumbnails of a photo are rendered
+// from: the photo itself for decodable images, the JPEG preview for RAW and
+// HEIC files and the poster frame for videos. It returns nil for anything
+// else.
+func renditionSourceData(contentType string, data, previewData []byte) []byte {
+	switch {
+	case IsRenderableContentType(contentType):
+		return data
+	case HasPreviewContentType(contentType) || IsVideoContentType(contentType):
+		return previewData
+	}
+	return nil
+}
+
+// renderThumbnails decodes data once (see decodeImage) and renders a JPEG
+// thumbnail fitted within a square of each of sizes, corrected for EXIF
+// orientation. Images smaller than a size are not scaled up.
+func renderThumbnails(data []byte, sizes []int) ([]thumbnail, error) {
+	src, err := decodeImage(data)
+	if err != nil {
+		return nil, err
+	}
+	orientation := readOrientation(data)
+
+	thumbnails := make([]thumbnail, 0, len(sizes))
+	for _, size := range sizes {
+		// The box is square, so the orientation does not change the fit
+		resized := applyOrientation(resizeImage(src, size, size, proto.RenderFit_RENDER_FIT_CONTAIN), orientation)
+		var out bytes.Buffer
+		if err := jpeg.Encode(&out, resized, &jpeg.Options{Quality: renderJPEGQuality}); err != nil {
+			return nil, fmt.Errorf("failed to encode JPEG: %w", err)
+		}
+		bounds := resized.Bounds()
+		thumbnails = append(thumbnails, thumbnail{
+			LongEdge: size,
+			Width:    bounds.Dx(),
+			Height:   bounds.Dy(),
+			Data:     out.Bytes(),
+		})
+	}
+	return thumbnails, nil
+}
+
+// storeRenditions renders a thumbnail of data for each of sizes, writes them
+// to GCS next to objectID and records them against the photo. It returns the
+// recorded renditions. Errors are logged but not fatal.
+func storeRenditions(ctx context.Context, db *gorm.DB, bucket *storage.BucketHandle, userID uint, objectID string, data []byte, sizes []int) []database.PhotoRendition {
+	if len(data) == 0 || len(sizes) == 0 {
+		return nil
+	}
+
+	thumbnails, err := renderThumbnails(data, sizes)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to generate thumbnails",
+			slog.String("object_id", objectID),
+			slog.String("error", err.Error()),
+		)
+		return nil
+	}
+
+	renditions := make([]database.PhotoRendition, 0, len(thumbnails))
+	for _, t := range thumbnails {
+		renditionID := renditionObjectID(objectID, t.LongEdge)
+		_, writeSpan := startSpan(ctx, "gcs.write_object")
+		writer, err := newDerivedObjectWriter(ctx, bucket.Object(renditionID), database.DerivedKindRendition, objectID)
+		if err == nil {
+			writer.ContentType = "image/jpeg"
+			if _, err = writer.Write(t.Data); err != nil {
+				_ = writer.Close()
+			}
+		}
+		if err != nil {
+			recordSpanError(writeSpan, err)
+			slog.WarnContext(ctx, "failed to write thumbnail to GCS",
+				slog.String("object_id", objectID),
+				slog.String("rendition_object_id", renditionID),
+				slog.String("error", err.Error()),
+			)
+			continue
+		}
+		if err := writer.Close(); err != nil {
+			recordSpanError(writeSpan, err)
+			slog.WarnContext(ctx, "failed to close thumbnail writer",
+				slog.String("object_id", objectID),
+				slog.String("rendition_object_id", renditionID),
+				slog.String("error", err.Error()),
+			)
+			continue
+		}
+		endSpanOk(writeSpan)
+
+		rendition := database.PhotoRendition{
+			ObjectID:      renditionID,
+			PhotoObjectID: objectID,
+			UserID:        userID,
+			LongEdge:      t.LongEdge,
+			Width:         t.Width,
+			Height:        t.Height,
+			ContentType:   "image/jpeg",
+			SizeBytes:     int64(len(t.Data)),
+		}
+		_, createSpan := startSpan(ctx, "db.create_or_restore_photo_rendition")
+		if err := database.CreateOrRestorePhotoRendition(db, &rendition); err != nil {
+			recordSpanError(createSpan, err)
+			slog.WarnContext(ctx, "failed to create thumbnail record",
+				slog.String("object_id", objectID),
+				slog.String("rendition_object_id", renditionID),
+				slog.String("error", err.Error()),
+			)
+			continue
+		}
+		endSpanOk(createSpan)
+		recordDerivedObject(ctx, db, userID, database.DerivedKindRendition, objectID, renditionID, rendition.SizeBytes)
+		renditions = append(renditions, rendition)
+	}
+
+	slog.InfoContext(ctx, "Generated thumbnails",
+		slog.String("object_id", objectID),
+		slog.Int("count", len(renditions)),
+	)
+	return renditions
+}
+
+// renditionsToProto converts rendition records to their proto form, smallest
+// first.
+func renditionsToProto(renditions []database.PhotoRendition) []*proto.PhotoRendition {
+	if len(renditions) == 0 {
+		return nil
+	}
+	sorted := slices.Clone(renditions)
+	slices.SortFunc(sorted, func(a, b database.PhotoRendition) int {
+		return cmp.Compare(a.LongEdge, b.LongEdge)
+	})
+	result := make([]*proto.PhotoRendition, 0, len(sorted))
+	for _, rendition := range sorted {
+		result = append(result, &proto.PhotoRendition{
+			ObjectId:    rendition.ObjectID,
+			LongEdge:    int32(rendition.LongEdge),
+			Width:       int32(rendition.Width),
+			Height:      int32(rendition.Height),
+			ContentType: rendition.ContentType,
+			SizeBytes:   rendition.SizeBytes,
+		})
+	}
+	return result
+}
+
+// getPhotoRenditions returns the renditions recorded against a photo.
+func getPhotoRenditions(db *gorm.DB, userID uint, photoObjectID string) ([]database.PhotoRendition, error) {
+	var renditions []database.PhotoRendition
+	err := db.Where("photo_object_id = ? AND user_id = ?", photoObjectID, userID).Find(&renditions).Error
+	return renditions, err
+}
+
+// getPhotosRenditions returns the renditions recorded against the given
+// photos, keyed by photo object ID.
+func getPhotosRenditions(db *gorm.DB, userID uint, photoObjectIDs []string) (map[string][]database.PhotoRendition, error) {
+	renditions := make(map[string][]database.PhotoRendition)
+	if len(photoObjectIDs) == 0 {
+		return renditions, nil
+	}
+
+	var rows []database.PhotoRendition
+	if err := db.Where("photo_object_id IN ? AND user_id = ?", photoObjectIDs, userID).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		renditions[row.PhotoObjectID] = append(renditions[row.PhotoObjectID], row)
+	}
+	return renditions, nil
+}
+
+// copyRenditions copies the renditions of sourcePhotoID so that they sit next
+// to destPhotoID and are recorded against it. If move is true the source
+// objects and records are removed afterwards. Errors are logged but not
+// fatal, as the photo itself has already been copied.
+func copyRenditions(ctx context.Context, db *gorm.DB, bucket *storage.BucketHandle, userID uint, sourcePhotoID, destPhotoID string, move bool) {
+	sources, err := getPhotoRenditions(db, userID, sourcePhotoID)
+	if err != nil {
+		slog.WarnContext(ctx, "failed to list thumbnails",
+			slog.String("object_id", sourcePhotoID),
+			slog.String("error", err.Error()),
+		)
+		return
+	}
+
+	for i := range sources {
+		source := &sources[i]
+		destID := renditionObjectID(destPhotoID, source.LongEdge)
+		_, copySpan := startSpan(ctx, "gcs.copy_object")
+		destObj := bucket.Object(destID)
+		conditions, err := derivedObjectConditions(ctx, destObj, database.DerivedKindRendition, destPhotoID)
+		if err == nil {
+			copier := destObj.If(conditions).CopierFrom(bucket.Object(source.ObjectID))
+			copier.Metadata = derivedObjectMetadata(database.DerivedKindRendition, destPhotoID)
+			_, err = copier.Run(ctx)
+		}
+		if err != nil {
+			recordSpanError(copySpan, err)
+			slog.WarnContext(ctx, "failed to copy thumbnail",
+				slog.String("rendition_object_id", source.ObjectID),
+				slog.String("destination", destID),
+				slog.String("error", err.Error()),
+			)
+			continue
+		}
+		endSpanOk(copySpan)
+
+		dest := *source
+		dest.Model = gorm.Model{}
+		dest.ObjectID = destID
+		dest.PhotoObjectID = destPhotoID
+		_, createSpan := startSpan(ctx, "db.create_or_restore_photo_rendition")
+		if err := database.CreateOrRestorePhotoRendition(db, &dest); err != nil {
+			recordSpanError(createSpan, err)
+			slog.WarnContext(ctx, "failed to create thumbnail record",
+				slog.String("rendition_object_id", destID),
+				slog.String("error", err.Error()),
+			)
+			continue
+		}
+		endSpanOk(createSpan)
+		recordDerivedObject(ctx, db, userID, database.DerivedKindRendition, destPhotoID, destID, dest.SizeBytes)
+
+		if move {
+			deleteRendition(ctx, db, bucket, source)
+		}
+	}
+}
+
+// deleteRendition removes a rendition object from GCS and its database
+// record. A nil bucket removes the record only. Errors are logged but not
+// fatal.
+func deleteRendition(ctx context.Context, db *gorm.DB, bucket *storage.BucketHandle, rendition *database.PhotoRendition) {
+	if bucket != nil {
+		_, gcsDelSpan := startSpan(ctx, "gcs.delete_object")
+		if err := bucket.Object(rendition.ObjectID).Delete(ctx); err != nil && err != storage.ErrObjectNotExist {
+			recordSpanError(gcsDelSpan, err)
+			slog.WarnContext(ctx, "failed to delete thumbnail from storage",
+				slog.String("rendition_object_id", rendition.ObjectID),
+				slog.String("error", err.Error()),
+			)
+		} else {
+			endSpanOk(gcsDelSpan)
+		}
+	}
+
+	_, dbDelSpan := startSpan(ctx, "db.delete_photo_rendition")
+	if err := db.Delete(rendition).Error; err != nil {
+		recordSpanError(dbDelSpan, err)
+		slog.WarnContext(ctx, "failed to delete thumbnail record",
+			slog.String("rendition_object_id", rendition.ObjectID),
+			slog.String("error", err.Error()),
+		)
+		return
+	}
+	endSpanOk(dbDelSpan)
+	forgetDerivedObject(ctx, db, rendition.ObjectID)
+}
+
+// missingThumbnailSizes returns the sizes that have no rendition among
+// renditions.
+func missingThumbnailSizes(renditions []database.PhotoRendition, sizes []int) []int {
+	var missing []int
+	for _, size := range sizes {
+		if !slices.ContainsFunc(renditions, func(r database.PhotoRendition) bool { return r.LongEdge == size }) {
+			missing = append(missing, size)
+		}
+	}
+	return missing
+}
+
+// syncRenditions generates the thumbnails missing from the user's photos and
+// deletes renditions whose photo no longer exists. RAW and HEIC files and
+// videos are rendered from their JPEG preview or poster frame and are skipped
+// until they have one. A PHASE_RENDITIONS progress message is sent per photo
+// or rendition examined.
+func (s *LibraryServer) syncRenditions(
+	ctx context.Context,
+	userID uint,
+	stream grpc.ServerStreamingServer[proto.SyncDatabaseProgress],
+) (generated, removed int, err error) {
+	var photoObjects []database.PhotoObject
+	_, dbListSpan := startSpan(ctx, "db.list_photo_objects")
+	if err := s.DB.Where("user_id = ?", userID).Order("object_id ASC").Find(&photoObjects).Error; err != nil {
+		recordSpanError(dbListSpan, err)
+		return 0, 0, status.Errorf(codes.Internal, "failed to list database objects: %v", err)
+	}
+	endSpanOk(dbListSpan)
+
+	var dbRenditions []database.PhotoRendition
+	_, renditionListSpan := startSpan(ctx, "db.list_photo_renditions")
+	if err := s.DB.Where("user_id = ?", userID).Find(&dbRenditions).Error; err != nil {
+		recordSpanError(renditionListSpan, err)
+		return 0, 0, status.Errorf(codes.Internal, "failed to list renditions: %v", err)
+	}
+	endSpanOk(renditionListSpan)
+
+	derived, err := loadDerivedObjectSet(s.DB)
+	if err != nil {
+		return 0, 0, status.Errorf(codes.Internal, "failed to list derived objects: %v", err)
+	}
+
+	photoSet := make(map[string]struct{}, len(photoObjects))
+	for _, photoObject := range photoObjects {
+		photoSet[photoObject.ObjectID] = struct{}{}
+	}
+	renditionMap := make(map[string][]database.PhotoRendition)
+	var orphans []database.PhotoRendition
+	for _, rendition := range dbRenditions {
+		if _, ok := photoSet[rendition.PhotoObjectID]; !ok {
+			orphans = append(orphans, rendition)
+			continue
+		}
+		renditionMap[rendition.PhotoObjectID] = append(renditionMap[rendition.PhotoObjectID], rendition)
+	}
+
+	// Generating thumbnails needs the originals; without a GCS client only
+	// the records of orphaned renditions can be cleaned up
+	var bucket *storage.BucketHandle
+	if s.GCSClient != nil {
+		bucket = s.GCSClient.Bucket(s.BucketName)
+	}
+
+	type pendingPhoto struct {
+		photoObject database.PhotoObject
+		sizes       []int
+	}
+	var pending []pendingPhoto
+	for _, photoObject := range photoObjects {
+		if bucket == nil || derived.contains(photoObject.ObjectID) {
+			continue
+		}
+		hasPreview := photoObject.ThumbnailObjectID != nil && *photoObject.ThumbnailObjectID != ""
+		rendersFromPreview := HasPreviewContentType(photoObject.ContentType) || IsVideoContentType(photoObject.ContentType)
+		if !IsRenderableContentType(photoObject.ContentType) && !(rendersFromPreview && hasPreview) {
+			continue
+		}
+		if missing := missingThumbnailSizes(renditionMap[photoObject.ObjectID], s.ThumbnailSizes); len(missing) > 0 {
+			pending = append(pending, pendingPhoto{photoObject: photoObject, sizes: missing})
+		}
+	}
+
+	total := uint32(len(orphans) + len(pending))
+	var processed uint32
+	sendProgress := func() error {
+		processed++
+		return stream.Send(&proto.SyncDatabaseProgress{
+			Phase:     proto.SyncDatabaseProgress_PHASE_RENDITIONS,
+			Processed: processed,
+			Total:     total,
+		})
+	}
+
+	for i := range orphans {
+		deleteRendition(ctx, s.DB, bucket, &orphans[i])
+		removed++
+		if err := sendProgress(); err != nil {
+			return generated, removed, err
+		}
+	}
+
+	for _, p := range pending {
+		sourceID := p.photoObject.ObjectID
+		if !IsRenderableContentType(p.photoObject.ContentType) {
+			sourceID = *p.photoObject.ThumbnailObjectID
+		}
+		data, err := readRenditionSource(ctx, bucket, sourceID)
+		if err != nil {
+			slog.WarnContext(ctx, "failed to read photo for thumbnail generation during sync",
+				slog.String("object_id", p.photoObject.ObjectID),
+				slog.String("error", err.Error()),
+			)
+		} else {
+			generated += len(storeRenditions(ctx, s.DB, bucket, userID, p.photoObject.ObjectID, data, p.sizes))
+		}
+		if err := sendProgress(); err != nil {
+			return generated, removed, err
+		}
+	}
+
+	return generated, removed, nil
+}
+
+// readRenditionSource downloads the object thumbnails are rendered from.
+func readRenditionSource(ctx context.Context, bucket *storage.BucketHandle, objectID string) ([]byte, error) {
+	_, readSpan := startSpan(ctx, "gcs.read_object")
+	reader, err := bucket.Object(objectID).NewReader(ctx)
+	if err != nil {
+		recordSpanError(readSpan, err)
+		return nil, err
+	}
+	data, err := io.ReadAll(reader)
+	_ = reader.Close()
+	if err != nil {
+		recordSpanError(readSpan, err)
+		return nil, err
+	}
+	endSpanOk(readSpan)
+	return data, nil
+}
@@ -0,0 +1,194 @@
+package internal
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+	"strings"
+	"testing"
+
+	"github.com/alexhokl/photos/database"
+	"github.com/alexhokl/photos/proto"
+)
+
+func TestRenditionObjectID(t *testing.T) {
+	tests := []struct {
+		objectID string
+		longEdge int
+		want     string
+	}{
+		{"dir1/dir2/image.jpg", 256, "dir1/dir2/image.jpg_256px.jpg"},
+		{"image.dng", 1024, "image.dng_1024px.jpg"},
+		{"photo", 2048, "photo_2048px.jpg"},
+	}
+
+	for _, test := range tests {
+		if got := renditionObjectID(test.objectID, test.longEdge); got != test.want {
+			t.Errorf("renditionObjectID(%q, %d) = %q, want %q", test.objectID, test.longEdge, got, test.want)
+		}
+	}
+}
+
+func TestRenderThumbnails(t *testing.T) {
+	var src bytes.Buffer
+	if err := jpeg.Encode(&src, image.NewRGBA(image.Rect(0, 0, 800, 600)), nil); err != nil {
+		t.Fatalf("failed to encode source: %v", err)
+	}
+
+	thumbnails, err := renderThumbnails(src.Bytes(), []int{256, 1024})
+	if err != nil {
+		t.Fatalf("renderThumbnails returned error: %v", err)
+	}
+	if len(thumbnails) != 2 {
+		t.Fatalf("expected 2 thumbnails, got %d", len(thumbnails))
+	}
+
+	expected := []struct{ longEdge, width, height int }{
+		{256, 256, 192},
+		// Never scaled up
+		{1024, 800, 600},
+	}
+	for i, want := range expected {
+		got := thumbnails[i]
+		if got.LongEdge != want.longEdge || got.Width != want.width || got.Height != want.height {
+			t.Errorf("thumbnail %d = %dpx %dx%d, want %dpx %dx%d", i, got.LongEdge, got.Width, got.Height, want.longEdge, want.width, want.height)
+		}
+		config, err := jpeg.DecodeConfig(bytes.NewReader(got.Data))
+		if err != nil {
+			t.Fatalf("thumbnail %d is not a JPEG: %v", i, err)
+		}
+		if config.Width != want.width || config.Height != want.height {
+			t.Errorf("thumbnail %d decodes to %dx%d, want %dx%d", i, config.Width, config.Height, want.width, want.height)
+		}
+	}
+
+	if _, err := renderThumbnails([]byte("not an image"), []int{256}); err == nil {
+		t.Error("expected error for undecodable data")
+	}
+}
+
+func TestRenderThumbnails_TooManyPixels(t *testing.T) {
+	_, err := renderThumbnails(oversizedPNG(), []int{256})
+	if err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Errorf("renderThumbnails() error = %v, want the image refused as too large", err)
+	}
+}
+
+func TestRenditionSourceData(t *testing.T) {
+	data, preview := []byte("photo"), []byte("preview")
+
+	if got := renditionSourceData("image/jpeg", data, nil); !bytes.Equal(got, data) {
+		t.Errorf("JPEG source = %q, want the photo", got)
+	}
+	if got := renditionSourceData("image/x-adobe-dng", data, preview); !bytes.Equal(got, preview) {
+		t.Errorf("DNG source = %q, want the preview", got)
+	}
+	if got := renditionSourceData("video/mp4", data, preview); !bytes.Equal(got, preview) {
+		t.Errorf("video source = %q, want the poster", got)
+	}
+	if got := renditionSourceData("application/pdf", data, preview); got != nil {
+		t.Errorf("PDF source = %q, want nil", got)
+	}
+}
+
+func TestMissingThumbnailSizes(t *testing.T) {
+	renditions := []database.PhotoRendition{{LongEdge: 256}, {LongEdge: 2048}}
+
+	got := missingThumbnailSizes(renditions, []int{256, 1024, 2048})
+	if len(got) != 1 || got[0] != 1024 {
+		t.Errorf("missingThumbnailSizes() = %v, want [1024]", got)
+	}
+	if got := missingThumbnailSizes(nil, nil); len(got) != 0 {
+		t.Errorf("missingThumbnailSizes() with no sizes = %v, want none", got)
+	}
+}
+
+func TestValidateThumbnailSizes(t *testing.T) {
+	if err := ValidateThumbnailSizes(DefaultThumbnailSizes); err != nil {
+		t.Errorf("ValidateThumbnailSizes(default) returned error: %v", err)
+	}
+	for _, sizes := range [][]int{{0}, {-1}, {256, maxRenderDimension + 1}} {
+		if err := ValidateThumbnailSizes(sizes); err == nil {
+			t.Errorf("ValidateThumbnailSizes(%v): expected error, got nil", sizes)
+		}
+	}
+}
+
+func TestListPhotos_ReturnsRenditions(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	server := &LibraryServer{DB: db}
+
+	photos := []database.PhotoObject{
+		{ObjectID: "a.jpg", ContentType: "image/jpeg", MD5Hash: "a", UserID: 1},
+		{ObjectID: "b.jpg", ContentType: "image/jpeg", MD5Hash: "b", UserID: 1},
+	}
+	if err := db.Create(&photos).Error; err != nil {
+		t.Fatalf("failed to create photos: %v", err)
+	}
+	renditions := []database.PhotoRendition{
+		{ObjectID: "a_1024px.jpg", PhotoObjectID: "a.jpg", UserID: 1, LongEdge: 1024, Width: 1024, Height: 768, ContentType: "image/jpeg"},
+		{ObjectID: "a_256px.jpg", PhotoObjectID: "a.jpg", UserID: 1, LongEdge: 256, Width: 256, Height: 192, ContentType: "image/jpeg"},
+		// Another user's rendition is not returned
+		{ObjectID: "b_256px.jpg", PhotoObjectID: "b.jpg", UserID: 2, LongEdge: 256, Width: 256, Height: 192, ContentType: "image/jpeg"},
+	}
+	if err := db.Create(&renditions).Error; err != nil {
+		t.Fatalf("failed to create renditions: %v", err)
+	}
+
+	resp, err := server.ListPhotos(contextWithUserID(1), &proto.ListPhotosRequest{})
+	if err != nil {
+		t.Fatalf("ListPhotos returned error: %v", err)
+	}
+
+	got := make(map[string][]*proto.PhotoRendition)
+	for _, photo := range resp.GetPhotos() {
+		got[photo.GetObjectId()] = photo.GetRenditions()
+	}
+	if len(got["a.jpg"]) != 2 {
+		t.Fatalf("expected 2 renditions for a.jpg, got %d", len(got["a.jpg"]))
+	}
+	if got["a.jpg"][0].GetObjectId() != "a_256px.jpg" || got["a.jpg"][1].GetObjectId() != "a_1024px.jpg" {
+		t.Errorf("expected renditions smallest first, got %v", got["a.jpg"])
+	}
+	if got["a.jpg"][0].GetWidth() != 256 || got["a.jpg"][0].GetHeight() != 192 {
+		t.Errorf("unexpected rendition dimensions %dx%d", got["a.jpg"][0].GetWidth(), got["a.jpg"][0].GetHeight())
+	}
+	if len(got["b.jpg"]) != 0 {
+		t.Errorf("expected no renditions for b.jpg, got %v", got["b.jpg"])
+	}
+}
+
+func TestSyncDatabase_RemovesOrphanedRenditions(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	server := &LibraryServer{DB: db, ThumbnailSizes: DefaultThumbnailSizes}
+
+	orphan := &database.PhotoRendition{ObjectID: "gone_256px.jpg", PhotoObjectID: "gone.jpg", UserID: 1, LongEdge: 256, ContentType: "image/jpeg"}
+	if err := db.Create(orphan).Error; err != nil {
+		t.Fatalf("failed to create rendition: %v", err)
+	}
+
+	stream := newMockSyncDatabaseStream(contextWithUserID(1))
+	if err := server.SyncDatabase(&proto.SyncDatabaseRequest{}, stream); err != nil {
+		t.Fatalf("SyncDatabase returned error: %v", err)
+	}
+
+	var count int64
+	db.Model(&database.PhotoRendition{}).Count(&count)
+	if count != 0 {
+		t.Errorf("expected orphaned rendition to be removed, %d remain", count)
+	}
+
+	var sawPhase bool
+	for _, progress := range stream.sent {
+		if progress.GetPhase() == proto.SyncDatabaseProgress_PHASE_RENDITIONS {
+			sawPhase = true
+		}
+	}
+	if !sawPhase {
+		t.Error("expected PHASE_RENDITIONS progress")
+	}
+	last := stream.sent[len(stream.sent)-1]
+	if !last.GetComplete() || last.GetRemoved() != 1 {
+		t.Errorf("final progress = %+v, want complete with removed=1", last)
+	}
+}
@@ -0,0 +1,365 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/alexhokl/photos/database"
+	"github.com/alexhokl/photos/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+// Maintenance tasks that can be scheduled.
+const (
+	// ScheduleTaskSync runs SyncDatabase, incrementally once the user has
+	// been synced
+	ScheduleTaskSync = "sync"
+	// ScheduleTaskSyncMetadata runs a full SyncDatabase with update_metadata
+	ScheduleTaskSyncMetadata = "sync_metadata"
+	// ScheduleTaskWebP runs UpdateWebp
+	ScheduleTaskWebP = "webp"
+	// ScheduleTaskPurgeTrash permanently deletes the records of photos
+	// deleted over trashRetention ago
+	ScheduleTaskPurgeTrash = "purge_trash"
+	// ScheduleTaskVerify checks the photos against the objects in storage
+	ScheduleTaskVerify = "verify"
+)
+
+// trashRetention is how long the records of deleted photos are kept, so
+// that they can be restored, before purge_trash deletes them for good
+const trashRetention = 30 * 24 * time.Hour
+
+// scheduleTasks are the tasks that can be scheduled.
+var scheduleTasks = []string{
+	ScheduleTaskSync,
+	ScheduleTaskSyncMetadata,
+	ScheduleTaskWebP,
+	ScheduleTaskPurgeTrash,
+	ScheduleTaskVerify,
+}
+
+// Schedule is a maintenance task run on a cron schedule.
+type Schedule struct {
+	Task       string
+	Expression string
+
+	cron *cronSchedule
+}
+
+// Next returns when the schedule next runs after t, or the zero time if it
+// never does.
+func (s Schedule) Next(t time.Time) time.Time {
+	return s.cron.next(t)
+}
+
+// ParseSchedules parses schedules given as task=expression, e.g.
+// "sync=0 3 * * *" (see parseCron). A task can only be scheduled once.
+func ParseSchedules(specs []string) ([]Schedule, error) {
+	schedules := make([]Schedule, 0, len(specs))
+	for _, spec := range specs {
+		task, expression, ok := strings.Cut(spec, "=")
+		task = strings.TrimSpace(task)
+		if !ok {
+			return nil, fmt.Errorf("invalid schedule %q (must be task=cron expression)", spec)
+		}
+		if !slices.Contains(scheduleTasks, task) {
+			return nil, fmt.Errorf("invalid schedule %q: unknown task %q (must be one of %s)", spec, task, strings.Join(scheduleTasks, ", "))
+		}
+		if slices.ContainsFunc(schedules, func(s Schedule) bool { return s.Task == task }) {
+			return nil, fmt.Errorf("invalid schedule %q: task %s is scheduled more than once", spec, task)
+		}
+		cron, err := parseCron(expression)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %w", spec, err)
+		}
+		schedules = append(schedules, Schedule{
+			Task:       task,
+			Expression: strings.TrimSpace(expression),
+			cron:       cron,
+		})
+	}
+	return schedules, nil
+}
+
+// RunSchedules runs the maintenance tasks of Schedules for every user until
+// ctx is cancelled, recording each run as a ScheduledRun. A task does not
+// run again until its previous run has finished; runs missed meanwhile, or
+// while the server was stopped, are skipped.
+func (s *LibraryServer) RunSchedules(ctx context.Context) {
+	if len(s.Schedules) == 0 {
+		return
+	}
+
+	// Runs left running by a server that stopped never finish
+	if err := s.DB.Model(&database.ScheduledRun{}).
+		Where("status = ?", database.ScheduledRunStatusRunning).
+		Updates(map[string]any{
+			"status":      database.ScheduledRunStatusFailed,
+			"error":       "interrupted by the server stopping",
+			"finished_at": time.Now(),
+		}).Error; err != nil {
+		slog.WarnContext(ctx, "failed to fail interrupted scheduled runs",
+			slog.String("error", err.Error()),
+		)
+	}
+
+	var wg sync.WaitGroup
+	for _, schedule := range s.Schedules {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.runSchedule(ctx, schedule)
+		}()
+	}
+	wg.Wait()
+}
+
+// runSchedule runs the task of schedule each time it is due until ctx is
+// cancelled.
+func (s *LibraryServer) runSchedule(ctx context.Context, schedule Schedule) {
+	for {
+		next := schedule.Next(time.Now())
+		if next.IsZero() {
+			slog.WarnContext(ctx, "schedule never runs",
+				slog.String("task", schedule.Task),
+				slog.String("expression", schedule.Expression),
+			)
+			return
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		var users []database.User
+		if err := s.DB.Find(&users).Error; err != nil {
+			slog.WarnContext(ctx, "failed to list users for scheduled task",
+				slog.String("task", schedule.Task),
+				slog.String("error", err.Error()),
+			)
+			continue
+		}
+		for _, user := range users {
+			if ctx.Err() != nil {
+				return
+			}
+			s.runScheduledTask(ctx, schedule.Task, user.ID)
+		}
+	}
+}
+
+// runScheduledTask runs task for the user and records the run, which it
+// returns.
+func (s *LibraryServer) runScheduledTask(ctx context.Context, task string, userID uint) *database.ScheduledRun {
+	run := &database.ScheduledRun{
+		Task:      task,
+		UserID:    userID,
+		Status:    database.ScheduledRunStatusRunning,
+		StartedAt: time.Now(),
+	}
+	if err := s.DB.Create(run).Error; err != nil {
+		slog.WarnContext(ctx, "failed to record scheduled run",
+			slog.String("task", task),
+			slog.String("error", err.Error()),
+		)
+	}
+	slog.InfoContext(ctx, "Running scheduled task",
+		slog.String("task", task),
+		slog.Uint64("user_id", uint64(userID)),
+	)
+
+	summary, err := s.runMaintenanceTask(context.WithValue(ctx, contextKeyUser{}, userID), task, userID)
+
+	finished := time.Now()
+	run.FinishedAt = &finished
+	run.Summary = summary
+	run.Status = database.ScheduledRunStatusSucceeded
+	if err != nil {
+		run.Status = database.ScheduledRunStatusFailed
+		run.Error = err.Error()
+	}
+	if run.ID != 0 {
+		if dbErr := s.DB.Model(run).Updates(map[string]any{
+			"status":      run.Status,
+			"finished_at": run.FinishedAt,
+			"summary":     run.Summary,
+			"error":       run.Error,
+		}).Error; dbErr != nil {
+			slog.WarnContext(ctx, "failed to record scheduled run",
+				slog.String("task", task),
+				slog.String("error", dbErr.Error()),
+			)
+		}
+	}
+
+	if err != nil {
+		slog.WarnContext(ctx, "scheduled task failed",
+			slog.String("task", task),
+			slog.Uint64("user_id", uint64(userID)),
+			slog.String("error", err.Error()),
+		)
+	} else {
+		slog.InfoContext(ctx, "Completed scheduled task",
+			slog.String("task", task),
+			slog.Uint64("user_id", uint64(userID)),
+			slog.String("summary", summary),
+		)
+	}
+	return run
+}
+
+// runMaintenanceTask runs task for the user, whom ctx must carry, and
+// returns a summary of what it did.
+func (s *LibraryServer) runMaintenanceTask(ctx context.Context, task string, userID uint) (string, error) {
+	switch task {
+	case ScheduleTaskSync, ScheduleTaskSyncMetadata:
+		stream := &lastMessageStream[proto.SyncDatabaseProgress]{ctx: ctx}
+		req := &proto.SyncDatabaseRequest{
+			UpdateMetadata: task == ScheduleTaskSyncMetadata,
+			Full:           task == ScheduleTaskSyncMetadata,
+		}
+		if err := s.SyncDatabase(req, stream); err != nil {
+			return "", err
+		}
+		last := stream.last
+		if last == nil {
+			return "", nil
+		}
+		return fmt.Sprintf("added %d, removed %d, metadata updated %d, thumbnails generated %d, posters generated %d",
+			last.GetAdded(), last.GetRemoved(), last.GetMetadataUpdated(), last.GetRenditionsGenerated(), last.GetPostersGenerated()), nil
+
+	case ScheduleTaskWebP:
+		stream := &lastMessageStream[proto.UpdateWebpProgress]{ctx: ctx}
+		if err := s.UpdateWebp(&proto.UpdateWebpRequest{}, stream); err != nil {
+			return "", err
+		}
+		last := stream.last
+		if last == nil {
+			return "", nil
+		}
+		return fmt.Sprintf("generated %d, skipped %d, failed %d", last.GetGenerated(), last.GetSkipped(), last.GetFailed()), nil
+
+	case ScheduleTaskPurgeTrash:
+		photos, records, err := purgeTrash(ctx, s.DB, userID, time.Now().Add(-trashRetention))
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("purged %d deleted photos and %d other records", photos, records), nil
+
+	case ScheduleTaskVerify:
+		report, _, err := s.checkIntegrity(ctx, userID)
+		if err != nil {
+			return "", err
+		}
+		return report.String(), nil
+	}
+	return "", fmt.Errorf("unknown task %q", task)
+}
+
+// purgeTrash permanently deletes the user's records deleted before cutoff:
+// photos and their sidecars, thumbnails, derived assets and stacks, and the
+// jobs that succeeded. It returns the number of photos and of other records
+// deleted.
+func purgeTrash(ctx context.Context, db *gorm.DB, userID uint, cutoff time.Time) (photos, records int64, err error) {
+	_, span := startSpan(ctx, "db.purge_trash")
+	err = db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Unscoped().
+			Where("user_id = ? AND deleted_at IS NOT NULL AND deleted_at < ?", userID, cutoff).
+			Delete(&database.PhotoObject{})
+		if result.Error != nil {
+			return result.Error
+		}
+		photos = result.RowsAffected
+
+		for _, model := range []any{&database.PhotoSidecar{}, &database.PhotoRendition{}, &database.DerivedObject{}, &database.PhotoStack{}} {
+			result := tx.Unscoped().
+				Where("user_id = ? AND deleted_at IS NOT NULL AND deleted_at < ?", userID, cutoff).
+				Delete(model)
+			if result.Error != nil {
+				return result.Error
+			}
+			records += result.RowsAffected
+		}
+
+		result = tx.Unscoped().
+			Where("user_id = ? AND status = ? AND finished_at < ?", userID, database.JobStatusSucceeded, cutoff).
+			Delete(&database.Job{})
+		if result.Error != nil {
+			return result.Error
+		}
+		records += result.RowsAffected
+		return nil
+	})
+	if err != nil {
+		recordSpanError(span, err)
+		return 0, 0, fmt.Errorf("failed to purge deleted records: %w", err)
+	}
+	endSpanOk(span)
+	return photos, records, nil
+}
+
+// scheduledRunToProto converts a ScheduledRun to its protobuf message.
+func scheduledRunToProto(run *database.ScheduledRun) *proto.ScheduledRun {
+	result := &proto.ScheduledRun{
+		Status:    run.Status,
+		StartedAt: run.StartedAt.Format(time.RFC3339),
+		Summary:   run.Summary,
+		Error:     run.Error,
+	}
+	if run.FinishedAt != nil {
+		result.FinishedAt = run.FinishedAt.Format(time.RFC3339)
+	}
+	return result
+}
+
+// ListSchedules lists the maintenance tasks the server runs on a schedule,
+// with when each next runs and the last run of each for the authenticated
+// user.
+func (s *LibraryServer) ListSchedules(ctx context.Context, req *proto.ListSchedulesRequest) (*proto.ListSchedulesResponse, error) {
+	userID, ok := ctx.Value(contextKeyUser{}).(uint)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "authentication required")
+	}
+
+	now := time.Now()
+	schedules := make([]*proto.Schedule, 0, len(s.Schedules))
+	for _, schedule := range s.Schedules {
+		result := &proto.Schedule{
+			Task:       schedule.Task,
+			Expression: schedule.Expression,
+		}
+		if next := schedule.Next(now); !next.IsZero() {
+			result.NextRunAt = next.Format(time.RFC3339)
+		}
+
+		var run database.ScheduledRun
+		_, dbSpan := startSpan(ctx, "db.get_last_scheduled_run")
+		err := s.DB.Where("task = ? AND user_id = ?", schedule.Task, userID).Order("id DESC").First(&run).Error
+		switch err {
+		case nil:
+			endSpanOk(dbSpan)
+			result.LastRun = scheduledRunToProto(&run)
+		case gorm.ErrRecordNotFound:
+			endSpanOk(dbSpan)
+		default:
+			recordSpanError(dbSpan, err)
+			return nil, status.Errorf(codes.Internal, "failed to query scheduled runs: %v", err)
+		}
+		schedules = append(schedules, result)
+	}
+
+	return &proto.ListSchedulesResponse{
+		Schedules: schedules,
+	}, nil
+}
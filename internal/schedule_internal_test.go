@@ -0,0 +1,250 @@
+package internal
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/alexhokl/photos/database"
+	"github.com/alexhokl/photos/proto"
+	"google.golang.org/grpc/codes"
+	"gorm.io/gorm"
+)
+
+func TestParseSchedules(t *testing.T) {
+	schedules, err := ParseSchedules([]string{"sync=0 3 * * *", " webp = @daily", "purge_trash=0 4 * * 0"})
+	if err != nil {
+		t.Fatalf("ParseSchedules() error = %v", err)
+	}
+	if len(schedules) != 3 {
+		t.Fatalf("got %d schedules, want 3", len(schedules))
+	}
+	if schedules[1].Task != ScheduleTaskWebP || schedules[1].Expression != "@daily" {
+		t.Errorf("got task %q, expression %q, want webp, @daily", schedules[1].Task, schedules[1].Expression)
+	}
+	after := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	if got := schedules[0].Next(after); !got.Equal(time.Date(2024, 5, 2, 3, 0, 0, 0, time.UTC)) {
+		t.Errorf("Next() = %v", got)
+	}
+
+	if schedules, err := ParseSchedules(nil); err != nil || len(schedules) != 0 {
+		t.Errorf("ParseSchedules(nil) = %v, %v, want none", schedules, err)
+	}
+
+	for _, specs := range [][]string{
+		{"sync"},
+		{"backup=0 3 * * *"},
+		{"sync=0 3 * *"},
+		{"sync=0 3 * * *", "sync=0 4 * * *"},
+	} {
+		if _, err := ParseSchedules(specs); err == nil {
+			t.Errorf("ParseSchedules(%q): expected error, got nil", specs)
+		}
+	}
+}
+
+func TestCheckPhotoIntegrity(t *testing.T) {
+	md5Hash := []byte("0123456789abcdef")
+	webpID := "a_webp.webp"
+	missingWebpID := "b_webp.webp"
+	thumbnailID := "b_preview.jpg"
+	photos := []database.PhotoObject{
+		{ObjectID: "a.jpg", MD5Hash: base64.StdEncoding.EncodeToString(md5Hash), WebpObjectID: &webpID},
+		{ObjectID: "b.jpg", MD5Hash: "different", WebpObjectID: &missingWebpID, ThumbnailObjectID: &thumbnailID},
+		{ObjectID: "composite.jpg", MD5Hash: "unknown"},
+		{ObjectID: "missing.jpg", MD5Hash: "missing"},
+	}
+	objects := map[string]*storage.ObjectAttrs{
+		"a.jpg":         {Name: "a.jpg", MD5: md5Hash},
+		"a_webp.webp":   {Name: "a_webp.webp"},
+		"b.jpg":         {Name: "b.jpg", MD5: md5Hash},
+		"b_preview.jpg": {Name: "b_preview.jpg"},
+		"composite.jpg": {Name: "composite.jpg"},
+	}
+
+	check := &integrityCheck{ctx: context.Background()}
+	check.checkPhotoIntegrity(photos, objects)
+	report := check.report
+	expected := integrityReport{photos: 4, missingObjects: 1, md5Mismatches: 1, danglingDerived: 1}
+	if report != expected {
+		t.Errorf("got %+v, want %+v", report, expected)
+	}
+	if got := report.String(); got != "checked 4 photos: 1 missing from storage, 1 with a different MD5, 1 dangling derived assets, 0 orphaned derived assets, 0 empty directories, 0 missing directories" {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+func TestPurgeTrash(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	now := time.Now()
+	cutoff := now.Add(-trashRetention)
+	longAgo := gorm.DeletedAt{Time: cutoff.Add(-time.Hour), Valid: true}
+	recently := gorm.DeletedAt{Time: now.Add(-time.Hour), Valid: true}
+
+	photos := []database.PhotoObject{
+		{ObjectID: "old.jpg", ContentType: "image/jpeg", MD5Hash: "1", UserID: 1},
+		{ObjectID: "recent.jpg", ContentType: "image/jpeg", MD5Hash: "2", UserID: 1},
+		{ObjectID: "kept.jpg", ContentType: "image/jpeg", MD5Hash: "3", UserID: 1},
+		{ObjectID: "other.jpg", ContentType: "image/jpeg", MD5Hash: "4", UserID: 2},
+	}
+	db.Create(&photos)
+	db.Unscoped().Model(&photos[0]).Update("deleted_at", longAgo)
+	db.Unscoped().Model(&photos[1]).Update("deleted_at", recently)
+	db.Unscoped().Model(&photos[3]).Update("deleted_at", longAgo)
+
+	rendition := database.PhotoRendition{PhotoObjectID: "old.jpg", ObjectID: "old_256.jpg", UserID: 1, LongEdge: 256, Width: 256, Height: 192, ContentType: "image/jpeg"}
+	db.Create(&rendition)
+	db.Unscoped().Model(&rendition).Update("deleted_at", longAgo)
+
+	finishedLongAgo := cutoff.Add(-time.Hour)
+	jobs := []database.Job{
+		{Kind: database.JobKindDerivedAssets, ObjectID: "old.jpg", UserID: 1, Status: database.JobStatusSucceeded, RunAfter: finishedLongAgo, FinishedAt: &finishedLongAgo},
+		{Kind: database.JobKindDerivedAssets, ObjectID: "kept.jpg", UserID: 1, Status: database.JobStatusFailed, RunAfter: finishedLongAgo, FinishedAt: &finishedLongAgo},
+		{Kind: database.JobKindDerivedAssets, ObjectID: "recent.jpg", UserID: 1, Status: database.JobStatusSucceeded, RunAfter: now, FinishedAt: &now},
+	}
+	db.Create(&jobs)
+
+	purgedPhotos, purgedRecords, err := purgeTrash(context.Background(), db, 1, cutoff)
+	if err != nil {
+		t.Fatalf("purgeTrash() error = %v", err)
+	}
+	if purgedPhotos != 1 || purgedRecords != 2 {
+		t.Errorf("got %d photos and %d records purged, want 1 and 2", purgedPhotos, purgedRecords)
+	}
+
+	var remaining []database.PhotoObject
+	db.Unscoped().Order("object_id").Find(&remaining)
+	var objectIDs []string
+	for _, photo := range remaining {
+		objectIDs = append(objectIDs, photo.ObjectID)
+	}
+	if strings.Join(objectIDs, ",") != "kept.jpg,other.jpg,recent.jpg" {
+		t.Errorf("got remaining photos %v", objectIDs)
+	}
+	var jobCount int64
+	db.Unscoped().Model(&database.Job{}).Count(&jobCount)
+	if jobCount != 2 {
+		t.Errorf("got %d jobs, want 2", jobCount)
+	}
+}
+
+func TestRunScheduledTask(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	server := &LibraryServer{DB: db}
+	ctx := context.Background()
+
+	run := server.runScheduledTask(ctx, ScheduleTaskPurgeTrash, 1)
+	stored := database.ScheduledRun{}
+	if err := db.First(&stored, run.ID).Error; err != nil {
+		t.Fatalf("failed to find run: %v", err)
+	}
+	if stored.Task != ScheduleTaskPurgeTrash || stored.Status != database.ScheduledRunStatusSucceeded || stored.FinishedAt == nil {
+		t.Errorf("got task %q, status %q, finished at %v", stored.Task, stored.Status, stored.FinishedAt)
+	}
+	if stored.Summary != "purged 0 deleted photos and 0 other records" {
+		t.Errorf("got summary %q", stored.Summary)
+	}
+
+	// The photo is not in storage
+	db.Create(&database.PhotoObject{ObjectID: "a.jpg", ContentType: "image/jpeg", MD5Hash: "1", UserID: 1})
+	run = server.runScheduledTask(ctx, ScheduleTaskVerify, 1)
+	if run.Status != database.ScheduledRunStatusSucceeded || !strings.Contains(run.Summary, "1 missing from storage") {
+		t.Errorf("got status %q, summary %q", run.Status, run.Summary)
+	}
+
+	run = server.runScheduledTask(ctx, "backup", 1)
+	failed := database.ScheduledRun{}
+	if err := db.First(&failed, run.ID).Error; err != nil {
+		t.Fatalf("failed to find run: %v", err)
+	}
+	if failed.Status != database.ScheduledRunStatusFailed || failed.Error != `unknown task "backup"` {
+		t.Errorf("got status %q, error %q", failed.Status, failed.Error)
+	}
+}
+
+func TestRunScheduledTask_Sync(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	server := &LibraryServer{DB: db}
+
+	// Without storage every photo is removed
+	db.Create(&database.PhotoObject{ObjectID: "a.jpg", ContentType: "image/jpeg", MD5Hash: "1", UserID: 1})
+	run := server.runScheduledTask(context.Background(), ScheduleTaskSync, 1)
+	if run.Status != database.ScheduledRunStatusSucceeded {
+		t.Fatalf("got status %q, error %q", run.Status, run.Error)
+	}
+	if !strings.HasPrefix(run.Summary, "added 0, removed 1,") {
+		t.Errorf("got summary %q", run.Summary)
+	}
+}
+
+func TestRunSchedules_FailsInterruptedRuns(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	schedules, err := ParseSchedules([]string{"verify=0 0 30 2 *"})
+	if err != nil {
+		t.Fatalf("ParseSchedules() error = %v", err)
+	}
+	server := &LibraryServer{DB: db, Schedules: schedules}
+	run := database.ScheduledRun{Task: ScheduleTaskVerify, UserID: 1, Status: database.ScheduledRunStatusRunning, StartedAt: time.Now()}
+	db.Create(&run)
+
+	// The schedule never runs, so RunSchedules returns
+	server.RunSchedules(context.Background())
+
+	if err := db.First(&run, run.ID).Error; err != nil {
+		t.Fatalf("failed to find run: %v", err)
+	}
+	if run.Status != database.ScheduledRunStatusFailed || run.FinishedAt == nil || run.Error == "" {
+		t.Errorf("got status %q, finished at %v, error %q", run.Status, run.FinishedAt, run.Error)
+	}
+}
+
+func TestListSchedules(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	schedules, err := ParseSchedules([]string{"sync=0 3 * * *", "webp=30 3 * * *"})
+	if err != nil {
+		t.Fatalf("ParseSchedules() error = %v", err)
+	}
+	server := &LibraryServer{DB: db, Schedules: schedules}
+
+	started := time.Date(2024, 5, 1, 3, 0, 0, 0, time.UTC)
+	finished := started.Add(time.Minute)
+	db.Create(&[]database.ScheduledRun{
+		{Task: ScheduleTaskSync, UserID: 1, Status: database.ScheduledRunStatusFailed, StartedAt: started.Add(-24 * time.Hour), FinishedAt: &finished, Error: "boom"},
+		{Task: ScheduleTaskSync, UserID: 1, Status: database.ScheduledRunStatusSucceeded, StartedAt: started, FinishedAt: &finished, Summary: "added 1"},
+		{Task: ScheduleTaskWebP, UserID: 2, Status: database.ScheduledRunStatusSucceeded, StartedAt: started, FinishedAt: &finished},
+	})
+
+	resp, err := server.ListSchedules(contextWithUserID(1), &proto.ListSchedulesRequest{})
+	if err != nil {
+		t.Fatalf("ListSchedules() error = %v", err)
+	}
+	if len(resp.GetSchedules()) != 2 {
+		t.Fatalf("got %d schedules, want 2", len(resp.GetSchedules()))
+	}
+	sync := resp.GetSchedules()[0]
+	if sync.GetTask() != ScheduleTaskSync || sync.GetExpression() != "0 3 * * *" {
+		t.Errorf("got task %q, expression %q", sync.GetTask(), sync.GetExpression())
+	}
+	nextRun, err := time.Parse(time.RFC3339, sync.GetNextRunAt())
+	if err != nil || !nextRun.After(time.Now()) {
+		t.Errorf("got next run %q, want a time in the future", sync.GetNextRunAt())
+	}
+	if lastRun := sync.GetLastRun(); lastRun.GetStatus() != database.ScheduledRunStatusSucceeded || lastRun.GetSummary() != "added 1" || lastRun.GetStartedAt() != started.Format(time.RFC3339) {
+		t.Errorf("got last run %v", lastRun)
+	}
+	// Only the runs of the user are reported
+	if webp := resp.GetSchedules()[1]; webp.GetLastRun() != nil {
+		t.Errorf("got last run %v, want none", webp.GetLastRun())
+	}
+
+	resp, err = (&LibraryServer{DB: db}).ListSchedules(contextWithUserID(1), &proto.ListSchedulesRequest{})
+	if err != nil || len(resp.GetSchedules()) != 0 {
+		t.Errorf("ListSchedules() without schedules = %v, %v, want none", resp, err)
+	}
+
+	_, err = server.ListSchedules(context.Background(), &proto.ListSchedulesRequest{})
+	assertGRPCError(t, err, codes.Unauthenticated)
+}
@@ -0,0 +1,467 @@
+package internal
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log/slog"
+	"path"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/alexhokl/photos/database"
+	"github.com/alexhokl/photos/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+// newPhotoSidecar creates a PhotoSidecar record from parsed XMP fields.
+func newPhotoSidecar(objectID, photoObjectID, md5Hash string, sizeBytes int64, userID uint, info *XMPSidecarInfo) *database.PhotoSidecar {
+	sidecar := &database.PhotoSidecar{
+		ObjectID:      objectID,
+		PhotoObjectID: photoObjectID,
+		MD5Hash:       md5Hash,
+		SizeBytes:     sizeBytes,
+		UserID:        userID,
+		Label:         info.Label,
+		Keywords:      strings.Join(info.Keywords, ","),
+		Caption:       info.Caption,
+		HasCrop:       info.HasCrop,
+		CropTop:       info.CropTop,
+		CropLeft:      info.CropLeft,
+		CropBottom:    info.CropBottom,
+		CropRight:     info.CropRight,
+		CropAngle:     info.CropAngle,
+	}
+	if info.HasRating {
+		rating := info.Rating
+		sidecar.Rating = &rating
+	}
+	return sidecar
+}
+
+// applySidecar copies the sidecar fields onto a proto.Photo.
+func applySidecar(photo *proto.Photo, sidecar *database.PhotoSidecar) {
+	if sidecar == nil {
+		return
+	}
+	photo.SidecarObjectId = sidecar.ObjectID
+	if sidecar.Rating != nil {
+		photo.Rating = int32(*sidecar.Rating)
+	}
+	photo.Label = sidecar.Label
+	if sidecar.Keywords != "" {
+		photo.Keywords = strings.Split(sidecar.Keywords, ",")
+	}
+	photo.Caption = sidecar.Caption
+	if sidecar.HasCrop {
+		photo.Crop = &proto.PhotoCrop{
+			Top:    sidecar.CropTop,
+			Left:   sidecar.CropLeft,
+			Bottom: sidecar.CropBottom,
+			Right:  sidecar.CropRight,
+			Angle:  sidecar.CropAngle,
+		}
+	}
+}
+
+// getPhotoSidecar returns the sidecar attached to a photo, or nil if there is none.
+func getPhotoSidecar(db *gorm.DB, userID uint, photoObjectID string) *database.PhotoSidecar {
+	var sidecar database.PhotoSidecar
+	if err := db.Where("photo_object_id = ? AND user_id = ?", photoObjectID, userID).First(&sidecar).Error; err != nil {
+		return nil
+	}
+	return &sidecar
+}
+
+// getPhotoSidecars returns the sidecars attached to the given photos, keyed by
+// photo object ID.
+func getPhotoSidecars(db *gorm.DB, userID uint, photoObjectIDs []string) (map[string]*database.PhotoSidecar, error) {
+	sidecars := make(map[string]*database.PhotoSidecar)
+	if len(photoObjectIDs) == 0 {
+		return sidecars, nil
+	}
+
+	var rows []database.PhotoSidecar
+	if err := db.Where("photo_object_id IN ? AND user_id = ?", photoObjectIDs, userID).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	for i := range rows {
+		sidecars[rows[i].PhotoObjectID] = &rows[i]
+	}
+	return sidecars, nil
+}
+
+// findSidecarOwner returns the object ID of the photo an XMP sidecar belongs
+// to: a photo in the same directory with the same basename. RAW files are
+// preferred over other formats so that a RAW+JPEG pair keeps the sidecar on
+// the RAW. Returns an empty string if no such photo exists.
+func findSidecarOwner(db *gorm.DB, userID uint, sidecarID string) (string, error) {
+	base := strings.TrimSuffix(sidecarID, path.Ext(sidecarID))
+
+	var candidates []database.PhotoObject
+	if err := db.Where("user_id = ? AND object_id LIKE ?", userID, base+".%").
+		Order("object_id ASC").
+		Find(&candidates).Error; err != nil {
+		return "", err
+	}
+
+	owner := ""
+	for _, candidate := range candidates {
+		id := candidate.ObjectID
+		// LIKE treats "_" and "%" in the basename as wildcards, so confirm
+		// the match exactly.
+		if strings.TrimSuffix(id, path.Ext(id)) != base {
+			continue
+		}
+		if isSidecarObjectID(id) || isDerivedObject(db, id) {
+			continue
+		}
+		if IsRawContentType(candidate.ContentType) {
+			return id, nil
+		}
+		if owner == "" {
+			owner = id
+		}
+	}
+	return owner, nil
+}
+
+// attachSidecar links an existing, unattached XMP sidecar to a newly created
+// photo with the same basename, and returns the attached sidecar (or nil if
+// there is none). A sidecar already attached to another existing photo is
+// left alone.
+func attachSidecar(ctx context.Context, db *gorm.DB, userID uint, photoObjectID string) *database.PhotoSidecar {
+	var sidecar database.PhotoSidecar
+	if err := db.Where("object_id = ? AND user_id = ?", sidecarObjectID(photoObjectID), userID).First(&sidecar).Error; err != nil {
+		return nil
+	}
+
+	if sidecar.PhotoObjectID == photoObjectID {
+		return &sidecar
+	}
+	if sidecar.PhotoObjectID != "" {
+		var count int64
+		if err := db.Model(&database.PhotoObject{}).
+			Where("object_id = ? AND user_id = ?", sidecar.PhotoObjectID, userID).
+			Count(&count).Error; err != nil || count > 0 {
+			return nil
+		}
+	}
+
+	_, dbSpan := startSpan(ctx, "db.attach_sidecar")
+	if err := db.Model(&sidecar).Update("photo_object_id", photoObjectID).Error; err != nil {
+		recordSpanError(dbSpan, err)
+		slog.WarnContext(ctx, "failed to attach XMP sidecar",
+			slog.String("object_id", photoObjectID),
+			slog.String("sidecar_object_id", sidecar.ObjectID),
+			slog.String("error", err.Error()),
+		)
+		return nil
+	}
+	endSpanOk(dbSpan)
+
+	slog.InfoContext(ctx, "Attached XMP sidecar",
+		slog.String("object_id", photoObjectID),
+		slog.String("sidecar_object_id", sidecar.ObjectID),
+	)
+	return &sidecar
+}
+
+// storeSidecar parses an XMP sidecar, writes it to GCS and records its fields
+// in the database, attaching it to the photo with the same basename if one
+// exists. It returns a proto.Photo describing the sidecar object and whether
+// the write was skipped because an identical sidecar already existed.
+// A renamed sidecar would no longer match its photo, so CONFLICT_POLICY_RENAME
+// is treated as CONFLICT_POLICY_FAIL.
+func storeSidecar(ctx context.Context, db *gorm.DB, bucket *storage.BucketHandle, userID uint, objectID string, data []byte, policy proto.ConflictPolicy) (*proto.Photo, bool, error) {
+	info, err := ParseXMPSidecar(data)
+	if err != nil {
+		return nil, false, status.Errorf(codes.InvalidArgument, "invalid XMP sidecar: %v", err)
+	}
+
+	md5Hash := md5.Sum(data)
+	md5HashBase64 := base64.StdEncoding.EncodeToString(md5Hash[:])
+
+	if policy == proto.ConflictPolicy_CONFLICT_POLICY_RENAME {
+		policy = proto.ConflictPolicy_CONFLICT_POLICY_FAIL
+	}
+	if err := checkQuota(ctx, db, userID, objectID, 0, int64(len(data)), replacesExisting(policy)); err != nil {
+		return nil, false, err
+	}
+	written, err := writeUploadObject(ctx, bucket, objectID, XMPSidecarContentType, nil, data, md5Hash[:], policy)
+	if err != nil {
+		return nil, false, err
+	}
+	attrs := written.Attrs
+
+	owner, err := findSidecarOwner(db, userID, objectID)
+	if err != nil {
+		return nil, false, status.Errorf(codes.Internal, "failed to find photo for sidecar: %v", err)
+	}
+
+	sidecar := newPhotoSidecar(objectID, owner, md5HashBase64, int64(len(data)), userID, info)
+	_, createSpan := startSpan(ctx, "db.create_or_restore_photo_sidecar")
+	if err := database.CreateOrRestorePhotoSidecar(db, sidecar); err != nil {
+		recordSpanError(createSpan, err)
+		return nil, false, status.Errorf(codes.Internal, "failed to create photo sidecar record: %v", err)
+	}
+	endSpanOk(createSpan)
+
+	slog.InfoContext(ctx, "Stored XMP sidecar",
+		slog.String("sidecar_object_id", objectID),
+		slog.String("object_id", owner),
+	)
+
+	photo := &proto.Photo{
+		ObjectId:    objectID,
+		Filename:    objectID,
+		ContentType: attrs.ContentType,
+		SizeBytes:   attrs.Size,
+		CreatedAt:   attrs.Created.Format(time.RFC3339),
+		UpdatedAt:   attrs.Updated.Format(time.RFC3339),
+		Md5Hash:     md5HashBase64,
+	}
+	applySidecar(photo, sidecar)
+	return photo, written.Skipped, nil
+}
+
+// copySidecar copies the sidecar attached to sourcePhotoID so that it sits
+// next to destPhotoID and is attached to it. If move is true the source
+// sidecar object and record are removed afterwards. Errors are logged but
+// not fatal, as the photo itself has already been copied.
+func copySidecar(ctx context.Context, db *gorm.DB, bucket *storage.BucketHandle, userID uint, sourcePhotoID, destPhotoID string, move bool) {
+	source := getPhotoSidecar(db, userID, sourcePhotoID)
+	if source == nil {
+		return
+	}
+
+	destID := sidecarObjectID(destPhotoID)
+	_, copySpan := startSpan(ctx, "gcs.copy_object")
+	if _, err := bucket.Object(destID).CopierFrom(bucket.Object(source.ObjectID)).Run(ctx); err != nil {
+		recordSpanError(copySpan, err)
+		slog.WarnContext(ctx, "failed to copy XMP sidecar",
+			slog.String("sidecar_object_id", source.ObjectID),
+			slog.String("destination", destID),
+			slog.String("error", err.Error()),
+		)
+		return
+	}
+	endSpanOk(copySpan)
+
+	dest := *source
+	dest.Model = gorm.Model{}
+	dest.ObjectID = destID
+	dest.PhotoObjectID = destPhotoID
+	_, createSpan := startSpan(ctx, "db.create_or_restore_photo_sidecar")
+	if err := database.CreateOrRestorePhotoSidecar(db, &dest); err != nil {
+		recordSpanError(createSpan, err)
+		slog.WarnContext(ctx, "failed to create XMP sidecar record",
+			slog.String("sidecar_object_id", destID),
+			slog.String("error", err.Error()),
+		)
+		return
+	}
+	endSpanOk(createSpan)
+
+	if move {
+		deleteSidecar(ctx, db, bucket, source)
+	}
+}
+
+// deleteSidecar removes a sidecar object from GCS and its database record.
+// Errors are logged but not fatal.
+func deleteSidecar(ctx context.Context, db *gorm.DB, bucket *storage.BucketHandle, sidecar *database.PhotoSidecar) {
+	_, gcsDelSpan := startSpan(ctx, "gcs.delete_object")
+	if err := bucket.Object(sidecar.ObjectID).Delete(ctx); err != nil && err != storage.ErrObjectNotExist {
+		recordSpanError(gcsDelSpan, err)
+		slog.WarnContext(ctx, "failed to delete XMP sidecar from storage",
+			slog.String("sidecar_object_id", sidecar.ObjectID),
+			slog.String("error", err.Error()),
+		)
+	} else {
+		endSpanOk(gcsDelSpan)
+	}
+
+	_, dbDelSpan := startSpan(ctx, "db.delete_photo_sidecar")
+	if err := db.Delete(sidecar).Error; err != nil {
+		recordSpanError(dbDelSpan, err)
+		slog.WarnContext(ctx, "failed to delete XMP sidecar record",
+			slog.String("sidecar_object_id", sidecar.ObjectID),
+			slog.String("error", err.Error()),
+		)
+		return
+	}
+	endSpanOk(dbDelSpan)
+}
+
+// splitSidecarObjects removes XMP sidecars from gcsObjects and returns them
+// in a separate map.
+func splitSidecarObjects(gcsObjects map[string]*storage.ObjectAttrs) map[string]*storage.ObjectAttrs {
+	sidecars := make(map[string]*storage.ObjectAttrs)
+	for id, attrs := range gcsObjects {
+		if isSidecarObjectID(id) {
+			sidecars[id] = attrs
+			delete(gcsObjects, id)
+		}
+	}
+	return sidecars
+}
+
+// syncSidecars reconciles PhotoSidecar records with the XMP sidecars found in
+// GCS. New or changed sidecars are downloaded, parsed and (re)attached;
+// records whose object no longer exists, according to exists, are deleted;
+// records whose photo has gone are re-attached to another photo with the
+// same basename if there is one. sidecarObjects may hold only the sidecars
+// changed since the last sync; failed is called with each of them that could
+// not be synced. A PHASE_SIDECAR progress message is sent per sidecar
+// examined.
+func (s *LibraryServer) syncSidecars(
+	ctx context.Context,
+	userID uint,
+	sidecarObjects map[string]*storage.ObjectAttrs,
+	exists func(objectID string) bool,
+	failed func(attrs *storage.ObjectAttrs),
+	stream grpc.ServerStreamingServer[proto.SyncDatabaseProgress],
+) (added, removed int, err error) {
+	var dbSidecars []database.PhotoSidecar
+	_, dbListSpan := startSpan(ctx, "db.list_photo_sidecars")
+	if err := s.DB.Where("user_id = ?", userID).Find(&dbSidecars).Error; err != nil {
+		recordSpanError(dbListSpan, err)
+		return 0, 0, status.Errorf(codes.Internal, "failed to list photo sidecars: %v", err)
+	}
+	endSpanOk(dbListSpan)
+
+	dbSidecarMap := make(map[string]database.PhotoSidecar, len(dbSidecars))
+	for _, sidecar := range dbSidecars {
+		dbSidecarMap[sidecar.ObjectID] = sidecar
+	}
+
+	total := uint32(len(sidecarObjects) + len(dbSidecars))
+	var processed uint32
+	sendProgress := func() error {
+		processed++
+		return stream.Send(&proto.SyncDatabaseProgress{
+			Phase:     proto.SyncDatabaseProgress_PHASE_SIDECAR,
+			Processed: processed,
+			Total:     total,
+		})
+	}
+
+	for objectID, attrs := range sidecarObjects {
+		md5Hash := base64.StdEncoding.EncodeToString(attrs.MD5)
+		existing, exists := dbSidecarMap[objectID]
+		if !exists || existing.MD5Hash != md5Hash || existing.SizeBytes != attrs.Size {
+			if err := s.syncSidecar(ctx, userID, objectID, md5Hash); err != nil {
+				slog.WarnContext(ctx, "failed to sync XMP sidecar",
+					slog.String("sidecar_object_id", objectID),
+					slog.String("error", err.Error()),
+				)
+				failed(attrs)
+			} else if !exists {
+				added++
+			}
+		}
+		if err := sendProgress(); err != nil {
+			return added, removed, err
+		}
+	}
+
+	for objectID, sidecar := range dbSidecarMap {
+		if !exists(objectID) {
+			_, delSpan := startSpan(ctx, "db.delete_photo_sidecar")
+			if err := s.DB.Delete(&sidecar).Error; err != nil {
+				recordSpanError(delSpan, err)
+				slog.WarnContext(ctx, "failed to delete XMP sidecar record during sync",
+					slog.String("sidecar_object_id", objectID),
+					slog.String("error", err.Error()),
+				)
+			} else {
+				endSpanOk(delSpan)
+				removed++
+			}
+		} else if err := s.reattachSidecar(ctx, userID, &sidecar); err != nil {
+			slog.WarnContext(ctx, "failed to re-attach XMP sidecar during sync",
+				slog.String("sidecar_object_id", objectID),
+				slog.String("error", err.Error()),
+			)
+		}
+		if err := sendProgress(); err != nil {
+			return added, removed, err
+		}
+	}
+
+	return added, removed, nil
+}
+
+// syncSidecar downloads and parses a single sidecar and upserts its record.
+func (s *LibraryServer) syncSidecar(ctx context.Context, userID uint, objectID, md5Hash string) error {
+	_, readSpan := startSpan(ctx, "gcs.read_object")
+	reader, err := s.GCSClient.Bucket(s.BucketName).Object(objectID).NewReader(ctx)
+	if err != nil {
+		recordSpanError(readSpan, err)
+		return err
+	}
+	data, err := io.ReadAll(reader)
+	_ = reader.Close()
+	if err != nil {
+		recordSpanError(readSpan, err)
+		return err
+	}
+	endSpanOk(readSpan)
+
+	info, err := ParseXMPSidecar(data)
+	if err != nil {
+		return err
+	}
+
+	owner, err := findSidecarOwner(s.DB, userID, objectID)
+	if err != nil {
+		return fmt.Errorf("failed to find photo for sidecar: %w", err)
+	}
+
+	_, createSpan := startSpan(ctx, "db.create_or_restore_photo_sidecar")
+	if err := database.CreateOrRestorePhotoSidecar(s.DB, newPhotoSidecar(objectID, owner, md5Hash, int64(len(data)), userID, info)); err != nil {
+		recordSpanError(createSpan, err)
+		return err
+	}
+	endSpanOk(createSpan)
+	return nil
+}
+
+// reattachSidecar re-resolves the photo of a sidecar whose photo is missing
+// (never uploaded, or since deleted).
+func (s *LibraryServer) reattachSidecar(ctx context.Context, userID uint, sidecar *database.PhotoSidecar) error {
+	if sidecar.PhotoObjectID != "" {
+		var count int64
+		if err := s.DB.Model(&database.PhotoObject{}).
+			Where("object_id = ? AND user_id = ?", sidecar.PhotoObjectID, userID).
+			Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return nil
+		}
+	}
+
+	owner, err := findSidecarOwner(s.DB, userID, sidecar.ObjectID)
+	if err != nil {
+		return err
+	}
+	if owner == sidecar.PhotoObjectID {
+		return nil
+	}
+
+	_, dbSpan := startSpan(ctx, "db.attach_sidecar")
+	if err := s.DB.Model(sidecar).Update("photo_object_id", owner).Error; err != nil {
+		recordSpanError(dbSpan, err)
+		return err
+	}
+	endSpanOk(dbSpan)
+	return nil
+}
@@ -0,0 +1,176 @@
+package internal
+
+import (
+	"slices"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/alexhokl/photos/database"
+	"github.com/alexhokl/photos/proto"
+)
+
+func TestFindSidecarOwner(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	photos := []database.PhotoObject{
+		{ObjectID: "2024/IMG_001.jpg", ContentType: "image/jpeg", MD5Hash: "a", UserID: 1},
+		{ObjectID: "2024/IMG_001.dng", ContentType: "image/x-adobe-dng", MD5Hash: "b", UserID: 1},
+		{ObjectID: "2024/IMG_002.jpg", ContentType: "image/jpeg", MD5Hash: "c", UserID: 1},
+		{ObjectID: "2024/IMGX003.jpg", ContentType: "image/jpeg", MD5Hash: "d", UserID: 1},
+		{ObjectID: "2024/IMG_004.jpg", ContentType: "image/jpeg", MD5Hash: "e", UserID: 2},
+	}
+	for i := range photos {
+		if err := db.Create(&photos[i]).Error; err != nil {
+			t.Fatalf("failed to create photo: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		sidecarID string
+		expected  string
+	}{
+		{"prefers RAW over JPEG", "2024/IMG_001.xmp", "2024/IMG_001.dng"},
+		{"single JPEG", "2024/IMG_002.xmp", "2024/IMG_002.jpg"},
+		{"underscore is not a wildcard", "2024/IMG_003.xmp", ""},
+		{"other user's photo", "2024/IMG_004.xmp", ""},
+		{"no photo", "2024/IMG_005.xmp", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			owner, err := findSidecarOwner(db, 1, test.sidecarID)
+			if err != nil {
+				t.Fatalf("findSidecarOwner returned error: %v", err)
+			}
+			if owner != test.expected {
+				t.Errorf("findSidecarOwner(%q) = %q, want %q", test.sidecarID, owner, test.expected)
+			}
+		})
+	}
+}
+
+func TestAttachSidecar(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	ctx := contextWithUserID(1)
+
+	pending := &database.PhotoSidecar{ObjectID: "2024/IMG_001.xmp", MD5Hash: "x", UserID: 1}
+	if err := db.Create(pending).Error; err != nil {
+		t.Fatalf("failed to create sidecar: %v", err)
+	}
+
+	sidecar := attachSidecar(ctx, db, 1, "2024/IMG_001.dng")
+	if sidecar == nil {
+		t.Fatal("expected pending sidecar to be attached")
+	}
+
+	var stored database.PhotoSidecar
+	if err := db.Where("object_id = ?", "2024/IMG_001.xmp").First(&stored).Error; err != nil {
+		t.Fatalf("failed to load sidecar: %v", err)
+	}
+	if stored.PhotoObjectID != "2024/IMG_001.dng" {
+		t.Errorf("PhotoObjectID = %q, want %q", stored.PhotoObjectID, "2024/IMG_001.dng")
+	}
+}
+
+func TestAttachSidecar_KeepsExistingOwner(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	ctx := contextWithUserID(1)
+
+	owner := &database.PhotoObject{ObjectID: "2024/IMG_001.dng", ContentType: "image/x-adobe-dng", MD5Hash: "a", UserID: 1}
+	if err := db.Create(owner).Error; err != nil {
+		t.Fatalf("failed to create photo: %v", err)
+	}
+	attached := &database.PhotoSidecar{ObjectID: "2024/IMG_001.xmp", PhotoObjectID: owner.ObjectID, MD5Hash: "x", UserID: 1}
+	if err := db.Create(attached).Error; err != nil {
+		t.Fatalf("failed to create sidecar: %v", err)
+	}
+
+	if sidecar := attachSidecar(ctx, db, 1, "2024/IMG_001.jpg"); sidecar != nil {
+		t.Errorf("expected sidecar to stay on the RAW, got attached to %q", sidecar.PhotoObjectID)
+	}
+}
+
+func TestApplySidecar(t *testing.T) {
+	rating := 3
+	sidecar := &database.PhotoSidecar{
+		ObjectID: "IMG_001.xmp",
+		Rating:   &rating,
+		Label:    "Blue",
+		Keywords: "family,beach",
+		Caption:  "Holiday",
+		HasCrop:  true,
+		CropTop:  0.25,
+		CropLeft: 0.5,
+	}
+
+	photo := &proto.Photo{}
+	applySidecar(photo, sidecar)
+
+	if photo.SidecarObjectId != "IMG_001.xmp" {
+		t.Errorf("SidecarObjectId = %q, want %q", photo.SidecarObjectId, "IMG_001.xmp")
+	}
+	if photo.Rating != 3 {
+		t.Errorf("Rating = %d, want 3", photo.Rating)
+	}
+	if photo.Label != "Blue" || photo.Caption != "Holiday" {
+		t.Errorf("Label/Caption = %q/%q, want Blue/Holiday", photo.Label, photo.Caption)
+	}
+	if !slices.Equal(photo.Keywords, []string{"family", "beach"}) {
+		t.Errorf("Keywords = %v, want [family beach]", photo.Keywords)
+	}
+	if photo.Crop == nil || photo.Crop.Top != 0.25 || photo.Crop.Left != 0.5 {
+		t.Errorf("Crop = %+v, want top=0.25 left=0.5", photo.Crop)
+	}
+
+	// A nil sidecar leaves the photo untouched
+	empty := &proto.Photo{}
+	applySidecar(empty, nil)
+	if empty.SidecarObjectId != "" || empty.Crop != nil {
+		t.Errorf("expected untouched photo, got %+v", empty)
+	}
+}
+
+func TestSplitSidecarObjects(t *testing.T) {
+	gcsObjects := map[string]*storage.ObjectAttrs{
+		"IMG_001.dng": {Name: "IMG_001.dng"},
+		"IMG_001.xmp": {Name: "IMG_001.xmp"},
+		"IMG_002.XMP": {Name: "IMG_002.XMP"},
+	}
+
+	sidecars := splitSidecarObjects(gcsObjects)
+
+	if len(sidecars) != 2 {
+		t.Errorf("expected 2 sidecars, got %d", len(sidecars))
+	}
+	if len(gcsObjects) != 1 {
+		t.Errorf("expected 1 remaining object, got %d", len(gcsObjects))
+	}
+	if _, ok := gcsObjects["IMG_001.dng"]; !ok {
+		t.Error("expected IMG_001.dng to remain")
+	}
+}
+
+func TestSyncDatabase_RemovesStaleSidecars(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	server := &LibraryServer{DB: db}
+
+	stale := &database.PhotoSidecar{ObjectID: "IMG_001.xmp", PhotoObjectID: "IMG_001.dng", MD5Hash: "x", UserID: 1}
+	if err := db.Create(stale).Error; err != nil {
+		t.Fatalf("failed to create sidecar: %v", err)
+	}
+
+	stream := newMockSyncDatabaseStream(contextWithUserID(1))
+	if err := server.SyncDatabase(&proto.SyncDatabaseRequest{}, stream); err != nil {
+		t.Fatalf("SyncDatabase returned error: %v", err)
+	}
+
+	var count int64
+	db.Model(&database.PhotoSidecar{}).Count(&count)
+	if count != 0 {
+		t.Errorf("expected stale sidecar to be removed, %d remain", count)
+	}
+
+	last := stream.sent[len(stream.sent)-1]
+	if !last.GetComplete() || last.GetRemoved() != 1 {
+		t.Errorf("final progress = %+v, want complete with removed=1", last)
+	}
+}
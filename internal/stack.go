@@ -0,0 +1,650 @@
+package internal
+
+import (
+	"context"
+	"log/slog"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alexhokl/photos/database"
+	"github.com/alexhokl/photos/proto"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+// burstMaxGap is the longest time between consecutive shots of a burst. EXIF
+// records the time taken to the second, so shots in consecutive seconds are
+// one burst.
+const burstMaxGap = time.Second
+
+// burstMinShots is the fewest shots stacked as a burst; fewer are left
+// alone, as two shots a second apart are as likely to be unrelated.
+const burstMinShots = 3
+
+// photoGroup is a set of photos to be stacked as one, in listing order.
+type photoGroup struct {
+	kind   string
+	photos []*database.PhotoObject
+}
+
+// shot is the photos of a directory sharing a basename, such as the RAW and
+// JPEG files written by a camera for the same shot.
+type shot struct {
+	photos    []*database.PhotoObject
+	timeTaken *time.Time
+	camera    string
+}
+
+// cameraName returns the camera of a photo from its EXIF make and model,
+// which often repeats the make (e.g. "Canon" and "Canon EOS R5").
+func cameraName(cameraMake, cameraModel string) string {
+	cameraMake = strings.TrimSpace(cameraMake)
+	cameraModel = strings.TrimSpace(cameraModel)
+	if cameraMake == "" || strings.HasPrefix(strings.ToLower(cameraModel), strings.ToLower(cameraMake)) {
+		return cameraModel
+	}
+	return strings.TrimSpace(cameraMake + " " + cameraModel)
+}
+
+// groupShots groups photos, which are in one directory, into shots by
+// basename, ordered by the time they were taken with those without a time
+// last.
+func groupShots(photos []database.PhotoObject) []*shot {
+	var shots []*shot
+	byBase := make(map[string]*shot)
+	for i := range photos {
+		photo := &photos[i]
+		base := strings.TrimSuffix(photo.ObjectID, path.Ext(photo.ObjectID))
+		s, ok := byBase[base]
+		if !ok {
+			s = &shot{}
+			byBase[base] = s
+			shots = append(shots, s)
+		}
+		s.photos = append(s.photos, photo)
+		if photo.TimeTaken != nil && (s.timeTaken == nil || photo.TimeTaken.Before(*s.timeTaken)) {
+			s.timeTaken = photo.TimeTaken
+		}
+		if s.camera == "" {
+			s.camera = photo.Camera
+		}
+	}
+	sort.SliceStable(shots, func(i, j int) bool {
+		a, b := shots[i].timeTaken, shots[j].timeTaken
+		if a == nil || b == nil {
+			return a != nil
+		}
+		return a.Before(*b)
+	})
+	return shots
+}
+
+// isRawJpegShot reports whether s holds a RAW file and a file that can be
+// displayed as is, such as a JPEG.
+func (s *shot) isRawJpegShot() bool {
+	var raw, other bool
+	for _, photo := range s.photos {
+		if IsRawContentType(photo.ContentType) {
+			raw = true
+		} else {
+			other = true
+		}
+	}
+	return raw && other
+}
+
+// followsInBurst reports whether s was taken with the same camera as prev,
+// within burstMaxGap of it.
+func (s *shot) followsInBurst(prev *shot) bool {
+	if s.timeTaken == nil || prev.timeTaken == nil || s.camera == "" || s.camera != prev.camera {
+		return false
+	}
+	return s.timeTaken.Sub(*prev.timeTaken) <= burstMaxGap
+}
+
+// findPhotoGroups returns the photos of a directory to be stacked: runs of at
+// least burstMinShots shots taken with the same camera, each within
+// burstMaxGap of the previous, as bursts, and the RAW and JPEG files of other
+// shots (see isRawJpegShot). Photos without a camera or time taken are never
+// part of a burst.
+func findPhotoGroups(photos []database.PhotoObject) []photoGroup {
+	var groups []photoGroup
+	shots := groupShots(photos)
+	for start := 0; start < len(shots); {
+		end := start + 1
+		for end < len(shots) && shots[end].followsInBurst(shots[end-1]) {
+			end++
+		}
+		if end-start >= burstMinShots {
+			group := photoGroup{kind: database.StackKindBurst}
+			for _, s := range shots[start:end] {
+				group.photos = append(group.photos, s.photos...)
+			}
+			groups = append(groups, group)
+		} else {
+			for _, s := range shots[start:end] {
+				if s.isRawJpegShot() {
+					groups = append(groups, photoGroup{kind: database.StackKindRawJpeg, photos: s.photos})
+				}
+			}
+		}
+		start = end
+	}
+	return groups
+}
+
+// defaultStackCover returns the photo listed for a new stack: its first photo
+// that is not a RAW file, which clients can display as is, or its first photo
+// if all of them are.
+func defaultStackCover(photos []*database.PhotoObject) string {
+	for _, photo := range photos {
+		if !IsRawContentType(photo.ContentType) {
+			return photo.ObjectID
+		}
+	}
+	return photos[0].ObjectID
+}
+
+// directoryPhotosQuery restricts query to the photos directly in directory,
+// which is empty for the root.
+func directoryPhotosQuery(query *gorm.DB, directory string) *gorm.DB {
+	if directory == "" {
+		return query.Where("object_id NOT LIKE ?", "%/%")
+	}
+	return query.Where("object_id LIKE ? AND object_id NOT LIKE ?", directory+"/%", directory+"/%/%")
+}
+
+// stackDirectory stacks the user's photos directly in directory (see
+// findPhotoGroups), bringing the stacks recorded for them up to date. Stacks
+// are kept, with their cover if it is still part of them, as photos are added
+// or removed; photos the user has unstacked are left alone. It returns the
+// number of stacks in the directory.
+func stackDirectory(ctx context.Context, db *gorm.DB, userID uint, directory string) (int, error) {
+	var photos []database.PhotoObject
+	_, dbListSpan := startSpan(ctx, "db.list_photo_objects")
+	if err := directoryPhotosQuery(db.Where("user_id = ? AND unstacked = ?", userID, false), directory).
+		Where("content_type LIKE ?", "image/%").
+		Where("object_id NOT IN (?)", db.Model(&database.DerivedObject{}).Select("object_id").Where("user_id = ?", userID)).
+		Order("object_id ASC").
+		Find(&photos).Error; err != nil {
+		recordSpanError(dbListSpan, err)
+		return 0, err
+	}
+	endSpanOk(dbListSpan)
+
+	var stackIDs []uint
+	for _, photo := range photos {
+		if photo.StackID != nil && !slices.Contains(stackIDs, *photo.StackID) {
+			stackIDs = append(stackIDs, *photo.StackID)
+		}
+	}
+	existing := make(map[uint]*database.PhotoStack)
+	if len(stackIDs) > 0 {
+		var stacks []database.PhotoStack
+		if err := db.Where("id IN ? AND user_id = ?", stackIDs, userID).Find(&stacks).Error; err != nil {
+			return 0, err
+		}
+		for i := range stacks {
+			existing[stacks[i].ID] = &stacks[i]
+		}
+	}
+
+	groups := findPhotoGroups(photos)
+	stacked := make(map[string]bool)
+	kept := make(map[uint]bool)
+	for _, group := range groups {
+		stack, err := reconcileStack(ctx, db, userID, group, existing, kept)
+		if err != nil {
+			return 0, err
+		}
+		kept[stack.ID] = true
+
+		var objectIDs []string
+		for _, photo := range group.photos {
+			stacked[photo.ObjectID] = true
+			if photo.StackID == nil || *photo.StackID != stack.ID {
+				objectIDs = append(objectIDs, photo.ObjectID)
+			}
+		}
+		if len(objectIDs) == 0 {
+			continue
+		}
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&database.PhotoObject{}).
+				Where("user_id = ? AND object_id IN ?", userID, objectIDs).
+				Update("stack_id", stack.ID).Error; err != nil {
+				return err
+			}
+			return recordPhotoChange(tx, userID, database.ChangeKindUpdated, objectIDs...)
+		}); err != nil {
+			return 0, err
+		}
+	}
+
+	// Photos no longer part of a stack, such as the other file of a deleted
+	// RAW and JPEG pair
+	var unstacked []string
+	for _, photo := range photos {
+		if photo.StackID != nil && !stacked[photo.ObjectID] {
+			unstacked = append(unstacked, photo.ObjectID)
+		}
+	}
+	if len(unstacked) > 0 {
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(&database.PhotoObject{}).
+				Where("user_id = ? AND object_id IN ?", userID, unstacked).
+				Update("stack_id", nil).Error; err != nil {
+				return err
+			}
+			return recordPhotoChange(tx, userID, database.ChangeKindUpdated, unstacked...)
+		}); err != nil {
+			return 0, err
+		}
+	}
+	for id, stack := range existing {
+		if kept[id] {
+			continue
+		}
+		if err := db.Delete(stack).Error; err != nil {
+			return 0, err
+		}
+	}
+
+	return len(groups), nil
+}
+
+// reconcileStack returns the stack for group: the existing stack most of its
+// photos belong to, unless it is already kept for another group, updated
+// with the kind of the group and a new cover if its cover has left it; or a
+// new stack.
+func reconcileStack(ctx context.Context, db *gorm.DB, userID uint, group photoGroup, existing map[uint]*database.PhotoStack, kept map[uint]bool) (*database.PhotoStack, error) {
+	votes := make(map[uint]int)
+	var stack *database.PhotoStack
+	for _, photo := range group.photos {
+		if photo.StackID == nil || kept[*photo.StackID] || existing[*photo.StackID] == nil {
+			continue
+		}
+		votes[*photo.StackID]++
+		if stack == nil || votes[*photo.StackID] > votes[stack.ID] {
+			stack = existing[*photo.StackID]
+		}
+	}
+
+	if stack == nil {
+		stack = &database.PhotoStack{
+			Kind:          group.kind,
+			CoverObjectID: defaultStackCover(group.photos),
+			UserID:        userID,
+		}
+		_, createSpan := startSpan(ctx, "db.create_photo_stack")
+		if err := db.Create(stack).Error; err != nil {
+			recordSpanError(createSpan, err)
+			return nil, err
+		}
+		endSpanOk(createSpan)
+		return stack, nil
+	}
+
+	cover := stack.CoverObjectID
+	if !slices.ContainsFunc(group.photos, func(photo *database.PhotoObject) bool { return photo.ObjectID == cover }) {
+		cover = defaultStackCover(group.photos)
+	}
+	if stack.Kind == group.kind && stack.CoverObjectID == cover {
+		return stack, nil
+	}
+	// The photos listed in place of the stack change with its cover
+	covers := []string{cover}
+	if stack.CoverObjectID != cover {
+		covers = append(covers, stack.CoverObjectID)
+	}
+	stack.Kind = group.kind
+	stack.CoverObjectID = cover
+	_, updateSpan := startSpan(ctx, "db.update_photo_stack")
+	if err := db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Save(stack).Error; err != nil {
+			return err
+		}
+		return recordPhotoChange(tx, userID, database.ChangeKindUpdated, covers...)
+	}); err != nil {
+		recordSpanError(updateSpan, err)
+		return nil, err
+	}
+	endSpanOk(updateSpan)
+	return stack, nil
+}
+
+// restackPhoto stacks the photos of the directory of objectID again, after
+// the photo has been uploaded, copied, moved or deleted. Errors are logged
+// but not fatal.
+func restackPhoto(ctx context.Context, db *gorm.DB, userID uint, objectID string) {
+	directory := ExtractDirectoryFromPath(objectID)
+	if _, err := stackDirectory(ctx, db, userID, directory); err != nil {
+		slog.WarnContext(ctx, "failed to stack photos",
+			slog.String("path", directory),
+			slog.String("error", err.Error()),
+		)
+	}
+}
+
+// syncStacks stacks the photos of every directory of the user (see
+// stackDirectory), such as those uploaded before stacking existed or synced
+// from the bucket, and deletes stacks left without photos. It returns the
+// number of stacks.
+func (s *LibraryServer) syncStacks(ctx context.Context, userID uint) (int, error) {
+	var objectIDs []string
+	_, dbListSpan := startSpan(ctx, "db.list_photo_objects")
+	if err := s.DB.Model(&database.PhotoObject{}).
+		Where("user_id = ? AND content_type LIKE ?", userID, "image/%").
+		Order("object_id ASC").
+		Pluck("object_id", &objectIDs).Error; err != nil {
+		recordSpanError(dbListSpan, err)
+		return 0, err
+	}
+	endSpanOk(dbListSpan)
+
+	var directories []string
+	for _, objectID := range objectIDs {
+		directory := ExtractDirectoryFromPath(objectID)
+		if !slices.Contains(directories, directory) {
+			directories = append(directories, directory)
+		}
+	}
+
+	stacks := 0
+	for _, directory := range directories {
+		count, err := stackDirectory(ctx, s.DB, userID, directory)
+		if err != nil {
+			return 0, err
+		}
+		stacks += count
+	}
+
+	_, dbDelSpan := startSpan(ctx, "db.delete_photo_stacks")
+	if err := s.DB.Where("user_id = ?", userID).
+		Where("id NOT IN (?)", s.DB.Model(&database.PhotoObject{}).Select("stack_id").Where("user_id = ? AND stack_id IS NOT NULL", userID)).
+		Delete(&database.PhotoStack{}).Error; err != nil {
+		recordSpanError(dbDelSpan, err)
+		return 0, err
+	}
+	endSpanOk(dbDelSpan)
+
+	return stacks, nil
+}
+
+// stackCoversQuery returns a subquery selecting the object IDs of the covers
+// of the user's stacks, which are listed in place of their stacks.
+func stackCoversQuery(db *gorm.DB, userID uint) *gorm.DB {
+	return db.Model(&database.PhotoStack{}).
+		Select("cover_object_id").
+		Where("user_id = ?", userID)
+}
+
+// getPhotoStacks returns the stacks photoObjects belong to, keyed by ID.
+func getPhotoStacks(db *gorm.DB, userID uint, photoObjects []database.PhotoObject) (map[uint]*proto.PhotoStack, error) {
+	var stackIDs []uint
+	for _, obj := range photoObjects {
+		if obj.StackID != nil && !slices.Contains(stackIDs, *obj.StackID) {
+			stackIDs = append(stackIDs, *obj.StackID)
+		}
+	}
+	result := make(map[uint]*proto.PhotoStack)
+	if len(stackIDs) == 0 {
+		return result, nil
+	}
+
+	var stacks []database.PhotoStack
+	if err := db.Where("id IN ? AND user_id = ?", stackIDs, userID).Find(&stacks).Error; err != nil {
+		return nil, err
+	}
+	var counts []struct {
+		StackID uint
+		Count   int32
+	}
+	if err := db.Model(&database.PhotoObject{}).
+		Select("stack_id, COUNT(*) AS count").
+		Where("user_id = ? AND stack_id IN ?", userID, stackIDs).
+		Group("stack_id").
+		Scan(&counts).Error; err != nil {
+		return nil, err
+	}
+	for i := range stacks {
+		result[stacks[i].ID] = &proto.PhotoStack{
+			Kind:          stacks[i].Kind,
+			CoverObjectId: stacks[i].CoverObjectID,
+		}
+	}
+	for _, count := range counts {
+		if stack, ok := result[count.StackID]; ok {
+			stack.Count = count.Count
+		}
+	}
+	return result, nil
+}
+
+// getStackedPhoto returns the photo objectID of the user and the stack it
+// belongs to, or a gRPC error if either does not exist.
+func (s *LibraryServer) getStackedPhoto(ctx context.Context, userID uint, objectID string) (*database.PhotoObject, *database.PhotoStack, error) {
+	if objectID == "" {
+		return nil, nil, status.Errorf(codes.InvalidArgument, "object_id is required")
+	}
+
+	var photoObject database.PhotoObject
+	_, dbSpan := startSpan(ctx, "db.get_photo")
+	if err := s.DB.Where("object_id = ? AND user_id = ?", objectID, userID).First(&photoObject).Error; err != nil {
+		recordSpanError(dbSpan, err)
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil, status.Errorf(codes.NotFound, "photo not found: %s", objectID)
+		}
+		return nil, nil, status.Errorf(codes.Internal, "failed to query photo: %v", err)
+	}
+	endSpanOk(dbSpan)
+	if photoObject.StackID == nil {
+		return nil, nil, status.Errorf(codes.NotFound, "photo is not stacked: %s", objectID)
+	}
+
+	var stack database.PhotoStack
+	_, stackSpan := startSpan(ctx, "db.get_photo_stack")
+	if err := s.DB.Where("id = ? AND user_id = ?", *photoObject.StackID, userID).First(&stack).Error; err != nil {
+		recordSpanError(stackSpan, err)
+		if err == gorm.ErrRecordNotFound {
+			return nil, nil, status.Errorf(codes.NotFound, "photo is not stacked: %s", objectID)
+		}
+		return nil, nil, status.Errorf(codes.Internal, "failed to query photo stack: %v", err)
+	}
+	endSpanOk(stackSpan)
+	return &photoObject, &stack, nil
+}
+
+// getStackPhotos returns the photos of stack, cover first and the others in
+// listing order.
+func (s *LibraryServer) getStackPhotos(ctx context.Context, stack *database.PhotoStack) ([]database.PhotoObject, error) {
+	var photoObjects []database.PhotoObject
+	_, dbSpan := startSpan(ctx, "db.list_stack_photos")
+	if err := s.DB.Where("stack_id = ? AND user_id = ?", stack.ID, stack.UserID).
+		Order("time_taken ASC NULLS LAST, object_id ASC").
+		Find(&photoObjects).Error; err != nil {
+		recordSpanError(dbSpan, err)
+		return nil, err
+	}
+	endSpanOk(dbSpan)
+	sort.SliceStable(photoObjects, func(i, j int) bool {
+		return photoObjects[i].ObjectID == stack.CoverObjectID && photoObjects[j].ObjectID != stack.CoverObjectID
+	})
+	return photoObjects, nil
+}
+
+// GetStack returns the stack a photo belongs to with all of its photos,
+// cover first.
+func (s *LibraryServer) GetStack(ctx context.Context, req *proto.GetStackRequest) (*proto.GetStackResponse, error) {
+	userID, ok := ctx.Value(contextKeyUser{}).(uint)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "authentication required")
+	}
+
+	_, stack, err := s.getStackedPhoto(ctx, userID, req.GetObjectId())
+	if err != nil {
+		return nil, err
+	}
+	photoObjects, err := s.getStackPhotos(ctx, stack)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list stack photos: %v", err)
+	}
+
+	objectIDs := make([]string, 0, len(photoObjects))
+	for _, obj := range photoObjects {
+		objectIDs = append(objectIDs, obj.ObjectID)
+	}
+	_, sidecarSpan := startSpan(ctx, "db.list_photo_sidecars")
+	sidecars, err := getPhotoSidecars(s.DB, userID, objectIDs)
+	if err != nil {
+		recordSpanError(sidecarSpan, err)
+		return nil, status.Errorf(codes.Internal, "failed to list photo sidecars: %v", err)
+	}
+	endSpanOk(sidecarSpan)
+
+	_, renditionSpan := startSpan(ctx, "db.list_photo_renditions")
+	renditions, err := getPhotosRenditions(s.DB, userID, objectIDs)
+	if err != nil {
+		recordSpanError(renditionSpan, err)
+		return nil, status.Errorf(codes.Internal, "failed to list photo renditions: %v", err)
+	}
+	endSpanOk(renditionSpan)
+
+	protoStack := &proto.PhotoStack{
+		Kind:          stack.Kind,
+		CoverObjectId: stack.CoverObjectID,
+		Count:         int32(len(photoObjects)),
+	}
+	photos := make([]*proto.Photo, 0, len(photoObjects))
+	for i := range photoObjects {
+		obj := &photoObjects[i]
+		photo := listedPhoto(obj)
+		applySidecar(photo, sidecars[obj.ObjectID])
+		photo.Renditions = renditionsToProto(renditions[obj.ObjectID])
+		photo.Stack = protoStack
+		photos = append(photos, photo)
+	}
+
+	return &proto.GetStackResponse{
+		Stack:  protoStack,
+		Photos: photos,
+	}, nil
+}
+
+// SetStackCover lists a photo in place of the stack it belongs to.
+func (s *LibraryServer) SetStackCover(ctx context.Context, req *proto.SetStackCoverRequest) (*proto.SetStackCoverResponse, error) {
+	userID, ok := ctx.Value(contextKeyUser{}).(uint)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "authentication required")
+	}
+
+	photoObject, stack, err := s.getStackedPhoto(ctx, userID, req.GetObjectId())
+	if err != nil {
+		return nil, err
+	}
+
+	// The photos listed in place of the stack change with its cover
+	covers := []string{photoObject.ObjectID}
+	if stack.CoverObjectID != photoObject.ObjectID {
+		covers = append(covers, stack.CoverObjectID)
+	}
+	_, updateSpan := startSpan(ctx, "db.update_photo_stack")
+	if err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(stack).Update("cover_object_id", photoObject.ObjectID).Error; err != nil {
+			return err
+		}
+		return recordPhotoChange(tx, userID, database.ChangeKindUpdated, covers...)
+	}); err != nil {
+		recordSpanError(updateSpan, err)
+		return nil, status.Errorf(codes.Internal, "failed to update stack cover: %v", err)
+	}
+	endSpanOk(updateSpan)
+
+	var count int64
+	_, countSpan := startSpan(ctx, "db.count_stack_photos")
+	if err := s.DB.Model(&database.PhotoObject{}).
+		Where("stack_id = ? AND user_id = ?", stack.ID, userID).
+		Count(&count).Error; err != nil {
+		recordSpanError(countSpan, err)
+		return nil, status.Errorf(codes.Internal, "failed to count stack photos: %v", err)
+	}
+	endSpanOk(countSpan)
+
+	slog.InfoContext(
+		ctx,
+		"Set stack cover",
+		slog.String("object_id", photoObject.ObjectID),
+		slog.Uint64("user_id", uint64(userID)),
+	)
+
+	return &proto.SetStackCoverResponse{
+		Stack: &proto.PhotoStack{
+			Kind:          stack.Kind,
+			CoverObjectId: photoObject.ObjectID,
+			Count:         int32(count),
+		},
+	}, nil
+}
+
+// Unstack splits up the stack a photo belongs to. Its photos are listed
+// individually from then on and are not stacked automatically again.
+func (s *LibraryServer) Unstack(ctx context.Context, req *proto.UnstackRequest) (*proto.UnstackResponse, error) {
+	userID, ok := ctx.Value(contextKeyUser{}).(uint)
+	if !ok {
+		return nil, status.Errorf(codes.Unauthenticated, "authentication required")
+	}
+
+	_, stack, err := s.getStackedPhoto(ctx, userID, req.GetObjectId())
+	if err != nil {
+		return nil, err
+	}
+	photoObjects, err := s.getStackPhotos(ctx, stack)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to list stack photos: %v", err)
+	}
+
+	objectIDs := make([]string, 0, len(photoObjects))
+	for _, obj := range photoObjects {
+		objectIDs = append(objectIDs, obj.ObjectID)
+	}
+
+	_, updateSpan := startSpan(ctx, "db.update_stack_id")
+	if err := s.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&database.PhotoObject{}).
+			Where("stack_id = ? AND user_id = ?", stack.ID, userID).
+			Updates(map[string]any{
+				"stack_id":  nil,
+				"unstacked": true,
+			}).Error; err != nil {
+			return err
+		}
+		return recordPhotoChange(tx, userID, database.ChangeKindUpdated, objectIDs...)
+	}); err != nil {
+		recordSpanError(updateSpan, err)
+		return nil, status.Errorf(codes.Internal, "failed to unstack photos: %v", err)
+	}
+	endSpanOk(updateSpan)
+
+	_, dbDelSpan := startSpan(ctx, "db.delete_photo_stack")
+	if err := s.DB.Delete(stack).Error; err != nil {
+		recordSpanError(dbDelSpan, err)
+		return nil, status.Errorf(codes.Internal, "failed to delete stack: %v", err)
+	}
+	endSpanOk(dbDelSpan)
+
+	slog.InfoContext(
+		ctx,
+		"Unstacked photos",
+		slog.String("object_id", req.GetObjectId()),
+		slog.Int("count", len(objectIDs)),
+		slog.Uint64("user_id", uint64(userID)),
+	)
+
+	return &proto.UnstackResponse{
+		ObjectIds: objectIDs,
+	}, nil
+}
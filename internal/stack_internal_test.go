@@ -0,0 +1,385 @@
+package internal
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/alexhokl/photos/database"
+	"github.com/alexhokl/photos/proto"
+	"google.golang.org/grpc/codes"
+	"gorm.io/gorm"
+)
+
+func TestCameraName(t *testing.T) {
+	tests := []struct {
+		make     string
+		model    string
+		expected string
+	}{
+		{"SONY", "ILCE-7M3", "SONY ILCE-7M3"},
+		{"Canon", "Canon EOS R5", "Canon EOS R5"},
+		{"Apple", "iPhone 15 Pro", "Apple iPhone 15 Pro"},
+		{"", "X100V", "X100V"},
+		{"FUJIFILM", "", "FUJIFILM"},
+		{"", "", ""},
+	}
+	for _, test := range tests {
+		if got := cameraName(test.make, test.model); got != test.expected {
+			t.Errorf("cameraName(%q, %q) = %q, want %q", test.make, test.model, got, test.expected)
+		}
+	}
+}
+
+// stackTestPhoto returns a photo of user 1 taken second seconds after a fixed
+// time with camera.
+func stackTestPhoto(objectID, contentType string, second int, camera string) database.PhotoObject {
+	taken := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC).Add(time.Duration(second) * time.Second)
+	return database.PhotoObject{
+		ObjectID:    objectID,
+		ContentType: contentType,
+		MD5Hash:     objectID,
+		UserID:      1,
+		TimeTaken:   &taken,
+		Camera:      camera,
+	}
+}
+
+func groupObjectIDs(groups []photoGroup) [][]string {
+	var result [][]string
+	for _, group := range groups {
+		var objectIDs []string
+		for _, photo := range group.photos {
+			objectIDs = append(objectIDs, photo.ObjectID)
+		}
+		result = append(result, append([]string{group.kind}, objectIDs...))
+	}
+	return result
+}
+
+func TestFindPhotoGroups(t *testing.T) {
+	tests := []struct {
+		name     string
+		photos   []database.PhotoObject
+		expected [][]string
+	}{
+		{
+			"RAW and JPEG",
+			[]database.PhotoObject{
+				stackTestPhoto("trip/DSC001.ARW", "image/x-sony-arw", 0, "SONY ILCE-7M3"),
+				stackTestPhoto("trip/DSC001.JPG", "image/jpeg", 0, "SONY ILCE-7M3"),
+				stackTestPhoto("trip/DSC002.JPG", "image/jpeg", 60, "SONY ILCE-7M3"),
+			},
+			[][]string{{"raw_jpeg", "trip/DSC001.ARW", "trip/DSC001.JPG"}},
+		},
+		{
+			"two JPEGs sharing a basename",
+			[]database.PhotoObject{
+				stackTestPhoto("trip/IMG_1.HEIC", "image/heic", 0, "Apple iPhone 15 Pro"),
+				stackTestPhoto("trip/IMG_1.JPG", "image/jpeg", 0, "Apple iPhone 15 Pro"),
+			},
+			nil,
+		},
+		{
+			"burst of RAW and JPEG shots",
+			[]database.PhotoObject{
+				stackTestPhoto("trip/DSC010.ARW", "image/x-sony-arw", 0, "SONY ILCE-7M3"),
+				stackTestPhoto("trip/DSC010.JPG", "image/jpeg", 0, "SONY ILCE-7M3"),
+				stackTestPhoto("trip/DSC011.ARW", "image/x-sony-arw", 0, "SONY ILCE-7M3"),
+				stackTestPhoto("trip/DSC011.JPG", "image/jpeg", 0, "SONY ILCE-7M3"),
+				stackTestPhoto("trip/DSC012.JPG", "image/jpeg", 1, "SONY ILCE-7M3"),
+				stackTestPhoto("trip/DSC013.JPG", "image/jpeg", 30, "SONY ILCE-7M3"),
+			},
+			[][]string{{"burst", "trip/DSC010.ARW", "trip/DSC010.JPG", "trip/DSC011.ARW", "trip/DSC011.JPG", "trip/DSC012.JPG"}},
+		},
+		{
+			"two shots are not a burst",
+			[]database.PhotoObject{
+				stackTestPhoto("trip/DSC020.JPG", "image/jpeg", 0, "SONY ILCE-7M3"),
+				stackTestPhoto("trip/DSC021.JPG", "image/jpeg", 1, "SONY ILCE-7M3"),
+			},
+			nil,
+		},
+		{
+			"shots from different cameras",
+			[]database.PhotoObject{
+				stackTestPhoto("trip/A.JPG", "image/jpeg", 0, "SONY ILCE-7M3"),
+				stackTestPhoto("trip/B.JPG", "image/jpeg", 0, "Apple iPhone 15 Pro"),
+				stackTestPhoto("trip/C.JPG", "image/jpeg", 1, "SONY ILCE-7M3"),
+				stackTestPhoto("trip/D.JPG", "image/jpeg", 1, "Apple iPhone 15 Pro"),
+			},
+			nil,
+		},
+		{
+			"shots without a camera",
+			[]database.PhotoObject{
+				stackTestPhoto("trip/shot1.png", "image/png", 0, ""),
+				stackTestPhoto("trip/shot2.png", "image/png", 0, ""),
+				stackTestPhoto("trip/shot3.png", "image/png", 0, ""),
+			},
+			nil,
+		},
+		{
+			"gap longer than a second",
+			[]database.PhotoObject{
+				stackTestPhoto("trip/P1.JPG", "image/jpeg", 0, "X100V"),
+				stackTestPhoto("trip/P2.JPG", "image/jpeg", 1, "X100V"),
+				stackTestPhoto("trip/P3.JPG", "image/jpeg", 3, "X100V"),
+				stackTestPhoto("trip/P4.JPG", "image/jpeg", 4, "X100V"),
+			},
+			nil,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := groupObjectIDs(findPhotoGroups(test.photos))
+			if !slices.EqualFunc(got, test.expected, slices.Equal) {
+				t.Errorf("findPhotoGroups() = %v, want %v", got, test.expected)
+			}
+		})
+	}
+}
+
+func TestDefaultStackCover(t *testing.T) {
+	raw := stackTestPhoto("DSC001.ARW", "image/x-sony-arw", 0, "")
+	jpeg := stackTestPhoto("DSC001.JPG", "image/jpeg", 0, "")
+
+	if got := defaultStackCover([]*database.PhotoObject{&raw, &jpeg}); got != "DSC001.JPG" {
+		t.Errorf("defaultStackCover() = %q, want DSC001.JPG", got)
+	}
+	if got := defaultStackCover([]*database.PhotoObject{&raw}); got != "DSC001.ARW" {
+		t.Errorf("defaultStackCover() of RAWs = %q, want DSC001.ARW", got)
+	}
+}
+
+// seedStackTestPhotos records a RAW and JPEG pair, a burst of three and a
+// photo that is not stacked in "trip", and a RAW and JPEG pair in
+// "trip/day2".
+func seedStackTestPhotos(t *testing.T, db *gorm.DB) {
+	t.Helper()
+	for _, obj := range []database.PhotoObject{
+		stackTestPhoto("trip/DSC001.ARW", "image/x-sony-arw", 0, "SONY ILCE-7M3"),
+		stackTestPhoto("trip/DSC001.JPG", "image/jpeg", 0, "SONY ILCE-7M3"),
+		stackTestPhoto("trip/DSC010.JPG", "image/jpeg", 100, "SONY ILCE-7M3"),
+		stackTestPhoto("trip/DSC011.JPG", "image/jpeg", 100, "SONY ILCE-7M3"),
+		stackTestPhoto("trip/DSC012.JPG", "image/jpeg", 101, "SONY ILCE-7M3"),
+		stackTestPhoto("trip/DSC020.JPG", "image/jpeg", 200, "SONY ILCE-7M3"),
+		stackTestPhoto("trip/day2/DSC100.ARW", "image/x-sony-arw", 900, "SONY ILCE-7M3"),
+		stackTestPhoto("trip/day2/DSC100.JPG", "image/jpeg", 900, "SONY ILCE-7M3"),
+	} {
+		if err := db.Create(&obj).Error; err != nil {
+			t.Fatalf("failed to seed photo object: %v", err)
+		}
+	}
+}
+
+func stackIDOf(t *testing.T, db *gorm.DB, objectID string) *uint {
+	t.Helper()
+	var photoObject database.PhotoObject
+	if err := db.Where("object_id = ?", objectID).First(&photoObject).Error; err != nil {
+		t.Fatalf("failed to load %s: %v", objectID, err)
+	}
+	return photoObject.StackID
+}
+
+func TestStackDirectory(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	seedStackTestPhotos(t, db)
+	ctx := context.Background()
+
+	count, err := stackDirectory(ctx, db, 1, "trip")
+	if err != nil {
+		t.Fatalf("stackDirectory() error = %v", err)
+	}
+
+	if count != 2 {
+		t.Errorf("stacks = %d, want 2", count)
+	}
+	pair := stackIDOf(t, db, "trip/DSC001.ARW")
+	burst := stackIDOf(t, db, "trip/DSC010.JPG")
+	if pair == nil || burst == nil || *pair == *burst {
+		t.Fatalf("expected the pair and the burst in different stacks, got %v and %v", pair, burst)
+	}
+	if id := stackIDOf(t, db, "trip/DSC001.JPG"); id == nil || *id != *pair {
+		t.Errorf("expected DSC001.JPG to be stacked with DSC001.ARW")
+	}
+	if id := stackIDOf(t, db, "trip/DSC020.JPG"); id != nil {
+		t.Errorf("expected DSC020.JPG not to be stacked")
+	}
+	if id := stackIDOf(t, db, "trip/day2/DSC100.JPG"); id != nil {
+		t.Errorf("expected photos of a sub-directory not to be stacked")
+	}
+	var stack database.PhotoStack
+	db.First(&stack, *pair)
+	if stack.Kind != database.StackKindRawJpeg || stack.CoverObjectID != "trip/DSC001.JPG" {
+		t.Errorf("stack = %s covered by %s, want raw_jpeg covered by trip/DSC001.JPG", stack.Kind, stack.CoverObjectID)
+	}
+
+	// A chosen cover is kept as the directory is stacked again
+	db.Model(&database.PhotoStack{}).Where("id = ?", *burst).Update("cover_object_id", "trip/DSC011.JPG")
+	if _, err := stackDirectory(ctx, db, 1, "trip"); err != nil {
+		t.Fatalf("stackDirectory() error = %v", err)
+	}
+	var burstStack database.PhotoStack
+	db.First(&burstStack, *burst)
+	if burstStack.CoverObjectID != "trip/DSC011.JPG" {
+		t.Errorf("cover = %s, want trip/DSC011.JPG", burstStack.CoverObjectID)
+	}
+	if id := stackIDOf(t, db, "trip/DSC010.JPG"); id == nil || *id != *burst {
+		t.Errorf("expected the burst to keep its stack")
+	}
+
+	// The RAW left alone when its JPEG is deleted is no longer stacked
+	db.Where("object_id = ?", "trip/DSC001.JPG").Delete(&database.PhotoObject{})
+	count, err = stackDirectory(ctx, db, 1, "trip")
+	if err != nil {
+		t.Fatalf("stackDirectory() error = %v", err)
+	}
+	if count != 1 {
+		t.Errorf("stacks = %d, want 1", count)
+	}
+	if id := stackIDOf(t, db, "trip/DSC001.ARW"); id != nil {
+		t.Errorf("expected DSC001.ARW not to be stacked")
+	}
+	if err := db.First(&database.PhotoStack{}, *pair).Error; err != gorm.ErrRecordNotFound {
+		t.Errorf("expected the stack of the pair to be deleted, got %v", err)
+	}
+}
+
+func TestSyncStacks(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	seedStackTestPhotos(t, db)
+	server := &LibraryServer{DB: db}
+
+	count, err := server.syncStacks(context.Background(), 1)
+
+	if err != nil {
+		t.Fatalf("syncStacks() error = %v", err)
+	}
+	if count != 3 {
+		t.Errorf("stacks = %d, want 3", count)
+	}
+	if id := stackIDOf(t, db, "trip/day2/DSC100.ARW"); id == nil {
+		t.Errorf("expected the pair in a sub-directory to be stacked")
+	}
+}
+
+func TestListPhotos_CollapsesStacks(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	seedStackTestPhotos(t, db)
+	server := &LibraryServer{DB: db}
+	if _, err := server.syncStacks(context.Background(), 1); err != nil {
+		t.Fatalf("syncStacks() error = %v", err)
+	}
+
+	resp, err := server.ListPhotos(contextWithUserID(1), &proto.ListPhotosRequest{Prefix: "trip/"})
+	if err != nil {
+		t.Fatalf("ListPhotos failed: %v", err)
+	}
+
+	if resp.TotalCount != 3 || len(resp.Photos) != 3 {
+		t.Fatalf("expected 2 stacks and 1 photo, got %d of %d", len(resp.Photos), resp.TotalCount)
+	}
+	stacks := make(map[string]*proto.PhotoStack)
+	for _, photo := range resp.Photos {
+		stacks[photo.ObjectId] = photo.Stack
+	}
+	if stack := stacks["trip/DSC001.JPG"]; stack == nil || stack.Kind != "raw_jpeg" || stack.Count != 2 {
+		t.Errorf("stack of trip/DSC001.JPG = %v, want raw_jpeg of 2", stack)
+	}
+	if stack := stacks["trip/DSC010.JPG"]; stack == nil || stack.Kind != "burst" || stack.Count != 3 {
+		t.Errorf("stack of trip/DSC010.JPG = %v, want burst of 3", stack)
+	}
+	if stack, ok := stacks["trip/DSC020.JPG"]; !ok || stack != nil {
+		t.Errorf("expected trip/DSC020.JPG to be listed without a stack")
+	}
+}
+
+func TestGetStack(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	seedStackTestPhotos(t, db)
+	server := &LibraryServer{DB: db}
+	if _, err := server.syncStacks(context.Background(), 1); err != nil {
+		t.Fatalf("syncStacks() error = %v", err)
+	}
+
+	resp, err := server.GetStack(contextWithUserID(1), &proto.GetStackRequest{ObjectId: "trip/DSC001.ARW"})
+	if err != nil {
+		t.Fatalf("GetStack failed: %v", err)
+	}
+
+	if resp.Stack.Count != 2 || resp.Stack.CoverObjectId != "trip/DSC001.JPG" {
+		t.Errorf("stack = %v, want 2 photos covered by trip/DSC001.JPG", resp.Stack)
+	}
+	if len(resp.Photos) != 2 || resp.Photos[0].ObjectId != "trip/DSC001.JPG" || resp.Photos[1].ObjectId != "trip/DSC001.ARW" {
+		t.Errorf("expected the cover first, got %v", resp.Photos)
+	}
+
+	_, err = server.GetStack(contextWithUserID(1), &proto.GetStackRequest{ObjectId: "trip/DSC020.JPG"})
+	assertGRPCError(t, err, codes.NotFound)
+	_, err = server.GetStack(contextWithUserID(2), &proto.GetStackRequest{ObjectId: "trip/DSC001.ARW"})
+	assertGRPCError(t, err, codes.NotFound)
+	_, err = server.GetStack(context.Background(), &proto.GetStackRequest{ObjectId: "trip/DSC001.ARW"})
+	assertGRPCError(t, err, codes.Unauthenticated)
+}
+
+func TestSetStackCover(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	seedStackTestPhotos(t, db)
+	server := &LibraryServer{DB: db}
+	if _, err := server.syncStacks(context.Background(), 1); err != nil {
+		t.Fatalf("syncStacks() error = %v", err)
+	}
+
+	resp, err := server.SetStackCover(contextWithUserID(1), &proto.SetStackCoverRequest{ObjectId: "trip/DSC012.JPG"})
+	if err != nil {
+		t.Fatalf("SetStackCover failed: %v", err)
+	}
+
+	if resp.Stack.CoverObjectId != "trip/DSC012.JPG" || resp.Stack.Count != 3 {
+		t.Errorf("stack = %v, want 3 photos covered by trip/DSC012.JPG", resp.Stack)
+	}
+	list, err := server.ListPhotos(contextWithUserID(1), &proto.ListPhotosRequest{Prefix: "trip/"})
+	if err != nil {
+		t.Fatalf("ListPhotos failed: %v", err)
+	}
+	if !slices.ContainsFunc(list.Photos, func(photo *proto.Photo) bool { return photo.ObjectId == "trip/DSC012.JPG" }) {
+		t.Errorf("expected the new cover to be listed")
+	}
+
+	_, err = server.SetStackCover(contextWithUserID(1), &proto.SetStackCoverRequest{})
+	assertGRPCError(t, err, codes.InvalidArgument)
+}
+
+func TestUnstack(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	seedStackTestPhotos(t, db)
+	server := &LibraryServer{DB: db}
+	if _, err := server.syncStacks(context.Background(), 1); err != nil {
+		t.Fatalf("syncStacks() error = %v", err)
+	}
+
+	resp, err := server.Unstack(contextWithUserID(1), &proto.UnstackRequest{ObjectId: "trip/DSC011.JPG"})
+	if err != nil {
+		t.Fatalf("Unstack failed: %v", err)
+	}
+
+	if len(resp.ObjectIds) != 3 {
+		t.Errorf("expected 3 photos unstacked, got %v", resp.ObjectIds)
+	}
+	// Unstacked photos are not stacked again
+	if _, err := server.syncStacks(context.Background(), 1); err != nil {
+		t.Fatalf("syncStacks() error = %v", err)
+	}
+	for _, objectID := range resp.ObjectIds {
+		if id := stackIDOf(t, db, objectID); id != nil {
+			t.Errorf("expected %s not to be stacked again", objectID)
+		}
+	}
+	var stacks int64
+	db.Model(&database.PhotoStack{}).Count(&stacks)
+	if stacks != 2 {
+		t.Errorf("stacks = %d, want 2", stacks)
+	}
+}
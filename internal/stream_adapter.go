@@ -0,0 +1,106 @@
+package internal
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	protobuf "google.golang.org/protobuf/proto"
+)
+
+// newInProcessStream runs a server-streaming handler of an in-process
+// service in a goroutine and returns a client stream that receives its
+// messages, so HTTP handlers can call a proto.ByteServiceServer the same way
+// as a gRPC client. The handler blocks on each message until it is
+// received, so at most one message is held in memory.
+func newInProcessStream[T any](ctx context.Context, handler func(grpc.ServerStreamingServer[T]) error) grpc.ServerStreamingClient[T] {
+	ctx, cancel := context.WithCancel(ctx)
+	messages := make(chan *T)
+	done := make(chan error, 1)
+
+	go func() {
+		done <- handler(&inProcessServerStream[T]{ctx: ctx, messages: messages})
+		close(messages)
+	}()
+
+	return &inProcessClientStream[T]{ctx: ctx, cancel: cancel, messages: messages, done: done}
+}
+
+// inProcessServerStream is the server side of an in-process stream. Only
+// Context and Send are used by the services.
+type inProcessServerStream[T any] struct {
+	grpc.ServerStream
+	ctx      context.Context
+	messages chan<- *T
+}
+
+func (s *inProcessServerStream[T]) Context() context.Context { return s.ctx }
+
+func (s *inProcessServerStream[T]) Send(msg *T) error {
+	// gRPC serialises a message before Send returns, so handlers reuse their
+	// buffers; the message is cloned to give the receiver the same guarantee
+	clone := any(protobuf.Clone(any(msg).(protobuf.Message))).(*T)
+	select {
+	case s.messages <- clone:
+		return nil
+	case <-s.ctx.Done():
+		return s.ctx.Err()
+	}
+}
+
+func (s *inProcessServerStream[T]) SetHeader(metadata.MD) error  { return nil }
+func (s *inProcessServerStream[T]) SendHeader(metadata.MD) error { return nil }
+func (s *inProcessServerStream[T]) SetTrailer(metadata.MD)       {}
+
+// inProcessClientStream is the client side of an in-process stream. Recv
+// returns the handler's error, or io.EOF, once all messages have been
+// received.
+type inProcessClientStream[T any] struct {
+	grpc.ClientStream
+	ctx      context.Context
+	cancel   context.CancelFunc
+	messages <-chan *T
+	done     <-chan error
+
+	once sync.Once
+	err  error
+}
+
+func (s *inProcessClientStream[T]) Context() context.Context { return s.ctx }
+
+func (s *inProcessClientStream[T]) Recv() (*T, error) {
+	if msg, ok := <-s.messages; ok {
+		return msg, nil
+	}
+	s.once.Do(func() {
+		s.err = <-s.done
+		if s.err == nil {
+			s.err = io.EOF
+		}
+		s.cancel()
+	})
+	return nil, s.err
+}
+
+// lastMessageStream is a server stream for calling a server-streaming
+// handler without a client, such as on a schedule. Every message is dropped
+// except the last, which summarises the run.
+type lastMessageStream[T any] struct {
+	grpc.ServerStream
+	ctx  context.Context
+	last *T
+}
+
+func (s *lastMessageStream[T]) Context() context.Context { return s.ctx }
+
+func (s *lastMessageStream[T]) Send(msg *T) error {
+	// Handlers reuse their buffers, as for inProcessServerStream
+	s.last = any(protobuf.Clone(any(msg).(protobuf.Message))).(*T)
+	return s.ctx.Err()
+}
+
+func (s *lastMessageStream[T]) SetHeader(metadata.MD) error  { return nil }
+func (s *lastMessageStream[T]) SendHeader(metadata.MD) error { return nil }
+func (s *lastMessageStream[T]) SetTrailer(metadata.MD)       {}
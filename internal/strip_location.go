@@ -12,11 +12,20 @@ import (
 // GPSTagID is the EXIF tag ID for the GPS IFD pointer (0x8825)
 const GPSTagID = 0x8825
 
-// StripLocationFromImage removes GPS location data from JPEG image EXIF.
+// StripLocationFromImage removes GPS location data from JPEG and HEIC image EXIF.
 // It returns the modified image data with GPS tags removed.
-// If the image is not a JPEG or has no EXIF data, the original data is returned unchanged.
-// Non-JPEG images are returned as-is since GPS stripping is only supported for JPEG.
+// If the image has no EXIF data, the original data is returned unchanged.
+// Other images are returned as-is since GPS stripping is only supported for JPEG and HEIC.
 func StripLocationFromImage(data []byte) ([]byte, error) {
+	if IsHEICContentType(DetectContentType(data)) {
+		stripped, err := stripHEIFLocation(data)
+		if err != nil {
+			// Failed to parse the HEIF structure, return original data
+			return data, nil
+		}
+		return stripped, nil
+	}
+
 	// Check if it's a JPEG by looking at magic bytes
 	if !isJPEG(data) {
 		// Not a JPEG, return original data
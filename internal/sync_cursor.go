@@ -0,0 +1,268 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/alexhokl/photos/database"
+	"google.golang.org/api/iterator"
+	"gorm.io/gorm"
+)
+
+const (
+	// syncCursorSkew is how far behind the start of a sync its cursor is
+	// kept. An object written while the bucket is listed may be listed
+	// before another written earlier, so objects written shortly before a
+	// sync are examined again by the next one rather than missed.
+	syncCursorSkew = time.Minute
+	// syncLookupBatchSize is the number of photos looked up at a time by
+	// object ID in an incremental sync
+	syncLookupBatchSize = 500
+)
+
+// syncPosition orders the changes to objects in the bucket, by update time
+// and then generation.
+type syncPosition struct {
+	updated    time.Time
+	generation int64
+}
+
+// objectSyncPosition returns the position of the last change to an object.
+func objectSyncPosition(attrs *storage.ObjectAttrs) syncPosition {
+	return syncPosition{updated: attrs.Updated, generation: attrs.Generation}
+}
+
+// after reports whether p is a later change than q.
+func (p syncPosition) after(q syncPosition) bool {
+	if !p.updated.Equal(q.updated) {
+		return p.updated.After(q.updated)
+	}
+	return p.generation > q.generation
+}
+
+// syncObjects are the objects and photos examined by a sync.
+type syncObjects struct {
+	// objects are the objects examined, other than derived assets
+	objects map[string]*storage.ObjectAttrs
+	// derivedObjects are the derived assets examined
+	derivedObjects map[string]*storage.ObjectAttrs
+	// names are the names of all objects in the bucket, changed or not
+	names map[string]struct{}
+	// photos are the photos of the user examined
+	photos []database.PhotoObject
+	// position is the cursor of the next incremental sync
+	position syncPosition
+}
+
+// exists reports whether an object is in the bucket.
+func (o *syncObjects) exists(objectID string) bool {
+	_, ok := o.names[objectID]
+	return ok
+}
+
+// fail keeps the cursor of the next incremental sync before the object
+// attrs, which could not be synced, so that it is examined again rather than
+// left out of the database until the next full sync.
+func (o *syncObjects) fail(attrs *storage.ObjectAttrs) {
+	position := objectSyncPosition(attrs)
+	before := syncPosition{updated: position.updated, generation: position.generation - 1}
+	if o.position.after(before) {
+		o.position = before
+	}
+}
+
+// loadSyncCursor returns the cursor of the last sync of the user, or nil if
+// the user has never been synced.
+func loadSyncCursor(ctx context.Context, db *gorm.DB, userID uint) (*syncPosition, error) {
+	_, span := startSpan(ctx, "db.get_sync_cursor")
+	var cursor database.SyncCursor
+	if err := db.Where("user_id = ?", userID).First(&cursor).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			endSpanOk(span)
+			return nil, nil
+		}
+		recordSpanError(span, err)
+		return nil, err
+	}
+	endSpanOk(span)
+	return &syncPosition{updated: cursor.ObjectUpdated, generation: cursor.ObjectGeneration}, nil
+}
+
+// saveSyncCursor records position as the cursor of the user.
+func saveSyncCursor(ctx context.Context, db *gorm.DB, userID uint, position syncPosition) error {
+	_, span := startSpan(ctx, "db.save_sync_cursor")
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var cursor database.SyncCursor
+		err := tx.Where("user_id = ?", userID).First(&cursor).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return tx.Create(&database.SyncCursor{
+				UserID:           userID,
+				ObjectUpdated:    position.updated,
+				ObjectGeneration: position.generation,
+			}).Error
+		}
+		if err != nil {
+			return err
+		}
+		return tx.Model(&cursor).Updates(map[string]any{
+			"object_updated":    position.updated,
+			"object_generation": position.generation,
+		}).Error
+	})
+	if err != nil {
+		recordSpanError(span, err)
+		return err
+	}
+	endSpanOk(span)
+	return nil
+}
+
+// capSyncPosition keeps position syncCursorSkew behind started, the time
+// the bucket started to be listed.
+func capSyncPosition(position syncPosition, started time.Time) syncPosition {
+	limit := started.Add(-syncCursorSkew)
+	if position.updated.After(limit) {
+		return syncPosition{updated: limit}
+	}
+	return position
+}
+
+// listAllSyncObjects lists every object in the bucket and every photo of the
+// user, for a full sync.
+func (s *LibraryServer) listAllSyncObjects(ctx context.Context, userID uint, derived derivedObjectSet) (*syncObjects, error) {
+	started := time.Now()
+
+	_, gcsListSpan := startSpan(ctx, "gcs.list_objects")
+	objects, derivedObjects, err := getGCSNonDerivedObjectsMap(ctx, s.GCSClient, s.BucketName, derived)
+	if err != nil {
+		recordSpanError(gcsListSpan, err)
+		return nil, fmt.Errorf("failed to list GCS objects: %w", err)
+	}
+	endSpanOk(gcsListSpan)
+
+	result := &syncObjects{
+		objects:        objects,
+		derivedObjects: derivedObjects,
+		names:          make(map[string]struct{}, len(objects)+len(derivedObjects)),
+	}
+	for _, m := range []map[string]*storage.ObjectAttrs{objects, derivedObjects} {
+		for objectID, attrs := range m {
+			result.names[objectID] = struct{}{}
+			if position := objectSyncPosition(attrs); position.after(result.position) {
+				result.position = position
+			}
+		}
+	}
+	result.position = capSyncPosition(result.position, started)
+
+	_, dbListSpan := startSpan(ctx, "db.list_photo_objects")
+	if err := s.DB.Where("user_id = ?", userID).Find(&result.photos).Error; err != nil {
+		recordSpanError(dbListSpan, err)
+		return nil, fmt.Errorf("failed to list database objects: %w", err)
+	}
+	endSpanOk(dbListSpan)
+
+	return result, nil
+}
+
+// listChangedSyncObjects lists the objects changed since cursor, for an
+// incremental sync. The bucket is listed with only the name, update time
+// and generation of each object, and the attributes of the objects changed
+// are read individually. The photos examined are those of the changed
+// objects and those whose object is no longer in the bucket.
+func (s *LibraryServer) listChangedSyncObjects(ctx context.Context, userID uint, derived derivedObjectSet, cursor syncPosition) (*syncObjects, error) {
+	started := time.Now()
+	result := &syncObjects{
+		objects:  make(map[string]*storage.ObjectAttrs),
+		names:    make(map[string]struct{}),
+		position: cursor,
+	}
+
+	if s.GCSClient != nil {
+		_, gcsListSpan := startSpan(ctx, "gcs.list_object_names")
+		bucket := s.GCSClient.Bucket(s.BucketName)
+		query := &storage.Query{}
+		if err := query.SetAttrSelection([]string{"Name", "Updated", "Generation"}); err != nil {
+			recordSpanError(gcsListSpan, err)
+			return nil, fmt.Errorf("failed to list GCS objects: %w", err)
+		}
+		var changed []string
+		it := bucket.Objects(ctx, query)
+		for {
+			attrs, err := it.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				recordSpanError(gcsListSpan, err)
+				return nil, fmt.Errorf("failed to list GCS objects: %w", err)
+			}
+			if attrs.Name == "" {
+				continue
+			}
+			result.names[attrs.Name] = struct{}{}
+			position := objectSyncPosition(attrs)
+			if !position.after(cursor) {
+				continue
+			}
+			changed = append(changed, attrs.Name)
+			if position.after(result.position) {
+				result.position = position
+			}
+		}
+		endSpanOk(gcsListSpan)
+
+		for _, objectID := range changed {
+			_, attrsSpan := startSpan(ctx, "gcs.get_object_attrs")
+			attrs, err := bucket.Object(objectID).Attrs(ctx)
+			if errors.Is(err, storage.ErrObjectNotExist) {
+				// Deleted since it was listed
+				endSpanOk(attrsSpan)
+				delete(result.names, objectID)
+				continue
+			}
+			if err != nil {
+				recordSpanError(attrsSpan, err)
+				return nil, fmt.Errorf("failed to get attributes of %s: %w", objectID, err)
+			}
+			endSpanOk(attrsSpan)
+			result.objects[objectID] = attrs
+		}
+	}
+	result.position = capSyncPosition(result.position, started)
+	result.derivedObjects = splitDerivedObjects(result.objects, derived)
+
+	var photoObjectIDs []string
+	_, dbListSpan := startSpan(ctx, "db.list_photo_object_ids")
+	if err := s.DB.Model(&database.PhotoObject{}).Where("user_id = ?", userID).
+		Pluck("object_id", &photoObjectIDs).Error; err != nil {
+		recordSpanError(dbListSpan, err)
+		return nil, fmt.Errorf("failed to list database objects: %w", err)
+	}
+	endSpanOk(dbListSpan)
+
+	var examine []string
+	for _, objectID := range photoObjectIDs {
+		_, changed := result.objects[objectID]
+		_, changedDerived := result.derivedObjects[objectID]
+		if changed || changedDerived || !result.exists(objectID) {
+			examine = append(examine, objectID)
+		}
+	}
+	for start := 0; start < len(examine); start += syncLookupBatchSize {
+		batch := examine[start:min(start+syncLookupBatchSize, len(examine))]
+		var photos []database.PhotoObject
+		_, dbGetSpan := startSpan(ctx, "db.list_photo_objects")
+		if err := s.DB.Where("user_id = ? AND object_id IN ?", userID, batch).Find(&photos).Error; err != nil {
+			recordSpanError(dbGetSpan, err)
+			return nil, fmt.Errorf("failed to list database objects: %w", err)
+		}
+		endSpanOk(dbGetSpan)
+		result.photos = append(result.photos, photos...)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,199 @@
+package internal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/alexhokl/photos/database"
+	"github.com/alexhokl/photos/proto"
+)
+
+func TestSyncPosition_After(t *testing.T) {
+	base := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		p, q syncPosition
+		want bool
+	}{
+		{"later update", syncPosition{base.Add(time.Second), 1}, syncPosition{base, 5}, true},
+		{"earlier update", syncPosition{base, 5}, syncPosition{base.Add(time.Second), 1}, false},
+		{"same update, later generation", syncPosition{base, 6}, syncPosition{base, 5}, true},
+		{"same update, same generation", syncPosition{base, 5}, syncPosition{base, 5}, false},
+		{"zero cursor", syncPosition{base, 1}, syncPosition{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.p.after(tt.q); got != tt.want {
+				t.Errorf("after() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCapSyncPosition(t *testing.T) {
+	started := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	old := syncPosition{updated: started.Add(-time.Hour), generation: 7}
+	if got := capSyncPosition(old, started); got != old {
+		t.Errorf("capSyncPosition() = %+v, want %+v unchanged", got, old)
+	}
+
+	recent := syncPosition{updated: started.Add(-time.Second), generation: 7}
+	got := capSyncPosition(recent, started)
+	if !got.updated.Equal(started.Add(-syncCursorSkew)) || got.generation != 0 {
+		t.Errorf("capSyncPosition() = %+v, want %v with no generation", got, started.Add(-syncCursorSkew))
+	}
+}
+
+func TestSyncObjects_Fail(t *testing.T) {
+	base := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	examined := &syncObjects{position: syncPosition{updated: base.Add(2 * time.Hour), generation: 9}}
+	first := &storage.ObjectAttrs{Name: "a.jpg", Updated: base, Generation: 4}
+	second := &storage.ObjectAttrs{Name: "b.jpg", Updated: base.Add(time.Hour), Generation: 7}
+	// Changed after the cursor, such as during the sync
+	later := &storage.ObjectAttrs{Name: "c.jpg", Updated: base.Add(3 * time.Hour), Generation: 8}
+
+	examined.fail(second)
+	examined.fail(later)
+	examined.fail(first)
+	examined.fail(second)
+
+	for _, attrs := range []*storage.ObjectAttrs{first, second, later} {
+		if !objectSyncPosition(attrs).after(examined.position) {
+			t.Errorf("%s is not after the cursor %+v, want it examined again", attrs.Name, examined.position)
+		}
+	}
+	if examined.position.after(syncPosition{updated: base, generation: 3}) {
+		t.Errorf("cursor = %+v, want it just before the earliest failed object", examined.position)
+	}
+}
+
+func TestSyncCursor_SaveAndLoad(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	ctx := context.Background()
+
+	cursor, err := loadSyncCursor(ctx, db, 1)
+	if err != nil || cursor != nil {
+		t.Fatalf("loadSyncCursor() = %v, %v, want none", cursor, err)
+	}
+
+	first := syncPosition{updated: time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC), generation: 1}
+	second := syncPosition{updated: first.updated.Add(time.Hour), generation: 2}
+	for _, position := range []syncPosition{first, second} {
+		if err := saveSyncCursor(ctx, db, 1, position); err != nil {
+			t.Fatalf("saveSyncCursor() error = %v", err)
+		}
+	}
+
+	cursor, err = loadSyncCursor(ctx, db, 1)
+	if err != nil || cursor == nil {
+		t.Fatalf("loadSyncCursor() = %v, %v, want a cursor", cursor, err)
+	}
+	if !cursor.updated.Equal(second.updated) || cursor.generation != second.generation {
+		t.Errorf("loadSyncCursor() = %+v, want %+v", *cursor, second)
+	}
+
+	var count int64
+	db.Model(&database.SyncCursor{}).Count(&count)
+	if count != 1 {
+		t.Errorf("got %d cursors, want 1", count)
+	}
+	if other, _ := loadSyncCursor(ctx, db, 2); other != nil {
+		t.Errorf("loadSyncCursor() for another user = %+v, want none", *other)
+	}
+}
+
+func TestSyncDatabase_IncrementalAfterFirstSync(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	server := &LibraryServer{DB: db}
+	ctx := contextWithUserID(1)
+
+	stream := newMockSyncDatabaseStream(ctx)
+	if err := server.SyncDatabase(&proto.SyncDatabaseRequest{}, stream); err != nil {
+		t.Fatalf("SyncDatabase() error = %v", err)
+	}
+	if last := stream.sent[len(stream.sent)-1]; !last.GetFull() {
+		t.Errorf("first sync full = false, want true")
+	}
+	if cursor, _ := loadSyncCursor(context.Background(), db, 1); cursor == nil {
+		t.Fatal("expected the first sync to save a cursor")
+	}
+
+	// A photo whose object is not in the bucket is found by the name listing
+	photo := database.PhotoObject{ObjectID: "2024/gone.jpg", ContentType: "image/jpeg", MD5Hash: "x", UserID: 1}
+	if err := db.Create(&photo).Error; err != nil {
+		t.Fatalf("failed to create photo: %v", err)
+	}
+
+	stream = newMockSyncDatabaseStream(ctx)
+	if err := server.SyncDatabase(&proto.SyncDatabaseRequest{}, stream); err != nil {
+		t.Fatalf("SyncDatabase() error = %v", err)
+	}
+	last := stream.sent[len(stream.sent)-1]
+	if last.GetFull() {
+		t.Errorf("second sync full = true, want false")
+	}
+	if last.GetRemoved() != 1 {
+		t.Errorf("removed = %d, want 1", last.GetRemoved())
+	}
+
+	stream = newMockSyncDatabaseStream(ctx)
+	if err := server.SyncDatabase(&proto.SyncDatabaseRequest{Full: true}, stream); err != nil {
+		t.Fatalf("SyncDatabase() error = %v", err)
+	}
+	if last := stream.sent[len(stream.sent)-1]; !last.GetFull() {
+		t.Errorf("requested full sync full = false, want true")
+	}
+}
+
+func TestSyncSidecars_KeepsUnchangedSidecars(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	server := &LibraryServer{DB: db}
+	ctx := contextWithUserID(1)
+
+	for _, objectID := range []string{"2024/IMG_001.xmp", "2024/IMG_002.xmp"} {
+		if err := db.Create(&database.PhotoSidecar{ObjectID: objectID, MD5Hash: "x", UserID: 1}).Error; err != nil {
+			t.Fatalf("failed to create sidecar: %v", err)
+		}
+	}
+
+	// Neither sidecar has changed, and only IMG_002.xmp has been deleted
+	exists := func(objectID string) bool { return objectID == "2024/IMG_001.xmp" }
+	stream := newMockSyncDatabaseStream(ctx)
+	added, removed, err := server.syncSidecars(ctx, 1, map[string]*storage.ObjectAttrs{}, exists, func(*storage.ObjectAttrs) {}, stream)
+	if err != nil {
+		t.Fatalf("syncSidecars() error = %v", err)
+	}
+	if added != 0 || removed != 1 {
+		t.Errorf("syncSidecars() = %d added, %d removed, want 0 and 1", added, removed)
+	}
+
+	var remaining []string
+	db.Model(&database.PhotoSidecar{}).Pluck("object_id", &remaining)
+	if len(remaining) != 1 || remaining[0] != "2024/IMG_001.xmp" {
+		t.Errorf("remaining sidecars = %v, want [2024/IMG_001.xmp]", remaining)
+	}
+}
+
+func TestHasGCSMetadata(t *testing.T) {
+	current := map[string]string{"date_taken": "2024-06-01T12:00:00Z", "width": "100", "other": "x"}
+	tests := []struct {
+		name     string
+		metadata map[string]string
+		want     bool
+	}{
+		{"subset", map[string]string{"width": "100"}, true},
+		{"empty", map[string]string{}, true},
+		{"different value", map[string]string{"width": "200"}, false},
+		{"missing key", map[string]string{"height": "100"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := hasGCSMetadata(current, tt.metadata); got != tt.want {
+				t.Errorf("hasGCSMetadata() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
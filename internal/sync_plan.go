@@ -0,0 +1,380 @@
+package internal
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/alexhokl/photos/database"
+	"github.com/alexhokl/photos/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// planSyncDatabase implements a dry run of SyncDatabase. The objects and
+// photos a sync would examine are examined in the same way, but instead of
+// being changed, the changes are streamed, one per message and in order of
+// object ID within each phase, followed by a summary of them. Nothing is
+// written to the database or the bucket, and the cursor of the user is left
+// as it is.
+//
+// With update_metadata each object examined is downloaded to compare its
+// metadata with that recorded. Changes made after the metadata refresh, to
+// Live Photos, stacks, posters and thumbnails, are not reported.
+func (s *LibraryServer) planSyncDatabase(userID uint, req *proto.SyncDatabaseRequest, stream grpc.ServerStreamingServer[proto.SyncDatabaseProgress]) error {
+	ctx := stream.Context()
+
+	_, derivedListSpan := startSpan(ctx, "db.list_derived_objects")
+	derived, err := loadDerivedObjectSet(s.DB)
+	if err != nil {
+		recordSpanError(derivedListSpan, err)
+		return status.Errorf(codes.Internal, "failed to list derived objects: %v", err)
+	}
+	endSpanOk(derivedListSpan)
+
+	cursor, err := loadSyncCursor(ctx, s.DB, userID)
+	if err != nil {
+		return status.Errorf(codes.Internal, "failed to get sync cursor: %v", err)
+	}
+	full := req.GetFull() || cursor == nil
+	var examined *syncObjects
+	if full {
+		examined, err = s.listAllSyncObjects(ctx, userID, derived)
+	} else {
+		examined, err = s.listChangedSyncObjects(ctx, userID, derived, *cursor)
+	}
+	if err != nil {
+		return status.Errorf(codes.Internal, "%v", err)
+	}
+	gcsObjects := examined.objects
+	sidecarObjects := splitSidecarObjects(gcsObjects)
+
+	dbObjectMap := make(map[string]database.PhotoObject, len(examined.photos))
+	for _, obj := range examined.photos {
+		dbObjectMap[obj.ObjectID] = obj
+	}
+
+	var added, removed, metadataUpdated int
+	send := func(phase proto.SyncDatabaseProgress_Phase, action proto.SyncChange_Action, objectID, reason string) error {
+		switch action {
+		case proto.SyncChange_ACTION_ADD, proto.SyncChange_ACTION_RESTORE:
+			added++
+		case proto.SyncChange_ACTION_REMOVE:
+			removed++
+		case proto.SyncChange_ACTION_UPDATE_METADATA:
+			metadataUpdated++
+		}
+		return stream.Send(&proto.SyncDatabaseProgress{
+			Phase: phase,
+			Change: &proto.SyncChange{
+				Action:   action,
+				ObjectId: objectID,
+				Reason:   reason,
+			},
+		})
+	}
+
+	// Objects in GCS but not in the database are added, or restored if they
+	// were deleted
+	for _, objectID := range sortedObjectIDs(gcsObjects) {
+		existing, exists := dbObjectMap[objectID]
+		action, reason := syncPhotoAction(gcsObjects[objectID], existing, exists)
+		if action == proto.SyncChange_ACTION_ADD {
+			var deleted database.PhotoObject
+			if err := s.DB.Unscoped().Where("object_id = ?", objectID).First(&deleted).Error; err == nil {
+				// CreateOrRestorePhotoObject takes over the row, deleted or not
+				action, reason = proto.SyncChange_ACTION_RESTORE, "recorded for another user"
+				if deleted.DeletedAt.Valid {
+					reason = fmt.Sprintf("deleted from the database at %s", deleted.DeletedAt.Time.Format(time.RFC3339))
+				}
+			}
+		}
+		if action == proto.SyncChange_ACTION_UNSPECIFIED {
+			continue
+		}
+		if err := send(proto.SyncDatabaseProgress_PHASE_ADD, action, objectID, reason); err != nil {
+			return err
+		}
+	}
+
+	// Photos whose object is not in GCS, and recorded derived assets, are
+	// removed
+	for _, photo := range sortedPhotos(dbObjectMap) {
+		reason := syncRemoveReason(photo.ObjectID, examined.exists, derived)
+		if reason == "" {
+			continue
+		}
+		if err := send(proto.SyncDatabaseProgress_PHASE_REMOVE, proto.SyncChange_ACTION_REMOVE, photo.ObjectID, reason); err != nil {
+			return err
+		}
+	}
+
+	if err := s.planSidecars(ctx, userID, sidecarObjects, examined.exists, send); err != nil {
+		return err
+	}
+
+	if req.GetUpdateMetadata() {
+		for _, objectID := range sortedObjectIDs(gcsObjects) {
+			attrs := gcsObjects[objectID]
+			photo, exists := dbObjectMap[objectID]
+			reason, err := s.planObjectMetadata(ctx, objectID, attrs, &photo, exists)
+			if err != nil {
+				slog.WarnContext(
+					ctx,
+					"failed to read metadata during sync dry run",
+					slog.String("object_id", objectID),
+					slog.String("error", err.Error()),
+				)
+			} else if reason != "" {
+				if err := send(proto.SyncDatabaseProgress_PHASE_METADATA, proto.SyncChange_ACTION_UPDATE_METADATA, objectID, reason); err != nil {
+					return err
+				}
+			}
+
+			// Photos added above have no WebP rendition yet
+			if derived.contains(objectID) {
+				continue
+			}
+			webpReason := syncWebPReason(&photo, attrs.ContentType)
+			if webpReason == "" {
+				continue
+			}
+			if err := send(proto.SyncDatabaseProgress_PHASE_METADATA, proto.SyncChange_ACTION_GENERATE_WEBP, objectID, webpReason); err != nil {
+				return err
+			}
+		}
+	}
+
+	slog.InfoContext(
+		ctx,
+		"Database sync dry run completed",
+		slog.Bool("full", full),
+		slog.Int("added", added),
+		slog.Int("removed", removed),
+		slog.Int("metadata_updated", metadataUpdated),
+		slog.Uint64("user_id", uint64(userID)),
+	)
+
+	return stream.Send(&proto.SyncDatabaseProgress{
+		Phase:           proto.SyncDatabaseProgress_PHASE_UNSPECIFIED,
+		Added:           uint32(added),
+		Removed:         uint32(removed),
+		MetadataUpdated: uint32(metadataUpdated),
+		Complete:        true,
+		Full:            full,
+	})
+}
+
+// planSidecars reports the changes syncSidecars would make to the XMP
+// sidecar records of the user.
+func (s *LibraryServer) planSidecars(
+	ctx context.Context,
+	userID uint,
+	sidecarObjects map[string]*storage.ObjectAttrs,
+	exists func(objectID string) bool,
+	send func(proto.SyncDatabaseProgress_Phase, proto.SyncChange_Action, string, string) error,
+) error {
+	var dbSidecars []database.PhotoSidecar
+	_, dbListSpan := startSpan(ctx, "db.list_photo_sidecars")
+	if err := s.DB.Where("user_id = ?", userID).Order("object_id").Find(&dbSidecars).Error; err != nil {
+		recordSpanError(dbListSpan, err)
+		return status.Errorf(codes.Internal, "failed to list photo sidecars: %v", err)
+	}
+	endSpanOk(dbListSpan)
+
+	dbSidecarMap := make(map[string]database.PhotoSidecar, len(dbSidecars))
+	for _, sidecar := range dbSidecars {
+		dbSidecarMap[sidecar.ObjectID] = sidecar
+	}
+
+	for _, objectID := range sortedObjectIDs(sidecarObjects) {
+		md5Hash := base64.StdEncoding.EncodeToString(sidecarObjects[objectID].MD5)
+		existing, found := dbSidecarMap[objectID]
+		var err error
+		switch {
+		case !found:
+			err = send(proto.SyncDatabaseProgress_PHASE_SIDECAR, proto.SyncChange_ACTION_ADD, objectID, "XMP sidecar not in the database")
+		case existing.MD5Hash != md5Hash:
+			err = send(proto.SyncDatabaseProgress_PHASE_SIDECAR, proto.SyncChange_ACTION_UPDATE_METADATA, objectID, "XMP sidecar changed")
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, sidecar := range dbSidecars {
+		if exists(sidecar.ObjectID) {
+			continue
+		}
+		if err := send(proto.SyncDatabaseProgress_PHASE_SIDECAR, proto.SyncChange_ACTION_REMOVE, sidecar.ObjectID, "XMP sidecar not in storage"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// planObjectMetadata downloads an object and describes how the metadata
+// updateObjectMetadata would record for it differs from that recorded, in
+// GCS and, if exists, in its photo. It returns "" if nothing differs.
+func (s *LibraryServer) planObjectMetadata(ctx context.Context, objectID string, attrs *storage.ObjectAttrs, photo *database.PhotoObject, exists bool) (string, error) {
+	data, err := readRenditionSource(ctx, s.GCSClient.Bucket(s.BucketName), objectID)
+	if err != nil {
+		return "", err
+	}
+	metadata := newSyncMetadata(ExtractPhotoMetadata(data, objectID))
+	if !exists {
+		photo = nil
+	}
+	return metadata.differences(attrs.Metadata, photo), nil
+}
+
+// syncPhotoAction returns the change a sync makes to the photo record of an
+// examined object, and why: ACTION_ADD if there is none, which exists
+// reports, ACTION_UPDATE_METADATA if its size is out of date and
+// ACTION_UNSPECIFIED if it is left as it is.
+func syncPhotoAction(attrs *storage.ObjectAttrs, existing database.PhotoObject, exists bool) (proto.SyncChange_Action, string) {
+	switch {
+	case !exists:
+		return proto.SyncChange_ACTION_ADD, "not in the database"
+	case existing.SizeBytes != attrs.Size:
+		// Sizes of rows created before sizes were recorded are backfilled,
+		// so that usage and quotas are accurate
+		return proto.SyncChange_ACTION_UPDATE_METADATA, fmt.Sprintf("size_bytes %d -> %d", existing.SizeBytes, attrs.Size)
+	default:
+		return proto.SyncChange_ACTION_UNSPECIFIED, ""
+	}
+}
+
+// syncRemoveReason returns why a sync removes the photo record of objectID,
+// or "" if it is kept. A record is removed once, whether its object is no
+// longer in storage, it is a recorded derived asset or both.
+func syncRemoveReason(objectID string, exists func(objectID string) bool, derived derivedObjectSet) string {
+	switch {
+	case !exists(objectID):
+		return "not in storage"
+	case derived.contains(objectID):
+		return "a derived asset"
+	default:
+		return ""
+	}
+}
+
+// syncWebPReason returns why a sync generates a WebP rendition of photo,
+// an object of contentType, or "" if it does not.
+func syncWebPReason(photo *database.PhotoObject, contentType string) string {
+	if photo.WebpObjectID != nil && *photo.WebpObjectID != "" {
+		return ""
+	}
+	switch {
+	case HasPreviewContentType(contentType):
+		return "no WebP rendition; from the JPEG preview"
+	case IsWebPConvertibleContentType(contentType):
+		return "no WebP rendition"
+	default:
+		return ""
+	}
+}
+
+// syncMetadata is the metadata a sync records for an object, in GCS and in
+// its photo, from the metadata extracted from its data.
+type syncMetadata struct {
+	gcs               map[string]string
+	timeTaken         *time.Time
+	contentIdentifier string
+	camera            string
+}
+
+// newSyncMetadata returns the metadata a sync records for an object with
+// photoMetadata.
+func newSyncMetadata(photoMetadata *PhotoMetadataInfo) syncMetadata {
+	m := syncMetadata{
+		gcs:               photoMetadata.ToGCSMetadata(),
+		contentIdentifier: photoMetadata.ContentIdentifier,
+		camera:            cameraName(photoMetadata.CameraMake, photoMetadata.CameraModel),
+	}
+	if photoMetadata.HasDateTaken {
+		m.timeTaken = &photoMetadata.DateTaken
+	}
+	return m
+}
+
+// photoColumns returns the photo columns a sync updates to m.
+func (m syncMetadata) photoColumns() map[string]any {
+	return map[string]any{
+		"time_taken":         m.timeTaken,
+		"content_identifier": m.contentIdentifier,
+		"camera":             m.camera,
+	}
+}
+
+// differences describes how m differs from the GCS metadata current and,
+// unless photo is nil, from that recorded in photo. It returns "" if
+// nothing differs.
+func (m syncMetadata) differences(current map[string]string, photo *database.PhotoObject) string {
+	var differences []string
+	if keys := staleGCSMetadataKeys(current, m.gcs); len(keys) > 0 {
+		differences = append(differences, "GCS metadata "+strings.Join(keys, ", "))
+	}
+	if photo == nil {
+		return strings.Join(differences, "; ")
+	}
+	if !sameTime(photo.TimeTaken, m.timeTaken) {
+		differences = append(differences, "time_taken")
+	}
+	if photo.ContentIdentifier != m.contentIdentifier {
+		differences = append(differences, "content_identifier")
+	}
+	if photo.Camera != m.camera {
+		differences = append(differences, "camera")
+	}
+	return strings.Join(differences, "; ")
+}
+
+// staleGCSMetadataKeys returns, in order, the keys of metadata whose entries
+// the GCS metadata current is missing or has other values for.
+func staleGCSMetadataKeys(current, metadata map[string]string) []string {
+	var keys []string
+	for key, value := range metadata {
+		if existing, ok := current[key]; !ok || existing != value {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sameTime reports whether two optional times are the same instant.
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.Equal(*b)
+}
+
+// sortedObjectIDs returns the keys of objects in order.
+func sortedObjectIDs(objects map[string]*storage.ObjectAttrs) []string {
+	objectIDs := make([]string, 0, len(objects))
+	for objectID := range objects {
+		objectIDs = append(objectIDs, objectID)
+	}
+	sort.Strings(objectIDs)
+	return objectIDs
+}
+
+// sortedPhotos returns the photos in order of object ID.
+func sortedPhotos(photos map[string]database.PhotoObject) []database.PhotoObject {
+	sorted := make([]database.PhotoObject, 0, len(photos))
+	for _, photo := range photos {
+		sorted = append(sorted, photo)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].ObjectID < sorted[j].ObjectID
+	})
+	return sorted
+}
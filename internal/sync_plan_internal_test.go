@@ -0,0 +1,157 @@
+package internal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alexhokl/photos/database"
+	"github.com/alexhokl/photos/proto"
+)
+
+func TestSyncDatabase_DryRunChangesNothing(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	server := &LibraryServer{DB: db}
+
+	photos := []database.PhotoObject{
+		{ObjectID: "2024/b.jpg", ContentType: "image/jpeg", MD5Hash: "x", UserID: 1},
+		{ObjectID: "2024/a.jpg", ContentType: "image/jpeg", MD5Hash: "y", UserID: 1},
+	}
+	if err := db.Create(&photos).Error; err != nil {
+		t.Fatalf("failed to create photos: %v", err)
+	}
+	if err := db.Create(&database.PhotoSidecar{ObjectID: "2024/a.xmp", PhotoObjectID: "2024/a.jpg", MD5Hash: "z", UserID: 1}).Error; err != nil {
+		t.Fatalf("failed to create sidecar: %v", err)
+	}
+	// A sync running elsewhere does not hold up a dry run
+	now := time.Now()
+	if err := db.Create(&database.OperationLock{
+		UserID:         1,
+		Operation:      OperationSyncDatabase,
+		Token:          "other",
+		Holder:         "other-host/42",
+		HeartbeatAt:    now,
+		LeaseExpiresAt: now.Add(operationLeaseDuration),
+	}).Error; err != nil {
+		t.Fatalf("failed to create lock: %v", err)
+	}
+
+	stream := newMockSyncDatabaseStream(contextWithUserID(1))
+	if err := server.SyncDatabase(&proto.SyncDatabaseRequest{DryRun: true}, stream); err != nil {
+		t.Fatalf("SyncDatabase() error = %v", err)
+	}
+
+	// Without storage every photo and sidecar would be removed
+	want := []struct {
+		phase    proto.SyncDatabaseProgress_Phase
+		objectID string
+	}{
+		{proto.SyncDatabaseProgress_PHASE_REMOVE, "2024/a.jpg"},
+		{proto.SyncDatabaseProgress_PHASE_REMOVE, "2024/b.jpg"},
+		{proto.SyncDatabaseProgress_PHASE_SIDECAR, "2024/a.xmp"},
+	}
+	if len(stream.sent) != len(want)+1 {
+		t.Fatalf("got %d messages, want %d changes and a summary", len(stream.sent), len(want))
+	}
+	for i, w := range want {
+		msg := stream.sent[i]
+		change := msg.GetChange()
+		if msg.GetPhase() != w.phase || change.GetAction() != proto.SyncChange_ACTION_REMOVE || change.GetObjectId() != w.objectID {
+			t.Errorf("message %d = %v, want removal of %s in %v", i, msg, w.objectID, w.phase)
+		}
+		if change.GetReason() == "" {
+			t.Errorf("message %d has no reason", i)
+		}
+	}
+	summary := stream.sent[len(stream.sent)-1]
+	if !summary.GetComplete() || summary.GetRemoved() != 3 || summary.GetAdded() != 0 || !summary.GetFull() {
+		t.Errorf("summary = %v, want 3 removed in a full sync", summary)
+	}
+
+	var count int64
+	db.Model(&database.PhotoObject{}).Count(&count)
+	if count != 2 {
+		t.Errorf("got %d photos after dry run, want 2", count)
+	}
+	db.Model(&database.PhotoSidecar{}).Count(&count)
+	if count != 1 {
+		t.Errorf("got %d sidecars after dry run, want 1", count)
+	}
+	if cursor, _ := loadSyncCursor(context.Background(), db, 1); cursor != nil {
+		t.Errorf("dry run saved a cursor: %+v", *cursor)
+	}
+}
+
+func TestSameTime(t *testing.T) {
+	a := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	b := a.In(time.FixedZone("HKT", 8*60*60))
+	c := a.Add(time.Second)
+	tests := []struct {
+		name string
+		x, y *time.Time
+		want bool
+	}{
+		{"both nil", nil, nil, true},
+		{"one nil", &a, nil, false},
+		{"same instant", &a, &b, true},
+		{"different", &a, &c, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sameTime(tt.x, tt.y); got != tt.want {
+				t.Errorf("sameTime() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSyncRemoveReason(t *testing.T) {
+	inStorage := map[string]bool{"2024/a.jpg": true, "2024/a.webp": true}
+	exists := func(objectID string) bool { return inStorage[objectID] }
+	derived := derivedObjectSet{"2024/a.webp": {}, "2024/gone.webp": {}}
+	tests := []struct {
+		objectID string
+		want     string
+	}{
+		{"2024/a.jpg", ""},
+		{"2024/gone.jpg", "not in storage"},
+		{"2024/a.webp", "a derived asset"},
+		// Removed once, not once for each reason
+		{"2024/gone.webp", "not in storage"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.objectID, func(t *testing.T) {
+			if got := syncRemoveReason(tt.objectID, exists, derived); got != tt.want {
+				t.Errorf("syncRemoveReason(%q) = %q, want %q", tt.objectID, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSyncMetadataDifferences(t *testing.T) {
+	taken := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	m := syncMetadata{
+		gcs:       map[string]string{"b": "2", "a": "1"},
+		timeTaken: &taken,
+		camera:    "Canon EOS R5",
+	}
+	photo := &database.PhotoObject{TimeTaken: &taken, Camera: "Canon EOS R5"}
+	tests := []struct {
+		name    string
+		current map[string]string
+		photo   *database.PhotoObject
+		want    string
+	}{
+		{"up to date", map[string]string{"a": "1", "b": "2", "c": "3"}, photo, ""},
+		{"stale GCS metadata", map[string]string{"a": "0"}, photo, "GCS metadata a, b"},
+		{"no photo", map[string]string{"a": "1", "b": "2"}, nil, ""},
+		{"stale photo", map[string]string{"a": "1", "b": "2"}, &database.PhotoObject{ContentIdentifier: "x"}, "time_taken; content_identifier; camera"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := m.differences(tt.current, tt.photo); got != tt.want {
+				t.Errorf("differences() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,731 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/alexhokl/photos/database"
+	"github.com/alexhokl/photos/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"gorm.io/gorm"
+)
+
+const (
+	// proxyShortEdge is the largest short edge, in pixels, of the MP4 proxy
+	proxyShortEdge = 1080
+	// maxHLSHeight is the largest HLS rung accepted (8K)
+	maxHLSHeight = 4320
+	// hlsSegmentSeconds is the target duration of an HLS segment
+	hlsSegmentSeconds = 6
+	// hlsPlaylistName is the name of the HLS master playlist
+	hlsPlaylistName = "index.m3u8"
+	// transcodeTimeout bounds a single transcode, including every HLS rung
+	transcodeTimeout = 2 * time.Hour
+)
+
+// ValidateHLSHeights checks that every HLS rung is a positive, even number
+// of pixels (as H.264 requires) no larger than 4320.
+func ValidateHLSHeights(heights []int) error {
+	for _, height := range heights {
+		if height < 2 || height > maxHLSHeight || height%2 != 0 {
+			return fmt.Errorf("invalid HLS height %d (must be an even number between 2 and %d)", height, maxHLSHeight)
+		}
+	}
+	return nil
+}
+
+// proxyObjectID returns the GCS object ID of the MP4 proxy of a video.
+// "_proxy.mp4" is appended to the object ID, extension included.
+// Example:
+//
+//	"dir1/dir2/clip.mov" → "dir1/dir2/clip.mov_proxy.mp4"
+func proxyObjectID(objectID string) string {
+	return objectID + "_proxy.mp4"
+}
+
+// hlsObjectPrefix returns the GCS prefix the HLS playlists and segments of a
+// video are stored under.
+// Example:
+//
+//	"dir1/dir2/clip.mov" → "dir1/dir2/clip.mov_hls/"
+func hlsObjectPrefix(objectID string) string {
+	return objectID + "_hls/"
+}
+
+// hlsObjectID returns the GCS object ID of the HLS master playlist of a
+// video.
+// Example:
+//
+//	"dir1/dir2/clip.mov" → "dir1/dir2/clip.mov_hls/index.m3u8"
+func hlsObjectID(objectID string) string {
+	return hlsObjectPrefix(objectID) + hlsPlaylistName
+}
+
+// hlsContentType returns the content type of a file in an HLS ladder.
+func hlsContentType(name string) string {
+	if strings.HasSuffix(name, ".m3u8") {
+		return "application/vnd.apple.mpegurl"
+	}
+	return "video/mp2t"
+}
+
+// hlsLadder returns the HLS rungs, in ascending order, to transcode a video
+// whose short edge is shortEdge pixels to. Rungs larger than the video are
+// dropped as upscaling only wastes space; if every rung is larger the video
+// is transcoded at its own size instead. A shortEdge of 0 (unknown) keeps
+// every rung.
+func hlsLadder(heights []int, shortEdge int) []int {
+	if len(heights) == 0 {
+		return nil
+	}
+	ladder := make([]int, 0, len(heights))
+	for _, height := range heights {
+		if shortEdge > 0 && height > shortEdge {
+			continue
+		}
+		if !slices.Contains(ladder, height) {
+			ladder = append(ladder, height)
+		}
+	}
+	if len(ladder) == 0 {
+		ladder = append(ladder, shortEdge&^1)
+	}
+	slices.Sort(ladder)
+	return ladder
+}
+
+// hlsMaxrateKbps returns the peak bitrate, in kbit/s, of an HLS rung with
+// the given short edge: about 5 Mbit/s at 1080p and 2.5 Mbit/s at 720p.
+func hlsMaxrateKbps(height int) int {
+	return max(height*height/200, 200)
+}
+
+// shortEdgeScaleFilter returns an ffmpeg filter scaling a video down so that
+// its short edge is at most limit pixels, keeping the aspect ratio and an
+// even long edge. Videos already small enough are left alone.
+func shortEdgeScaleFilter(limit int) string {
+	return fmt.Sprintf("scale=w='if(gt(iw,ih),-2,min(%d,iw))':h='if(gt(iw,ih),min(%d,ih),-2)'", limit, limit)
+}
+
+// h264Args returns the ffmpeg output options encoding the first video and
+// (if any) audio stream to H.264/AAC, which every browser and phone plays.
+func h264Args(shortEdge int) []string {
+	return []string{
+		"-map", "0:v:0",
+		"-map", "0:a:0?",
+		"-vf", shortEdgeScaleFilter(shortEdge),
+		"-c:v", "libx264",
+		"-preset", "veryfast",
+		"-crf", "23",
+		"-pix_fmt", "yuv420p",
+		"-c:a", "aac",
+		"-b:a", "128k",
+		"-ac", "2",
+	}
+}
+
+// proxyArgs returns the ffmpeg arguments transcoding input to an MP4 proxy
+// at output, with the index up front so that it plays while downloading.
+func proxyArgs(input, output string) []string {
+	args := append([]string{"-i", input}, h264Args(proxyShortEdge)...)
+	return append(args, "-movflags", "+faststart", output)
+}
+
+// hlsArgs returns the ffmpeg arguments transcoding input to the HLS rung
+// name of the given short edge in dir. Key frames are forced at segment
+// boundaries so that players can switch between rungs.
+func hlsArgs(input, dir, name string, height int) []string {
+	maxrate := hlsMaxrateKbps(height)
+	args := append([]string{"-i", input}, h264Args(height)...)
+	return append(args,
+		"-maxrate", fmt.Sprintf("%dk", maxrate),
+		"-bufsize", fmt.Sprintf("%dk", 2*maxrate),
+		"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%d)", hlsSegmentSeconds),
+		"-f", "hls",
+		"-hls_time", strconv.Itoa(hlsSegmentSeconds),
+		"-hls_playlist_type", "vod",
+		"-hls_segment_filename", filepath.Join(dir, name+"_%03d.ts"),
+		filepath.Join(dir, name+".m3u8"),
+	)
+}
+
+// parseFFmpegProgress returns the position, in seconds, reported by a line
+// of ffmpeg's -progress output. out_time_ms is in microseconds too, despite
+// its name.
+func parseFFmpegProgress(line string) (float64, bool) {
+	key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+	if !ok || (key != "out_time_us" && key != "out_time_ms") {
+		return 0, false
+	}
+	microseconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || microseconds < 0 {
+		return 0, false
+	}
+	return float64(microseconds) / 1e6, true
+}
+
+// runFFmpeg runs ffmpeg with args, reporting the fraction (0-1) of a video
+// of durationSeconds encoded so far to progress, which may be nil. Without
+// a duration progress is only reported on completion.
+func runFFmpeg(ctx context.Context, args []string, durationSeconds float64, progress func(fraction float64)) error {
+	args = append([]string{"-hide_banner", "-nostdin", "-y", "-loglevel", "error", "-nostats", "-progress", "pipe:1"}, args...)
+	cmd := exec.CommandContext(ctx, ToolFFmpeg, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to read ffmpeg progress: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		seconds, ok := parseFFmpegProgress(scanner.Text())
+		if ok && durationSeconds > 0 && progress != nil {
+			progress(min(seconds/durationSeconds, 1))
+		}
+	}
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("ffmpeg failed: %w, stderr: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if progress != nil {
+		progress(1)
+	}
+	return nil
+}
+
+// hlsVariant is a rung of an HLS ladder as listed in the master playlist.
+type hlsVariant struct {
+	// Playlist is the name of the media playlist of the rung
+	Playlist string
+	// Bandwidth is the average bitrate of the rung in bit/s
+	Bandwidth int64
+}
+
+// hlsPlaylistDuration returns the total duration, in seconds, of the
+// segments listed in a media playlist.
+func hlsPlaylistDuration(playlist []byte) float64 {
+	var total float64
+	for line := range strings.Lines(string(playlist)) {
+		value, ok := strings.CutPrefix(strings.TrimSpace(line), "#EXTINF:")
+		if !ok {
+			continue
+		}
+		value, _, _ = strings.Cut(value, ",")
+		if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+			total += seconds
+		}
+	}
+	return total
+}
+
+// hlsMasterPlaylist returns the master playlist listing variants.
+func hlsMasterPlaylist(variants []hlsVariant) []byte {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	for _, variant := range variants {
+		fmt.Fprintf(&b, "#EXT-X-STREAM-INF:BANDWIDTH=%d\n%s\n", variant.Bandwidth, variant.Playlist)
+	}
+	return []byte(b.String())
+}
+
+// readHLSVariant returns the HLS rung name transcoded to dir, with its
+// bandwidth measured from the size of its segments.
+func readHLSVariant(dir, name string, height int) (hlsVariant, error) {
+	variant := hlsVariant{
+		Playlist:  name + ".m3u8",
+		Bandwidth: int64(hlsMaxrateKbps(height)) * 1000,
+	}
+	playlist, err := os.ReadFile(filepath.Join(dir, variant.Playlist))
+	if err != nil {
+		return variant, fmt.Errorf("failed to read HLS playlist: %w", err)
+	}
+	duration := hlsPlaylistDuration(playlist)
+	if duration <= 0 {
+		return variant, nil
+	}
+
+	segments, err := filepath.Glob(filepath.Join(dir, name+"_*.ts"))
+	if err != nil {
+		return variant, fmt.Errorf("failed to list HLS segments: %w", err)
+	}
+	var size int64
+	for _, segment := range segments {
+		info, err := os.Stat(segment)
+		if err != nil {
+			return variant, fmt.Errorf("failed to stat HLS segment: %w", err)
+		}
+		size += info.Size()
+	}
+	if size > 0 {
+		variant.Bandwidth = int64(float64(size*8) / duration)
+	}
+	return variant, nil
+}
+
+// Transcoder converts videos to an H.264/AAC MP4 proxy and, if HLSHeights
+// is set, an HLS ladder, both of which are recorded as derived assets of the
+// video. Phones record HEVC in QuickTime containers that many browsers
+// cannot play; the proxy plays everywhere. Transcodes run one at a time as
+// they are CPU bound.
+type Transcoder struct {
+	DB         *gorm.DB
+	GCSClient  *storage.Client
+	BucketName string
+	// HLSHeights holds the short edges, in pixels, of the HLS rungs. HLS is
+	// not generated if empty.
+	HLSHeights []int
+	// Capabilities holds the external tools found at startup
+	Capabilities *Capabilities
+	// Jobs queues background transcodes of uploaded videos; nil leaves them
+	// for TranscodeVideo
+	Jobs *JobQueue
+
+	mu sync.Mutex
+}
+
+// NewTranscoder returns a Transcoder storing its outputs in bucketName.
+func NewTranscoder(db *gorm.DB, gcsClient *storage.Client, bucketName string, hlsHeights []int, caps *Capabilities) *Transcoder {
+	return &Transcoder{
+		DB:           db,
+		GCSClient:    gcsClient,
+		BucketName:   bucketName,
+		HLSHeights:   hlsHeights,
+		Capabilities: caps,
+	}
+}
+
+// Enqueue queues a background transcode of the video objectID. Without a
+// job queue, or if queueing fails, the video is left for TranscodeVideo to
+// pick up.
+func (t *Transcoder) Enqueue(ctx context.Context, userID uint, objectID string) {
+	if t.Jobs == nil {
+		return
+	}
+	if err := t.Jobs.Enqueue(ctx, userID, database.JobKindTranscode, objectID); err != nil {
+		slog.WarnContext(ctx, "failed to queue transcode; video left for backfill",
+			slog.String("object_id", objectID),
+			slog.String("error", err.Error()),
+		)
+	}
+}
+
+// RunJob is the JobHandler of JobKindTranscode jobs. Videos deleted since
+// the job was queued are skipped. While another transcode is running the job
+// is deferred rather than left holding a worker of the shared queue.
+func (t *Transcoder) RunJob(ctx context.Context, job *database.Job) error {
+	if !t.mu.TryLock() {
+		return errJobBusy
+	}
+	defer t.mu.Unlock()
+
+	var photoObject database.PhotoObject
+	err := t.DB.Where("object_id = ? AND user_id = ?", job.ObjectID, job.UserID).First(&photoObject).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to find video to transcode: %w", err)
+	}
+	return t.transcode(ctx, &photoObject, nil)
+}
+
+// needsTranscode reports whether photoObject lacks its proxy or, if HLS is
+// enabled, its HLS ladder.
+func (t *Transcoder) needsTranscode(photoObject *database.PhotoObject) bool {
+	if photoObject.ProxyObjectID == nil || *photoObject.ProxyObjectID == "" {
+		return true
+	}
+	return len(t.HLSHeights) > 0 && (photoObject.HLSObjectID == nil || *photoObject.HLSObjectID == "")
+}
+
+// Transcode generates the MP4 proxy and HLS ladder of the video
+// photoObject, uploads them, records them as derived assets and references
+// them from photoObject. The percentage (0-100) of the work done so far is
+// reported to progress, which may be nil.
+func (t *Transcoder) Transcode(ctx context.Context, photoObject *database.PhotoObject, progress func(percent float64)) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.transcode(ctx, photoObject, progress)
+}
+
+// transcode is Transcode with t.mu held.
+func (t *Transcoder) transcode(ctx context.Context, photoObject *database.PhotoObject, progress func(percent float64)) error {
+	if !t.Capabilities.Has(ToolFFmpeg) {
+		return fmt.Errorf("cannot transcode video: %w", &exec.Error{Name: ToolFFmpeg, Err: exec.ErrNotFound})
+	}
+	if t.GCSClient == nil {
+		return fmt.Errorf("no storage bucket available for transcoding")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, transcodeTimeout)
+	defer cancel()
+
+	objectID := photoObject.ObjectID
+	bucket := t.GCSClient.Bucket(t.BucketName)
+
+	tmpDir, err := os.MkdirTemp("", "transcode-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	inputPath := filepath.Join(tmpDir, "input"+path.Ext(objectID))
+	attrs, err := downloadObjectToFile(ctx, bucket, objectID, inputPath)
+	if err != nil {
+		return err
+	}
+
+	info := ParseVideoGCSMetadata(attrs.Metadata)
+	if t.Capabilities.Has(ToolFFprobe) {
+		// GCS metadata is used if ffprobe fails
+		_ = probeVideoFile(inputPath, info)
+	}
+	shortEdge := 0
+	if info.HasDimensions {
+		shortEdge = min(info.Width, info.Height)
+	}
+	ladder := hlsLadder(t.HLSHeights, shortEdge)
+
+	steps := float64(1 + len(ladder))
+	step := 0
+	report := func(fraction float64) {
+		if progress != nil {
+			progress(100 * (float64(step) + fraction) / steps)
+		}
+	}
+
+	proxyPath := filepath.Join(tmpDir, "proxy.mp4")
+	if err := runFFmpeg(ctx, proxyArgs(inputPath, proxyPath), info.DurationSeconds, report); err != nil {
+		return err
+	}
+	proxyID := proxyObjectID(objectID)
+	if err := t.storeDerivedFile(ctx, bucket, photoObject, database.DerivedKindProxy, proxyID, proxyPath, "video/mp4"); err != nil {
+		return err
+	}
+	if err := t.updateColumn(ctx, photoObject, "proxy_object_id", proxyID); err != nil {
+		return err
+	}
+	photoObject.ProxyObjectID = &proxyID
+	step++
+
+	if len(ladder) == 0 {
+		return nil
+	}
+
+	hlsDir := filepath.Join(tmpDir, "hls")
+	if err := os.Mkdir(hlsDir, 0o700); err != nil {
+		return fmt.Errorf("failed to create HLS dir: %w", err)
+	}
+	variants := make([]hlsVariant, 0, len(ladder))
+	for _, height := range ladder {
+		name := fmt.Sprintf("%dp", height)
+		if err := runFFmpeg(ctx, hlsArgs(inputPath, hlsDir, name, height), info.DurationSeconds, report); err != nil {
+			return err
+		}
+		variant, err := readHLSVariant(hlsDir, name, height)
+		if err != nil {
+			return err
+		}
+		variants = append(variants, variant)
+		step++
+	}
+	if err := os.WriteFile(filepath.Join(hlsDir, hlsPlaylistName), hlsMasterPlaylist(variants), 0o600); err != nil {
+		return fmt.Errorf("failed to write HLS playlist: %w", err)
+	}
+
+	entries, err := os.ReadDir(hlsDir)
+	if err != nil {
+		return fmt.Errorf("failed to list HLS files: %w", err)
+	}
+	// rungs are named after their height, so the master playlist sorts last
+	// and never lists a rung that has not been uploaded yet
+	prefix := hlsObjectPrefix(objectID)
+	uploaded := make(map[string]struct{}, len(entries))
+	for _, entry := range entries {
+		id := prefix + entry.Name()
+		if err := t.storeDerivedFile(ctx, bucket, photoObject, database.DerivedKindHLS, id, filepath.Join(hlsDir, entry.Name()), hlsContentType(entry.Name())); err != nil {
+			return err
+		}
+		uploaded[id] = struct{}{}
+	}
+	t.deleteStaleHLSObjects(ctx, bucket, photoObject, uploaded)
+
+	hlsID := hlsObjectID(objectID)
+	if err := t.updateColumn(ctx, photoObject, "hls_object_id", hlsID); err != nil {
+		return err
+	}
+	photoObject.HLSObjectID = &hlsID
+	return nil
+}
+
+// downloadObjectToFile downloads objectID to filePath without holding it in
+// memory, as videos can be large.
+func downloadObjectToFile(ctx context.Context, bucket *storage.BucketHandle, objectID, filePath string) (*storage.ObjectAttrs, error) {
+	_, readSpan := startSpan(ctx, "gcs.read_object")
+	reader, err := bucket.Object(objectID).NewReader(ctx)
+	if err != nil {
+		recordSpanError(readSpan, err)
+		return nil, fmt.Errorf("failed to open video: %w", err)
+	}
+	defer func() { _ = reader.Close() }()
+
+	file, err := os.Create(filePath)
+	if err != nil {
+		recordSpanError(readSpan, err)
+		return nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	if _, err := io.Copy(file, reader); err != nil {
+		_ = file.Close()
+		recordSpanError(readSpan, err)
+		return nil, fmt.Errorf("failed to download video: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		recordSpanError(readSpan, err)
+		return nil, fmt.Errorf("failed to close temp file: %w", err)
+	}
+	endSpanOk(readSpan)
+
+	attrs, err := bucket.Object(objectID).Attrs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read video attributes: %w", err)
+	}
+	return attrs, nil
+}
+
+// storeDerivedFile uploads the file at filePath as the derived asset
+// objectID of photoObject and records it.
+func (t *Transcoder) storeDerivedFile(ctx context.Context, bucket *storage.BucketHandle, photoObject *database.PhotoObject, kind, objectID, filePath, contentType string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", filepath.Base(filePath), err)
+	}
+	defer func() { _ = file.Close() }()
+
+	_, writeSpan := startSpan(ctx, "gcs.write_object")
+	writer, err := newDerivedObjectWriter(ctx, bucket.Object(objectID), kind, photoObject.ObjectID)
+	if err != nil {
+		recordSpanError(writeSpan, err)
+		return err
+	}
+	writer.ContentType = contentType
+	if _, err := io.Copy(writer, file); err != nil {
+		_ = writer.Close()
+		recordSpanError(writeSpan, err)
+		return fmt.Errorf("failed to write %s: %w", objectID, err)
+	}
+	if err := writer.Close(); err != nil {
+		recordSpanError(writeSpan, err)
+		return fmt.Errorf("failed to close writer of %s: %w", objectID, err)
+	}
+	endSpanOk(writeSpan)
+
+	recordDerivedObject(ctx, t.DB, photoObject.UserID, kind, photoObject.ObjectID, objectID, writer.Attrs().Size)
+	return nil
+}
+
+// updateColumn sets column of photoObject to objectID.
+func (t *Transcoder) updateColumn(ctx context.Context, photoObject *database.PhotoObject, column, objectID string) error {
+	_, dbSpan := startSpan(ctx, "db.update_"+column)
+	if err := t.DB.Model(&database.PhotoObject{}).
+		Where("object_id = ? AND user_id = ?", photoObject.ObjectID, photoObject.UserID).
+		Update(column, objectID).Error; err != nil {
+		recordSpanError(dbSpan, err)
+		return fmt.Errorf("failed to update %s: %w", column, err)
+	}
+	endSpanOk(dbSpan)
+	return nil
+}
+
+// deleteStaleHLSObjects removes the HLS files of photoObject left over from
+// an earlier transcode with a different ladder. Errors are logged but not
+// fatal.
+func (t *Transcoder) deleteStaleHLSObjects(ctx context.Context, bucket *storage.BucketHandle, photoObject *database.PhotoObject, current map[string]struct{}) {
+	var derived []database.DerivedObject
+	if err := t.DB.Where("source_object_id = ? AND user_id = ? AND kind = ?", photoObject.ObjectID, photoObject.UserID, database.DerivedKindHLS).
+		Find(&derived).Error; err != nil {
+		slog.WarnContext(ctx, "failed to list HLS objects",
+			slog.String("object_id", photoObject.ObjectID),
+			slog.String("error", err.Error()),
+		)
+		return
+	}
+	for _, d := range derived {
+		if _, ok := current[d.ObjectID]; !ok {
+			deleteDerivedObject(ctx, t.DB, bucket, d.ObjectID)
+		}
+	}
+}
+
+// TranscodeVideo transcodes the videos of the authenticated user to an MP4
+// proxy and, if enabled on the server, an HLS ladder. With an object_id only
+// that video is transcoded; otherwise every video lacking its proxy or HLS
+// ladder is. force transcodes videos again even if they are complete. It
+// fails with FailedPrecondition if ffmpeg is not installed.
+//
+// Progress is streamed while a video is being transcoded (percent), after
+// each video, and in a final summary message with complete=true.
+// Per-object failures are logged and counted as failed; they do not abort
+// the run.
+func (s *LibraryServer) TranscodeVideo(req *proto.TranscodeVideoRequest, stream grpc.ServerStreamingServer[proto.TranscodeVideoProgress]) error {
+	ctx := stream.Context()
+	userID, ok := ctx.Value(contextKeyUser{}).(uint)
+	if !ok {
+		return status.Errorf(codes.Unauthenticated, "authentication required")
+	}
+	if s.Transcoder == nil || !s.Capabilities.Has(ToolFFmpeg) {
+		return status.Errorf(codes.FailedPrecondition, "%s is not installed on this server", ToolFFmpeg)
+	}
+
+	var videos []database.PhotoObject
+	if objectID := req.GetObjectId(); objectID != "" {
+		var photoObject database.PhotoObject
+		_, dbSpan := startSpan(ctx, "db.get_photo_object")
+		if err := s.DB.Where("object_id = ? AND user_id = ?", objectID, userID).First(&photoObject).Error; err != nil {
+			recordSpanError(dbSpan, err)
+			if err == gorm.ErrRecordNotFound {
+				return status.Errorf(codes.NotFound, "photo not found: %s", objectID)
+			}
+			return status.Errorf(codes.Internal, "failed to get photo: %v", err)
+		}
+		endSpanOk(dbSpan)
+		if !IsVideoContentType(photoObject.ContentType) {
+			return status.Errorf(codes.InvalidArgument, "%s is not a video", objectID)
+		}
+		videos = append(videos, photoObject)
+	} else {
+		_, derivedListSpan := startSpan(ctx, "db.list_derived_objects")
+		derived, err := loadDerivedObjectSet(s.DB)
+		if err != nil {
+			recordSpanError(derivedListSpan, err)
+			return status.Errorf(codes.Internal, "failed to list derived objects: %v", err)
+		}
+		endSpanOk(derivedListSpan)
+
+		var databaseVideos []database.PhotoObject
+		_, dbListSpan := startSpan(ctx, "db.list_photo_objects")
+		if err := s.DB.Where("user_id = ? AND content_type LIKE ?", userID, "video/%").
+			Find(&databaseVideos).Error; err != nil {
+			recordSpanError(dbListSpan, err)
+			return status.Errorf(codes.Internal, "failed to list database objects: %v", err)
+		}
+		endSpanOk(dbListSpan)
+
+		for _, obj := range databaseVideos {
+			if !derived.contains(obj.ObjectID) {
+				videos = append(videos, obj)
+			}
+		}
+		slices.SortFunc(videos, func(a, b database.PhotoObject) int {
+			return cmp.Compare(a.ObjectID, b.ObjectID)
+		})
+	}
+
+	pause := time.Duration(req.GetPauseBetweenObjectsSeconds()) * time.Second
+	total := uint32(len(videos))
+
+	slog.InfoContext(
+		ctx,
+		"Starting video transcoding",
+		slog.Int("videos", len(videos)),
+		slog.Bool("force", req.GetForce()),
+		slog.Uint64("user_id", uint64(userID)),
+	)
+
+	var transcoded, skipped, failed int
+	for i := range videos {
+		video := &videos[i]
+		result := webpStatusSkipped
+		if req.GetForce() || s.Transcoder.needsTranscode(video) {
+			lastPercent := -1
+			err := s.Transcoder.Transcode(ctx, video, func(percent float64) {
+				// one message per whole percent is plenty for a progress bar
+				if int(percent) <= lastPercent {
+					return
+				}
+				lastPercent = int(percent)
+				_ = stream.Send(&proto.TranscodeVideoProgress{
+					ObjectId:   video.ObjectID,
+					Percent:    percent,
+					Processed:  uint32(i),
+					Total:      total,
+					Transcoded: uint32(transcoded),
+					Skipped:    uint32(skipped),
+					Failed:     uint32(failed),
+				})
+			})
+			if err != nil {
+				slog.WarnContext(
+					ctx,
+					"failed to transcode video",
+					slog.String("object_id", video.ObjectID),
+					slog.String("error", err.Error()),
+				)
+				result = webpStatusFailed
+			} else {
+				result = webpStatusGenerated
+			}
+		}
+		switch result {
+		case webpStatusGenerated:
+			transcoded++
+		case webpStatusSkipped:
+			skipped++
+		case webpStatusFailed:
+			failed++
+		}
+
+		if pause > 0 && result == webpStatusGenerated {
+			time.Sleep(pause)
+		}
+
+		if err := stream.Send(&proto.TranscodeVideoProgress{
+			ObjectId:   video.ObjectID,
+			Percent:    100,
+			Processed:  uint32(i + 1),
+			Total:      total,
+			Transcoded: uint32(transcoded),
+			Skipped:    uint32(skipped),
+			Failed:     uint32(failed),
+		}); err != nil {
+			return err
+		}
+	}
+
+	slog.InfoContext(
+		ctx,
+		"Video transcoding pass completed",
+		slog.Int("transcoded", transcoded),
+		slog.Int("skipped", skipped),
+		slog.Int("failed", failed),
+		slog.Uint64("user_id", uint64(userID)),
+	)
+
+	return stream.Send(&proto.TranscodeVideoProgress{
+		Processed:  total,
+		Total:      total,
+		Transcoded: uint32(transcoded),
+		Skipped:    uint32(skipped),
+		Failed:     uint32(failed),
+		Complete:   true,
+	})
+}
@@ -0,0 +1,309 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/alexhokl/photos/database"
+	"github.com/alexhokl/photos/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+func TestProxyAndHLSObjectID(t *testing.T) {
+	tests := []struct {
+		objectID      string
+		expectedProxy string
+		expectedHLS   string
+	}{
+		{"dir1/dir2/clip.mov", "dir1/dir2/clip.mov_proxy.mp4", "dir1/dir2/clip.mov_hls/index.m3u8"},
+		{"clip.mp4", "clip.mp4_proxy.mp4", "clip.mp4_hls/index.m3u8"},
+		{"noext", "noext_proxy.mp4", "noext_hls/index.m3u8"},
+	}
+	for _, test := range tests {
+		if got := proxyObjectID(test.objectID); got != test.expectedProxy {
+			t.Errorf("proxyObjectID(%q) = %q, want %q", test.objectID, got, test.expectedProxy)
+		}
+		if got := hlsObjectID(test.objectID); got != test.expectedHLS {
+			t.Errorf("hlsObjectID(%q) = %q, want %q", test.objectID, got, test.expectedHLS)
+		}
+		if got := derivedObjectID(database.DerivedKindProxy, test.objectID); got != test.expectedProxy {
+			t.Errorf("derivedObjectID(proxy, %q) = %q, want %q", test.objectID, got, test.expectedProxy)
+		}
+		if got := derivedObjectID(database.DerivedKindHLS, test.objectID); got != test.expectedHLS {
+			t.Errorf("derivedObjectID(hls, %q) = %q, want %q", test.objectID, got, test.expectedHLS)
+		}
+	}
+}
+
+func TestDerivedObjectColumn(t *testing.T) {
+	tests := []struct {
+		kind     string
+		objectID string
+		expected string
+	}{
+		{database.DerivedKindWebP, "a/b.webp", "webp_object_id"},
+		{database.DerivedKindAVIF, "a/b.avif", "avif_object_id"},
+		{database.DerivedKindProxy, "a/b_proxy.mp4", "proxy_object_id"},
+		{database.DerivedKindHLS, "a/b_hls/index.m3u8", "hls_object_id"},
+		{database.DerivedKindHLS, "a/b_hls/720p.m3u8", ""},
+		{database.DerivedKindHLS, "a/b_hls/720p_000.ts", ""},
+		{database.DerivedKindPreview, "a/b_preview.jpg", "thumbnail_object_id"},
+		{database.DerivedKindThumbnail, "a/b_thumb.jpg", "thumbnail_object_id"},
+	}
+	for _, test := range tests {
+		if got := derivedObjectColumn(test.kind, test.objectID); got != test.expected {
+			t.Errorf("derivedObjectColumn(%q, %q) = %q, want %q", test.kind, test.objectID, got, test.expected)
+		}
+	}
+}
+
+func TestMovedDerivedObjectID_HLS(t *testing.T) {
+	got := movedDerivedObjectID(database.DerivedKindHLS, "old/clip.mov_hls/720p_001.ts", "old/clip.mov", "new/trip.mov")
+	if got != "new/trip.mov_hls/720p_001.ts" {
+		t.Errorf("movedDerivedObjectID = %q, want %q", got, "new/trip.mov_hls/720p_001.ts")
+	}
+}
+
+func TestValidateHLSHeights(t *testing.T) {
+	if err := ValidateHLSHeights([]int{360, 720, 1080}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	for _, heights := range [][]int{{0}, {-360}, {721}, {4322}} {
+		if err := ValidateHLSHeights(heights); err == nil {
+			t.Errorf("ValidateHLSHeights(%v): expected error, got nil", heights)
+		}
+	}
+}
+
+func TestHLSLadder(t *testing.T) {
+	tests := []struct {
+		name      string
+		heights   []int
+		shortEdge int
+		expected  []int
+	}{
+		{"disabled", nil, 1080, nil},
+		{"sorted and deduplicated", []int{1080, 360, 720, 360}, 2160, []int{360, 720, 1080}},
+		{"drops rungs larger than the video", []int{360, 720, 1080}, 720, []int{360, 720}},
+		{"video smaller than every rung", []int{720, 1080}, 481, []int{480}},
+		{"unknown size keeps every rung", []int{720, 360}, 0, []int{360, 720}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := hlsLadder(test.heights, test.shortEdge); !slices.Equal(got, test.expected) {
+				t.Errorf("hlsLadder(%v, %d) = %v, want %v", test.heights, test.shortEdge, got, test.expected)
+			}
+		})
+	}
+}
+
+func TestParseFFmpegProgress(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected float64
+		ok       bool
+	}{
+		{"out_time_us=2500000", 2.5, true},
+		{"out_time_ms=1000000\n", 1, true},
+		{"out_time_us=N/A", 0, false},
+		{"out_time=00:00:02.500000", 0, false},
+		{"frame=42", 0, false},
+		{"progress=end", 0, false},
+	}
+	for _, test := range tests {
+		got, ok := parseFFmpegProgress(test.line)
+		if ok != test.ok || got != test.expected {
+			t.Errorf("parseFFmpegProgress(%q) = %v, %v; want %v, %v", test.line, got, ok, test.expected, test.ok)
+		}
+	}
+}
+
+func TestHLSPlaylistDuration(t *testing.T) {
+	playlist := []byte("#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:6\n#EXTINF:6.006000,\n720p_000.ts\n#EXTINF:3.5,\n720p_001.ts\n#EXT-X-ENDLIST\n")
+	if got := hlsPlaylistDuration(playlist); got != 9.506 {
+		t.Errorf("hlsPlaylistDuration = %v, want 9.506", got)
+	}
+}
+
+func TestHLSMasterPlaylist(t *testing.T) {
+	got := string(hlsMasterPlaylist([]hlsVariant{
+		{Playlist: "360p.m3u8", Bandwidth: 650000},
+		{Playlist: "720p.m3u8", Bandwidth: 2600000},
+	}))
+	expected := "#EXTM3U\n#EXT-X-VERSION:3\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=650000\n360p.m3u8\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=2600000\n720p.m3u8\n"
+	if got != expected {
+		t.Errorf("hlsMasterPlaylist =\n%s\nwant\n%s", got, expected)
+	}
+}
+
+func TestReadHLSVariant(t *testing.T) {
+	dir := t.TempDir()
+	playlist := "#EXTM3U\n#EXTINF:2.0,\n720p_000.ts\n#EXTINF:2.0,\n720p_001.ts\n#EXT-X-ENDLIST\n"
+	if err := os.WriteFile(filepath.Join(dir, "720p.m3u8"), []byte(playlist), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"720p_000.ts", "720p_001.ts"} {
+		if err := os.WriteFile(filepath.Join(dir, name), make([]byte, 250000), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// a segment of another rung is not counted
+	if err := os.WriteFile(filepath.Join(dir, "360p_000.ts"), make([]byte, 1000), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	variant, err := readHLSVariant(dir, "720p", 720)
+	if err != nil {
+		t.Fatalf("readHLSVariant returned error: %v", err)
+	}
+	if variant.Playlist != "720p.m3u8" || variant.Bandwidth != 1000000 {
+		t.Errorf("readHLSVariant = %+v, want playlist 720p.m3u8 and bandwidth 1000000", variant)
+	}
+}
+
+func TestTranscode_MissingTool(t *testing.T) {
+	transcoder := NewTranscoder(nil, nil, "test-bucket", nil, &Capabilities{})
+	err := transcoder.Transcode(context.Background(), &database.PhotoObject{ObjectID: "clip.mov"}, nil)
+	if !errors.Is(err, exec.ErrNotFound) {
+		t.Fatalf("Transcode() error = %v, want exec.ErrNotFound", err)
+	}
+}
+
+type mockTranscodeVideoStream struct {
+	grpc.ServerStream
+	ctx  context.Context
+	sent []*proto.TranscodeVideoProgress
+}
+
+func (m *mockTranscodeVideoStream) Send(msg *proto.TranscodeVideoProgress) error {
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func (m *mockTranscodeVideoStream) Context() context.Context { return m.ctx }
+
+func TestTranscodeVideo_Unauthenticated(t *testing.T) {
+	server := &LibraryServer{}
+	stream := &mockTranscodeVideoStream{ctx: context.Background()}
+
+	err := server.TranscodeVideo(&proto.TranscodeVideoRequest{}, stream)
+
+	assertGRPCError(t, err, codes.Unauthenticated)
+}
+
+func TestTranscodeVideo_Unavailable(t *testing.T) {
+	tests := []struct {
+		name   string
+		server *LibraryServer
+	}{
+		{"no transcoder", &LibraryServer{}},
+		{"ffmpeg missing", &LibraryServer{Transcoder: &Transcoder{}, Capabilities: &Capabilities{}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			stream := &mockTranscodeVideoStream{ctx: contextWithUserID(1)}
+
+			err := test.server.TranscodeVideo(&proto.TranscodeVideoRequest{}, stream)
+
+			assertGRPCError(t, err, codes.FailedPrecondition)
+			if len(stream.sent) != 0 {
+				t.Errorf("expected no progress messages, got %d", len(stream.sent))
+			}
+		})
+	}
+}
+
+func TestTranscodeVideo_SingleObject(t *testing.T) {
+	db := setupLibraryTestDB(t)
+	for _, obj := range []database.PhotoObject{
+		{ObjectID: "photos/image.jpg", ContentType: "image/jpeg", MD5Hash: "h1", UserID: 1},
+		{ObjectID: "photos/other_user.mov", ContentType: "video/quicktime", MD5Hash: "h2", UserID: 2},
+	} {
+		if err := db.Create(&obj).Error; err != nil {
+			t.Fatalf("failed to seed photo object: %v", err)
+		}
+	}
+	server := &LibraryServer{DB: db, Transcoder: NewTranscoder(db, nil, "test-bucket", nil, nil)}
+
+	tests := []struct {
+		objectID string
+		code     codes.Code
+	}{
+		{"photos/missing.mov", codes.NotFound},
+		{"photos/other_user.mov", codes.NotFound},
+		{"photos/image.jpg", codes.InvalidArgument},
+	}
+	for _, test := range tests {
+		t.Run(test.objectID, func(t *testing.T) {
+			stream := &mockTranscodeVideoStream{ctx: contextWithUserID(1)}
+
+			err := server.TranscodeVideo(&proto.TranscodeVideoRequest{ObjectId: test.objectID}, stream)
+
+			assertGRPCError(t, err, test.code)
+		})
+	}
+}
+
+func TestTranscodeVideo_EligibilityFilter(t *testing.T) {
+	// without ffmpeg every video that is transcoded fails, which tells them
+	// apart from those skipped
+	db := setupLibraryTestDB(t)
+
+	proxyID := "photos/done_proxy.mp4"
+	hlsID := "photos/done_hls/index.m3u8"
+	objects := []database.PhotoObject{
+		{ObjectID: "photos/done.mov", ContentType: "video/quicktime", MD5Hash: "h1", UserID: 1, ProxyObjectID: &proxyID, HLSObjectID: &hlsID},
+		{ObjectID: "photos/no_hls.mov", ContentType: "video/quicktime", MD5Hash: "h2", UserID: 1, ProxyObjectID: &proxyID},
+		{ObjectID: "photos/new.mp4", ContentType: "video/mp4", MD5Hash: "h3", UserID: 1},
+		{ObjectID: "photos/image.jpg", ContentType: "image/jpeg", MD5Hash: "h4", UserID: 1},
+		{ObjectID: "photos/derived_proxy.mp4", ContentType: "video/mp4", MD5Hash: "h5", UserID: 1},
+		{ObjectID: "photos/other_user.mov", ContentType: "video/quicktime", MD5Hash: "h6", UserID: 2},
+	}
+	for _, obj := range objects {
+		if err := db.Create(&obj).Error; err != nil {
+			t.Fatalf("failed to seed photo object: %v", err)
+		}
+	}
+	recordDerivedObject(context.Background(), db, 1, database.DerivedKindProxy, "photos/derived.mov", "photos/derived_proxy.mp4", 0)
+
+	tests := []struct {
+		name        string
+		hlsHeights  []int
+		force       bool
+		wantSkipped uint32
+		wantFailed  uint32
+	}{
+		{"proxy only", nil, false, 2, 1},
+		{"with HLS", []int{720}, false, 1, 2},
+		{"force", nil, true, 0, 3},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := &LibraryServer{DB: db, Transcoder: NewTranscoder(db, nil, "test-bucket", test.hlsHeights, &Capabilities{})}
+			stream := &mockTranscodeVideoStream{ctx: contextWithUserID(1)}
+
+			if err := server.TranscodeVideo(&proto.TranscodeVideoRequest{Force: test.force}, stream); err != nil {
+				t.Fatalf("TranscodeVideo returned error: %v", err)
+			}
+
+			if len(stream.sent) != 4 {
+				t.Fatalf("expected 4 progress messages (3 per-object + 1 summary), got %d", len(stream.sent))
+			}
+			summary := stream.sent[3]
+			if !summary.GetComplete() || summary.GetTotal() != 3 ||
+				summary.GetSkipped() != test.wantSkipped || summary.GetFailed() != test.wantFailed {
+				t.Errorf("summary complete=%v total=%d skipped=%d failed=%d, want true/3/%d/%d",
+					summary.GetComplete(), summary.GetTotal(), summary.GetSkipped(), summary.GetFailed(),
+					test.wantSkipped, test.wantFailed)
+			}
+		})
+	}
+}
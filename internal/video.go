@@ -3,6 +3,7 @@ package internal
 import (
 	"bytes"
 	"context"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -57,12 +58,18 @@ type ffprobeOutput struct {
 
 // ExtractVideoMetadata extracts metadata from video data using ffprobe.
 // It writes the data to a temporary file, runs ffprobe, and parses the output.
-// Returns a VideoMetadataInfo struct with available metadata.
-func ExtractVideoMetadata(data []byte, originalFilename string) (*VideoMetadataInfo, error) {
+// If ffprobe is not installed, MP4 and QuickTime metadata is read in Go
+// instead (see readMP4Metadata). Returns a VideoMetadataInfo struct with
+// available metadata.
+func ExtractVideoMetadata(caps *Capabilities, data []byte, originalFilename string) (*VideoMetadataInfo, error) {
 	info := &VideoMetadataInfo{
 		OriginalFilename: originalFilename,
 	}
 
+	if !caps.Has(ToolFFprobe) {
+		return info, readMP4Metadata(data, info)
+	}
+
 	// Create a temporary file to store the video data
 	tmpFile, err := os.CreateTemp("", "video-*.tmp")
 	if err != nil {
@@ -80,7 +87,12 @@ func ExtractVideoMetadata(data []byte, originalFilename string) (*VideoMetadataI
 		return info, fmt.Errorf("failed to close temp file: %w", err)
 	}
 
-	// Run ffprobe to get video metadata
+	return info, probeVideoFile(tmpFile.Name(), info)
+}
+
+// probeVideoFile runs ffprobe on the video at videoPath and fills in the
+// duration, dimensions and creation time it reports.
+func probeVideoFile(videoPath string, info *VideoMetadataInfo) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
@@ -89,17 +101,17 @@ func ExtractVideoMetadata(data []byte, originalFilename string) (*VideoMetadataI
 		"-print_format", "json",
 		"-show_format",
 		"-show_streams",
-		tmpFile.Name(),
+		videoPath,
 	)
 
 	output, err := cmd.Output()
 	if err != nil {
-		return info, fmt.Errorf("ffprobe failed: %w", err)
+		return fmt.Errorf("ffprobe failed: %w", err)
 	}
 
 	var probeOutput ffprobeOutput
 	if err := json.Unmarshal(output, &probeOutput); err != nil {
-		return info, fmt.Errorf("failed to parse ffprobe output: %w", err)
+		return fmt.Errorf("failed to parse ffprobe output: %w", err)
 	}
 
 	// Extract duration
@@ -130,7 +142,100 @@ func ExtractVideoMetadata(data []byte, originalFilename string) (*VideoMetadataI
 		}
 	}
 
-	return info, nil
+	return nil
+}
+
+// mp4Epoch is the epoch of the timestamps in MP4 and QuickTime files.
+var mp4Epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// readMP4Metadata reads the duration and creation time of an MP4 or QuickTime
+// video from its movie header (mvhd) box and its dimensions from the header
+// (tkhd) of its first track with any, filling in info.
+func readMP4Metadata(data []byte, info *VideoMetadataInfo) error {
+	moovs := mp4Boxes(data, "moov")
+	if len(moovs) == 0 {
+		return fmt.Errorf("no moov box found")
+	}
+	moov := moovs[0]
+
+	if mvhds := mp4Boxes(moov, "mvhd"); len(mvhds) > 0 {
+		mvhd := mvhds[0]
+		var created, timescale, duration uint64
+		switch {
+		case len(mvhd) >= 20 && mvhd[0] == 0:
+			created = uint64(binary.BigEndian.Uint32(mvhd[4:8]))
+			timescale = uint64(binary.BigEndian.Uint32(mvhd[12:16]))
+			duration = uint64(binary.BigEndian.Uint32(mvhd[16:20]))
+		case len(mvhd) >= 32 && mvhd[0] == 1:
+			created = binary.BigEndian.Uint64(mvhd[4:12])
+			timescale = uint64(binary.BigEndian.Uint32(mvhd[20:24]))
+			duration = binary.BigEndian.Uint64(mvhd[24:32])
+		}
+		if timescale > 0 {
+			info.DurationSeconds = float64(duration) / float64(timescale)
+		}
+		if created > 0 {
+			info.DateTaken = mp4Epoch.Add(time.Duration(created) * time.Second)
+			info.HasDateTaken = true
+		}
+	}
+
+	for _, trak := range mp4Boxes(moov, "trak") {
+		tkhds := mp4Boxes(trak, "tkhd")
+		if len(tkhds) == 0 {
+			continue
+		}
+		tkhd := tkhds[0]
+		// The width and height are 16.16 fixed point numbers at the end of
+		// the box, which is longer in version 1
+		var dimensions []byte
+		switch {
+		case len(tkhd) >= 84 && tkhd[0] == 0:
+			dimensions = tkhd[76:84]
+		case len(tkhd) >= 96 && tkhd[0] == 1:
+			dimensions = tkhd[88:96]
+		default:
+			continue
+		}
+		width := int(binary.BigEndian.Uint32(dimensions[0:4]) >> 16)
+		height := int(binary.BigEndian.Uint32(dimensions[4:8]) >> 16)
+		if width > 0 && height > 0 {
+			info.Width = width
+			info.Height = height
+			info.HasDimensions = true
+			break
+		}
+	}
+
+	return nil
+}
+
+// mp4Boxes returns the payloads of the boxes of boxType directly within data.
+func mp4Boxes(data []byte, boxType string) [][]byte {
+	var boxes [][]byte
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data[0:4]))
+		header := uint64(8)
+		switch size {
+		case 0:
+			// The box extends to the end of the data
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return boxes
+			}
+			size = binary.BigEndian.Uint64(data[8:16])
+			header = 16
+		}
+		if size < header || size > uint64(len(data)) {
+			return boxes
+		}
+		if string(data[4:8]) == boxType {
+			boxes = append(boxes, data[header:size])
+		}
+		data = data[size:]
+	}
+	return boxes
 }
 
 // GenerateVideoThumbnail generates a thumbnail image from video data using ffmpeg.
@@ -1,6 +1,7 @@
 package internal
 
 import (
+	"encoding/binary"
 	"testing"
 	"time"
 )
@@ -550,3 +551,58 @@ func TestVideoMetadataInfo_DurationPrecision(t *testing.T) {
 		})
 	}
 }
+
+// mp4Box returns an MP4 box of boxType with payload.
+func mp4Box(boxType string, payload ...[]byte) []byte {
+	var body []byte
+	for _, p := range payload {
+		body = append(body, p...)
+	}
+	box := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	box = append(box, boxType...)
+	return append(box, body...)
+}
+
+// tkhdPayload returns a version 0 track header with the given dimensions.
+func tkhdPayload(width, height int) []byte {
+	payload := make([]byte, 84)
+	binary.BigEndian.PutUint32(payload[76:80], uint32(width)<<16)
+	binary.BigEndian.PutUint32(payload[80:84], uint32(height)<<16)
+	return payload
+}
+
+func TestReadMP4Metadata(t *testing.T) {
+	created := time.Date(2024, 6, 1, 12, 30, 0, 0, time.UTC)
+	mvhd := make([]byte, 20)
+	binary.BigEndian.PutUint32(mvhd[4:8], uint32(created.Sub(mp4Epoch)/time.Second))
+	binary.BigEndian.PutUint32(mvhd[12:16], 600)
+	binary.BigEndian.PutUint32(mvhd[16:20], 9000)
+
+	data := append(
+		mp4Box("ftyp", []byte("isom")),
+		mp4Box("moov",
+			mp4Box("mvhd", mvhd),
+			// An audio track has no dimensions
+			mp4Box("trak", mp4Box("tkhd", tkhdPayload(0, 0))),
+			mp4Box("trak", mp4Box("tkhd", tkhdPayload(1920, 1080))),
+		)...,
+	)
+
+	info := &VideoMetadataInfo{}
+	if err := readMP4Metadata(data, info); err != nil {
+		t.Fatalf("readMP4Metadata returned error: %v", err)
+	}
+	if info.DurationSeconds != 15 {
+		t.Errorf("DurationSeconds = %v, want 15", info.DurationSeconds)
+	}
+	if !info.HasDimensions || info.Width != 1920 || info.Height != 1080 {
+		t.Errorf("dimensions = %dx%d (has=%v), want 1920x1080", info.Width, info.Height, info.HasDimensions)
+	}
+	if !info.HasDateTaken || !info.DateTaken.Equal(created) {
+		t.Errorf("DateTaken = %v (has=%v), want %v", info.DateTaken, info.HasDateTaken, created)
+	}
+
+	if err := readMP4Metadata(mp4Box("ftyp", []byte("isom")), &VideoMetadataInfo{}); err == nil {
+		t.Error("expected error for data without a moov box")
+	}
+}
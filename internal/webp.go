@@ -3,10 +3,11 @@ package internal
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"image/jpeg"
 	"os"
 	"os/exec"
-	"path"
 	"strings"
 	"time"
 )
@@ -15,7 +16,7 @@ import (
 const DefaultWebPQuality = 80
 
 // IsWebPConvertibleContentType returns true for raster image formats that cwebp
-// can convert to WebP.  Videos, DNG raw files, HEIC, and already-WebP files are
+// can convert to WebP.  Videos, RAW files, HEIC, and already-WebP files are
 // excluded.
 func IsWebPConvertibleContentType(contentType string) bool {
 	switch strings.ToLower(contentType) {
@@ -26,19 +27,75 @@ func IsWebPConvertibleContentType(contentType string) bool {
 }
 
 // webpObjectID returns the GCS object ID for the WebP version of an image.
-// The file extension (if any) is replaced with ".webp"; the directory is
-// preserved unchanged.
+// ".webp" is appended to the object ID, extension included, so that the
+// WebP of "image.jpg" is not "image.webp", which may be an original, nor that
+// of "image.dng" next to it.
 // Examples:
 //
-//	"dir1/dir2/image.jpg" → "dir1/dir2/image.webp"
-//	"image.png"           → "image.webp"
+//	"dir1/dir2/image.jpg" → "dir1/dir2/image.jpg.webp"
+//	"image.png"           → "image.png.webp"
 //	"photo"               → "photo.webp"
 func webpObjectID(objectID string) string {
-	ext := path.Ext(objectID)
-	if ext == "" {
-		return objectID + ".webp"
+	return objectID + ".webp"
+}
+
+// webJPEGObjectID returns the GCS object ID of the JPEG web rendition stored
+// in place of a WebP when cwebp is not installed. "_web.jpg" is appended to
+// the object ID, extension included.
+// Example:
+//
+//	"dir1/dir2/image.png" → "dir1/dir2/image.png_web.jpg"
+func webJPEGObjectID(objectID string) string {
+	return objectID + "_web.jpg"
+}
+
+// webRendition is the web-friendly rendition of an image referenced by
+// webp_object_id.
+type webRendition struct {
+	ObjectID    string
+	ContentType string
+	Data        []byte
+}
+
+// generateWebRendition generates the web rendition of the image objectID
+// from data: a WebP encoded by cwebp or, if cwebp is not installed, a JPEG
+// encoded in Go (see encodeWebJPEG).
+func generateWebRendition(caps *Capabilities, objectID string, data []byte, quality int) (*webRendition, error) {
+	if caps.Has(ToolCWebP) {
+		webpData, err := GenerateWebP(data, quality)
+		if err == nil {
+			return &webRendition{ObjectID: webpObjectID(objectID), ContentType: "image/webp", Data: webpData}, nil
+		}
+		if !errors.Is(err, exec.ErrNotFound) {
+			return nil, err
+		}
+	}
+
+	jpegData, err := encodeWebJPEG(data, quality)
+	if err != nil {
+		return nil, err
+	}
+	return &webRendition{ObjectID: webJPEGObjectID(objectID), ContentType: "image/jpeg", Data: jpegData}, nil
+}
+
+// encodeWebJPEG re-encodes image data (see decodeImage) as a JPEG of the
+// given quality (1-100, otherwise DefaultWebPQuality). The EXIF orientation
+// is applied, as the metadata carrying it is not kept.
+func encodeWebJPEG(data []byte, quality int) ([]byte, error) {
+	if quality < 1 || quality > 100 {
+		quality = DefaultWebPQuality
+	}
+	img, err := decodeImage(data)
+	if err != nil {
+		return nil, err
+	}
+	oriented := applyOrientation(img, readOrientation(data))
+
+	var out bytes.Buffer
+	if err := jpeg.Encode(&out, oriented, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, fmt.Errorf("failed to encode JPEG: %w", err)
 	}
-	return strings.TrimSuffix(objectID, ext) + ".webp"
+	return out.Bytes(), nil
 }
 
 // GenerateWebP converts image data to a lossy WebP using the external cwebp
@@ -4,12 +4,15 @@ import (
 	"bytes"
 	"image"
 	"image/color"
+	"image/jpeg"
 	"image/png"
 	"os/exec"
 	"slices"
+	"strings"
 	"testing"
 
 	"cloud.google.com/go/storage"
+	"github.com/alexhokl/photos/database"
 )
 
 func TestIsWebPConvertibleContentType(t *testing.T) {
@@ -46,13 +49,13 @@ func TestWebpObjectID(t *testing.T) {
 		input    string
 		expected string
 	}{
-		{"dir1/dir2/image.jpg", "dir1/dir2/image.webp"},
-		{"image.png", "image.webp"},
-		{"image.gif", "image.webp"},
-		{"image.jpeg", "image.webp"},
+		{"dir1/dir2/image.jpg", "dir1/dir2/image.jpg.webp"},
+		{"image.png", "image.png.webp"},
+		{"image.gif", "image.gif.webp"},
+		{"image.jpeg", "image.jpeg.webp"},
 		{"photo", "photo.webp"},
-		{"IMG.JPG", "IMG.webp"},
-		{"a/b/c/file.JPEG", "a/b/c/file.webp"},
+		{"IMG.JPG", "IMG.JPG.webp"},
+		{"a/b/c/file.JPEG", "a/b/c/file.JPEG.webp"},
 		{"noext/photo", "noext/photo.webp"},
 	}
 	for _, test := range tests {
@@ -128,54 +131,17 @@ func TestGenerateWebP_OutOfRangeQualityClamped(t *testing.T) {
 	}
 }
 
-func TestIsDerivedObjectID(t *testing.T) {
-	tests := []struct {
-		objectID string
-		expected bool
-	}{
-		// preview JPEGs from DNG processing
-		{"IMG_001_preview.jpg", true},
-		{"a/b/IMG_001_preview.jpg", true},
-		{"A/B/IMG_001_PREVIEW.JPG", true},
-		// video thumbnails
-		{"clip_thumb.jpg", true},
-		{"a/b/clip_thumb.jpg", true},
-		{"A/B/CLIP_THUMB.JPG", true},
-		// WebP renditions
-		{"image.webp", true},
-		{"a/b/image.webp", true},
-		{"A/B/IMAGE.WEBP", true},
-		// original uploads — must not be treated as derived
-		{"photo.jpg", false},
-		{"a/b/image.jpeg", false},
-		{"a/b/image.png", false},
-		{"preview.jpg", false},          // "preview" without underscore prefix
-		{"thumb.jpg", false},            // "thumb" without underscore prefix
-		{"my_preview_shot.jpg", false},  // contains "_preview" but not as suffix
-		{"my_thumbnail.jpg", false},     // contains "thumb" mid-name but not _thumb.jpg suffix
-		{"my.webp.jpg", false},          // ".webp" not at suffix
-		{"", false},
-	}
-	for _, test := range tests {
-		t.Run(test.objectID, func(t *testing.T) {
-			result := isDerivedObjectID(test.objectID)
-			if result != test.expected {
-				t.Errorf("isDerivedObjectID(%q) = %v, want %v", test.objectID, result, test.expected)
-			}
-		})
-	}
-}
-
 func TestMissingWebp(t *testing.T) {
 	tests := []struct {
-		name        string
-		gcsObjects  map[string]*storage.ObjectAttrs
-		expected    []string
+		name       string
+		gcsObjects map[string]*storage.ObjectAttrs
+		derived    derivedObjectSet
+		expected   []string
 	}{
 		{
-			name:        "empty map",
-			gcsObjects:  map[string]*storage.ObjectAttrs{},
-			expected:    nil,
+			name:       "empty map",
+			gcsObjects: map[string]*storage.ObjectAttrs{},
+			expected:   nil,
 		},
 		{
 			name: "jpeg missing webp",
@@ -186,12 +152,32 @@ func TestMissingWebp(t *testing.T) {
 		},
 		{
 			name: "jpeg with webp present",
+			gcsObjects: map[string]*storage.ObjectAttrs{
+				"a/photo.jpg":      {Name: "a/photo.jpg", ContentType: "image/jpeg"},
+				"a/photo.jpg.webp": {Name: "a/photo.jpg.webp", ContentType: "image/webp"},
+			},
+			expected: nil,
+		},
+		{
+			name: "jpeg with marked webp named without its extension",
 			gcsObjects: map[string]*storage.ObjectAttrs{
 				"a/photo.jpg": {Name: "a/photo.jpg", ContentType: "image/jpeg"},
-				"a/photo.webp": {Name: "a/photo.webp", ContentType: "image/webp"},
+				"a/photo.webp": {
+					Name:        "a/photo.webp",
+					ContentType: "image/webp",
+					Metadata:    derivedObjectMetadata(database.DerivedKindWebP, "a/photo.jpg"),
+				},
 			},
 			expected: nil,
 		},
+		{
+			name: "jpeg next to an uploaded webp of the same name",
+			gcsObjects: map[string]*storage.ObjectAttrs{
+				"a/photo.jpg":  {Name: "a/photo.jpg", ContentType: "image/jpeg"},
+				"a/photo.webp": {Name: "a/photo.webp", ContentType: "image/webp"},
+			},
+			expected: []string{"a/photo.jpg"},
+		},
 		{
 			name: "png missing webp",
 			gcsObjects: map[string]*storage.ObjectAttrs{
@@ -214,11 +200,11 @@ func TestMissingWebp(t *testing.T) {
 			expected: nil,
 		},
 		{
-			name: "heic excluded",
+			name: "heic missing webp",
 			gcsObjects: map[string]*storage.ObjectAttrs{
 				"img.heic": {Name: "img.heic", ContentType: "image/heic"},
 			},
-			expected: nil,
+			expected: []string{"img.heic"},
 		},
 		{
 			name: "video excluded",
@@ -237,39 +223,51 @@ func TestMissingWebp(t *testing.T) {
 		{
 			name: "dng with webp present",
 			gcsObjects: map[string]*storage.ObjectAttrs{
-				"raw.dng":  {Name: "raw.dng", ContentType: "image/x-adobe-dng"},
-				"raw.webp": {Name: "raw.webp", ContentType: "image/webp"},
+				"raw.dng":      {Name: "raw.dng", ContentType: "image/x-adobe-dng"},
+				"raw.dng.webp": {Name: "raw.dng.webp", ContentType: "image/webp"},
 			},
 			expected: nil,
 		},
 		{
-			name: "derived preview excluded",
+			name: "marked preview excluded",
 			gcsObjects: map[string]*storage.ObjectAttrs{
-				"IMG_001_preview.jpg": {Name: "IMG_001_preview.jpg", ContentType: "image/jpeg"},
+				"IMG_001_preview.jpg": {
+					Name:        "IMG_001_preview.jpg",
+					ContentType: "image/jpeg",
+					Metadata:    derivedObjectMetadata(database.DerivedKindPreview, "IMG_001.dng"),
+				},
 			},
 			expected: nil,
 		},
 		{
-			name: "derived thumb excluded",
+			name: "recorded thumb excluded",
 			gcsObjects: map[string]*storage.ObjectAttrs{
 				"clip_thumb.jpg": {Name: "clip_thumb.jpg", ContentType: "image/jpeg"},
 			},
+			derived:  derivedObjectSet{"clip_thumb.jpg": {}},
 			expected: nil,
 		},
+		{
+			name: "unrecorded preview-like upload included",
+			gcsObjects: map[string]*storage.ObjectAttrs{
+				"IMG_001_preview.jpg": {Name: "IMG_001_preview.jpg", ContentType: "image/jpeg"},
+			},
+			expected: []string{"IMG_001_preview.jpg"},
+		},
 		{
 			name: "mixed objects",
 			gcsObjects: map[string]*storage.ObjectAttrs{
-				"photo.jpg":  {Name: "photo.jpg", ContentType: "image/jpeg"},
-				"photo.webp": {Name: "photo.webp", ContentType: "image/webp"},
-				"clip.mp4":   {Name: "clip.mp4", ContentType: "video/mp4"},
-				"raw.dng":    {Name: "raw.dng", ContentType: "image/x-adobe-dng"},
+				"photo.jpg":      {Name: "photo.jpg", ContentType: "image/jpeg"},
+				"photo.jpg.webp": {Name: "photo.jpg.webp", ContentType: "image/webp"},
+				"clip.mp4":       {Name: "clip.mp4", ContentType: "video/mp4"},
+				"raw.dng":        {Name: "raw.dng", ContentType: "image/x-adobe-dng"},
 			},
 			expected: []string{"raw.dng"},
 		},
 	}
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
-			result := missingWebp(test.gcsObjects)
+			result := missingWebp(test.gcsObjects, test.derived)
 			slices.Sort(result)
 			expected := slices.Clone(test.expected)
 			slices.Sort(expected)
@@ -279,3 +277,49 @@ func TestMissingWebp(t *testing.T) {
 		})
 	}
 }
+
+func TestWebJPEGObjectID(t *testing.T) {
+	tests := []struct {
+		objectID string
+		expected string
+	}{
+		{"dir1/dir2/image.png", "dir1/dir2/image.png_web.jpg"},
+		{"image.jpg", "image.jpg_web.jpg"},
+		{"photo", "photo_web.jpg"},
+	}
+	for _, test := range tests {
+		if got := webJPEGObjectID(test.objectID); got != test.expected {
+			t.Errorf("webJPEGObjectID(%q) = %q, want %q", test.objectID, got, test.expected)
+		}
+	}
+}
+
+func TestGenerateWebRendition_JPEGFallback(t *testing.T) {
+	var src bytes.Buffer
+	if err := png.Encode(&src, image.NewRGBA(image.Rect(0, 0, 40, 30))); err != nil {
+		t.Fatalf("failed to encode source: %v", err)
+	}
+
+	// cwebp is not among the probed tools
+	rendition, err := generateWebRendition(&Capabilities{}, "a/image.png", src.Bytes(), DefaultWebPQuality)
+	if err != nil {
+		t.Fatalf("generateWebRendition returned error: %v", err)
+	}
+	if rendition.ObjectID != "a/image.png_web.jpg" || rendition.ContentType != "image/jpeg" {
+		t.Errorf("rendition = %s (%s), want a/image.png_web.jpg (image/jpeg)", rendition.ObjectID, rendition.ContentType)
+	}
+	config, err := jpeg.DecodeConfig(bytes.NewReader(rendition.Data))
+	if err != nil {
+		t.Fatalf("rendition is not a JPEG: %v", err)
+	}
+	if config.Width != 40 || config.Height != 30 {
+		t.Errorf("rendition is %dx%d, want 40x30", config.Width, config.Height)
+	}
+
+	if _, err := generateWebRendition(&Capabilities{}, "bad.png", []byte("not an image"), DefaultWebPQuality); err == nil {
+		t.Error("expected error for undecodable data")
+	}
+	if _, err := generateWebRendition(&Capabilities{}, "huge.png", oversizedPNG(), DefaultWebPQuality); err == nil || !strings.Contains(err.Error(), "larger than") {
+		t.Errorf("generateWebRendition() error = %v, want the image refused as too large", err)
+	}
+}
@@ -0,0 +1,174 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// XMP namespaces used by Lightroom / Camera Raw sidecars
+const (
+	xmpNamespaceRDF = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	xmpNamespaceXMP = "http://ns.adobe.com/xap/1.0/"
+	xmpNamespaceDC  = "http://purl.org/dc/elements/1.1/"
+	xmpNamespaceCRS = "http://ns.adobe.com/camera-raw-settings/1.0/"
+)
+
+// XMPSidecarContentType is the content type stored for XMP sidecar objects.
+const XMPSidecarContentType = "application/rdf+xml"
+
+// XMPSidecarInfo contains the fields extracted from an XMP sidecar file
+type XMPSidecarInfo struct {
+	// Rating is the star rating (-1 = rejected, 0 = unrated, 1-5 = stars)
+	Rating int
+	// HasRating indicates if a rating was found
+	HasRating bool
+	// Label is the colour label (e.g., "Red", "Green")
+	Label string
+	// Keywords are the dc:subject entries
+	Keywords []string
+	// Caption is the dc:description entry
+	Caption string
+	// HasCrop indicates if the sidecar contains an active crop
+	HasCrop bool
+	// CropTop, CropLeft, CropBottom and CropRight are the crop rectangle as
+	// fractions (0-1) of the original image dimensions
+	CropTop    float64
+	CropLeft   float64
+	CropBottom float64
+	CropRight  float64
+	// CropAngle is the straighten angle in degrees
+	CropAngle float64
+}
+
+// isSidecarObjectID returns true if the object ID refers to an XMP sidecar.
+func isSidecarObjectID(objectID string) bool {
+	return strings.EqualFold(path.Ext(objectID), ".xmp")
+}
+
+// sidecarObjectID returns the object ID of the XMP sidecar for a photo.
+// The file extension (if any) is replaced with ".xmp", following the
+// Lightroom naming convention.
+// For example "photos/2024/IMG_001.dng" → "photos/2024/IMG_001.xmp".
+func sidecarObjectID(objectID string) string {
+	ext := path.Ext(objectID)
+	if ext == "" {
+		return objectID + ".xmp"
+	}
+	return strings.TrimSuffix(objectID, ext) + ".xmp"
+}
+
+// ParseXMPSidecar parses an XMP sidecar and returns the rating, label,
+// keywords, caption and crop settings it contains. Properties may be written
+// either as attributes of rdf:Description or as child elements; both forms
+// are accepted. Unknown properties are ignored.
+func ParseXMPSidecar(data []byte) (*XMPSidecarInfo, error) {
+	info := &XMPSidecarInfo{}
+
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	var stack []xml.Name
+	var text strings.Builder
+
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse XMP: %w", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			for _, attr := range t.Attr {
+				info.setProperty(attr.Name, attr.Value)
+			}
+			stack = append(stack, t.Name)
+			text.Reset()
+
+		case xml.CharData:
+			text.Write(t)
+
+		case xml.EndElement:
+			value := strings.TrimSpace(text.String())
+			text.Reset()
+
+			if t.Name.Space == xmpNamespaceRDF && t.Name.Local == "li" {
+				// rdf:li sits inside a container (rdf:Bag/Seq/Alt) whose parent
+				// is the property being described.
+				if len(stack) >= 3 && value != "" {
+					info.addListItem(stack[len(stack)-3], value)
+				}
+			} else if value != "" {
+				info.setProperty(t.Name, value)
+			}
+
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+
+	return info, nil
+}
+
+// setProperty records a simple (non-list) XMP property.
+func (x *XMPSidecarInfo) setProperty(name xml.Name, value string) {
+	switch name.Space {
+	case xmpNamespaceXMP:
+		switch name.Local {
+		case "Rating":
+			if rating, err := strconv.Atoi(value); err == nil {
+				x.Rating = rating
+				x.HasRating = true
+			}
+		case "Label":
+			x.Label = value
+		}
+
+	case xmpNamespaceCRS:
+		switch name.Local {
+		case "HasCrop":
+			x.HasCrop = strings.EqualFold(value, "true")
+		case "CropTop":
+			x.CropTop = parseXMPFloat(value)
+		case "CropLeft":
+			x.CropLeft = parseXMPFloat(value)
+		case "CropBottom":
+			x.CropBottom = parseXMPFloat(value)
+		case "CropRight":
+			x.CropRight = parseXMPFloat(value)
+		case "CropAngle":
+			x.CropAngle = parseXMPFloat(value)
+		}
+	}
+}
+
+// addListItem records an rdf:li entry belonging to the given property.
+func (x *XMPSidecarInfo) addListItem(property xml.Name, value string) {
+	if property.Space != xmpNamespaceDC {
+		return
+	}
+	switch property.Local {
+	case "subject":
+		x.Keywords = append(x.Keywords, value)
+	case "description":
+		// dc:description is a language alternative; keep the first entry,
+		// which is the x-default language by convention.
+		if x.Caption == "" {
+			x.Caption = value
+		}
+	}
+}
+
+func parseXMPFloat(value string) float64 {
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0
+	}
+	return f
+}
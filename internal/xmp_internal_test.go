@@ -0,0 +1,156 @@
+package internal
+
+import (
+	"slices"
+	"testing"
+)
+
+const lightroomXMPAttributes = `<?xpacket begin="" id="W5M0MpCehiHzreSzNTczkc9d"?>
+<x:xmpmeta xmlns:x="adobe:ns:meta/" x:xmptk="Adobe XMP Core 7.0-c000">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+    xmlns:dc="http://purl.org/dc/elements/1.1/"
+    xmlns:crs="http://ns.adobe.com/camera-raw-settings/1.0/"
+   xmp:Rating="4"
+   xmp:Label="Red"
+   crs:HasCrop="True"
+   crs:CropTop="0.1"
+   crs:CropLeft="0.05"
+   crs:CropBottom="0.9"
+   crs:CropRight="0.95"
+   crs:CropAngle="-1.5">
+   <dc:subject>
+    <rdf:Bag>
+     <rdf:li>family</rdf:li>
+     <rdf:li>beach</rdf:li>
+    </rdf:Bag>
+   </dc:subject>
+   <dc:description>
+    <rdf:Alt>
+     <rdf:li xml:lang="x-default">Sunset at the beach</rdf:li>
+    </rdf:Alt>
+   </dc:description>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>
+<?xpacket end="w"?>`
+
+const lightroomXMPElements = `<x:xmpmeta xmlns:x="adobe:ns:meta/">
+ <rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+  <rdf:Description rdf:about=""
+    xmlns:xmp="http://ns.adobe.com/xap/1.0/"
+    xmlns:crs="http://ns.adobe.com/camera-raw-settings/1.0/">
+   <xmp:Rating>-1</xmp:Rating>
+   <xmp:Label>Green</xmp:Label>
+   <crs:HasCrop>False</crs:HasCrop>
+  </rdf:Description>
+ </rdf:RDF>
+</x:xmpmeta>`
+
+func TestParseXMPSidecar_Attributes(t *testing.T) {
+	info, err := ParseXMPSidecar([]byte(lightroomXMPAttributes))
+	if err != nil {
+		t.Fatalf("ParseXMPSidecar returned error: %v", err)
+	}
+
+	if !info.HasRating || info.Rating != 4 {
+		t.Errorf("Rating = %d (has=%v), want 4", info.Rating, info.HasRating)
+	}
+	if info.Label != "Red" {
+		t.Errorf("Label = %q, want %q", info.Label, "Red")
+	}
+	if !slices.Equal(info.Keywords, []string{"family", "beach"}) {
+		t.Errorf("Keywords = %v, want [family beach]", info.Keywords)
+	}
+	if info.Caption != "Sunset at the beach" {
+		t.Errorf("Caption = %q, want %q", info.Caption, "Sunset at the beach")
+	}
+	if !info.HasCrop {
+		t.Errorf("HasCrop = false, want true")
+	}
+	if info.CropTop != 0.1 || info.CropLeft != 0.05 || info.CropBottom != 0.9 || info.CropRight != 0.95 {
+		t.Errorf("crop = (%v, %v, %v, %v), want (0.1, 0.05, 0.9, 0.95)",
+			info.CropTop, info.CropLeft, info.CropBottom, info.CropRight)
+	}
+	if info.CropAngle != -1.5 {
+		t.Errorf("CropAngle = %v, want -1.5", info.CropAngle)
+	}
+}
+
+func TestParseXMPSidecar_Elements(t *testing.T) {
+	info, err := ParseXMPSidecar([]byte(lightroomXMPElements))
+	if err != nil {
+		t.Fatalf("ParseXMPSidecar returned error: %v", err)
+	}
+
+	if !info.HasRating || info.Rating != -1 {
+		t.Errorf("Rating = %d (has=%v), want -1", info.Rating, info.HasRating)
+	}
+	if info.Label != "Green" {
+		t.Errorf("Label = %q, want %q", info.Label, "Green")
+	}
+	if info.HasCrop {
+		t.Errorf("HasCrop = true, want false")
+	}
+	if len(info.Keywords) != 0 {
+		t.Errorf("Keywords = %v, want none", info.Keywords)
+	}
+}
+
+func TestParseXMPSidecar_NoProperties(t *testing.T) {
+	info, err := ParseXMPSidecar([]byte(`<x:xmpmeta xmlns:x="adobe:ns:meta/"></x:xmpmeta>`))
+	if err != nil {
+		t.Fatalf("ParseXMPSidecar returned error: %v", err)
+	}
+	if info.HasRating || info.HasCrop || info.Label != "" || info.Caption != "" {
+		t.Errorf("expected empty info, got %+v", info)
+	}
+}
+
+func TestParseXMPSidecar_InvalidXML(t *testing.T) {
+	_, err := ParseXMPSidecar([]byte(`<x:xmpmeta><rdf:RDF>`))
+	if err == nil {
+		t.Error("expected error for truncated XML, got nil")
+	}
+}
+
+func TestIsSidecarObjectID(t *testing.T) {
+	tests := []struct {
+		objectID string
+		expected bool
+	}{
+		{"IMG_001.xmp", true},
+		{"photos/2024/IMG_001.XMP", true},
+		{"IMG_001.dng", false},
+		{"IMG_001.xmp.jpg", false},
+		{"xmp", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		t.Run(test.objectID, func(t *testing.T) {
+			if got := isSidecarObjectID(test.objectID); got != test.expected {
+				t.Errorf("isSidecarObjectID(%q) = %v, want %v", test.objectID, got, test.expected)
+			}
+		})
+	}
+}
+
+func TestSidecarObjectID(t *testing.T) {
+	tests := []struct {
+		objectID string
+		expected string
+	}{
+		{"IMG_001.dng", "IMG_001.xmp"},
+		{"photos/2024/IMG_001.DNG", "photos/2024/IMG_001.xmp"},
+		{"photos/2024/IMG_001.jpg", "photos/2024/IMG_001.xmp"},
+		{"photo", "photo.xmp"},
+	}
+	for _, test := range tests {
+		t.Run(test.objectID, func(t *testing.T) {
+			if got := sidecarObjectID(test.objectID); got != test.expected {
+				t.Errorf("sidecarObjectID(%q) = %q, want %q", test.objectID, got, test.expected)
+			}
+		})
+	}
+}
@@ -23,6 +23,241 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+// ConflictPolicy decides what an upload does when an object with the same ID
+// already exists in the bucket
+type ConflictPolicy int32
+
+const (
+	// CONFLICT_POLICY_UNSPECIFIED behaves as CONFLICT_POLICY_REPLACE
+	ConflictPolicy_CONFLICT_POLICY_UNSPECIFIED ConflictPolicy = 0
+	// CONFLICT_POLICY_FAIL rejects the upload with ALREADY_EXISTS
+	ConflictPolicy_CONFLICT_POLICY_FAIL ConflictPolicy = 1
+	// CONFLICT_POLICY_RENAME stores the upload as "name (1).ext", "name (2).ext", ...
+	ConflictPolicy_CONFLICT_POLICY_RENAME ConflictPolicy = 2
+	// CONFLICT_POLICY_REPLACE overwrites the existing object
+	ConflictPolicy_CONFLICT_POLICY_REPLACE ConflictPolicy = 3
+	// CONFLICT_POLICY_SKIP_IF_IDENTICAL keeps the existing object if its MD5
+	// matches the upload and rejects the upload with ALREADY_EXISTS otherwise
+	ConflictPolicy_CONFLICT_POLICY_SKIP_IF_IDENTICAL ConflictPolicy = 4
+)
+
+// Enum value maps for ConflictPolicy.
+var (
+	ConflictPolicy_name = map[int32]string{
+		0: "CONFLICT_POLICY_UNSPECIFIED",
+		1: "CONFLICT_POLICY_FAIL",
+		2: "CONFLICT_POLICY_RENAME",
+		3: "CONFLICT_POLICY_REPLACE",
+		4: "CONFLICT_POLICY_SKIP_IF_IDENTICAL",
+	}
+	ConflictPolicy_value = map[string]int32{
+		"CONFLICT_POLICY_UNSPECIFIED":       0,
+		"CONFLICT_POLICY_FAIL":              1,
+		"CONFLICT_POLICY_RENAME":            2,
+		"CONFLICT_POLICY_REPLACE":           3,
+		"CONFLICT_POLICY_SKIP_IF_IDENTICAL": 4,
+	}
+)
+
+func (x ConflictPolicy) Enum() *ConflictPolicy {
+	p := new(ConflictPolicy)
+	*p = x
+	return p
+}
+
+func (x ConflictPolicy) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (ConflictPolicy) Descriptor() protoreflect.EnumDescriptor {
+	return file_proto_photos_proto_enumTypes[0].Descriptor()
+}
+
+func (ConflictPolicy) Type() protoreflect.EnumType {
+	return &file_proto_photos_proto_enumTypes[0]
+}
+
+func (x ConflictPolicy) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use ConflictPolicy.Descriptor instead.
+func (ConflictPolicy) EnumDescriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{0}
+}
+
+// RenderFit controls how a photo is fitted into the requested width and height
+type RenderFit int32
+
+const (
+	// RENDER_FIT_UNSPECIFIED acts as RENDER_FIT_CONTAIN
+	RenderFit_RENDER_FIT_UNSPECIFIED RenderFit = 0
+	// RENDER_FIT_CONTAIN scales the photo to fit within the box, keeping its
+	// aspect ratio
+	RenderFit_RENDER_FIT_CONTAIN RenderFit = 1
+	// RENDER_FIT_COVER scales the photo to fill the box and crops the overflow
+	// around the centre
+	RenderFit_RENDER_FIT_COVER RenderFit = 2
+)
+
+// Enum value maps for RenderFit.
+var (
+	RenderFit_name = map[int32]string{
+		0: "RENDER_FIT_UNSPECIFIED",
+		1: "RENDER_FIT_CONTAIN",
+		2: "RENDER_FIT_COVER",
+	}
+	RenderFit_value = map[string]int32{
+		"RENDER_FIT_UNSPECIFIED": 0,
+		"RENDER_FIT_CONTAIN":     1,
+		"RENDER_FIT_COVER":       2,
+	}
+)
+
+func (x RenderFit) Enum() *RenderFit {
+	p := new(RenderFit)
+	*p = x
+	return p
+}
+
+func (x RenderFit) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (RenderFit) Descriptor() protoreflect.EnumDescriptor {
+	return file_proto_photos_proto_enumTypes[1].Descriptor()
+}
+
+func (RenderFit) Type() protoreflect.EnumType {
+	return &file_proto_photos_proto_enumTypes[1]
+}
+
+func (x RenderFit) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use RenderFit.Descriptor instead.
+func (RenderFit) EnumDescriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{1}
+}
+
+// RenderFormat is the image format of a rendition
+type RenderFormat int32
+
+const (
+	// RENDER_FORMAT_UNSPECIFIED acts as RENDER_FORMAT_JPEG
+	RenderFormat_RENDER_FORMAT_UNSPECIFIED RenderFormat = 0
+	RenderFormat_RENDER_FORMAT_JPEG        RenderFormat = 1
+	// RENDER_FORMAT_WEBP requires cwebp on the server; JPEG is returned if it
+	// is unavailable
+	RenderFormat_RENDER_FORMAT_WEBP RenderFormat = 2
+)
+
+// Enum value maps for RenderFormat.
+var (
+	RenderFormat_name = map[int32]string{
+		0: "RENDER_FORMAT_UNSPECIFIED",
+		1: "RENDER_FORMAT_JPEG",
+		2: "RENDER_FORMAT_WEBP",
+	}
+	RenderFormat_value = map[string]int32{
+		"RENDER_FORMAT_UNSPECIFIED": 0,
+		"RENDER_FORMAT_JPEG":        1,
+		"RENDER_FORMAT_WEBP":        2,
+	}
+)
+
+func (x RenderFormat) Enum() *RenderFormat {
+	p := new(RenderFormat)
+	*p = x
+	return p
+}
+
+func (x RenderFormat) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (RenderFormat) Descriptor() protoreflect.EnumDescriptor {
+	return file_proto_photos_proto_enumTypes[2].Descriptor()
+}
+
+func (RenderFormat) Type() protoreflect.EnumType {
+	return &file_proto_photos_proto_enumTypes[2]
+}
+
+func (x RenderFormat) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use RenderFormat.Descriptor instead.
+func (RenderFormat) EnumDescriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{2}
+}
+
+// Action is the kind of change.
+type SyncChange_Action int32
+
+const (
+	SyncChange_ACTION_UNSPECIFIED SyncChange_Action = 0
+	// A photo or XMP sidecar would be added.
+	SyncChange_ACTION_ADD SyncChange_Action = 1
+	// A deleted photo would be restored.
+	SyncChange_ACTION_RESTORE SyncChange_Action = 2
+	// A photo or XMP sidecar would be removed from the database.
+	SyncChange_ACTION_REMOVE SyncChange_Action = 3
+	// The metadata of a photo or XMP sidecar would be updated.
+	SyncChange_ACTION_UPDATE_METADATA SyncChange_Action = 4
+	// A WebP rendition would be generated.
+	SyncChange_ACTION_GENERATE_WEBP SyncChange_Action = 5
+)
+
+// Enum value maps for SyncChange_Action.
+var (
+	SyncChange_Action_name = map[int32]string{
+		0: "ACTION_UNSPECIFIED",
+		1: "ACTION_ADD",
+		2: "ACTION_RESTORE",
+		3: "ACTION_REMOVE",
+		4: "ACTION_UPDATE_METADATA",
+		5: "ACTION_GENERATE_WEBP",
+	}
+	SyncChange_Action_value = map[string]int32{
+		"ACTION_UNSPECIFIED":     0,
+		"ACTION_ADD":             1,
+		"ACTION_RESTORE":         2,
+		"ACTION_REMOVE":          3,
+		"ACTION_UPDATE_METADATA": 4,
+		"ACTION_GENERATE_WEBP":   5,
+	}
+)
+
+func (x SyncChange_Action) Enum() *SyncChange_Action {
+	p := new(SyncChange_Action)
+	*p = x
+	return p
+}
+
+func (x SyncChange_Action) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (SyncChange_Action) Descriptor() protoreflect.EnumDescriptor {
+	return file_proto_photos_proto_enumTypes[3].Descriptor()
+}
+
+func (SyncChange_Action) Type() protoreflect.EnumType {
+	return &file_proto_photos_proto_enumTypes[3]
+}
+
+func (x SyncChange_Action) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use SyncChange_Action.Descriptor instead.
+func (SyncChange_Action) EnumDescriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{33, 0}
+}
+
 // Phase identifies which stage of the sync produced this progress message.
 type SyncDatabaseProgress_Phase int32
 
@@ -31,6 +266,9 @@ const (
 	SyncDatabaseProgress_PHASE_ADD         SyncDatabaseProgress_Phase = 1
 	SyncDatabaseProgress_PHASE_REMOVE      SyncDatabaseProgress_Phase = 2
 	SyncDatabaseProgress_PHASE_METADATA    SyncDatabaseProgress_Phase = 3
+	SyncDatabaseProgress_PHASE_SIDECAR     SyncDatabaseProgress_Phase = 4
+	SyncDatabaseProgress_PHASE_RENDITIONS  SyncDatabaseProgress_Phase = 5
+	SyncDatabaseProgress_PHASE_POSTERS     SyncDatabaseProgress_Phase = 6
 )
 
 // Enum value maps for SyncDatabaseProgress_Phase.
@@ -40,12 +278,18 @@ var (
 		1: "PHASE_ADD",
 		2: "PHASE_REMOVE",
 		3: "PHASE_METADATA",
+		4: "PHASE_SIDECAR",
+		5: "PHASE_RENDITIONS",
+		6: "PHASE_POSTERS",
 	}
 	SyncDatabaseProgress_Phase_value = map[string]int32{
 		"PHASE_UNSPECIFIED": 0,
 		"PHASE_ADD":         1,
 		"PHASE_REMOVE":      2,
 		"PHASE_METADATA":    3,
+		"PHASE_SIDECAR":     4,
+		"PHASE_RENDITIONS":  5,
+		"PHASE_POSTERS":     6,
 	}
 )
 
@@ -60,11 +304,11 @@ func (x SyncDatabaseProgress_Phase) String() string {
 }
 
 func (SyncDatabaseProgress_Phase) Descriptor() protoreflect.EnumDescriptor {
-	return file_proto_photos_proto_enumTypes[0].Descriptor()
+	return file_proto_photos_proto_enumTypes[4].Descriptor()
 }
 
 func (SyncDatabaseProgress_Phase) Type() protoreflect.EnumType {
-	return &file_proto_photos_proto_enumTypes[0]
+	return &file_proto_photos_proto_enumTypes[4]
 }
 
 func (x SyncDatabaseProgress_Phase) Number() protoreflect.EnumNumber {
@@ -73,7 +317,132 @@ func (x SyncDatabaseProgress_Phase) Number() protoreflect.EnumNumber {
 
 // Deprecated: Use SyncDatabaseProgress_Phase.Descriptor instead.
 func (SyncDatabaseProgress_Phase) EnumDescriptor() ([]byte, []int) {
-	return file_proto_photos_proto_rawDescGZIP(), []int{24, 0}
+	return file_proto_photos_proto_rawDescGZIP(), []int{34, 0}
+}
+
+// Kind is the kind of problem.
+type IntegrityProblem_Kind int32
+
+const (
+	IntegrityProblem_KIND_UNSPECIFIED IntegrityProblem_Kind = 0
+	// The object of a photo is not in storage.
+	IntegrityProblem_KIND_MISSING_OBJECT IntegrityProblem_Kind = 1
+	// The MD5 hash of the object of a photo differs from that recorded.
+	IntegrityProblem_KIND_MD5_MISMATCH IntegrityProblem_Kind = 2
+	// A photo, thumbnail or derived asset record refers to a derived asset
+	// that is not in storage.
+	IntegrityProblem_KIND_DANGLING_DERIVED IntegrityProblem_Kind = 3
+	// A derived asset is in storage but its photo is not in the database.
+	IntegrityProblem_KIND_ORPHANED_DERIVED IntegrityProblem_Kind = 4
+	// A directory row has no photos.
+	IntegrityProblem_KIND_EMPTY_DIRECTORY IntegrityProblem_Kind = 5
+	// A directory with photos has no directory row.
+	IntegrityProblem_KIND_MISSING_DIRECTORY IntegrityProblem_Kind = 6
+)
+
+// Enum value maps for IntegrityProblem_Kind.
+var (
+	IntegrityProblem_Kind_name = map[int32]string{
+		0: "KIND_UNSPECIFIED",
+		1: "KIND_MISSING_OBJECT",
+		2: "KIND_MD5_MISMATCH",
+		3: "KIND_DANGLING_DERIVED",
+		4: "KIND_ORPHANED_DERIVED",
+		5: "KIND_EMPTY_DIRECTORY",
+		6: "KIND_MISSING_DIRECTORY",
+	}
+	IntegrityProblem_Kind_value = map[string]int32{
+		"KIND_UNSPECIFIED":       0,
+		"KIND_MISSING_OBJECT":    1,
+		"KIND_MD5_MISMATCH":      2,
+		"KIND_DANGLING_DERIVED":  3,
+		"KIND_ORPHANED_DERIVED":  4,
+		"KIND_EMPTY_DIRECTORY":   5,
+		"KIND_MISSING_DIRECTORY": 6,
+	}
+)
+
+func (x IntegrityProblem_Kind) Enum() *IntegrityProblem_Kind {
+	p := new(IntegrityProblem_Kind)
+	*p = x
+	return p
+}
+
+func (x IntegrityProblem_Kind) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (IntegrityProblem_Kind) Descriptor() protoreflect.EnumDescriptor {
+	return file_proto_photos_proto_enumTypes[5].Descriptor()
+}
+
+func (IntegrityProblem_Kind) Type() protoreflect.EnumType {
+	return &file_proto_photos_proto_enumTypes[5]
+}
+
+func (x IntegrityProblem_Kind) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use IntegrityProblem_Kind.Descriptor instead.
+func (IntegrityProblem_Kind) EnumDescriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{36, 0}
+}
+
+// Provider is how a feature is provided
+type ServerCapability_Provider int32
+
+const (
+	ServerCapability_PROVIDER_UNSPECIFIED ServerCapability_Provider = 0
+	// The external tool is installed and used
+	ServerCapability_PROVIDER_EXTERNAL ServerCapability_Provider = 1
+	// The external tool is missing and an in-process fallback is used
+	ServerCapability_PROVIDER_FALLBACK ServerCapability_Provider = 2
+	// The external tool is missing and the feature is unavailable
+	ServerCapability_PROVIDER_UNAVAILABLE ServerCapability_Provider = 3
+)
+
+// Enum value maps for ServerCapability_Provider.
+var (
+	ServerCapability_Provider_name = map[int32]string{
+		0: "PROVIDER_UNSPECIFIED",
+		1: "PROVIDER_EXTERNAL",
+		2: "PROVIDER_FALLBACK",
+		3: "PROVIDER_UNAVAILABLE",
+	}
+	ServerCapability_Provider_value = map[string]int32{
+		"PROVIDER_UNSPECIFIED": 0,
+		"PROVIDER_EXTERNAL":    1,
+		"PROVIDER_FALLBACK":    2,
+		"PROVIDER_UNAVAILABLE": 3,
+	}
+)
+
+func (x ServerCapability_Provider) Enum() *ServerCapability_Provider {
+	p := new(ServerCapability_Provider)
+	*p = x
+	return p
+}
+
+func (x ServerCapability_Provider) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (ServerCapability_Provider) Descriptor() protoreflect.EnumDescriptor {
+	return file_proto_photos_proto_enumTypes[6].Descriptor()
+}
+
+func (ServerCapability_Provider) Type() protoreflect.EnumType {
+	return &file_proto_photos_proto_enumTypes[6]
+}
+
+func (x ServerCapability_Provider) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use ServerCapability_Provider.Descriptor instead.
+func (ServerCapability_Provider) EnumDescriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{79, 0}
 }
 
 // Photo represents a stored photo with metadata
@@ -120,7 +489,42 @@ type Photo struct {
 	// Object ID of the thumbnail image (for videos)
 	ThumbnailObjectId string `protobuf:"bytes,26,opt,name=thumbnail_object_id,json=thumbnailObjectId,proto3" json:"thumbnail_object_id,omitempty"`
 	// Object ID of the generated WebP version (for images)
-	WebpObjectId  string `protobuf:"bytes,27,opt,name=webp_object_id,json=webpObjectId,proto3" json:"webp_object_id,omitempty"`
+	WebpObjectId string `protobuf:"bytes,27,opt,name=webp_object_id,json=webpObjectId,proto3" json:"webp_object_id,omitempty"`
+	// Object ID of the attached XMP sidecar (e.g. from Lightroom)
+	SidecarObjectId string `protobuf:"bytes,28,opt,name=sidecar_object_id,json=sidecarObjectId,proto3" json:"sidecar_object_id,omitempty"`
+	// Star rating from the XMP sidecar (-1 = rejected, 0 = unrated, 1-5 = stars)
+	Rating int32 `protobuf:"varint,29,opt,name=rating,proto3" json:"rating,omitempty"`
+	// Colour label from the XMP sidecar (e.g., "Red")
+	Label string `protobuf:"bytes,30,opt,name=label,proto3" json:"label,omitempty"`
+	// Keywords from the XMP sidecar
+	Keywords []string `protobuf:"bytes,31,rep,name=keywords,proto3" json:"keywords,omitempty"`
+	// Caption from the XMP sidecar
+	Caption string `protobuf:"bytes,32,opt,name=caption,proto3" json:"caption,omitempty"`
+	// Crop from the XMP sidecar (unset if the sidecar has no crop)
+	Crop *PhotoCrop `protobuf:"bytes,33,opt,name=crop,proto3" json:"crop,omitempty"`
+	// Pre-generated fixed-size thumbnails, smallest first
+	Renditions []*PhotoRendition `protobuf:"bytes,34,rep,name=renditions,proto3" json:"renditions,omitempty"`
+	// Object ID of the generated AVIF version (for images; empty unless the
+	// server generates AVIF renditions). Smaller than the WebP version, for
+	// clients that can decode AVIF.
+	AvifObjectId string `protobuf:"bytes,35,opt,name=avif_object_id,json=avifObjectId,proto3" json:"avif_object_id,omitempty"`
+	// Object ID of the H.264/AAC MP4 proxy (for videos), playable where the
+	// original (e.g. HEVC .mov) is not
+	ProxyObjectId string `protobuf:"bytes,36,opt,name=proxy_object_id,json=proxyObjectId,proto3" json:"proxy_object_id,omitempty"`
+	// Object ID of the HLS master playlist (for videos; empty unless the server
+	// generates HLS renditions). Its variant playlists and segments sit next to
+	// it, so it can be played from the raw bytes endpoint.
+	HlsObjectId string `protobuf:"bytes,37,opt,name=hls_object_id,json=hlsObjectId,proto3" json:"hls_object_id,omitempty"`
+	// Object ID of a short, silent animated WebP of the start of a video, for
+	// playback on hover
+	AnimatedPreviewObjectId string `protobuf:"bytes,38,opt,name=animated_preview_object_id,json=animatedPreviewObjectId,proto3" json:"animated_preview_object_id,omitempty"`
+	// Object ID of the video played with a Live Photo or motion photo: the
+	// .MOV uploaded alongside an iPhone Live Photo, which is not listed on its
+	// own, or the MP4 extracted from an Android motion photo
+	CompanionObjectId string `protobuf:"bytes,39,opt,name=companion_object_id,json=companionObjectId,proto3" json:"companion_object_id,omitempty"`
+	// Stack the photo belongs to (unset if it is not stacked). ListPhotos
+	// lists the cover of a stack in place of all of its photos.
+	Stack         *PhotoStack `protobuf:"bytes,40,opt,name=stack,proto3" json:"stack,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
@@ -344,30 +748,125 @@ func (x *Photo) GetWebpObjectId() string {
 	return ""
 }
 
-// UploadRequest contains the photo data to upload
-type UploadRequest struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	ObjectId      string                 `protobuf:"bytes,1,opt,name=object_id,json=objectId,proto3" json:"object_id,omitempty"`
-	ContentType   string                 `protobuf:"bytes,2,opt,name=content_type,json=contentType,proto3" json:"content_type,omitempty"`
-	Data          []byte                 `protobuf:"bytes,3,opt,name=data,proto3" json:"data,omitempty"`
+func (x *Photo) GetSidecarObjectId() string {
+	if x != nil {
+		return x.SidecarObjectId
+	}
+	return ""
+}
+
+func (x *Photo) GetRating() int32 {
+	if x != nil {
+		return x.Rating
+	}
+	return 0
+}
+
+func (x *Photo) GetLabel() string {
+	if x != nil {
+		return x.Label
+	}
+	return ""
+}
+
+func (x *Photo) GetKeywords() []string {
+	if x != nil {
+		return x.Keywords
+	}
+	return nil
+}
+
+func (x *Photo) GetCaption() string {
+	if x != nil {
+		return x.Caption
+	}
+	return ""
+}
+
+func (x *Photo) GetCrop() *PhotoCrop {
+	if x != nil {
+		return x.Crop
+	}
+	return nil
+}
+
+func (x *Photo) GetRenditions() []*PhotoRendition {
+	if x != nil {
+		return x.Renditions
+	}
+	return nil
+}
+
+func (x *Photo) GetAvifObjectId() string {
+	if x != nil {
+		return x.AvifObjectId
+	}
+	return ""
+}
+
+func (x *Photo) GetProxyObjectId() string {
+	if x != nil {
+		return x.ProxyObjectId
+	}
+	return ""
+}
+
+func (x *Photo) GetHlsObjectId() string {
+	if x != nil {
+		return x.HlsObjectId
+	}
+	return ""
+}
+
+func (x *Photo) GetAnimatedPreviewObjectId() string {
+	if x != nil {
+		return x.AnimatedPreviewObjectId
+	}
+	return ""
+}
+
+func (x *Photo) GetCompanionObjectId() string {
+	if x != nil {
+		return x.CompanionObjectId
+	}
+	return ""
+}
+
+func (x *Photo) GetStack() *PhotoStack {
+	if x != nil {
+		return x.Stack
+	}
+	return nil
+}
+
+// PhotoStack groups photos listed as one: the RAW and JPEG files of the same
+// shot, or the frames of a burst
+type PhotoStack struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Kind of stack: "raw_jpeg" or "burst"
+	Kind string `protobuf:"bytes,1,opt,name=kind,proto3" json:"kind,omitempty"`
+	// Object ID of the photo listed in place of the stack
+	CoverObjectId string `protobuf:"bytes,2,opt,name=cover_object_id,json=coverObjectId,proto3" json:"cover_object_id,omitempty"`
+	// Number of photos in the stack
+	Count         int32 `protobuf:"varint,3,opt,name=count,proto3" json:"count,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *UploadRequest) Reset() {
-	*x = UploadRequest{}
+func (x *PhotoStack) Reset() {
+	*x = PhotoStack{}
 	mi := &file_proto_photos_proto_msgTypes[1]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *UploadRequest) String() string {
+func (x *PhotoStack) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*UploadRequest) ProtoMessage() {}
+func (*PhotoStack) ProtoMessage() {}
 
-func (x *UploadRequest) ProtoReflect() protoreflect.Message {
+func (x *PhotoStack) ProtoReflect() protoreflect.Message {
 	mi := &file_proto_photos_proto_msgTypes[1]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -379,54 +878,63 @@ func (x *UploadRequest) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use UploadRequest.ProtoReflect.Descriptor instead.
-func (*UploadRequest) Descriptor() ([]byte, []int) {
+// Deprecated: Use PhotoStack.ProtoReflect.Descriptor instead.
+func (*PhotoStack) Descriptor() ([]byte, []int) {
 	return file_proto_photos_proto_rawDescGZIP(), []int{1}
 }
 
-func (x *UploadRequest) GetObjectId() string {
+func (x *PhotoStack) GetKind() string {
 	if x != nil {
-		return x.ObjectId
+		return x.Kind
 	}
 	return ""
 }
 
-func (x *UploadRequest) GetContentType() string {
+func (x *PhotoStack) GetCoverObjectId() string {
 	if x != nil {
-		return x.ContentType
+		return x.CoverObjectId
 	}
 	return ""
 }
 
-func (x *UploadRequest) GetData() []byte {
+func (x *PhotoStack) GetCount() int32 {
 	if x != nil {
-		return x.Data
+		return x.Count
 	}
-	return nil
+	return 0
 }
 
-// UploadResponse returns the uploaded photo metadata
-type UploadResponse struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Photo         *Photo                 `protobuf:"bytes,1,opt,name=photo,proto3" json:"photo,omitempty"`
+// PhotoRendition is a pre-generated JPEG thumbnail of a photo, fitted within
+// a square of long_edge pixels.
+type PhotoRendition struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Object ID of the thumbnail
+	ObjectId string `protobuf:"bytes,1,opt,name=object_id,json=objectId,proto3" json:"object_id,omitempty"`
+	// Configured size the thumbnail was generated for, in pixels
+	LongEdge int32 `protobuf:"varint,2,opt,name=long_edge,json=longEdge,proto3" json:"long_edge,omitempty"`
+	// Actual dimensions in pixels; smaller than long_edge if the photo is
+	Width         int32  `protobuf:"varint,3,opt,name=width,proto3" json:"width,omitempty"`
+	Height        int32  `protobuf:"varint,4,opt,name=height,proto3" json:"height,omitempty"`
+	ContentType   string `protobuf:"bytes,5,opt,name=content_type,json=contentType,proto3" json:"content_type,omitempty"`
+	SizeBytes     int64  `protobuf:"varint,6,opt,name=size_bytes,json=sizeBytes,proto3" json:"size_bytes,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *UploadResponse) Reset() {
-	*x = UploadResponse{}
+func (x *PhotoRendition) Reset() {
+	*x = PhotoRendition{}
 	mi := &file_proto_photos_proto_msgTypes[2]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *UploadResponse) String() string {
+func (x *PhotoRendition) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*UploadResponse) ProtoMessage() {}
+func (*PhotoRendition) ProtoMessage() {}
 
-func (x *UploadResponse) ProtoReflect() protoreflect.Message {
+func (x *PhotoRendition) ProtoReflect() protoreflect.Message {
 	mi := &file_proto_photos_proto_msgTypes[2]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
@@ -438,16 +946,255 @@ func (x *UploadResponse) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use UploadResponse.ProtoReflect.Descriptor instead.
-func (*UploadResponse) Descriptor() ([]byte, []int) {
+// Deprecated: Use PhotoRendition.ProtoReflect.Descriptor instead.
+func (*PhotoRendition) Descriptor() ([]byte, []int) {
 	return file_proto_photos_proto_rawDescGZIP(), []int{2}
 }
 
-func (x *UploadResponse) GetPhoto() *Photo {
+func (x *PhotoRendition) GetObjectId() string {
 	if x != nil {
-		return x.Photo
+		return x.ObjectId
 	}
-	return nil
+	return ""
+}
+
+func (x *PhotoRendition) GetLongEdge() int32 {
+	if x != nil {
+		return x.LongEdge
+	}
+	return 0
+}
+
+func (x *PhotoRendition) GetWidth() int32 {
+	if x != nil {
+		return x.Width
+	}
+	return 0
+}
+
+func (x *PhotoRendition) GetHeight() int32 {
+	if x != nil {
+		return x.Height
+	}
+	return 0
+}
+
+func (x *PhotoRendition) GetContentType() string {
+	if x != nil {
+		return x.ContentType
+	}
+	return ""
+}
+
+func (x *PhotoRendition) GetSizeBytes() int64 {
+	if x != nil {
+		return x.SizeBytes
+	}
+	return 0
+}
+
+// PhotoCrop is a crop rectangle expressed as fractions (0-1) of the original
+// image dimensions, as recorded by Lightroom / Camera Raw
+type PhotoCrop struct {
+	state  protoimpl.MessageState `protogen:"open.v1"`
+	Top    float64                `protobuf:"fixed64,1,opt,name=top,proto3" json:"top,omitempty"`
+	Left   float64                `protobuf:"fixed64,2,opt,name=left,proto3" json:"left,omitempty"`
+	Bottom float64                `protobuf:"fixed64,3,opt,name=bottom,proto3" json:"bottom,omitempty"`
+	Right  float64                `protobuf:"fixed64,4,opt,name=right,proto3" json:"right,omitempty"`
+	// Straighten angle in degrees
+	Angle         float64 `protobuf:"fixed64,5,opt,name=angle,proto3" json:"angle,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PhotoCrop) Reset() {
+	*x = PhotoCrop{}
+	mi := &file_proto_photos_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PhotoCrop) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PhotoCrop) ProtoMessage() {}
+
+func (x *PhotoCrop) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PhotoCrop.ProtoReflect.Descriptor instead.
+func (*PhotoCrop) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *PhotoCrop) GetTop() float64 {
+	if x != nil {
+		return x.Top
+	}
+	return 0
+}
+
+func (x *PhotoCrop) GetLeft() float64 {
+	if x != nil {
+		return x.Left
+	}
+	return 0
+}
+
+func (x *PhotoCrop) GetBottom() float64 {
+	if x != nil {
+		return x.Bottom
+	}
+	return 0
+}
+
+func (x *PhotoCrop) GetRight() float64 {
+	if x != nil {
+		return x.Right
+	}
+	return 0
+}
+
+func (x *PhotoCrop) GetAngle() float64 {
+	if x != nil {
+		return x.Angle
+	}
+	return 0
+}
+
+// UploadRequest contains the photo data to upload
+type UploadRequest struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	ObjectId    string                 `protobuf:"bytes,1,opt,name=object_id,json=objectId,proto3" json:"object_id,omitempty"`
+	ContentType string                 `protobuf:"bytes,2,opt,name=content_type,json=contentType,proto3" json:"content_type,omitempty"`
+	Data        []byte                 `protobuf:"bytes,3,opt,name=data,proto3" json:"data,omitempty"`
+	// conflict_policy decides what happens when object_id already exists
+	ConflictPolicy ConflictPolicy `protobuf:"varint,4,opt,name=conflict_policy,json=conflictPolicy,proto3,enum=photos.ConflictPolicy" json:"conflict_policy,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *UploadRequest) Reset() {
+	*x = UploadRequest{}
+	mi := &file_proto_photos_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UploadRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UploadRequest) ProtoMessage() {}
+
+func (x *UploadRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UploadRequest.ProtoReflect.Descriptor instead.
+func (*UploadRequest) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *UploadRequest) GetObjectId() string {
+	if x != nil {
+		return x.ObjectId
+	}
+	return ""
+}
+
+func (x *UploadRequest) GetContentType() string {
+	if x != nil {
+		return x.ContentType
+	}
+	return ""
+}
+
+func (x *UploadRequest) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *UploadRequest) GetConflictPolicy() ConflictPolicy {
+	if x != nil {
+		return x.ConflictPolicy
+	}
+	return ConflictPolicy_CONFLICT_POLICY_UNSPECIFIED
+}
+
+// UploadResponse returns the uploaded photo metadata
+type UploadResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Photo *Photo                 `protobuf:"bytes,1,opt,name=photo,proto3" json:"photo,omitempty"`
+	// skipped is true when an identical object already existed and the upload
+	// was not written (CONFLICT_POLICY_SKIP_IF_IDENTICAL)
+	Skipped       bool `protobuf:"varint,2,opt,name=skipped,proto3" json:"skipped,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UploadResponse) Reset() {
+	*x = UploadResponse{}
+	mi := &file_proto_photos_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UploadResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UploadResponse) ProtoMessage() {}
+
+func (x *UploadResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UploadResponse.ProtoReflect.Descriptor instead.
+func (*UploadResponse) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *UploadResponse) GetPhoto() *Photo {
+	if x != nil {
+		return x.Photo
+	}
+	return nil
+}
+
+func (x *UploadResponse) GetSkipped() bool {
+	if x != nil {
+		return x.Skipped
+	}
+	return false
 }
 
 // DownloadRequest specifies which photo to retrieve
@@ -462,7 +1209,7 @@ type DownloadRequest struct {
 
 func (x *DownloadRequest) Reset() {
 	*x = DownloadRequest{}
-	mi := &file_proto_photos_proto_msgTypes[3]
+	mi := &file_proto_photos_proto_msgTypes[6]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -474,7 +1221,7 @@ func (x *DownloadRequest) String() string {
 func (*DownloadRequest) ProtoMessage() {}
 
 func (x *DownloadRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_photos_proto_msgTypes[3]
+	mi := &file_proto_photos_proto_msgTypes[6]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -487,7 +1234,7 @@ func (x *DownloadRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use DownloadRequest.ProtoReflect.Descriptor instead.
 func (*DownloadRequest) Descriptor() ([]byte, []int) {
-	return file_proto_photos_proto_rawDescGZIP(), []int{3}
+	return file_proto_photos_proto_rawDescGZIP(), []int{6}
 }
 
 func (x *DownloadRequest) GetObjectId() string {
@@ -515,7 +1262,7 @@ type DownloadResponse struct {
 
 func (x *DownloadResponse) Reset() {
 	*x = DownloadResponse{}
-	mi := &file_proto_photos_proto_msgTypes[4]
+	mi := &file_proto_photos_proto_msgTypes[7]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -527,7 +1274,7 @@ func (x *DownloadResponse) String() string {
 func (*DownloadResponse) ProtoMessage() {}
 
 func (x *DownloadResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_photos_proto_msgTypes[4]
+	mi := &file_proto_photos_proto_msgTypes[7]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -540,7 +1287,7 @@ func (x *DownloadResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use DownloadResponse.ProtoReflect.Descriptor instead.
 func (*DownloadResponse) Descriptor() ([]byte, []int) {
-	return file_proto_photos_proto_rawDescGZIP(), []int{4}
+	return file_proto_photos_proto_rawDescGZIP(), []int{7}
 }
 
 func (x *DownloadResponse) GetPhoto() *Photo {
@@ -567,7 +1314,7 @@ type DeletePhotoRequest struct {
 
 func (x *DeletePhotoRequest) Reset() {
 	*x = DeletePhotoRequest{}
-	mi := &file_proto_photos_proto_msgTypes[5]
+	mi := &file_proto_photos_proto_msgTypes[8]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -579,7 +1326,7 @@ func (x *DeletePhotoRequest) String() string {
 func (*DeletePhotoRequest) ProtoMessage() {}
 
 func (x *DeletePhotoRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_photos_proto_msgTypes[5]
+	mi := &file_proto_photos_proto_msgTypes[8]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -592,7 +1339,7 @@ func (x *DeletePhotoRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use DeletePhotoRequest.ProtoReflect.Descriptor instead.
 func (*DeletePhotoRequest) Descriptor() ([]byte, []int) {
-	return file_proto_photos_proto_rawDescGZIP(), []int{5}
+	return file_proto_photos_proto_rawDescGZIP(), []int{8}
 }
 
 func (x *DeletePhotoRequest) GetObjectId() string {
@@ -612,7 +1359,7 @@ type DeletePhotoResponse struct {
 
 func (x *DeletePhotoResponse) Reset() {
 	*x = DeletePhotoResponse{}
-	mi := &file_proto_photos_proto_msgTypes[6]
+	mi := &file_proto_photos_proto_msgTypes[9]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -624,7 +1371,7 @@ func (x *DeletePhotoResponse) String() string {
 func (*DeletePhotoResponse) ProtoMessage() {}
 
 func (x *DeletePhotoResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_photos_proto_msgTypes[6]
+	mi := &file_proto_photos_proto_msgTypes[9]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -637,7 +1384,7 @@ func (x *DeletePhotoResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use DeletePhotoResponse.ProtoReflect.Descriptor instead.
 func (*DeletePhotoResponse) Descriptor() ([]byte, []int) {
-	return file_proto_photos_proto_rawDescGZIP(), []int{6}
+	return file_proto_photos_proto_rawDescGZIP(), []int{9}
 }
 
 func (x *DeletePhotoResponse) GetSuccess() bool {
@@ -657,7 +1404,7 @@ type GetPhotoRequest struct {
 
 func (x *GetPhotoRequest) Reset() {
 	*x = GetPhotoRequest{}
-	mi := &file_proto_photos_proto_msgTypes[7]
+	mi := &file_proto_photos_proto_msgTypes[10]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -669,7 +1416,7 @@ func (x *GetPhotoRequest) String() string {
 func (*GetPhotoRequest) ProtoMessage() {}
 
 func (x *GetPhotoRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_photos_proto_msgTypes[7]
+	mi := &file_proto_photos_proto_msgTypes[10]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -682,7 +1429,7 @@ func (x *GetPhotoRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use GetPhotoRequest.ProtoReflect.Descriptor instead.
 func (*GetPhotoRequest) Descriptor() ([]byte, []int) {
-	return file_proto_photos_proto_rawDescGZIP(), []int{7}
+	return file_proto_photos_proto_rawDescGZIP(), []int{10}
 }
 
 func (x *GetPhotoRequest) GetObjectId() string {
@@ -702,7 +1449,7 @@ type GetPhotoResponse struct {
 
 func (x *GetPhotoResponse) Reset() {
 	*x = GetPhotoResponse{}
-	mi := &file_proto_photos_proto_msgTypes[8]
+	mi := &file_proto_photos_proto_msgTypes[11]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -714,7 +1461,7 @@ func (x *GetPhotoResponse) String() string {
 func (*GetPhotoResponse) ProtoMessage() {}
 
 func (x *GetPhotoResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_photos_proto_msgTypes[8]
+	mi := &file_proto_photos_proto_msgTypes[11]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -727,7 +1474,7 @@ func (x *GetPhotoResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use GetPhotoResponse.ProtoReflect.Descriptor instead.
 func (*GetPhotoResponse) Descriptor() ([]byte, []int) {
-	return file_proto_photos_proto_rawDescGZIP(), []int{8}
+	return file_proto_photos_proto_rawDescGZIP(), []int{11}
 }
 
 func (x *GetPhotoResponse) GetPhoto() *Photo {
@@ -749,7 +1496,7 @@ type ListPhotosRequest struct {
 
 func (x *ListPhotosRequest) Reset() {
 	*x = ListPhotosRequest{}
-	mi := &file_proto_photos_proto_msgTypes[9]
+	mi := &file_proto_photos_proto_msgTypes[12]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -761,7 +1508,7 @@ func (x *ListPhotosRequest) String() string {
 func (*ListPhotosRequest) ProtoMessage() {}
 
 func (x *ListPhotosRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_photos_proto_msgTypes[9]
+	mi := &file_proto_photos_proto_msgTypes[12]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -774,7 +1521,7 @@ func (x *ListPhotosRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use ListPhotosRequest.ProtoReflect.Descriptor instead.
 func (*ListPhotosRequest) Descriptor() ([]byte, []int) {
-	return file_proto_photos_proto_rawDescGZIP(), []int{9}
+	return file_proto_photos_proto_rawDescGZIP(), []int{12}
 }
 
 func (x *ListPhotosRequest) GetPageSize() int32 {
@@ -810,7 +1557,7 @@ type ListPhotosResponse struct {
 
 func (x *ListPhotosResponse) Reset() {
 	*x = ListPhotosResponse{}
-	mi := &file_proto_photos_proto_msgTypes[10]
+	mi := &file_proto_photos_proto_msgTypes[13]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -822,7 +1569,7 @@ func (x *ListPhotosResponse) String() string {
 func (*ListPhotosResponse) ProtoMessage() {}
 
 func (x *ListPhotosResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_photos_proto_msgTypes[10]
+	mi := &file_proto_photos_proto_msgTypes[13]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -835,7 +1582,7 @@ func (x *ListPhotosResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use ListPhotosResponse.ProtoReflect.Descriptor instead.
 func (*ListPhotosResponse) Descriptor() ([]byte, []int) {
-	return file_proto_photos_proto_rawDescGZIP(), []int{10}
+	return file_proto_photos_proto_rawDescGZIP(), []int{13}
 }
 
 func (x *ListPhotosResponse) GetPhotos() []*Photo {
@@ -870,7 +1617,7 @@ type CopyPhotoRequest struct {
 
 func (x *CopyPhotoRequest) Reset() {
 	*x = CopyPhotoRequest{}
-	mi := &file_proto_photos_proto_msgTypes[11]
+	mi := &file_proto_photos_proto_msgTypes[14]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -882,7 +1629,7 @@ func (x *CopyPhotoRequest) String() string {
 func (*CopyPhotoRequest) ProtoMessage() {}
 
 func (x *CopyPhotoRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_photos_proto_msgTypes[11]
+	mi := &file_proto_photos_proto_msgTypes[14]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -895,7 +1642,7 @@ func (x *CopyPhotoRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use CopyPhotoRequest.ProtoReflect.Descriptor instead.
 func (*CopyPhotoRequest) Descriptor() ([]byte, []int) {
-	return file_proto_photos_proto_rawDescGZIP(), []int{11}
+	return file_proto_photos_proto_rawDescGZIP(), []int{14}
 }
 
 func (x *CopyPhotoRequest) GetSourceObjectId() string {
@@ -922,7 +1669,7 @@ type CopyPhotoResponse struct {
 
 func (x *CopyPhotoResponse) Reset() {
 	*x = CopyPhotoResponse{}
-	mi := &file_proto_photos_proto_msgTypes[12]
+	mi := &file_proto_photos_proto_msgTypes[15]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -934,7 +1681,7 @@ func (x *CopyPhotoResponse) String() string {
 func (*CopyPhotoResponse) ProtoMessage() {}
 
 func (x *CopyPhotoResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_photos_proto_msgTypes[12]
+	mi := &file_proto_photos_proto_msgTypes[15]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -947,7 +1694,7 @@ func (x *CopyPhotoResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use CopyPhotoResponse.ProtoReflect.Descriptor instead.
 func (*CopyPhotoResponse) Descriptor() ([]byte, []int) {
-	return file_proto_photos_proto_rawDescGZIP(), []int{12}
+	return file_proto_photos_proto_rawDescGZIP(), []int{15}
 }
 
 func (x *CopyPhotoResponse) GetPhoto() *Photo {
@@ -968,7 +1715,7 @@ type RenamePhotoRequest struct {
 
 func (x *RenamePhotoRequest) Reset() {
 	*x = RenamePhotoRequest{}
-	mi := &file_proto_photos_proto_msgTypes[13]
+	mi := &file_proto_photos_proto_msgTypes[16]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -980,7 +1727,7 @@ func (x *RenamePhotoRequest) String() string {
 func (*RenamePhotoRequest) ProtoMessage() {}
 
 func (x *RenamePhotoRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_photos_proto_msgTypes[13]
+	mi := &file_proto_photos_proto_msgTypes[16]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -993,7 +1740,7 @@ func (x *RenamePhotoRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use RenamePhotoRequest.ProtoReflect.Descriptor instead.
 func (*RenamePhotoRequest) Descriptor() ([]byte, []int) {
-	return file_proto_photos_proto_rawDescGZIP(), []int{13}
+	return file_proto_photos_proto_rawDescGZIP(), []int{16}
 }
 
 func (x *RenamePhotoRequest) GetSourceObjectId() string {
@@ -1020,7 +1767,7 @@ type RenamePhotoResponse struct {
 
 func (x *RenamePhotoResponse) Reset() {
 	*x = RenamePhotoResponse{}
-	mi := &file_proto_photos_proto_msgTypes[14]
+	mi := &file_proto_photos_proto_msgTypes[17]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -1032,7 +1779,7 @@ func (x *RenamePhotoResponse) String() string {
 func (*RenamePhotoResponse) ProtoMessage() {}
 
 func (x *RenamePhotoResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_photos_proto_msgTypes[14]
+	mi := &file_proto_photos_proto_msgTypes[17]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1045,7 +1792,7 @@ func (x *RenamePhotoResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use RenamePhotoResponse.ProtoReflect.Descriptor instead.
 func (*RenamePhotoResponse) Descriptor() ([]byte, []int) {
-	return file_proto_photos_proto_rawDescGZIP(), []int{14}
+	return file_proto_photos_proto_rawDescGZIP(), []int{17}
 }
 
 func (x *RenamePhotoResponse) GetPhoto() *Photo {
@@ -1067,7 +1814,7 @@ type UpdatePhotoMetadataRequest struct {
 
 func (x *UpdatePhotoMetadataRequest) Reset() {
 	*x = UpdatePhotoMetadataRequest{}
-	mi := &file_proto_photos_proto_msgTypes[15]
+	mi := &file_proto_photos_proto_msgTypes[18]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -1079,7 +1826,7 @@ func (x *UpdatePhotoMetadataRequest) String() string {
 func (*UpdatePhotoMetadataRequest) ProtoMessage() {}
 
 func (x *UpdatePhotoMetadataRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_photos_proto_msgTypes[15]
+	mi := &file_proto_photos_proto_msgTypes[18]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1092,7 +1839,7 @@ func (x *UpdatePhotoMetadataRequest) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use UpdatePhotoMetadataRequest.ProtoReflect.Descriptor instead.
 func (*UpdatePhotoMetadataRequest) Descriptor() ([]byte, []int) {
-	return file_proto_photos_proto_rawDescGZIP(), []int{15}
+	return file_proto_photos_proto_rawDescGZIP(), []int{18}
 }
 
 func (x *UpdatePhotoMetadataRequest) GetObjectId() string {
@@ -1126,7 +1873,7 @@ type UpdatePhotoMetadataResponse struct {
 
 func (x *UpdatePhotoMetadataResponse) Reset() {
 	*x = UpdatePhotoMetadataResponse{}
-	mi := &file_proto_photos_proto_msgTypes[16]
+	mi := &file_proto_photos_proto_msgTypes[19]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
@@ -1138,7 +1885,7 @@ func (x *UpdatePhotoMetadataResponse) String() string {
 func (*UpdatePhotoMetadataResponse) ProtoMessage() {}
 
 func (x *UpdatePhotoMetadataResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_photos_proto_msgTypes[16]
+	mi := &file_proto_photos_proto_msgTypes[19]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1151,7 +1898,7 @@ func (x *UpdatePhotoMetadataResponse) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use UpdatePhotoMetadataResponse.ProtoReflect.Descriptor instead.
 func (*UpdatePhotoMetadataResponse) Descriptor() ([]byte, []int) {
-	return file_proto_photos_proto_rawDescGZIP(), []int{16}
+	return file_proto_photos_proto_rawDescGZIP(), []int{19}
 }
 
 func (x *UpdatePhotoMetadataResponse) GetPhoto() *Photo {
@@ -1161,31 +1908,29 @@ func (x *UpdatePhotoMetadataResponse) GetPhoto() *Photo {
 	return nil
 }
 
-// GenerateSignedUrlRequest specifies parameters for signed URL generation
-type GenerateSignedUrlRequest struct {
-	state             protoimpl.MessageState `protogen:"open.v1"`
-	ObjectId          string                 `protobuf:"bytes,1,opt,name=object_id,json=objectId,proto3" json:"object_id,omitempty"`
-	ExpirationSeconds int64                  `protobuf:"varint,2,opt,name=expiration_seconds,json=expirationSeconds,proto3" json:"expiration_seconds,omitempty"`
-	Method            string                 `protobuf:"bytes,3,opt,name=method,proto3" json:"method,omitempty"`
-	unknownFields     protoimpl.UnknownFields
-	sizeCache         protoimpl.SizeCache
+// GetStackRequest specifies a photo of the stack to expand
+type GetStackRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ObjectId      string                 `protobuf:"bytes,1,opt,name=object_id,json=objectId,proto3" json:"object_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
 }
 
-func (x *GenerateSignedUrlRequest) Reset() {
-	*x = GenerateSignedUrlRequest{}
-	mi := &file_proto_photos_proto_msgTypes[17]
+func (x *GetStackRequest) Reset() {
+	*x = GetStackRequest{}
+	mi := &file_proto_photos_proto_msgTypes[20]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *GenerateSignedUrlRequest) String() string {
+func (x *GetStackRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*GenerateSignedUrlRequest) ProtoMessage() {}
+func (*GetStackRequest) ProtoMessage() {}
 
-func (x *GenerateSignedUrlRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_photos_proto_msgTypes[17]
+func (x *GetStackRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[20]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1196,56 +1941,42 @@ func (x *GenerateSignedUrlRequest) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use GenerateSignedUrlRequest.ProtoReflect.Descriptor instead.
-func (*GenerateSignedUrlRequest) Descriptor() ([]byte, []int) {
-	return file_proto_photos_proto_rawDescGZIP(), []int{17}
+// Deprecated: Use GetStackRequest.ProtoReflect.Descriptor instead.
+func (*GetStackRequest) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{20}
 }
 
-func (x *GenerateSignedUrlRequest) GetObjectId() string {
+func (x *GetStackRequest) GetObjectId() string {
 	if x != nil {
 		return x.ObjectId
 	}
 	return ""
 }
 
-func (x *GenerateSignedUrlRequest) GetExpirationSeconds() int64 {
-	if x != nil {
-		return x.ExpirationSeconds
-	}
-	return 0
-}
-
-func (x *GenerateSignedUrlRequest) GetMethod() string {
-	if x != nil {
-		return x.Method
-	}
-	return ""
-}
-
-// GenerateSignedUrlResponse returns the signed URL and expiration time
-type GenerateSignedUrlResponse struct {
+// GetStackResponse returns the stack and its photos, cover first
+type GetStackResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	SignedUrl     string                 `protobuf:"bytes,1,opt,name=signed_url,json=signedUrl,proto3" json:"signed_url,omitempty"`
-	ExpiresAt     string                 `protobuf:"bytes,2,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`
+	Stack         *PhotoStack            `protobuf:"bytes,1,opt,name=stack,proto3" json:"stack,omitempty"`
+	Photos        []*Photo               `protobuf:"bytes,2,rep,name=photos,proto3" json:"photos,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *GenerateSignedUrlResponse) Reset() {
-	*x = GenerateSignedUrlResponse{}
-	mi := &file_proto_photos_proto_msgTypes[18]
+func (x *GetStackResponse) Reset() {
+	*x = GetStackResponse{}
+	mi := &file_proto_photos_proto_msgTypes[21]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *GenerateSignedUrlResponse) String() string {
+func (x *GetStackResponse) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*GenerateSignedUrlResponse) ProtoMessage() {}
+func (*GetStackResponse) ProtoMessage() {}
 
-func (x *GenerateSignedUrlResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_photos_proto_msgTypes[18]
+func (x *GetStackResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[21]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1256,48 +1987,48 @@ func (x *GenerateSignedUrlResponse) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use GenerateSignedUrlResponse.ProtoReflect.Descriptor instead.
-func (*GenerateSignedUrlResponse) Descriptor() ([]byte, []int) {
-	return file_proto_photos_proto_rawDescGZIP(), []int{18}
+// Deprecated: Use GetStackResponse.ProtoReflect.Descriptor instead.
+func (*GetStackResponse) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{21}
 }
 
-func (x *GenerateSignedUrlResponse) GetSignedUrl() string {
+func (x *GetStackResponse) GetStack() *PhotoStack {
 	if x != nil {
-		return x.SignedUrl
+		return x.Stack
 	}
-	return ""
+	return nil
 }
 
-func (x *GenerateSignedUrlResponse) GetExpiresAt() string {
+func (x *GetStackResponse) GetPhotos() []*Photo {
 	if x != nil {
-		return x.ExpiresAt
+		return x.Photos
 	}
-	return ""
+	return nil
 }
 
-// PhotoExistsRequest specifies which photo to check for existence
-type PhotoExistsRequest struct {
+// SetStackCoverRequest specifies the photo to list in place of its stack
+type SetStackCoverRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
 	ObjectId      string                 `protobuf:"bytes,1,opt,name=object_id,json=objectId,proto3" json:"object_id,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *PhotoExistsRequest) Reset() {
-	*x = PhotoExistsRequest{}
-	mi := &file_proto_photos_proto_msgTypes[19]
+func (x *SetStackCoverRequest) Reset() {
+	*x = SetStackCoverRequest{}
+	mi := &file_proto_photos_proto_msgTypes[22]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *PhotoExistsRequest) String() string {
+func (x *SetStackCoverRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*PhotoExistsRequest) ProtoMessage() {}
+func (*SetStackCoverRequest) ProtoMessage() {}
 
-func (x *PhotoExistsRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_photos_proto_msgTypes[19]
+func (x *SetStackCoverRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[22]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1308,41 +2039,41 @@ func (x *PhotoExistsRequest) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use PhotoExistsRequest.ProtoReflect.Descriptor instead.
-func (*PhotoExistsRequest) Descriptor() ([]byte, []int) {
-	return file_proto_photos_proto_rawDescGZIP(), []int{19}
+// Deprecated: Use SetStackCoverRequest.ProtoReflect.Descriptor instead.
+func (*SetStackCoverRequest) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{22}
 }
 
-func (x *PhotoExistsRequest) GetObjectId() string {
+func (x *SetStackCoverRequest) GetObjectId() string {
 	if x != nil {
 		return x.ObjectId
 	}
 	return ""
 }
 
-// PhotoExistsResponse returns whether the photo exists
-type PhotoExistsResponse struct {
+// SetStackCoverResponse returns the updated stack
+type SetStackCoverResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Exists        bool                   `protobuf:"varint,1,opt,name=exists,proto3" json:"exists,omitempty"`
+	Stack         *PhotoStack            `protobuf:"bytes,1,opt,name=stack,proto3" json:"stack,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *PhotoExistsResponse) Reset() {
-	*x = PhotoExistsResponse{}
-	mi := &file_proto_photos_proto_msgTypes[20]
+func (x *SetStackCoverResponse) Reset() {
+	*x = SetStackCoverResponse{}
+	mi := &file_proto_photos_proto_msgTypes[23]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *PhotoExistsResponse) String() string {
+func (x *SetStackCoverResponse) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*PhotoExistsResponse) ProtoMessage() {}
+func (*SetStackCoverResponse) ProtoMessage() {}
 
-func (x *PhotoExistsResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_photos_proto_msgTypes[20]
+func (x *SetStackCoverResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[23]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1353,42 +2084,41 @@ func (x *PhotoExistsResponse) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use PhotoExistsResponse.ProtoReflect.Descriptor instead.
-func (*PhotoExistsResponse) Descriptor() ([]byte, []int) {
-	return file_proto_photos_proto_rawDescGZIP(), []int{20}
+// Deprecated: Use SetStackCoverResponse.ProtoReflect.Descriptor instead.
+func (*SetStackCoverResponse) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{23}
 }
 
-func (x *PhotoExistsResponse) GetExists() bool {
+func (x *SetStackCoverResponse) GetStack() *PhotoStack {
 	if x != nil {
-		return x.Exists
+		return x.Stack
 	}
-	return false
+	return nil
 }
 
-// ListDirectoriesRequest specifies the prefix and pagination for listing directories
-type ListDirectoriesRequest struct {
+// UnstackRequest specifies a photo of the stack to split up
+type UnstackRequest struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Prefix        string                 `protobuf:"bytes,1,opt,name=prefix,proto3" json:"prefix,omitempty"`
-	Recursive     bool                   `protobuf:"varint,2,opt,name=recursive,proto3" json:"recursive,omitempty"`
+	ObjectId      string                 `protobuf:"bytes,1,opt,name=object_id,json=objectId,proto3" json:"object_id,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *ListDirectoriesRequest) Reset() {
-	*x = ListDirectoriesRequest{}
-	mi := &file_proto_photos_proto_msgTypes[21]
+func (x *UnstackRequest) Reset() {
+	*x = UnstackRequest{}
+	mi := &file_proto_photos_proto_msgTypes[24]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *ListDirectoriesRequest) String() string {
+func (x *UnstackRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*ListDirectoriesRequest) ProtoMessage() {}
+func (*UnstackRequest) ProtoMessage() {}
 
-func (x *ListDirectoriesRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_photos_proto_msgTypes[21]
+func (x *UnstackRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[24]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1399,48 +2129,42 @@ func (x *ListDirectoriesRequest) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use ListDirectoriesRequest.ProtoReflect.Descriptor instead.
-func (*ListDirectoriesRequest) Descriptor() ([]byte, []int) {
-	return file_proto_photos_proto_rawDescGZIP(), []int{21}
+// Deprecated: Use UnstackRequest.ProtoReflect.Descriptor instead.
+func (*UnstackRequest) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{24}
 }
 
-func (x *ListDirectoriesRequest) GetPrefix() string {
+func (x *UnstackRequest) GetObjectId() string {
 	if x != nil {
-		return x.Prefix
+		return x.ObjectId
 	}
 	return ""
 }
 
-func (x *ListDirectoriesRequest) GetRecursive() bool {
-	if x != nil {
-		return x.Recursive
-	}
-	return false
-}
-
-// ListDirectoriesResponse returns directory prefixes
-type ListDirectoriesResponse struct {
+// UnstackResponse returns the photos of the former stack, which are listed
+// individually and not stacked automatically again
+type UnstackResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
-	Prefixes      []string               `protobuf:"bytes,1,rep,name=prefixes,proto3" json:"prefixes,omitempty"`
+	ObjectIds     []string               `protobuf:"bytes,1,rep,name=object_ids,json=objectIds,proto3" json:"object_ids,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *ListDirectoriesResponse) Reset() {
-	*x = ListDirectoriesResponse{}
-	mi := &file_proto_photos_proto_msgTypes[22]
+func (x *UnstackResponse) Reset() {
+	*x = UnstackResponse{}
+	mi := &file_proto_photos_proto_msgTypes[25]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *ListDirectoriesResponse) String() string {
+func (x *UnstackResponse) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*ListDirectoriesResponse) ProtoMessage() {}
+func (*UnstackResponse) ProtoMessage() {}
 
-func (x *ListDirectoriesResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_photos_proto_msgTypes[22]
+func (x *UnstackResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[25]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1451,47 +2175,43 @@ func (x *ListDirectoriesResponse) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use ListDirectoriesResponse.ProtoReflect.Descriptor instead.
-func (*ListDirectoriesResponse) Descriptor() ([]byte, []int) {
-	return file_proto_photos_proto_rawDescGZIP(), []int{22}
+// Deprecated: Use UnstackResponse.ProtoReflect.Descriptor instead.
+func (*UnstackResponse) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{25}
 }
 
-func (x *ListDirectoriesResponse) GetPrefixes() []string {
+func (x *UnstackResponse) GetObjectIds() []string {
 	if x != nil {
-		return x.Prefixes
+		return x.ObjectIds
 	}
 	return nil
 }
 
-// SyncDatabaseRequest specifies options for database synchronization
-type SyncDatabaseRequest struct {
-	state protoimpl.MessageState `protogen:"open.v1"`
-	// If true, downloads each photo file to extract EXIF metadata,
-	// updates GCS object metadata, and sets time_taken in the database
-	UpdateMetadata bool `protobuf:"varint,1,opt,name=update_metadata,json=updateMetadata,proto3" json:"update_metadata,omitempty"`
-	// Seconds to sleep between per-object metadata updates. Used to reduce CPU
-	// pressure on the server during large syncs. Only effective when
-	// update_metadata is true.
-	PauseBetweenObjectsSeconds uint32 `protobuf:"varint,2,opt,name=pause_between_objects_seconds,json=pauseBetweenObjectsSeconds,proto3" json:"pause_between_objects_seconds,omitempty"`
-	unknownFields              protoimpl.UnknownFields
-	sizeCache                  protoimpl.SizeCache
+// GenerateSignedUrlRequest specifies parameters for signed URL generation
+type GenerateSignedUrlRequest struct {
+	state             protoimpl.MessageState `protogen:"open.v1"`
+	ObjectId          string                 `protobuf:"bytes,1,opt,name=object_id,json=objectId,proto3" json:"object_id,omitempty"`
+	ExpirationSeconds int64                  `protobuf:"varint,2,opt,name=expiration_seconds,json=expirationSeconds,proto3" json:"expiration_seconds,omitempty"`
+	Method            string                 `protobuf:"bytes,3,opt,name=method,proto3" json:"method,omitempty"`
+	unknownFields     protoimpl.UnknownFields
+	sizeCache         protoimpl.SizeCache
 }
 
-func (x *SyncDatabaseRequest) Reset() {
-	*x = SyncDatabaseRequest{}
-	mi := &file_proto_photos_proto_msgTypes[23]
+func (x *GenerateSignedUrlRequest) Reset() {
+	*x = GenerateSignedUrlRequest{}
+	mi := &file_proto_photos_proto_msgTypes[26]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *SyncDatabaseRequest) String() string {
+func (x *GenerateSignedUrlRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*SyncDatabaseRequest) ProtoMessage() {}
+func (*GenerateSignedUrlRequest) ProtoMessage() {}
 
-func (x *SyncDatabaseRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_photos_proto_msgTypes[23]
+func (x *GenerateSignedUrlRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[26]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1502,66 +2222,2527 @@ func (x *SyncDatabaseRequest) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use SyncDatabaseRequest.ProtoReflect.Descriptor instead.
-func (*SyncDatabaseRequest) Descriptor() ([]byte, []int) {
-	return file_proto_photos_proto_rawDescGZIP(), []int{23}
+// Deprecated: Use GenerateSignedUrlRequest.ProtoReflect.Descriptor instead.
+func (*GenerateSignedUrlRequest) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{26}
 }
 
-func (x *SyncDatabaseRequest) GetUpdateMetadata() bool {
+func (x *GenerateSignedUrlRequest) GetObjectId() string {
 	if x != nil {
-		return x.UpdateMetadata
+		return x.ObjectId
 	}
-	return false
+	return ""
 }
 
-func (x *SyncDatabaseRequest) GetPauseBetweenObjectsSeconds() uint32 {
+func (x *GenerateSignedUrlRequest) GetExpirationSeconds() int64 {
 	if x != nil {
-		return x.PauseBetweenObjectsSeconds
+		return x.ExpirationSeconds
 	}
 	return 0
 }
 
-// SyncDatabaseProgress is streamed from SyncDatabase as it advances through
-// its phases. A message is emitted per processed object, plus one final
-// message with complete=true summarising the run.
-type SyncDatabaseProgress struct {
-	state protoimpl.MessageState `protogen:"open.v1"`
-	// phase is the sync phase this message refers to.
-	Phase SyncDatabaseProgress_Phase `protobuf:"varint,1,opt,name=phase,proto3,enum=photos.SyncDatabaseProgress_Phase" json:"phase,omitempty"`
-	// processed is the number of database objects processed so far in this phase.
-	Processed uint32 `protobuf:"varint,2,opt,name=processed,proto3" json:"processed,omitempty"`
-	// total is the number of database objects to process in this phase.
-	Total uint32 `protobuf:"varint,3,opt,name=total,proto3" json:"total,omitempty"`
-	// added is the cumulative count of newly inserted objects (populated on the
-	// final message).
-	Added uint32 `protobuf:"varint,4,opt,name=added,proto3" json:"added,omitempty"`
-	// removed is the cumulative count of deleted objects (populated on the final
-	// message).
-	Removed uint32 `protobuf:"varint,5,opt,name=removed,proto3" json:"removed,omitempty"`
-	// metadata_updated is the cumulative count of objects whose metadata was
-	// refreshed (populated on the final message).
-	MetadataUpdated uint32 `protobuf:"varint,6,opt,name=metadata_updated,json=metadataUpdated,proto3" json:"metadata_updated,omitempty"`
-	// complete is set on the final summary message of the run.
-	Complete      bool `protobuf:"varint,7,opt,name=complete,proto3" json:"complete,omitempty"`
+func (x *GenerateSignedUrlRequest) GetMethod() string {
+	if x != nil {
+		return x.Method
+	}
+	return ""
+}
+
+// GenerateSignedUrlResponse returns the signed URL and expiration time
+type GenerateSignedUrlResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	SignedUrl     string                 `protobuf:"bytes,1,opt,name=signed_url,json=signedUrl,proto3" json:"signed_url,omitempty"`
+	ExpiresAt     string                 `protobuf:"bytes,2,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *SyncDatabaseProgress) Reset() {
-	*x = SyncDatabaseProgress{}
-	mi := &file_proto_photos_proto_msgTypes[24]
-	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+func (x *GenerateSignedUrlResponse) Reset() {
+	*x = GenerateSignedUrlResponse{}
+	mi := &file_proto_photos_proto_msgTypes[27]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GenerateSignedUrlResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GenerateSignedUrlResponse) ProtoMessage() {}
+
+func (x *GenerateSignedUrlResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[27]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GenerateSignedUrlResponse.ProtoReflect.Descriptor instead.
+func (*GenerateSignedUrlResponse) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{27}
+}
+
+func (x *GenerateSignedUrlResponse) GetSignedUrl() string {
+	if x != nil {
+		return x.SignedUrl
+	}
+	return ""
+}
+
+func (x *GenerateSignedUrlResponse) GetExpiresAt() string {
+	if x != nil {
+		return x.ExpiresAt
+	}
+	return ""
+}
+
+// PhotoExistsRequest specifies which photo to check for existence
+type PhotoExistsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	ObjectId      string                 `protobuf:"bytes,1,opt,name=object_id,json=objectId,proto3" json:"object_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PhotoExistsRequest) Reset() {
+	*x = PhotoExistsRequest{}
+	mi := &file_proto_photos_proto_msgTypes[28]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PhotoExistsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PhotoExistsRequest) ProtoMessage() {}
+
+func (x *PhotoExistsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[28]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PhotoExistsRequest.ProtoReflect.Descriptor instead.
+func (*PhotoExistsRequest) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{28}
+}
+
+func (x *PhotoExistsRequest) GetObjectId() string {
+	if x != nil {
+		return x.ObjectId
+	}
+	return ""
+}
+
+// PhotoExistsResponse returns whether the photo exists
+type PhotoExistsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Exists        bool                   `protobuf:"varint,1,opt,name=exists,proto3" json:"exists,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *PhotoExistsResponse) Reset() {
+	*x = PhotoExistsResponse{}
+	mi := &file_proto_photos_proto_msgTypes[29]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PhotoExistsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PhotoExistsResponse) ProtoMessage() {}
+
+func (x *PhotoExistsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[29]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PhotoExistsResponse.ProtoReflect.Descriptor instead.
+func (*PhotoExistsResponse) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{29}
+}
+
+func (x *PhotoExistsResponse) GetExists() bool {
+	if x != nil {
+		return x.Exists
+	}
+	return false
+}
+
+// ListDirectoriesRequest specifies the prefix and pagination for listing directories
+type ListDirectoriesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Prefix        string                 `protobuf:"bytes,1,opt,name=prefix,proto3" json:"prefix,omitempty"`
+	Recursive     bool                   `protobuf:"varint,2,opt,name=recursive,proto3" json:"recursive,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListDirectoriesRequest) Reset() {
+	*x = ListDirectoriesRequest{}
+	mi := &file_proto_photos_proto_msgTypes[30]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListDirectoriesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListDirectoriesRequest) ProtoMessage() {}
+
+func (x *ListDirectoriesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[30]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListDirectoriesRequest.ProtoReflect.Descriptor instead.
+func (*ListDirectoriesRequest) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{30}
+}
+
+func (x *ListDirectoriesRequest) GetPrefix() string {
+	if x != nil {
+		return x.Prefix
+	}
+	return ""
+}
+
+func (x *ListDirectoriesRequest) GetRecursive() bool {
+	if x != nil {
+		return x.Recursive
+	}
+	return false
+}
+
+// ListDirectoriesResponse returns directory prefixes
+type ListDirectoriesResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Prefixes      []string               `protobuf:"bytes,1,rep,name=prefixes,proto3" json:"prefixes,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListDirectoriesResponse) Reset() {
+	*x = ListDirectoriesResponse{}
+	mi := &file_proto_photos_proto_msgTypes[31]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListDirectoriesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListDirectoriesResponse) ProtoMessage() {}
+
+func (x *ListDirectoriesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[31]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListDirectoriesResponse.ProtoReflect.Descriptor instead.
+func (*ListDirectoriesResponse) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{31}
+}
+
+func (x *ListDirectoriesResponse) GetPrefixes() []string {
+	if x != nil {
+		return x.Prefixes
+	}
+	return nil
+}
+
+// SyncDatabaseRequest specifies options for database synchronization
+type SyncDatabaseRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// If true, downloads each photo file to extract EXIF metadata,
+	// updates GCS object metadata, and sets time_taken in the database
+	UpdateMetadata bool `protobuf:"varint,1,opt,name=update_metadata,json=updateMetadata,proto3" json:"update_metadata,omitempty"`
+	// Seconds to sleep between per-object metadata updates. Used to reduce CPU
+	// pressure on the server during large syncs. Only effective when
+	// update_metadata is true. Each of the objects updated at once sleeps
+	// after its update.
+	PauseBetweenObjectsSeconds uint32 `protobuf:"varint,2,opt,name=pause_between_objects_seconds,json=pauseBetweenObjectsSeconds,proto3" json:"pause_between_objects_seconds,omitempty"`
+	// If true, every object in the bucket and every photo in the database is
+	// examined. Otherwise only the objects changed since the last sync are,
+	// and deletions are found by comparing object names; the first sync of a
+	// user is always full.
+	Full bool `protobuf:"varint,3,opt,name=full,proto3" json:"full,omitempty"`
+	// If true, nothing is changed: the changes the sync would make are
+	// streamed instead, one per message, followed by a summary of them.
+	DryRun        bool `protobuf:"varint,4,opt,name=dry_run,json=dryRun,proto3" json:"dry_run,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SyncDatabaseRequest) Reset() {
+	*x = SyncDatabaseRequest{}
+	mi := &file_proto_photos_proto_msgTypes[32]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SyncDatabaseRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SyncDatabaseRequest) ProtoMessage() {}
+
+func (x *SyncDatabaseRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[32]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SyncDatabaseRequest.ProtoReflect.Descriptor instead.
+func (*SyncDatabaseRequest) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{32}
+}
+
+func (x *SyncDatabaseRequest) GetUpdateMetadata() bool {
+	if x != nil {
+		return x.UpdateMetadata
+	}
+	return false
+}
+
+func (x *SyncDatabaseRequest) GetPauseBetweenObjectsSeconds() uint32 {
+	if x != nil {
+		return x.PauseBetweenObjectsSeconds
+	}
+	return 0
+}
+
+func (x *SyncDatabaseRequest) GetFull() bool {
+	if x != nil {
+		return x.Full
+	}
+	return false
+}
+
+func (x *SyncDatabaseRequest) GetDryRun() bool {
+	if x != nil {
+		return x.DryRun
+	}
+	return false
+}
+
+// SyncChange is a change to a single object that a sync would make, as
+// reported by a dry run.
+type SyncChange struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// action is the kind of change.
+	Action SyncChange_Action `protobuf:"varint,1,opt,name=action,proto3,enum=photos.SyncChange_Action" json:"action,omitempty"`
+	// object_id is the object changed.
+	ObjectId string `protobuf:"bytes,2,opt,name=object_id,json=objectId,proto3" json:"object_id,omitempty"`
+	// reason explains the change, such as the metadata that differs.
+	Reason        string `protobuf:"bytes,3,opt,name=reason,proto3" json:"reason,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SyncChange) Reset() {
+	*x = SyncChange{}
+	mi := &file_proto_photos_proto_msgTypes[33]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SyncChange) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SyncChange) ProtoMessage() {}
+
+func (x *SyncChange) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[33]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SyncChange.ProtoReflect.Descriptor instead.
+func (*SyncChange) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{33}
+}
+
+func (x *SyncChange) GetAction() SyncChange_Action {
+	if x != nil {
+		return x.Action
+	}
+	return SyncChange_ACTION_UNSPECIFIED
+}
+
+func (x *SyncChange) GetObjectId() string {
+	if x != nil {
+		return x.ObjectId
+	}
+	return ""
+}
+
+func (x *SyncChange) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+// SyncDatabaseProgress is streamed from SyncDatabase as it advances through
+// its phases. A message is emitted per processed object, plus one final
+// message with complete=true summarising the run.
+type SyncDatabaseProgress struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// phase is the sync phase this message refers to.
+	Phase SyncDatabaseProgress_Phase `protobuf:"varint,1,opt,name=phase,proto3,enum=photos.SyncDatabaseProgress_Phase" json:"phase,omitempty"`
+	// processed is the number of database objects processed so far in this phase.
+	Processed uint32 `protobuf:"varint,2,opt,name=processed,proto3" json:"processed,omitempty"`
+	// total is the number of database objects to process in this phase.
+	Total uint32 `protobuf:"varint,3,opt,name=total,proto3" json:"total,omitempty"`
+	// added is the cumulative count of newly inserted objects (populated on the
+	// final message).
+	Added uint32 `protobuf:"varint,4,opt,name=added,proto3" json:"added,omitempty"`
+	// removed is the cumulative count of deleted objects (populated on the final
+	// message).
+	Removed uint32 `protobuf:"varint,5,opt,name=removed,proto3" json:"removed,omitempty"`
+	// metadata_updated is the cumulative count of objects whose metadata was
+	// refreshed (populated on the final message).
+	MetadataUpdated uint32 `protobuf:"varint,6,opt,name=metadata_updated,json=metadataUpdated,proto3" json:"metadata_updated,omitempty"`
+	// complete is set on the final summary message of the run.
+	Complete bool `protobuf:"varint,7,opt,name=complete,proto3" json:"complete,omitempty"`
+	// renditions_generated is the cumulative count of thumbnails generated
+	// (populated on the final message).
+	RenditionsGenerated uint32 `protobuf:"varint,8,opt,name=renditions_generated,json=renditionsGenerated,proto3" json:"renditions_generated,omitempty"`
+	// posters_generated is the cumulative count of videos given a poster frame
+	// or animated preview (populated on the final message).
+	PostersGenerated uint32 `protobuf:"varint,9,opt,name=posters_generated,json=postersGenerated,proto3" json:"posters_generated,omitempty"`
+	// full is set on the final message if every object was examined rather
+	// than only those changed since the last sync.
+	Full bool `protobuf:"varint,10,opt,name=full,proto3" json:"full,omitempty"`
+	// change is set on each message of a dry run but the last; the counts of
+	// the last message are then of the changes reported.
+	Change        *SyncChange `protobuf:"bytes,11,opt,name=change,proto3" json:"change,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SyncDatabaseProgress) Reset() {
+	*x = SyncDatabaseProgress{}
+	mi := &file_proto_photos_proto_msgTypes[34]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SyncDatabaseProgress) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SyncDatabaseProgress) ProtoMessage() {}
+
+func (x *SyncDatabaseProgress) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[34]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SyncDatabaseProgress.ProtoReflect.Descriptor instead.
+func (*SyncDatabaseProgress) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{34}
+}
+
+func (x *SyncDatabaseProgress) GetPhase() SyncDatabaseProgress_Phase {
+	if x != nil {
+		return x.Phase
+	}
+	return SyncDatabaseProgress_PHASE_UNSPECIFIED
+}
+
+func (x *SyncDatabaseProgress) GetProcessed() uint32 {
+	if x != nil {
+		return x.Processed
+	}
+	return 0
+}
+
+func (x *SyncDatabaseProgress) GetTotal() uint32 {
+	if x != nil {
+		return x.Total
+	}
+	return 0
+}
+
+func (x *SyncDatabaseProgress) GetAdded() uint32 {
+	if x != nil {
+		return x.Added
+	}
+	return 0
+}
+
+func (x *SyncDatabaseProgress) GetRemoved() uint32 {
+	if x != nil {
+		return x.Removed
+	}
+	return 0
+}
+
+func (x *SyncDatabaseProgress) GetMetadataUpdated() uint32 {
+	if x != nil {
+		return x.MetadataUpdated
+	}
+	return 0
+}
+
+func (x *SyncDatabaseProgress) GetComplete() bool {
+	if x != nil {
+		return x.Complete
+	}
+	return false
+}
+
+func (x *SyncDatabaseProgress) GetRenditionsGenerated() uint32 {
+	if x != nil {
+		return x.RenditionsGenerated
+	}
+	return 0
+}
+
+func (x *SyncDatabaseProgress) GetPostersGenerated() uint32 {
+	if x != nil {
+		return x.PostersGenerated
+	}
+	return 0
+}
+
+func (x *SyncDatabaseProgress) GetFull() bool {
+	if x != nil {
+		return x.Full
+	}
+	return false
+}
+
+func (x *SyncDatabaseProgress) GetChange() *SyncChange {
+	if x != nil {
+		return x.Change
+	}
+	return nil
+}
+
+// VerifyLibraryRequest specifies options for checking the integrity of the
+// library.
+type VerifyLibraryRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// repair fixes the problems that are safe to fix: photos whose object is
+	// missing are moved to the trash, references to missing derived assets are
+	// cleared so that they are generated again, derived assets whose original
+	// is gone from storage are deleted, and directory rows are deleted or
+	// created to match the photos. Objects whose MD5 hash differs are only
+	// reported.
+	Repair        bool `protobuf:"varint,1,opt,name=repair,proto3" json:"repair,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *VerifyLibraryRequest) Reset() {
+	*x = VerifyLibraryRequest{}
+	mi := &file_proto_photos_proto_msgTypes[35]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *VerifyLibraryRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VerifyLibraryRequest) ProtoMessage() {}
+
+func (x *VerifyLibraryRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[35]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VerifyLibraryRequest.ProtoReflect.Descriptor instead.
+func (*VerifyLibraryRequest) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{35}
+}
+
+func (x *VerifyLibraryRequest) GetRepair() bool {
+	if x != nil {
+		return x.Repair
+	}
+	return false
+}
+
+// IntegrityProblem is a problem found by VerifyLibrary.
+type IntegrityProblem struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// kind is the kind of problem.
+	Kind IntegrityProblem_Kind `protobuf:"varint,1,opt,name=kind,proto3,enum=photos.IntegrityProblem_Kind" json:"kind,omitempty"`
+	// object_id is the photo or derived asset with the problem, or the path of
+	// the directory.
+	ObjectId string `protobuf:"bytes,2,opt,name=object_id,json=objectId,proto3" json:"object_id,omitempty"`
+	// detail describes the problem, such as the derived asset missing.
+	Detail string `protobuf:"bytes,3,opt,name=detail,proto3" json:"detail,omitempty"`
+	// repaired is set if the problem has been repaired.
+	Repaired      bool `protobuf:"varint,4,opt,name=repaired,proto3" json:"repaired,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *IntegrityProblem) Reset() {
+	*x = IntegrityProblem{}
+	mi := &file_proto_photos_proto_msgTypes[36]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *IntegrityProblem) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*IntegrityProblem) ProtoMessage() {}
+
+func (x *IntegrityProblem) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[36]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use IntegrityProblem.ProtoReflect.Descriptor instead.
+func (*IntegrityProblem) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{36}
+}
+
+func (x *IntegrityProblem) GetKind() IntegrityProblem_Kind {
+	if x != nil {
+		return x.Kind
+	}
+	return IntegrityProblem_KIND_UNSPECIFIED
+}
+
+func (x *IntegrityProblem) GetObjectId() string {
+	if x != nil {
+		return x.ObjectId
+	}
+	return ""
+}
+
+func (x *IntegrityProblem) GetDetail() string {
+	if x != nil {
+		return x.Detail
+	}
+	return ""
+}
+
+func (x *IntegrityProblem) GetRepaired() bool {
+	if x != nil {
+		return x.Repaired
+	}
+	return false
+}
+
+// VerifyLibraryProgress is streamed from VerifyLibrary. A message is emitted
+// per problem found, in order of kind and then object ID, plus one final
+// message with complete=true summarising the check, on which the counts are
+// populated.
+type VerifyLibraryProgress struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// problem is set on each message but the last.
+	Problem *IntegrityProblem `protobuf:"bytes,1,opt,name=problem,proto3" json:"problem,omitempty"`
+	// photos_checked is the number of photos checked.
+	PhotosChecked uint32 `protobuf:"varint,2,opt,name=photos_checked,json=photosChecked,proto3" json:"photos_checked,omitempty"`
+	// missing_objects is the number of photos whose object is not in storage.
+	MissingObjects uint32 `protobuf:"varint,3,opt,name=missing_objects,json=missingObjects,proto3" json:"missing_objects,omitempty"`
+	// md5_mismatches is the number of photos whose MD5 hash differs.
+	Md5Mismatches uint32 `protobuf:"varint,4,opt,name=md5_mismatches,json=md5Mismatches,proto3" json:"md5_mismatches,omitempty"`
+	// dangling_derived is the number of references to missing derived assets.
+	DanglingDerived uint32 `protobuf:"varint,5,opt,name=dangling_derived,json=danglingDerived,proto3" json:"dangling_derived,omitempty"`
+	// orphaned_derived is the number of derived assets without a photo.
+	OrphanedDerived uint32 `protobuf:"varint,6,opt,name=orphaned_derived,json=orphanedDerived,proto3" json:"orphaned_derived,omitempty"`
+	// empty_directories is the number of directory rows without photos.
+	EmptyDirectories uint32 `protobuf:"varint,7,opt,name=empty_directories,json=emptyDirectories,proto3" json:"empty_directories,omitempty"`
+	// missing_directories is the number of directories without a row.
+	MissingDirectories uint32 `protobuf:"varint,8,opt,name=missing_directories,json=missingDirectories,proto3" json:"missing_directories,omitempty"`
+	// repaired is the number of problems repaired.
+	Repaired uint32 `protobuf:"varint,9,opt,name=repaired,proto3" json:"repaired,omitempty"`
+	// complete is set on the final summary message of the check.
+	Complete      bool `protobuf:"varint,10,opt,name=complete,proto3" json:"complete,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *VerifyLibraryProgress) Reset() {
+	*x = VerifyLibraryProgress{}
+	mi := &file_proto_photos_proto_msgTypes[37]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *VerifyLibraryProgress) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*VerifyLibraryProgress) ProtoMessage() {}
+
+func (x *VerifyLibraryProgress) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[37]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use VerifyLibraryProgress.ProtoReflect.Descriptor instead.
+func (*VerifyLibraryProgress) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{37}
+}
+
+func (x *VerifyLibraryProgress) GetProblem() *IntegrityProblem {
+	if x != nil {
+		return x.Problem
+	}
+	return nil
+}
+
+func (x *VerifyLibraryProgress) GetPhotosChecked() uint32 {
+	if x != nil {
+		return x.PhotosChecked
+	}
+	return 0
+}
+
+func (x *VerifyLibraryProgress) GetMissingObjects() uint32 {
+	if x != nil {
+		return x.MissingObjects
+	}
+	return 0
+}
+
+func (x *VerifyLibraryProgress) GetMd5Mismatches() uint32 {
+	if x != nil {
+		return x.Md5Mismatches
+	}
+	return 0
+}
+
+func (x *VerifyLibraryProgress) GetDanglingDerived() uint32 {
+	if x != nil {
+		return x.DanglingDerived
+	}
+	return 0
+}
+
+func (x *VerifyLibraryProgress) GetOrphanedDerived() uint32 {
+	if x != nil {
+		return x.OrphanedDerived
+	}
+	return 0
+}
+
+func (x *VerifyLibraryProgress) GetEmptyDirectories() uint32 {
+	if x != nil {
+		return x.EmptyDirectories
+	}
+	return 0
+}
+
+func (x *VerifyLibraryProgress) GetMissingDirectories() uint32 {
+	if x != nil {
+		return x.MissingDirectories
+	}
+	return 0
+}
+
+func (x *VerifyLibraryProgress) GetRepaired() uint32 {
+	if x != nil {
+		return x.Repaired
+	}
+	return 0
+}
+
+func (x *VerifyLibraryProgress) GetComplete() bool {
+	if x != nil {
+		return x.Complete
+	}
+	return false
+}
+
+// UpdateWebpRequest specifies options for generating missing WebP renditions.
+type UpdateWebpRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Seconds to sleep between per-object WebP generations. Used to reduce CPU
+	// pressure on the server during large runs. Each of the objects processed
+	// at once sleeps after its generation.
+	PauseBetweenObjectsSeconds uint32 `protobuf:"varint,1,opt,name=pause_between_objects_seconds,json=pauseBetweenObjectsSeconds,proto3" json:"pause_between_objects_seconds,omitempty"`
+	unknownFields              protoimpl.UnknownFields
+	sizeCache                  protoimpl.SizeCache
+}
+
+func (x *UpdateWebpRequest) Reset() {
+	*x = UpdateWebpRequest{}
+	mi := &file_proto_photos_proto_msgTypes[38]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UpdateWebpRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UpdateWebpRequest) ProtoMessage() {}
+
+func (x *UpdateWebpRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[38]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UpdateWebpRequest.ProtoReflect.Descriptor instead.
+func (*UpdateWebpRequest) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{38}
+}
+
+func (x *UpdateWebpRequest) GetPauseBetweenObjectsSeconds() uint32 {
+	if x != nil {
+		return x.PauseBetweenObjectsSeconds
+	}
+	return 0
+}
+
+// UpdateWebpProgress is streamed from UpdateWebp as it advances. A message is
+// emitted per processed object, plus one final message with complete=true
+// summarising the run.
+type UpdateWebpProgress struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// processed is the number of eligible database objects processed so far.
+	Processed uint32 `protobuf:"varint,1,opt,name=processed,proto3" json:"processed,omitempty"`
+	// total is the number of eligible database objects to process.
+	Total uint32 `protobuf:"varint,2,opt,name=total,proto3" json:"total,omitempty"`
+	// generated is the cumulative count of WebP renditions successfully created.
+	Generated uint32 `protobuf:"varint,3,opt,name=generated,proto3" json:"generated,omitempty"`
+	// skipped is the cumulative count of objects skipped (e.g. unsupported
+	// content type or missing source data).
+	Skipped uint32 `protobuf:"varint,4,opt,name=skipped,proto3" json:"skipped,omitempty"`
+	// failed is the cumulative count of objects whose WebP generation failed.
+	Failed uint32 `protobuf:"varint,5,opt,name=failed,proto3" json:"failed,omitempty"`
+	// complete is set on the final summary message of the run.
+	Complete      bool `protobuf:"varint,6,opt,name=complete,proto3" json:"complete,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UpdateWebpProgress) Reset() {
+	*x = UpdateWebpProgress{}
+	mi := &file_proto_photos_proto_msgTypes[39]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UpdateWebpProgress) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UpdateWebpProgress) ProtoMessage() {}
+
+func (x *UpdateWebpProgress) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[39]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UpdateWebpProgress.ProtoReflect.Descriptor instead.
+func (*UpdateWebpProgress) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{39}
+}
+
+func (x *UpdateWebpProgress) GetProcessed() uint32 {
+	if x != nil {
+		return x.Processed
+	}
+	return 0
+}
+
+func (x *UpdateWebpProgress) GetTotal() uint32 {
+	if x != nil {
+		return x.Total
+	}
+	return 0
+}
+
+func (x *UpdateWebpProgress) GetGenerated() uint32 {
+	if x != nil {
+		return x.Generated
+	}
+	return 0
+}
+
+func (x *UpdateWebpProgress) GetSkipped() uint32 {
+	if x != nil {
+		return x.Skipped
+	}
+	return 0
+}
+
+func (x *UpdateWebpProgress) GetFailed() uint32 {
+	if x != nil {
+		return x.Failed
+	}
+	return 0
+}
+
+func (x *UpdateWebpProgress) GetComplete() bool {
+	if x != nil {
+		return x.Complete
+	}
+	return false
+}
+
+// UpdateAvifRequest specifies options for generating missing AVIF renditions.
+type UpdateAvifRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Seconds to sleep between per-object AVIF generations. Used to reduce CPU
+	// pressure on the server during large runs.
+	PauseBetweenObjectsSeconds uint32 `protobuf:"varint,1,opt,name=pause_between_objects_seconds,json=pauseBetweenObjectsSeconds,proto3" json:"pause_between_objects_seconds,omitempty"`
+	unknownFields              protoimpl.UnknownFields
+	sizeCache                  protoimpl.SizeCache
+}
+
+func (x *UpdateAvifRequest) Reset() {
+	*x = UpdateAvifRequest{}
+	mi := &file_proto_photos_proto_msgTypes[40]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UpdateAvifRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UpdateAvifRequest) ProtoMessage() {}
+
+func (x *UpdateAvifRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[40]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UpdateAvifRequest.ProtoReflect.Descriptor instead.
+func (*UpdateAvifRequest) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{40}
+}
+
+func (x *UpdateAvifRequest) GetPauseBetweenObjectsSeconds() uint32 {
+	if x != nil {
+		return x.PauseBetweenObjectsSeconds
+	}
+	return 0
+}
+
+// UpdateAvifProgress is streamed from UpdateAvif as it advances. A message is
+// emitted per processed object, plus one final message with complete=true
+// summarising the run.
+type UpdateAvifProgress struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// processed is the number of eligible database objects processed so far.
+	Processed uint32 `protobuf:"varint,1,opt,name=processed,proto3" json:"processed,omitempty"`
+	// total is the number of eligible database objects to process.
+	Total uint32 `protobuf:"varint,2,opt,name=total,proto3" json:"total,omitempty"`
+	// generated is the cumulative count of AVIF renditions successfully created.
+	Generated uint32 `protobuf:"varint,3,opt,name=generated,proto3" json:"generated,omitempty"`
+	// skipped is the cumulative count of objects skipped (e.g. unsupported
+	// content type or missing source data).
+	Skipped uint32 `protobuf:"varint,4,opt,name=skipped,proto3" json:"skipped,omitempty"`
+	// failed is the cumulative count of objects whose AVIF generation failed.
+	Failed uint32 `protobuf:"varint,5,opt,name=failed,proto3" json:"failed,omitempty"`
+	// complete is set on the final summary message of the run.
+	Complete      bool `protobuf:"varint,6,opt,name=complete,proto3" json:"complete,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UpdateAvifProgress) Reset() {
+	*x = UpdateAvifProgress{}
+	mi := &file_proto_photos_proto_msgTypes[41]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UpdateAvifProgress) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UpdateAvifProgress) ProtoMessage() {}
+
+func (x *UpdateAvifProgress) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[41]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UpdateAvifProgress.ProtoReflect.Descriptor instead.
+func (*UpdateAvifProgress) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{41}
+}
+
+func (x *UpdateAvifProgress) GetProcessed() uint32 {
+	if x != nil {
+		return x.Processed
+	}
+	return 0
+}
+
+func (x *UpdateAvifProgress) GetTotal() uint32 {
+	if x != nil {
+		return x.Total
+	}
+	return 0
+}
+
+func (x *UpdateAvifProgress) GetGenerated() uint32 {
+	if x != nil {
+		return x.Generated
+	}
+	return 0
+}
+
+func (x *UpdateAvifProgress) GetSkipped() uint32 {
+	if x != nil {
+		return x.Skipped
+	}
+	return 0
+}
+
+func (x *UpdateAvifProgress) GetFailed() uint32 {
+	if x != nil {
+		return x.Failed
+	}
+	return 0
+}
+
+func (x *UpdateAvifProgress) GetComplete() bool {
+	if x != nil {
+		return x.Complete
+	}
+	return false
+}
+
+// TranscodeVideoRequest selects the videos to transcode to an MP4 proxy and,
+// if enabled on the server, HLS renditions.
+type TranscodeVideoRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Object ID of the video to transcode. If empty, every video of the
+	// authenticated user missing a proxy (or HLS renditions) is transcoded.
+	ObjectId string `protobuf:"bytes,1,opt,name=object_id,json=objectId,proto3" json:"object_id,omitempty"`
+	// Transcode videos again even if they already have a proxy and HLS
+	// renditions.
+	Force bool `protobuf:"varint,2,opt,name=force,proto3" json:"force,omitempty"`
+	// Seconds to sleep between videos. Used to reduce CPU pressure on the
+	// server during large runs.
+	PauseBetweenObjectsSeconds uint32 `protobuf:"varint,3,opt,name=pause_between_objects_seconds,json=pauseBetweenObjectsSeconds,proto3" json:"pause_between_objects_seconds,omitempty"`
+	unknownFields              protoimpl.UnknownFields
+	sizeCache                  protoimpl.SizeCache
+}
+
+func (x *TranscodeVideoRequest) Reset() {
+	*x = TranscodeVideoRequest{}
+	mi := &file_proto_photos_proto_msgTypes[42]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TranscodeVideoRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TranscodeVideoRequest) ProtoMessage() {}
+
+func (x *TranscodeVideoRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[42]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TranscodeVideoRequest.ProtoReflect.Descriptor instead.
+func (*TranscodeVideoRequest) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{42}
+}
+
+func (x *TranscodeVideoRequest) GetObjectId() string {
+	if x != nil {
+		return x.ObjectId
+	}
+	return ""
+}
+
+func (x *TranscodeVideoRequest) GetForce() bool {
+	if x != nil {
+		return x.Force
+	}
+	return false
+}
+
+func (x *TranscodeVideoRequest) GetPauseBetweenObjectsSeconds() uint32 {
+	if x != nil {
+		return x.PauseBetweenObjectsSeconds
+	}
+	return 0
+}
+
+// TranscodeVideoProgress is streamed from TranscodeVideo as it advances. A
+// message is emitted as ffmpeg progresses through each video and once it is
+// done, plus one final message with complete=true summarising the run.
+type TranscodeVideoProgress struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// object_id is the video being transcoded.
+	ObjectId string `protobuf:"bytes,1,opt,name=object_id,json=objectId,proto3" json:"object_id,omitempty"`
+	// percent is how far the transcode of object_id has got (0-100).
+	Percent float64 `protobuf:"fixed64,2,opt,name=percent,proto3" json:"percent,omitempty"`
+	// processed is the number of videos processed so far.
+	Processed uint32 `protobuf:"varint,3,opt,name=processed,proto3" json:"processed,omitempty"`
+	// total is the number of videos to process.
+	Total uint32 `protobuf:"varint,4,opt,name=total,proto3" json:"total,omitempty"`
+	// transcoded is the cumulative count of videos successfully transcoded.
+	Transcoded uint32 `protobuf:"varint,5,opt,name=transcoded,proto3" json:"transcoded,omitempty"`
+	// skipped is the cumulative count of videos skipped (e.g. already
+	// transcoded).
+	Skipped uint32 `protobuf:"varint,6,opt,name=skipped,proto3" json:"skipped,omitempty"`
+	// failed is the cumulative count of videos whose transcode failed.
+	Failed uint32 `protobuf:"varint,7,opt,name=failed,proto3" json:"failed,omitempty"`
+	// complete is set on the final summary message of the run.
+	Complete      bool `protobuf:"varint,8,opt,name=complete,proto3" json:"complete,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *TranscodeVideoProgress) Reset() {
+	*x = TranscodeVideoProgress{}
+	mi := &file_proto_photos_proto_msgTypes[43]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *TranscodeVideoProgress) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TranscodeVideoProgress) ProtoMessage() {}
+
+func (x *TranscodeVideoProgress) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[43]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TranscodeVideoProgress.ProtoReflect.Descriptor instead.
+func (*TranscodeVideoProgress) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{43}
+}
+
+func (x *TranscodeVideoProgress) GetObjectId() string {
+	if x != nil {
+		return x.ObjectId
+	}
+	return ""
+}
+
+func (x *TranscodeVideoProgress) GetPercent() float64 {
+	if x != nil {
+		return x.Percent
+	}
+	return 0
+}
+
+func (x *TranscodeVideoProgress) GetProcessed() uint32 {
+	if x != nil {
+		return x.Processed
+	}
+	return 0
+}
+
+func (x *TranscodeVideoProgress) GetTotal() uint32 {
+	if x != nil {
+		return x.Total
+	}
+	return 0
+}
+
+func (x *TranscodeVideoProgress) GetTranscoded() uint32 {
+	if x != nil {
+		return x.Transcoded
+	}
+	return 0
+}
+
+func (x *TranscodeVideoProgress) GetSkipped() uint32 {
+	if x != nil {
+		return x.Skipped
+	}
+	return 0
+}
+
+func (x *TranscodeVideoProgress) GetFailed() uint32 {
+	if x != nil {
+		return x.Failed
+	}
+	return 0
+}
+
+func (x *TranscodeVideoProgress) GetComplete() bool {
+	if x != nil {
+		return x.Complete
+	}
+	return false
+}
+
+// StreamingUploadRequest is sent as a stream of chunks for large uploads
+type StreamingUploadRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Data:
+	//
+	//	*StreamingUploadRequest_Metadata
+	//	*StreamingUploadRequest_Chunk
+	//	*StreamingUploadRequest_EndOfFile
+	Data          isStreamingUploadRequest_Data `protobuf_oneof:"data"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StreamingUploadRequest) Reset() {
+	*x = StreamingUploadRequest{}
+	mi := &file_proto_photos_proto_msgTypes[44]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StreamingUploadRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamingUploadRequest) ProtoMessage() {}
+
+func (x *StreamingUploadRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[44]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamingUploadRequest.ProtoReflect.Descriptor instead.
+func (*StreamingUploadRequest) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{44}
+}
+
+func (x *StreamingUploadRequest) GetData() isStreamingUploadRequest_Data {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *StreamingUploadRequest) GetMetadata() *PhotoMetadata {
+	if x != nil {
+		if x, ok := x.Data.(*StreamingUploadRequest_Metadata); ok {
+			return x.Metadata
+		}
+	}
+	return nil
+}
+
+func (x *StreamingUploadRequest) GetChunk() []byte {
+	if x != nil {
+		if x, ok := x.Data.(*StreamingUploadRequest_Chunk); ok {
+			return x.Chunk
+		}
+	}
+	return nil
+}
+
+func (x *StreamingUploadRequest) GetEndOfFile() bool {
+	if x != nil {
+		if x, ok := x.Data.(*StreamingUploadRequest_EndOfFile); ok {
+			return x.EndOfFile
+		}
+	}
+	return false
+}
+
+type isStreamingUploadRequest_Data interface {
+	isStreamingUploadRequest_Data()
+}
+
+type StreamingUploadRequest_Metadata struct {
+	// metadata is sent in the first message only
+	Metadata *PhotoMetadata `protobuf:"bytes,1,opt,name=metadata,proto3,oneof"`
+}
+
+type StreamingUploadRequest_Chunk struct {
+	// chunk contains a portion of the photo data
+	Chunk []byte `protobuf:"bytes,2,opt,name=chunk,proto3,oneof"`
+}
+
+type StreamingUploadRequest_EndOfFile struct {
+	// end_of_file signals the end of the current file in a bulk upload; value is ignored
+	EndOfFile bool `protobuf:"varint,3,opt,name=end_of_file,json=endOfFile,proto3,oneof"`
+}
+
+func (*StreamingUploadRequest_Metadata) isStreamingUploadRequest_Data() {}
+
+func (*StreamingUploadRequest_Chunk) isStreamingUploadRequest_Data() {}
+
+func (*StreamingUploadRequest_EndOfFile) isStreamingUploadRequest_Data() {}
+
+// BulkUploadFileResult is streamed back for each file in a bulk upload
+type BulkUploadFileResult struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// object_id identifies which file this result corresponds to
+	ObjectId string `protobuf:"bytes,1,opt,name=object_id,json=objectId,proto3" json:"object_id,omitempty"`
+	// success indicates whether the upload succeeded
+	Success bool `protobuf:"varint,2,opt,name=success,proto3" json:"success,omitempty"`
+	// photo is populated on success
+	Photo *Photo `protobuf:"bytes,3,opt,name=photo,proto3" json:"photo,omitempty"`
+	// error_message is populated on failure
+	ErrorMessage string `protobuf:"bytes,4,opt,name=error_message,json=errorMessage,proto3" json:"error_message,omitempty"`
+	// skipped is true when an identical object already existed and the upload
+	// was not written (CONFLICT_POLICY_SKIP_IF_IDENTICAL)
+	Skipped       bool `protobuf:"varint,5,opt,name=skipped,proto3" json:"skipped,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BulkUploadFileResult) Reset() {
+	*x = BulkUploadFileResult{}
+	mi := &file_proto_photos_proto_msgTypes[45]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BulkUploadFileResult) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BulkUploadFileResult) ProtoMessage() {}
+
+func (x *BulkUploadFileResult) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[45]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BulkUploadFileResult.ProtoReflect.Descriptor instead.
+func (*BulkUploadFileResult) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{45}
+}
+
+func (x *BulkUploadFileResult) GetObjectId() string {
+	if x != nil {
+		return x.ObjectId
+	}
+	return ""
+}
+
+func (x *BulkUploadFileResult) GetSuccess() bool {
+	if x != nil {
+		return x.Success
+	}
+	return false
+}
+
+func (x *BulkUploadFileResult) GetPhoto() *Photo {
+	if x != nil {
+		return x.Photo
+	}
+	return nil
+}
+
+func (x *BulkUploadFileResult) GetErrorMessage() string {
+	if x != nil {
+		return x.ErrorMessage
+	}
+	return ""
+}
+
+func (x *BulkUploadFileResult) GetSkipped() bool {
+	if x != nil {
+		return x.Skipped
+	}
+	return false
+}
+
+// PhotoMetadata contains info about the photo being uploaded
+type PhotoMetadata struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	Filename    string                 `protobuf:"bytes,1,opt,name=filename,proto3" json:"filename,omitempty"`
+	ContentType string                 `protobuf:"bytes,2,opt,name=content_type,json=contentType,proto3" json:"content_type,omitempty"`
+	// conflict_policy decides what happens when filename already exists
+	ConflictPolicy ConflictPolicy `protobuf:"varint,3,opt,name=conflict_policy,json=conflictPolicy,proto3,enum=photos.ConflictPolicy" json:"conflict_policy,omitempty"`
+	unknownFields  protoimpl.UnknownFields
+	sizeCache      protoimpl.SizeCache
+}
+
+func (x *PhotoMetadata) Reset() {
+	*x = PhotoMetadata{}
+	mi := &file_proto_photos_proto_msgTypes[46]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *PhotoMetadata) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PhotoMetadata) ProtoMessage() {}
+
+func (x *PhotoMetadata) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[46]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PhotoMetadata.ProtoReflect.Descriptor instead.
+func (*PhotoMetadata) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{46}
+}
+
+func (x *PhotoMetadata) GetFilename() string {
+	if x != nil {
+		return x.Filename
+	}
+	return ""
+}
+
+func (x *PhotoMetadata) GetContentType() string {
+	if x != nil {
+		return x.ContentType
+	}
+	return ""
+}
+
+func (x *PhotoMetadata) GetConflictPolicy() ConflictPolicy {
+	if x != nil {
+		return x.ConflictPolicy
+	}
+	return ConflictPolicy_CONFLICT_POLICY_UNSPECIFIED
+}
+
+// StreamingDownloadRequest specifies which photo to download
+type StreamingDownloadRequest struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	ObjectId string                 `protobuf:"bytes,1,opt,name=object_id,json=objectId,proto3" json:"object_id,omitempty"`
+	// If true, GPS location data will be removed from the downloaded image EXIF
+	StripLocation bool `protobuf:"varint,2,opt,name=strip_location,json=stripLocation,proto3" json:"strip_location,omitempty"`
+	// offset is the first byte to stream; a negative offset streams the last
+	// -offset bytes. Cannot be combined with strip_location.
+	Offset int64 `protobuf:"varint,3,opt,name=offset,proto3" json:"offset,omitempty"`
+	// length limits the number of bytes streamed from offset; 0 streams to the
+	// end of the object
+	Length int64 `protobuf:"varint,4,opt,name=length,proto3" json:"length,omitempty"`
+	// If true, only the metadata message is sent
+	MetadataOnly  bool `protobuf:"varint,5,opt,name=metadata_only,json=metadataOnly,proto3" json:"metadata_only,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StreamingDownloadRequest) Reset() {
+	*x = StreamingDownloadRequest{}
+	mi := &file_proto_photos_proto_msgTypes[47]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StreamingDownloadRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamingDownloadRequest) ProtoMessage() {}
+
+func (x *StreamingDownloadRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[47]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamingDownloadRequest.ProtoReflect.Descriptor instead.
+func (*StreamingDownloadRequest) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{47}
+}
+
+func (x *StreamingDownloadRequest) GetObjectId() string {
+	if x != nil {
+		return x.ObjectId
+	}
+	return ""
+}
+
+func (x *StreamingDownloadRequest) GetStripLocation() bool {
+	if x != nil {
+		return x.StripLocation
+	}
+	return false
+}
+
+func (x *StreamingDownloadRequest) GetOffset() int64 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *StreamingDownloadRequest) GetLength() int64 {
+	if x != nil {
+		return x.Length
+	}
+	return 0
+}
+
+func (x *StreamingDownloadRequest) GetMetadataOnly() bool {
+	if x != nil {
+		return x.MetadataOnly
+	}
+	return false
+}
+
+// StreamingDownloadResponse is streamed back in chunks
+type StreamingDownloadResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Data:
+	//
+	//	*StreamingDownloadResponse_Metadata
+	//	*StreamingDownloadResponse_Chunk
+	Data          isStreamingDownloadResponse_Data `protobuf_oneof:"data"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StreamingDownloadResponse) Reset() {
+	*x = StreamingDownloadResponse{}
+	mi := &file_proto_photos_proto_msgTypes[48]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StreamingDownloadResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamingDownloadResponse) ProtoMessage() {}
+
+func (x *StreamingDownloadResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[48]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamingDownloadResponse.ProtoReflect.Descriptor instead.
+func (*StreamingDownloadResponse) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{48}
+}
+
+func (x *StreamingDownloadResponse) GetData() isStreamingDownloadResponse_Data {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *StreamingDownloadResponse) GetMetadata() *Photo {
+	if x != nil {
+		if x, ok := x.Data.(*StreamingDownloadResponse_Metadata); ok {
+			return x.Metadata
+		}
+	}
+	return nil
+}
+
+func (x *StreamingDownloadResponse) GetChunk() []byte {
+	if x != nil {
+		if x, ok := x.Data.(*StreamingDownloadResponse_Chunk); ok {
+			return x.Chunk
+		}
+	}
+	return nil
+}
+
+type isStreamingDownloadResponse_Data interface {
+	isStreamingDownloadResponse_Data()
+}
+
+type StreamingDownloadResponse_Metadata struct {
+	// metadata is sent in the first message only
+	Metadata *Photo `protobuf:"bytes,1,opt,name=metadata,proto3,oneof"`
+}
+
+type StreamingDownloadResponse_Chunk struct {
+	// chunk contains a portion of the photo data
+	Chunk []byte `protobuf:"bytes,2,opt,name=chunk,proto3,oneof"`
+}
+
+func (*StreamingDownloadResponse_Metadata) isStreamingDownloadResponse_Data() {}
+
+func (*StreamingDownloadResponse_Chunk) isStreamingDownloadResponse_Data() {}
+
+// DownloadArchiveRequest selects the photos to download as a ZIP archive
+type DownloadArchiveRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// prefix is the directory to archive, including its sub-directories; empty
+	// archives the whole library
+	Prefix string `protobuf:"bytes,1,opt,name=prefix,proto3" json:"prefix,omitempty"`
+	// include_derived adds the derived assets of the photos, such as WebP
+	// renditions, previews, thumbnails and video proxies
+	IncludeDerived bool `protobuf:"varint,2,opt,name=include_derived,json=includeDerived,proto3" json:"include_derived,omitempty"`
+	// If true, GPS location data will be removed from the EXIF of each JPEG and
+	// HEIC image, and XMP sidecars, Live Photo and motion photo videos and
+	// derived videos, which would keep it, are left out
+	StripLocation bool `protobuf:"varint,3,opt,name=strip_location,json=stripLocation,proto3" json:"strip_location,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DownloadArchiveRequest) Reset() {
+	*x = DownloadArchiveRequest{}
+	mi := &file_proto_photos_proto_msgTypes[49]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DownloadArchiveRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DownloadArchiveRequest) ProtoMessage() {}
+
+func (x *DownloadArchiveRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[49]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DownloadArchiveRequest.ProtoReflect.Descriptor instead.
+func (*DownloadArchiveRequest) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{49}
+}
+
+func (x *DownloadArchiveRequest) GetPrefix() string {
+	if x != nil {
+		return x.Prefix
+	}
+	return ""
+}
+
+func (x *DownloadArchiveRequest) GetIncludeDerived() bool {
+	if x != nil {
+		return x.IncludeDerived
+	}
+	return false
+}
+
+func (x *DownloadArchiveRequest) GetStripLocation() bool {
+	if x != nil {
+		return x.StripLocation
+	}
+	return false
+}
+
+// DownloadArchiveResponse carries the next portion of the ZIP archive
+type DownloadArchiveResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Chunk         []byte                 `protobuf:"bytes,1,opt,name=chunk,proto3" json:"chunk,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DownloadArchiveResponse) Reset() {
+	*x = DownloadArchiveResponse{}
+	mi := &file_proto_photos_proto_msgTypes[50]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DownloadArchiveResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DownloadArchiveResponse) ProtoMessage() {}
+
+func (x *DownloadArchiveResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[50]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DownloadArchiveResponse.ProtoReflect.Descriptor instead.
+func (*DownloadArchiveResponse) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{50}
+}
+
+func (x *DownloadArchiveResponse) GetChunk() []byte {
+	if x != nil {
+		return x.Chunk
+	}
+	return nil
+}
+
+// RenderPhotoRequest specifies the rendition to return. At least one of
+// width and height must be set; if only one is, the other follows from the
+// aspect ratio. Photos are never scaled up.
+type RenderPhotoRequest struct {
+	state    protoimpl.MessageState `protogen:"open.v1"`
+	ObjectId string                 `protobuf:"bytes,1,opt,name=object_id,json=objectId,proto3" json:"object_id,omitempty"`
+	Width    int32                  `protobuf:"varint,2,opt,name=width,proto3" json:"width,omitempty"`
+	Height   int32                  `protobuf:"varint,3,opt,name=height,proto3" json:"height,omitempty"`
+	Fit      RenderFit              `protobuf:"varint,4,opt,name=fit,proto3,enum=photos.RenderFit" json:"fit,omitempty"`
+	Format   RenderFormat           `protobuf:"varint,5,opt,name=format,proto3,enum=photos.RenderFormat" json:"format,omitempty"`
+	// if_none_match is the If-None-Match of an HTTP request; if it matches
+	// the etag of the rendition, nothing is rendered and not_modified is set
+	IfNoneMatch   string `protobuf:"bytes,6,opt,name=if_none_match,json=ifNoneMatch,proto3" json:"if_none_match,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RenderPhotoRequest) Reset() {
+	*x = RenderPhotoRequest{}
+	mi := &file_proto_photos_proto_msgTypes[51]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RenderPhotoRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RenderPhotoRequest) ProtoMessage() {}
+
+func (x *RenderPhotoRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[51]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RenderPhotoRequest.ProtoReflect.Descriptor instead.
+func (*RenderPhotoRequest) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{51}
+}
+
+func (x *RenderPhotoRequest) GetObjectId() string {
+	if x != nil {
+		return x.ObjectId
+	}
+	return ""
+}
+
+func (x *RenderPhotoRequest) GetWidth() int32 {
+	if x != nil {
+		return x.Width
+	}
+	return 0
+}
+
+func (x *RenderPhotoRequest) GetHeight() int32 {
+	if x != nil {
+		return x.Height
+	}
+	return 0
+}
+
+func (x *RenderPhotoRequest) GetFit() RenderFit {
+	if x != nil {
+		return x.Fit
+	}
+	return RenderFit_RENDER_FIT_UNSPECIFIED
+}
+
+func (x *RenderPhotoRequest) GetFormat() RenderFormat {
+	if x != nil {
+		return x.Format
+	}
+	return RenderFormat_RENDER_FORMAT_UNSPECIFIED
+}
+
+func (x *RenderPhotoRequest) GetIfNoneMatch() string {
+	if x != nil {
+		return x.IfNoneMatch
+	}
+	return ""
+}
+
+// RenderPhotoResponse contains the encoded rendition
+type RenderPhotoResponse struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	Data        []byte                 `protobuf:"bytes,1,opt,name=data,proto3" json:"data,omitempty"`
+	ContentType string                 `protobuf:"bytes,2,opt,name=content_type,json=contentType,proto3" json:"content_type,omitempty"`
+	Width       int32                  `protobuf:"varint,3,opt,name=width,proto3" json:"width,omitempty"`
+	Height      int32                  `protobuf:"varint,4,opt,name=height,proto3" json:"height,omitempty"`
+	// etag identifies the rendition; it changes when the photo does
+	Etag string `protobuf:"bytes,5,opt,name=etag,proto3" json:"etag,omitempty"`
+	// not_modified is set, without data, when if_none_match matched etag
+	NotModified   bool `protobuf:"varint,6,opt,name=not_modified,json=notModified,proto3" json:"not_modified,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RenderPhotoResponse) Reset() {
+	*x = RenderPhotoResponse{}
+	mi := &file_proto_photos_proto_msgTypes[52]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RenderPhotoResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RenderPhotoResponse) ProtoMessage() {}
+
+func (x *RenderPhotoResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[52]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RenderPhotoResponse.ProtoReflect.Descriptor instead.
+func (*RenderPhotoResponse) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{52}
+}
+
+func (x *RenderPhotoResponse) GetData() []byte {
+	if x != nil {
+		return x.Data
+	}
+	return nil
+}
+
+func (x *RenderPhotoResponse) GetContentType() string {
+	if x != nil {
+		return x.ContentType
+	}
+	return ""
+}
+
+func (x *RenderPhotoResponse) GetWidth() int32 {
+	if x != nil {
+		return x.Width
+	}
+	return 0
+}
+
+func (x *RenderPhotoResponse) GetHeight() int32 {
+	if x != nil {
+		return x.Height
+	}
+	return 0
+}
+
+func (x *RenderPhotoResponse) GetEtag() string {
+	if x != nil {
+		return x.Etag
+	}
+	return ""
+}
+
+func (x *RenderPhotoResponse) GetNotModified() bool {
+	if x != nil {
+		return x.NotModified
+	}
+	return false
+}
+
+// CreateMarkdownRequest specifies parameters for creating a markdown file
+type CreateMarkdownRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The prefix (directory path) where index.md will be created
+	Prefix string `protobuf:"bytes,1,opt,name=prefix,proto3" json:"prefix,omitempty"`
+	// The markdown content with YAML frontmatter
+	Markdown      string `protobuf:"bytes,2,opt,name=markdown,proto3" json:"markdown,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreateMarkdownRequest) Reset() {
+	*x = CreateMarkdownRequest{}
+	mi := &file_proto_photos_proto_msgTypes[53]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateMarkdownRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateMarkdownRequest) ProtoMessage() {}
+
+func (x *CreateMarkdownRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[53]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateMarkdownRequest.ProtoReflect.Descriptor instead.
+func (*CreateMarkdownRequest) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{53}
+}
+
+func (x *CreateMarkdownRequest) GetPrefix() string {
+	if x != nil {
+		return x.Prefix
+	}
+	return ""
+}
+
+func (x *CreateMarkdownRequest) GetMarkdown() string {
+	if x != nil {
+		return x.Markdown
+	}
+	return ""
+}
+
+// CreateMarkdownResponse confirms the markdown file creation
+type CreateMarkdownResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The full object ID of the created markdown file
+	ObjectId      string `protobuf:"bytes,1,opt,name=object_id,json=objectId,proto3" json:"object_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *CreateMarkdownResponse) Reset() {
+	*x = CreateMarkdownResponse{}
+	mi := &file_proto_photos_proto_msgTypes[54]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *CreateMarkdownResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CreateMarkdownResponse) ProtoMessage() {}
+
+func (x *CreateMarkdownResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[54]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CreateMarkdownResponse.ProtoReflect.Descriptor instead.
+func (*CreateMarkdownResponse) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{54}
+}
+
+func (x *CreateMarkdownResponse) GetObjectId() string {
+	if x != nil {
+		return x.ObjectId
+	}
+	return ""
+}
+
+// GetMarkdownRequest specifies parameters for retrieving a markdown file
+type GetMarkdownRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The prefix (directory path) where index.md is located
+	Prefix        string `protobuf:"bytes,1,opt,name=prefix,proto3" json:"prefix,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetMarkdownRequest) Reset() {
+	*x = GetMarkdownRequest{}
+	mi := &file_proto_photos_proto_msgTypes[55]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetMarkdownRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetMarkdownRequest) ProtoMessage() {}
+
+func (x *GetMarkdownRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[55]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetMarkdownRequest.ProtoReflect.Descriptor instead.
+func (*GetMarkdownRequest) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{55}
+}
+
+func (x *GetMarkdownRequest) GetPrefix() string {
+	if x != nil {
+		return x.Prefix
+	}
+	return ""
+}
+
+// GetMarkdownResponse returns the markdown file content
+type GetMarkdownResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The full object ID of the markdown file
+	ObjectId string `protobuf:"bytes,1,opt,name=object_id,json=objectId,proto3" json:"object_id,omitempty"`
+	// The markdown content with YAML frontmatter
+	Markdown      string `protobuf:"bytes,2,opt,name=markdown,proto3" json:"markdown,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetMarkdownResponse) Reset() {
+	*x = GetMarkdownResponse{}
+	mi := &file_proto_photos_proto_msgTypes[56]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetMarkdownResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetMarkdownResponse) ProtoMessage() {}
+
+func (x *GetMarkdownResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[56]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetMarkdownResponse.ProtoReflect.Descriptor instead.
+func (*GetMarkdownResponse) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{56}
+}
+
+func (x *GetMarkdownResponse) GetObjectId() string {
+	if x != nil {
+		return x.ObjectId
+	}
+	return ""
+}
+
+func (x *GetMarkdownResponse) GetMarkdown() string {
+	if x != nil {
+		return x.Markdown
+	}
+	return ""
+}
+
+// UpdateMarkdownRequest specifies parameters for updating a markdown file
+type UpdateMarkdownRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The prefix (directory path) where index.md is located
+	Prefix string `protobuf:"bytes,1,opt,name=prefix,proto3" json:"prefix,omitempty"`
+	// The new markdown content with YAML frontmatter
+	Markdown      string `protobuf:"bytes,2,opt,name=markdown,proto3" json:"markdown,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UpdateMarkdownRequest) Reset() {
+	*x = UpdateMarkdownRequest{}
+	mi := &file_proto_photos_proto_msgTypes[57]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *UpdateMarkdownRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*UpdateMarkdownRequest) ProtoMessage() {}
+
+func (x *UpdateMarkdownRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[57]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UpdateMarkdownRequest.ProtoReflect.Descriptor instead.
+func (*UpdateMarkdownRequest) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{57}
+}
+
+func (x *UpdateMarkdownRequest) GetPrefix() string {
+	if x != nil {
+		return x.Prefix
+	}
+	return ""
+}
+
+func (x *UpdateMarkdownRequest) GetMarkdown() string {
+	if x != nil {
+		return x.Markdown
+	}
+	return ""
+}
+
+// UpdateMarkdownResponse confirms the markdown file update
+type UpdateMarkdownResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The full object ID of the updated markdown file
+	ObjectId      string `protobuf:"bytes,1,opt,name=object_id,json=objectId,proto3" json:"object_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *UpdateMarkdownResponse) Reset() {
+	*x = UpdateMarkdownResponse{}
+	mi := &file_proto_photos_proto_msgTypes[58]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *SyncDatabaseProgress) String() string {
+func (x *UpdateMarkdownResponse) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*SyncDatabaseProgress) ProtoMessage() {}
+func (*UpdateMarkdownResponse) ProtoMessage() {}
 
-func (x *SyncDatabaseProgress) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_photos_proto_msgTypes[24]
+func (x *UpdateMarkdownResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[58]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use UpdateMarkdownResponse.ProtoReflect.Descriptor instead.
+func (*UpdateMarkdownResponse) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{58}
+}
+
+func (x *UpdateMarkdownResponse) GetObjectId() string {
+	if x != nil {
+		return x.ObjectId
+	}
+	return ""
+}
+
+// DeleteMarkdownRequest specifies parameters for deleting a markdown file
+type DeleteMarkdownRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The prefix (directory path) where index.md is located
+	Prefix        string `protobuf:"bytes,1,opt,name=prefix,proto3" json:"prefix,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteMarkdownRequest) Reset() {
+	*x = DeleteMarkdownRequest{}
+	mi := &file_proto_photos_proto_msgTypes[59]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteMarkdownRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteMarkdownRequest) ProtoMessage() {}
+
+func (x *DeleteMarkdownRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[59]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteMarkdownRequest.ProtoReflect.Descriptor instead.
+func (*DeleteMarkdownRequest) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{59}
+}
+
+func (x *DeleteMarkdownRequest) GetPrefix() string {
+	if x != nil {
+		return x.Prefix
+	}
+	return ""
+}
+
+// DeleteMarkdownResponse confirms the markdown file deletion
+type DeleteMarkdownResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Whether the deletion was successful
+	Success       bool `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DeleteMarkdownResponse) Reset() {
+	*x = DeleteMarkdownResponse{}
+	mi := &file_proto_photos_proto_msgTypes[60]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DeleteMarkdownResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeleteMarkdownResponse) ProtoMessage() {}
+
+func (x *DeleteMarkdownResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[60]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeleteMarkdownResponse.ProtoReflect.Descriptor instead.
+func (*DeleteMarkdownResponse) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{60}
+}
+
+func (x *DeleteMarkdownResponse) GetSuccess() bool {
+	if x != nil {
+		return x.Success
+	}
+	return false
+}
+
+// GenerateVideoThumbnailRequest specifies parameters for generating a video thumbnail
+type GenerateVideoThumbnailRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The object ID of the video
+	ObjectId string `protobuf:"bytes,1,opt,name=object_id,json=objectId,proto3" json:"object_id,omitempty"`
+	// Time offset in milliseconds to capture the frame. If 0 (the default), a
+	// representative frame of the first seconds is chosen, skipping black
+	// frames.
+	TimeOffsetMs  int64 `protobuf:"varint,2,opt,name=time_offset_ms,json=timeOffsetMs,proto3" json:"time_offset_ms,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GenerateVideoThumbnailRequest) Reset() {
+	*x = GenerateVideoThumbnailRequest{}
+	mi := &file_proto_photos_proto_msgTypes[61]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GenerateVideoThumbnailRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GenerateVideoThumbnailRequest) ProtoMessage() {}
+
+func (x *GenerateVideoThumbnailRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[61]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GenerateVideoThumbnailRequest.ProtoReflect.Descriptor instead.
+func (*GenerateVideoThumbnailRequest) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{61}
+}
+
+func (x *GenerateVideoThumbnailRequest) GetObjectId() string {
+	if x != nil {
+		return x.ObjectId
+	}
+	return ""
+}
+
+func (x *GenerateVideoThumbnailRequest) GetTimeOffsetMs() int64 {
+	if x != nil {
+		return x.TimeOffsetMs
+	}
+	return 0
+}
+
+// GenerateVideoThumbnailResponse returns the generated thumbnail information
+type GenerateVideoThumbnailResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The object ID of the generated thumbnail
+	ThumbnailObjectId string `protobuf:"bytes,1,opt,name=thumbnail_object_id,json=thumbnailObjectId,proto3" json:"thumbnail_object_id,omitempty"`
+	// A signed URL to access the thumbnail
+	SignedUrl string `protobuf:"bytes,2,opt,name=signed_url,json=signedUrl,proto3" json:"signed_url,omitempty"`
+	// When the signed URL expires (RFC3339 format)
+	ExpiresAt     string `protobuf:"bytes,3,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GenerateVideoThumbnailResponse) Reset() {
+	*x = GenerateVideoThumbnailResponse{}
+	mi := &file_proto_photos_proto_msgTypes[62]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GenerateVideoThumbnailResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GenerateVideoThumbnailResponse) ProtoMessage() {}
+
+func (x *GenerateVideoThumbnailResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[62]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GenerateVideoThumbnailResponse.ProtoReflect.Descriptor instead.
+func (*GenerateVideoThumbnailResponse) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{62}
+}
+
+func (x *GenerateVideoThumbnailResponse) GetThumbnailObjectId() string {
+	if x != nil {
+		return x.ThumbnailObjectId
+	}
+	return ""
+}
+
+func (x *GenerateVideoThumbnailResponse) GetSignedUrl() string {
+	if x != nil {
+		return x.SignedUrl
+	}
+	return ""
+}
+
+func (x *GenerateVideoThumbnailResponse) GetExpiresAt() string {
+	if x != nil {
+		return x.ExpiresAt
+	}
+	return ""
+}
+
+// GenerateDNGPreviewRequest specifies the RAW photo for which to generate a JPEG preview
+type GenerateDNGPreviewRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The object ID of the RAW photo (DNG, CR2, CR3, NEF, ARW, RAF or ORF)
+	ObjectId      string `protobuf:"bytes,1,opt,name=object_id,json=objectId,proto3" json:"object_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GenerateDNGPreviewRequest) Reset() {
+	*x = GenerateDNGPreviewRequest{}
+	mi := &file_proto_photos_proto_msgTypes[63]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GenerateDNGPreviewRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GenerateDNGPreviewRequest) ProtoMessage() {}
+
+func (x *GenerateDNGPreviewRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[63]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1572,85 +4753,104 @@ func (x *SyncDatabaseProgress) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use SyncDatabaseProgress.ProtoReflect.Descriptor instead.
-func (*SyncDatabaseProgress) Descriptor() ([]byte, []int) {
-	return file_proto_photos_proto_rawDescGZIP(), []int{24}
+// Deprecated: Use GenerateDNGPreviewRequest.ProtoReflect.Descriptor instead.
+func (*GenerateDNGPreviewRequest) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{63}
 }
 
-func (x *SyncDatabaseProgress) GetPhase() SyncDatabaseProgress_Phase {
+func (x *GenerateDNGPreviewRequest) GetObjectId() string {
 	if x != nil {
-		return x.Phase
+		return x.ObjectId
 	}
-	return SyncDatabaseProgress_PHASE_UNSPECIFIED
+	return ""
 }
 
-func (x *SyncDatabaseProgress) GetProcessed() uint32 {
-	if x != nil {
-		return x.Processed
-	}
-	return 0
+// GenerateDNGPreviewResponse returns the generated preview information
+type GenerateDNGPreviewResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The object ID of the generated JPEG preview
+	ThumbnailObjectId string `protobuf:"bytes,1,opt,name=thumbnail_object_id,json=thumbnailObjectId,proto3" json:"thumbnail_object_id,omitempty"`
+	// A signed URL to access the preview
+	SignedUrl string `protobuf:"bytes,2,opt,name=signed_url,json=signedUrl,proto3" json:"signed_url,omitempty"`
+	// When the signed URL expires (RFC3339 format)
+	ExpiresAt     string `protobuf:"bytes,3,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
 }
 
-func (x *SyncDatabaseProgress) GetTotal() uint32 {
-	if x != nil {
-		return x.Total
-	}
-	return 0
+func (x *GenerateDNGPreviewResponse) Reset() {
+	*x = GenerateDNGPreviewResponse{}
+	mi := &file_proto_photos_proto_msgTypes[64]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
 }
 
-func (x *SyncDatabaseProgress) GetAdded() uint32 {
+func (x *GenerateDNGPreviewResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GenerateDNGPreviewResponse) ProtoMessage() {}
+
+func (x *GenerateDNGPreviewResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[64]
 	if x != nil {
-		return x.Added
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
 	}
-	return 0
+	return mi.MessageOf(x)
 }
 
-func (x *SyncDatabaseProgress) GetRemoved() uint32 {
+// Deprecated: Use GenerateDNGPreviewResponse.ProtoReflect.Descriptor instead.
+func (*GenerateDNGPreviewResponse) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{64}
+}
+
+func (x *GenerateDNGPreviewResponse) GetThumbnailObjectId() string {
 	if x != nil {
-		return x.Removed
+		return x.ThumbnailObjectId
 	}
-	return 0
+	return ""
 }
 
-func (x *SyncDatabaseProgress) GetMetadataUpdated() uint32 {
+func (x *GenerateDNGPreviewResponse) GetSignedUrl() string {
 	if x != nil {
-		return x.MetadataUpdated
+		return x.SignedUrl
 	}
-	return 0
+	return ""
 }
 
-func (x *SyncDatabaseProgress) GetComplete() bool {
+func (x *GenerateDNGPreviewResponse) GetExpiresAt() string {
 	if x != nil {
-		return x.Complete
+		return x.ExpiresAt
 	}
-	return false
+	return ""
 }
 
-// UpdateWebpRequest specifies options for generating missing WebP renditions.
-type UpdateWebpRequest struct {
-	state protoimpl.MessageState `protogen:"open.v1"`
-	// Seconds to sleep between per-object WebP generations. Used to reduce CPU
-	// pressure on the server during large runs.
-	PauseBetweenObjectsSeconds uint32 `protobuf:"varint,1,opt,name=pause_between_objects_seconds,json=pauseBetweenObjectsSeconds,proto3" json:"pause_between_objects_seconds,omitempty"`
-	unknownFields              protoimpl.UnknownFields
-	sizeCache                  protoimpl.SizeCache
+// GetUsageRequest requests the storage usage of the authenticated user
+type GetUsageRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
 }
 
-func (x *UpdateWebpRequest) Reset() {
-	*x = UpdateWebpRequest{}
-	mi := &file_proto_photos_proto_msgTypes[25]
+func (x *GetUsageRequest) Reset() {
+	*x = GetUsageRequest{}
+	mi := &file_proto_photos_proto_msgTypes[65]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *UpdateWebpRequest) String() string {
+func (x *GetUsageRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*UpdateWebpRequest) ProtoMessage() {}
+func (*GetUsageRequest) ProtoMessage() {}
 
-func (x *UpdateWebpRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_photos_proto_msgTypes[25]
+func (x *GetUsageRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[65]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1661,55 +4861,61 @@ func (x *UpdateWebpRequest) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use UpdateWebpRequest.ProtoReflect.Descriptor instead.
-func (*UpdateWebpRequest) Descriptor() ([]byte, []int) {
-	return file_proto_photos_proto_rawDescGZIP(), []int{25}
-}
-
-func (x *UpdateWebpRequest) GetPauseBetweenObjectsSeconds() uint32 {
-	if x != nil {
-		return x.PauseBetweenObjectsSeconds
-	}
-	return 0
+// Deprecated: Use GetUsageRequest.ProtoReflect.Descriptor instead.
+func (*GetUsageRequest) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{65}
 }
 
-// UpdateWebpProgress is streamed from UpdateWebp as it advances. A message is
-// emitted per processed object, plus one final message with complete=true
-// summarising the run.
-type UpdateWebpProgress struct {
+// GetUsageResponse breaks down the storage used by the authenticated user.
+// Quotas apply to originals and XMP sidecars; derived assets are reported for
+// information.
+type GetUsageResponse struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
-	// processed is the number of eligible database objects processed so far.
-	Processed uint32 `protobuf:"varint,1,opt,name=processed,proto3" json:"processed,omitempty"`
-	// total is the number of eligible database objects to process.
-	Total uint32 `protobuf:"varint,2,opt,name=total,proto3" json:"total,omitempty"`
-	// generated is the cumulative count of WebP renditions successfully created.
-	Generated uint32 `protobuf:"varint,3,opt,name=generated,proto3" json:"generated,omitempty"`
-	// skipped is the cumulative count of objects skipped (e.g. unsupported
-	// content type or missing source data).
-	Skipped uint32 `protobuf:"varint,4,opt,name=skipped,proto3" json:"skipped,omitempty"`
-	// failed is the cumulative count of objects whose WebP generation failed.
-	Failed uint32 `protobuf:"varint,5,opt,name=failed,proto3" json:"failed,omitempty"`
-	// complete is set on the final summary message of the run.
-	Complete      bool `protobuf:"varint,6,opt,name=complete,proto3" json:"complete,omitempty"`
+	// object_count is the number of original photos and videos
+	ObjectCount int64 `protobuf:"varint,1,opt,name=object_count,json=objectCount,proto3" json:"object_count,omitempty"`
+	// original_bytes is the total size of original photos and videos
+	OriginalBytes int64 `protobuf:"varint,2,opt,name=original_bytes,json=originalBytes,proto3" json:"original_bytes,omitempty"`
+	// webp_bytes is the total size of WebP renditions
+	WebpBytes int64 `protobuf:"varint,3,opt,name=webp_bytes,json=webpBytes,proto3" json:"webp_bytes,omitempty"`
+	// avif_bytes is the total size of AVIF renditions
+	AvifBytes int64 `protobuf:"varint,11,opt,name=avif_bytes,json=avifBytes,proto3" json:"avif_bytes,omitempty"`
+	// video_bytes is the total size of MP4 proxies and HLS renditions of videos
+	// and of the videos extracted from motion photos
+	VideoBytes int64 `protobuf:"varint,12,opt,name=video_bytes,json=videoBytes,proto3" json:"video_bytes,omitempty"`
+	// preview_bytes is the total size of JPEG previews of RAW and HEIC files
+	PreviewBytes int64 `protobuf:"varint,4,opt,name=preview_bytes,json=previewBytes,proto3" json:"preview_bytes,omitempty"`
+	// thumbnail_bytes is the total size of video thumbnails and animated
+	// previews
+	ThumbnailBytes int64 `protobuf:"varint,5,opt,name=thumbnail_bytes,json=thumbnailBytes,proto3" json:"thumbnail_bytes,omitempty"`
+	// sidecar_bytes is the total size of XMP sidecars
+	SidecarBytes int64 `protobuf:"varint,6,opt,name=sidecar_bytes,json=sidecarBytes,proto3" json:"sidecar_bytes,omitempty"`
+	// rendition_bytes is the total size of pre-generated thumbnails
+	RenditionBytes int64 `protobuf:"varint,10,opt,name=rendition_bytes,json=renditionBytes,proto3" json:"rendition_bytes,omitempty"`
+	// total_bytes is the sum of all of the above
+	TotalBytes int64 `protobuf:"varint,7,opt,name=total_bytes,json=totalBytes,proto3" json:"total_bytes,omitempty"`
+	// quota_bytes caps original_bytes plus sidecar_bytes; zero means unlimited
+	QuotaBytes int64 `protobuf:"varint,8,opt,name=quota_bytes,json=quotaBytes,proto3" json:"quota_bytes,omitempty"`
+	// quota_objects caps object_count; zero means unlimited
+	QuotaObjects  int64 `protobuf:"varint,9,opt,name=quota_objects,json=quotaObjects,proto3" json:"quota_objects,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *UpdateWebpProgress) Reset() {
-	*x = UpdateWebpProgress{}
-	mi := &file_proto_photos_proto_msgTypes[26]
+func (x *GetUsageResponse) Reset() {
+	*x = GetUsageResponse{}
+	mi := &file_proto_photos_proto_msgTypes[66]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *UpdateWebpProgress) String() string {
+func (x *GetUsageResponse) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*UpdateWebpProgress) ProtoMessage() {}
+func (*GetUsageResponse) ProtoMessage() {}
 
-func (x *UpdateWebpProgress) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_photos_proto_msgTypes[26]
+func (x *GetUsageResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[66]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1720,81 +4926,139 @@ func (x *UpdateWebpProgress) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use UpdateWebpProgress.ProtoReflect.Descriptor instead.
-func (*UpdateWebpProgress) Descriptor() ([]byte, []int) {
-	return file_proto_photos_proto_rawDescGZIP(), []int{26}
+// Deprecated: Use GetUsageResponse.ProtoReflect.Descriptor instead.
+func (*GetUsageResponse) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{66}
 }
 
-func (x *UpdateWebpProgress) GetProcessed() uint32 {
+func (x *GetUsageResponse) GetObjectCount() int64 {
 	if x != nil {
-		return x.Processed
+		return x.ObjectCount
 	}
 	return 0
 }
 
-func (x *UpdateWebpProgress) GetTotal() uint32 {
+func (x *GetUsageResponse) GetOriginalBytes() int64 {
 	if x != nil {
-		return x.Total
+		return x.OriginalBytes
 	}
 	return 0
 }
 
-func (x *UpdateWebpProgress) GetGenerated() uint32 {
+func (x *GetUsageResponse) GetWebpBytes() int64 {
 	if x != nil {
-		return x.Generated
+		return x.WebpBytes
 	}
 	return 0
 }
 
-func (x *UpdateWebpProgress) GetSkipped() uint32 {
+func (x *GetUsageResponse) GetAvifBytes() int64 {
 	if x != nil {
-		return x.Skipped
+		return x.AvifBytes
 	}
 	return 0
 }
 
-func (x *UpdateWebpProgress) GetFailed() uint32 {
+func (x *GetUsageResponse) GetVideoBytes() int64 {
 	if x != nil {
-		return x.Failed
+		return x.VideoBytes
 	}
 	return 0
 }
 
-func (x *UpdateWebpProgress) GetComplete() bool {
+func (x *GetUsageResponse) GetPreviewBytes() int64 {
 	if x != nil {
-		return x.Complete
+		return x.PreviewBytes
 	}
-	return false
+	return 0
 }
 
-// StreamingUploadRequest is sent as a stream of chunks for large uploads
-type StreamingUploadRequest struct {
+func (x *GetUsageResponse) GetThumbnailBytes() int64 {
+	if x != nil {
+		return x.ThumbnailBytes
+	}
+	return 0
+}
+
+func (x *GetUsageResponse) GetSidecarBytes() int64 {
+	if x != nil {
+		return x.SidecarBytes
+	}
+	return 0
+}
+
+func (x *GetUsageResponse) GetRenditionBytes() int64 {
+	if x != nil {
+		return x.RenditionBytes
+	}
+	return 0
+}
+
+func (x *GetUsageResponse) GetTotalBytes() int64 {
+	if x != nil {
+		return x.TotalBytes
+	}
+	return 0
+}
+
+func (x *GetUsageResponse) GetQuotaBytes() int64 {
+	if x != nil {
+		return x.QuotaBytes
+	}
+	return 0
+}
+
+func (x *GetUsageResponse) GetQuotaObjects() int64 {
+	if x != nil {
+		return x.QuotaObjects
+	}
+	return 0
+}
+
+// Job is background work on a photo, such as generating the derived assets
+// of an upload, run by the job queue of the server
+type Job struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
-	// Types that are valid to be assigned to Data:
-	//
-	//	*StreamingUploadRequest_Metadata
-	//	*StreamingUploadRequest_Chunk
-	//	*StreamingUploadRequest_EndOfFile
-	Data          isStreamingUploadRequest_Data `protobuf_oneof:"data"`
+	Id    uint64                 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	// Kind of job: "derived_assets" or "transcode"
+	Kind string `protobuf:"bytes,2,opt,name=kind,proto3" json:"kind,omitempty"`
+	// Object ID of the photo or video the job works on
+	ObjectId string `protobuf:"bytes,3,opt,name=object_id,json=objectId,proto3" json:"object_id,omitempty"`
+	// Status of the job: "pending", "running", "succeeded" or "failed"
+	Status string `protobuf:"bytes,4,opt,name=status,proto3" json:"status,omitempty"`
+	// Number of attempts made so far
+	Attempts int32 `protobuf:"varint,5,opt,name=attempts,proto3" json:"attempts,omitempty"`
+	// Number of attempts made before the job is failed
+	MaxAttempts int32 `protobuf:"varint,6,opt,name=max_attempts,json=maxAttempts,proto3" json:"max_attempts,omitempty"`
+	// Error of the last failed attempt
+	LastError string `protobuf:"bytes,7,opt,name=last_error,json=lastError,proto3" json:"last_error,omitempty"`
+	// When the job was queued (RFC3339 format)
+	CreatedAt string `protobuf:"bytes,8,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	// When a pending job is next attempted (RFC3339 format)
+	RunAfter string `protobuf:"bytes,9,opt,name=run_after,json=runAfter,proto3" json:"run_after,omitempty"`
+	// When the last attempt started and finished (RFC3339 format; empty if
+	// there has been none)
+	StartedAt     string `protobuf:"bytes,10,opt,name=started_at,json=startedAt,proto3" json:"started_at,omitempty"`
+	FinishedAt    string `protobuf:"bytes,11,opt,name=finished_at,json=finishedAt,proto3" json:"finished_at,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *StreamingUploadRequest) Reset() {
-	*x = StreamingUploadRequest{}
-	mi := &file_proto_photos_proto_msgTypes[27]
+func (x *Job) Reset() {
+	*x = Job{}
+	mi := &file_proto_photos_proto_msgTypes[67]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *StreamingUploadRequest) String() string {
+func (x *Job) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*StreamingUploadRequest) ProtoMessage() {}
+func (*Job) ProtoMessage() {}
 
-func (x *StreamingUploadRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_photos_proto_msgTypes[27]
+func (x *Job) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[67]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1805,167 +5069,115 @@ func (x *StreamingUploadRequest) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use StreamingUploadRequest.ProtoReflect.Descriptor instead.
-func (*StreamingUploadRequest) Descriptor() ([]byte, []int) {
-	return file_proto_photos_proto_rawDescGZIP(), []int{27}
-}
-
-func (x *StreamingUploadRequest) GetData() isStreamingUploadRequest_Data {
-	if x != nil {
-		return x.Data
-	}
-	return nil
+// Deprecated: Use Job.ProtoReflect.Descriptor instead.
+func (*Job) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{67}
 }
 
-func (x *StreamingUploadRequest) GetMetadata() *PhotoMetadata {
+func (x *Job) GetId() uint64 {
 	if x != nil {
-		if x, ok := x.Data.(*StreamingUploadRequest_Metadata); ok {
-			return x.Metadata
-		}
+		return x.Id
 	}
-	return nil
+	return 0
 }
 
-func (x *StreamingUploadRequest) GetChunk() []byte {
+func (x *Job) GetKind() string {
 	if x != nil {
-		if x, ok := x.Data.(*StreamingUploadRequest_Chunk); ok {
-			return x.Chunk
-		}
+		return x.Kind
 	}
-	return nil
+	return ""
 }
 
-func (x *StreamingUploadRequest) GetEndOfFile() bool {
+func (x *Job) GetObjectId() string {
 	if x != nil {
-		if x, ok := x.Data.(*StreamingUploadRequest_EndOfFile); ok {
-			return x.EndOfFile
-		}
+		return x.ObjectId
 	}
-	return false
-}
-
-type isStreamingUploadRequest_Data interface {
-	isStreamingUploadRequest_Data()
-}
-
-type StreamingUploadRequest_Metadata struct {
-	// metadata is sent in the first message only
-	Metadata *PhotoMetadata `protobuf:"bytes,1,opt,name=metadata,proto3,oneof"`
-}
-
-type StreamingUploadRequest_Chunk struct {
-	// chunk contains a portion of the photo data
-	Chunk []byte `protobuf:"bytes,2,opt,name=chunk,proto3,oneof"`
-}
-
-type StreamingUploadRequest_EndOfFile struct {
-	// end_of_file signals the end of the current file in a bulk upload; value is ignored
-	EndOfFile bool `protobuf:"varint,3,opt,name=end_of_file,json=endOfFile,proto3,oneof"`
-}
-
-func (*StreamingUploadRequest_Metadata) isStreamingUploadRequest_Data() {}
-
-func (*StreamingUploadRequest_Chunk) isStreamingUploadRequest_Data() {}
-
-func (*StreamingUploadRequest_EndOfFile) isStreamingUploadRequest_Data() {}
-
-// BulkUploadFileResult is streamed back for each file in a bulk upload
-type BulkUploadFileResult struct {
-	state protoimpl.MessageState `protogen:"open.v1"`
-	// object_id identifies which file this result corresponds to
-	ObjectId string `protobuf:"bytes,1,opt,name=object_id,json=objectId,proto3" json:"object_id,omitempty"`
-	// success indicates whether the upload succeeded
-	Success bool `protobuf:"varint,2,opt,name=success,proto3" json:"success,omitempty"`
-	// photo is populated on success
-	Photo *Photo `protobuf:"bytes,3,opt,name=photo,proto3" json:"photo,omitempty"`
-	// error_message is populated on failure
-	ErrorMessage  string `protobuf:"bytes,4,opt,name=error_message,json=errorMessage,proto3" json:"error_message,omitempty"`
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
+	return ""
 }
 
-func (x *BulkUploadFileResult) Reset() {
-	*x = BulkUploadFileResult{}
-	mi := &file_proto_photos_proto_msgTypes[28]
-	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
-	ms.StoreMessageInfo(mi)
+func (x *Job) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
 }
 
-func (x *BulkUploadFileResult) String() string {
-	return protoimpl.X.MessageStringOf(x)
+func (x *Job) GetAttempts() int32 {
+	if x != nil {
+		return x.Attempts
+	}
+	return 0
 }
 
-func (*BulkUploadFileResult) ProtoMessage() {}
-
-func (x *BulkUploadFileResult) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_photos_proto_msgTypes[28]
+func (x *Job) GetMaxAttempts() int32 {
 	if x != nil {
-		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
-		if ms.LoadMessageInfo() == nil {
-			ms.StoreMessageInfo(mi)
-		}
-		return ms
+		return x.MaxAttempts
 	}
-	return mi.MessageOf(x)
+	return 0
 }
 
-// Deprecated: Use BulkUploadFileResult.ProtoReflect.Descriptor instead.
-func (*BulkUploadFileResult) Descriptor() ([]byte, []int) {
-	return file_proto_photos_proto_rawDescGZIP(), []int{28}
+func (x *Job) GetLastError() string {
+	if x != nil {
+		return x.LastError
+	}
+	return ""
 }
 
-func (x *BulkUploadFileResult) GetObjectId() string {
+func (x *Job) GetCreatedAt() string {
 	if x != nil {
-		return x.ObjectId
+		return x.CreatedAt
 	}
 	return ""
 }
 
-func (x *BulkUploadFileResult) GetSuccess() bool {
+func (x *Job) GetRunAfter() string {
 	if x != nil {
-		return x.Success
+		return x.RunAfter
 	}
-	return false
+	return ""
 }
 
-func (x *BulkUploadFileResult) GetPhoto() *Photo {
+func (x *Job) GetStartedAt() string {
 	if x != nil {
-		return x.Photo
+		return x.StartedAt
 	}
-	return nil
+	return ""
 }
 
-func (x *BulkUploadFileResult) GetErrorMessage() string {
+func (x *Job) GetFinishedAt() string {
 	if x != nil {
-		return x.ErrorMessage
+		return x.FinishedAt
 	}
 	return ""
 }
 
-// PhotoMetadata contains info about the photo being uploaded
-type PhotoMetadata struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Filename      string                 `protobuf:"bytes,1,opt,name=filename,proto3" json:"filename,omitempty"`
-	ContentType   string                 `protobuf:"bytes,2,opt,name=content_type,json=contentType,proto3" json:"content_type,omitempty"`
+// ListJobsRequest specifies the status of the jobs to list and pagination
+type ListJobsRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// status filters the jobs by status (e.g. "failed"); all jobs are listed
+	// if empty
+	Status        string `protobuf:"bytes,1,opt,name=status,proto3" json:"status,omitempty"`
+	PageSize      int32  `protobuf:"varint,2,opt,name=page_size,json=pageSize,proto3" json:"page_size,omitempty"`
+	PageToken     string `protobuf:"bytes,3,opt,name=page_token,json=pageToken,proto3" json:"page_token,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *PhotoMetadata) Reset() {
-	*x = PhotoMetadata{}
-	mi := &file_proto_photos_proto_msgTypes[29]
+func (x *ListJobsRequest) Reset() {
+	*x = ListJobsRequest{}
+	mi := &file_proto_photos_proto_msgTypes[68]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *PhotoMetadata) String() string {
+func (x *ListJobsRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*PhotoMetadata) ProtoMessage() {}
+func (*ListJobsRequest) ProtoMessage() {}
 
-func (x *PhotoMetadata) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_photos_proto_msgTypes[29]
+func (x *ListJobsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[68]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -1976,50 +5188,56 @@ func (x *PhotoMetadata) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use PhotoMetadata.ProtoReflect.Descriptor instead.
-func (*PhotoMetadata) Descriptor() ([]byte, []int) {
-	return file_proto_photos_proto_rawDescGZIP(), []int{29}
+// Deprecated: Use ListJobsRequest.ProtoReflect.Descriptor instead.
+func (*ListJobsRequest) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{68}
 }
 
-func (x *PhotoMetadata) GetFilename() string {
+func (x *ListJobsRequest) GetStatus() string {
 	if x != nil {
-		return x.Filename
+		return x.Status
 	}
 	return ""
 }
 
-func (x *PhotoMetadata) GetContentType() string {
+func (x *ListJobsRequest) GetPageSize() int32 {
 	if x != nil {
-		return x.ContentType
+		return x.PageSize
+	}
+	return 0
+}
+
+func (x *ListJobsRequest) GetPageToken() string {
+	if x != nil {
+		return x.PageToken
 	}
 	return ""
 }
 
-// StreamingDownloadRequest specifies which photo to download
-type StreamingDownloadRequest struct {
-	state    protoimpl.MessageState `protogen:"open.v1"`
-	ObjectId string                 `protobuf:"bytes,1,opt,name=object_id,json=objectId,proto3" json:"object_id,omitempty"`
-	// If true, GPS location data will be removed from the downloaded image EXIF
-	StripLocation bool `protobuf:"varint,2,opt,name=strip_location,json=stripLocation,proto3" json:"strip_location,omitempty"`
+// ListJobsResponse returns a page of jobs, newest first
+type ListJobsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Jobs          []*Job                 `protobuf:"bytes,1,rep,name=jobs,proto3" json:"jobs,omitempty"`
+	NextPageToken string                 `protobuf:"bytes,2,opt,name=next_page_token,json=nextPageToken,proto3" json:"next_page_token,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *StreamingDownloadRequest) Reset() {
-	*x = StreamingDownloadRequest{}
-	mi := &file_proto_photos_proto_msgTypes[30]
+func (x *ListJobsResponse) Reset() {
+	*x = ListJobsResponse{}
+	mi := &file_proto_photos_proto_msgTypes[69]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *StreamingDownloadRequest) String() string {
+func (x *ListJobsResponse) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*StreamingDownloadRequest) ProtoMessage() {}
+func (*ListJobsResponse) ProtoMessage() {}
 
-func (x *StreamingDownloadRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_photos_proto_msgTypes[30]
+func (x *ListJobsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[69]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -2030,52 +5248,48 @@ func (x *StreamingDownloadRequest) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use StreamingDownloadRequest.ProtoReflect.Descriptor instead.
-func (*StreamingDownloadRequest) Descriptor() ([]byte, []int) {
-	return file_proto_photos_proto_rawDescGZIP(), []int{30}
+// Deprecated: Use ListJobsResponse.ProtoReflect.Descriptor instead.
+func (*ListJobsResponse) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{69}
 }
 
-func (x *StreamingDownloadRequest) GetObjectId() string {
+func (x *ListJobsResponse) GetJobs() []*Job {
 	if x != nil {
-		return x.ObjectId
+		return x.Jobs
 	}
-	return ""
+	return nil
 }
 
-func (x *StreamingDownloadRequest) GetStripLocation() bool {
+func (x *ListJobsResponse) GetNextPageToken() string {
 	if x != nil {
-		return x.StripLocation
+		return x.NextPageToken
 	}
-	return false
+	return ""
 }
 
-// StreamingDownloadResponse is streamed back in chunks
-type StreamingDownloadResponse struct {
-	state protoimpl.MessageState `protogen:"open.v1"`
-	// Types that are valid to be assigned to Data:
-	//
-	//	*StreamingDownloadResponse_Metadata
-	//	*StreamingDownloadResponse_Chunk
-	Data          isStreamingDownloadResponse_Data `protobuf_oneof:"data"`
+// GetJobRequest specifies the job to retrieve
+type GetJobRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            uint64                 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *StreamingDownloadResponse) Reset() {
-	*x = StreamingDownloadResponse{}
-	mi := &file_proto_photos_proto_msgTypes[31]
+func (x *GetJobRequest) Reset() {
+	*x = GetJobRequest{}
+	mi := &file_proto_photos_proto_msgTypes[70]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *StreamingDownloadResponse) String() string {
+func (x *GetJobRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*StreamingDownloadResponse) ProtoMessage() {}
+func (*GetJobRequest) ProtoMessage() {}
 
-func (x *StreamingDownloadResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_photos_proto_msgTypes[31]
+func (x *GetJobRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[70]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -2086,80 +5300,41 @@ func (x *StreamingDownloadResponse) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use StreamingDownloadResponse.ProtoReflect.Descriptor instead.
-func (*StreamingDownloadResponse) Descriptor() ([]byte, []int) {
-	return file_proto_photos_proto_rawDescGZIP(), []int{31}
-}
-
-func (x *StreamingDownloadResponse) GetData() isStreamingDownloadResponse_Data {
-	if x != nil {
-		return x.Data
-	}
-	return nil
-}
-
-func (x *StreamingDownloadResponse) GetMetadata() *Photo {
-	if x != nil {
-		if x, ok := x.Data.(*StreamingDownloadResponse_Metadata); ok {
-			return x.Metadata
-		}
-	}
-	return nil
+// Deprecated: Use GetJobRequest.ProtoReflect.Descriptor instead.
+func (*GetJobRequest) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{70}
 }
 
-func (x *StreamingDownloadResponse) GetChunk() []byte {
+func (x *GetJobRequest) GetId() uint64 {
 	if x != nil {
-		if x, ok := x.Data.(*StreamingDownloadResponse_Chunk); ok {
-			return x.Chunk
-		}
+		return x.Id
 	}
-	return nil
-}
-
-type isStreamingDownloadResponse_Data interface {
-	isStreamingDownloadResponse_Data()
-}
-
-type StreamingDownloadResponse_Metadata struct {
-	// metadata is sent in the first message only
-	Metadata *Photo `protobuf:"bytes,1,opt,name=metadata,proto3,oneof"`
-}
-
-type StreamingDownloadResponse_Chunk struct {
-	// chunk contains a portion of the photo data
-	Chunk []byte `protobuf:"bytes,2,opt,name=chunk,proto3,oneof"`
+	return 0
 }
 
-func (*StreamingDownloadResponse_Metadata) isStreamingDownloadResponse_Data() {}
-
-func (*StreamingDownloadResponse_Chunk) isStreamingDownloadResponse_Data() {}
-
-// CreateMarkdownRequest specifies parameters for creating a markdown file
-type CreateMarkdownRequest struct {
-	state protoimpl.MessageState `protogen:"open.v1"`
-	// The prefix (directory path) where index.md will be created
-	Prefix string `protobuf:"bytes,1,opt,name=prefix,proto3" json:"prefix,omitempty"`
-	// The markdown content with YAML frontmatter
-	Markdown      string `protobuf:"bytes,2,opt,name=markdown,proto3" json:"markdown,omitempty"`
+// GetJobResponse returns the job
+type GetJobResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Job           *Job                   `protobuf:"bytes,1,opt,name=job,proto3" json:"job,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *CreateMarkdownRequest) Reset() {
-	*x = CreateMarkdownRequest{}
-	mi := &file_proto_photos_proto_msgTypes[32]
+func (x *GetJobResponse) Reset() {
+	*x = GetJobResponse{}
+	mi := &file_proto_photos_proto_msgTypes[71]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *CreateMarkdownRequest) String() string {
+func (x *GetJobResponse) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*CreateMarkdownRequest) ProtoMessage() {}
+func (*GetJobResponse) ProtoMessage() {}
 
-func (x *CreateMarkdownRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_photos_proto_msgTypes[32]
+func (x *GetJobResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[71]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -2170,49 +5345,41 @@ func (x *CreateMarkdownRequest) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use CreateMarkdownRequest.ProtoReflect.Descriptor instead.
-func (*CreateMarkdownRequest) Descriptor() ([]byte, []int) {
-	return file_proto_photos_proto_rawDescGZIP(), []int{32}
-}
-
-func (x *CreateMarkdownRequest) GetPrefix() string {
-	if x != nil {
-		return x.Prefix
-	}
-	return ""
+// Deprecated: Use GetJobResponse.ProtoReflect.Descriptor instead.
+func (*GetJobResponse) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{71}
 }
 
-func (x *CreateMarkdownRequest) GetMarkdown() string {
+func (x *GetJobResponse) GetJob() *Job {
 	if x != nil {
-		return x.Markdown
+		return x.Job
 	}
-	return ""
+	return nil
 }
 
-// CreateMarkdownResponse confirms the markdown file creation
-type CreateMarkdownResponse struct {
-	state protoimpl.MessageState `protogen:"open.v1"`
-	// The full object ID of the created markdown file
-	ObjectId      string `protobuf:"bytes,1,opt,name=object_id,json=objectId,proto3" json:"object_id,omitempty"`
+// RetryJobRequest specifies the failed job to run again
+type RetryJobRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            uint64                 `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *CreateMarkdownResponse) Reset() {
-	*x = CreateMarkdownResponse{}
-	mi := &file_proto_photos_proto_msgTypes[33]
+func (x *RetryJobRequest) Reset() {
+	*x = RetryJobRequest{}
+	mi := &file_proto_photos_proto_msgTypes[72]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *CreateMarkdownResponse) String() string {
+func (x *RetryJobRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*CreateMarkdownResponse) ProtoMessage() {}
+func (*RetryJobRequest) ProtoMessage() {}
 
-func (x *CreateMarkdownResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_photos_proto_msgTypes[33]
+func (x *RetryJobRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[72]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -2223,42 +5390,41 @@ func (x *CreateMarkdownResponse) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use CreateMarkdownResponse.ProtoReflect.Descriptor instead.
-func (*CreateMarkdownResponse) Descriptor() ([]byte, []int) {
-	return file_proto_photos_proto_rawDescGZIP(), []int{33}
+// Deprecated: Use RetryJobRequest.ProtoReflect.Descriptor instead.
+func (*RetryJobRequest) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{72}
 }
 
-func (x *CreateMarkdownResponse) GetObjectId() string {
+func (x *RetryJobRequest) GetId() uint64 {
 	if x != nil {
-		return x.ObjectId
+		return x.Id
 	}
-	return ""
+	return 0
 }
 
-// GetMarkdownRequest specifies parameters for retrieving a markdown file
-type GetMarkdownRequest struct {
-	state protoimpl.MessageState `protogen:"open.v1"`
-	// The prefix (directory path) where index.md is located
-	Prefix        string `protobuf:"bytes,1,opt,name=prefix,proto3" json:"prefix,omitempty"`
+// RetryJobResponse returns the job, pending again
+type RetryJobResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Job           *Job                   `protobuf:"bytes,1,opt,name=job,proto3" json:"job,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *GetMarkdownRequest) Reset() {
-	*x = GetMarkdownRequest{}
-	mi := &file_proto_photos_proto_msgTypes[34]
+func (x *RetryJobResponse) Reset() {
+	*x = RetryJobResponse{}
+	mi := &file_proto_photos_proto_msgTypes[73]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *GetMarkdownRequest) String() string {
+func (x *RetryJobResponse) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*GetMarkdownRequest) ProtoMessage() {}
+func (*RetryJobResponse) ProtoMessage() {}
 
-func (x *GetMarkdownRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_photos_proto_msgTypes[34]
+func (x *RetryJobResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[73]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -2269,44 +5435,50 @@ func (x *GetMarkdownRequest) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use GetMarkdownRequest.ProtoReflect.Descriptor instead.
-func (*GetMarkdownRequest) Descriptor() ([]byte, []int) {
-	return file_proto_photos_proto_rawDescGZIP(), []int{34}
+// Deprecated: Use RetryJobResponse.ProtoReflect.Descriptor instead.
+func (*RetryJobResponse) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{73}
 }
 
-func (x *GetMarkdownRequest) GetPrefix() string {
+func (x *RetryJobResponse) GetJob() *Job {
 	if x != nil {
-		return x.Prefix
+		return x.Job
 	}
-	return ""
+	return nil
 }
 
-// GetMarkdownResponse returns the markdown file content
-type GetMarkdownResponse struct {
+// ScheduledRun is a run of a scheduled maintenance task for the user
+type ScheduledRun struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
-	// The full object ID of the markdown file
-	ObjectId string `protobuf:"bytes,1,opt,name=object_id,json=objectId,proto3" json:"object_id,omitempty"`
-	// The markdown content with YAML frontmatter
-	Markdown      string `protobuf:"bytes,2,opt,name=markdown,proto3" json:"markdown,omitempty"`
+	// Status of the run: "running", "succeeded" or "failed"
+	Status string `protobuf:"bytes,1,opt,name=status,proto3" json:"status,omitempty"`
+	// When the run started and finished (RFC3339 format; finished_at is empty
+	// while it is running)
+	StartedAt  string `protobuf:"bytes,2,opt,name=started_at,json=startedAt,proto3" json:"started_at,omitempty"`
+	FinishedAt string `protobuf:"bytes,3,opt,name=finished_at,json=finishedAt,proto3" json:"finished_at,omitempty"`
+	// What the run did, e.g. "added 3, removed 1"
+	Summary string `protobuf:"bytes,4,opt,name=summary,proto3" json:"summary,omitempty"`
+	// Why the run failed
+	Error         string `protobuf:"bytes,5,opt,name=error,proto3" json:"error,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *GetMarkdownResponse) Reset() {
-	*x = GetMarkdownResponse{}
-	mi := &file_proto_photos_proto_msgTypes[35]
+func (x *ScheduledRun) Reset() {
+	*x = ScheduledRun{}
+	mi := &file_proto_photos_proto_msgTypes[74]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *GetMarkdownResponse) String() string {
+func (x *ScheduledRun) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*GetMarkdownResponse) ProtoMessage() {}
+func (*ScheduledRun) ProtoMessage() {}
 
-func (x *GetMarkdownResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_photos_proto_msgTypes[35]
+func (x *ScheduledRun) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[74]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -2317,51 +5489,76 @@ func (x *GetMarkdownResponse) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use GetMarkdownResponse.ProtoReflect.Descriptor instead.
-func (*GetMarkdownResponse) Descriptor() ([]byte, []int) {
-	return file_proto_photos_proto_rawDescGZIP(), []int{35}
+// Deprecated: Use ScheduledRun.ProtoReflect.Descriptor instead.
+func (*ScheduledRun) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{74}
 }
 
-func (x *GetMarkdownResponse) GetObjectId() string {
+func (x *ScheduledRun) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *ScheduledRun) GetStartedAt() string {
+	if x != nil {
+		return x.StartedAt
+	}
+	return ""
+}
+
+func (x *ScheduledRun) GetFinishedAt() string {
+	if x != nil {
+		return x.FinishedAt
+	}
+	return ""
+}
+
+func (x *ScheduledRun) GetSummary() string {
 	if x != nil {
-		return x.ObjectId
+		return x.Summary
 	}
 	return ""
 }
 
-func (x *GetMarkdownResponse) GetMarkdown() string {
+func (x *ScheduledRun) GetError() string {
 	if x != nil {
-		return x.Markdown
+		return x.Error
 	}
 	return ""
 }
 
-// UpdateMarkdownRequest specifies parameters for updating a markdown file
-type UpdateMarkdownRequest struct {
+// Schedule is a maintenance task the server runs on a cron schedule
+type Schedule struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
-	// The prefix (directory path) where index.md is located
-	Prefix string `protobuf:"bytes,1,opt,name=prefix,proto3" json:"prefix,omitempty"`
-	// The new markdown content with YAML frontmatter
-	Markdown      string `protobuf:"bytes,2,opt,name=markdown,proto3" json:"markdown,omitempty"`
+	// Task run: "sync", "sync_metadata", "webp", "purge_trash" or "verify"
+	Task string `protobuf:"bytes,1,opt,name=task,proto3" json:"task,omitempty"`
+	// Cron expression of the schedule, e.g. "0 3 * * *"
+	Expression string `protobuf:"bytes,2,opt,name=expression,proto3" json:"expression,omitempty"`
+	// When the task next runs (RFC3339 format)
+	NextRunAt string `protobuf:"bytes,3,opt,name=next_run_at,json=nextRunAt,proto3" json:"next_run_at,omitempty"`
+	// The last run of the task for the user; unset if it has not run
+	LastRun       *ScheduledRun `protobuf:"bytes,4,opt,name=last_run,json=lastRun,proto3" json:"last_run,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *UpdateMarkdownRequest) Reset() {
-	*x = UpdateMarkdownRequest{}
-	mi := &file_proto_photos_proto_msgTypes[36]
+func (x *Schedule) Reset() {
+	*x = Schedule{}
+	mi := &file_proto_photos_proto_msgTypes[75]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *UpdateMarkdownRequest) String() string {
+func (x *Schedule) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*UpdateMarkdownRequest) ProtoMessage() {}
+func (*Schedule) ProtoMessage() {}
 
-func (x *UpdateMarkdownRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_photos_proto_msgTypes[36]
+func (x *Schedule) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[75]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -2372,49 +5569,61 @@ func (x *UpdateMarkdownRequest) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use UpdateMarkdownRequest.ProtoReflect.Descriptor instead.
-func (*UpdateMarkdownRequest) Descriptor() ([]byte, []int) {
-	return file_proto_photos_proto_rawDescGZIP(), []int{36}
+// Deprecated: Use Schedule.ProtoReflect.Descriptor instead.
+func (*Schedule) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{75}
 }
 
-func (x *UpdateMarkdownRequest) GetPrefix() string {
+func (x *Schedule) GetTask() string {
 	if x != nil {
-		return x.Prefix
+		return x.Task
 	}
 	return ""
 }
 
-func (x *UpdateMarkdownRequest) GetMarkdown() string {
+func (x *Schedule) GetExpression() string {
 	if x != nil {
-		return x.Markdown
+		return x.Expression
 	}
 	return ""
 }
 
-// UpdateMarkdownResponse confirms the markdown file update
-type UpdateMarkdownResponse struct {
-	state protoimpl.MessageState `protogen:"open.v1"`
-	// The full object ID of the updated markdown file
-	ObjectId      string `protobuf:"bytes,1,opt,name=object_id,json=objectId,proto3" json:"object_id,omitempty"`
+func (x *Schedule) GetNextRunAt() string {
+	if x != nil {
+		return x.NextRunAt
+	}
+	return ""
+}
+
+func (x *Schedule) GetLastRun() *ScheduledRun {
+	if x != nil {
+		return x.LastRun
+	}
+	return nil
+}
+
+// ListSchedulesRequest requests the maintenance schedules of the server
+type ListSchedulesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *UpdateMarkdownResponse) Reset() {
-	*x = UpdateMarkdownResponse{}
-	mi := &file_proto_photos_proto_msgTypes[37]
+func (x *ListSchedulesRequest) Reset() {
+	*x = ListSchedulesRequest{}
+	mi := &file_proto_photos_proto_msgTypes[76]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *UpdateMarkdownResponse) String() string {
+func (x *ListSchedulesRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*UpdateMarkdownResponse) ProtoMessage() {}
+func (*ListSchedulesRequest) ProtoMessage() {}
 
-func (x *UpdateMarkdownResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_photos_proto_msgTypes[37]
+func (x *ListSchedulesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[76]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -2425,42 +5634,34 @@ func (x *UpdateMarkdownResponse) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use UpdateMarkdownResponse.ProtoReflect.Descriptor instead.
-func (*UpdateMarkdownResponse) Descriptor() ([]byte, []int) {
-	return file_proto_photos_proto_rawDescGZIP(), []int{37}
-}
-
-func (x *UpdateMarkdownResponse) GetObjectId() string {
-	if x != nil {
-		return x.ObjectId
-	}
-	return ""
+// Deprecated: Use ListSchedulesRequest.ProtoReflect.Descriptor instead.
+func (*ListSchedulesRequest) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{76}
 }
 
-// DeleteMarkdownRequest specifies parameters for deleting a markdown file
-type DeleteMarkdownRequest struct {
-	state protoimpl.MessageState `protogen:"open.v1"`
-	// The prefix (directory path) where index.md is located
-	Prefix        string `protobuf:"bytes,1,opt,name=prefix,proto3" json:"prefix,omitempty"`
+// ListSchedulesResponse returns the maintenance schedules of the server
+type ListSchedulesResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Schedules     []*Schedule            `protobuf:"bytes,1,rep,name=schedules,proto3" json:"schedules,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *DeleteMarkdownRequest) Reset() {
-	*x = DeleteMarkdownRequest{}
-	mi := &file_proto_photos_proto_msgTypes[38]
+func (x *ListSchedulesResponse) Reset() {
+	*x = ListSchedulesResponse{}
+	mi := &file_proto_photos_proto_msgTypes[77]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *DeleteMarkdownRequest) String() string {
+func (x *ListSchedulesResponse) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*DeleteMarkdownRequest) ProtoMessage() {}
+func (*ListSchedulesResponse) ProtoMessage() {}
 
-func (x *DeleteMarkdownRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_photos_proto_msgTypes[38]
+func (x *ListSchedulesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[77]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -2471,42 +5672,40 @@ func (x *DeleteMarkdownRequest) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use DeleteMarkdownRequest.ProtoReflect.Descriptor instead.
-func (*DeleteMarkdownRequest) Descriptor() ([]byte, []int) {
-	return file_proto_photos_proto_rawDescGZIP(), []int{38}
+// Deprecated: Use ListSchedulesResponse.ProtoReflect.Descriptor instead.
+func (*ListSchedulesResponse) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{77}
 }
 
-func (x *DeleteMarkdownRequest) GetPrefix() string {
+func (x *ListSchedulesResponse) GetSchedules() []*Schedule {
 	if x != nil {
-		return x.Prefix
+		return x.Schedules
 	}
-	return ""
+	return nil
 }
 
-// DeleteMarkdownResponse confirms the markdown file deletion
-type DeleteMarkdownResponse struct {
-	state protoimpl.MessageState `protogen:"open.v1"`
-	// Whether the deletion was successful
-	Success       bool `protobuf:"varint,1,opt,name=success,proto3" json:"success,omitempty"`
+// GetServerCapabilitiesRequest requests the capabilities of the server
+type GetServerCapabilitiesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *DeleteMarkdownResponse) Reset() {
-	*x = DeleteMarkdownResponse{}
-	mi := &file_proto_photos_proto_msgTypes[39]
+func (x *GetServerCapabilitiesRequest) Reset() {
+	*x = GetServerCapabilitiesRequest{}
+	mi := &file_proto_photos_proto_msgTypes[78]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *DeleteMarkdownResponse) String() string {
+func (x *GetServerCapabilitiesRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*DeleteMarkdownResponse) ProtoMessage() {}
+func (*GetServerCapabilitiesRequest) ProtoMessage() {}
 
-func (x *DeleteMarkdownResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_photos_proto_msgTypes[39]
+func (x *GetServerCapabilitiesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[78]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -2517,44 +5716,44 @@ func (x *DeleteMarkdownResponse) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use DeleteMarkdownResponse.ProtoReflect.Descriptor instead.
-func (*DeleteMarkdownResponse) Descriptor() ([]byte, []int) {
-	return file_proto_photos_proto_rawDescGZIP(), []int{39}
-}
-
-func (x *DeleteMarkdownResponse) GetSuccess() bool {
-	if x != nil {
-		return x.Success
-	}
-	return false
+// Deprecated: Use GetServerCapabilitiesRequest.ProtoReflect.Descriptor instead.
+func (*GetServerCapabilitiesRequest) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{78}
 }
 
-// GenerateVideoThumbnailRequest specifies parameters for generating a video thumbnail
-type GenerateVideoThumbnailRequest struct {
+// ServerCapability describes how a feature depending on an external tool is
+// provided
+type ServerCapability struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
-	// The object ID of the video
-	ObjectId string `protobuf:"bytes,1,opt,name=object_id,json=objectId,proto3" json:"object_id,omitempty"`
-	// Time offset in milliseconds to capture the frame (default: 0)
-	TimeOffsetMs  int64 `protobuf:"varint,2,opt,name=time_offset_ms,json=timeOffsetMs,proto3" json:"time_offset_ms,omitempty"`
+	// feature is the name of the feature, e.g. "webp"
+	Feature string `protobuf:"bytes,1,opt,name=feature,proto3" json:"feature,omitempty"`
+	// tool is the name of the external tool, e.g. "cwebp"
+	Tool string `protobuf:"bytes,2,opt,name=tool,proto3" json:"tool,omitempty"`
+	// tool_path is where the tool was found; empty if it is missing
+	ToolPath string `protobuf:"bytes,3,opt,name=tool_path,json=toolPath,proto3" json:"tool_path,omitempty"`
+	// provider is how the feature is provided
+	Provider ServerCapability_Provider `protobuf:"varint,4,opt,name=provider,proto3,enum=photos.ServerCapability_Provider" json:"provider,omitempty"`
+	// fallback describes the in-process fallback; empty if there is none
+	Fallback      string `protobuf:"bytes,5,opt,name=fallback,proto3" json:"fallback,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *GenerateVideoThumbnailRequest) Reset() {
-	*x = GenerateVideoThumbnailRequest{}
-	mi := &file_proto_photos_proto_msgTypes[40]
+func (x *ServerCapability) Reset() {
+	*x = ServerCapability{}
+	mi := &file_proto_photos_proto_msgTypes[79]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *GenerateVideoThumbnailRequest) String() string {
+func (x *ServerCapability) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*GenerateVideoThumbnailRequest) ProtoMessage() {}
+func (*ServerCapability) ProtoMessage() {}
 
-func (x *GenerateVideoThumbnailRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_photos_proto_msgTypes[40]
+func (x *ServerCapability) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[79]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -2565,53 +5764,69 @@ func (x *GenerateVideoThumbnailRequest) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use GenerateVideoThumbnailRequest.ProtoReflect.Descriptor instead.
-func (*GenerateVideoThumbnailRequest) Descriptor() ([]byte, []int) {
-	return file_proto_photos_proto_rawDescGZIP(), []int{40}
+// Deprecated: Use ServerCapability.ProtoReflect.Descriptor instead.
+func (*ServerCapability) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{79}
 }
 
-func (x *GenerateVideoThumbnailRequest) GetObjectId() string {
+func (x *ServerCapability) GetFeature() string {
 	if x != nil {
-		return x.ObjectId
+		return x.Feature
 	}
 	return ""
 }
 
-func (x *GenerateVideoThumbnailRequest) GetTimeOffsetMs() int64 {
+func (x *ServerCapability) GetTool() string {
 	if x != nil {
-		return x.TimeOffsetMs
+		return x.Tool
 	}
-	return 0
+	return ""
 }
 
-// GenerateVideoThumbnailResponse returns the generated thumbnail information
-type GenerateVideoThumbnailResponse struct {
-	state protoimpl.MessageState `protogen:"open.v1"`
-	// The object ID of the generated thumbnail
-	ThumbnailObjectId string `protobuf:"bytes,1,opt,name=thumbnail_object_id,json=thumbnailObjectId,proto3" json:"thumbnail_object_id,omitempty"`
-	// A signed URL to access the thumbnail
-	SignedUrl string `protobuf:"bytes,2,opt,name=signed_url,json=signedUrl,proto3" json:"signed_url,omitempty"`
-	// When the signed URL expires (RFC3339 format)
-	ExpiresAt     string `protobuf:"bytes,3,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`
+func (x *ServerCapability) GetToolPath() string {
+	if x != nil {
+		return x.ToolPath
+	}
+	return ""
+}
+
+func (x *ServerCapability) GetProvider() ServerCapability_Provider {
+	if x != nil {
+		return x.Provider
+	}
+	return ServerCapability_PROVIDER_UNSPECIFIED
+}
+
+func (x *ServerCapability) GetFallback() string {
+	if x != nil {
+		return x.Fallback
+	}
+	return ""
+}
+
+// GetServerCapabilitiesResponse lists the capabilities of the server
+type GetServerCapabilitiesResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Capabilities  []*ServerCapability    `protobuf:"bytes,1,rep,name=capabilities,proto3" json:"capabilities,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *GenerateVideoThumbnailResponse) Reset() {
-	*x = GenerateVideoThumbnailResponse{}
-	mi := &file_proto_photos_proto_msgTypes[41]
+func (x *GetServerCapabilitiesResponse) Reset() {
+	*x = GetServerCapabilitiesResponse{}
+	mi := &file_proto_photos_proto_msgTypes[80]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *GenerateVideoThumbnailResponse) String() string {
+func (x *GetServerCapabilitiesResponse) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*GenerateVideoThumbnailResponse) ProtoMessage() {}
+func (*GetServerCapabilitiesResponse) ProtoMessage() {}
 
-func (x *GenerateVideoThumbnailResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_photos_proto_msgTypes[41]
+func (x *GetServerCapabilitiesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[80]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -2622,56 +5837,47 @@ func (x *GenerateVideoThumbnailResponse) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use GenerateVideoThumbnailResponse.ProtoReflect.Descriptor instead.
-func (*GenerateVideoThumbnailResponse) Descriptor() ([]byte, []int) {
-	return file_proto_photos_proto_rawDescGZIP(), []int{41}
-}
-
-func (x *GenerateVideoThumbnailResponse) GetThumbnailObjectId() string {
-	if x != nil {
-		return x.ThumbnailObjectId
-	}
-	return ""
-}
-
-func (x *GenerateVideoThumbnailResponse) GetSignedUrl() string {
-	if x != nil {
-		return x.SignedUrl
-	}
-	return ""
+// Deprecated: Use GetServerCapabilitiesResponse.ProtoReflect.Descriptor instead.
+func (*GetServerCapabilitiesResponse) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{80}
 }
 
-func (x *GenerateVideoThumbnailResponse) GetExpiresAt() string {
+func (x *GetServerCapabilitiesResponse) GetCapabilities() []*ServerCapability {
 	if x != nil {
-		return x.ExpiresAt
+		return x.Capabilities
 	}
-	return ""
+	return nil
 }
 
-// GenerateDNGPreviewRequest specifies the DNG photo for which to generate a JPEG preview
-type GenerateDNGPreviewRequest struct {
+// GetChangesRequest specifies the token to return the changes since
+type GetChangesRequest struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
-	// The object ID of the DNG photo
-	ObjectId      string `protobuf:"bytes,1,opt,name=object_id,json=objectId,proto3" json:"object_id,omitempty"`
+	// since_token is the next_token of the last response. If empty, no changes
+	// are returned, only the token of the latest change: get it before listing
+	// the photos to build an index, then get the changes since it.
+	SinceToken string `protobuf:"bytes,1,opt,name=since_token,json=sinceToken,proto3" json:"since_token,omitempty"`
+	// page_size is the maximum number of changes read; 0 reads 1000, and at
+	// most 10000 are read
+	PageSize      int32 `protobuf:"varint,2,opt,name=page_size,json=pageSize,proto3" json:"page_size,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *GenerateDNGPreviewRequest) Reset() {
-	*x = GenerateDNGPreviewRequest{}
-	mi := &file_proto_photos_proto_msgTypes[42]
+func (x *GetChangesRequest) Reset() {
+	*x = GetChangesRequest{}
+	mi := &file_proto_photos_proto_msgTypes[81]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *GenerateDNGPreviewRequest) String() string {
+func (x *GetChangesRequest) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*GenerateDNGPreviewRequest) ProtoMessage() {}
+func (*GetChangesRequest) ProtoMessage() {}
 
-func (x *GenerateDNGPreviewRequest) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_photos_proto_msgTypes[42]
+func (x *GetChangesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[81]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -2682,46 +5888,61 @@ func (x *GenerateDNGPreviewRequest) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use GenerateDNGPreviewRequest.ProtoReflect.Descriptor instead.
-func (*GenerateDNGPreviewRequest) Descriptor() ([]byte, []int) {
-	return file_proto_photos_proto_rawDescGZIP(), []int{42}
+// Deprecated: Use GetChangesRequest.ProtoReflect.Descriptor instead.
+func (*GetChangesRequest) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{81}
 }
 
-func (x *GenerateDNGPreviewRequest) GetObjectId() string {
+func (x *GetChangesRequest) GetSinceToken() string {
 	if x != nil {
-		return x.ObjectId
+		return x.SinceToken
 	}
 	return ""
 }
 
-// GenerateDNGPreviewResponse returns the generated preview information
-type GenerateDNGPreviewResponse struct {
+func (x *GetChangesRequest) GetPageSize() int32 {
+	if x != nil {
+		return x.PageSize
+	}
+	return 0
+}
+
+// GetChangesResponse returns the photos changed since a token. Each photo is
+// listed once, by its last change: a photo deleted is listed as deleted, one
+// created, even if updated afterwards, as created, and one only updated as
+// updated.
+type GetChangesResponse struct {
 	state protoimpl.MessageState `protogen:"open.v1"`
-	// The object ID of the generated JPEG preview
-	ThumbnailObjectId string `protobuf:"bytes,1,opt,name=thumbnail_object_id,json=thumbnailObjectId,proto3" json:"thumbnail_object_id,omitempty"`
-	// A signed URL to access the preview
-	SignedUrl string `protobuf:"bytes,2,opt,name=signed_url,json=signedUrl,proto3" json:"signed_url,omitempty"`
-	// When the signed URL expires (RFC3339 format)
-	ExpiresAt     string `protobuf:"bytes,3,opt,name=expires_at,json=expiresAt,proto3" json:"expires_at,omitempty"`
+	// created_object_ids are the photos created, such as by an upload, a copy
+	// or a rename.
+	CreatedObjectIds []string `protobuf:"bytes,1,rep,name=created_object_ids,json=createdObjectIds,proto3" json:"created_object_ids,omitempty"`
+	// updated_object_ids are the photos whose metadata was updated.
+	UpdatedObjectIds []string `protobuf:"bytes,2,rep,name=updated_object_ids,json=updatedObjectIds,proto3" json:"updated_object_ids,omitempty"`
+	// deleted_object_ids are the photos deleted, such as by a rename.
+	DeletedObjectIds []string `protobuf:"bytes,3,rep,name=deleted_object_ids,json=deletedObjectIds,proto3" json:"deleted_object_ids,omitempty"`
+	// next_token is the token to get the changes after these.
+	NextToken string `protobuf:"bytes,4,opt,name=next_token,json=nextToken,proto3" json:"next_token,omitempty"`
+	// has_more is set if there are more changes after next_token.
+	HasMore       bool `protobuf:"varint,5,opt,name=has_more,json=hasMore,proto3" json:"has_more,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
 }
 
-func (x *GenerateDNGPreviewResponse) Reset() {
-	*x = GenerateDNGPreviewResponse{}
-	mi := &file_proto_photos_proto_msgTypes[43]
+func (x *GetChangesResponse) Reset() {
+	*x = GetChangesResponse{}
+	mi := &file_proto_photos_proto_msgTypes[82]
 	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 	ms.StoreMessageInfo(mi)
 }
 
-func (x *GenerateDNGPreviewResponse) String() string {
+func (x *GetChangesResponse) String() string {
 	return protoimpl.X.MessageStringOf(x)
 }
 
-func (*GenerateDNGPreviewResponse) ProtoMessage() {}
+func (*GetChangesResponse) ProtoMessage() {}
 
-func (x *GenerateDNGPreviewResponse) ProtoReflect() protoreflect.Message {
-	mi := &file_proto_photos_proto_msgTypes[43]
+func (x *GetChangesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_photos_proto_msgTypes[82]
 	if x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -2732,37 +5953,52 @@ func (x *GenerateDNGPreviewResponse) ProtoReflect() protoreflect.Message {
 	return mi.MessageOf(x)
 }
 
-// Deprecated: Use GenerateDNGPreviewResponse.ProtoReflect.Descriptor instead.
-func (*GenerateDNGPreviewResponse) Descriptor() ([]byte, []int) {
-	return file_proto_photos_proto_rawDescGZIP(), []int{43}
+// Deprecated: Use GetChangesResponse.ProtoReflect.Descriptor instead.
+func (*GetChangesResponse) Descriptor() ([]byte, []int) {
+	return file_proto_photos_proto_rawDescGZIP(), []int{82}
 }
 
-func (x *GenerateDNGPreviewResponse) GetThumbnailObjectId() string {
+func (x *GetChangesResponse) GetCreatedObjectIds() []string {
 	if x != nil {
-		return x.ThumbnailObjectId
+		return x.CreatedObjectIds
 	}
-	return ""
+	return nil
 }
 
-func (x *GenerateDNGPreviewResponse) GetSignedUrl() string {
+func (x *GetChangesResponse) GetUpdatedObjectIds() []string {
 	if x != nil {
-		return x.SignedUrl
+		return x.UpdatedObjectIds
 	}
-	return ""
+	return nil
 }
 
-func (x *GenerateDNGPreviewResponse) GetExpiresAt() string {
+func (x *GetChangesResponse) GetDeletedObjectIds() []string {
 	if x != nil {
-		return x.ExpiresAt
+		return x.DeletedObjectIds
+	}
+	return nil
+}
+
+func (x *GetChangesResponse) GetNextToken() string {
+	if x != nil {
+		return x.NextToken
 	}
 	return ""
 }
 
+func (x *GetChangesResponse) GetHasMore() bool {
+	if x != nil {
+		return x.HasMore
+	}
+	return false
+}
+
 var File_proto_photos_proto protoreflect.FileDescriptor
 
 const file_proto_photos_proto_rawDesc = "" +
 	"\n" +
-	"\x12proto/photos.proto\x12\x06photos\x1a\x1cgoogle/api/annotations.proto\x1a\x1bgoogle/protobuf/empty.proto\"\xf4\x06\n" +
+	"\x12proto/photos.proto\x12\x06photos\x1a\x1cgoogle/api/annotations.proto\x1a\x1bgoogle/protobuf/empty.proto\"\xec\n" +
+	"\n" +
 	"\x05Photo\x12\x1b\n" +
 	"\tobject_id\x18\x01 \x01(\tR\bobjectId\x12\x1a\n" +
 	"\bfilename\x18\x02 \x01(\tR\bfilename\x12!\n" +
@@ -2797,13 +6033,49 @@ const file_proto_photos_proto_rawDesc = "" +
 	"\x10duration_seconds\x18\x18 \x01(\x01R\x0fdurationSeconds\x12\x19\n" +
 	"\bis_video\x18\x19 \x01(\bR\aisVideo\x12.\n" +
 	"\x13thumbnail_object_id\x18\x1a \x01(\tR\x11thumbnailObjectId\x12$\n" +
-	"\x0ewebp_object_id\x18\x1b \x01(\tR\fwebpObjectId\"c\n" +
+	"\x0ewebp_object_id\x18\x1b \x01(\tR\fwebpObjectId\x12*\n" +
+	"\x11sidecar_object_id\x18\x1c \x01(\tR\x0fsidecarObjectId\x12\x16\n" +
+	"\x06rating\x18\x1d \x01(\x05R\x06rating\x12\x14\n" +
+	"\x05label\x18\x1e \x01(\tR\x05label\x12\x1a\n" +
+	"\bkeywords\x18\x1f \x03(\tR\bkeywords\x12\x18\n" +
+	"\acaption\x18  \x01(\tR\acaption\x12%\n" +
+	"\x04crop\x18! \x01(\v2\x11.photos.PhotoCropR\x04crop\x126\n" +
+	"\n" +
+	"renditions\x18\" \x03(\v2\x16.photos.PhotoRenditionR\n" +
+	"renditions\x12$\n" +
+	"\x0eavif_object_id\x18# \x01(\tR\favifObjectId\x12&\n" +
+	"\x0fproxy_object_id\x18$ \x01(\tR\rproxyObjectId\x12\"\n" +
+	"\rhls_object_id\x18% \x01(\tR\vhlsObjectId\x12;\n" +
+	"\x1aanimated_preview_object_id\x18& \x01(\tR\x17animatedPreviewObjectId\x12.\n" +
+	"\x13companion_object_id\x18' \x01(\tR\x11companionObjectId\x12(\n" +
+	"\x05stack\x18( \x01(\v2\x12.photos.PhotoStackR\x05stack\"^\n" +
+	"\n" +
+	"PhotoStack\x12\x12\n" +
+	"\x04kind\x18\x01 \x01(\tR\x04kind\x12&\n" +
+	"\x0fcover_object_id\x18\x02 \x01(\tR\rcoverObjectId\x12\x14\n" +
+	"\x05count\x18\x03 \x01(\x05R\x05count\"\xba\x01\n" +
+	"\x0ePhotoRendition\x12\x1b\n" +
+	"\tobject_id\x18\x01 \x01(\tR\bobjectId\x12\x1b\n" +
+	"\tlong_edge\x18\x02 \x01(\x05R\blongEdge\x12\x14\n" +
+	"\x05width\x18\x03 \x01(\x05R\x05width\x12\x16\n" +
+	"\x06height\x18\x04 \x01(\x05R\x06height\x12!\n" +
+	"\fcontent_type\x18\x05 \x01(\tR\vcontentType\x12\x1d\n" +
+	"\n" +
+	"size_bytes\x18\x06 \x01(\x03R\tsizeBytes\"u\n" +
+	"\tPhotoCrop\x12\x10\n" +
+	"\x03top\x18\x01 \x01(\x01R\x03top\x12\x12\n" +
+	"\x04left\x18\x02 \x01(\x01R\x04left\x12\x16\n" +
+	"\x06bottom\x18\x03 \x01(\x01R\x06bottom\x12\x14\n" +
+	"\x05right\x18\x04 \x01(\x01R\x05right\x12\x14\n" +
+	"\x05angle\x18\x05 \x01(\x01R\x05angle\"\xa4\x01\n" +
 	"\rUploadRequest\x12\x1b\n" +
 	"\tobject_id\x18\x01 \x01(\tR\bobjectId\x12!\n" +
 	"\fcontent_type\x18\x02 \x01(\tR\vcontentType\x12\x12\n" +
-	"\x04data\x18\x03 \x01(\fR\x04data\"5\n" +
+	"\x04data\x18\x03 \x01(\fR\x04data\x12?\n" +
+	"\x0fconflict_policy\x18\x04 \x01(\x0e2\x16.photos.ConflictPolicyR\x0econflictPolicy\"O\n" +
 	"\x0eUploadResponse\x12#\n" +
-	"\x05photo\x18\x01 \x01(\v2\r.photos.PhotoR\x05photo\"U\n" +
+	"\x05photo\x18\x01 \x01(\v2\r.photos.PhotoR\x05photo\x12\x18\n" +
+	"\askipped\x18\x02 \x01(\bR\askipped\"U\n" +
 	"\x0fDownloadRequest\x12\x1b\n" +
 	"\tobject_id\x18\x01 \x01(\tR\bobjectId\x12%\n" +
 	"\x0estrip_location\x18\x02 \x01(\bR\rstripLocation\"K\n" +
@@ -2846,7 +6118,21 @@ const file_proto_photos_proto_rawDesc = "" +
 	"\x03key\x18\x01 \x01(\tR\x03key\x12\x14\n" +
 	"\x05value\x18\x02 \x01(\tR\x05value:\x028\x01\"B\n" +
 	"\x1bUpdatePhotoMetadataResponse\x12#\n" +
-	"\x05photo\x18\x01 \x01(\v2\r.photos.PhotoR\x05photo\"~\n" +
+	"\x05photo\x18\x01 \x01(\v2\r.photos.PhotoR\x05photo\".\n" +
+	"\x0fGetStackRequest\x12\x1b\n" +
+	"\tobject_id\x18\x01 \x01(\tR\bobjectId\"c\n" +
+	"\x10GetStackResponse\x12(\n" +
+	"\x05stack\x18\x01 \x01(\v2\x12.photos.PhotoStackR\x05stack\x12%\n" +
+	"\x06photos\x18\x02 \x03(\v2\r.photos.PhotoR\x06photos\"3\n" +
+	"\x14SetStackCoverRequest\x12\x1b\n" +
+	"\tobject_id\x18\x01 \x01(\tR\bobjectId\"A\n" +
+	"\x15SetStackCoverResponse\x12(\n" +
+	"\x05stack\x18\x01 \x01(\v2\x12.photos.PhotoStackR\x05stack\"-\n" +
+	"\x0eUnstackRequest\x12\x1b\n" +
+	"\tobject_id\x18\x01 \x01(\tR\bobjectId\"0\n" +
+	"\x0fUnstackResponse\x12\x1d\n" +
+	"\n" +
+	"object_ids\x18\x01 \x03(\tR\tobjectIds\"~\n" +
 	"\x18GenerateSignedUrlRequest\x12\x1b\n" +
 	"\tobject_id\x18\x01 \x01(\tR\bobjectId\x12-\n" +
 	"\x12expiration_seconds\x18\x02 \x01(\x03R\x11expirationSeconds\x12\x16\n" +
@@ -2864,10 +6150,25 @@ const file_proto_photos_proto_rawDesc = "" +
 	"\x06prefix\x18\x01 \x01(\tR\x06prefix\x12\x1c\n" +
 	"\trecursive\x18\x02 \x01(\bR\trecursive\"5\n" +
 	"\x17ListDirectoriesResponse\x12\x1a\n" +
-	"\bprefixes\x18\x01 \x03(\tR\bprefixes\"\x81\x01\n" +
+	"\bprefixes\x18\x01 \x03(\tR\bprefixes\"\xae\x01\n" +
 	"\x13SyncDatabaseRequest\x12'\n" +
 	"\x0fupdate_metadata\x18\x01 \x01(\bR\x0eupdateMetadata\x12A\n" +
-	"\x1dpause_between_objects_seconds\x18\x02 \x01(\rR\x1apauseBetweenObjectsSeconds\"\xd0\x02\n" +
+	"\x1dpause_between_objects_seconds\x18\x02 \x01(\rR\x1apauseBetweenObjectsSeconds\x12\x12\n" +
+	"\x04full\x18\x03 \x01(\bR\x04full\x12\x17\n" +
+	"\adry_run\x18\x04 \x01(\bR\x06dryRun\"\x84\x02\n" +
+	"\n" +
+	"SyncChange\x121\n" +
+	"\x06action\x18\x01 \x01(\x0e2\x19.photos.SyncChange.ActionR\x06action\x12\x1b\n" +
+	"\tobject_id\x18\x02 \x01(\tR\bobjectId\x12\x16\n" +
+	"\x06reason\x18\x03 \x01(\tR\x06reason\"\x8d\x01\n" +
+	"\x06Action\x12\x16\n" +
+	"\x12ACTION_UNSPECIFIED\x10\x00\x12\x0e\n" +
+	"\n" +
+	"ACTION_ADD\x10\x01\x12\x12\n" +
+	"\x0eACTION_RESTORE\x10\x02\x12\x11\n" +
+	"\rACTION_REMOVE\x10\x03\x12\x1a\n" +
+	"\x16ACTION_UPDATE_METADATA\x10\x04\x12\x18\n" +
+	"\x14ACTION_GENERATE_WEBP\x10\x05\"\xad\x04\n" +
 	"\x14SyncDatabaseProgress\x128\n" +
 	"\x05phase\x18\x01 \x01(\x0e2\".photos.SyncDatabaseProgress.PhaseR\x05phase\x12\x1c\n" +
 	"\tprocessed\x18\x02 \x01(\rR\tprocessed\x12\x14\n" +
@@ -2875,12 +6176,47 @@ const file_proto_photos_proto_rawDesc = "" +
 	"\x05added\x18\x04 \x01(\rR\x05added\x12\x18\n" +
 	"\aremoved\x18\x05 \x01(\rR\aremoved\x12)\n" +
 	"\x10metadata_updated\x18\x06 \x01(\rR\x0fmetadataUpdated\x12\x1a\n" +
-	"\bcomplete\x18\a \x01(\bR\bcomplete\"S\n" +
+	"\bcomplete\x18\a \x01(\bR\bcomplete\x121\n" +
+	"\x14renditions_generated\x18\b \x01(\rR\x13renditionsGenerated\x12+\n" +
+	"\x11posters_generated\x18\t \x01(\rR\x10postersGenerated\x12\x12\n" +
+	"\x04full\x18\n" +
+	" \x01(\bR\x04full\x12*\n" +
+	"\x06change\x18\v \x01(\v2\x12.photos.SyncChangeR\x06change\"\x8f\x01\n" +
 	"\x05Phase\x12\x15\n" +
 	"\x11PHASE_UNSPECIFIED\x10\x00\x12\r\n" +
 	"\tPHASE_ADD\x10\x01\x12\x10\n" +
 	"\fPHASE_REMOVE\x10\x02\x12\x12\n" +
-	"\x0ePHASE_METADATA\x10\x03\"V\n" +
+	"\x0ePHASE_METADATA\x10\x03\x12\x11\n" +
+	"\rPHASE_SIDECAR\x10\x04\x12\x14\n" +
+	"\x10PHASE_RENDITIONS\x10\x05\x12\x11\n" +
+	"\rPHASE_POSTERS\x10\x06\".\n" +
+	"\x14VerifyLibraryRequest\x12\x16\n" +
+	"\x06repair\x18\x01 \x01(\bR\x06repair\"\xd1\x02\n" +
+	"\x10IntegrityProblem\x121\n" +
+	"\x04kind\x18\x01 \x01(\x0e2\x1d.photos.IntegrityProblem.KindR\x04kind\x12\x1b\n" +
+	"\tobject_id\x18\x02 \x01(\tR\bobjectId\x12\x16\n" +
+	"\x06detail\x18\x03 \x01(\tR\x06detail\x12\x1a\n" +
+	"\brepaired\x18\x04 \x01(\bR\brepaired\"\xb8\x01\n" +
+	"\x04Kind\x12\x14\n" +
+	"\x10KIND_UNSPECIFIED\x10\x00\x12\x17\n" +
+	"\x13KIND_MISSING_OBJECT\x10\x01\x12\x15\n" +
+	"\x11KIND_MD5_MISMATCH\x10\x02\x12\x19\n" +
+	"\x15KIND_DANGLING_DERIVED\x10\x03\x12\x19\n" +
+	"\x15KIND_ORPHANED_DERIVED\x10\x04\x12\x18\n" +
+	"\x14KIND_EMPTY_DIRECTORY\x10\x05\x12\x1a\n" +
+	"\x16KIND_MISSING_DIRECTORY\x10\x06\"\xae\x03\n" +
+	"\x15VerifyLibraryProgress\x122\n" +
+	"\aproblem\x18\x01 \x01(\v2\x18.photos.IntegrityProblemR\aproblem\x12%\n" +
+	"\x0ephotos_checked\x18\x02 \x01(\rR\rphotosChecked\x12'\n" +
+	"\x0fmissing_objects\x18\x03 \x01(\rR\x0emissingObjects\x12%\n" +
+	"\x0emd5_mismatches\x18\x04 \x01(\rR\rmd5Mismatches\x12)\n" +
+	"\x10dangling_derived\x18\x05 \x01(\rR\x0fdanglingDerived\x12)\n" +
+	"\x10orphaned_derived\x18\x06 \x01(\rR\x0forphanedDerived\x12+\n" +
+	"\x11empty_directories\x18\a \x01(\rR\x10emptyDirectories\x12/\n" +
+	"\x13missing_directories\x18\b \x01(\rR\x12missingDirectories\x12\x1a\n" +
+	"\brepaired\x18\t \x01(\rR\brepaired\x12\x1a\n" +
+	"\bcomplete\x18\n" +
+	" \x01(\bR\bcomplete\"V\n" +
 	"\x11UpdateWebpRequest\x12A\n" +
 	"\x1dpause_between_objects_seconds\x18\x01 \x01(\rR\x1apauseBetweenObjectsSeconds\"\xb4\x01\n" +
 	"\x12UpdateWebpProgress\x12\x1c\n" +
@@ -2889,27 +6225,76 @@ const file_proto_photos_proto_rawDesc = "" +
 	"\tgenerated\x18\x03 \x01(\rR\tgenerated\x12\x18\n" +
 	"\askipped\x18\x04 \x01(\rR\askipped\x12\x16\n" +
 	"\x06failed\x18\x05 \x01(\rR\x06failed\x12\x1a\n" +
-	"\bcomplete\x18\x06 \x01(\bR\bcomplete\"\x8f\x01\n" +
+	"\bcomplete\x18\x06 \x01(\bR\bcomplete\"V\n" +
+	"\x11UpdateAvifRequest\x12A\n" +
+	"\x1dpause_between_objects_seconds\x18\x01 \x01(\rR\x1apauseBetweenObjectsSeconds\"\xb4\x01\n" +
+	"\x12UpdateAvifProgress\x12\x1c\n" +
+	"\tprocessed\x18\x01 \x01(\rR\tprocessed\x12\x14\n" +
+	"\x05total\x18\x02 \x01(\rR\x05total\x12\x1c\n" +
+	"\tgenerated\x18\x03 \x01(\rR\tgenerated\x12\x18\n" +
+	"\askipped\x18\x04 \x01(\rR\askipped\x12\x16\n" +
+	"\x06failed\x18\x05 \x01(\rR\x06failed\x12\x1a\n" +
+	"\bcomplete\x18\x06 \x01(\bR\bcomplete\"\x8d\x01\n" +
+	"\x15TranscodeVideoRequest\x12\x1b\n" +
+	"\tobject_id\x18\x01 \x01(\tR\bobjectId\x12\x14\n" +
+	"\x05force\x18\x02 \x01(\bR\x05force\x12A\n" +
+	"\x1dpause_between_objects_seconds\x18\x03 \x01(\rR\x1apauseBetweenObjectsSeconds\"\xf1\x01\n" +
+	"\x16TranscodeVideoProgress\x12\x1b\n" +
+	"\tobject_id\x18\x01 \x01(\tR\bobjectId\x12\x18\n" +
+	"\apercent\x18\x02 \x01(\x01R\apercent\x12\x1c\n" +
+	"\tprocessed\x18\x03 \x01(\rR\tprocessed\x12\x14\n" +
+	"\x05total\x18\x04 \x01(\rR\x05total\x12\x1e\n" +
+	"\n" +
+	"transcoded\x18\x05 \x01(\rR\n" +
+	"transcoded\x12\x18\n" +
+	"\askipped\x18\x06 \x01(\rR\askipped\x12\x16\n" +
+	"\x06failed\x18\a \x01(\rR\x06failed\x12\x1a\n" +
+	"\bcomplete\x18\b \x01(\bR\bcomplete\"\x8f\x01\n" +
 	"\x16StreamingUploadRequest\x123\n" +
 	"\bmetadata\x18\x01 \x01(\v2\x15.photos.PhotoMetadataH\x00R\bmetadata\x12\x16\n" +
 	"\x05chunk\x18\x02 \x01(\fH\x00R\x05chunk\x12 \n" +
 	"\vend_of_file\x18\x03 \x01(\bH\x00R\tendOfFileB\x06\n" +
-	"\x04data\"\x97\x01\n" +
+	"\x04data\"\xb1\x01\n" +
 	"\x14BulkUploadFileResult\x12\x1b\n" +
 	"\tobject_id\x18\x01 \x01(\tR\bobjectId\x12\x18\n" +
 	"\asuccess\x18\x02 \x01(\bR\asuccess\x12#\n" +
 	"\x05photo\x18\x03 \x01(\v2\r.photos.PhotoR\x05photo\x12#\n" +
-	"\rerror_message\x18\x04 \x01(\tR\ferrorMessage\"N\n" +
+	"\rerror_message\x18\x04 \x01(\tR\ferrorMessage\x12\x18\n" +
+	"\askipped\x18\x05 \x01(\bR\askipped\"\x8f\x01\n" +
 	"\rPhotoMetadata\x12\x1a\n" +
 	"\bfilename\x18\x01 \x01(\tR\bfilename\x12!\n" +
-	"\fcontent_type\x18\x02 \x01(\tR\vcontentType\"^\n" +
+	"\fcontent_type\x18\x02 \x01(\tR\vcontentType\x12?\n" +
+	"\x0fconflict_policy\x18\x03 \x01(\x0e2\x16.photos.ConflictPolicyR\x0econflictPolicy\"\xb3\x01\n" +
 	"\x18StreamingDownloadRequest\x12\x1b\n" +
 	"\tobject_id\x18\x01 \x01(\tR\bobjectId\x12%\n" +
-	"\x0estrip_location\x18\x02 \x01(\bR\rstripLocation\"h\n" +
+	"\x0estrip_location\x18\x02 \x01(\bR\rstripLocation\x12\x16\n" +
+	"\x06offset\x18\x03 \x01(\x03R\x06offset\x12\x16\n" +
+	"\x06length\x18\x04 \x01(\x03R\x06length\x12#\n" +
+	"\rmetadata_only\x18\x05 \x01(\bR\fmetadataOnly\"h\n" +
 	"\x19StreamingDownloadResponse\x12+\n" +
 	"\bmetadata\x18\x01 \x01(\v2\r.photos.PhotoH\x00R\bmetadata\x12\x16\n" +
 	"\x05chunk\x18\x02 \x01(\fH\x00R\x05chunkB\x06\n" +
-	"\x04data\"K\n" +
+	"\x04data\"\x80\x01\n" +
+	"\x16DownloadArchiveRequest\x12\x16\n" +
+	"\x06prefix\x18\x01 \x01(\tR\x06prefix\x12'\n" +
+	"\x0finclude_derived\x18\x02 \x01(\bR\x0eincludeDerived\x12%\n" +
+	"\x0estrip_location\x18\x03 \x01(\bR\rstripLocation\"/\n" +
+	"\x17DownloadArchiveResponse\x12\x14\n" +
+	"\x05chunk\x18\x01 \x01(\fR\x05chunk\"\xd6\x01\n" +
+	"\x12RenderPhotoRequest\x12\x1b\n" +
+	"\tobject_id\x18\x01 \x01(\tR\bobjectId\x12\x14\n" +
+	"\x05width\x18\x02 \x01(\x05R\x05width\x12\x16\n" +
+	"\x06height\x18\x03 \x01(\x05R\x06height\x12#\n" +
+	"\x03fit\x18\x04 \x01(\x0e2\x11.photos.RenderFitR\x03fit\x12,\n" +
+	"\x06format\x18\x05 \x01(\x0e2\x14.photos.RenderFormatR\x06format\x12\"\n" +
+	"\rif_none_match\x18\x06 \x01(\tR\vifNoneMatch\"\xb1\x01\n" +
+	"\x13RenderPhotoResponse\x12\x12\n" +
+	"\x04data\x18\x01 \x01(\fR\x04data\x12!\n" +
+	"\fcontent_type\x18\x02 \x01(\tR\vcontentType\x12\x14\n" +
+	"\x05width\x18\x03 \x01(\x05R\x05width\x12\x16\n" +
+	"\x06height\x18\x04 \x01(\x05R\x06height\x12\x12\n" +
+	"\x04etag\x18\x05 \x01(\tR\x04etag\x12!\n" +
+	"\fnot_modified\x18\x06 \x01(\bR\vnotModified\"K\n" +
 	"\x15CreateMarkdownRequest\x12\x16\n" +
 	"\x06prefix\x18\x01 \x01(\tR\x06prefix\x12\x1a\n" +
 	"\bmarkdown\x18\x02 \x01(\tR\bmarkdown\"5\n" +
@@ -2945,13 +6330,125 @@ const file_proto_photos_proto_rawDesc = "" +
 	"\n" +
 	"signed_url\x18\x02 \x01(\tR\tsignedUrl\x12\x1d\n" +
 	"\n" +
-	"expires_at\x18\x03 \x01(\tR\texpiresAt2\xd1\x03\n" +
+	"expires_at\x18\x03 \x01(\tR\texpiresAt\"\x11\n" +
+	"\x0fGetUsageRequest\"\xbe\x03\n" +
+	"\x10GetUsageResponse\x12!\n" +
+	"\fobject_count\x18\x01 \x01(\x03R\vobjectCount\x12%\n" +
+	"\x0eoriginal_bytes\x18\x02 \x01(\x03R\roriginalBytes\x12\x1d\n" +
+	"\n" +
+	"webp_bytes\x18\x03 \x01(\x03R\twebpBytes\x12\x1d\n" +
+	"\n" +
+	"avif_bytes\x18\v \x01(\x03R\tavifBytes\x12\x1f\n" +
+	"\vvideo_bytes\x18\f \x01(\x03R\n" +
+	"videoBytes\x12#\n" +
+	"\rpreview_bytes\x18\x04 \x01(\x03R\fpreviewBytes\x12'\n" +
+	"\x0fthumbnail_bytes\x18\x05 \x01(\x03R\x0ethumbnailBytes\x12#\n" +
+	"\rsidecar_bytes\x18\x06 \x01(\x03R\fsidecarBytes\x12'\n" +
+	"\x0frendition_bytes\x18\n" +
+	" \x01(\x03R\x0erenditionBytes\x12\x1f\n" +
+	"\vtotal_bytes\x18\a \x01(\x03R\n" +
+	"totalBytes\x12\x1f\n" +
+	"\vquota_bytes\x18\b \x01(\x03R\n" +
+	"quotaBytes\x12#\n" +
+	"\rquota_objects\x18\t \x01(\x03R\fquotaObjects\"\xb8\x02\n" +
+	"\x03Job\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x04R\x02id\x12\x12\n" +
+	"\x04kind\x18\x02 \x01(\tR\x04kind\x12\x1b\n" +
+	"\tobject_id\x18\x03 \x01(\tR\bobjectId\x12\x16\n" +
+	"\x06status\x18\x04 \x01(\tR\x06status\x12\x1a\n" +
+	"\battempts\x18\x05 \x01(\x05R\battempts\x12!\n" +
+	"\fmax_attempts\x18\x06 \x01(\x05R\vmaxAttempts\x12\x1d\n" +
+	"\n" +
+	"last_error\x18\a \x01(\tR\tlastError\x12\x1d\n" +
+	"\n" +
+	"created_at\x18\b \x01(\tR\tcreatedAt\x12\x1b\n" +
+	"\trun_after\x18\t \x01(\tR\brunAfter\x12\x1d\n" +
+	"\n" +
+	"started_at\x18\n" +
+	" \x01(\tR\tstartedAt\x12\x1f\n" +
+	"\vfinished_at\x18\v \x01(\tR\n" +
+	"finishedAt\"e\n" +
+	"\x0fListJobsRequest\x12\x16\n" +
+	"\x06status\x18\x01 \x01(\tR\x06status\x12\x1b\n" +
+	"\tpage_size\x18\x02 \x01(\x05R\bpageSize\x12\x1d\n" +
+	"\n" +
+	"page_token\x18\x03 \x01(\tR\tpageToken\"[\n" +
+	"\x10ListJobsResponse\x12\x1f\n" +
+	"\x04jobs\x18\x01 \x03(\v2\v.photos.JobR\x04jobs\x12&\n" +
+	"\x0fnext_page_token\x18\x02 \x01(\tR\rnextPageToken\"\x1f\n" +
+	"\rGetJobRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x04R\x02id\"/\n" +
+	"\x0eGetJobResponse\x12\x1d\n" +
+	"\x03job\x18\x01 \x01(\v2\v.photos.JobR\x03job\"!\n" +
+	"\x0fRetryJobRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x04R\x02id\"1\n" +
+	"\x10RetryJobResponse\x12\x1d\n" +
+	"\x03job\x18\x01 \x01(\v2\v.photos.JobR\x03job\"\x96\x01\n" +
+	"\fScheduledRun\x12\x16\n" +
+	"\x06status\x18\x01 \x01(\tR\x06status\x12\x1d\n" +
+	"\n" +
+	"started_at\x18\x02 \x01(\tR\tstartedAt\x12\x1f\n" +
+	"\vfinished_at\x18\x03 \x01(\tR\n" +
+	"finishedAt\x12\x18\n" +
+	"\asummary\x18\x04 \x01(\tR\asummary\x12\x14\n" +
+	"\x05error\x18\x05 \x01(\tR\x05error\"\x8f\x01\n" +
+	"\bSchedule\x12\x12\n" +
+	"\x04task\x18\x01 \x01(\tR\x04task\x12\x1e\n" +
+	"\n" +
+	"expression\x18\x02 \x01(\tR\n" +
+	"expression\x12\x1e\n" +
+	"\vnext_run_at\x18\x03 \x01(\tR\tnextRunAt\x12/\n" +
+	"\blast_run\x18\x04 \x01(\v2\x14.photos.ScheduledRunR\alastRun\"\x16\n" +
+	"\x14ListSchedulesRequest\"G\n" +
+	"\x15ListSchedulesResponse\x12.\n" +
+	"\tschedules\x18\x01 \x03(\v2\x10.photos.ScheduleR\tschedules\"\x1e\n" +
+	"\x1cGetServerCapabilitiesRequest\"\xa6\x02\n" +
+	"\x10ServerCapability\x12\x18\n" +
+	"\afeature\x18\x01 \x01(\tR\afeature\x12\x12\n" +
+	"\x04tool\x18\x02 \x01(\tR\x04tool\x12\x1b\n" +
+	"\ttool_path\x18\x03 \x01(\tR\btoolPath\x12=\n" +
+	"\bprovider\x18\x04 \x01(\x0e2!.photos.ServerCapability.ProviderR\bprovider\x12\x1a\n" +
+	"\bfallback\x18\x05 \x01(\tR\bfallback\"l\n" +
+	"\bProvider\x12\x18\n" +
+	"\x14PROVIDER_UNSPECIFIED\x10\x00\x12\x15\n" +
+	"\x11PROVIDER_EXTERNAL\x10\x01\x12\x15\n" +
+	"\x11PROVIDER_FALLBACK\x10\x02\x12\x18\n" +
+	"\x14PROVIDER_UNAVAILABLE\x10\x03\"]\n" +
+	"\x1dGetServerCapabilitiesResponse\x12<\n" +
+	"\fcapabilities\x18\x01 \x03(\v2\x18.photos.ServerCapabilityR\fcapabilities\"Q\n" +
+	"\x11GetChangesRequest\x12\x1f\n" +
+	"\vsince_token\x18\x01 \x01(\tR\n" +
+	"sinceToken\x12\x1b\n" +
+	"\tpage_size\x18\x02 \x01(\x05R\bpageSize\"\xd8\x01\n" +
+	"\x12GetChangesResponse\x12,\n" +
+	"\x12created_object_ids\x18\x01 \x03(\tR\x10createdObjectIds\x12,\n" +
+	"\x12updated_object_ids\x18\x02 \x03(\tR\x10updatedObjectIds\x12,\n" +
+	"\x12deleted_object_ids\x18\x03 \x03(\tR\x10deletedObjectIds\x12\x1d\n" +
+	"\n" +
+	"next_token\x18\x04 \x01(\tR\tnextToken\x12\x19\n" +
+	"\bhas_more\x18\x05 \x01(\bR\ahasMore*\xab\x01\n" +
+	"\x0eConflictPolicy\x12\x1f\n" +
+	"\x1bCONFLICT_POLICY_UNSPECIFIED\x10\x00\x12\x18\n" +
+	"\x14CONFLICT_POLICY_FAIL\x10\x01\x12\x1a\n" +
+	"\x16CONFLICT_POLICY_RENAME\x10\x02\x12\x1b\n" +
+	"\x17CONFLICT_POLICY_REPLACE\x10\x03\x12%\n" +
+	"!CONFLICT_POLICY_SKIP_IF_IDENTICAL\x10\x04*U\n" +
+	"\tRenderFit\x12\x1a\n" +
+	"\x16RENDER_FIT_UNSPECIFIED\x10\x00\x12\x16\n" +
+	"\x12RENDER_FIT_CONTAIN\x10\x01\x12\x14\n" +
+	"\x10RENDER_FIT_COVER\x10\x02*]\n" +
+	"\fRenderFormat\x12\x1d\n" +
+	"\x19RENDER_FORMAT_UNSPECIFIED\x10\x00\x12\x16\n" +
+	"\x12RENDER_FORMAT_JPEG\x10\x01\x12\x16\n" +
+	"\x12RENDER_FORMAT_WEBP\x10\x022\xef\x04\n" +
 	"\vByteService\x12U\n" +
 	"\x06Upload\x12\x15.photos.UploadRequest\x1a\x16.photos.UploadResponse\"\x1c\x82\xd3\xe4\x93\x02\x16:\x01*\"\x11/v1/photos/upload\x12i\n" +
 	"\bDownload\x12\x17.photos.DownloadRequest\x1a\x18.photos.DownloadResponse\"*\x82\xd3\xe4\x93\x02$\x12\"/v1/photos/{object_id=**}/download\x12K\n" +
 	"\x0fStreamingUpload\x12\x1e.photos.StreamingUploadRequest\x1a\x16.photos.UploadResponse(\x01\x12W\n" +
 	"\x13BulkStreamingUpload\x12\x1e.photos.StreamingUploadRequest\x1a\x1c.photos.BulkUploadFileResult(\x010\x01\x12Z\n" +
-	"\x11StreamingDownload\x12 .photos.StreamingDownloadRequest\x1a!.photos.StreamingDownloadResponse0\x012\x99\x10\n" +
+	"\x11StreamingDownload\x12 .photos.StreamingDownloadRequest\x1a!.photos.StreamingDownloadResponse0\x01\x12T\n" +
+	"\x0fDownloadArchive\x12\x1e.photos.DownloadArchiveRequest\x1a\x1f.photos.DownloadArchiveResponse0\x01\x12F\n" +
+	"\vRenderPhoto\x12\x1a.photos.RenderPhotoRequest\x1a\x1b.photos.RenderPhotoResponse2\xc3\x1a\n" +
 	"\x0eLibraryService\x12i\n" +
 	"\vDeletePhoto\x12\x1a.photos.DeletePhotoRequest\x1a\x1b.photos.DeletePhotoResponse\"!\x82\xd3\xe4\x93\x02\x1b*\x19/v1/photos/{object_id=**}\x12`\n" +
 	"\bGetPhoto\x12\x17.photos.GetPhotoRequest\x1a\x18.photos.GetPhotoResponse\"!\x82\xd3\xe4\x93\x02\x1b\x12\x19/v1/photos/{object_id=**}\x12W\n" +
@@ -2960,19 +6457,35 @@ const file_proto_photos_proto_rawDesc = "" +
 	"/v1/photos\x12r\n" +
 	"\tCopyPhoto\x12\x18.photos.CopyPhotoRequest\x1a\x19.photos.CopyPhotoResponse\"0\x82\xd3\xe4\x93\x02*:\x01*\"%/v1/photos/{source_object_id=**}/copy\x12z\n" +
 	"\vRenamePhoto\x12\x1a.photos.RenamePhotoRequest\x1a\x1b.photos.RenamePhotoResponse\"2\x82\xd3\xe4\x93\x02,:\x01*\"'/v1/photos/{source_object_id=**}/rename\x12\x8d\x01\n" +
-	"\x13UpdatePhotoMetadata\x12\".photos.UpdatePhotoMetadataRequest\x1a#.photos.UpdatePhotoMetadataResponse\"-\x82\xd3\xe4\x93\x02':\x01*2\"/v1/photos/{object_id=**}/metadata\x12\x89\x01\n" +
+	"\x13UpdatePhotoMetadata\x12\".photos.UpdatePhotoMetadataRequest\x1a#.photos.UpdatePhotoMetadataResponse\"-\x82\xd3\xe4\x93\x02':\x01*2\"/v1/photos/{object_id=**}/metadata\x12f\n" +
+	"\bGetStack\x12\x17.photos.GetStackRequest\x1a\x18.photos.GetStackResponse\"'\x82\xd3\xe4\x93\x02!\x12\x1f/v1/photos/{object_id=**}/stack\x12~\n" +
+	"\rSetStackCover\x12\x1c.photos.SetStackCoverRequest\x1a\x1d.photos.SetStackCoverResponse\"0\x82\xd3\xe4\x93\x02*:\x01*\"%/v1/photos/{object_id=**}/stack/cover\x12c\n" +
+	"\aUnstack\x12\x16.photos.UnstackRequest\x1a\x17.photos.UnstackResponse\"'\x82\xd3\xe4\x93\x02!*\x1f/v1/photos/{object_id=**}/stack\x12\x89\x01\n" +
 	"\x11GenerateSignedUrl\x12 .photos.GenerateSignedUrlRequest\x1a!.photos.GenerateSignedUrlResponse\"/\x82\xd3\xe4\x93\x02):\x01*\"$/v1/photos/{object_id=**}/signed-url\x12p\n" +
 	"\vPhotoExists\x12\x1a.photos.PhotoExistsRequest\x1a\x1b.photos.PhotoExistsResponse\"(\x82\xd3\xe4\x93\x02\"\x12 /v1/photos/{object_id=**}/exists\x12k\n" +
 	"\x0fListDirectories\x12\x1e.photos.ListDirectoriesRequest\x1a\x1f.photos.ListDirectoriesResponse\"\x17\x82\xd3\xe4\x93\x02\x11\x12\x0f/v1/directories\x12g\n" +
-	"\fSyncDatabase\x12\x1b.photos.SyncDatabaseRequest\x1a\x1c.photos.SyncDatabaseProgress\"\x1a\x82\xd3\xe4\x93\x02\x14:\x01*\"\x0f/v1/photos/sync0\x01\x12h\n" +
+	"\fSyncDatabase\x12\x1b.photos.SyncDatabaseRequest\x1a\x1c.photos.SyncDatabaseProgress\"\x1a\x82\xd3\xe4\x93\x02\x14:\x01*\"\x0f/v1/photos/sync0\x01\x12l\n" +
+	"\rVerifyLibrary\x12\x1c.photos.VerifyLibraryRequest\x1a\x1d.photos.VerifyLibraryProgress\"\x1c\x82\xd3\xe4\x93\x02\x16:\x01*\"\x11/v1/photos:verify0\x01\x12h\n" +
 	"\n" +
-	"UpdateWebp\x12\x19.photos.UpdateWebpRequest\x1a\x1a.photos.UpdateWebpProgress\"!\x82\xd3\xe4\x93\x02\x1b:\x01*\"\x16/v1/photos:update-webp0\x01\x12\x80\x01\n" +
+	"UpdateWebp\x12\x19.photos.UpdateWebpRequest\x1a\x1a.photos.UpdateWebpProgress\"!\x82\xd3\xe4\x93\x02\x1b:\x01*\"\x16/v1/photos:update-webp0\x01\x12h\n" +
+	"\n" +
+	"UpdateAvif\x12\x19.photos.UpdateAvifRequest\x1a\x1a.photos.UpdateAvifProgress\"!\x82\xd3\xe4\x93\x02\x1b:\x01*\"\x16/v1/photos:update-avif0\x01\x12r\n" +
+	"\x0eTranscodeVideo\x12\x1d.photos.TranscodeVideoRequest\x1a\x1e.photos.TranscodeVideoProgress\"\x1f\x82\xd3\xe4\x93\x02\x19:\x01*\"\x14/v1/photos:transcode0\x01\x12\x80\x01\n" +
 	"\x0eCreateMarkdown\x12\x1d.photos.CreateMarkdownRequest\x1a\x1e.photos.CreateMarkdownResponse\"/\x82\xd3\xe4\x93\x02):\x01*\"$/v1/directories/{prefix=**}/markdown\x12t\n" +
 	"\vGetMarkdown\x12\x1a.photos.GetMarkdownRequest\x1a\x1b.photos.GetMarkdownResponse\",\x82\xd3\xe4\x93\x02&\x12$/v1/directories/{prefix=**}/markdown\x12\x80\x01\n" +
 	"\x0eUpdateMarkdown\x12\x1d.photos.UpdateMarkdownRequest\x1a\x1e.photos.UpdateMarkdownResponse\"/\x82\xd3\xe4\x93\x02):\x01*\x1a$/v1/directories/{prefix=**}/markdown\x12}\n" +
 	"\x0eDeleteMarkdown\x12\x1d.photos.DeleteMarkdownRequest\x1a\x1e.photos.DeleteMarkdownResponse\",\x82\xd3\xe4\x93\x02&*$/v1/directories/{prefix=**}/markdown\x12\x97\x01\n" +
 	"\x16GenerateVideoThumbnail\x12%.photos.GenerateVideoThumbnailRequest\x1a&.photos.GenerateVideoThumbnailResponse\".\x82\xd3\xe4\x93\x02(:\x01*\"#/v1/photos/{object_id=**}/thumbnail\x12\x8d\x01\n" +
-	"\x12GenerateDNGPreview\x12!.photos.GenerateDNGPreviewRequest\x1a\".photos.GenerateDNGPreviewResponse\"0\x82\xd3\xe4\x93\x02*:\x01*\"%/v1/photos/{object_id=**}/dng-previewB\x0eZ\fphotos/protob\x06proto3"
+	"\x12GenerateDNGPreview\x12!.photos.GenerateDNGPreviewRequest\x1a\".photos.GenerateDNGPreviewResponse\"0\x82\xd3\xe4\x93\x02*:\x01*\"%/v1/photos/{object_id=**}/dng-preview\x12P\n" +
+	"\bGetUsage\x12\x17.photos.GetUsageRequest\x1a\x18.photos.GetUsageResponse\"\x11\x82\xd3\xe4\x93\x02\v\x12\t/v1/usage\x12O\n" +
+	"\bListJobs\x12\x17.photos.ListJobsRequest\x1a\x18.photos.ListJobsResponse\"\x10\x82\xd3\xe4\x93\x02\n" +
+	"\x12\b/v1/jobs\x12N\n" +
+	"\x06GetJob\x12\x15.photos.GetJobRequest\x1a\x16.photos.GetJobResponse\"\x15\x82\xd3\xe4\x93\x02\x0f\x12\r/v1/jobs/{id}\x12]\n" +
+	"\bRetryJob\x12\x17.photos.RetryJobRequest\x1a\x18.photos.RetryJobResponse\"\x1e\x82\xd3\xe4\x93\x02\x18:\x01*\"\x13/v1/jobs/{id}:retry\x12c\n" +
+	"\rListSchedules\x12\x1c.photos.ListSchedulesRequest\x1a\x1d.photos.ListSchedulesResponse\"\x15\x82\xd3\xe4\x93\x02\x0f\x12\r/v1/schedules\x12~\n" +
+	"\x15GetServerCapabilities\x12$.photos.GetServerCapabilitiesRequest\x1a%.photos.GetServerCapabilitiesResponse\"\x18\x82\xd3\xe4\x93\x02\x12\x12\x10/v1/capabilities\x12X\n" +
+	"\n" +
+	"GetChanges\x12\x19.photos.GetChangesRequest\x1a\x1a.photos.GetChangesResponse\"\x13\x82\xd3\xe4\x93\x02\r\x12\v/v1/changesB\x0eZ\fphotos/protob\x06proto3"
 
 var (
 	file_proto_photos_proto_rawDescOnce sync.Once
@@ -2986,118 +6499,214 @@ func file_proto_photos_proto_rawDescGZIP() []byte {
 	return file_proto_photos_proto_rawDescData
 }
 
-var file_proto_photos_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
-var file_proto_photos_proto_msgTypes = make([]protoimpl.MessageInfo, 45)
+var file_proto_photos_proto_enumTypes = make([]protoimpl.EnumInfo, 7)
+var file_proto_photos_proto_msgTypes = make([]protoimpl.MessageInfo, 84)
 var file_proto_photos_proto_goTypes = []any{
-	(SyncDatabaseProgress_Phase)(0),        // 0: photos.SyncDatabaseProgress.Phase
-	(*Photo)(nil),                          // 1: photos.Photo
-	(*UploadRequest)(nil),                  // 2: photos.UploadRequest
-	(*UploadResponse)(nil),                 // 3: photos.UploadResponse
-	(*DownloadRequest)(nil),                // 4: photos.DownloadRequest
-	(*DownloadResponse)(nil),               // 5: photos.DownloadResponse
-	(*DeletePhotoRequest)(nil),             // 6: photos.DeletePhotoRequest
-	(*DeletePhotoResponse)(nil),            // 7: photos.DeletePhotoResponse
-	(*GetPhotoRequest)(nil),                // 8: photos.GetPhotoRequest
-	(*GetPhotoResponse)(nil),               // 9: photos.GetPhotoResponse
-	(*ListPhotosRequest)(nil),              // 10: photos.ListPhotosRequest
-	(*ListPhotosResponse)(nil),             // 11: photos.ListPhotosResponse
-	(*CopyPhotoRequest)(nil),               // 12: photos.CopyPhotoRequest
-	(*CopyPhotoResponse)(nil),              // 13: photos.CopyPhotoResponse
-	(*RenamePhotoRequest)(nil),             // 14: photos.RenamePhotoRequest
-	(*RenamePhotoResponse)(nil),            // 15: photos.RenamePhotoResponse
-	(*UpdatePhotoMetadataRequest)(nil),     // 16: photos.UpdatePhotoMetadataRequest
-	(*UpdatePhotoMetadataResponse)(nil),    // 17: photos.UpdatePhotoMetadataResponse
-	(*GenerateSignedUrlRequest)(nil),       // 18: photos.GenerateSignedUrlRequest
-	(*GenerateSignedUrlResponse)(nil),      // 19: photos.GenerateSignedUrlResponse
-	(*PhotoExistsRequest)(nil),             // 20: photos.PhotoExistsRequest
-	(*PhotoExistsResponse)(nil),            // 21: photos.PhotoExistsResponse
-	(*ListDirectoriesRequest)(nil),         // 22: photos.ListDirectoriesRequest
-	(*ListDirectoriesResponse)(nil),        // 23: photos.ListDirectoriesResponse
-	(*SyncDatabaseRequest)(nil),            // 24: photos.SyncDatabaseRequest
-	(*SyncDatabaseProgress)(nil),           // 25: photos.SyncDatabaseProgress
-	(*UpdateWebpRequest)(nil),              // 26: photos.UpdateWebpRequest
-	(*UpdateWebpProgress)(nil),             // 27: photos.UpdateWebpProgress
-	(*StreamingUploadRequest)(nil),         // 28: photos.StreamingUploadRequest
-	(*BulkUploadFileResult)(nil),           // 29: photos.BulkUploadFileResult
-	(*PhotoMetadata)(nil),                  // 30: photos.PhotoMetadata
-	(*StreamingDownloadRequest)(nil),       // 31: photos.StreamingDownloadRequest
-	(*StreamingDownloadResponse)(nil),      // 32: photos.StreamingDownloadResponse
-	(*CreateMarkdownRequest)(nil),          // 33: photos.CreateMarkdownRequest
-	(*CreateMarkdownResponse)(nil),         // 34: photos.CreateMarkdownResponse
-	(*GetMarkdownRequest)(nil),             // 35: photos.GetMarkdownRequest
-	(*GetMarkdownResponse)(nil),            // 36: photos.GetMarkdownResponse
-	(*UpdateMarkdownRequest)(nil),          // 37: photos.UpdateMarkdownRequest
-	(*UpdateMarkdownResponse)(nil),         // 38: photos.UpdateMarkdownResponse
-	(*DeleteMarkdownRequest)(nil),          // 39: photos.DeleteMarkdownRequest
-	(*DeleteMarkdownResponse)(nil),         // 40: photos.DeleteMarkdownResponse
-	(*GenerateVideoThumbnailRequest)(nil),  // 41: photos.GenerateVideoThumbnailRequest
-	(*GenerateVideoThumbnailResponse)(nil), // 42: photos.GenerateVideoThumbnailResponse
-	(*GenerateDNGPreviewRequest)(nil),      // 43: photos.GenerateDNGPreviewRequest
-	(*GenerateDNGPreviewResponse)(nil),     // 44: photos.GenerateDNGPreviewResponse
-	nil,                                    // 45: photos.UpdatePhotoMetadataRequest.CustomMetadataEntry
+	(ConflictPolicy)(0),                    // 0: photos.ConflictPolicy
+	(RenderFit)(0),                         // 1: photos.RenderFit
+	(RenderFormat)(0),                      // 2: photos.RenderFormat
+	(SyncChange_Action)(0),                 // 3: photos.SyncChange.Action
+	(SyncDatabaseProgress_Phase)(0),        // 4: photos.SyncDatabaseProgress.Phase
+	(IntegrityProblem_Kind)(0),             // 5: photos.IntegrityProblem.Kind
+	(ServerCapability_Provider)(0),         // 6: photos.ServerCapability.Provider
+	(*Photo)(nil),                          // 7: photos.Photo
+	(*PhotoStack)(nil),                     // 8: photos.PhotoStack
+	(*PhotoRendition)(nil),                 // 9: photos.PhotoRendition
+	(*PhotoCrop)(nil),                      // 10: photos.PhotoCrop
+	(*UploadRequest)(nil),                  // 11: photos.UploadRequest
+	(*UploadResponse)(nil),                 // 12: photos.UploadResponse
+	(*DownloadRequest)(nil),                // 13: photos.DownloadRequest
+	(*DownloadResponse)(nil),               // 14: photos.DownloadResponse
+	(*DeletePhotoRequest)(nil),             // 15: photos.DeletePhotoRequest
+	(*DeletePhotoResponse)(nil),            // 16: photos.DeletePhotoResponse
+	(*GetPhotoRequest)(nil),                // 17: photos.GetPhotoRequest
+	(*GetPhotoResponse)(nil),               // 18: photos.GetPhotoResponse
+	(*ListPhotosRequest)(nil),              // 19: photos.ListPhotosRequest
+	(*ListPhotosResponse)(nil),             // 20: photos.ListPhotosResponse
+	(*CopyPhotoRequest)(nil),               // 21: photos.CopyPhotoRequest
+	(*CopyPhotoResponse)(nil),              // 22: photos.CopyPhotoResponse
+	(*RenamePhotoRequest)(nil),             // 23: photos.RenamePhotoRequest
+	(*RenamePhotoResponse)(nil),            // 24: photos.RenamePhotoResponse
+	(*UpdatePhotoMetadataRequest)(nil),     // 25: photos.UpdatePhotoMetadataRequest
+	(*UpdatePhotoMetadataResponse)(nil),    // 26: photos.UpdatePhotoMetadataResponse
+	(*GetStackRequest)(nil),                // 27: photos.GetStackRequest
+	(*GetStackResponse)(nil),               // 28: photos.GetStackResponse
+	(*SetStackCoverRequest)(nil),           // 29: photos.SetStackCoverRequest
+	(*SetStackCoverResponse)(nil),          // 30: photos.SetStackCoverResponse
+	(*UnstackRequest)(nil),                 // 31: photos.UnstackRequest
+	(*UnstackResponse)(nil),                // 32: photos.UnstackResponse
+	(*GenerateSignedUrlRequest)(nil),       // 33: photos.GenerateSignedUrlRequest
+	(*GenerateSignedUrlResponse)(nil),      // 34: photos.GenerateSignedUrlResponse
+	(*PhotoExistsRequest)(nil),             // 35: photos.PhotoExistsRequest
+	(*PhotoExistsResponse)(nil),            // 36: photos.PhotoExistsResponse
+	(*ListDirectoriesRequest)(nil),         // 37: photos.ListDirectoriesRequest
+	(*ListDirectoriesResponse)(nil),        // 38: photos.ListDirectoriesResponse
+	(*SyncDatabaseRequest)(nil),            // 39: photos.SyncDatabaseRequest
+	(*SyncChange)(nil),                     // 40: photos.SyncChange
+	(*SyncDatabaseProgress)(nil),           // 41: photos.SyncDatabaseProgress
+	(*VerifyLibraryRequest)(nil),           // 42: photos.VerifyLibraryRequest
+	(*IntegrityProblem)(nil),               // 43: photos.IntegrityProblem
+	(*VerifyLibraryProgress)(nil),          // 44: photos.VerifyLibraryProgress
+	(*UpdateWebpRequest)(nil),              // 45: photos.UpdateWebpRequest
+	(*UpdateWebpProgress)(nil),             // 46: photos.UpdateWebpProgress
+	(*UpdateAvifRequest)(nil),              // 47: photos.UpdateAvifRequest
+	(*UpdateAvifProgress)(nil),             // 48: photos.UpdateAvifProgress
+	(*TranscodeVideoRequest)(nil),          // 49: photos.TranscodeVideoRequest
+	(*TranscodeVideoProgress)(nil),         // 50: photos.TranscodeVideoProgress
+	(*StreamingUploadRequest)(nil),         // 51: photos.StreamingUploadRequest
+	(*BulkUploadFileResult)(nil),           // 52: photos.BulkUploadFileResult
+	(*PhotoMetadata)(nil),                  // 53: photos.PhotoMetadata
+	(*StreamingDownloadRequest)(nil),       // 54: photos.StreamingDownloadRequest
+	(*StreamingDownloadResponse)(nil),      // 55: photos.StreamingDownloadResponse
+	(*DownloadArchiveRequest)(nil),         // 56: photos.DownloadArchiveRequest
+	(*DownloadArchiveResponse)(nil),        // 57: photos.DownloadArchiveResponse
+	(*RenderPhotoRequest)(nil),             // 58: photos.RenderPhotoRequest
+	(*RenderPhotoResponse)(nil),            // 59: photos.RenderPhotoResponse
+	(*CreateMarkdownRequest)(nil),          // 60: photos.CreateMarkdownRequest
+	(*CreateMarkdownResponse)(nil),         // 61: photos.CreateMarkdownResponse
+	(*GetMarkdownRequest)(nil),             // 62: photos.GetMarkdownRequest
+	(*GetMarkdownResponse)(nil),            // 63: photos.GetMarkdownResponse
+	(*UpdateMarkdownRequest)(nil),          // 64: photos.UpdateMarkdownRequest
+	(*UpdateMarkdownResponse)(nil),         // 65: photos.UpdateMarkdownResponse
+	(*DeleteMarkdownRequest)(nil),          // 66: photos.DeleteMarkdownRequest
+	(*DeleteMarkdownResponse)(nil),         // 67: photos.DeleteMarkdownResponse
+	(*GenerateVideoThumbnailRequest)(nil),  // 68: photos.GenerateVideoThumbnailRequest
+	(*GenerateVideoThumbnailResponse)(nil), // 69: photos.GenerateVideoThumbnailResponse
+	(*GenerateDNGPreviewRequest)(nil),      // 70: photos.GenerateDNGPreviewRequest
+	(*GenerateDNGPreviewResponse)(nil),     // 71: photos.GenerateDNGPreviewResponse
+	(*GetUsageRequest)(nil),                // 72: photos.GetUsageRequest
+	(*GetUsageResponse)(nil),               // 73: photos.GetUsageResponse
+	(*Job)(nil),                            // 74: photos.Job
+	(*ListJobsRequest)(nil),                // 75: photos.ListJobsRequest
+	(*ListJobsResponse)(nil),               // 76: photos.ListJobsResponse
+	(*GetJobRequest)(nil),                  // 77: photos.GetJobRequest
+	(*GetJobResponse)(nil),                 // 78: photos.GetJobResponse
+	(*RetryJobRequest)(nil),                // 79: photos.RetryJobRequest
+	(*RetryJobResponse)(nil),               // 80: photos.RetryJobResponse
+	(*ScheduledRun)(nil),                   // 81: photos.ScheduledRun
+	(*Schedule)(nil),                       // 82: photos.Schedule
+	(*ListSchedulesRequest)(nil),           // 83: photos.ListSchedulesRequest
+	(*ListSchedulesResponse)(nil),          // 84: photos.ListSchedulesResponse
+	(*GetServerCapabilitiesRequest)(nil),   // 85: photos.GetServerCapabilitiesRequest
+	(*ServerCapability)(nil),               // 86: photos.ServerCapability
+	(*GetServerCapabilitiesResponse)(nil),  // 87: photos.GetServerCapabilitiesResponse
+	(*GetChangesRequest)(nil),              // 88: photos.GetChangesRequest
+	(*GetChangesResponse)(nil),             // 89: photos.GetChangesResponse
+	nil,                                    // 90: photos.UpdatePhotoMetadataRequest.CustomMetadataEntry
 }
 var file_proto_photos_proto_depIdxs = []int32{
-	1,  // 0: photos.UploadResponse.photo:type_name -> photos.Photo
-	1,  // 1: photos.DownloadResponse.photo:type_name -> photos.Photo
-	1,  // 2: photos.GetPhotoResponse.photo:type_name -> photos.Photo
-	1,  // 3: photos.ListPhotosResponse.photos:type_name -> photos.Photo
-	1,  // 4: photos.CopyPhotoResponse.photo:type_name -> photos.Photo
-	1,  // 5: photos.RenamePhotoResponse.photo:type_name -> photos.Photo
-	45, // 6: photos.UpdatePhotoMetadataRequest.custom_metadata:type_name -> photos.UpdatePhotoMetadataRequest.CustomMetadataEntry
-	1,  // 7: photos.UpdatePhotoMetadataResponse.photo:type_name -> photos.Photo
-	0,  // 8: photos.SyncDatabaseProgress.phase:type_name -> photos.SyncDatabaseProgress.Phase
-	30, // 9: photos.StreamingUploadRequest.metadata:type_name -> photos.PhotoMetadata
-	1,  // 10: photos.BulkUploadFileResult.photo:type_name -> photos.Photo
-	1,  // 11: photos.StreamingDownloadResponse.metadata:type_name -> photos.Photo
-	2,  // 12: photos.ByteService.Upload:input_type -> photos.UploadRequest
-	4,  // 13: photos.ByteService.Download:input_type -> photos.DownloadRequest
-	28, // 14: photos.ByteService.StreamingUpload:input_type -> photos.StreamingUploadRequest
-	28, // 15: photos.ByteService.BulkStreamingUpload:input_type -> photos.StreamingUploadRequest
-	31, // 16: photos.ByteService.StreamingDownload:input_type -> photos.StreamingDownloadRequest
-	6,  // 17: photos.LibraryService.DeletePhoto:input_type -> photos.DeletePhotoRequest
-	8,  // 18: photos.LibraryService.GetPhoto:input_type -> photos.GetPhotoRequest
-	10, // 19: photos.LibraryService.ListPhotos:input_type -> photos.ListPhotosRequest
-	12, // 20: photos.LibraryService.CopyPhoto:input_type -> photos.CopyPhotoRequest
-	14, // 21: photos.LibraryService.RenamePhoto:input_type -> photos.RenamePhotoRequest
-	16, // 22: photos.LibraryService.UpdatePhotoMetadata:input_type -> photos.UpdatePhotoMetadataRequest
-	18, // 23: photos.LibraryService.GenerateSignedUrl:input_type -> photos.GenerateSignedUrlRequest
-	20, // 24: photos.LibraryService.PhotoExists:input_type -> photos.PhotoExistsRequest
-	22, // 25: photos.LibraryService.ListDirectories:input_type -> photos.ListDirectoriesRequest
-	24, // 26: photos.LibraryService.SyncDatabase:input_type -> photos.SyncDatabaseRequest
-	26, // 27: photos.LibraryService.UpdateWebp:input_type -> photos.UpdateWebpRequest
-	33, // 28: photos.LibraryService.CreateMarkdown:input_type -> photos.CreateMarkdownRequest
-	35, // 29: photos.LibraryService.GetMarkdown:input_type -> photos.GetMarkdownRequest
-	37, // 30: photos.LibraryService.UpdateMarkdown:input_type -> photos.UpdateMarkdownRequest
-	39, // 31: photos.LibraryService.DeleteMarkdown:input_type -> photos.DeleteMarkdownRequest
-	41, // 32: photos.LibraryService.GenerateVideoThumbnail:input_type -> photos.GenerateVideoThumbnailRequest
-	43, // 33: photos.LibraryService.GenerateDNGPreview:input_type -> photos.GenerateDNGPreviewRequest
-	3,  // 34: photos.ByteService.Upload:output_type -> photos.UploadResponse
-	5,  // 35: photos.ByteService.Download:output_type -> photos.DownloadResponse
-	3,  // 36: photos.ByteService.StreamingUpload:output_type -> photos.UploadResponse
-	29, // 37: photos.ByteService.BulkStreamingUpload:output_type -> photos.BulkUploadFileResult
-	32, // 38: photos.ByteService.StreamingDownload:output_type -> photos.StreamingDownloadResponse
-	7,  // 39: photos.LibraryService.DeletePhoto:output_type -> photos.DeletePhotoResponse
-	9,  // 40: photos.LibraryService.GetPhoto:output_type -> photos.GetPhotoResponse
-	11, // 41: photos.LibraryService.ListPhotos:output_type -> photos.ListPhotosResponse
-	13, // 42: photos.LibraryService.CopyPhoto:output_type -> photos.CopyPhotoResponse
-	15, // 43: photos.LibraryService.RenamePhoto:output_type -> photos.RenamePhotoResponse
-	17, // 44: photos.LibraryService.UpdatePhotoMetadata:output_type -> photos.UpdatePhotoMetadataResponse
-	19, // 45: photos.LibraryService.GenerateSignedUrl:output_type -> photos.GenerateSignedUrlResponse
-	21, // 46: photos.LibraryService.PhotoExists:output_type -> photos.PhotoExistsResponse
-	23, // 47: photos.LibraryService.ListDirectories:output_type -> photos.ListDirectoriesResponse
-	25, // 48: photos.LibraryService.SyncDatabase:output_type -> photos.SyncDatabaseProgress
-	27, // 49: photos.LibraryService.UpdateWebp:output_type -> photos.UpdateWebpProgress
-	34, // 50: photos.LibraryService.CreateMarkdown:output_type -> photos.CreateMarkdownResponse
-	36, // 51: photos.LibraryService.GetMarkdown:output_type -> photos.GetMarkdownResponse
-	38, // 52: photos.LibraryService.UpdateMarkdown:output_type -> photos.UpdateMarkdownResponse
-	40, // 53: photos.LibraryService.DeleteMarkdown:output_type -> photos.DeleteMarkdownResponse
-	42, // 54: photos.LibraryService.GenerateVideoThumbnail:output_type -> photos.GenerateVideoThumbnailResponse
-	44, // 55: photos.LibraryService.GenerateDNGPreview:output_type -> photos.GenerateDNGPreviewResponse
-	34, // [34:56] is the sub-list for method output_type
-	12, // [12:34] is the sub-list for method input_type
-	12, // [12:12] is the sub-list for extension type_name
-	12, // [12:12] is the sub-list for extension extendee
-	0,  // [0:12] is the sub-list for field type_name
+	10, // 0: photos.Photo.crop:type_name -> photos.PhotoCrop
+	9,  // 1: photos.Photo.renditions:type_name -> photos.PhotoRendition
+	8,  // 2: photos.Photo.stack:type_name -> photos.PhotoStack
+	0,  // 3: photos.UploadRequest.conflict_policy:type_name -> photos.ConflictPolicy
+	7,  // 4: photos.UploadResponse.photo:type_name -> photos.Photo
+	7,  // 5: photos.DownloadResponse.photo:type_name -> photos.Photo
+	7,  // 6: photos.GetPhotoResponse.photo:type_name -> photos.Photo
+	7,  // 7: photos.ListPhotosResponse.photos:type_name -> photos.Photo
+	7,  // 8: photos.CopyPhotoResponse.photo:type_name -> photos.Photo
+	7,  // 9: photos.RenamePhotoResponse.photo:type_name -> photos.Photo
+	90, // 10: photos.UpdatePhotoMetadataRequest.custom_metadata:type_name -> photos.UpdatePhotoMetadataRequest.CustomMetadataEntry
+	7,  // 11: photos.UpdatePhotoMetadataResponse.photo:type_name -> photos.Photo
+	8,  // 12: photos.GetStackResponse.stack:type_name -> photos.PhotoStack
+	7,  // 13: photos.GetStackResponse.photos:type_name -> photos.Photo
+	8,  // 14: photos.SetStackCoverResponse.stack:type_name -> photos.PhotoStack
+	3,  // 15: photos.SyncChange.action:type_name -> photos.SyncChange.Action
+	4,  // 16: photos.SyncDatabaseProgress.phase:type_name -> photos.SyncDatabaseProgress.Phase
+	40, // 17: photos.SyncDatabaseProgress.change:type_name -> photos.SyncChange
+	5,  // 18: photos.IntegrityProblem.kind:type_name -> photos.IntegrityProblem.Kind
+	43, // 19: photos.VerifyLibraryProgress.problem:type_name -> photos.IntegrityProblem
+	53, // 20: photos.StreamingUploadRequest.metadata:type_name -> photos.PhotoMetadata
+	7,  // 21: photos.BulkUploadFileResult.photo:type_name -> photos.Photo
+	0,  // 22: photos.PhotoMetadata.conflict_policy:type_name -> photos.ConflictPolicy
+	7,  // 23: photos.StreamingDownloadResponse.metadata:type_name -> photos.Photo
+	1,  // 24: photos.RenderPhotoRequest.fit:type_name -> photos.RenderFit
+	2,  // 25: photos.RenderPhotoRequest.format:type_name -> photos.RenderFormat
+	74, // 26: photos.ListJobsResponse.jobs:type_name -> photos.Job
+	74, // 27: photos.GetJobResponse.job:type_name -> photos.Job
+	74, // 28: photos.RetryJobResponse.job:type_name -> photos.Job
+	81, // 29: photos.Schedule.last_run:type_name -> photos.ScheduledRun
+	82, // 30: photos.ListSchedulesResponse.schedules:type_name -> photos.Schedule
+	6,  // 31: photos.ServerCapability.provider:type_name -> photos.ServerCapability.Provider
+	86, // 32: photos.GetServerCapabilitiesResponse.capabilities:type_name -> photos.ServerCapability
+	11, // 33: photos.ByteService.Upload:input_type -> photos.UploadRequest
+	13, // 34: photos.ByteService.Download:input_type -> photos.DownloadRequest
+	51, // 35: photos.ByteService.StreamingUpload:input_type -> photos.StreamingUploadRequest
+	51, // 36: photos.ByteService.BulkStreamingUpload:input_type -> photos.StreamingUploadRequest
+	54, // 37: photos.ByteService.StreamingDownload:input_type -> photos.StreamingDownloadRequest
+	56, // 38: photos.ByteService.DownloadArchive:input_type -> photos.DownloadArchiveRequest
+	58, // 39: photos.ByteService.RenderPhoto:input_type -> photos.RenderPhotoRequest
+	15, // 40: photos.LibraryService.DeletePhoto:input_type -> photos.DeletePhotoRequest
+	17, // 41: photos.LibraryService.GetPhoto:input_type -> photos.GetPhotoRequest
+	19, // 42: photos.LibraryService.ListPhotos:input_type -> photos.ListPhotosRequest
+	21, // 43: photos.LibraryService.CopyPhoto:input_type -> photos.CopyPhotoRequest
+	23, // 44: photos.LibraryService.RenamePhoto:input_type -> photos.RenamePhotoRequest
+	25, // 45: photos.LibraryService.UpdatePhotoMetadata:input_type -> photos.UpdatePhotoMetadataRequest
+	27, // 46: photos.LibraryService.GetStack:input_type -> photos.GetStackRequest
+	29, // 47: photos.LibraryService.SetStackCover:input_type -> photos.SetStackCoverRequest
+	31, // 48: photos.LibraryService.Unstack:input_type -> photos.UnstackRequest
+	33, // 49: photos.LibraryService.GenerateSignedUrl:input_type -> photos.GenerateSignedUrlRequest
+	35, // 50: photos.LibraryService.PhotoExists:input_type -> photos.PhotoExistsRequest
+	37, // 51: photos.LibraryService.ListDirectories:input_type -> photos.ListDirectoriesRequest
+	39, // 52: photos.LibraryService.SyncDatabase:input_type -> photos.SyncDatabaseRequest
+	42, // 53: photos.LibraryService.VerifyLibrary:input_type -> photos.VerifyLibraryRequest
+	45, // 54: photos.LibraryService.UpdateWebp:input_type -> photos.UpdateWebpRequest
+	47, // 55: photos.LibraryService.UpdateAvif:input_type -> photos.UpdateAvifRequest
+	49, // 56: photos.LibraryService.TranscodeVideo:input_type -> photos.TranscodeVideoRequest
+	60, // 57: photos.LibraryService.CreateMarkdown:input_type -> photos.CreateMarkdownRequest
+	62, // 58: photos.LibraryService.GetMarkdown:input_type -> photos.GetMarkdownRequest
+	64, // 59: photos.LibraryService.UpdateMarkdown:input_type -> photos.UpdateMarkdownRequest
+	66, // 60: photos.LibraryService.DeleteMarkdown:input_type -> photos.DeleteMarkdownRequest
+	68, // 61: photos.LibraryService.GenerateVideoThumbnail:input_type -> photos.GenerateVideoThumbnailRequest
+	70, // 62: photos.LibraryService.GenerateDNGPreview:input_type -> photos.GenerateDNGPreviewRequest
+	72, // 63: photos.LibraryService.GetUsage:input_type -> photos.GetUsageRequest
+	75, // 64: photos.LibraryService.ListJobs:input_type -> photos.ListJobsRequest
+	77, // 65: photos.LibraryService.GetJob:input_type -> photos.GetJobRequest
+	79, // 66: photos.LibraryService.RetryJob:input_type -> photos.RetryJobRequest
+	83, // 67: photos.LibraryService.ListSchedules:input_type -> photos.ListSchedulesRequest
+	85, // 68: photos.LibraryService.GetServerCapabilities:input_type -> photos.GetServerCapabilitiesRequest
+	88, // 69: photos.LibraryService.GetChanges:input_type -> photos.GetChangesRequest
+	12, // 70: photos.ByteService.Upload:output_type -> photos.UploadResponse
+	14, // 71: photos.ByteService.Download:output_type -> photos.DownloadResponse
+	12, // 72: photos.ByteService.StreamingUpload:output_type -> photos.UploadResponse
+	52, // 73: photos.ByteService.BulkStreamingUpload:output_type -> photos.BulkUploadFileResult
+	55, // 74: photos.ByteService.StreamingDownload:output_type -> photos.StreamingDownloadResponse
+	57, // 75: photos.ByteService.DownloadArchive:output_type -> photos.DownloadArchiveResponse
+	59, // 76: photos.ByteService.RenderPhoto:output_type -> photos.RenderPhotoResponse
+	16, // 77: photos.LibraryService.DeletePhoto:output_type -> photos.DeletePhotoResponse
+	18, // 78: photos.LibraryService.GetPhoto:output_type -> photos.GetPhotoResponse
+	20, // 79: photos.LibraryService.ListPhotos:output_type -> photos.ListPhotosResponse
+	22, // 80: photos.LibraryService.CopyPhoto:output_type -> photos.CopyPhotoResponse
+	24, // 81: photos.LibraryService.RenamePhoto:output_type -> photos.RenamePhotoResponse
+	26, // 82: photos.LibraryService.UpdatePhotoMetadata:output_type -> photos.UpdatePhotoMetadataResponse
+	28, // 83: photos.LibraryService.GetStack:output_type -> photos.GetStackResponse
+	30, // 84: photos.LibraryService.SetStackCover:output_type -> photos.SetStackCoverResponse
+	32, // 85: photos.LibraryService.Unstack:output_type -> photos.UnstackResponse
+	34, // 86: photos.LibraryService.GenerateSignedUrl:output_type -> photos.GenerateSignedUrlResponse
+	36, // 87: photos.LibraryService.PhotoExists:output_type -> photos.PhotoExistsResponse
+	38, // 88: photos.LibraryService.ListDirectories:output_type -> photos.ListDirectoriesResponse
+	41, // 89: photos.LibraryService.SyncDatabase:output_type -> photos.SyncDatabaseProgress
+	44, // 90: photos.LibraryService.VerifyLibrary:output_type -> photos.VerifyLibraryProgress
+	46, // 91: photos.LibraryService.UpdateWebp:output_type -> photos.UpdateWebpProgress
+	48, // 92: photos.LibraryService.UpdateAvif:output_type -> photos.UpdateAvifProgress
+	50, // 93: photos.LibraryService.TranscodeVideo:output_type -> photos.TranscodeVideoProgress
+	61, // 94: photos.LibraryService.CreateMarkdown:output_type -> photos.CreateMarkdownResponse
+	63, // 95: photos.LibraryService.GetMarkdown:output_type -> photos.GetMarkdownResponse
+	65, // 96: photos.LibraryService.UpdateMarkdown:output_type -> photos.UpdateMarkdownResponse
+	67, // 97: photos.LibraryService.DeleteMarkdown:output_type -> photos.DeleteMarkdownResponse
+	69, // 98: photos.LibraryService.GenerateVideoThumbnail:output_type -> photos.GenerateVideoThumbnailResponse
+	71, // 99: photos.LibraryService.GenerateDNGPreview:output_type -> photos.GenerateDNGPreviewResponse
+	73, // 100: photos.LibraryService.GetUsage:output_type -> photos.GetUsageResponse
+	76, // 101: photos.LibraryService.ListJobs:output_type -> photos.ListJobsResponse
+	78, // 102: photos.LibraryService.GetJob:output_type -> photos.GetJobResponse
+	80, // 103: photos.LibraryService.RetryJob:output_type -> photos.RetryJobResponse
+	84, // 104: photos.LibraryService.ListSchedules:output_type -> photos.ListSchedulesResponse
+	87, // 105: photos.LibraryService.GetServerCapabilities:output_type -> photos.GetServerCapabilitiesResponse
+	89, // 106: photos.LibraryService.GetChanges:output_type -> photos.GetChangesResponse
+	70, // [70:107] is the sub-list for method output_type
+	33, // [33:70] is the sub-list for method input_type
+	33, // [33:33] is the sub-list for extension type_name
+	33, // [33:33] is the sub-list for extension extendee
+	0,  // [0:33] is the sub-list for field type_name
 }
 
 func init() { file_proto_photos_proto_init() }
@@ -3105,12 +6714,12 @@ func file_proto_photos_proto_init() {
 	if File_proto_photos_proto != nil {
 		return
 	}
-	file_proto_photos_proto_msgTypes[27].OneofWrappers = []any{
+	file_proto_photos_proto_msgTypes[44].OneofWrappers = []any{
 		(*StreamingUploadRequest_Metadata)(nil),
 		(*StreamingUploadRequest_Chunk)(nil),
 		(*StreamingUploadRequest_EndOfFile)(nil),
 	}
-	file_proto_photos_proto_msgTypes[31].OneofWrappers = []any{
+	file_proto_photos_proto_msgTypes[48].OneofWrappers = []any{
 		(*StreamingDownloadResponse_Metadata)(nil),
 		(*StreamingDownloadResponse_Chunk)(nil),
 	}
@@ -3119,8 +6728,8 @@ func file_proto_photos_proto_init() {
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_photos_proto_rawDesc), len(file_proto_photos_proto_rawDesc)),
-			NumEnums:      1,
-			NumMessages:   45,
+			NumEnums:      7,
+			NumMessages:   84,
 			NumExtensions: 0,
 			NumServices:   2,
 		},
@@ -24,6 +24,8 @@ const (
 	ByteService_StreamingUpload_FullMethodName     = "/photos.ByteService/StreamingUpload"
 	ByteService_BulkStreamingUpload_FullMethodName = "/photos.ByteService/BulkStreamingUpload"
 	ByteService_StreamingDownload_FullMethodName   = "/photos.ByteService/StreamingDownload"
+	ByteService_DownloadArchive_FullMethodName     = "/photos.ByteService/DownloadArchive"
+	ByteService_RenderPhoto_FullMethodName         = "/photos.ByteService/RenderPhoto"
 )
 
 // ByteServiceClient is the client API for ByteService service.
@@ -45,6 +47,14 @@ type ByteServiceClient interface {
 	BulkStreamingUpload(ctx context.Context, opts ...grpc.CallOption) (grpc.BidiStreamingClient[StreamingUploadRequest, BulkUploadFileResult], error)
 	// StreamingDownload downloads a photo using server-side streaming for large files
 	StreamingDownload(ctx context.Context, in *StreamingDownloadRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[StreamingDownloadResponse], error)
+	// DownloadArchive streams a ZIP archive of every photo under a prefix. The
+	// RESTful route is GET /v1/archive/{prefix}, served outside grpc-gateway as
+	// it returns the raw ZIP bytes.
+	DownloadArchive(ctx context.Context, in *DownloadArchiveRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[DownloadArchiveResponse], error)
+	// RenderPhoto returns a resized rendition of a photo, cached on the
+	// server's disk. The RESTful route is GET /v1/photos/render/{object_id},
+	// served outside grpc-gateway as it returns the raw image bytes.
+	RenderPhoto(ctx context.Context, in *RenderPhotoRequest, opts ...grpc.CallOption) (*RenderPhotoResponse, error)
 }
 
 type byteServiceClient struct {
@@ -120,6 +130,35 @@ func (c *byteServiceClient) StreamingDownload(ctx context.Context, in *Streaming
 // This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
 type ByteService_StreamingDownloadClient = grpc.ServerStreamingClient[StreamingDownloadResponse]
 
+func (c *byteServiceClient) DownloadArchive(ctx context.Context, in *DownloadArchiveRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[DownloadArchiveResponse], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &ByteService_ServiceDesc.Streams[3], ByteService_DownloadArchive_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[DownloadArchiveRequest, DownloadArchiveResponse]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ByteService_DownloadArchiveClient = grpc.ServerStreamingClient[DownloadArchiveResponse]
+
+func (c *byteServiceClient) RenderPhoto(ctx context.Context, in *RenderPhotoRequest, opts ...grpc.CallOption) (*RenderPhotoResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RenderPhotoResponse)
+	err := c.cc.Invoke(ctx, ByteService_RenderPhoto_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // ByteServiceServer is the server API for ByteService service.
 // All implementations must embed UnimplementedByteServiceServer
 // for forward compatibility.
@@ -139,6 +178,14 @@ type ByteServiceServer interface {
 	BulkStreamingUpload(grpc.BidiStreamingServer[StreamingUploadRequest, BulkUploadFileResult]) error
 	// StreamingDownload downloads a photo using server-side streaming for large files
 	StreamingDownload(*StreamingDownloadRequest, grpc.ServerStreamingServer[StreamingDownloadResponse]) error
+	// DownloadArchive streams a ZIP archive of every photo under a prefix. The
+	// RESTful route is GET /v1/archive/{prefix}, served outside grpc-gateway as
+	// it returns the raw ZIP bytes.
+	DownloadArchive(*DownloadArchiveRequest, grpc.ServerStreamingServer[DownloadArchiveResponse]) error
+	// RenderPhoto returns a resized rendition of a photo, cached on the
+	// server's disk. The RESTful route is GET /v1/photos/render/{object_id},
+	// served outside grpc-gateway as it returns the raw image bytes.
+	RenderPhoto(context.Context, *RenderPhotoRequest) (*RenderPhotoResponse, error)
 	mustEmbedUnimplementedByteServiceServer()
 }
 
@@ -164,6 +211,12 @@ func (UnimplementedByteServiceServer) BulkStreamingUpload(grpc.BidiStreamingServ
 func (UnimplementedByteServiceServer) StreamingDownload(*StreamingDownloadRequest, grpc.ServerStreamingServer[StreamingDownloadResponse]) error {
 	return status.Error(codes.Unimplemented, "method StreamingDownload not implemented")
 }
+func (UnimplementedByteServiceServer) DownloadArchive(*DownloadArchiveRequest, grpc.ServerStreamingServer[DownloadArchiveResponse]) error {
+	return status.Error(codes.Unimplemented, "method DownloadArchive not implemented")
+}
+func (UnimplementedByteServiceServer) RenderPhoto(context.Context, *RenderPhotoRequest) (*RenderPhotoResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method RenderPhoto not implemented")
+}
 func (UnimplementedByteServiceServer) mustEmbedUnimplementedByteServiceServer() {}
 func (UnimplementedByteServiceServer) testEmbeddedByValue()                     {}
 
@@ -246,6 +299,35 @@ func _ByteService_StreamingDownload_Handler(srv interface{}, stream grpc.ServerS
 // This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
 type ByteService_StreamingDownloadServer = grpc.ServerStreamingServer[StreamingDownloadResponse]
 
+func _ByteService_DownloadArchive_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(DownloadArchiveRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ByteServiceServer).DownloadArchive(m, &grpc.GenericServerStream[DownloadArchiveRequest, DownloadArchiveResponse]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type ByteService_DownloadArchiveServer = grpc.ServerStreamingServer[DownloadArchiveResponse]
+
+func _ByteService_RenderPhoto_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RenderPhotoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ByteServiceServer).RenderPhoto(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: ByteService_RenderPhoto_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ByteServiceServer).RenderPhoto(ctx, req.(*RenderPhotoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // ByteService_ServiceDesc is the grpc.ServiceDesc for ByteService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -261,6 +343,10 @@ var ByteService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "Download",
 			Handler:    _ByteService_Download_Handler,
 		},
+		{
+			MethodName: "RenderPhoto",
+			Handler:    _ByteService_RenderPhoto_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -279,6 +365,11 @@ var ByteService_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _ByteService_StreamingDownload_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "DownloadArchive",
+			Handler:       _ByteService_DownloadArchive_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "proto/photos.proto",
 }
@@ -290,17 +381,30 @@ const (
 	LibraryService_CopyPhoto_FullMethodName              = "/photos.LibraryService/CopyPhoto"
 	LibraryService_RenamePhoto_FullMethodName            = "/photos.LibraryService/RenamePhoto"
 	LibraryService_UpdatePhotoMetadata_FullMethodName    = "/photos.LibraryService/UpdatePhotoMetadata"
+	LibraryService_GetStack_FullMethodName               = "/photos.LibraryService/GetStack"
+	LibraryService_SetStackCover_FullMethodName          = "/photos.LibraryService/SetStackCover"
+	LibraryService_Unstack_FullMethodName                = "/photos.LibraryService/Unstack"
 	LibraryService_GenerateSignedUrl_FullMethodName      = "/photos.LibraryService/GenerateSignedUrl"
 	LibraryService_PhotoExists_FullMethodName            = "/photos.LibraryService/PhotoExists"
 	LibraryService_ListDirectories_FullMethodName        = "/photos.LibraryService/ListDirectories"
 	LibraryService_SyncDatabase_FullMethodName           = "/photos.LibraryService/SyncDatabase"
+	LibraryService_VerifyLibrary_FullMethodName          = "/photos.LibraryService/VerifyLibrary"
 	LibraryService_UpdateWebp_FullMethodName             = "/photos.LibraryService/UpdateWebp"
+	LibraryService_UpdateAvif_FullMethodName             = "/photos.LibraryService/UpdateAvif"
+	LibraryService_TranscodeVideo_FullMethodName         = "/photos.LibraryService/TranscodeVideo"
 	LibraryService_CreateMarkdown_FullMethodName         = "/photos.LibraryService/CreateMarkdown"
 	LibraryService_GetMarkdown_FullMethodName            = "/photos.LibraryService/GetMarkdown"
 	LibraryService_UpdateMarkdown_FullMethodName         = "/photos.LibraryService/UpdateMarkdown"
 	LibraryService_DeleteMarkdown_FullMethodName         = "/photos.LibraryService/DeleteMarkdown"
 	LibraryService_GenerateVideoThumbnail_FullMethodName = "/photos.LibraryService/GenerateVideoThumbnail"
 	LibraryService_GenerateDNGPreview_FullMethodName     = "/photos.LibraryService/GenerateDNGPreview"
+	LibraryService_GetUsage_FullMethodName               = "/photos.LibraryService/GetUsage"
+	LibraryService_ListJobs_FullMethodName               = "/photos.LibraryService/ListJobs"
+	LibraryService_GetJob_FullMethodName                 = "/photos.LibraryService/GetJob"
+	LibraryService_RetryJob_FullMethodName               = "/photos.LibraryService/RetryJob"
+	LibraryService_ListSchedules_FullMethodName          = "/photos.LibraryService/ListSchedules"
+	LibraryService_GetServerCapabilities_FullMethodName  = "/photos.LibraryService/GetServerCapabilities"
+	LibraryService_GetChanges_FullMethodName             = "/photos.LibraryService/GetChanges"
 )
 
 // LibraryServiceClient is the client API for LibraryService service.
@@ -319,6 +423,12 @@ type LibraryServiceClient interface {
 	RenamePhoto(ctx context.Context, in *RenamePhotoRequest, opts ...grpc.CallOption) (*RenamePhotoResponse, error)
 	// UpdatePhotoMetadata updates metadata for a photo
 	UpdatePhotoMetadata(ctx context.Context, in *UpdatePhotoMetadataRequest, opts ...grpc.CallOption) (*UpdatePhotoMetadataResponse, error)
+	// GetStack returns the stack a photo belongs to with all of its photos
+	GetStack(ctx context.Context, in *GetStackRequest, opts ...grpc.CallOption) (*GetStackResponse, error)
+	// SetStackCover lists a photo in place of the stack it belongs to
+	SetStackCover(ctx context.Context, in *SetStackCoverRequest, opts ...grpc.CallOption) (*SetStackCoverResponse, error)
+	// Unstack splits up the stack a photo belongs to
+	Unstack(ctx context.Context, in *UnstackRequest, opts ...grpc.CallOption) (*UnstackResponse, error)
 	// GenerateSignedUrl creates a time-limited signed URL for photo access
 	GenerateSignedUrl(ctx context.Context, in *GenerateSignedUrlRequest, opts ...grpc.CallOption) (*GenerateSignedUrlResponse, error)
 	// PhotoExists checks if a photo exists by ID
@@ -327,9 +437,19 @@ type LibraryServiceClient interface {
 	ListDirectories(ctx context.Context, in *ListDirectoriesRequest, opts ...grpc.CallOption) (*ListDirectoriesResponse, error)
 	// SyncDatabase syncs the photo database with the storage backend
 	SyncDatabase(ctx context.Context, in *SyncDatabaseRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[SyncDatabaseProgress], error)
+	// VerifyLibrary checks the database against the storage backend and the
+	// derived assets, streaming the problems found, and optionally repairs
+	// those that are safe to repair
+	VerifyLibrary(ctx context.Context, in *VerifyLibraryRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[VerifyLibraryProgress], error)
 	// UpdateWebp generates missing WebP renditions for all eligible PhotoObject
 	// rows that do not yet have a webp_object_id set.
 	UpdateWebp(ctx context.Context, in *UpdateWebpRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[UpdateWebpProgress], error)
+	// UpdateAvif generates missing AVIF renditions for all eligible PhotoObject
+	// rows that do not yet have an avif_object_id set.
+	UpdateAvif(ctx context.Context, in *UpdateAvifRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[UpdateAvifProgress], error)
+	// TranscodeVideo transcodes a video, or every video missing them, to an
+	// H.264/AAC MP4 proxy and optionally HLS renditions, streaming progress.
+	TranscodeVideo(ctx context.Context, in *TranscodeVideoRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[TranscodeVideoProgress], error)
 	// CreateMarkdown creates an index.md file in a specified prefix (directory)
 	CreateMarkdown(ctx context.Context, in *CreateMarkdownRequest, opts ...grpc.CallOption) (*CreateMarkdownResponse, error)
 	// GetMarkdown retrieves an index.md file from a specified prefix (directory)
@@ -340,8 +460,27 @@ type LibraryServiceClient interface {
 	DeleteMarkdown(ctx context.Context, in *DeleteMarkdownRequest, opts ...grpc.CallOption) (*DeleteMarkdownResponse, error)
 	// GenerateVideoThumbnail generates a thumbnail image for a video
 	GenerateVideoThumbnail(ctx context.Context, in *GenerateVideoThumbnailRequest, opts ...grpc.CallOption) (*GenerateVideoThumbnailResponse, error)
-	// GenerateDNGPreview generates a JPEG preview image for a DNG photo using dcraw
+	// GenerateDNGPreview generates a JPEG preview image for a RAW photo using dcraw
 	GenerateDNGPreview(ctx context.Context, in *GenerateDNGPreviewRequest, opts ...grpc.CallOption) (*GenerateDNGPreviewResponse, error)
+	// GetUsage reports the storage used by the authenticated user and their quota
+	GetUsage(ctx context.Context, in *GetUsageRequest, opts ...grpc.CallOption) (*GetUsageResponse, error)
+	// ListJobs lists the background jobs of the authenticated user, such as the
+	// generation of derived assets of uploads, newest first
+	ListJobs(ctx context.Context, in *ListJobsRequest, opts ...grpc.CallOption) (*ListJobsResponse, error)
+	// GetJob retrieves a background job by ID
+	GetJob(ctx context.Context, in *GetJobRequest, opts ...grpc.CallOption) (*GetJobResponse, error)
+	// RetryJob runs a failed background job again
+	RetryJob(ctx context.Context, in *RetryJobRequest, opts ...grpc.CallOption) (*RetryJobResponse, error)
+	// ListSchedules lists the maintenance tasks the server runs on a
+	// schedule, with when each next runs and the result of its last run
+	ListSchedules(ctx context.Context, in *ListSchedulesRequest, opts ...grpc.CallOption) (*ListSchedulesResponse, error)
+	// GetServerCapabilities reports the external tools found when the server
+	// started and how each feature depending on them is provided
+	GetServerCapabilities(ctx context.Context, in *GetServerCapabilitiesRequest, opts ...grpc.CallOption) (*GetServerCapabilitiesResponse, error)
+	// GetChanges returns the photos of the authenticated user created, updated
+	// and deleted since a token, with the token of the changes returned, so
+	// that clients can keep an index of their photos up to date
+	GetChanges(ctx context.Context, in *GetChangesRequest, opts ...grpc.CallOption) (*GetChangesResponse, error)
 }
 
 type libraryServiceClient struct {
@@ -412,6 +551,36 @@ func (c *libraryServiceClient) UpdatePhotoMetadata(ctx context.Context, in *Upda
 	return out, nil
 }
 
+func (c *libraryServiceClient) GetStack(ctx context.Context, in *GetStackRequest, opts ...grpc.CallOption) (*GetStackResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetStackResponse)
+	err := c.cc.Invoke(ctx, LibraryService_GetStack_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *libraryServiceClient) SetStackCover(ctx context.Context, in *SetStackCoverRequest, opts ...grpc.CallOption) (*SetStackCoverResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(SetStackCoverResponse)
+	err := c.cc.Invoke(ctx, LibraryService_SetStackCover_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *libraryServiceClient) Unstack(ctx context.Context, in *UnstackRequest, opts ...grpc.CallOption) (*UnstackResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(UnstackResponse)
+	err := c.cc.Invoke(ctx, LibraryService_Unstack_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *libraryServiceClient) GenerateSignedUrl(ctx context.Context, in *GenerateSignedUrlRequest, opts ...grpc.CallOption) (*GenerateSignedUrlResponse, error) {
 	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
 	out := new(GenerateSignedUrlResponse)
@@ -461,9 +630,28 @@ func (c *libraryServiceClient) SyncDatabase(ctx context.Context, in *SyncDatabas
 // This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
 type LibraryService_SyncDatabaseClient = grpc.ServerStreamingClient[SyncDatabaseProgress]
 
+func (c *libraryServiceClient) VerifyLibrary(ctx context.Context, in *VerifyLibraryRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[VerifyLibraryProgress], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &LibraryService_ServiceDesc.Streams[1], LibraryService_VerifyLibrary_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[VerifyLibraryRequest, VerifyLibraryProgress]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type LibraryService_VerifyLibraryClient = grpc.ServerStreamingClient[VerifyLibraryProgress]
+
 func (c *libraryServiceClient) UpdateWebp(ctx context.Context, in *UpdateWebpRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[UpdateWebpProgress], error) {
 	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
-	stream, err := c.cc.NewStream(ctx, &LibraryService_ServiceDesc.Streams[1], LibraryService_UpdateWebp_FullMethodName, cOpts...)
+	stream, err := c.cc.NewStream(ctx, &LibraryService_ServiceDesc.Streams[2], LibraryService_UpdateWebp_FullMethodName, cOpts...)
 	if err != nil {
 		return nil, err
 	}
@@ -480,6 +668,44 @@ func (c *libraryServiceClient) UpdateWebp(ctx context.Context, in *UpdateWebpReq
 // This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
 type LibraryService_UpdateWebpClient = grpc.ServerStreamingClient[UpdateWebpProgress]
 
+func (c *libraryServiceClient) UpdateAvif(ctx context.Context, in *UpdateAvifRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[UpdateAvifProgress], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &LibraryService_ServiceDesc.Streams[3], LibraryService_UpdateAvif_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[UpdateAvifRequest, UpdateAvifProgress]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type LibraryService_UpdateAvifClient = grpc.ServerStreamingClient[UpdateAvifProgress]
+
+func (c *libraryServiceClient) TranscodeVideo(ctx context.Context, in *TranscodeVideoRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[TranscodeVideoProgress], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &LibraryService_ServiceDesc.Streams[4], LibraryService_TranscodeVideo_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[TranscodeVideoRequest, TranscodeVideoProgress]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type LibraryService_TranscodeVideoClient = grpc.ServerStreamingClient[TranscodeVideoProgress]
+
 func (c *libraryServiceClient) CreateMarkdown(ctx context.Context, in *CreateMarkdownRequest, opts ...grpc.CallOption) (*CreateMarkdownResponse, error) {
 	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
 	out := new(CreateMarkdownResponse)
@@ -540,6 +766,76 @@ func (c *libraryServiceClient) GenerateDNGPreview(ctx context.Context, in *Gener
 	return out, nil
 }
 
+func (c *libraryServiceClient) GetUsage(ctx context.Context, in *GetUsageRequest, opts ...grpc.CallOption) (*GetUsageResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetUsageResponse)
+	err := c.cc.Invoke(ctx, LibraryService_GetUsage_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *libraryServiceClient) ListJobs(ctx context.Context, in *ListJobsRequest, opts ...grpc.CallOption) (*ListJobsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListJobsResponse)
+	err := c.cc.Invoke(ctx, LibraryService_ListJobs_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *libraryServiceClient) GetJob(ctx context.Context, in *GetJobRequest, opts ...grpc.CallOption) (*GetJobResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetJobResponse)
+	err := c.cc.Invoke(ctx, LibraryService_GetJob_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *libraryServiceClient) RetryJob(ctx context.Context, in *RetryJobRequest, opts ...grpc.CallOption) (*RetryJobResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(RetryJobResponse)
+	err := c.cc.Invoke(ctx, LibraryService_RetryJob_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *libraryServiceClient) ListSchedules(ctx context.Context, in *ListSchedulesRequest, opts ...grpc.CallOption) (*ListSchedulesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListSchedulesResponse)
+	err := c.cc.Invoke(ctx, LibraryService_ListSchedules_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *libraryServiceClient) GetServerCapabilities(ctx context.Context, in *GetServerCapabilitiesRequest, opts ...grpc.CallOption) (*GetServerCapabilitiesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetServerCapabilitiesResponse)
+	err := c.cc.Invoke(ctx, LibraryService_GetServerCapabilities_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *libraryServiceClient) GetChanges(ctx context.Context, in *GetChangesRequest, opts ...grpc.CallOption) (*GetChangesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetChangesResponse)
+	err := c.cc.Invoke(ctx, LibraryService_GetChanges_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // LibraryServiceServer is the server API for LibraryService service.
 // All implementations must embed UnimplementedLibraryServiceServer
 // for forward compatibility.
@@ -556,6 +852,12 @@ type LibraryServiceServer interface {
 	RenamePhoto(context.Context, *RenamePhotoRequest) (*RenamePhotoResponse, error)
 	// UpdatePhotoMetadata updates metadata for a photo
 	UpdatePhotoMetadata(context.Context, *UpdatePhotoMetadataRequest) (*UpdatePhotoMetadataResponse, error)
+	// GetStack returns the stack a photo belongs to with all of its photos
+	GetStack(context.Context, *GetStackRequest) (*GetStackResponse, error)
+	// SetStackCover lists a photo in place of the stack it belongs to
+	SetStackCover(context.Context, *SetStackCoverRequest) (*SetStackCoverResponse, error)
+	// Unstack splits up the stack a photo belongs to
+	Unstack(context.Context, *UnstackRequest) (*UnstackResponse, error)
 	// GenerateSignedUrl creates a time-limited signed URL for photo access
 	GenerateSignedUrl(context.Context, *GenerateSignedUrlRequest) (*GenerateSignedUrlResponse, error)
 	// PhotoExists checks if a photo exists by ID
@@ -564,9 +866,19 @@ type LibraryServiceServer interface {
 	ListDirectories(context.Context, *ListDirectoriesRequest) (*ListDirectoriesResponse, error)
 	// SyncDatabase syncs the photo database with the storage backend
 	SyncDatabase(*SyncDatabaseRequest, grpc.ServerStreamingServer[SyncDatabaseProgress]) error
+	// VerifyLibrary checks the database against the storage backend and the
+	// derived assets, streaming the problems found, and optionally repairs
+	// those that are safe to repair
+	VerifyLibrary(*VerifyLibraryRequest, grpc.ServerStreamingServer[VerifyLibraryProgress]) error
 	// UpdateWebp generates missing WebP renditions for all eligible PhotoObject
 	// rows that do not yet have a webp_object_id set.
 	UpdateWebp(*UpdateWebpRequest, grpc.ServerStreamingServer[UpdateWebpProgress]) error
+	// UpdateAvif generates missing AVIF renditions for all eligible PhotoObject
+	// rows that do not yet have an avif_object_id set.
+	UpdateAvif(*UpdateAvifRequest, grpc.ServerStreamingServer[UpdateAvifProgress]) error
+	// TranscodeVideo transcodes a video, or every video missing them, to an
+	// H.264/AAC MP4 proxy and optionally HLS renditions, streaming progress.
+	TranscodeVideo(*TranscodeVideoRequest, grpc.ServerStreamingServer[TranscodeVideoProgress]) error
 	// CreateMarkdown creates an index.md file in a specified prefix (directory)
 	CreateMarkdown(context.Context, *CreateMarkdownRequest) (*CreateMarkdownResponse, error)
 	// GetMarkdown retrieves an index.md file from a specified prefix (directory)
@@ -577,8 +889,27 @@ type LibraryServiceServer interface {
 	DeleteMarkdown(context.Context, *DeleteMarkdownRequest) (*DeleteMarkdownResponse, error)
 	// GenerateVideoThumbnail generates a thumbnail image for a video
 	GenerateVideoThumbnail(context.Context, *GenerateVideoThumbnailRequest) (*GenerateVideoThumbnailResponse, error)
-	// GenerateDNGPreview generates a JPEG preview image for a DNG photo using dcraw
+	// GenerateDNGPreview generates a JPEG preview image for a RAW photo using dcraw
 	GenerateDNGPreview(context.Context, *GenerateDNGPreviewRequest) (*GenerateDNGPreviewResponse, error)
+	// GetUsage reports the storage used by the authenticated user and their quota
+	GetUsage(context.Context, *GetUsageRequest) (*GetUsageResponse, error)
+	// ListJobs lists the background jobs of the authenticated user, such as the
+	// generation of derived assets of uploads, newest first
+	ListJobs(context.Context, *ListJobsRequest) (*ListJobsResponse, error)
+	// GetJob retrieves a background job by ID
+	GetJob(context.Context, *GetJobRequest) (*GetJobResponse, error)
+	// RetryJob runs a failed background job again
+	RetryJob(context.Context, *RetryJobRequest) (*RetryJobResponse, error)
+	// ListSchedules lists the maintenance tasks the server runs on a
+	// schedule, with when each next runs and the result of its last run
+	ListSchedules(context.Context, *ListSchedulesRequest) (*ListSchedulesResponse, error)
+	// GetServerCapabilities reports the external tools found when the server
+	// started and how each feature depending on them is provided
+	GetServerCapabilities(context.Context, *GetServerCapabilitiesRequest) (*GetServerCapabilitiesResponse, error)
+	// GetChanges returns the photos of the authenticated user created, updated
+	// and deleted since a token, with the token of the changes returned, so
+	// that clients can keep an index of their photos up to date
+	GetChanges(context.Context, *GetChangesRequest) (*GetChangesResponse, error)
 	mustEmbedUnimplementedLibraryServiceServer()
 }
 
@@ -607,6 +938,15 @@ func (UnimplementedLibraryServiceServer) RenamePhoto(context.Context, *RenamePho
 func (UnimplementedLibraryServiceServer) UpdatePhotoMetadata(context.Context, *UpdatePhotoMetadataRequest) (*UpdatePhotoMetadataResponse, error) {
 	return nil, status.Error(codes.Unimplemented, "method UpdatePhotoMetadata not implemented")
 }
+func (UnimplementedLibraryServiceServer) GetStack(context.Context, *GetStackRequest) (*GetStackResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetStack not implemented")
+}
+func (UnimplementedLibraryServiceServer) SetStackCover(context.Context, *SetStackCoverRequest) (*SetStackCoverResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method SetStackCover not implemented")
+}
+func (UnimplementedLibraryServiceServer) Unstack(context.Context, *UnstackRequest) (*UnstackResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Unstack not implemented")
+}
 func (UnimplementedLibraryServiceServer) GenerateSignedUrl(context.Context, *GenerateSignedUrlRequest) (*GenerateSignedUrlResponse, error) {
 	return nil, status.Error(codes.Unimplemented, "method GenerateSignedUrl not implemented")
 }
@@ -619,9 +959,18 @@ func (UnimplementedLibraryServiceServer) ListDirectories(context.Context, *ListD
 func (UnimplementedLibraryServiceServer) SyncDatabase(*SyncDatabaseRequest, grpc.ServerStreamingServer[SyncDatabaseProgress]) error {
 	return status.Error(codes.Unimplemented, "method SyncDatabase not implemented")
 }
+func (UnimplementedLibraryServiceServer) VerifyLibrary(*VerifyLibraryRequest, grpc.ServerStreamingServer[VerifyLibraryProgress]) error {
+	return status.Error(codes.Unimplemented, "method VerifyLibrary not implemented")
+}
 func (UnimplementedLibraryServiceServer) UpdateWebp(*UpdateWebpRequest, grpc.ServerStreamingServer[UpdateWebpProgress]) error {
 	return status.Error(codes.Unimplemented, "method UpdateWebp not implemented")
 }
+func (UnimplementedLibraryServiceServer) UpdateAvif(*UpdateAvifRequest, grpc.ServerStreamingServer[UpdateAvifProgress]) error {
+	return status.Error(codes.Unimplemented, "method UpdateAvif not implemented")
+}
+func (UnimplementedLibraryServiceServer) TranscodeVideo(*TranscodeVideoRequest, grpc.ServerStreamingServer[TranscodeVideoProgress]) error {
+	return status.Error(codes.Unimplemented, "method TranscodeVideo not implemented")
+}
 func (UnimplementedLibraryServiceServer) CreateMarkdown(context.Context, *CreateMarkdownRequest) (*CreateMarkdownResponse, error) {
 	return nil, status.Error(codes.Unimplemented, "method CreateMarkdown not implemented")
 }
@@ -640,6 +989,27 @@ func (UnimplementedLibraryServiceServer) GenerateVideoThumbnail(context.Context,
 func (UnimplementedLibraryServiceServer) GenerateDNGPreview(context.Context, *GenerateDNGPreviewRequest) (*GenerateDNGPreviewResponse, error) {
 	return nil, status.Error(codes.Unimplemented, "method GenerateDNGPreview not implemented")
 }
+func (UnimplementedLibraryServiceServer) GetUsage(context.Context, *GetUsageRequest) (*GetUsageResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetUsage not implemented")
+}
+func (UnimplementedLibraryServiceServer) ListJobs(context.Context, *ListJobsRequest) (*ListJobsResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method ListJobs not implemented")
+}
+func (UnimplementedLibraryServiceServer) GetJob(context.Context, *GetJobRequest) (*GetJobResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetJob not implemented")
+}
+func (UnimplementedLibraryServiceServer) RetryJob(context.Context, *RetryJobRequest) (*RetryJobResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method RetryJob not implemented")
+}
+func (UnimplementedLibraryServiceServer) ListSchedules(context.Context, *ListSchedulesRequest) (*ListSchedulesResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method ListSchedules not implemented")
+}
+func (UnimplementedLibraryServiceServer) GetServerCapabilities(context.Context, *GetServerCapabilitiesRequest) (*GetServerCapabilitiesResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetServerCapabilities not implemented")
+}
+func (UnimplementedLibraryServiceServer) GetChanges(context.Context, *GetChangesRequest) (*GetChangesResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method GetChanges not implemented")
+}
 func (UnimplementedLibraryServiceServer) mustEmbedUnimplementedLibraryServiceServer() {}
 func (UnimplementedLibraryServiceServer) testEmbeddedByValue()                        {}
 
@@ -769,6 +1139,60 @@ func _LibraryService_UpdatePhotoMetadata_Handler(srv interface{}, ctx context.Co
 	return interceptor(ctx, in, info, handler)
 }
 
+func _LibraryService_GetStack_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetStackRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LibraryServiceServer).GetStack(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LibraryService_GetStack_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LibraryServiceServer).GetStack(ctx, req.(*GetStackRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LibraryService_SetStackCover_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(SetStackCoverRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LibraryServiceServer).SetStackCover(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LibraryService_SetStackCover_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LibraryServiceServer).SetStackCover(ctx, req.(*SetStackCoverRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LibraryService_Unstack_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(UnstackRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LibraryServiceServer).Unstack(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LibraryService_Unstack_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LibraryServiceServer).Unstack(ctx, req.(*UnstackRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _LibraryService_GenerateSignedUrl_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(GenerateSignedUrlRequest)
 	if err := dec(in); err != nil {
@@ -834,6 +1258,17 @@ func _LibraryService_SyncDatabase_Handler(srv interface{}, stream grpc.ServerStr
 // This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
 type LibraryService_SyncDatabaseServer = grpc.ServerStreamingServer[SyncDatabaseProgress]
 
+func _LibraryService_VerifyLibrary_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(VerifyLibraryRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(LibraryServiceServer).VerifyLibrary(m, &grpc.GenericServerStream[VerifyLibraryRequest, VerifyLibraryProgress]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type LibraryService_VerifyLibraryServer = grpc.ServerStreamingServer[VerifyLibraryProgress]
+
 func _LibraryService_UpdateWebp_Handler(srv interface{}, stream grpc.ServerStream) error {
 	m := new(UpdateWebpRequest)
 	if err := stream.RecvMsg(m); err != nil {
@@ -845,6 +1280,28 @@ func _LibraryService_UpdateWebp_Handler(srv interface{}, stream grpc.ServerStrea
 // This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
 type LibraryService_UpdateWebpServer = grpc.ServerStreamingServer[UpdateWebpProgress]
 
+func _LibraryService_UpdateAvif_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(UpdateAvifRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(LibraryServiceServer).UpdateAvif(m, &grpc.GenericServerStream[UpdateAvifRequest, UpdateAvifProgress]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type LibraryService_UpdateAvifServer = grpc.ServerStreamingServer[UpdateAvifProgress]
+
+func _LibraryService_TranscodeVideo_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(TranscodeVideoRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(LibraryServiceServer).TranscodeVideo(m, &grpc.GenericServerStream[TranscodeVideoRequest, TranscodeVideoProgress]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type LibraryService_TranscodeVideoServer = grpc.ServerStreamingServer[TranscodeVideoProgress]
+
 func _LibraryService_CreateMarkdown_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(CreateMarkdownRequest)
 	if err := dec(in); err != nil {
@@ -953,6 +1410,132 @@ func _LibraryService_GenerateDNGPreview_Handler(srv interface{}, ctx context.Con
 	return interceptor(ctx, in, info, handler)
 }
 
+func _LibraryService_GetUsage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetUsageRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LibraryServiceServer).GetUsage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LibraryService_GetUsage_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LibraryServiceServer).GetUsage(ctx, req.(*GetUsageRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LibraryService_ListJobs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListJobsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LibraryServiceServer).ListJobs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LibraryService_ListJobs_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LibraryServiceServer).ListJobs(ctx, req.(*ListJobsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LibraryService_GetJob_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetJobRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LibraryServiceServer).GetJob(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LibraryService_GetJob_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LibraryServiceServer).GetJob(ctx, req.(*GetJobRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LibraryService_RetryJob_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RetryJobRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LibraryServiceServer).RetryJob(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LibraryService_RetryJob_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LibraryServiceServer).RetryJob(ctx, req.(*RetryJobRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LibraryService_ListSchedules_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListSchedulesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LibraryServiceServer).ListSchedules(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LibraryService_ListSchedules_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LibraryServiceServer).ListSchedules(ctx, req.(*ListSchedulesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LibraryService_GetServerCapabilities_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetServerCapabilitiesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LibraryServiceServer).GetServerCapabilities(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LibraryService_GetServerCapabilities_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LibraryServiceServer).GetServerCapabilities(ctx, req.(*GetServerCapabilitiesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LibraryService_GetChanges_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetChangesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LibraryServiceServer).GetChanges(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LibraryService_GetChanges_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LibraryServiceServer).GetChanges(ctx, req.(*GetChangesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // LibraryService_ServiceDesc is the grpc.ServiceDesc for LibraryService service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -984,6 +1567,18 @@ var LibraryService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "UpdatePhotoMetadata",
 			Handler:    _LibraryService_UpdatePhotoMetadata_Handler,
 		},
+		{
+			MethodName: "GetStack",
+			Handler:    _LibraryService_GetStack_Handler,
+		},
+		{
+			MethodName: "SetStackCover",
+			Handler:    _LibraryService_SetStackCover_Handler,
+		},
+		{
+			MethodName: "Unstack",
+			Handler:    _LibraryService_Unstack_Handler,
+		},
 		{
 			MethodName: "GenerateSignedUrl",
 			Handler:    _LibraryService_GenerateSignedUrl_Handler,
@@ -1020,6 +1615,34 @@ var LibraryService_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GenerateDNGPreview",
 			Handler:    _LibraryService_GenerateDNGPreview_Handler,
 		},
+		{
+			MethodName: "GetUsage",
+			Handler:    _LibraryService_GetUsage_Handler,
+		},
+		{
+			MethodName: "ListJobs",
+			Handler:    _LibraryService_ListJobs_Handler,
+		},
+		{
+			MethodName: "GetJob",
+			Handler:    _LibraryService_GetJob_Handler,
+		},
+		{
+			MethodName: "RetryJob",
+			Handler:    _LibraryService_RetryJob_Handler,
+		},
+		{
+			MethodName: "ListSchedules",
+			Handler:    _LibraryService_ListSchedules_Handler,
+		},
+		{
+			MethodName: "GetServerCapabilities",
+			Handler:    _LibraryService_GetServerCapabilities_Handler,
+		},
+		{
+			MethodName: "GetChanges",
+			Handler:    _LibraryService_GetChanges_Handler,
+		},
 	},
 	Streams: []grpc.StreamDesc{
 		{
@@ -1027,11 +1650,26 @@ var LibraryService_ServiceDesc = grpc.ServiceDesc{
 			Handler:       _LibraryService_SyncDatabase_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "VerifyLibrary",
+			Handler:       _LibraryService_VerifyLibrary_Handler,
+			ServerStreams: true,
+		},
 		{
 			StreamName:    "UpdateWebp",
 			Handler:       _LibraryService_UpdateWebp_Handler,
 			ServerStreams: true,
 		},
+		{
+			StreamName:    "UpdateAvif",
+			Handler:       _LibraryService_UpdateAvif_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "TranscodeVideo",
+			Handler:       _LibraryService_TranscodeVideo_Handler,
+			ServerStreams: true,
+		},
 	},
 	Metadata: "proto/photos.proto",
 }